@@ -0,0 +1,80 @@
+// Command licensing-certify signs an intermediate key's certificate with the
+// root key. It is run offline, where the root private key is kept, and its
+// output is installed as the intermediate's certificate.jwt.
+//
+// Usage:
+//
+//	licensing-certify -root root-private.pem -key plugin-2026-public.pem \
+//	  -id plugin-2026 -products domain_changer -valid 8760h > certificate.jwt
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/danielchatfield/go-jwt"
+
+	"github.com/volcanicpixels/licensing/license"
+)
+
+func main() {
+	root := flag.String("root", "", "the root private key PEM file")
+	key := flag.String("key", "", "the intermediate public key PEM file")
+	id := flag.String("id", "", "the intermediate key ID")
+	products := flag.String("products", "", "comma separated products the key may sign licenses for, empty for all")
+	valid := flag.Duration("valid", 365*24*time.Hour, "how long the key may sign licenses for")
+	flag.Parse()
+
+	if *root == "" || *key == "" || *id == "" {
+		flag.Usage()
+		log.Fatal("-root, -key and -id are required")
+	}
+
+	rootPEM, err := ioutil.ReadFile(*root)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rootKey, err := jwt.ParseRSAPrivateKeyFromPEM(rootPEM)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keyPEM, err := ioutil.ReadFile(*key)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	publicKey, err := jwt.ParseRSAPublicKeyFromPEM(keyPEM)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	now := time.Now()
+	cert := &license.Certificate{
+		KeyID:     *id,
+		PublicKey: publicKey,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(*valid),
+	}
+
+	if *products != "" {
+		cert.Products = strings.Split(*products, ",")
+	}
+
+	token, err := cert.Encode(rootKey)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(token)
+}
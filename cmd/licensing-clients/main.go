@@ -0,0 +1,137 @@
+// Command licensing-clients generates the reference implementations of
+// license verification for PHP (WordPress plugins) and Node from the license
+// package's description of the formats and claims (see license.Describe),
+// so that client-side verification can't drift from what the server issues.
+// With -check it only reports the generated files that are out of date,
+// failing if there are any, for CI to run after changes to the license
+// package. The package's test does the same check.
+//
+// Usage:
+//
+//	go run ./cmd/licensing-clients -out clients
+//	go run ./cmd/licensing-clients -out clients -check
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/volcanicpixels/licensing/license"
+)
+
+// clients are the generated files, by their path in the output directory.
+var clients = map[string]*template.Template{
+	"php/license-verify.php": template.Must(template.New("php").Parse(phpTemplate)),
+	"node/license-verify.js": template.Must(template.New("node").Parse(nodeTemplate)),
+}
+
+// templateData is what the templates are generated from.
+type templateData struct {
+	*license.Description
+
+	// FormatNames lists the formats, V2Version is the version byte of v2
+	// licenses and V2LengthSize the size of their length in bytes.
+	FormatNames  string
+	V2Version    int
+	V2LengthSize int
+}
+
+func main() {
+	out := flag.String("out", "clients", "the directory to write the clients to")
+	check := flag.Bool("check", false, "only check that the files are up to date")
+	flag.Parse()
+
+	files, err := generate()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stale := 0
+
+	for name, generated := range files {
+		path := filepath.Join(*out, filepath.FromSlash(name))
+
+		if *check {
+			if old, err := ioutil.ReadFile(path); err != nil || !bytes.Equal(old, generated) {
+				fmt.Println(path)
+				stale++
+			}
+
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			log.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, generated, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if stale > 0 {
+		log.Fatalf("%v generated files are out of date, run licensing-clients to update them", stale)
+	}
+}
+
+// generate returns the contents of the clients, by their path in the output
+// directory.
+func generate() (map[string][]byte, error) {
+	data, err := newTemplateData(license.Describe())
+
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte, len(clients))
+
+	for name, t := range clients {
+		var b bytes.Buffer
+
+		if err := t.Execute(&b, data); err != nil {
+			return nil, fmt.Errorf("%v: %v", name, err)
+		}
+
+		files[name] = b.Bytes()
+	}
+
+	return files, nil
+}
+
+// newTemplateData picks out of the description of the formats what the
+// templates need.
+func newTemplateData(d *license.Description) (*templateData, error) {
+	data := &templateData{Description: d}
+	var names []string
+
+	for _, f := range d.Formats {
+		names = append(names, f.Name)
+
+		if f.Name != license.FormatV2 {
+			continue
+		}
+
+		data.V2Version = f.Version
+
+		for _, field := range f.Layout {
+			if field.Name == "length" {
+				data.V2LengthSize = field.Size
+			}
+		}
+	}
+
+	if data.V2Version == 0 || data.V2LengthSize == 0 {
+		return nil, fmt.Errorf("the description of the %v format has no version or length", license.FormatV2)
+	}
+
+	data.FormatNames = strings.Join(names, ", ")
+	return data, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestClientsUpToDate regenerates the clients and compares them with the
+// checked in ones, which must be regenerated after changes to the license
+// package.
+func TestClientsUpToDate(t *testing.T) {
+	files, err := generate()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, generated := range files {
+		path := filepath.Join("..", "..", "clients", filepath.FromSlash(name))
+		old, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			continue
+		}
+
+		if bytes.Equal(old, generated) {
+			continue
+		}
+
+		oldLines, newLines := strings.Split(string(old), "\n"), strings.Split(string(generated), "\n")
+		line := len(newLines) + 1
+
+		for i := range newLines {
+			if i >= len(oldLines) || oldLines[i] != newLines[i] {
+				line = i + 1
+				break
+			}
+		}
+
+		t.Errorf("%v is out of date from line %v, run go run ./cmd/licensing-clients -out clients", path, line)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+// nodeTemplate is the Node client, a CommonJS module that only needs the
+// crypto module.
+const nodeTemplate = `// Code generated by licensing-clients from the license package. DO NOT EDIT.
+
+// Reference verification of licenses for Node.
+//
+// verifyLicense checks a license against the root public key, through the
+// certificate of the intermediate key that signed it if it has one, in every
+// format the server issues ({{.FormatNames}}), and returns its claims. Like
+// the Go verify package it rejects licenses used before their nbf time, and
+// leaves their expiry (exp) and revocation to the caller.
+//
+//     const { verifyLicense } = require('./license-verify');
+//     const claims = verifyLicense(token, fs.readFileSync('root-public.pem', 'utf8'));
+
+'use strict';
+
+const crypto = require('crypto');
+
+const MAX_TOKEN_SIZE = {{.MaxTokenSize}};
+const V2_VERSION = {{.V2Version}};
+const V2_LENGTH_SIZE = {{.V2LengthSize}};
+const CLAIMS_VERSION = {{.ClaimsVersion}};
+
+// the claims of a license, their types, whether they are required and the
+// type of their members
+const CLAIMS = {
+{{- range .Claims}}
+  '{{.Name}}': ['{{.Type}}', {{.Required}}, '{{.Items}}'], // {{.Summary}}
+{{- end}}
+};
+
+// the claims of the certificate of an intermediate key
+const CERTIFICATE_CLAIMS = {
+{{- range .CertificateClaims}}
+  '{{.Name}}': ['{{.Type}}', {{.Required}}, '{{.Items}}'], // {{.Summary}}
+{{- end}}
+};
+
+class VerifyError extends Error {}
+
+// verifyLicense verifies a license against the root public key (PEM) at now
+// (Unix seconds, by default the current time) and returns its claims. It
+// throws a VerifyError if the license doesn't verify.
+function verifyLicense(token, rootPem, now) {
+  token = String(token).trim();
+
+  if (Buffer.byteLength(token) > MAX_TOKEN_SIZE) {
+    throw new VerifyError('The license is too large');
+  }
+
+  const unverified = payload(token);
+  let key = rootPem;
+  let certificate = null;
+
+  if (unverified._cert !== undefined && unverified._cert !== null) {
+    certificate = parseCertificate(unverified._cert, rootPem);
+    key = certificate.key;
+  }
+
+  const claims = verify(token, key);
+  checkClaims(claims, CLAIMS);
+
+  const products = [claims._prod].concat(claims._bundle || []);
+
+  if (certificate !== null && !allows(certificate.claims, products, claims.iat || 0)) {
+    throw new VerifyError('The signing key is not allowed to sign this license');
+  }
+
+  if (now === undefined) {
+    now = Math.floor(Date.now() / 1000);
+  }
+
+  if (claims.nbf !== undefined && now < claims.nbf) {
+    throw new VerifyError('The license is not valid yet');
+  }
+
+  return claims;
+}
+
+// verify checks the signature of a license with a public key (PEM) and
+// returns its claims, any one signature of an envelope is enough
+function verify(token, pem) {
+  if (token[0] === '{') {
+    const envelope = parseEnvelope(token);
+    let error = new VerifyError('The envelope has no signatures');
+
+    for (const s of envelope.signatures) {
+      if (!s || typeof s.protected !== 'string' || typeof s.signature !== 'string') {
+        continue;
+      }
+
+      try {
+        return verifyJWT(s.protected + '.' + envelope.payload + '.' + s.signature, pem);
+      } catch (e) {
+        error = e;
+      }
+    }
+
+    throw error;
+  }
+
+  if (token.includes('.')) {
+    return verifyJWT(token, pem);
+  }
+
+  const v2 = splitV2(token);
+  verifySignature(v2.message, v2.signature, pem);
+  return decodeClaims(v2.claims);
+}
+
+// payload returns the claims of a license WITHOUT verifying it, only to find
+// the key to verify it with
+function payload(token) {
+  if (token === '') {
+    throw new VerifyError('The license is empty');
+  }
+
+  if (token[0] === '{') {
+    return decodeClaims(base64urlDecode(parseEnvelope(token).payload));
+  }
+
+  if (token.includes('.')) {
+    return decodeClaims(base64urlDecode(splitJWT(token)[1]));
+  }
+
+  return decodeClaims(splitV2(token).claims);
+}
+
+function parseEnvelope(token) {
+  let envelope;
+
+  try {
+    envelope = JSON.parse(token);
+  } catch (e) {
+    throw new VerifyError('Malformed envelope');
+  }
+
+  if (!envelope || typeof envelope.payload !== 'string' || !Array.isArray(envelope.signatures)) {
+    throw new VerifyError('Malformed envelope');
+  }
+
+  return envelope;
+}
+
+function splitJWT(token) {
+  const parts = token.split('.');
+
+  if (parts.length !== 3) {
+    throw new VerifyError('Malformed token');
+  }
+
+  return parts;
+}
+
+function verifyJWT(token, pem) {
+  const parts = splitJWT(token);
+  let header;
+
+  try {
+    header = JSON.parse(base64urlDecode(parts[0]).toString('utf8'));
+  } catch (e) {
+    throw new VerifyError('Malformed token header');
+  }
+
+  if (!header || header.alg !== 'RS256') {
+    throw new VerifyError('The token is not signed with RS256');
+  }
+
+  verifySignature(Buffer.from(parts[0] + '.' + parts[1]), base64urlDecode(parts[2]), pem);
+  return decodeClaims(base64urlDecode(parts[1]));
+}
+
+// splitV2 returns the signed message, claims and signature of a v2 license
+function splitV2(token) {
+  const b = base64urlDecode(token);
+  const header = 1 + V2_LENGTH_SIZE;
+
+  if (b.length < header || b[0] !== V2_VERSION) {
+    throw new VerifyError('Not a license');
+  }
+
+  let n = 0;
+
+  for (let i = 1; i < header; i++) {
+    n = n * 256 + b[i];
+  }
+
+  if (n === 0 || b.length <= header + n) {
+    throw new VerifyError('Malformed v2 license');
+  }
+
+  return {
+    message: b.subarray(0, header + n),
+    claims: b.subarray(header, header + n),
+    signature: b.subarray(header + n),
+  };
+}
+
+function verifySignature(message, signature, pem) {
+  const verifier = crypto.createVerify('RSA-SHA256');
+  verifier.update(message);
+
+  if (!verifier.verify(pem, signature)) {
+    throw new VerifyError('Invalid signature');
+  }
+}
+
+// parseCertificate verifies the certificate of an intermediate key with the
+// root key and returns its claims and the intermediate public key (PEM)
+function parseCertificate(token, rootPem) {
+  if (typeof token !== 'string') {
+    throw new VerifyError('The _cert claim is not a string');
+  }
+
+  const claims = verifyJWT(token, rootPem);
+  checkClaims(claims, CERTIFICATE_CLAIMS);
+
+  if (!/^[A-Za-z0-9+/]*={0,2}$/.test(claims._pub)) {
+    throw new VerifyError('Malformed certificate key');
+  }
+
+  const lines = claims._pub.match(/.{1,64}/g) || [];
+  const key = '-----BEGIN PUBLIC KEY-----\n' + lines.join('\n') + '\n-----END PUBLIC KEY-----\n';
+  return { claims: claims, key: key };
+}
+
+// allows reports whether an intermediate key may sign a license of the
+// products (a bundle's and those bundled) issued at issuedAt
+function allows(certificate, products, issuedAt) {
+  if (issuedAt < certificate.iat || issuedAt >= certificate._until) {
+    return false;
+  }
+
+  return certificate._prods === undefined || products.every((p) => certificate._prods.includes(p));
+}
+
+function decodeClaims(json) {
+  let claims;
+
+  try {
+    claims = JSON.parse(json.toString('utf8'));
+  } catch (e) {
+    throw new VerifyError('The claims are not JSON');
+  }
+
+  if (!claims || typeof claims !== 'object' || Array.isArray(claims)) {
+    throw new VerifyError('The claims are not a JSON object');
+  }
+
+  return claims;
+}
+
+function checkClaims(claims, types) {
+  for (const name of Object.keys(types)) {
+    const [type, required, items] = types[name];
+
+    // null is the same as missing
+    if (claims[name] === undefined || claims[name] === null) {
+      if (required) {
+        throw new VerifyError('The ' + name + ' claim is missing');
+      }
+
+      continue;
+    }
+
+    if (!isType(claims[name], type)) {
+      throw new VerifyError('The ' + name + ' claim is not of type ' + type);
+    }
+
+    if (items !== '' && !Object.values(claims[name]).every((v) => isType(v, items))) {
+      throw new VerifyError('The members of the ' + name + ' claim must be of type ' + items);
+    }
+  }
+}
+
+function isType(v, type) {
+  switch (type) {
+    case 'string':
+      return typeof v === 'string';
+    case 'integer':
+      return Number.isInteger(v);
+    case 'boolean':
+      return typeof v === 'boolean';
+    case 'object':
+      return v !== null && typeof v === 'object' && !Array.isArray(v);
+    case 'array':
+      return Array.isArray(v);
+  }
+
+  return false;
+}
+
+function base64urlDecode(s) {
+  if (typeof s !== 'string' || !/^[A-Za-z0-9_-]*$/.test(s)) {
+    throw new VerifyError('Invalid base64url');
+  }
+
+  return Buffer.from(s.replace(/-/g, '+').replace(/_/g, '/'), 'base64');
+}
+
+module.exports = { verifyLicense, VerifyError, CLAIMS_VERSION };
+`
@@ -0,0 +1,289 @@
+package main
+
+// phpTemplate is the PHP client, a class with no dependencies other than the
+// openssl extension so that WordPress plugins can bundle it.
+const phpTemplate = `<?php
+// Code generated by licensing-clients from the license package. DO NOT EDIT.
+
+/**
+ * Reference verification of licenses for PHP, such as WordPress plugins.
+ *
+ * Licensing_Verify::license() checks a license against the root public key,
+ * through the certificate of the intermediate key that signed it if it has
+ * one, in every format the server issues ({{.FormatNames}}), and returns its
+ * claims. Like the Go verify package it rejects licenses used before their
+ * nbf time, and leaves their expiry (exp) and revocation to the caller.
+ *
+ *     $claims = Licensing_Verify::license($token, file_get_contents('root-public.pem'));
+ */
+
+class Licensing_Verify_Exception extends Exception {}
+
+final class Licensing_Verify {
+	const MAX_TOKEN_SIZE = {{.MaxTokenSize}};
+	const V2_VERSION = {{.V2Version}};
+	const V2_LENGTH_SIZE = {{.V2LengthSize}};
+	const CLAIMS_VERSION = {{.ClaimsVersion}};
+
+	// the claims of a license, their types, whether they are required and
+	// the type of their members
+	private static $claims = array(
+{{- range .Claims}}
+		'{{.Name}}' => array('{{.Type}}', {{.Required}}, '{{.Items}}'), // {{.Summary}}
+{{- end}}
+	);
+
+	// the claims of the certificate of an intermediate key
+	private static $certificate_claims = array(
+{{- range .CertificateClaims}}
+		'{{.Name}}' => array('{{.Type}}', {{.Required}}, '{{.Items}}'), // {{.Summary}}
+{{- end}}
+	);
+
+	/**
+	 * Verifies a license against the root public key (PEM) at now (a Unix
+	 * time, by default the current one) and returns its claims.
+	 *
+	 * @throws Licensing_Verify_Exception if it doesn't verify
+	 */
+	public static function license($token, $root_pem, $now = null) {
+		$token = trim($token);
+
+		if (strlen($token) > self::MAX_TOKEN_SIZE) {
+			throw new Licensing_Verify_Exception('The license is too large');
+		}
+
+		$unverified = self::payload($token);
+		$key = $root_pem;
+		$certificate = null;
+
+		if (isset($unverified['_cert'])) {
+			$certificate = self::certificate($unverified['_cert'], $root_pem);
+			$key = $certificate['key'];
+		}
+
+		$claims = self::verify($token, $key);
+		self::check_claims($claims, self::$claims);
+		$issued_at = isset($claims['iat']) ? $claims['iat'] : 0;
+
+		$products = isset($claims['_bundle']) ? array_merge(array($claims['_prod']), $claims['_bundle']) : array($claims['_prod']);
+
+		if ($certificate !== null && !self::allows($certificate['claims'], $products, $issued_at)) {
+			throw new Licensing_Verify_Exception('The signing key is not allowed to sign this license');
+		}
+
+		$now = $now === null ? time() : $now;
+
+		if (isset($claims['nbf']) && $now < $claims['nbf']) {
+			throw new Licensing_Verify_Exception('The license is not valid yet');
+		}
+
+		return $claims;
+	}
+
+	// verify checks the signature of a license with a public key (PEM) and
+	// returns its claims, any one signature of an envelope is enough
+	private static function verify($token, $pem) {
+		if ($token[0] === '{') {
+			$envelope = self::envelope($token);
+			$error = new Licensing_Verify_Exception('The envelope has no signatures');
+
+			foreach ($envelope['signatures'] as $s) {
+				if (!isset($s['protected'], $s['signature'])) {
+					continue;
+				}
+
+				try {
+					return self::verify_jwt($s['protected'] . '.' . $envelope['payload'] . '.' . $s['signature'], $pem);
+				} catch (Licensing_Verify_Exception $e) {
+					$error = $e;
+				}
+			}
+
+			throw $error;
+		}
+
+		if (strpos($token, '.') !== false) {
+			return self::verify_jwt($token, $pem);
+		}
+
+		list($message, $claims, $signature) = self::split_v2($token);
+		self::verify_signature($message, $signature, $pem);
+		return self::decode_claims($claims);
+	}
+
+	// payload returns the claims of a license WITHOUT verifying it, only to
+	// find the key to verify it with
+	private static function payload($token) {
+		if ($token === '') {
+			throw new Licensing_Verify_Exception('The license is empty');
+		}
+
+		if ($token[0] === '{') {
+			$envelope = self::envelope($token);
+			return self::decode_claims(self::base64url_decode($envelope['payload']));
+		}
+
+		if (strpos($token, '.') !== false) {
+			$parts = self::split_jwt($token);
+			return self::decode_claims(self::base64url_decode($parts[1]));
+		}
+
+		list(, $claims) = self::split_v2($token);
+		return self::decode_claims($claims);
+	}
+
+	private static function envelope($token) {
+		$envelope = json_decode($token, true);
+
+		if (!is_array($envelope) || !isset($envelope['payload'], $envelope['signatures']) || !is_string($envelope['payload']) || !is_array($envelope['signatures'])) {
+			throw new Licensing_Verify_Exception('Malformed envelope');
+		}
+
+		return $envelope;
+	}
+
+	private static function split_jwt($token) {
+		$parts = explode('.', $token);
+
+		if (count($parts) !== 3) {
+			throw new Licensing_Verify_Exception('Malformed token');
+		}
+
+		return $parts;
+	}
+
+	private static function verify_jwt($token, $pem) {
+		$parts = self::split_jwt($token);
+		$header = json_decode(self::base64url_decode($parts[0]), true);
+
+		if (!is_array($header) || !isset($header['alg']) || $header['alg'] !== 'RS256') {
+			throw new Licensing_Verify_Exception('The token is not signed with RS256');
+		}
+
+		self::verify_signature($parts[0] . '.' . $parts[1], self::base64url_decode($parts[2]), $pem);
+		return self::decode_claims(self::base64url_decode($parts[1]));
+	}
+
+	// split_v2 returns the signed message, claims and signature of a v2
+	// license
+	private static function split_v2($token) {
+		$b = self::base64url_decode($token);
+		$header = 1 + self::V2_LENGTH_SIZE;
+
+		if (strlen($b) < $header || ord($b[0]) !== self::V2_VERSION) {
+			throw new Licensing_Verify_Exception('Not a license');
+		}
+
+		$n = 0;
+
+		for ($i = 1; $i < $header; $i++) {
+			$n = ($n << 8) | ord($b[$i]);
+		}
+
+		if ($n === 0 || strlen($b) <= $header + $n) {
+			throw new Licensing_Verify_Exception('Malformed v2 license');
+		}
+
+		return array(substr($b, 0, $header + $n), substr($b, $header, $n), substr($b, $header + $n));
+	}
+
+	private static function verify_signature($message, $signature, $pem) {
+		if (openssl_verify($message, $signature, $pem, OPENSSL_ALGO_SHA256) !== 1) {
+			throw new Licensing_Verify_Exception('Invalid signature');
+		}
+	}
+
+	// certificate verifies the certificate of an intermediate key with the
+	// root key and returns its claims and the intermediate public key (PEM)
+	private static function certificate($token, $root_pem) {
+		if (!is_string($token)) {
+			throw new Licensing_Verify_Exception('The _cert claim is not a string');
+		}
+
+		$claims = self::verify_jwt($token, $root_pem);
+		self::check_claims($claims, self::$certificate_claims);
+		$der = base64_decode($claims['_pub'], true);
+
+		if ($der === false) {
+			throw new Licensing_Verify_Exception('Malformed certificate key');
+		}
+
+		$key = "-----BEGIN PUBLIC KEY-----\n" . chunk_split(base64_encode($der), 64, "\n") . "-----END PUBLIC KEY-----\n";
+		return array('claims' => $claims, 'key' => $key);
+	}
+
+	// allows reports whether an intermediate key may sign a license of the
+	// products (a bundle's and those bundled) issued at issued_at
+	private static function allows($certificate, $products, $issued_at) {
+		if ($issued_at < $certificate['iat'] || $issued_at >= $certificate['_until']) {
+			return false;
+		}
+
+		return !isset($certificate['_prods']) || count(array_diff($products, $certificate['_prods'])) === 0;
+	}
+
+	private static function decode_claims($json) {
+		$claims = json_decode($json, true);
+
+		if (!is_array($claims)) {
+			throw new Licensing_Verify_Exception('The claims are not a JSON object');
+		}
+
+		return $claims;
+	}
+
+	private static function check_claims($claims, $types) {
+		foreach ($types as $name => $type) {
+			// null is the same as missing
+			if (!isset($claims[$name])) {
+				if ($type[1]) {
+					throw new Licensing_Verify_Exception("The $name claim is missing");
+				}
+
+				continue;
+			}
+
+			if (!self::is_type($claims[$name], $type[0])) {
+				throw new Licensing_Verify_Exception("The $name claim is not of type {$type[0]}");
+			}
+
+			foreach ($type[2] === '' ? array() : $claims[$name] as $v) {
+				if (!self::is_type($v, $type[2])) {
+					throw new Licensing_Verify_Exception("The members of the $name claim must be of type {$type[2]}");
+				}
+			}
+		}
+	}
+
+	private static function is_type($v, $type) {
+		switch ($type) {
+		case 'string':
+			return is_string($v);
+		case 'integer':
+			return is_int($v);
+		case 'boolean':
+			return is_bool($v);
+		case 'object':
+		case 'array':
+			return is_array($v);
+		}
+
+		return false;
+	}
+
+	private static function base64url_decode($s) {
+		if (!is_string($s) || preg_match('/^[A-Za-z0-9_-]*$/', $s) !== 1) {
+			throw new Licensing_Verify_Exception('Invalid base64url');
+		}
+
+		$b = base64_decode(str_pad(strtr($s, '-_', '+/'), (int) ceil(strlen($s) / 4) * 4, '='), true);
+
+		if ($b === false) {
+			throw new Licensing_Verify_Exception('Invalid base64url');
+		}
+
+		return $b;
+	}
+}
+`
@@ -0,0 +1,1443 @@
+// Package config loads the licensing server's settings from a YAML file and
+// environment variables.
+package config
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds all of the server's settings.
+type Config struct {
+	Keys        Keys               `yaml:"keys"`
+	Secrets     Secrets            `yaml:"secrets"`
+	Storage     Storage            `yaml:"storage"`
+	Products    map[string]Product `yaml:"products"`
+	Licenses    Licenses           `yaml:"licenses"`
+	Revocations Revocations        `yaml:"revocations"`
+	APIKeys     []APIKey           `yaml:"api_keys"`
+	Resellers   []Reseller         `yaml:"resellers"`
+	Webhooks    []string           `yaml:"webhooks"`
+	RateLimit   RateLimit          `yaml:"rate_limit"`
+	PII         PII                `yaml:"pii"`
+	AccessToken AccessToken        `yaml:"access_tokens"`
+	Mail        Mail               `yaml:"mail"`
+	Payments    Payments           `yaml:"payments"`
+	Slack       Slack              `yaml:"slack"`
+	Alerts      Alerts             `yaml:"alerts"`
+	Certificate Certificate        `yaml:"certificate"`
+	Compression Compression        `yaml:"compression"`
+	Maintenance Maintenance        `yaml:"maintenance"`
+	Region      Region             `yaml:"region"`
+	Snapshots   Snapshots          `yaml:"snapshots"`
+	CDN         CDN                `yaml:"cdn"`
+
+	// WebhookSecret is the name of the secret in Secret Manager that webhook
+	// payloads are signed with, if empty they are not signed.
+	WebhookSecret string `yaml:"webhook_secret"`
+}
+
+// Keys configures where signing keys come from.
+type Keys struct {
+	// Source is where keys are loaded from, either "storage" where they are
+	// kept as keys/<id>/{private,public}.pem, or "secretmanager" where they
+	// are the secrets <id>-private-pem and <id>-public-pem.
+	Source string `yaml:"source"`
+
+	// ID is the key that licenses and revocation lists are signed with
+	// unless a product specifies its own.
+	ID string `yaml:"id"`
+
+	// SandboxID is the key that test-mode licenses are signed with, it must
+	// never be trusted by production builds of the software.
+	SandboxID string `yaml:"sandbox_id"`
+
+	// RootID is the key that certifies intermediate keys, only its public
+	// key is needed as the private key is kept offline. Keys with a
+	// certificate.jwt (or <id>-certificate-jwt secret) are intermediates and
+	// the licenses they sign carry the certificate.
+	RootID string `yaml:"root_id"`
+
+	// CrossSignID is the ID of a key that licenses signed with the default
+	// key are also signed with (see Product.CrossSignKey).
+	CrossSignID string `yaml:"cross_sign_id"`
+
+	// LegacyID is the key that licenses issued by the previous system are
+	// verified with (see license.ParseLegacy), only its public key is
+	// needed. Empty rejects legacy licenses.
+	LegacyID string `yaml:"legacy_id"`
+
+	// Compromised are keys whose private key may have leaked. Licenses
+	// signed with them fail validation as reissue_required until they are
+	// re-signed with the current key, so they must no longer sign anything.
+	Compromised []string `yaml:"compromised"`
+
+	// CacheTTL is how long parsed private keys are kept in memory before
+	// being loaded again, zero loads them for every use.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+}
+
+// APIKey is a key that integrations such as the storefront use to call the
+// API without an admin login.
+type APIKey struct {
+	// ID identifies the key in logs, it is not secret.
+	ID string `yaml:"id"`
+
+	// Hash is the hex encoded SHA-256 hash of the key, the key itself is
+	// never stored.
+	Hash string `yaml:"hash"`
+
+	// Sandbox keys issue test-mode licenses signed with the sandbox key and
+	// kept apart from real ones.
+	Sandbox bool `yaml:"sandbox"`
+
+	// Reseller is the ID of the reseller the key belongs to, reseller keys
+	// can only use the reseller API as that reseller.
+	Reseller string `yaml:"reseller"`
+
+	// DailyQuota and MonthlyQuota are the most licenses the key can issue
+	// in a day and in a calendar month (UTC), zero is unlimited. They stop a
+	// leaked key or a runaway integration from issuing without limit.
+	DailyQuota   int `yaml:"daily_quota"`
+	MonthlyQuota int `yaml:"monthly_quota"`
+
+	// Debug keys get how long the parts of each request took in a
+	// Server-Timing header, as admins do.
+	Debug bool `yaml:"debug"`
+
+	// Admin keys can also change the bookkeeping of licenses, which other
+	// keys can only issue and read, and are told the products there are when
+	// they get one wrong.
+	Admin bool `yaml:"admin"`
+}
+
+// Reseller is a partner that issues licenses through the reseller API.
+type Reseller struct {
+	ID   string `yaml:"id"`
+	Name string `yaml:"name"`
+
+	// Products are the products the reseller can issue licenses for, empty
+	// allows every product.
+	Products []string `yaml:"products"`
+
+	// MonthlyQuota is the most licenses the reseller can issue in a calendar
+	// month (UTC), zero is unlimited.
+	MonthlyQuota int `yaml:"monthly_quota"`
+}
+
+// Reseller returns the reseller with the given ID, or nil.
+func (cfg *Config) Reseller(id string) *Reseller {
+	for i := range cfg.Resellers {
+		if cfg.Resellers[i].ID == id {
+			return &cfg.Resellers[i]
+		}
+	}
+
+	return nil
+}
+
+// Secrets configures Google Secret Manager.
+type Secrets struct {
+	// Project holds the secrets, empty uses the project the server runs in.
+	Project string `yaml:"project"`
+
+	// CacheTTL is how long secrets are cached before being fetched again.
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+}
+
+// Product holds settings for a single product.
+type Product struct {
+	// Key is the ID of the key this product's licenses are signed with,
+	// empty uses the default key.
+	Key string `yaml:"key"`
+
+	// CrossSignKey is the ID of a key that the product's licenses are also
+	// signed with, set it to the old key while rotating to a new one so that
+	// software that only knows the old key keeps working.
+	CrossSignKey string `yaml:"cross_sign_key"`
+
+	// ActivationEntitlement is the entitlement that activations count
+	// against, e.g. "domains" for a plugin activated once per site. Its
+	// limit caps the activations of licenses without max_activations.
+	ActivationEntitlement string `yaml:"activation_entitlement"`
+
+	// Templates are named bundles of license settings, e.g. "pro-annual".
+	Templates map[string]Template `yaml:"templates"`
+
+	// Plans are the tiers a license can be on, e.g. "personal", "business"
+	// and "agency", and moved between.
+	Plans map[string]Plan `yaml:"plans"`
+
+	// Bundle makes the product a bundle of other products, e.g.
+	// domain_changer and two other plugins, whose licenses are valid for
+	// each of them. A product without its own entitlements has the
+	// license's.
+	Bundle map[string]BundledProduct `yaml:"bundle"`
+
+	// ProratePlanChanges scales the time left on a license by the ratio of
+	// the old and new plans' prices when it changes plan.
+	ProratePlanChanges bool `yaml:"prorate_plan_changes"`
+
+	// EnforceRegions fails validation of licenses used outside their
+	// regions, otherwise it is only reported.
+	EnforceRegions bool `yaml:"enforce_regions"`
+
+	// Release is the latest version of the product, which the update
+	// endpoint offers to licensed installs.
+	Release Release `yaml:"release"`
+
+	// EDDItemID and EDDItemName are the product's item in Easy Digital
+	// Downloads, which clients of the EDD compatible API send instead of the
+	// product ID.
+	EDDItemID   int    `yaml:"edd_item_id"`
+	EDDItemName string `yaml:"edd_item_name"`
+
+	// Alerts overrides the default anomaly alert thresholds for the
+	// product.
+	Alerts *AlertThresholds `yaml:"alerts"`
+
+	// Policy is how the product's licenses are issued and validated when
+	// the create request doesn't say.
+	Policy Policy `yaml:"policy"`
+
+	// Cap is the most licenses that can ever be issued for the product,
+	// e.g. 500 for a lifetime deal, zero is unlimited. Sandbox licenses
+	// aren't counted.
+	Cap int `yaml:"cap"`
+}
+
+// BundledProduct is a product of a bundle.
+type BundledProduct struct {
+	// Entitlements are the features of the product the bundle unlocks,
+	// they replace those of the license for it. Nil uses the license's.
+	Entitlements map[string]int `yaml:"entitlements"`
+}
+
+// Policy overrides the licenses section for a product, settings left out use
+// it.
+type Policy struct {
+	// Expiry is how long licenses are valid for, 0s never expires. Templates
+	// and create requests that set an expiry override it.
+	Expiry *time.Duration `yaml:"expiry"`
+
+	// GracePeriod is how long after expiring a license is in grace.
+	GracePeriod *time.Duration `yaml:"grace_period"`
+
+	// RevalidateAfter overrides Licenses.RevalidateAfter, 0s leaves it to
+	// the software.
+	RevalidateAfter *time.Duration `yaml:"revalidate_after"`
+
+	// MaxActivations limits the activations of licenses whose template,
+	// plan and create request don't, zero is unlimited.
+	MaxActivations int `yaml:"max_activations"`
+
+	// DeactivateAfter frees activations that haven't been seen (activated
+	// or validated with their fingerprint) for this long, e.g. 2160h for
+	// 90 days, so that sites that were deleted don't keep using seats.
+	// Zero keeps activations until they are deactivated.
+	DeactivateAfter time.Duration `yaml:"deactivate_after"`
+
+	// Sharing flags licenses checking in from more installs or addresses
+	// than a single customer plausibly has.
+	Sharing Sharing `yaml:"sharing"`
+
+	// Formats are the formats licenses may be issued in, "v1" (an RS256 JWT)
+	// and "v2" (RS256 without the JWT header), so that a product's software
+	// only has to verify the algorithms it knows. Empty allows both, the
+	// license-v2 feature flag picks between them.
+	Formats []string `yaml:"formats"`
+}
+
+// Sharing decides when a license is being shared, such as a key posted on a
+// forum, from the distinct fingerprints and IP addresses it is activated and
+// validated from.
+type Sharing struct {
+	// Fingerprints and IPs are the most distinct installs and addresses a
+	// license may check in from within a window, zero doesn't limit them.
+	Fingerprints int `yaml:"fingerprints"`
+	IPs          int `yaml:"ips"`
+
+	// Window is how long check-ins are counted for, 24h if zero.
+	Window time.Duration `yaml:"window"`
+
+	// Suspend suspends licenses that are flagged, they then fail
+	// validation as suspended until the suspension is lifted.
+	Suspend bool `yaml:"suspend"`
+}
+
+// Enabled reports whether sharing is detected.
+func (s Sharing) Enabled() bool {
+	return s.Fingerprints > 0 || s.IPs > 0
+}
+
+// Period returns the window check-ins are counted for.
+func (s Sharing) Period() time.Duration {
+	if s.Window == 0 {
+		return 24 * time.Hour
+	}
+
+	return s.Window
+}
+
+// Release describes the latest version of a product for the update endpoint,
+// the Requires fields are shown by WordPress.
+type Release struct {
+	Version   string `yaml:"version"`
+	Changelog string `yaml:"changelog"`
+
+	// Package is the file in storage holding the release, e.g.
+	// releases/domain_changer-1.4.0.zip. It is only downloaded with a
+	// download token, so access tokens must be enabled.
+	Package string `yaml:"package"`
+
+	Requires    string `yaml:"requires"`     // WordPress version
+	Tested      string `yaml:"tested"`       // WordPress version
+	RequiresPHP string `yaml:"requires_php"` // PHP version
+}
+
+// Plan is a set of entitlements that licenses on it have.
+type Plan struct {
+	// Entitlements are the features the plan unlocks (see license.License).
+	Entitlements map[string]int `yaml:"entitlements"`
+
+	// MaxActivations limits the number of activations, zero is unlimited.
+	MaxActivations int `yaml:"max_activations"`
+
+	// Seats makes licenses on the plan named-user licenses with this many
+	// members, such as a business plan for a team. Zero has no named users.
+	Seats int `yaml:"seats"`
+
+	// Price is only used for prorating, so any unit will do.
+	Price float64 `yaml:"price"`
+}
+
+// Template holds the settings for licenses created from it, any of which the
+// create request can override.
+type Template struct {
+	// Expiry is how long licenses are valid for, zero uses the default.
+	Expiry time.Duration `yaml:"expiry"`
+
+	// Entitlements are the features the license unlocks (see
+	// license.License).
+	Entitlements map[string]int `yaml:"entitlements"`
+
+	// MaxActivations limits the number of activations, zero is unlimited.
+	MaxActivations int `yaml:"max_activations"`
+
+	// Seats makes licenses named-user licenses for this many users (see
+	// license.License).
+	Seats int `yaml:"seats"`
+
+	// Attrs are default license attributes.
+	Attrs map[string]string `yaml:"attrs"`
+
+	// Regions are the countries licenses may be used in, empty allows
+	// every country (see license.License).
+	Regions []string `yaml:"regions"`
+
+	// MinVersion and MaxVersion limit the product versions licenses cover
+	// (see license.License).
+	MinVersion string `yaml:"min_version"`
+	MaxVersion string `yaml:"max_version"`
+}
+
+// Storage configures the file storage backend (see storage.Open).
+type Storage struct {
+	// Backend is one of gcs, file or memory, empty uses the platform's
+	// default (gcs on App Engine, file otherwise).
+	Backend string `yaml:"backend"`
+
+	// Location is the bucket or directory, empty uses the default.
+	Location string `yaml:"location"`
+
+	// SignedURLs is how long the signed URLs that license files and offline
+	// bundles are downloaded from work, zero sends them through the app.
+	// Only GCS can sign URLs, other backends always send files themselves.
+	SignedURLs time.Duration `yaml:"signed_urls"`
+
+	// BreakerThreshold is the number of failed calls in a row, from
+	// timeouts or server errors, after which calls to storage and the
+	// revocations datastore fail fast for BreakerCooldown. A single call is
+	// then let through to probe whether the backend has recovered. Zero
+	// disables the breaker.
+	BreakerThreshold int           `yaml:"breaker_threshold"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown"`
+}
+
+// Licenses configures newly issued licenses.
+type Licenses struct {
+	// DefaultExpiry is how long new licenses are valid for, zero means they
+	// never expire.
+	DefaultExpiry time.Duration `yaml:"default_expiry"`
+
+	// GracePeriod is how long after expiring a license is reported as in
+	// grace, when the software should keep working while asking for a
+	// renewal.
+	GracePeriod time.Duration `yaml:"grace_period"`
+
+	// RevalidateAfter is how long software may rely on a verdict before
+	// validating again, sent as a time in validation responses and signed
+	// into receipts so that validation traffic can be tuned from here. Zero
+	// leaves it to the software.
+	RevalidateAfter time.Duration `yaml:"revalidate_after"`
+
+	// Watermark puts the purchaser in a visible claim of each license to
+	// discourage sharing, one of "" (off), WatermarkPlain or WatermarkHash.
+	Watermark string `yaml:"watermark"`
+}
+
+// License watermarks.
+const (
+	WatermarkPlain = "plain" // the customer's name and email
+	WatermarkHash  = "hash"  // a hash of the customer's email
+)
+
+// Revocations configures the revocation list.
+type Revocations struct {
+	// Store is where revocations are recorded, RevocationsFile (the source
+	// file) or RevocationsDatastore.
+	Store string `yaml:"store"`
+
+	// Shadow is the other store while migrating between them, revocations
+	// are also recorded in it and its list is compared with the store's,
+	// logging any differences. Empty doesn't shadow the store.
+	Shadow string `yaml:"shadow"`
+
+	// Source is the private file revocations are recorded in by the file
+	// store.
+	Source string `yaml:"source"`
+
+	// Output is the public, signed file generated from the source.
+	Output string `yaml:"output"`
+
+	// Log is the private file the transparency log of revocations is kept
+	// in (see package translog).
+	Log string `yaml:"log"`
+
+	// TTL is how long a generated revocation list is valid for. It must be
+	// comfortably longer than the interval it is regenerated at (see
+	// cron.yaml).
+	TTL time.Duration `yaml:"ttl"`
+
+	// ShardPrefixLength splits the list into shards of the IDs sharing a
+	// prefix of this length, for clients that only want to fetch the
+	// revocations relevant to their license. Zero doesn't shard the list.
+	ShardPrefixLength int `yaml:"shard_prefix_length"`
+}
+
+// Revocation stores.
+const (
+	RevocationsFile      = "file"
+	RevocationsDatastore = "datastore"
+)
+
+// RateLimit limits the number of API requests per client IP address.
+type RateLimit struct {
+	// RequestsPerMinute is the sustained rate allowed, zero disables rate
+	// limiting.
+	RequestsPerMinute int `yaml:"requests_per_minute"`
+
+	// Burst is the number of requests allowed above the sustained rate.
+	Burst int `yaml:"burst"`
+}
+
+// PII configures encryption of customers' personal data at rest, which is
+// only supported by the datastore.
+type PII struct {
+	// KMSKey is the name of the Cloud KMS key that the data keys are
+	// encrypted with, empty stores personal data unencrypted.
+	KMSKey string `yaml:"kms_key"`
+
+	// DataKey and IndexKey are the base64 encoded, KMS encrypted keys that
+	// personal data is encrypted with and that blind indexes for searching
+	// it are computed with. Each is 32 random bytes before encryption.
+	DataKey  string `yaml:"data_key"`
+	IndexKey string `yaml:"index_key"`
+}
+
+// AccessToken configures the short-lived tokens that licenses can be
+// exchanged for (see license.AccessToken).
+type AccessToken struct {
+	// Key is the ID of the key tokens are signed with, it must not sign
+	// licenses. Empty disables access tokens.
+	Key string `yaml:"key"`
+
+	// TTL is how long a token is valid for.
+	TTL time.Duration `yaml:"ttl"`
+
+	// Scopes maps each scope a token can be issued for to the entitlement a
+	// license needs for it, empty requires none.
+	Scopes map[string]string `yaml:"scopes"`
+}
+
+// Mail configures emails to customers.
+type Mail struct {
+	// Sender is the address mail is sent from, empty doesn't send mail.
+	Sender string `yaml:"sender"`
+
+	// Issued emails customers their license when it is issued.
+	Issued bool `yaml:"issued"`
+
+	// ExpiryReminder is how long before their license expires customers
+	// are reminded, zero doesn't remind them.
+	ExpiryReminder time.Duration `yaml:"expiry_reminder"`
+
+	// RecoveryTTL is how long the links customers are emailed to recover
+	// their license keys work, zero disables recovery. Links are signed
+	// with the access token key.
+	RecoveryTTL time.Duration `yaml:"recovery_ttl"`
+
+	// RecoveryPerAddress is how many recovery links are emailed to an
+	// address a day, RecoveryPerClient how many recovery requests a client
+	// IP address can make an hour.
+	RecoveryPerAddress int `yaml:"recovery_per_address"`
+	RecoveryPerClient  int `yaml:"recovery_per_client"`
+}
+
+// Payments configures the webhooks of payment providers, which revoke
+// licenses when their payment is refunded or charged back.
+type Payments struct {
+	// StripeWebhookSecret is the name of the secret in Secret Manager that
+	// holds the signing secret of the Stripe webhook endpoint, empty disables
+	// the endpoint.
+	StripeWebhookSecret string `yaml:"stripe_webhook_secret"`
+
+	// PayPal configures PayPal's Instant Payment Notifications, which also
+	// issue licenses for completed payments.
+	PayPal PayPal `yaml:"paypal"`
+
+	// FastSpring configures the FastSpring webhook, which also issues
+	// licenses for completed orders.
+	FastSpring FastSpring `yaml:"fastspring"`
+}
+
+// FastSpring configures the FastSpring webhook endpoint.
+type FastSpring struct {
+	// WebhookSecret is the name of the secret in Secret Manager that holds
+	// the HMAC secret of the webhook, empty disables the endpoint.
+	WebhookSecret string `yaml:"webhook_secret"`
+
+	// SKUs maps the SKUs (or product paths) of FastSpring products to the
+	// license issued for them.
+	SKUs map[string]PaymentItem `yaml:"skus"`
+}
+
+// PayPal configures the PayPal IPN endpoint.
+type PayPal struct {
+	// Receiver is the email address of the PayPal account that is paid,
+	// notifications for other accounts are ignored. Empty disables the
+	// endpoint.
+	Receiver string `yaml:"receiver"`
+
+	// Items maps the item_number of PayPal buttons to the license issued
+	// for them.
+	Items map[string]PaymentItem `yaml:"items"`
+}
+
+// PaymentItem is the license issued when a payment provider's item is paid
+// for, created from a template or put on a plan of the product.
+type PaymentItem struct {
+	Product  string `yaml:"product"`
+	Template string `yaml:"template"`
+	Plan     string `yaml:"plan"`
+
+	// Price and Currency are what a unit of a PayPal item must be paid,
+	// e.g. "49.00" and "USD", as buyers can change the amount of a button.
+	Price    string `yaml:"price"`
+	Currency string `yaml:"currency"`
+}
+
+// Slack configures posting to a Slack channel.
+type Slack struct {
+	// WebhookSecret is the name of the secret in Secret Manager that holds
+	// the URL of the channel's incoming webhook, empty disables Slack.
+	WebhookSecret string `yaml:"webhook_secret"`
+
+	// Events are the licensing events posted to Slack, from SlackEvents.
+	Events []string `yaml:"events"`
+}
+
+// SlackEvents are the events that can be posted to Slack.
+var SlackEvents = []string{
+	"license.revoked",      // revoked through the API
+	"license.auto-revoked", // revoked over a refund or chargeback
+	"key.rotated",          // a product started signing with another key
+	"quota.exceeded",       // an API key or reseller used up a quota
+	"reconcile.mismatch",   // the stores and exported revocation list disagree
+	"product.kill-switch",  // a product's kill-switch was set or cleared
+	"license.sharing",      // a license was flagged as shared
+}
+
+// Certificate configures the branding of the PDF certificates of licenses.
+type Certificate struct {
+	// Issuer is the name shown as issuing the certificates, e.g. the
+	// company selling the products.
+	Issuer string `yaml:"issuer"`
+
+	// Color is the colour of the certificates' header and border, as
+	// #rrggbb.
+	Color string `yaml:"color"`
+}
+
+// RGB returns the components of the certificate colour from 0 to 255.
+func (c Certificate) RGB() (r, g, b uint8) {
+	rgb, _ := hex.DecodeString(strings.TrimPrefix(c.Color, "#"))
+
+	if len(rgb) != 3 {
+		return 0, 0, 0
+	}
+
+	return rgb[0], rgb[1], rgb[2]
+}
+
+// Compression configures the gzip compression of API responses.
+type Compression struct {
+	// MinSize is the size in bytes from which responses are compressed for
+	// clients that accept gzip, smaller ones aren't worth it. Zero disables
+	// compression.
+	MinSize int `yaml:"min_size"`
+}
+
+// Maintenance configures maintenance mode, in which nothing is changed so
+// that the stores can be migrated without racing live writes.
+type Maintenance struct {
+	// Enabled rejects the requests that would change a store with a 503.
+	Enabled bool `yaml:"enabled"`
+
+	// RetryAfter is sent to rejected clients as Retry-After.
+	RetryAfter time.Duration `yaml:"retry_after"`
+}
+
+// Region configures serving validations from several regions. The primary
+// deployment copies the public keys and the revocation list it publishes to
+// a bucket in each replica region, and the deployments there validate with
+// them and send requests that change anything to the primary.
+type Region struct {
+	// Name is the deployment's region, e.g. europe-west1.
+	Name string `yaml:"name"`
+
+	// Primary is the URL of the primary deployment, which makes this one a
+	// replica that redirects the requests that would change a store, and
+	// the cron jobs, to it.
+	Primary string `yaml:"primary"`
+
+	// Replicas are the regions the primary copies its published files to.
+	Replicas []Replica `yaml:"replicas"`
+}
+
+// Replica is a region with deployments that read from their own bucket.
+type Replica struct {
+	Region string `yaml:"region"`
+
+	// Location is the region's bucket, of the same backend as the
+	// primary's storage.
+	Location string `yaml:"location"`
+}
+
+// Snapshots configures the daily snapshots of the licenses, the revocations
+// and the public keys, which admins can restore.
+type Snapshots struct {
+	// Location is the bucket or directory snapshots are written to, of the
+	// same backend as storage, empty writes them to storage's. Give the
+	// bucket object versioning so they can't be overwritten, and a lifecycle
+	// rule to delete old ones.
+	Location string `yaml:"location"`
+}
+
+// CDN configures publishing the revocation list to a public bucket behind a
+// CDN, so that installs download it from the CDN rather than the app. The
+// list is published after every revocation under a name of its own, which
+// never changes and can be cached forever, and then latest.json names it.
+type CDN struct {
+	// Location is the bucket, of the same backend as storage, empty doesn't
+	// publish to a CDN.
+	Location string `yaml:"location"`
+
+	// URL is where the CDN serves the bucket, e.g. https://cdn.example.com,
+	// latest.json then has the URL of the list too.
+	URL string `yaml:"url"`
+
+	// MaxAge is how long the CDN and installs may cache latest.json, so how
+	// long a revocation can take to reach them.
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// Alerts configures alerts on unusual volumes of licenses issued, validated
+// and revoked, such as a spike in issuance from a leaked key or a drop in
+// validations from an outage.
+type Alerts struct {
+	// Slack posts alerts to the Slack webhook.
+	Slack bool `yaml:"slack"`
+
+	// Emails are the addresses alerts are mailed to, mail must have a
+	// sender.
+	Emails []string `yaml:"emails"`
+
+	// BaselineHours is how many hours before the hour being checked its
+	// volume is compared with.
+	BaselineHours int `yaml:"baseline_hours"`
+
+	// Thresholds apply to products that don't have their own.
+	Thresholds AlertThresholds `yaml:"thresholds"`
+}
+
+// Enabled reports whether alerts are sent anywhere, volumes are only
+// counted if they are.
+func (a Alerts) Enabled() bool {
+	return a.Slack || len(a.Emails) > 0
+}
+
+// AlertThresholds decide when an hour's volume is unusual compared with the
+// hourly average of the baseline.
+type AlertThresholds struct {
+	// Spike alerts when the volume is more than this multiple of the
+	// baseline, zero disables spike alerts.
+	Spike float64 `yaml:"spike"`
+
+	// Drop alerts when the volume is less than this fraction of the
+	// baseline, zero disables drop alerts.
+	Drop float64 `yaml:"drop"`
+
+	// MinBaseline is the smallest hourly average that drops are alerted on
+	// and that spikes are measured against, so that quiet products don't
+	// alert on every few licenses.
+	MinBaseline float64 `yaml:"min_baseline"`
+}
+
+func (t AlertThresholds) validate() error {
+	if t.Spike < 0 || t.Drop < 0 || t.MinBaseline < 0 {
+		return fmt.Errorf("thresholds must not be negative")
+	}
+
+	if t.Spike != 0 && t.Spike <= 1 {
+		return fmt.Errorf("spike must be more than 1")
+	}
+
+	if t.Drop >= 1 {
+		return fmt.Errorf("drop must be less than 1")
+	}
+
+	return nil
+}
+
+func (pol Policy) validate(p Product) error {
+	if pol.Expiry != nil && *pol.Expiry < 0 {
+		return fmt.Errorf("expiry must not be negative")
+	}
+
+	if pol.GracePeriod != nil && *pol.GracePeriod < 0 {
+		return fmt.Errorf("grace_period must not be negative")
+	}
+
+	if pol.RevalidateAfter != nil && *pol.RevalidateAfter < 0 {
+		return fmt.Errorf("revalidate_after must not be negative")
+	}
+
+	if pol.MaxActivations < 0 {
+		return fmt.Errorf("max_activations must not be negative")
+	}
+
+	if pol.DeactivateAfter < 0 || pol.DeactivateAfter > 0 && pol.DeactivateAfter < 24*time.Hour {
+		return fmt.Errorf("deactivate_after must be zero or at least a day")
+	}
+
+	if sh := pol.Sharing; sh.Fingerprints < 0 || sh.IPs < 0 {
+		return fmt.Errorf("sharing limits must not be negative")
+	}
+
+	if sh := pol.Sharing; sh.Window < 0 || sh.Window > 0 && sh.Window < time.Hour {
+		return fmt.Errorf("sharing window must be zero or at least an hour")
+	}
+
+	if sh := pol.Sharing; sh.Suspend && !sh.Enabled() {
+		return fmt.Errorf("sharing can't suspend licenses without fingerprints or ips")
+	}
+
+	v1 := len(pol.Formats) == 0
+
+	for _, f := range pol.Formats {
+		switch f {
+		case "v1":
+			v1 = true
+		case "v2":
+		default:
+			return fmt.Errorf("unknown format %q", f)
+		}
+	}
+
+	// only v1 licenses can carry two signatures
+	if p.CrossSignKey != "" && !v1 {
+		return fmt.Errorf("cross-signed products must allow the v1 format")
+	}
+
+	return nil
+}
+
+// DefaultExpiry returns how long a product's licenses are valid for when
+// neither their template nor the create request says, zero never expires.
+func (cfg *Config) DefaultExpiry(product string) time.Duration {
+	if e := cfg.Products[product].Policy.Expiry; e != nil {
+		return *e
+	}
+
+	return cfg.Licenses.DefaultExpiry
+}
+
+// GracePeriod returns how long a product's licenses are in grace after
+// expiring.
+func (cfg *Config) GracePeriod(product string) time.Duration {
+	if g := cfg.Products[product].Policy.GracePeriod; g != nil {
+		return *g
+	}
+
+	return cfg.Licenses.GracePeriod
+}
+
+// RevalidateAfter returns how long software may rely on a verdict on one of
+// a product's licenses, zero if the server doesn't say.
+func (cfg *Config) RevalidateAfter(product string) time.Duration {
+	if d := cfg.Products[product].Policy.RevalidateAfter; d != nil {
+		return *d
+	}
+
+	return cfg.Licenses.RevalidateAfter
+}
+
+// AlertThresholds returns the anomaly alert thresholds for a product.
+func (cfg *Config) AlertThresholds(product string) AlertThresholds {
+	if p, ok := cfg.Products[product]; ok && p.Alerts != nil {
+		return *p.Alerts
+	}
+
+	return cfg.Alerts.Thresholds
+}
+
+// signsWith reports whether a key signs licenses or access tokens.
+func (cfg *Config) signsWith(kid string) bool {
+	if kid == "" {
+		return false
+	}
+
+	if kid == cfg.Keys.ID || kid == cfg.Keys.SandboxID || kid == cfg.Keys.CrossSignID || kid == cfg.AccessToken.Key {
+		return true
+	}
+
+	for _, p := range cfg.Products {
+		if kid == p.Key || kid == p.CrossSignKey {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsCompromised reports whether a key is listed as compromised.
+func (cfg *Config) IsCompromised(kid string) bool {
+	for _, k := range cfg.Keys.Compromised {
+		if k == kid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Default returns the configuration used when nothing is overridden.
+func Default() *Config {
+	return &Config{
+		Keys: Keys{
+			Source:    "storage",
+			ID:        "plugin",
+			SandboxID: "sandbox",
+			CacheTTL:  10 * time.Minute,
+		},
+		Secrets: Secrets{
+			CacheTTL: 10 * time.Minute,
+		},
+		Licenses: Licenses{
+			RevalidateAfter: 24 * time.Hour,
+		},
+		Storage: Storage{
+			BreakerThreshold: 5,
+			BreakerCooldown:  30 * time.Second,
+		},
+		Revocations: Revocations{
+			Store:  RevocationsFile,
+			Source: "revocations.txt",
+			Output: "revocations.json",
+			Log:    "revocations.log",
+			TTL:    72 * time.Hour,
+		},
+		AccessToken: AccessToken{
+			TTL: 15 * time.Minute,
+		},
+		Mail: Mail{
+			RecoveryPerAddress: 3,
+			RecoveryPerClient:  10,
+		},
+		Certificate: Certificate{
+			Issuer: "Volcanic Pixels",
+			Color:  "#1d3557",
+		},
+		Compression: Compression{
+			MinSize: 1024,
+		},
+		Maintenance: Maintenance{
+			RetryAfter: 5 * time.Minute,
+		},
+		CDN: CDN{
+			MaxAge: time.Minute,
+		},
+		Alerts: Alerts{
+			BaselineHours: 24,
+			Thresholds: AlertThresholds{
+				Spike:       3,
+				Drop:        0.25,
+				MinBaseline: 5,
+			},
+		},
+	}
+}
+
+// DefaultProduct is the only product of configs that don't list any.
+const DefaultProduct = "domain_changer"
+
+// Load returns the default configuration overridden by the YAML file at path
+// (if it exists) and then by environment variables, and validates it.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return nil, err
+		default:
+			if err := yaml.Unmarshal(data, cfg); err != nil {
+				return nil, fmt.Errorf("config: parsing %v: %v", path, err)
+			}
+		}
+	}
+
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	// configs from before unknown products were rejected may not list any,
+	// they are treated as selling the default product alone rather than
+	// failing every create request
+	if len(cfg.Products) == 0 {
+		cfg.Products = map[string]Product{DefaultProduct: {}}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// loadEnv overrides settings with any environment variables that are set.
+func (cfg *Config) loadEnv() error {
+	strs := map[string]*string{
+		"KEY_SOURCE":                &cfg.Keys.Source,
+		"KEY_ID":                    &cfg.Keys.ID,
+		"SANDBOX_KEY_ID":            &cfg.Keys.SandboxID,
+		"ROOT_KEY_ID":               &cfg.Keys.RootID,
+		"CROSS_SIGN_KEY_ID":         &cfg.Keys.CrossSignID,
+		"LEGACY_KEY_ID":             &cfg.Keys.LegacyID,
+		"STORAGE_BACKEND":           &cfg.Storage.Backend,
+		"STORAGE_LOCATION":          &cfg.Storage.Location,
+		"REVOCATIONS_STORE":         &cfg.Revocations.Store,
+		"REVOCATIONS_SHADOW":        &cfg.Revocations.Shadow,
+		"REVOCATIONS_SOURCE":        &cfg.Revocations.Source,
+		"REVOCATIONS_OUTPUT":        &cfg.Revocations.Output,
+		"REVOCATIONS_LOG":           &cfg.Revocations.Log,
+		"SECRETS_PROJECT":           &cfg.Secrets.Project,
+		"WEBHOOK_SECRET":            &cfg.WebhookSecret,
+		"PII_KMS_KEY":               &cfg.PII.KMSKey,
+		"PII_DATA_KEY":              &cfg.PII.DataKey,
+		"PII_INDEX_KEY":             &cfg.PII.IndexKey,
+		"ACCESS_TOKEN_KEY_ID":       &cfg.AccessToken.Key,
+		"LICENSE_WATERMARK":         &cfg.Licenses.Watermark,
+		"MAIL_SENDER":               &cfg.Mail.Sender,
+		"STRIPE_WEBHOOK_SECRET":     &cfg.Payments.StripeWebhookSecret,
+		"PAYPAL_RECEIVER":           &cfg.Payments.PayPal.Receiver,
+		"FASTSPRING_WEBHOOK_SECRET": &cfg.Payments.FastSpring.WebhookSecret,
+		"SLACK_WEBHOOK_SECRET":      &cfg.Slack.WebhookSecret,
+		"CERTIFICATE_ISSUER":        &cfg.Certificate.Issuer,
+		"CERTIFICATE_COLOR":         &cfg.Certificate.Color,
+		"REGION":                    &cfg.Region.Name,
+		"PRIMARY_URL":               &cfg.Region.Primary,
+		"SNAPSHOTS_LOCATION":        &cfg.Snapshots.Location,
+		"CDN_LOCATION":              &cfg.CDN.Location,
+		"CDN_URL":                   &cfg.CDN.URL,
+	}
+
+	for name, v := range strs {
+		if s := os.Getenv(name); s != "" {
+			*v = s
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"LICENSE_DEFAULT_EXPIRY":   &cfg.Licenses.DefaultExpiry,
+		"LICENSE_GRACE_PERIOD":     &cfg.Licenses.GracePeriod,
+		"LICENSE_REVALIDATE_AFTER": &cfg.Licenses.RevalidateAfter,
+		"REVOCATIONS_TTL":          &cfg.Revocations.TTL,
+		"SECRETS_CACHE_TTL":        &cfg.Secrets.CacheTTL,
+		"ACCESS_TOKEN_TTL":         &cfg.AccessToken.TTL,
+		"STORAGE_SIGNED_URLS":      &cfg.Storage.SignedURLs,
+		"STORAGE_BREAKER_COOLDOWN": &cfg.Storage.BreakerCooldown,
+		"KEY_CACHE_TTL":            &cfg.Keys.CacheTTL,
+		"MAIL_EXPIRY_REMINDER":     &cfg.Mail.ExpiryReminder,
+		"MAIL_RECOVERY_TTL":        &cfg.Mail.RecoveryTTL,
+		"MAINTENANCE_RETRY_AFTER":  &cfg.Maintenance.RetryAfter,
+		"CDN_MAX_AGE":              &cfg.CDN.MaxAge,
+	}
+
+	for name, v := range durations {
+		if s := os.Getenv(name); s != "" {
+			d, err := time.ParseDuration(s)
+
+			if err != nil {
+				return fmt.Errorf("config: %v: %v", name, err)
+			}
+
+			*v = d
+		}
+	}
+
+	ints := map[string]*int{
+		"RATE_LIMIT_PER_MINUTE":           &cfg.RateLimit.RequestsPerMinute,
+		"RATE_LIMIT_BURST":                &cfg.RateLimit.Burst,
+		"REVOCATIONS_SHARD_PREFIX_LENGTH": &cfg.Revocations.ShardPrefixLength,
+		"ALERTS_BASELINE_HOURS":           &cfg.Alerts.BaselineHours,
+		"COMPRESSION_MIN_SIZE":            &cfg.Compression.MinSize,
+		"STORAGE_BREAKER_THRESHOLD":       &cfg.Storage.BreakerThreshold,
+	}
+
+	for name, v := range ints {
+		if s := os.Getenv(name); s != "" {
+			n, err := strconv.Atoi(s)
+
+			if err != nil {
+				return fmt.Errorf("config: %v: %v", name, err)
+			}
+
+			*v = n
+		}
+	}
+
+	bools := map[string]*bool{
+		"MAINTENANCE": &cfg.Maintenance.Enabled,
+	}
+
+	for name, v := range bools {
+		if s := os.Getenv(name); s != "" {
+			b, err := strconv.ParseBool(s)
+
+			if err != nil {
+				return fmt.Errorf("config: %v: %v", name, err)
+			}
+
+			*v = b
+		}
+	}
+
+	return nil
+}
+
+// Validate checks that the settings are usable.
+func (cfg *Config) Validate() error {
+	switch cfg.Keys.Source {
+	case "storage", "secretmanager":
+	default:
+		return fmt.Errorf("config: unknown key source %q", cfg.Keys.Source)
+	}
+
+	if cfg.Keys.ID == "" || cfg.Keys.SandboxID == "" {
+		return fmt.Errorf("config: key IDs are required")
+	}
+
+	if cfg.Keys.ID == cfg.Keys.SandboxID {
+		return fmt.Errorf("config: the sandbox must have its own key")
+	}
+
+	for _, kid := range cfg.Keys.Compromised {
+		if cfg.signsWith(kid) {
+			return fmt.Errorf("config: compromised key %q still signs licenses or tokens", kid)
+		}
+	}
+
+	if cfg.Keys.RootID != "" && (cfg.Keys.RootID == cfg.Keys.SandboxID || cfg.Keys.RootID == cfg.Keys.ID) {
+		return fmt.Errorf("config: the root key must not sign licenses")
+	}
+
+	if cfg.Keys.CrossSignID == cfg.Keys.SandboxID || cfg.Keys.CrossSignID == cfg.Keys.ID {
+		return fmt.Errorf("config: the default key must be cross-signed with another key that isn't the sandbox's")
+	}
+
+	for name, p := range cfg.Products {
+		if p.Key == cfg.Keys.SandboxID || p.CrossSignKey == cfg.Keys.SandboxID {
+			return fmt.Errorf("config: products must not use the sandbox key")
+		}
+
+		if p.CrossSignKey != "" && p.CrossSignKey == p.Key {
+			return fmt.Errorf("config: %v must be cross-signed with a different key", name)
+		}
+
+		if p.Cap < 0 {
+			return fmt.Errorf("config: the cap of %v must not be negative", name)
+		}
+
+		if err := p.Policy.validate(p); err != nil {
+			return fmt.Errorf("config: the policy of %v: %v", name, err)
+		}
+	}
+
+	if t := cfg.AccessToken; t.Key != "" {
+		if t.TTL <= 0 {
+			return fmt.Errorf("config: access token TTL must be positive")
+		}
+
+		if t.Key == cfg.Keys.ID || t.Key == cfg.Keys.SandboxID || t.Key == cfg.Keys.RootID || t.Key == cfg.Keys.CrossSignID {
+			return fmt.Errorf("config: access tokens must be signed with a key that doesn't sign licenses")
+		}
+
+		for name, p := range cfg.Products {
+			if t.Key == p.Key || t.Key == p.CrossSignKey {
+				return fmt.Errorf("config: access tokens must be signed with a key that doesn't sign %v licenses", name)
+			}
+		}
+	}
+
+	ids := make(map[string]bool)
+
+	for _, key := range cfg.APIKeys {
+		if key.ID == "" || ids[key.ID] {
+			return fmt.Errorf("config: API keys must have unique IDs")
+		}
+
+		ids[key.ID] = true
+
+		if b, err := hex.DecodeString(key.Hash); err != nil || len(b) != 32 {
+			return fmt.Errorf("config: the hash of API key %v must be a hex encoded SHA-256", key.ID)
+		}
+
+		if key.Reseller != "" && cfg.Reseller(key.Reseller) == nil {
+			return fmt.Errorf("config: API key %v belongs to unknown reseller %v", key.ID, key.Reseller)
+		}
+
+		if key.Admin && key.Reseller != "" {
+			return fmt.Errorf("config: reseller API key %v can't be an admin key", key.ID)
+		}
+
+		if key.DailyQuota < 0 || key.MonthlyQuota < 0 {
+			return fmt.Errorf("config: API key %v has a negative quota", key.ID)
+		}
+	}
+
+	resellers := make(map[string]bool)
+
+	for _, r := range cfg.Resellers {
+		if r.ID == "" || resellers[r.ID] {
+			return fmt.Errorf("config: resellers must have unique IDs")
+		}
+
+		resellers[r.ID] = true
+
+		if r.MonthlyQuota < 0 {
+			return fmt.Errorf("config: reseller %v has a negative quota", r.ID)
+		}
+	}
+
+	if cfg.Secrets.CacheTTL < 0 {
+		return fmt.Errorf("config: secret cache TTL must not be negative")
+	}
+
+	switch cfg.Storage.Backend {
+	case "", "gcs", "file", "memory":
+	default:
+		return fmt.Errorf("config: unknown storage backend %q", cfg.Storage.Backend)
+	}
+
+	if cfg.Storage.SignedURLs < 0 || cfg.Storage.SignedURLs > 7*24*time.Hour {
+		return fmt.Errorf("config: signed URLs must work for between 0 and 7 days")
+	}
+
+	for name, p := range cfg.Products {
+		if name == "" {
+			return fmt.Errorf("config: product names must not be empty")
+		}
+
+		for tname, t := range p.Templates {
+			if t.Expiry < 0 || t.MaxActivations < 0 || t.Seats < 0 {
+				return fmt.Errorf("config: template %v of %v has a negative expiry, activation limit or seats", tname, name)
+			}
+
+			for feature, limit := range t.Entitlements {
+				if limit < 0 {
+					return fmt.Errorf("config: template %v of %v has a negative limit for %v", tname, name, feature)
+				}
+			}
+
+			for _, r := range t.Regions {
+				if len(r) != 2 {
+					return fmt.Errorf("config: template %v of %v has region %q, regions are two letter country codes", tname, name, r)
+				}
+			}
+		}
+
+		for pname, plan := range p.Plans {
+			if plan.MaxActivations < 0 || plan.Seats < 0 || plan.Price < 0 {
+				return fmt.Errorf("config: plan %v of %v has a negative activation limit, seat count or price", pname, name)
+			}
+
+			for feature, limit := range plan.Entitlements {
+				if limit < 0 {
+					return fmt.Errorf("config: plan %v of %v has a negative limit for %v", pname, name, feature)
+				}
+			}
+		}
+
+		for bname, b := range p.Bundle {
+			if bundled, ok := cfg.Products[bname]; !ok || bname == name || len(bundled.Bundle) > 0 {
+				return fmt.Errorf("config: the bundle %v must be of other products that aren't bundles, not %v", name, bname)
+			}
+
+			for feature, limit := range b.Entitlements {
+				if limit < 0 {
+					return fmt.Errorf("config: the bundle %v has a negative limit for %v of %v", name, feature, bname)
+				}
+			}
+		}
+
+		if p.Release.Package != "" && cfg.AccessToken.Key == "" {
+			return fmt.Errorf("config: the release of %v can't be downloaded without access tokens", name)
+		}
+
+		if p.Alerts != nil {
+			if err := p.Alerts.validate(); err != nil {
+				return fmt.Errorf("config: alert thresholds of %v: %v", name, err)
+			}
+		}
+	}
+
+	if err := cfg.validateItems("PayPal", cfg.Payments.PayPal.Items); err != nil {
+		return err
+	}
+
+	for id, item := range cfg.Payments.PayPal.Items {
+		if price, err := strconv.ParseFloat(item.Price, 64); err != nil || price <= 0 {
+			return fmt.Errorf("config: PayPal item %v must have a positive price, e.g. 49.00", id)
+		}
+
+		if len(item.Currency) != 3 || strings.ToUpper(item.Currency) != item.Currency {
+			return fmt.Errorf("config: PayPal item %v must have a currency code, e.g. USD", id)
+		}
+	}
+
+	if err := cfg.validateItems("FastSpring", cfg.Payments.FastSpring.SKUs); err != nil {
+		return err
+	}
+
+	for _, event := range cfg.Slack.Events {
+		known := false
+
+		for _, e := range SlackEvents {
+			known = known || e == event
+		}
+
+		if !known {
+			return fmt.Errorf("config: unknown Slack event %q", event)
+		}
+
+		if cfg.Slack.WebhookSecret == "" {
+			return fmt.Errorf("config: Slack events can't be posted without a Slack webhook")
+		}
+	}
+
+	if cfg.Mail.ExpiryReminder < 0 {
+		return fmt.Errorf("config: the expiry reminder must not be negative")
+	}
+
+	if m := cfg.Mail; m.RecoveryTTL < 0 || m.RecoveryPerAddress < 0 || m.RecoveryPerClient < 0 {
+		return fmt.Errorf("config: the recovery TTL and limits must not be negative")
+	}
+
+	if cfg.Mail.RecoveryTTL > 0 && (cfg.Mail.Sender == "" || cfg.AccessToken.Key == "") {
+		return fmt.Errorf("config: license recovery needs a mail sender and access tokens")
+	}
+
+	color := cfg.Certificate.Color
+
+	if _, err := hex.DecodeString(strings.TrimPrefix(color, "#")); err != nil || len(color) != 7 || color[0] != '#' {
+		return fmt.Errorf("config: the certificate color must be #rrggbb")
+	}
+
+	if a := cfg.Alerts; a.Enabled() {
+		if a.Slack && cfg.Slack.WebhookSecret == "" {
+			return fmt.Errorf("config: alerts can't be posted to Slack without a Slack webhook")
+		}
+
+		if len(a.Emails) > 0 && cfg.Mail.Sender == "" {
+			return fmt.Errorf("config: alerts can't be emailed without a mail sender")
+		}
+
+		if a.BaselineHours < 1 {
+			return fmt.Errorf("config: the alert baseline must be at least an hour")
+		}
+
+		if err := a.Thresholds.validate(); err != nil {
+			return fmt.Errorf("config: alert thresholds: %v", err)
+		}
+	}
+
+	if cfg.Licenses.DefaultExpiry < 0 || cfg.Licenses.GracePeriod < 0 {
+		return fmt.Errorf("config: default license expiry and grace period must not be negative")
+	}
+
+	if cfg.Licenses.RevalidateAfter < 0 {
+		return fmt.Errorf("config: license revalidate_after must not be negative")
+	}
+
+	switch cfg.Licenses.Watermark {
+	case "", WatermarkPlain, WatermarkHash:
+	default:
+		return fmt.Errorf("config: unknown license watermark %q", cfg.Licenses.Watermark)
+	}
+
+	switch cfg.Revocations.Store {
+	case RevocationsFile, RevocationsDatastore:
+	default:
+		return fmt.Errorf("config: unknown revocation store %q", cfg.Revocations.Store)
+	}
+
+	switch cfg.Revocations.Shadow {
+	case "":
+	case cfg.Revocations.Store:
+		return fmt.Errorf("config: the revocation store can't shadow itself")
+	case RevocationsFile, RevocationsDatastore:
+	default:
+		return fmt.Errorf("config: unknown revocation shadow store %q", cfg.Revocations.Shadow)
+	}
+
+	if cfg.Revocations.Source == "" || cfg.Revocations.Output == "" || cfg.Revocations.Log == "" {
+		return fmt.Errorf("config: revocation source, output and log files are required")
+	}
+
+	if r := cfg.Revocations; r.Source == r.Output || r.Log == r.Source || r.Log == r.Output {
+		return fmt.Errorf("config: revocation source, output and log must be different files")
+	}
+
+	if cfg.Revocations.TTL < time.Hour {
+		return fmt.Errorf("config: revocation list TTL must be at least an hour")
+	}
+
+	if cfg.Revocations.ShardPrefixLength < 0 || cfg.Revocations.ShardPrefixLength > 3 {
+		return fmt.Errorf("config: revocation shard prefix length must be between 0 and 3")
+	}
+
+	hasKeys := cfg.PII.DataKey != "" && cfg.PII.IndexKey != ""
+	noKeys := cfg.PII.DataKey == "" && cfg.PII.IndexKey == ""
+
+	if cfg.PII.KMSKey != "" && !hasKeys || cfg.PII.KMSKey == "" && !noKeys {
+		return fmt.Errorf("config: PII encryption needs a KMS key and both data keys")
+	}
+
+	for _, key := range []string{cfg.PII.DataKey, cfg.PII.IndexKey} {
+		if _, err := base64.StdEncoding.DecodeString(key); err != nil {
+			return fmt.Errorf("config: PII data keys must be base64 encoded")
+		}
+	}
+
+	for _, hook := range cfg.Webhooks {
+		u, err := url.Parse(hook)
+
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("config: invalid webhook URL %q", hook)
+		}
+	}
+
+	if cfg.RateLimit.RequestsPerMinute < 0 || cfg.RateLimit.Burst < 0 {
+		return fmt.Errorf("config: rate limits must not be negative")
+	}
+
+	if cfg.Storage.BreakerThreshold < 0 || cfg.Storage.BreakerThreshold > 0 && cfg.Storage.BreakerCooldown <= 0 {
+		return fmt.Errorf("config: the storage breaker needs a positive threshold and cooldown")
+	}
+
+	if cfg.Compression.MinSize < 0 {
+		return fmt.Errorf("config: the compression min_size must not be negative")
+	}
+
+	if cfg.Maintenance.RetryAfter < time.Second {
+		return fmt.Errorf("config: the maintenance retry_after must be at least a second")
+	}
+
+	if p := cfg.Region.Primary; p != "" {
+		u, err := url.Parse(p)
+
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("config: invalid primary URL %q", p)
+		}
+
+		if len(cfg.Region.Replicas) > 0 {
+			return fmt.Errorf("config: only the primary copies files to replicas")
+		}
+	}
+
+	for _, r := range cfg.Region.Replicas {
+		if r.Region == "" || r.Location == "" {
+			return fmt.Errorf("config: replicas need a region and a location")
+		}
+	}
+
+	if cdn := cfg.CDN.URL; cdn != "" {
+		u, err := url.Parse(cdn)
+
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("config: invalid CDN URL %q", cdn)
+		}
+	}
+
+	if cfg.CDN.MaxAge < 0 {
+		return fmt.Errorf("config: the CDN max_age must not be negative")
+	}
+
+	return nil
+}
+
+// validateItems checks that a payment provider's items issue licenses of
+// known products, templates and plans.
+func (cfg *Config) validateItems(provider string, items map[string]PaymentItem) error {
+	for id, item := range items {
+		p, ok := cfg.Products[item.Product]
+
+		if !ok {
+			return fmt.Errorf("config: %v item %v is for unknown product %q", provider, id, item.Product)
+		}
+
+		if _, ok := p.Templates[item.Template]; item.Template != "" && !ok {
+			return fmt.Errorf("config: %v item %v uses unknown template %q of %v", provider, id, item.Template, item.Product)
+		}
+
+		if _, ok := p.Plans[item.Plan]; item.Plan != "" && !ok {
+			return fmt.Errorf("config: %v item %v uses unknown plan %q of %v", provider, id, item.Plan, item.Product)
+		}
+	}
+
+	return nil
+}
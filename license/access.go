@@ -0,0 +1,102 @@
+package license
+
+import (
+	"crypto/rsa"
+	"errors"
+	"time"
+
+	"github.com/danielchatfield/go-jwt"
+	"github.com/dchest/uniuri"
+)
+
+// ErrAccessTokenExpired is returned by ParseAccessToken for expired tokens.
+var ErrAccessTokenExpired = errors.New("access token expired")
+
+// AccessToken is a short-lived token that a license is exchanged for, so
+// that services such as update downloads can check what the holder may do
+// without ever seeing the license. It is signed with its own key and doesn't
+// have a _prod claim, so it is never mistaken for a license.
+type AccessToken struct {
+	ID        string
+	LicenseID string
+	Product   string
+	Scope     string
+	Test      bool
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// NewAccessToken returns a token for the license with the given scope,
+// valid for ttl.
+func NewAccessToken(l *License, scope string, ttl time.Duration) *AccessToken {
+	now := time.Now()
+
+	return &AccessToken{
+		ID:        uniuri.New(),
+		LicenseID: l.ID,
+		Product:   l.Product,
+		Scope:     scope,
+		Test:      l.Test,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(ttl),
+	}
+}
+
+// Encode signs the token with key and returns the encoded token.
+func (at *AccessToken) Encode(key *rsa.PrivateKey) (string, error) {
+	t := jwt.NewToken(jwt.RSA)
+
+	t.SetClaim("jti", at.ID)
+	t.SetClaim("sub", at.LicenseID)
+	t.SetClaim("prod", at.Product)
+	t.SetClaim("scope", at.Scope)
+	t.SetClaim("iat", at.IssuedAt.Unix())
+	t.SetClaim("exp", at.ExpiresAt.Unix())
+
+	if at.Test {
+		t.SetClaim("test", true)
+	}
+
+	return t.Encode(key)
+}
+
+// ParseAccessToken verifies a token with key and checks that it hasn't
+// expired. Callers must check the scope.
+func ParseAccessToken(token string, key *rsa.PublicKey) (*AccessToken, error) {
+	tok, err := jwt.ParseToken(token, jwt.RSA, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	at := &AccessToken{}
+	at.ID, _ = tok.Claim("jti").(string)
+	at.Product, _ = tok.Claim("prod").(string)
+	at.Test, _ = tok.Claim("test").(bool)
+
+	var ok bool
+
+	if at.LicenseID, ok = tok.Claim("sub").(string); !ok {
+		return nil, errors.New("Error extracting access token license ID")
+	}
+
+	if at.Scope, ok = tok.Claim("scope").(string); !ok {
+		return nil, errors.New("Error extracting access token scope")
+	}
+
+	iat, _ := tok.Claim("iat").(float64)
+	exp, ok := tok.Claim("exp").(float64)
+
+	if !ok {
+		return nil, errors.New("Error extracting access token expiry")
+	}
+
+	at.IssuedAt = time.Unix(int64(iat), 0)
+	at.ExpiresAt = time.Unix(int64(exp), 0)
+
+	if !time.Now().Before(at.ExpiresAt) {
+		return nil, ErrAccessTokenExpired
+	}
+
+	return at, nil
+}
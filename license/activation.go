@@ -0,0 +1,90 @@
+package license
+
+import (
+	"crypto/rsa"
+	"errors"
+	"time"
+
+	"github.com/danielchatfield/go-jwt"
+)
+
+// OfflineActivation is the signed response to an air-gapped install's
+// activation request. It is signed with the same key as the license so that
+// the software can verify it with the public key it already has, and has a
+// _fp claim instead of _prod so that it is never mistaken for a license.
+type OfflineActivation struct {
+	LicenseID   string
+	Fingerprint string
+	IssuedAt    time.Time
+
+	// Sequence is the license's counter when the activation was signed, as
+	// in a Receipt. Activations from before it was added have zero.
+	Sequence int
+
+	// ExpiresAt is the license's expiry, nil if it never expires.
+	ExpiresAt *time.Time
+
+	// Certificate is the certificate of the signing key if it is an
+	// intermediate (see License.Certificate).
+	Certificate string
+}
+
+// Encode signs the activation with key and returns the encoded token.
+func (a *OfflineActivation) Encode(key *rsa.PrivateKey) (string, error) {
+	t := jwt.NewToken(jwt.RSA)
+
+	t.SetClaim("sub", a.LicenseID)
+	t.SetClaim("_fp", a.Fingerprint)
+	t.SetClaim("iat", a.IssuedAt.Unix())
+
+	if a.Sequence > 0 {
+		t.SetClaim("_seq", a.Sequence)
+	}
+
+	if a.ExpiresAt != nil {
+		t.SetClaim("exp", a.ExpiresAt.Unix())
+	}
+
+	if a.Certificate != "" {
+		t.SetClaim("_cert", a.Certificate)
+	}
+
+	return t.Encode(key)
+}
+
+// ParseOfflineActivation verifies an activation with key. Callers must check
+// that the fingerprint is that of the install and the license ID that of its
+// license.
+func ParseOfflineActivation(token string, key *rsa.PublicKey) (*OfflineActivation, error) {
+	tok, err := jwt.ParseToken(token, jwt.RSA, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	a := &OfflineActivation{}
+	var ok bool
+
+	if a.LicenseID, ok = tok.Claim("sub").(string); !ok {
+		return nil, errors.New("Error extracting activation license ID")
+	}
+
+	if a.Fingerprint, ok = tok.Claim("_fp").(string); !ok {
+		return nil, errors.New("Error extracting activation fingerprint")
+	}
+
+	iat, _ := tok.Claim("iat").(float64)
+	a.IssuedAt = time.Unix(int64(iat), 0)
+
+	seq, _ := tok.Claim("_seq").(float64)
+	a.Sequence = int(seq)
+
+	if exp, ok := tok.Claim("exp").(float64); ok {
+		expiresAt := time.Unix(int64(exp), 0)
+		a.ExpiresAt = &expiresAt
+	}
+
+	a.Certificate, _ = tok.Claim("_cert").(string)
+
+	return a, nil
+}
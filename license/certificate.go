@@ -0,0 +1,136 @@
+package license
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/danielchatfield/go-jwt"
+)
+
+// Certificate is an intermediate key's public key signed by the root key,
+// licenses signed with the intermediate key carry it so that software only
+// needs to trust the root key. Product keys can then be rotated without
+// shipping a new public key.
+type Certificate struct {
+	KeyID     string
+	PublicKey *rsa.PublicKey
+
+	// Products are those the intermediate key may sign licenses for, empty
+	// allows every product.
+	Products []string
+
+	// The intermediate key may only sign licenses issued between IssuedAt
+	// and ExpiresAt, licenses it signed stay valid after it expires.
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// Encode signs the certificate with the root key and returns the encoded
+// token.
+func (cert *Certificate) Encode(root *rsa.PrivateKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+
+	if err != nil {
+		return "", err
+	}
+
+	t := jwt.NewToken(jwt.RSA)
+
+	t.SetClaim("sub", cert.KeyID)
+	t.SetClaim("_pub", base64.StdEncoding.EncodeToString(der))
+	t.SetClaim("iat", cert.IssuedAt.Unix())
+
+	// not exp, the token is used long after the key stops signing
+	t.SetClaim("_until", cert.ExpiresAt.Unix())
+
+	if len(cert.Products) > 0 {
+		t.SetClaim("_prods", cert.Products)
+	}
+
+	return t.Encode(root)
+}
+
+// ParseCertificate verifies a certificate with the root key.
+func ParseCertificate(token string, root *rsa.PublicKey) (*Certificate, error) {
+	if err := checkSize(token); err != nil {
+		return nil, err
+	}
+
+	tok, err := jwt.ParseToken(token, jwt.RSA, root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c := claimsOf(tok.Claim, certificateClaims)
+
+	if err := c.check(certificateClaims); err != nil {
+		return nil, err
+	}
+
+	der, err := base64.StdEncoding.DecodeString(c.str("_pub"))
+
+	if err != nil {
+		return nil, errors.New("Error extracting certificate public key")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pub, ok := key.(*rsa.PublicKey)
+
+	if !ok {
+		return nil, errors.New("Certificate public key is not an RSA key")
+	}
+
+	// the registry requires the times, so they are set
+	return &Certificate{
+		KeyID:     c.str("sub"),
+		PublicKey: pub,
+		Products:  c.strings("_prods"),
+		IssuedAt:  c.IssuedAt(),
+		ExpiresAt: *c.time("_until"),
+	}, nil
+}
+
+// AllowsLicense reports whether the certified key may sign a license, for a
+// bundle license one for each of the bundle's products too.
+func (cert *Certificate) AllowsLicense(l *License) bool {
+	if !cert.Allows(l.Product, l.IssuedAt) {
+		return false
+	}
+
+	for _, p := range l.Products {
+		if !cert.Allows(p, l.IssuedAt) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Allows reports whether the certified key may sign a license for product
+// issued at the given time.
+func (cert *Certificate) Allows(product string, issuedAt time.Time) bool {
+	if issuedAt.Before(cert.IssuedAt) || !issuedAt.Before(cert.ExpiresAt) {
+		return false
+	}
+
+	if len(cert.Products) == 0 {
+		return true
+	}
+
+	for _, p := range cert.Products {
+		if p == product {
+			return true
+		}
+	}
+
+	return false
+}
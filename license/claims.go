@@ -0,0 +1,359 @@
+package license
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// The types of claims, as JSON types.
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeBoolean = "boolean"
+	TypeObject  = "object"
+	TypeArray   = "array"
+)
+
+// ClaimsVersion is the version of the claims new licenses are encoded with.
+// It goes up whenever a claim is added, so software can tell from a claim's
+// Since whether a license without it predates it.
+const ClaimsVersion = 13
+
+// Claim defines a claim of a payload.
+type Claim struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	// Items is the type of the members of an object or array, empty if
+	// they can be anything. Object members are objects of counts like
+	// _ent.
+	Items    string `json:"items,omitempty"`
+	Required bool   `json:"required,omitempty"`
+
+	// Since is the claims version the claim was added in.
+	Since   int    `json:"since"`
+	Summary string `json:"summary"`
+
+	// limit is the largest value of an integer, or the most members of an
+	// object or array. Integer members are counts, up to maxCount.
+	limit float64
+}
+
+// licenseClaims is the registry of the claims of a license's payload, the
+// same in every format. Claims not in it are ignored.
+var licenseClaims = []Claim{
+	{"jti", TypeString, "", true, 1, "the license ID", 0},
+	{"_prod", TypeString, "", true, 1, "the product", 0},
+	{"iat", TypeInteger, "", false, 1, "when the license was issued, in Unix seconds", maxUnix},
+	{"exp", TypeInteger, "", false, 2, "when the license expires, in Unix seconds, missing if it never does", maxUnix},
+	{"nbf", TypeInteger, "", false, 10, "when the license becomes valid, in Unix seconds", maxUnix},
+	{"_attrs", TypeObject, "", false, 1, "attributes such as email and name, at most 64", maxAttrs},
+	{"test", TypeBoolean, "", false, 3, "true for test licenses issued with a sandbox key", 0},
+	{"_ent", TypeObject, TypeInteger, false, 4, "the entitlements, features and their integer limits, missing unlocks everything", maxEntitlements},
+	{"_maxact", TypeInteger, "", false, 4, "the most activations, activations are checked by the server", maxCount},
+	{"_plan", TypeString, "", false, 6, "the plan", 0},
+	{"_bundle", TypeArray, TypeString, false, 13, "the products a bundle license covers, _prod is the bundle", maxEntitlements},
+	{"_pent", TypeObject, TypeObject, false, 13, "the entitlements of the products of a bundle that don't share _ent, by product", maxEntitlements},
+	{"_seats", TypeInteger, "", false, 11, "the most named users", maxCount},
+	{"_regions", TypeArray, TypeString, false, 8, "the two letter country codes the license may be used in", maxRegions},
+	{"_minver", TypeString, "", false, 9, "the lowest version of the software the license covers", 0},
+	{"_maxver", TypeString, "", false, 9, "the highest version of the software the license covers", 0},
+	{"_wm", TypeString, "", false, 7, "the watermark naming the purchaser", 0},
+	{"_serial", TypeInteger, "", false, 12, "how many times the license was re-signed, a higher one supersedes it", maxCount},
+	{"_cert", TypeString, "", false, 5, "the certificate of the intermediate key that signed the license", 0},
+}
+
+// certificateClaims is the registry of the claims of the certificate in the
+// _cert claim.
+var certificateClaims = []Claim{
+	{"sub", TypeString, "", true, 5, "the ID of the intermediate key", 0},
+	{"_pub", TypeString, "", true, 5, "the intermediate public key, base64 PKIX DER", 0},
+	{"iat", TypeInteger, "", true, 5, "when the key may start signing, in Unix seconds", maxUnix},
+	{"_until", TypeInteger, "", true, 5, "when the key stops signing, in Unix seconds, licenses it signed stay valid", maxUnix},
+	{"_prods", TypeArray, TypeString, false, 5, "the products the key may sign for, missing allows every product", maxEntitlements},
+}
+
+// check returns an error if a value of the claim, nil for a missing one,
+// isn't of its type or is out of range.
+func (cl *Claim) check(v interface{}) error {
+	if v == nil {
+		if cl.Required {
+			return fmt.Errorf("The %v claim is missing", cl.Name)
+		}
+
+		return nil
+	}
+
+	var members []interface{}
+
+	switch cl.Type {
+	case TypeObject:
+		m, ok := v.(map[string]interface{})
+
+		if !ok {
+			return fmt.Errorf("The %v claim is not of type %v", cl.Name, cl.Type)
+		}
+
+		for _, member := range m {
+			members = append(members, member)
+		}
+	case TypeArray:
+		var ok bool
+
+		if members, ok = v.([]interface{}); !ok {
+			return fmt.Errorf("The %v claim is not of type %v", cl.Name, cl.Type)
+		}
+	default:
+		if checkValue(cl.Type, v, cl.limit) {
+			return nil
+		}
+
+		if _, ok := v.(float64); ok && cl.Type == TypeInteger {
+			return fmt.Errorf("The %v claim is out of range", cl.Name)
+		}
+
+		return fmt.Errorf("The %v claim is not of type %v", cl.Name, cl.Type)
+	}
+
+	if float64(len(members)) > cl.limit {
+		return fmt.Errorf("The %v claim has more than %v members", cl.Name, cl.limit)
+	}
+
+	for _, member := range members {
+		if cl.Items != "" && !checkValue(cl.Items, member, maxCount) {
+			return fmt.Errorf("The members of the %v claim must be of type %v", cl.Name, cl.Items)
+		}
+	}
+
+	return nil
+}
+
+// checkValue reports whether a scalar JSON value, or an object of counts as a
+// member, is of a type. Integers must be whole numbers from zero to limit.
+func checkValue(typ string, v interface{}, limit float64) bool {
+	switch typ {
+	case TypeString:
+		_, ok := v.(string)
+		return ok
+	case TypeBoolean:
+		_, ok := v.(bool)
+		return ok
+	case TypeInteger:
+		n, ok := v.(float64)
+		return ok && n >= 0 && n <= limit && n == math.Trunc(n)
+	case TypeObject:
+		m, ok := v.(map[string]interface{})
+
+		if !ok || len(m) > maxEntitlements {
+			return false
+		}
+
+		for _, member := range m {
+			if !checkValue(TypeInteger, member, limit) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// Claims are the claims of a decoded payload, with numbers as float64 as
+// encoding/json decodes them. Their getters return the zero value for a
+// claim that is missing or of the wrong type, so they are checked against
+// the registry when a license is parsed.
+type Claims map[string]interface{}
+
+// claimsOf copies the claims in a registry from a decoded token.
+func claimsOf(claim func(name string) interface{}, registry []Claim) Claims {
+	c := make(Claims, len(registry))
+
+	for _, cl := range registry {
+		if v := claim(cl.Name); v != nil {
+			c[cl.Name] = v
+		}
+	}
+
+	return c
+}
+
+// check returns the first claim of a registry that is missing or invalid.
+func (c Claims) check(registry []Claim) error {
+	for i := range registry {
+		if err := registry[i].check(c[registry[i].Name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c Claims) str(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+func (c Claims) count(name string) int {
+	n, _ := c[name].(float64)
+	return int(n)
+}
+
+// time returns a timestamp claim, nil if it is missing.
+func (c Claims) time(name string) *time.Time {
+	n, ok := c[name].(float64)
+
+	if !ok {
+		return nil
+	}
+
+	t := time.Unix(int64(n), 0)
+	return &t
+}
+
+func (c Claims) strings(name string) []string {
+	var ss []string
+	vs, _ := c[name].([]interface{})
+
+	for _, v := range vs {
+		if s, ok := v.(string); ok {
+			ss = append(ss, s)
+		}
+	}
+
+	return ss
+}
+
+// ID returns the jti claim, the license ID.
+func (c Claims) ID() string {
+	return c.str("jti")
+}
+
+// Product returns the _prod claim.
+func (c Claims) Product() string {
+	return c.str("_prod")
+}
+
+// IssuedAt returns the iat claim, the zero time if it is missing.
+func (c Claims) IssuedAt() time.Time {
+	if t := c.time("iat"); t != nil {
+		return *t
+	}
+
+	return time.Time{}
+}
+
+// ExpiresAt returns the exp claim, nil for licenses that never expire.
+func (c Claims) ExpiresAt() *time.Time {
+	return c.time("exp")
+}
+
+// NotBefore returns the nbf claim, nil for licenses valid once issued.
+func (c Claims) NotBefore() *time.Time {
+	return c.time("nbf")
+}
+
+// Attrs returns the _attrs claim, never nil.
+func (c Claims) Attrs() map[string]interface{} {
+	if attrs, ok := c["_attrs"].(map[string]interface{}); ok {
+		return attrs
+	}
+
+	return make(map[string]interface{})
+}
+
+// Test returns the test claim.
+func (c Claims) Test() bool {
+	test, _ := c["test"].(bool)
+	return test
+}
+
+// Entitlements returns the _ent claim, nil if the license unlocks
+// everything.
+func (c Claims) Entitlements() map[string]int {
+	ent, ok := c["_ent"].(map[string]interface{})
+
+	if !ok {
+		return nil
+	}
+
+	m := make(map[string]int, len(ent))
+
+	for feature, limit := range ent {
+		n, _ := limit.(float64)
+		m[feature] = int(n)
+	}
+
+	return m
+}
+
+// MaxActivations returns the _maxact claim.
+func (c Claims) MaxActivations() int {
+	return c.count("_maxact")
+}
+
+// Plan returns the _plan claim.
+func (c Claims) Plan() string {
+	return c.str("_plan")
+}
+
+// Products returns the _bundle claim.
+func (c Claims) Products() []string {
+	return c.strings("_bundle")
+}
+
+// ProductEntitlements returns the _pent claim, nil if no product of the
+// bundle has its own entitlements.
+func (c Claims) ProductEntitlements() map[string]map[string]int {
+	pent, ok := c["_pent"].(map[string]interface{})
+
+	if !ok {
+		return nil
+	}
+
+	m := make(map[string]map[string]int, len(pent))
+
+	for product, ent := range pent {
+		m[product] = Claims{"_ent": ent}.Entitlements()
+	}
+
+	return m
+}
+
+// Seats returns the _seats claim.
+func (c Claims) Seats() int {
+	return c.count("_seats")
+}
+
+// Regions returns the _regions claim.
+func (c Claims) Regions() []string {
+	return c.strings("_regions")
+}
+
+// MinVersion returns the _minver claim.
+func (c Claims) MinVersion() string {
+	return c.str("_minver")
+}
+
+// MaxVersion returns the _maxver claim.
+func (c Claims) MaxVersion() string {
+	return c.str("_maxver")
+}
+
+// Watermark returns the _wm claim.
+func (c Claims) Watermark() string {
+	return c.str("_wm")
+}
+
+// Serial returns the _serial claim.
+func (c Claims) Serial() int {
+	return c.count("_serial")
+}
+
+// Certificate returns the _cert claim, the encoded certificate of the
+// intermediate key that signed the license.
+func (c Claims) Certificate() string {
+	return c.str("_cert")
+}
@@ -0,0 +1,104 @@
+package license
+
+//go:generate go run ../cmd/licensing-clients -out ../clients
+
+// Description is a machine-readable description of how licenses are
+// encoded, for generating and testing clients in other languages. It is
+// built from the same constants the encoders use, so it can't drift from
+// them.
+type Description struct {
+	Formats []FormatDescription `json:"formats"`
+
+	// Claims are the registry of a license's payload, the same in every
+	// format, and ClaimsVersion the version new licenses are encoded with.
+	Claims        []Claim `json:"claims"`
+	ClaimsVersion int     `json:"claimsVersion"`
+
+	// CertificateClaims are those of the certificate in the _cert claim,
+	// a JWT signed by the root key.
+	CertificateClaims []Claim `json:"certificateClaims"`
+
+	// MaxTokenSize is the most bytes of a license that are decoded.
+	MaxTokenSize int `json:"maxTokenSize"`
+}
+
+// FormatDescription describes one encoding of a license.
+type FormatDescription struct {
+	Name    string `json:"name"`
+	Summary string `json:"summary"`
+
+	// Detect is how a token in the format is told apart from the others.
+	Detect string `json:"detect"`
+
+	// Algorithm is the JWA name of the signature algorithm and Signed what
+	// the signature is over.
+	Algorithm string `json:"algorithm"`
+	Signed    string `json:"signed"`
+
+	// Version is the value of the version byte, for binary formats.
+	Version int `json:"version,omitempty"`
+
+	// Layout is the parts of a token in order.
+	Layout []FieldDescription `json:"layout"`
+}
+
+// FieldDescription is a part of an encoded license.
+type FieldDescription struct {
+	Name string `json:"name"`
+
+	// Size is the part's length in bytes, zero for a variable length.
+	Size     int    `json:"size,omitempty"`
+	Encoding string `json:"encoding"`
+	Summary  string `json:"summary"`
+}
+
+// Describe returns the description of the formats licenses are encoded in.
+func Describe() *Description {
+	return &Description{
+		Formats: []FormatDescription{
+			{
+				Name:      FormatV1,
+				Summary:   "A compact JWS (RFC 7515), a JWT whose payload is the claims",
+				Detect:    "three base64url parts separated by dots",
+				Algorithm: "RS256",
+				Signed:    "the ASCII header and payload parts joined by a dot",
+				Layout: []FieldDescription{
+					{Name: "header", Encoding: "base64url JSON", Summary: `{"alg": "RS256", "typ": "JWT"}`},
+					{Name: "payload", Encoding: "base64url JSON", Summary: "the claims"},
+					{Name: "signature", Encoding: "base64url", Summary: "RSASSA-PKCS1-v1_5 with SHA-256"},
+				},
+			},
+			{
+				Name:      FormatV1 + "-envelope",
+				Summary:   "A v1 license signed by more than one key while keys are rotated, in the JWS JSON serialization (RFC 7515)",
+				Detect:    "starts with {",
+				Algorithm: "RS256",
+				Signed:    "each signature is over its protected header and the payload like a v1 token, any one of them that verifies is enough",
+				Layout: []FieldDescription{
+					{Name: "payload", Encoding: "base64url JSON", Summary: "the claims, shared by the signatures"},
+					{Name: "signatures[].protected", Encoding: "base64url JSON", Summary: "the header of a v1 token"},
+					{Name: "signatures[].header.kid", Encoding: "string", Summary: "the ID of the key that made the signature"},
+					{Name: "signatures[].signature", Encoding: "base64url", Summary: "the signature of a v1 token"},
+				},
+			},
+			{
+				Name:      FormatV2,
+				Summary:   "A single base64url string without a JWT header, shorter to paste",
+				Detect:    "no dots or {, and the first decoded byte is the version",
+				Algorithm: "RS256",
+				Signed:    "the decoded bytes before the signature",
+				Version:   v2Version,
+				Layout: []FieldDescription{
+					{Name: "version", Size: 1, Encoding: "byte", Summary: "the format version"},
+					{Name: "length", Size: v2HeaderSize - 1, Encoding: "big-endian unsigned integer", Summary: "the length of the claims in bytes"},
+					{Name: "claims", Encoding: "JSON", Summary: "the claims, length bytes"},
+					{Name: "signature", Encoding: "bytes", Summary: "RSASSA-PKCS1-v1_5 with SHA-256, the rest of the token"},
+				},
+			},
+		},
+		Claims:            append([]Claim(nil), licenseClaims...),
+		ClaimsVersion:     ClaimsVersion,
+		CertificateClaims: append([]Claim(nil), certificateClaims...),
+		MaxTokenSize:      MaxTokenSize,
+	}
+}
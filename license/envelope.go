@@ -0,0 +1,129 @@
+package license
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Signer is a key that signs licenses and the ID it is known by.
+type Signer struct {
+	KeyID string
+	Key   *rsa.PrivateKey
+}
+
+// envelope is a license signed by more than one key in the JWS JSON
+// serialization (RFC 7515), each signature with the protected header makes
+// an ordinary token.
+type envelope struct {
+	Payload    string      `json:"payload"`
+	Signatures []signature `json:"signatures"`
+}
+
+type signature struct {
+	Protected string          `json:"protected"`
+	Header    signatureHeader `json:"header"`
+	Signature string          `json:"signature"`
+}
+
+type signatureHeader struct {
+	KeyID string `json:"kid"`
+}
+
+// EncodeMulti signs the license with each of the keys and returns the
+// envelope of the signatures. It is used while rotating keys so that software
+// that only knows one of the keys can verify the license.
+func (l *License) EncodeMulti(signers ...Signer) (string, error) {
+	var env envelope
+
+	for _, s := range signers {
+		token, err := l.Encode(s.Key)
+
+		if err != nil {
+			return "", err
+		}
+
+		parts := strings.Split(token, ".")
+
+		if env.Payload == "" {
+			env.Payload = parts[1]
+		} else if parts[1] != env.Payload {
+			return "", errors.New("Signatures of the license have different payloads")
+		}
+
+		env.Signatures = append(env.Signatures, signature{parts[0], signatureHeader{s.KeyID}, parts[2]})
+	}
+
+	if len(env.Signatures) == 0 {
+		return "", errors.New("No keys to sign the license with")
+	}
+
+	b, err := json.Marshal(env)
+	return string(b), err
+}
+
+// isEnvelope reports whether a token is an envelope rather than a compact
+// token.
+func isEnvelope(token string) bool {
+	return strings.HasPrefix(strings.TrimSpace(token), "{")
+}
+
+// compacts returns the ordinary tokens in an envelope along with the IDs of
+// the keys that signed them, a compact token is returned as it is.
+func compacts(token string) ([]string, []string, error) {
+	if err := checkSize(token); err != nil {
+		return nil, nil, err
+	}
+
+	if !isEnvelope(token) {
+		return []string{token}, []string{""}, nil
+	}
+
+	var env envelope
+
+	if err := json.Unmarshal([]byte(token), &env); err != nil {
+		return nil, nil, err
+	}
+
+	if len(env.Signatures) == 0 {
+		return nil, nil, errors.New("Envelope has no signatures")
+	}
+
+	if len(env.Signatures) > maxSignatures {
+		return nil, nil, errors.New("Envelope has too many signatures")
+	}
+
+	tokens := make([]string, len(env.Signatures))
+	kids := make([]string, len(env.Signatures))
+
+	for i, s := range env.Signatures {
+		tokens[i] = s.Protected + "." + env.Payload + "." + s.Signature
+		kids[i] = s.Header.KeyID
+	}
+
+	return tokens, kids, nil
+}
+
+// Compact returns the ordinary token in an envelope signed by the key with
+// the given ID, for software that only understands compact tokens. A compact
+// token is returned as it is.
+func Compact(token, kid string) (string, error) {
+	if !isEnvelope(token) {
+		return token, nil
+	}
+
+	tokens, kids, err := compacts(token)
+
+	if err != nil {
+		return "", err
+	}
+
+	for i := range tokens {
+		if kids[i] == kid {
+			return tokens[i], nil
+		}
+	}
+
+	return "", errors.New("License is not signed by key " + kid)
+}
@@ -0,0 +1,57 @@
+package license
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// EncodeFile returns the contents of a .lic file for the license, which is
+// its encoded token after comment lines describing it for whoever opens the
+// file. The comments aren't signed, the same details are in the token.
+func (l *License) EncodeFile(token string) []byte {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "# License for %v\n", l.Product)
+	fmt.Fprintf(&buf, "# ID: %v\n", l.ID)
+
+	if l.Watermark != "" {
+		fmt.Fprintf(&buf, "# Licensed to: %v\n", l.Watermark)
+	}
+
+	if l.ExpiresAt != nil {
+		fmt.Fprintf(&buf, "# Expires: %v\n", l.ExpiresAt.UTC().Format("2006-01-02"))
+	} else {
+		fmt.Fprintf(&buf, "# Expires: never\n")
+	}
+
+	buf.WriteString(token)
+	buf.WriteString("\n")
+
+	return buf.Bytes()
+}
+
+// ReadFile returns the token in the contents of a .lic file, skipping blank
+// lines and comments. A bare token is returned as it is.
+func ReadFile(data []byte) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	// envelopes of several signatures are longer than the default limit
+	scanner.Buffer(nil, 1<<20)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return line, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", errors.New("No license in the file")
+}
@@ -0,0 +1,57 @@
+package license_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/licensingtest"
+)
+
+// FuzzParse checks that parsing arbitrary strings never panics, and that
+// every token Parse accepts can also be peeked at.
+func FuzzParse(f *testing.F) {
+	key := licensingtest.PluginKey.PrivateKey()
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	lic := license.New("domain_changer")
+	lic.Attrs = map[string]interface{}{"email": "jane@example.com", "name": "Jane"}
+	lic.ExpiresAt = &expires
+	lic.Entitlements = map[string]int{"domains": 5}
+	lic.Products = []string{"domain_changer", "plugin"}
+
+	for _, encode := range []func() (string, error){
+		func() (string, error) { return lic.Encode(key) },
+		func() (string, error) { return lic.EncodeV2(key) },
+		func() (string, error) {
+			return lic.EncodeMulti(license.Signer{KeyID: "plugin", Key: key}, license.Signer{KeyID: "other", Key: licensingtest.OtherKey.PrivateKey()})
+		},
+	} {
+		token, err := encode()
+
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		f.Add(token)
+	}
+
+	for _, s := range []string{"", "SUCCESS", "{}", `{"payload": "", "signatures": []}`, "a.b.c", "..", "eyJhbGciOiJub25lIn0.e30.", "\x00\xff"} {
+		f.Add(s)
+	}
+
+	public := licensingtest.PluginKey.PublicKey()
+
+	f.Fuzz(func(t *testing.T, token string) {
+		license.ParseLegacy(token, public)
+		license.Peek(token)
+
+		if _, err := license.Parse(token, public); err != nil {
+			return
+		}
+
+		if _, err := license.Peek(token); err != nil {
+			t.Errorf("Parse accepted %q but Peek failed: %v", token, err)
+		}
+	})
+}
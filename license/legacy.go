@@ -0,0 +1,95 @@
+package license
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/danielchatfield/go-jwt"
+)
+
+// Licenses issued by the previous licensing system, which are still in use,
+// are RS256 JWTs with a different payload:
+//
+//	{
+//	  "license_key": "<ID>",
+//	  "product": "domain_changer",
+//	  "customer": {"email": "jane@example.com", "name": "Jane Doe"},
+//	  "order_id": "<charge ID>",
+//	  "created": <unix time>,
+//	  "expires": <unix time, 0 or missing for lifetime licenses>,
+//	  "sites": <activation limit, 0 or missing for unlimited>
+//	}
+//
+// They are signed with the legacy key, which only ever signed this format.
+
+// legacyClaims are the claims of the legacy payload that Peek needs.
+type legacyClaims struct {
+	LicenseKey string `json:"license_key"`
+	Product    string `json:"product"`
+}
+
+// ParseLegacy verifies a license in the legacy format with the legacy key
+// and maps it to a License. The customer and order become the email, name
+// and chargeId attributes and sites the activation limit. Legacy licenses
+// have no entitlements, so they unlock everything.
+func ParseLegacy(token string, key interface{}) (*License, error) {
+	token = strings.TrimSpace(token)
+
+	if err := checkToken(token); err != nil {
+		return nil, err
+	}
+
+	tok, err := jwt.ParseToken(token, jwt.RSA, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	l := &License{Legacy: true, Attrs: make(map[string]interface{})}
+	var ok bool
+
+	if l.ID, ok = tok.Claim("license_key").(string); !ok || l.ID == "" {
+		return nil, errors.New("Error extracting legacy license key")
+	}
+
+	if l.Product, ok = tok.Claim("product").(string); !ok || l.Product == "" {
+		return nil, errors.New("Error extracting legacy license product")
+	}
+
+	if l.IssuedAt, _, err = unixClaim(tok.Claim("created")); err != nil {
+		return nil, err
+	}
+
+	// the previous system wrote 0 for lifetime licenses and unlimited sites
+	if expires, ok := tok.Claim("expires").(float64); ok && expires > 0 {
+		if expires > maxUnix {
+			return nil, errors.New("License timestamp out of range")
+		}
+
+		expiresAt := time.Unix(int64(expires), 0)
+		l.ExpiresAt = &expiresAt
+	}
+
+	if sites, ok := tok.Claim("sites").(float64); ok && sites > 0 {
+		if sites > maxCount {
+			return nil, errors.New("License count out of range")
+		}
+
+		l.MaxActivations = int(sites)
+	}
+
+	if customer, ok := tok.Claim("customer").(map[string]interface{}); ok {
+		for _, k := range []string{"email", "name"} {
+			if v, ok := customer[k].(string); ok && v != "" {
+				l.Attrs[k] = v
+			}
+		}
+	}
+
+	if order, ok := tok.Claim("order_id").(string); ok && order != "" {
+		l.Attrs["chargeId"] = order
+	}
+
+	return l, nil
+}
@@ -1,7 +1,10 @@
 package license
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/danielchatfield/go-jwt"
@@ -9,20 +12,266 @@ import (
 )
 
 // License represents a license entity
-type License interface {
-	Encode(key interface{}) (string, error)
-}
-
-type license struct {
+type License struct {
 	ID       string                 `json:"id"`
 	Product  string                 `json:"product"`
 	IssuedAt time.Time              `json:"issuedAt"`
 	Attrs    map[string]interface{} `json:"attrs"`
+
+	// ExpiresAt is nil for licenses that never expire.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+
+	// Test is set on licenses issued in sandbox mode, software must reject
+	// them unless it is a test build.
+	Test bool `json:"test,omitempty"`
+
+	// Entitlements are the features the license unlocks, a positive value
+	// is a limit (e.g. "domains": 10) and zero means unlimited. A nil map
+	// means the license unlocks everything, as it did before entitlements.
+	Entitlements map[string]int `json:"entitlements,omitempty"`
+
+	// MaxActivations limits how many installs can be activated, zero is
+	// unlimited.
+	MaxActivations int `json:"maxActivations,omitempty"`
+
+	// Plan is the name of the product plan the entitlements come from, if
+	// any.
+	Plan string `json:"plan,omitempty"`
+
+	// Products are the products a bundle license covers, Product is then
+	// the bundle (see Covers). Empty for a license of a single product.
+	Products []string `json:"products,omitempty"`
+
+	// ProductEntitlements are the entitlements of the products of a bundle
+	// that don't share the license's (see EntitlementsFor), never nil for
+	// a product.
+	ProductEntitlements map[string]map[string]int `json:"productEntitlements,omitempty"`
+
+	// Seats makes the license a named-user license for this many users,
+	// whose emails are kept by the server. Zero is not named-user.
+	Seats int `json:"seats,omitempty"`
+
+	// Regions are the countries (ISO 3166-1 alpha-2 codes, e.g. "DE") the
+	// license may be used in, for products with regional distribution
+	// deals. Empty allows every country.
+	Regions []string `json:"regions,omitempty"`
+
+	// MinVersion and MaxVersion limit the versions of the product the
+	// license covers, e.g. a lifetime license for 2.x has a MaxVersion of
+	// "2" (see AllowsVersion). Empty is no limit.
+	MinVersion string `json:"minVersion,omitempty"`
+	MaxVersion string `json:"maxVersion,omitempty"`
+
+	// NotBefore is when the license becomes valid, e.g. the release date of
+	// a product sold as a pre-order (see ValidAt). Nil is valid once issued.
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+
+	// Serial counts the times the license was re-signed to replace its
+	// previous strings, which the server stops accepting once it stores a
+	// higher serial. Zero until it is first replaced.
+	Serial int `json:"serial,omitempty"`
+
+	// Certificate is the encoded certificate of the intermediate key the
+	// license is signed with, empty if it is signed with a trusted key
+	// directly (see Certificate).
+	Certificate string `json:"certificate,omitempty"`
+
+	// RevokedAt is set once the license is revoked. It is stored state
+	// rather than part of the encoded license, the revocation list is what
+	// the software checks.
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+
+	// RevokeAt is when a scheduled revocation takes effect, the license is
+	// valid until then. It is stored like RevokedAt.
+	RevokeAt *time.Time `json:"revokeAt,omitempty"`
+
+	// SharingDetectedAt is when the license was last seen checking in from
+	// more installs or addresses than its product allows, and SuspendedAt
+	// when it was suspended for it. Both are stored like RevokedAt.
+	SharingDetectedAt *time.Time `json:"sharingDetectedAt,omitempty"`
+	SuspendedAt       *time.Time `json:"suspendedAt,omitempty"`
+
+	// Watermark names the purchaser (or is a hash of their email) to
+	// discourage sharing, it is derived from Attrs when signing rather than
+	// stored.
+	Watermark string `json:"watermark,omitempty"`
+
+	// Reseller is the ID of the reseller that issued the license, if any.
+	// Like RevokedAt it is only stored.
+	Reseller string `json:"reseller,omitempty"`
+
+	// Campaign is the promotion or coupon code the license was issued under,
+	// if any, for attributing sales and churn to it. Like RevokedAt it is
+	// only stored.
+	Campaign string `json:"campaign,omitempty"`
+
+	// Invoice is the number of the invoice the license was sold on and
+	// ReceiptURL where its receipt is, for support to find the sale. Like
+	// RevokedAt they are only stored.
+	Invoice    string `json:"invoice,omitempty"`
+	ReceiptURL string `json:"receiptUrl,omitempty"`
+
+	// CustomerID is the storefront's or CRM's ID of the customer the
+	// license belongs to, Metadata is free-form bookkeeping, and Notes and
+	// Tags are support's. Unlike Attrs none of them are signed, they are
+	// only stored so they can be changed without reissuing the license.
+	CustomerID string            `json:"customerId,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Notes      string            `json:"notes,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`
+
+	// MembersTokenHash is the hex SHA-256 of the token the admin of an
+	// organization manages the members of its license with, empty until one
+	// is issued. The license itself is shared with the members, so it isn't
+	// enough. Like RevokedAt it is only stored, and it is never sent out.
+	MembersTokenHash string `json:"-"`
+
+	// Extensions are the times the license's expiry was moved later by
+	// support, oldest first, as opposed to it being renewed with a new
+	// license. Like RevokedAt they are only stored.
+	Extensions []Extension `json:"extensions,omitempty"`
+
+	// KeyID is the key the license is signed with, set when it is signed or
+	// verified. Like RevokedAt it is only stored, and it is empty for
+	// licenses stored before it was recorded.
+	KeyID string `json:"keyId,omitempty"`
+
+	// Legacy is set on licenses decoded from the previous system's format
+	// (see ParseLegacy), Encode always uses the current format.
+	Legacy bool `json:"legacy,omitempty"`
+}
+
+// Extension is a change of a license's expiry from From to To, made by Actor
+// for Reason.
+type Extension struct {
+	At     time.Time `json:"at"`
+	Actor  string    `json:"actor"`
+	Reason string    `json:"reason"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+}
+
+// Statuses of a stored license.
+const (
+	StatusActive  = "active"
+	StatusRevoked = "revoked"
+	StatusExpired = "expired"
+)
+
+// Status returns the status of the license at the given time.
+func (l *License) Status(now time.Time) string {
+	switch {
+	case l.RevokedAt != nil:
+		return StatusRevoked
+	case l.ExpiresAt != nil && !now.Before(*l.ExpiresAt):
+		return StatusExpired
+	}
+
+	return StatusActive
+}
+
+// ValidAt reports whether the license has become valid by a time, which
+// says nothing about whether it has expired or been revoked since.
+func (l *License) ValidAt(t time.Time) bool {
+	return l.NotBefore == nil || !t.Before(*l.NotBefore)
+}
+
+// Email returns the customer's email address from the attributes, or an
+// empty string if there isn't one.
+func (l *License) Email() string {
+	email, _ := l.Attrs["email"].(string)
+	return email
+}
+
+// ChargeID returns the chargeId attribute, the payment provider's ID of the
+// payment for the license.
+func (l *License) ChargeID() string {
+	id, _ := l.Attrs["chargeId"].(string)
+	return id
+}
+
+// OrderID returns the orderId attribute, the storefront's ID of the order the
+// license was issued for.
+func (l *License) OrderID() string {
+	id, _ := l.Attrs["orderId"].(string)
+	return id
+}
+
+// AllowsRegion reports whether the license may be used in a country, given
+// as an ISO 3166-1 alpha-2 code.
+func (l *License) AllowsRegion(country string) bool {
+	if len(l.Regions) == 0 {
+		return true
+	}
+
+	for _, r := range l.Regions {
+		if strings.EqualFold(r, country) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Covers reports whether the license is for a product, either its own or one
+// of its bundle's.
+func (l *License) Covers(product string) bool {
+	if product == l.Product {
+		return true
+	}
+
+	for _, p := range l.Products {
+		if p == product {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EntitlementsFor returns the entitlements of one of the products the license
+// covers, like Entitlements nil unlocks everything. Products of a bundle
+// without their own have the license's, and products it doesn't cover have
+// none.
+func (l *License) EntitlementsFor(product string) map[string]int {
+	if !l.Covers(product) {
+		return map[string]int{}
+	}
+
+	if ent, ok := l.ProductEntitlements[product]; ok && product != l.Product {
+		return ent
+	}
+
+	return l.Entitlements
+}
+
+// HasTag reports whether the license is tagged with a tag.
+func (l *License) HasTag(tag string) bool {
+	for _, t := range l.Tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Locale returns the customer's locale attribute, such as "de" or "pt-BR",
+// which emails to them are written in.
+func (l *License) Locale() string {
+	locale, _ := l.Attrs["locale"].(string)
+	return locale
+}
+
+// Name returns the customer name attribute.
+func (l *License) Name() string {
+	name, _ := l.Attrs["name"].(string)
+	return name
 }
 
 // New creates a new License. Takes the product that the license is for.
-func New(product string) License {
-	return &license{
+func New(product string) *License {
+	return &License{
 		ID:       uniuri.New(),
 		Product:  product,
 		IssuedAt: time.Now(),
@@ -30,47 +279,218 @@ func New(product string) License {
 	}
 }
 
-func (l *license) Encode(key interface{}) (encoded string, err error) {
+// Encode signs the license with key and returns the encoded token.
+func (l *License) Encode(key interface{}) (encoded string, err error) {
 	// This is essentially a wrapper around jwt.Token.Encode()
 
 	t := jwt.NewToken(jwt.RSA)
 
-	t.SetClaim("jti", l.ID)
-	t.SetClaim("iat", l.IssuedAt.Unix())
-	t.SetClaim("_prod", l.Product)
-	t.SetClaim("_attrs", l.Attrs)
+	for name, v := range l.Claims() {
+		t.SetClaim(name, v)
+	}
 
 	return t.Encode(key)
 }
 
-func Parse(token string, key interface{}) (*license, error) {
+// Claims returns the claims the license is encoded as, in every format. They
+// are the ones in the registry (see Claim), which Parse checks them against.
+func (l *License) Claims() map[string]interface{} {
+	claims := map[string]interface{}{
+		"jti":    l.ID,
+		"iat":    l.IssuedAt.Unix(),
+		"_prod":  l.Product,
+		"_attrs": l.Attrs,
+	}
+
+	if l.ExpiresAt != nil {
+		claims["exp"] = l.ExpiresAt.Unix()
+	}
+
+	if l.NotBefore != nil {
+		claims["nbf"] = l.NotBefore.Unix()
+	}
+
+	if l.Test {
+		claims["test"] = true
+	}
+
+	if l.Entitlements != nil {
+		claims["_ent"] = l.Entitlements
+	}
+
+	if l.MaxActivations > 0 {
+		claims["_maxact"] = l.MaxActivations
+	}
+
+	if l.Plan != "" {
+		claims["_plan"] = l.Plan
+	}
+
+	if len(l.Products) > 0 {
+		claims["_bundle"] = l.Products
+	}
+
+	if len(l.ProductEntitlements) > 0 {
+		claims["_pent"] = l.ProductEntitlements
+	}
+
+	if l.Seats > 0 {
+		claims["_seats"] = l.Seats
+	}
+
+	if len(l.Regions) > 0 {
+		claims["_regions"] = l.Regions
+	}
+
+	if l.MinVersion != "" {
+		claims["_minver"] = l.MinVersion
+	}
+
+	if l.MaxVersion != "" {
+		claims["_maxver"] = l.MaxVersion
+	}
+
+	if l.Watermark != "" {
+		claims["_wm"] = l.Watermark
+	}
+
+	if l.Serial > 0 {
+		claims["_serial"] = l.Serial
+	}
+
+	if l.Certificate != "" {
+		claims["_cert"] = l.Certificate
+	}
+
+	return claims
+}
+
+// Parse verifies a token with key and returns the license it encodes. The
+// token may be an envelope (see EncodeMulti) as long as one of its signatures
+// is by key.
+func Parse(token string, key interface{}) (*License, error) {
+	tokens, _, err := compacts(token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tokens {
+		var l *License
+
+		if l, err = parse(t, key); err == nil {
+			return l, nil
+		}
+	}
+
+	return nil, err
+}
+
+func parse(token string, key interface{}) (*License, error) {
+	if err := checkToken(token); err != nil {
+		return nil, err
+	}
+
+	if Format(token) == FormatV2 {
+		return parseV2(token, key)
+	}
+
 	tok, err := jwt.ParseToken(token, jwt.RSA, key)
 
 	if err != nil {
 		return nil, err
 	}
 
-	// tok decoded - now lets construct the license
+	return fromClaims(tok.Claim)
+}
+
+// fromClaims constructs a license from the claims of a verified token, once
+// they are checked against the registry.
+func fromClaims(claim func(name string) interface{}) (*License, error) {
+	c := claimsOf(claim, licenseClaims)
+
+	if err := c.check(licenseClaims); err != nil {
+		return nil, err
+	}
+
+	return &License{
+		ID:                  c.ID(),
+		Product:             c.Product(),
+		IssuedAt:            c.IssuedAt(),
+		Attrs:               c.Attrs(),
+		ExpiresAt:           c.ExpiresAt(),
+		NotBefore:           c.NotBefore(),
+		Test:                c.Test(),
+		Entitlements:        c.Entitlements(),
+		MaxActivations:      c.MaxActivations(),
+		Plan:                c.Plan(),
+		Products:            c.Products(),
+		ProductEntitlements: c.ProductEntitlements(),
+		Seats:               c.Seats(),
+		Regions:             c.Regions(),
+		MinVersion:          c.MinVersion(),
+		MaxVersion:          c.MaxVersion(),
+		Watermark:           c.Watermark(),
+		Serial:              c.Serial(),
+		Certificate:         c.Certificate(),
+	}, nil
+}
+
+// Unverified holds the claims of a token needed to decide how to verify it,
+// such as its Product, Test and Certificate.
+type Unverified struct {
+	Claims
+
+	// Legacy is set for tokens in the legacy format (see ParseLegacy),
+	// Product then returns its product claim.
+	Legacy bool
+}
+
+// Peek reads some claims from a token WITHOUT verifying it, it is only
+// intended for choosing the key to verify the token with.
+func Peek(token string) (*Unverified, error) {
+	// the signatures of an envelope share the payload
+	tokens, _, err := compacts(token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkToken(tokens[0]); err != nil {
+		return nil, err
+	}
+
+	var payload []byte
 
-	l := &license{}
-	var ok bool
+	if Format(tokens[0]) == FormatV2 {
+		payload, _, _ = decodeV2(tokens[0])
+	} else {
+		parts := strings.Split(tokens[0], ".")
 
-	l.ID, ok = tok.Claim("jti").(string)
+		if len(parts) != 3 {
+			return nil, errors.New("Malformed token")
+		}
 
-	if !ok {
-		return nil, errors.New("Error extracting license ID")
+		if payload, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "=")); err != nil {
+			return nil, err
+		}
 	}
 
-	l.Product, ok = tok.Claim("_prod").(string)
+	var u Unverified
 
-	if !ok {
-		return nil, errors.New("Error extracting license product")
+	if err := json.Unmarshal(payload, &u.Claims); err != nil {
+		return nil, err
 	}
 
-	// these fields are not strictly required so we don't handle errors
-	timestamp := int64(tok.Claim("iat").(float64))
-	l.IssuedAt = time.Unix(timestamp, 0)
-	l.Attrs = tok.Claim("_attrs").(map[string]interface{})
+	if u.Product() == "" {
+		var legacy legacyClaims
+
+		if err := json.Unmarshal(payload, &legacy); err == nil && legacy.LicenseKey != "" && legacy.Product != "" {
+			return &Unverified{Claims{"_prod": legacy.Product}, true}, nil
+		}
+
+		return nil, errors.New("Error extracting license product")
+	}
 
-	return l, nil
+	return &u, nil
 }
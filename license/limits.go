@@ -0,0 +1,93 @@
+package license
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// MaxTokenSize is the most bytes of an encoded license that are decoded,
+// envelopes included. Licenses are a few kilobytes, so anything this big was
+// crafted to slow down whoever decodes it.
+const MaxTokenSize = 32 << 10
+
+// Limits on what a license may hold, which are far above what is issued.
+const (
+	maxSignatures   = 4   // in an envelope
+	maxClaims       = 64  // top-level claims of a payload
+	maxAttrs        = 64  // attributes
+	maxEntitlements = 256 // entitlements
+	maxRegions      = 250 // regions, there are fewer countries
+	maxCount        = math.MaxInt32
+
+	// maxUnix is the last second of the year 9999, timestamps after it
+	// don't round-trip.
+	maxUnix = 253402300799
+)
+
+var errTooLarge = fmt.Errorf("The license is larger than %v bytes", MaxTokenSize)
+
+// checkSize rejects tokens too large to decode.
+func checkSize(token string) error {
+	if len(token) > MaxTokenSize {
+		return errTooLarge
+	}
+
+	return nil
+}
+
+// unixClaim converts a timestamp claim, ok is false if it isn't a number and
+// an error is returned if it is out of range.
+func unixClaim(v interface{}) (t time.Time, ok bool, err error) {
+	n, ok := v.(float64)
+
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	if n < 0 || n > maxUnix || n != math.Trunc(n) {
+		return time.Time{}, true, errors.New("License timestamp out of range")
+	}
+
+	return time.Unix(int64(n), 0), true, nil
+}
+
+// checkToken rejects a compact or v2 token that is too large or has too
+// many claims before it is verified.
+func checkToken(token string) error {
+	if err := checkSize(token); err != nil {
+		return err
+	}
+
+	var payload []byte
+
+	if claims, _, err := decodeV2(token); err == nil {
+		payload = claims
+	} else {
+		parts := strings.Split(token, ".")
+
+		if len(parts) != 3 {
+			return errors.New("Malformed token")
+		}
+
+		if payload, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "=")); err != nil {
+			return err
+		}
+	}
+
+	var claims map[string]json.RawMessage
+
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return err
+	}
+
+	if len(claims) > maxClaims {
+		return errors.New("The license has too many claims")
+	}
+
+	return nil
+}
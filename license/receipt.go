@@ -0,0 +1,115 @@
+package license
+
+import (
+	"crypto/rsa"
+	"errors"
+	"time"
+
+	"github.com/danielchatfield/go-jwt"
+)
+
+// Receipt is the signed result of validating a license, for software that
+// validates online now and then and has to trust its own clock in between.
+// Like an OfflineActivation it is signed with the license's key and has no
+// _prod claim, so it is never mistaken for a license.
+//
+// Sequence is a counter kept by the server for each license that never
+// decreases, so a receipt with a lower sequence than one already seen is an
+// old one being replayed. IssuedAt is the server's time, so a clock that is
+// behind the newest receipt has been rolled back (see RolledBack).
+type Receipt struct {
+	LicenseID string
+	Status    string
+	Valid     bool
+	Sequence  int
+	IssuedAt  time.Time
+
+	// ExpiresAt is the license's expiry, nil if it never expires.
+	ExpiresAt *time.Time
+
+	// RevalidateAfter is when the server wants the license validated
+	// again, nil if it leaves that to the software. Until then the
+	// software may rely on the receipt instead of validating online.
+	RevalidateAfter *time.Time
+
+	// Certificate is the certificate of the signing key if it is an
+	// intermediate (see License.Certificate).
+	Certificate string
+}
+
+// Encode signs the receipt with key and returns the encoded token.
+func (r *Receipt) Encode(key *rsa.PrivateKey) (string, error) {
+	t := jwt.NewToken(jwt.RSA)
+
+	t.SetClaim("sub", r.LicenseID)
+	t.SetClaim("_status", r.Status)
+	t.SetClaim("_valid", r.Valid)
+	t.SetClaim("_seq", r.Sequence)
+	t.SetClaim("iat", r.IssuedAt.Unix())
+
+	if r.ExpiresAt != nil {
+		t.SetClaim("exp", r.ExpiresAt.Unix())
+	}
+
+	if r.RevalidateAfter != nil {
+		t.SetClaim("_reval", r.RevalidateAfter.Unix())
+	}
+
+	if r.Certificate != "" {
+		t.SetClaim("_cert", r.Certificate)
+	}
+
+	return t.Encode(key)
+}
+
+// ParseReceipt verifies a receipt with key. Callers must check that the
+// license ID is that of their license.
+func ParseReceipt(token string, key *rsa.PublicKey) (*Receipt, error) {
+	tok, err := jwt.ParseToken(token, jwt.RSA, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Receipt{}
+	var ok bool
+
+	if r.LicenseID, ok = tok.Claim("sub").(string); !ok {
+		return nil, errors.New("Error extracting receipt license ID")
+	}
+
+	seq, ok := tok.Claim("_seq").(float64)
+
+	if !ok {
+		return nil, errors.New("Error extracting receipt sequence")
+	}
+
+	r.Sequence = int(seq)
+	r.Status, _ = tok.Claim("_status").(string)
+	r.Valid, _ = tok.Claim("_valid").(bool)
+
+	iat, _ := tok.Claim("iat").(float64)
+	r.IssuedAt = time.Unix(int64(iat), 0)
+
+	if exp, ok := tok.Claim("exp").(float64); ok {
+		expiresAt := time.Unix(int64(exp), 0)
+		r.ExpiresAt = &expiresAt
+	}
+
+	if reval, ok := tok.Claim("_reval").(float64); ok {
+		revalidateAfter := time.Unix(int64(reval), 0)
+		r.RevalidateAfter = &revalidateAfter
+	}
+
+	r.Certificate, _ = tok.Claim("_cert").(string)
+
+	return r, nil
+}
+
+// RolledBack reports whether a clock reading now has been set back from the
+// time the receipt was issued, allowing for the clock being behind the
+// server's by up to tolerance. Software should check it against the newest
+// receipt (and offline activation) it has kept.
+func RolledBack(issuedAt, now time.Time, tolerance time.Duration) bool {
+	return now.Add(tolerance).Before(issuedAt)
+}
@@ -0,0 +1,84 @@
+package license
+
+import (
+	"crypto/rsa"
+	"errors"
+	"time"
+
+	"github.com/danielchatfield/go-jwt"
+	"github.com/dchest/uniuri"
+)
+
+// ErrRecoveryTokenExpired is returned by ParseRecoveryToken for expired
+// tokens.
+var ErrRecoveryTokenExpired = errors.New("recovery token expired")
+
+// RecoveryToken is the token in the link a customer is emailed to recover
+// the license keys issued to their address. It is signed with the access
+// token key, and has neither a sub nor a scope so that it can't be used as
+// an access token.
+type RecoveryToken struct {
+	ID        string
+	Email     string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// NewRecoveryToken returns a token for the licenses of email, valid for ttl.
+func NewRecoveryToken(email string, ttl time.Duration) *RecoveryToken {
+	now := time.Now()
+
+	return &RecoveryToken{
+		ID:        uniuri.New(),
+		Email:     email,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(ttl),
+	}
+}
+
+// Encode signs the token with key and returns the encoded token.
+func (rt *RecoveryToken) Encode(key *rsa.PrivateKey) (string, error) {
+	t := jwt.NewToken(jwt.RSA)
+
+	t.SetClaim("jti", rt.ID)
+	t.SetClaim("_email", rt.Email)
+	t.SetClaim("iat", rt.IssuedAt.Unix())
+	t.SetClaim("exp", rt.ExpiresAt.Unix())
+
+	return t.Encode(key)
+}
+
+// ParseRecoveryToken verifies a token with key and checks that it hasn't
+// expired.
+func ParseRecoveryToken(token string, key *rsa.PublicKey) (*RecoveryToken, error) {
+	tok, err := jwt.ParseToken(token, jwt.RSA, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rt := &RecoveryToken{}
+	rt.ID, _ = tok.Claim("jti").(string)
+
+	var ok bool
+
+	if rt.Email, ok = tok.Claim("_email").(string); !ok || rt.Email == "" {
+		return nil, errors.New("Error extracting recovery token email")
+	}
+
+	iat, _ := tok.Claim("iat").(float64)
+	exp, ok := tok.Claim("exp").(float64)
+
+	if !ok {
+		return nil, errors.New("Error extracting recovery token expiry")
+	}
+
+	rt.IssuedAt = time.Unix(int64(iat), 0)
+	rt.ExpiresAt = time.Unix(int64(exp), 0)
+
+	if !time.Now().Before(rt.ExpiresAt) {
+		return nil, ErrRecoveryTokenExpired
+	}
+
+	return rt, nil
+}
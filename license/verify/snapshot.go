@@ -0,0 +1,96 @@
+package verify
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/danielchatfield/go-jwt"
+	"github.com/volcanicpixels/licensing/license"
+)
+
+var (
+	// ErrRevoked is returned by Offline for a license whose ID is in the
+	// revocation snapshot.
+	ErrRevoked = errors.New("verify: license has been revoked")
+
+	// ErrStaleSnapshot is returned by Offline when the revocation snapshot
+	// is older than allowed, so it may be missing recent revocations.
+	ErrStaleSnapshot = errors.New("verify: revocation snapshot is too old")
+)
+
+// Snapshot is a signed snapshot of the revoked license IDs, which offline
+// bundles have in revocations.json, for installations that can't download
+// the revocation list.
+type Snapshot struct {
+	// TakenAt is when the server took the snapshot.
+	TakenAt time.Time
+
+	revoked map[string]bool
+}
+
+// ParseSnapshot verifies a revocation snapshot, the contents of an offline
+// bundle's revocations.json, with key, the bundle's key with the revocations
+// role.
+func ParseSnapshot(data []byte, key *rsa.PublicKey) (*Snapshot, error) {
+	var file struct {
+		Token string `json:"token"`
+	}
+
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	tok, err := jwt.ParseToken(file.Token, jwt.RSA, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	iat, ok := tok.Claim("iat").(float64)
+
+	if !ok {
+		return nil, errors.New("verify: revocation snapshot has no time")
+	}
+
+	s := &Snapshot{TakenAt: time.Unix(int64(iat), 0), revoked: make(map[string]bool)}
+	revoked, _ := tok.Claim("_revoked").([]interface{})
+
+	for _, id := range revoked {
+		if id, ok := id.(string); ok {
+			s.revoked[id] = true
+		}
+	}
+
+	return s, nil
+}
+
+// Revoked reports whether the license with the ID was revoked when the
+// snapshot was taken.
+func (s *Snapshot) Revoked(id string) bool {
+	return s.revoked[id]
+}
+
+// Offline verifies a license against root like License, and checks that it
+// isn't revoked in a snapshot taken no more than maxAge ago, so air-gapped
+// installations still enforce revocations as of their last bundle. Zero
+// accepts a snapshot of any age. A snapshot that is too old fails with
+// ErrStaleSnapshot, which software can report as needing a new bundle.
+func Offline(token string, root *rsa.PublicKey, s *Snapshot, maxAge time.Duration) (*license.License, error) {
+	l, err := License(token, root)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if maxAge > 0 && time.Since(s.TakenAt) > maxAge {
+		return nil, ErrStaleSnapshot
+	}
+
+	if s.Revoked(l.ID) {
+		return nil, ErrRevoked
+	}
+
+	return l, nil
+}
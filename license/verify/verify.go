@@ -0,0 +1,96 @@
+// Package verify checks licenses against the root key, following the chain
+// through the certificate of the intermediate key that signed them. It is
+// what Go software embedding the root public key uses.
+package verify
+
+import (
+	"crypto/rsa"
+	"errors"
+	"time"
+
+	"github.com/volcanicpixels/licensing/license"
+)
+
+var (
+	// ErrNotAllowed is returned when a license is signed by an intermediate
+	// key that may not sign it, e.g. one for a different product.
+	ErrNotAllowed = errors.New("verify: the signing key is not allowed to sign this license")
+
+	// ErrNoCertificate is returned by Chain for a license that was signed
+	// with a key directly rather than with a certified intermediate key.
+	ErrNoCertificate = errors.New("verify: license has no certificate")
+
+	// ErrNotYetValid is returned for a license that is used before its
+	// NotBefore time, such as a pre-order before the release date.
+	ErrNotYetValid = errors.New("verify: license is not valid yet")
+)
+
+// Chain verifies a license signed by an intermediate key whose certificate
+// is signed by root, and returns the license and the certificate.
+func Chain(token string, root *rsa.PublicKey) (*license.License, *license.Certificate, error) {
+	u, err := license.Peek(token)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if u.Certificate() == "" {
+		return nil, nil, ErrNoCertificate
+	}
+
+	cert, err := license.ParseCertificate(u.Certificate(), root)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l, err := license.Parse(token, cert.PublicKey)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !cert.AllowsLicense(l) {
+		return nil, nil, ErrNotAllowed
+	}
+
+	if !l.ValidAt(time.Now()) {
+		return nil, nil, ErrNotYetValid
+	}
+
+	return l, cert, nil
+}
+
+// License verifies a license against root. Licenses with a certificate are
+// verified through the chain, those without must be signed by root itself.
+// Both are ErrNotYetValid before their NotBefore time.
+func License(token string, root *rsa.PublicKey) (*license.License, error) {
+	l, _, err := Chain(token, root)
+
+	if err != ErrNoCertificate {
+		return l, err
+	}
+
+	if l, err = license.Parse(token, root); err != nil {
+		return nil, err
+	}
+
+	if !l.ValidAt(time.Now()) {
+		return nil, ErrNotYetValid
+	}
+
+	return l, nil
+}
+
+// Fresh reports whether software may rely on a receipt reading the clock at
+// now instead of validating its license online, which is until the time the
+// server said to validate again. Receipts the server didn't give a time are
+// never fresh, and neither are any read from a clock that is behind the
+// time they were issued, since it has been rolled back.
+func Fresh(r *license.Receipt, now time.Time) bool {
+	if r.RevalidateAfter == nil || now.Before(r.IssuedAt) {
+		return false
+	}
+
+	return now.Before(*r.RevalidateAfter)
+}
@@ -0,0 +1,69 @@
+package license
+
+import (
+	"strconv"
+	"strings"
+)
+
+// CompareVersions compares dotted versions such as "1.10.2" part by part,
+// numerically where both parts are numbers, returning -1, 0 or 1. Missing
+// parts count as zero.
+func CompareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		ap, bp := "0", "0"
+
+		if i < len(as) {
+			ap = as[i]
+		}
+
+		if i < len(bs) {
+			bp = bs[i]
+		}
+
+		an, aerr := strconv.Atoi(ap)
+		bn, berr := strconv.Atoi(bp)
+
+		switch {
+		case aerr == nil && berr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+
+			return 1
+		case (aerr != nil || berr != nil) && ap != bp:
+			if ap < bp {
+				return -1
+			}
+
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// AllowsVersion reports whether the license covers a version of its
+// product. MaxVersion covers the versions it is a prefix of, so "2" (or
+// "2.x") allows 2.9.1 but not 3.0.
+func (l *License) AllowsVersion(version string) bool {
+	if l.MinVersion != "" && CompareVersions(version, l.MinVersion) < 0 {
+		return false
+	}
+
+	if l.MaxVersion != "" {
+		max := strings.TrimSuffix(strings.TrimSuffix(l.MaxVersion, ".x"), ".*")
+		parts := strings.Split(version, ".")
+
+		if n := len(strings.Split(max, ".")); len(parts) > n {
+			parts = parts[:n]
+		}
+
+		if CompareVersions(strings.Join(parts, "."), max) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,266 @@
+// Package licensingtest provides an in-memory platform, fixed keypairs and
+// HTTP helpers for testing the licensing server.
+package licensingtest
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/mail"
+	"github.com/volcanicpixels/licensing/pii"
+	"github.com/volcanicpixels/licensing/platform"
+	"github.com/volcanicpixels/licensing/storage"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+var _ platform.Platform = (*Platform)(nil)
+
+// Platform is a platform.Platform where everything is kept in memory. The
+// stores are exported so that tests can seed and inspect them.
+type Platform struct {
+	Files                  *storage.MemoryStorage
+	LicenseStore           *store.MemoryLicenses
+	SandboxLicenseStore    *store.MemoryLicenses
+	ActivationStore        *store.MemoryActivations
+	SandboxActivationStore *store.MemoryActivations
+	UserStore              *store.MemoryUsers
+	SandboxUserStore       *store.MemoryUsers
+	OrderStore             *store.MemoryOrders
+	SandboxOrderStore      *store.MemoryOrders
+	RevocationStore        *store.MemoryRevocations
+	AuditLog               *store.MemoryAudit
+	EventLog               *store.MemoryEvents
+	CounterStore           *store.MemoryCounters
+	EmailTemplateStore     *store.MemoryEmailTemplates
+	FlagStore              *store.MemoryFlags
+	JobStore               *store.MemoryJobs
+	Mail                   *mail.MemoryMailer
+
+	// FileStorage is returned by Storage instead of Files if it is set,
+	// e.g. to wrap Files in a slower storage.
+	FileStorage storage.Storage
+
+	// CounterStorage is returned by Counters instead of CounterStore if it
+	// is set.
+	CounterStorage store.Counters
+
+	// Tasks are the tasks enqueued so far, which tests run by posting
+	// them to the handler.
+	Tasks []Task
+
+	// Cipher is what PII returns, nil by default.
+	Cipher *pii.Cipher
+
+	// Admin is whether requests are treated as coming from an app admin, it
+	// is true by default, set it to false to test API key access.
+	Admin bool
+
+	mu       sync.Mutex
+	logs     []string
+	requests int
+}
+
+// NewPlatform returns an empty Platform with PluginKey, SandboxKey and
+// OtherKey installed.
+func NewPlatform() *Platform {
+	p := &Platform{
+		Files:                  storage.NewMemory(),
+		LicenseStore:           store.NewMemoryLicenses(),
+		SandboxLicenseStore:    store.NewMemoryLicenses(),
+		ActivationStore:        store.NewMemoryActivations(),
+		SandboxActivationStore: store.NewMemoryActivations(),
+		UserStore:              store.NewMemoryUsers(),
+		SandboxUserStore:       store.NewMemoryUsers(),
+		OrderStore:             store.NewMemoryOrders(),
+		SandboxOrderStore:      store.NewMemoryOrders(),
+		RevocationStore:        store.NewMemoryRevocations(),
+		AuditLog:               store.NewMemoryAudit(),
+		EventLog:               store.NewMemoryEvents(),
+		CounterStore:           store.NewMemoryCounters(),
+		EmailTemplateStore:     store.NewMemoryEmailTemplates(),
+		FlagStore:              store.NewMemoryFlags(),
+		JobStore:               store.NewMemoryJobs(),
+		Mail:                   mail.NewMemory(),
+		Admin:                  true,
+	}
+
+	for _, kp := range []KeyPair{PluginKey, SandboxKey, OtherKey} {
+		if err := kp.Install(p.Files); err != nil {
+			panic(err)
+		}
+	}
+
+	return p
+}
+
+// Task is an enqueued task.
+type Task struct {
+	Path   string
+	Params url.Values
+}
+
+// requestIDKey is the context key of the ID of a request.
+type requestIDKey struct{}
+
+// NewContext numbers requests from 1, their request IDs are "test-1",
+// "test-2" and so on.
+func (p *Platform) NewContext(r *http.Request) context.Context {
+	p.mu.Lock()
+	p.requests++
+	id := fmt.Sprintf("test-%v", p.requests)
+	p.mu.Unlock()
+
+	return context.WithValue(context.Background(), requestIDKey{}, id)
+}
+
+func (p *Platform) RequestID(c context.Context) string {
+	id, _ := c.Value(requestIDKey{}).(string)
+	return id
+}
+
+func (p *Platform) Storage(c context.Context) (storage.Storage, error) {
+	if p.FileStorage != nil {
+		return p.FileStorage, nil
+	}
+
+	return p.Files, nil
+}
+
+// Licenses logs the licenses it stores in EventLog.
+func (p *Platform) Licenses(c context.Context, namespace string) store.Licenses {
+	if namespace == store.SandboxNamespace {
+		return store.NewLoggedLicenses(p.SandboxLicenseStore, p.EventLog, namespace)
+	}
+
+	return store.NewLoggedLicenses(p.LicenseStore, p.EventLog, "")
+}
+
+func (p *Platform) Activations(c context.Context, namespace string) store.Activations {
+	if namespace == store.SandboxNamespace {
+		return p.SandboxActivationStore
+	}
+
+	return p.ActivationStore
+}
+
+func (p *Platform) Users(c context.Context, namespace string) store.Users {
+	if namespace == store.SandboxNamespace {
+		return p.SandboxUserStore
+	}
+
+	return p.UserStore
+}
+
+func (p *Platform) Orders(c context.Context, namespace string) store.Orders {
+	if namespace == store.SandboxNamespace {
+		return p.SandboxOrderStore
+	}
+
+	return p.OrderStore
+}
+
+// Revocations logs the revocations in EventLog.
+func (p *Platform) Revocations(c context.Context) (store.Revocations, error) {
+	return store.NewLoggedRevocations(p.RevocationStore, p.EventLog), nil
+}
+
+// RevocationsIn returns RevocationStore whatever the backend.
+func (p *Platform) RevocationsIn(c context.Context, backend string) (store.Revocations, error) {
+	return p.RevocationStore, nil
+}
+
+func (p *Platform) Audit(c context.Context) store.Audit {
+	return p.AuditLog
+}
+
+func (p *Platform) Events(c context.Context) store.Events {
+	return p.EventLog
+}
+
+func (p *Platform) Counters(c context.Context) store.Counters {
+	if p.CounterStorage != nil {
+		return p.CounterStorage
+	}
+
+	return p.CounterStore
+}
+
+func (p *Platform) EmailTemplates(c context.Context) store.EmailTemplates {
+	return p.EmailTemplateStore
+}
+
+func (p *Platform) Flags(c context.Context) store.Flags {
+	return p.FlagStore
+}
+
+func (p *Platform) Jobs(c context.Context) store.Jobs {
+	return p.JobStore
+}
+
+// Enqueue records the task in Tasks, it isn't run.
+func (p *Platform) Enqueue(c context.Context, path string, params url.Values) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Tasks = append(p.Tasks, Task{path, params})
+	return nil
+}
+
+func (p *Platform) Mailer(c context.Context) mail.Mailer {
+	return p.Mail
+}
+
+func (p *Platform) HTTPClient(c context.Context) *http.Client {
+	return http.DefaultClient
+}
+
+func (p *Platform) GoogleClient(c context.Context, scope ...string) (*http.Client, error) {
+	return http.DefaultClient, nil
+}
+
+func (p *Platform) PII(c context.Context) (*pii.Cipher, error) {
+	return p.Cipher, nil
+}
+
+func (p *Platform) IsAdmin(c context.Context, r *http.Request) bool {
+	return p.Admin
+}
+
+func (p *Platform) ProjectID(c context.Context) string {
+	return "licensing-test"
+}
+
+// Logs returns the messages logged so far, each prefixed with its level.
+func (p *Platform) Logs() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.logs...)
+}
+
+func (p *Platform) logf(level, format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.logs = append(p.logs, level+": "+fmt.Sprintf(format, args...))
+}
+
+func (p *Platform) Debugf(c context.Context, format string, args ...interface{}) {
+	p.logf("DEBUG", format, args...)
+}
+
+func (p *Platform) Infof(c context.Context, format string, args ...interface{}) {
+	p.logf("INFO", format, args...)
+}
+
+func (p *Platform) Warningf(c context.Context, format string, args ...interface{}) {
+	p.logf("WARNING", format, args...)
+}
+
+func (p *Platform) Errorf(c context.Context, format string, args ...interface{}) {
+	p.logf("ERROR", format, args...)
+}
@@ -0,0 +1,93 @@
+package licensingtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+)
+
+// Server is an httptest.Server with helpers for calling the JSON API.
+type Server struct {
+	*httptest.Server
+
+	// APIKey is sent as the bearer token of requests when it is set.
+	APIKey string
+}
+
+// NewServer starts a Server for h, typically the API router built after the
+// server's configuration and platform have been set (paths then start with
+// /api). Callers should Close it.
+func NewServer(h http.Handler) *Server {
+	return &Server{Server: httptest.NewServer(h)}
+}
+
+// Response is a decoded API response.
+type Response struct {
+	StatusCode int
+	Body       []byte
+}
+
+// Decode unmarshals the result field of the response envelope into v.
+func (r *Response) Decode(v interface{}) error {
+	var envelope struct {
+		Result json.RawMessage `json:"result"`
+	}
+
+	if err := json.Unmarshal(r.Body, &envelope); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(envelope.Result, v)
+}
+
+// Do sends a request with an optional JSON body to path.
+func (s *Server) Do(method, path string, body interface{}) (*Response, error) {
+	var buf bytes.Buffer
+
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(method, s.URL+path, &buf)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	}
+
+	if s.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	}
+
+	resp, err := s.Client().Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	var out bytes.Buffer
+
+	if _, err := out.ReadFrom(resp.Body); err != nil {
+		return nil, err
+	}
+
+	return &Response{resp.StatusCode, out.Bytes()}, nil
+}
+
+// Get sends a GET request to path.
+func (s *Server) Get(path string) (*Response, error) {
+	return s.Do("GET", path, nil)
+}
+
+// Post sends a POST request with a JSON body to path.
+func (s *Server) Post(path string, body interface{}) (*Response, error) {
+	return s.Do("POST", path, body)
+}
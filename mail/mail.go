@@ -0,0 +1,47 @@
+// Package mail sends emails to customers.
+package mail
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// Message is a plain text email to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends messages.
+type Mailer interface {
+	Send(c context.Context, m *Message) error
+}
+
+// MemoryMailer keeps the messages it is sent instead of sending them.
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMemory returns an empty MemoryMailer.
+func NewMemory() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (mm *MemoryMailer) Send(c context.Context, m *Message) error {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	mm.messages = append(mm.messages, *m)
+	return nil
+}
+
+// Messages returns the messages sent so far, oldest first.
+func (mm *MemoryMailer) Messages() []Message {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	return append([]Message(nil), mm.messages...)
+}
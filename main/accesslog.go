@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+)
+
+const accessContextKey contextKey = 1
+
+// accessEntry is what the access log entry of a request says about who made
+// it and which license it was about, which the handlers it passes through
+// fill in.
+type accessEntry struct {
+	keyID     string
+	licenseID string
+}
+
+// requestAccessEntry returns the access log entry of a request, or nil
+// outside of one.
+func requestAccessEntry(c context.Context) *accessEntry {
+	entry, _ := c.Value(accessContextKey).(*accessEntry)
+	return entry
+}
+
+// noteLicense records in the access log entry of a request the license it is
+// about, the first one noted if there are several.
+func noteLicense(c context.Context, id string) {
+	if entry := requestAccessEntry(c); entry != nil && entry.licenseID == "" {
+		entry.licenseID = id
+	}
+}
+
+// statusWriter remembers the status of the response written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+
+	return sw.ResponseWriter.Write(b)
+}
+
+// logAccess writes the access log entry of a request that started at start,
+// and counts it towards the validation success rate if it is a validation.
+// Requests to /licenses/{id} routes are about that license unless a handler
+// noted another.
+func logAccess(c context.Context, r *http.Request, sw *statusWriter, entry *accessEntry, start time.Time) {
+	name := ""
+
+	if route := mux.CurrentRoute(r); route != nil {
+		name = route.GetName()
+	}
+
+	if id := mux.Vars(r)["id"]; id != "" && entry.licenseID == "" && strings.HasPrefix(strings.TrimPrefix(r.URL.Path, v2Prefix), "/licenses/") {
+		entry.licenseID = id
+	}
+
+	status := sw.status
+
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	countValidation(c, name, status)
+
+	env.Infof(c, "access route=%v method=%v path=%q status=%v latency_ms=%v key=%v license=%v request=%v",
+		name, r.Method, r.URL.Path, status, int64(time.Since(start)/time.Millisecond), entry.keyID, entry.licenseID, env.RequestID(c))
+}
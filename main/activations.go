@@ -0,0 +1,405 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+const maxFingerprintLength = 200
+
+// licenseNamespace returns the namespace a license and its activations are
+// stored in.
+func licenseNamespace(l *license.License) string {
+	if l.Test {
+		return store.SandboxNamespace
+	}
+
+	return ""
+}
+
+// activationLimit returns how many installs a license can activate, zero is
+// unlimited. Without max_activations the limit of the product's activation
+// entitlement applies.
+func activationLimit(l *license.License) int {
+	if l.MaxActivations > 0 {
+		return l.MaxActivations
+	}
+
+	if feature := cfg.Products[l.Product].ActivationEntitlement; feature != "" {
+		return l.Entitlements[feature]
+	}
+
+	return 0
+}
+
+type activationRequest struct {
+	License     string `json:"license"`
+	Fingerprint string `json:"fingerprint"`
+	Site        string `json:"site"`
+	Version     string `json:"version"`
+
+	// User is the email of the member activating a license with seats.
+	User string `json:"user"`
+}
+
+func checkFingerprint(fingerprint string) *appError {
+	if fingerprint == "" || len(fingerprint) > maxFingerprintLength {
+		err := errors.New("invalid fingerprint")
+		return &appError{err, "A fingerprint of at most 200 characters is required", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	return nil
+}
+
+// decodeActivationRequest decodes the request and verifies its license.
+func decodeActivationRequest(v *validator, r *http.Request) (*activationRequest, *license.License, *appError) {
+	var req activationRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, nil, &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	req.Fingerprint = strings.TrimSpace(req.Fingerprint)
+	req.User = normalizeEmail(req.User)
+
+	if e := checkFingerprint(req.Fingerprint); e != nil {
+		return nil, nil, e
+	}
+
+	lic, err := v.parse(strings.TrimSpace(req.License))
+
+	if _, invalid := err.(*invalidError); invalid {
+		return nil, nil, &appError{err, "The license is invalid", http.StatusBadRequest, codeLicenseInvalid}
+	}
+
+	if err != nil {
+		return nil, nil, &appError{err, "Could not load public key for verifying", http.StatusInternalServerError, codeKeyUnavailable}
+	}
+
+	return &req, lic, nil
+}
+
+// ActivateLicense handles POST requests to /api/licenses/activate
+//
+// The request body holds the encoded license, a fingerprint identifying the
+// install and optionally the site it is on and the product version.
+// Activating an install again updates it. The license must be valid and, if
+// it limits its activations, have one free. Licenses with seats can only be activated by
+// their members, so the request also holds the member's email as user.
+//
+// Example:
+//
+//	POST /api/licenses/activate {"license": "eyJhbGciOiJSUzI1NiIs...", "fingerprint": "a1b2c3", "site": "https://example.com"}
+//	200 {"id": "daS7y8sioiecYy", "status": "valid", "valid": true, "activations": {"used": 1, "allowed": 3}, ...}
+//
+//	409 The license has no activations left
+func ActivateLicense(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	v := newValidator(c)
+	req, lic, e := decodeActivationRequest(v, r)
+
+	if e != nil {
+		return e
+	}
+
+	if lic.Seats > 0 {
+		if req.User == "" {
+			err := fmt.Errorf("license %v has seats", lic.ID)
+			return &appError{err, "The license is for named members, the user activating it is required", http.StatusBadRequest, codeInvalidRequest}
+		}
+
+		v.user = req.User
+	}
+
+	vd, err := v.check(lic)
+
+	if err != nil {
+		return &appError{err, "An error occurred validating the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if !vd.Valid {
+		writeJSON(w, http.StatusForbidden, vd)
+		return nil
+	}
+
+	now := time.Now()
+	a := store.Activation{
+		LicenseID:   lic.ID,
+		Fingerprint: req.Fingerprint,
+		Site:        req.Site,
+		Version:     strings.TrimSpace(req.Version),
+		ActivatedAt: now,
+		LastSeenAt:  now,
+	}
+
+	err = env.Activations(c, licenseNamespace(lic)).Activate(c, a, activationLimit(lic))
+
+	if err == store.ErrActivationLimit {
+		return &appError{err, "The license has no activations left", http.StatusConflict, codeActivationLimit}
+	}
+
+	if err != nil {
+		return &appError{err, "An error occurred activating the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	// the verdict is checked again to count the new activation
+	if vd, err = v.check(lic); err != nil {
+		return &appError{err, "An error occurred validating the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	watchSharing(c, r, vd, req.Fingerprint)
+
+	writeJSON(w, 200, vd)
+	return nil
+}
+
+// activationInfo is an activation as shown to support, the site is hashed
+// (see siteHash) so that it can be matched against what a customer says
+// without the list giving their sites away.
+type activationInfo struct {
+	Fingerprint string    `json:"fingerprint"`
+	SiteHash    string    `json:"siteHash,omitempty"`
+	Version     string    `json:"version,omitempty"`
+	ActivatedAt time.Time `json:"activatedAt"`
+	LastSeenAt  time.Time `json:"lastSeenAt"`
+}
+
+// siteHash returns the hex SHA-256 of a site URL without its scheme and
+// trailing slashes, lower cased, so "https://Example.com/" and
+// "http://example.com" have the same hash. It is empty for no site.
+func siteHash(site string) string {
+	site = strings.ToLower(strings.TrimSpace(site))
+	site = strings.TrimPrefix(strings.TrimPrefix(site, "https://"), "http://")
+	site = strings.TrimRight(site, "/")
+
+	if site == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(site))
+	return hex.EncodeToString(sum[:])
+}
+
+// ListActivations handles GET requests to /api/licenses/{id}/activations
+//
+// It lists a license's activations, oldest first, with when each last
+// checked in (activated or validated with its fingerprint), the product
+// version it last reported and a hash of its site, so that support can tell
+// a customer which of their sites is using up an activation.
+//
+// Example:
+//
+//	GET /api/licenses/daS7y8sioiecYy/activations
+//	200 {"activations": [{"fingerprint": "a1b2c3", "siteHash": "a379a6f6...", "version": "1.4.0", "activatedAt": "...", "lastSeenAt": "..."}], "used": 1, "allowed": 3}
+func ListActivations(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	namespace := requestNamespace(c)
+	lic, err := env.Licenses(c, namespace).Get(c, mux.Vars(r)["id"])
+
+	if err == store.ErrNotFound {
+		return &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	activations, err := env.Activations(c, namespace).List(c, lic.ID)
+
+	if err != nil {
+		return &appError{err, "An error occurred listing the activations", http.StatusInternalServerError, codeInternal}
+	}
+
+	infos := make([]activationInfo, len(activations))
+
+	for i, a := range activations {
+		infos[i] = activationInfo{a.Fingerprint, siteHash(a.Site), a.Version, a.ActivatedAt, a.LastSeenAt}
+	}
+
+	writeJSON(w, 200, struct {
+		Activations []activationInfo `json:"activations"`
+		activationUsage
+	}{infos, activationUsage{len(activations), activationLimit(lic)}})
+
+	return nil
+}
+
+// checkIn records that an install of the license a verdict is for was seen,
+// errors are logged since they mustn't fail the request. Nothing is recorded
+// in maintenance mode.
+func checkIn(c context.Context, vd *verdict, fingerprint, version string) {
+	if cfg.Maintenance.Enabled {
+		return
+	}
+
+	a := store.Activation{LicenseID: vd.ID, Fingerprint: fingerprint, Version: version, LastSeenAt: time.Now()}
+	namespace := ""
+
+	if vd.Test {
+		namespace = store.SandboxNamespace
+	}
+
+	if err := env.Activations(c, namespace).CheckIn(c, a); err != nil {
+		env.Errorf(c, "Could not check in activation %v of %v: %v", fingerprint, vd.ID, err)
+	}
+}
+
+// DeactivateLicense handles POST requests to /api/licenses/deactivate
+//
+// The request body holds the encoded license and the fingerprint of the
+// install to deactivate, freeing up its activation. Revoked and expired
+// licenses can be deactivated.
+func DeactivateLicense(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	req, lic, e := decodeActivationRequest(newValidator(c), r)
+
+	if e != nil {
+		return e
+	}
+
+	if err := env.Activations(c, licenseNamespace(lic)).Deactivate(c, lic.ID, req.Fingerprint); err != nil {
+		return &appError{err, "An error occurred deactivating the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	writeJSON(w, 200, "SUCCESS")
+	return nil
+}
+
+// ActivateOffline handles POST requests to /api/activations/offline
+//
+// The request body is the activation request exported by an install without
+// internet access, holding the ID of its license and its fingerprint. The
+// license is activated as by /api/licenses/activate, sandbox=true looks it
+// up among test licenses. The response is the activation signed with the
+// license's key, for the admin to carry back to the install.
+//
+// Example:
+//
+//	POST /api/activations/offline {"licenseId": "daS7y8sioiecYy", "fingerprint": "a1b2c3"}
+//	200 {"licenseId": "daS7y8sioiecYy", "fingerprint": "a1b2c3", "activation": "eyJhbGciOiJSUzI1NiIs..."}
+func ActivateOffline(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	var req struct {
+		LicenseID   string `json:"licenseId"`
+		Fingerprint string `json:"fingerprint"`
+		Site        string `json:"site"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	req.Fingerprint = strings.TrimSpace(req.Fingerprint)
+
+	if e := checkFingerprint(req.Fingerprint); e != nil {
+		return e
+	}
+
+	namespace := ""
+
+	if r.URL.Query().Get("sandbox") == "true" {
+		namespace = store.SandboxNamespace
+	}
+
+	lic, err := env.Licenses(c, namespace).Get(c, strings.TrimSpace(req.LicenseID))
+
+	if err == store.ErrNotFound {
+		return &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	vd, err := newValidator(c).check(lic)
+
+	if err != nil {
+		return &appError{err, "An error occurred validating the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if !vd.Valid {
+		writeJSON(w, http.StatusForbidden, vd)
+		return nil
+	}
+
+	now := time.Now()
+	a := store.Activation{
+		LicenseID:   lic.ID,
+		Fingerprint: req.Fingerprint,
+		Site:        req.Site,
+		ActivatedAt: now,
+		LastSeenAt:  now,
+	}
+
+	err = env.Activations(c, licenseNamespace(lic)).Activate(c, a, activationLimit(lic))
+
+	if err == store.ErrActivationLimit {
+		return &appError{err, "The license has no activations left", http.StatusConflict, codeActivationLimit}
+	}
+
+	if err != nil {
+		return &appError{err, "An error occurred activating the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	kid := signingKeyID(lic)
+	key, err := productPrivateKey(c, lic.Product, kid)
+
+	if err != nil {
+		return &appError{err, "Could not load private key for signing", http.StatusInternalServerError, codeKeyUnavailable}
+	}
+
+	seq, err := nextSequence(c, lic)
+
+	if err != nil {
+		return &appError{err, "Could not count the activation", http.StatusInternalServerError, codeInternal}
+	}
+
+	act := &license.OfflineActivation{
+		LicenseID:   lic.ID,
+		Fingerprint: req.Fingerprint,
+		IssuedAt:    now,
+		Sequence:    seq,
+		ExpiresAt:   lic.ExpiresAt,
+	}
+
+	if !lic.Test {
+		if act.Certificate, err = getCertificate(c, kid); err != nil {
+			return &appError{err, "Could not load the certificate of the signing key", http.StatusInternalServerError, codeKeyUnavailable}
+		}
+	}
+
+	token, err := act.Encode(key)
+
+	if err != nil {
+		return &appError{err, "Could not encode the activation", http.StatusInternalServerError, codeInternal}
+	}
+
+	entry := store.AuditEntry{
+		Action:   "license.activate-offline",
+		Target:   lic.ID,
+		Customer: lic.Email(),
+		Details:  map[string]string{"fingerprint": req.Fingerprint},
+	}
+
+	if err := audit(c, entry); err != nil {
+		env.Errorf(c, "Could not record the offline activation of %v in the audit log: %v", lic.ID, err)
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="activation-response.json"`)
+
+	writeJSON(w, 200, struct {
+		LicenseID   string `json:"licenseId"`
+		Fingerprint string `json:"fingerprint"`
+		Activation  string `json:"activation"`
+	}{lic.ID, req.Fingerprint, token})
+
+	return nil
+}
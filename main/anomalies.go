@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/mail"
+)
+
+// The volumes that are watched for anomalies.
+const (
+	volumeIssued    = "issued"
+	volumeValidated = "validated"
+	volumeRevoked   = "revoked"
+)
+
+var volumes = []string{volumeIssued, volumeValidated, volumeRevoked}
+
+// volumeCounter names the counter of a product's volume in the hour
+// containing t (in UTC).
+func volumeCounter(volume, product string, t time.Time) string {
+	return fmt.Sprintf("volume:%v:%v:%v", volume, product, t.UTC().Format("2006-01-02T15"))
+}
+
+// countVolume counts a production license towards a product's volume for the
+// current hour, it does nothing unless alerts are enabled. Errors are logged
+// since counting is only for alerting.
+func countVolume(c context.Context, volume, product string) {
+	if !cfg.Alerts.Enabled() || product == "" {
+		return
+	}
+
+	if err := env.Counters(c).Increment(c, volumeCounter(volume, product, time.Now())); err != nil {
+		env.Errorf(c, "Could not count a %v license of %v: %v", volume, product, err)
+	}
+}
+
+// anomaly is an hour in which a product's volume was unusual.
+type anomaly struct {
+	Product  string    `json:"product"`
+	Volume   string    `json:"volume"`
+	Kind     string    `json:"kind"` // spike or drop
+	Hour     time.Time `json:"hour"`
+	Count    int       `json:"count"`
+	Baseline float64   `json:"baseline"` // hourly average
+}
+
+func (a anomaly) String() string {
+	return fmt.Sprintf("%v: %v licenses %v in the hour from %v, against %.1f an hour over the last %v hours (%v)",
+		a.Product, a.Count, a.Volume, a.Hour.Format("15:04 MST"), a.Baseline, cfg.Alerts.BaselineHours, a.Kind)
+}
+
+// detectAnomalies compares each product's volumes in the last complete hour
+// before now with their hourly averages over the baseline hours before it.
+func detectAnomalies(c context.Context, now time.Time) ([]anomaly, error) {
+	hour := now.UTC().Truncate(time.Hour).Add(-time.Hour)
+	products := make([]string, 0, len(cfg.Products))
+
+	for name := range cfg.Products {
+		products = append(products, name)
+	}
+
+	sort.Strings(products)
+	anomalies := []anomaly{}
+
+	for _, product := range products {
+		t := cfg.AlertThresholds(product)
+
+		for _, volume := range volumes {
+			count, err := env.Counters(c).Count(c, volumeCounter(volume, product, hour))
+
+			if err != nil {
+				return nil, err
+			}
+
+			total := 0
+
+			for i := 1; i <= cfg.Alerts.BaselineHours; i++ {
+				n, err := env.Counters(c).Count(c, volumeCounter(volume, product, hour.Add(-time.Duration(i)*time.Hour)))
+
+				if err != nil {
+					return nil, err
+				}
+
+				total += n
+			}
+
+			a := anomaly{
+				Product:  product,
+				Volume:   volume,
+				Hour:     hour,
+				Count:    count,
+				Baseline: float64(total) / float64(cfg.Alerts.BaselineHours),
+			}
+
+			// a quiet product is compared with the minimum baseline, so a
+			// leaked key is still noticed but a few licenses aren't
+			base := a.Baseline
+
+			if base < t.MinBaseline {
+				base = t.MinBaseline
+			}
+
+			switch {
+			case t.Spike > 0 && float64(count) > t.Spike*base:
+				a.Kind = "spike"
+			case t.Drop > 0 && a.Baseline >= t.MinBaseline && float64(count) < t.Drop*a.Baseline:
+				a.Kind = "drop"
+			default:
+				continue
+			}
+
+			anomalies = append(anomalies, a)
+		}
+	}
+
+	return anomalies, nil
+}
+
+// sendAlert posts anomalies to Slack and emails them to the configured
+// addresses, failures are logged so that one channel failing doesn't stop
+// the others.
+func sendAlert(c context.Context, anomalies []anomaly) {
+	lines := make([]string, len(anomalies))
+
+	for i, a := range anomalies {
+		lines[i] = a.String()
+	}
+
+	text := "Unusual licensing volumes, spikes may be abuse and drops an outage:\n\n" + strings.Join(lines, "\n")
+
+	if cfg.Alerts.Slack {
+		if err := postSlack(c, text); err != nil {
+			env.Errorf(c, "Could not post the anomaly alert to Slack: %v", err)
+		}
+	}
+
+	for _, to := range cfg.Alerts.Emails {
+		msg := &mail.Message{
+			To:      to,
+			Subject: "Licensing alert: unusual volumes",
+			Body:    text + "\n",
+		}
+
+		if err := env.Mailer(c).Send(c, msg); err != nil {
+			env.Errorf(c, "Could not email the anomaly alert to %v: %v", to, err)
+		}
+	}
+}
+
+// CheckAnomalies handles GET requests to /api/jobs/anomalies
+//
+// It is run hourly by cron and alerts on the products whose volume of
+// licenses issued, validated or revoked in the last complete hour is unusual
+// (see config.AlertThresholds). Only production licenses are counted.
+//
+// Example:
+//
+//	GET /api/jobs/anomalies
+//	200 [{"product": "domain_changer", "volume": "issued", "kind": "spike", "count": 420, "baseline": 12.5, ...}]
+func CheckAnomalies(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	if !cfg.Alerts.Enabled() {
+		writeJSON(w, 200, []anomaly{})
+		return nil
+	}
+
+	anomalies, err := detectAnomalies(c, time.Now())
+
+	if err != nil {
+		return &appError{err, "An error occurred counting licensing volumes", http.StatusInternalServerError, codeInternal}
+	}
+
+	if len(anomalies) > 0 {
+		sendAlert(c, anomalies)
+	}
+
+	writeJSON(w, 200, anomalies)
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// v2Prefix is where every route is also served with v2 responses.
+const v2Prefix = "/v2"
+
+// encodedLicense is a license string as the result of a response, which v2
+// responses wrap in an object.
+type encodedLicense string
+
+// envelope is the body of a v2 success response, data is the result of the
+// v1 one. Errors are answered like v1 ones, with the request ID in the
+// X-Request-Id header.
+type envelope struct {
+	Data      interface{} `json:"data"`
+	RequestID string      `json:"request_id"`
+
+	// Deduplicated is set when a create request returns the license that
+	// was already issued for its order.
+	Deduplicated bool `json:"deduplicated,omitempty"`
+}
+
+// v2Writer marks the response of a request to a v2 route, so that
+// writeResponse writes an envelope.
+type v2Writer struct {
+	http.ResponseWriter
+	requestID string
+}
+
+// versioned wraps the handler of a route served under v2Prefix so that its
+// success responses are envelopes.
+func versioned(h appHandler) appHandler {
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+		id := env.RequestID(c)
+		w.Header().Set("X-Request-Id", id)
+		return h(c, &v2Writer{w, id}, r)
+	}
+}
+
+// v2Data returns the data of a v2 response for the result of a v1 one, the
+// bare strings of v1 become objects: "SUCCESS" an empty one and a license
+// string {"license": "..."}.
+func v2Data(result interface{}) interface{} {
+	switch v := result.(type) {
+	case encodedLicense:
+		return struct {
+			License string `json:"license"`
+		}{string(v)}
+	case string:
+		if v == "SUCCESS" {
+			return struct{}{}
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,19 @@
+//go:build appengine
+// +build appengine
+
+package main
+
+import (
+	"github.com/volcanicpixels/licensing/platform"
+)
+
+func init() {
+	var err error
+
+	if cfg, err = loadConfig(); err != nil {
+		panic(err)
+	}
+
+	env = platform.NewAppEngine(cfg)
+	registerHandlers()
+}
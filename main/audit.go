@@ -0,0 +1,31 @@
+package main
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// actor describes who made a request for the audit log.
+func actor(c context.Context) string {
+	p, _ := c.Value(principalContextKey).(*principal)
+
+	switch {
+	case p == nil:
+		return "anonymous"
+	case p.key != nil:
+		return "key:" + p.key.ID
+	default:
+		return "admin"
+	}
+}
+
+// audit records an action taken by the request's principal.
+func audit(c context.Context, e store.AuditEntry) error {
+	e.Time = time.Now()
+	e.Actor = actor(c)
+
+	return env.Audit(c).Record(c, e)
+}
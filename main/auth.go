@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/config"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// access is the level of access a route requires.
+type access int
+
+const (
+	// adminAccess routes can only be called by app admins (and cron).
+	adminAccess access = iota
+
+	// apiKeyAccess routes can also be called with any API key other than a
+	// reseller's.
+	apiKeyAccess
+
+	// adminKeyAccess routes can be called by app admins and with admin API
+	// keys.
+	adminKeyAccess
+
+	// resellerAccess routes on /resellers/{id} can be called by admins and
+	// with the API keys of that reseller.
+	resellerAccess
+
+	// publicAccess routes can be called by anyone, such as the software
+	// validating its own license.
+	publicAccess
+)
+
+// principal is who a request was made by, it is nil for anonymous requests
+// to public routes.
+type principal struct {
+	admin bool
+	key   *config.APIKey
+}
+
+type contextKey int
+
+const principalContextKey contextKey = 0
+
+// authenticate wraps h so that it is only called for requests with the
+// required access. Requests with an API key are authenticated by the key
+// alone so that an admin can try out a sandbox key.
+func authenticate(level access, h appHandler) appHandler {
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+		var p *principal
+
+		switch {
+		case r.Header.Get("Authorization") != "":
+			if level == adminAccess {
+				return &appError{errors.New("API key used for an admin route"), "Admin access is required", http.StatusForbidden, codeForbidden}
+			}
+
+			key, err := apiKeyFromRequest(r)
+
+			if err != nil {
+				return &appError{err, "A valid API key is required", http.StatusUnauthorized, codeAuthRequired}
+			}
+
+			if err := checkReseller(level, key, r); err != nil {
+				return &appError{err, "The API key can't be used for this request", http.StatusForbidden, codeForbidden}
+			}
+
+			if level == adminKeyAccess && !key.Admin {
+				return &appError{errors.New("API key used for an admin key route"), "An admin API key is required", http.StatusForbidden, codeForbidden}
+			}
+
+			p = &principal{key: key}
+
+			if entry := requestAccessEntry(c); entry != nil {
+				entry.keyID = key.ID
+			}
+		case env.IsAdmin(c, r):
+			p = &principal{admin: true}
+		case level != publicAccess:
+			return &appError{errors.New("not signed in as an admin"), "Authentication is required", http.StatusUnauthorized, codeAuthRequired}
+		}
+
+		if t := requestTimingsOf(c); t != nil && p != nil && (p.admin || p.key.Debug) {
+			t.enabled = true
+		}
+
+		return h(context.WithValue(c, principalContextKey, p), w, r)
+	}
+}
+
+// apiKeyFromRequest returns the configured key matching the bearer token in
+// the Authorization header.
+func apiKeyFromRequest(r *http.Request) (*config.APIKey, error) {
+	auth := r.Header.Get("Authorization")
+
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, errors.New("expected a bearer token")
+	}
+
+	sum := sha256.Sum256([]byte(strings.TrimPrefix(auth, "Bearer ")))
+
+	for i := range cfg.APIKeys {
+		hash, _ := hex.DecodeString(cfg.APIKeys[i].Hash)
+
+		if subtle.ConstantTimeCompare(sum[:], hash) == 1 {
+			return &cfg.APIKeys[i], nil
+		}
+	}
+
+	return nil, errors.New("unknown API key")
+}
+
+// checkReseller keeps reseller keys to their own reseller's routes, and
+// other keys out of them.
+func checkReseller(level access, key *config.APIKey, r *http.Request) error {
+	switch {
+	case level == resellerAccess && key.Reseller == "":
+		return errors.New("not a reseller API key")
+	case level != resellerAccess && key.Reseller != "":
+		return errors.New("reseller API key used outside the reseller API")
+	case level == resellerAccess && mux.Vars(r)["id"] != key.Reseller:
+		return errors.New("reseller API key used for another reseller")
+	}
+
+	return nil
+}
+
+// requestAPIKey returns the API key the request was authenticated with, or
+// nil if it wasn't.
+func requestAPIKey(c context.Context) *config.APIKey {
+	if p, _ := c.Value(principalContextKey).(*principal); p != nil {
+		return p.key
+	}
+
+	return nil
+}
+
+// isAdmin reports whether the request was made by a signed in admin, rather
+// than with an API key.
+func isAdmin(c context.Context) bool {
+	p, _ := c.Value(principalContextKey).(*principal)
+	return p != nil && p.admin
+}
+
+// isAdminKey reports whether the request was made with an admin API key.
+func isAdminKey(c context.Context) bool {
+	key := requestAPIKey(c)
+	return key != nil && key.Admin
+}
+
+// isAuthenticated reports whether the request was made by an admin or with an
+// API key.
+func isAuthenticated(c context.Context) bool {
+	p, _ := c.Value(principalContextKey).(*principal)
+	return p != nil
+}
+
+// isSandbox reports whether the request was made with a sandbox API key.
+func isSandbox(c context.Context) bool {
+	key := requestAPIKey(c)
+	return key != nil && key.Sandbox
+}
+
+// requestNamespace returns the namespace of the licenses a request can see,
+// sandbox API keys only see sandbox licenses.
+func requestNamespace(c context.Context) string {
+	if isSandbox(c) {
+		return store.SandboxNamespace
+	}
+
+	return ""
+}
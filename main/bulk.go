@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// Bulk actions.
+const (
+	bulkRevoke         = "revoke"
+	bulkExtend         = "extend"
+	bulkAddEntitlement = "add_entitlement"
+)
+
+// jobBulk is the kind of bulk action jobs.
+const jobBulk = "bulk"
+
+// bulkBatchSize is how many licenses a batch of a bulk action goes through,
+// each one is signed again so this keeps a task well within its deadline.
+const bulkBatchSize = 100
+
+// maxBulkExtendDays is the longest a bulk action extends licenses by.
+const maxBulkExtendDays = 3650
+
+// bulkRequest is the body of a bulk action.
+type bulkRequest struct {
+	Action string `json:"action"`
+
+	// Filter picks the licenses the action applies to, the product is
+	// required so that a mistake can't revoke every license.
+	Filter struct {
+		Product         string     `json:"product"`
+		Plan            string     `json:"plan"`
+		PurchasedBefore *time.Time `json:"purchased_before"`
+	} `json:"filter"`
+
+	// Days extends licenses by that many days.
+	Days int `json:"days"`
+
+	// Entitlement is added with the limit to licenses that don't have it.
+	Entitlement string `json:"entitlement"`
+	Limit       int    `json:"limit"`
+
+	// Reason is the comment of the revocations.
+	Reason string `json:"reason"`
+}
+
+// params returns the job parameters of a checked request.
+func (req *bulkRequest) params() map[string]string {
+	params := map[string]string{"action": req.Action, "product": req.Filter.Product}
+
+	if req.Filter.Plan != "" {
+		params["plan"] = req.Filter.Plan
+	}
+
+	if req.Filter.PurchasedBefore != nil {
+		params["purchasedBefore"] = req.Filter.PurchasedBefore.UTC().Format(time.RFC3339)
+	}
+
+	switch req.Action {
+	case bulkRevoke:
+		params["reason"] = req.Reason
+	case bulkExtend:
+		params["days"] = strconv.Itoa(req.Days)
+	case bulkAddEntitlement:
+		params["entitlement"] = req.Entitlement
+		params["limit"] = strconv.Itoa(req.Limit)
+	}
+
+	return params
+}
+
+// BulkAction handles POST requests to /api/licenses/bulk
+//
+// It applies an action to every license of a product, optionally only those
+// of a plan or purchased before a time, in the background. The actions are
+// revoke (with a reason), extend (by days, perpetual licenses are left alone)
+// and add_entitlement (with a limit, to licenses that don't have it and
+// don't unlock everything). Extended licenses and those given an entitlement
+// are signed again, which customers get by recovering their license, and
+// revoked licenses are skipped. The response is the job, whose progress is
+// at GET /api/jobs/{id}. A dry run answers straight away with how many of
+// the first 1000 licenses the action would change and the first of their IDs.
+//
+// Example:
+//
+//	POST /api/licenses/bulk {"action": "extend", "filter": {"product": "domain_changer", "purchased_before": "2026-10-01T00:00:00Z"}, "days": 30}
+//	202 {"id": "p3Bd9sKe0aQvT1xZ", "kind": "bulk", "status": "running", "params": {...}, "processed": 0, "failed": 0, ...}
+func BulkAction(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	var req bulkRequest
+
+	dry, e := dryRun(r)
+
+	if e != nil {
+		return e
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	if e := checkProduct(c, req.Filter.Product); e != nil {
+		return e
+	}
+
+	switch req.Action {
+	case bulkRevoke:
+	case bulkExtend:
+		if req.Days < 1 || req.Days > maxBulkExtendDays {
+			err := fmt.Errorf("invalid days %v", req.Days)
+			return &appError{&fieldError{Field: "days", err: err}, "days must be from 1 to 3650", http.StatusBadRequest, codeInvalidRequest}
+		}
+	case bulkAddEntitlement:
+		if req.Entitlement == "" || req.Limit < 0 {
+			err := errors.New("invalid entitlement")
+			return &appError{&fieldError{Field: "entitlement", err: err}, "An entitlement and a limit of zero or more are required", http.StatusBadRequest, codeInvalidRequest}
+		}
+	default:
+		err := fmt.Errorf("unknown action %q", req.Action)
+		allowed := []string{bulkRevoke, bulkExtend, bulkAddEntitlement}
+		return &appError{&fieldError{Field: "action", Allowed: allowed, err: err}, "The action must be revoke, extend or add_entitlement", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	if dry {
+		return previewBulk(c, w, req.params())
+	}
+
+	job, err := startJob(c, jobBulk, req.params())
+
+	if err != nil {
+		return &appError{err, "Could not start the job", http.StatusInternalServerError, codeInternal}
+	}
+
+	if err := audit(c, store.AuditEntry{Action: "license.bulk", Target: job.ID, Details: job.Params}); err != nil {
+		env.Errorf(c, "Could not record bulk action %v in the audit log: %v", job.ID, err)
+	}
+
+	writeJSON(w, http.StatusAccepted, job)
+	return nil
+}
+
+// bulkQuery returns the query for a page of the licenses a bulk action with
+// the job parameters applies to.
+func bulkQuery(params map[string]string, cursor string) (store.Query, error) {
+	q := store.Query{Product: params["product"], Plan: params["plan"], Limit: bulkBatchSize, Cursor: cursor}
+
+	if s := params["purchasedBefore"]; s != "" {
+		var err error
+
+		if q.CreatedBefore, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, err
+		}
+	}
+
+	return q, nil
+}
+
+// runBulkBatch applies a bulk action to a batch of licenses.
+func runBulkBatch(c context.Context, job *store.Job) (string, error) {
+	q, err := bulkQuery(job.Params, job.Cursor)
+
+	if err != nil {
+		return "", &jobError{err}
+	}
+
+	licenses, cursor, err := env.Licenses(c, "").List(c, q)
+
+	if err != nil {
+		return "", err
+	}
+
+	for _, lic := range licenses {
+		if lic.RevokedAt != nil {
+			continue
+		}
+
+		changed, err := applyBulk(c, job, lic)
+
+		switch {
+		case err != nil:
+			env.Errorf(c, "Bulk action %v failed on %v: %v", job.ID, lic.ID, err)
+			job.Failed++
+		case changed:
+			job.Processed++
+		}
+	}
+
+	return cursor, nil
+}
+
+// applyBulk applies a bulk action to a license that isn't revoked, changed
+// is false for licenses the action leaves alone.
+func applyBulk(c context.Context, job *store.Job, lic *license.License) (changed bool, err error) {
+	if changed, err := changeBulk(job.Params, lic); err != nil || !changed {
+		return false, err
+	}
+
+	if job.Params["action"] == bulkRevoke {
+		rev := store.Revocation{ID: lic.ID, Comment: job.Params["reason"]}
+
+		if err := revokeLicense(c, licenseNamespace(lic), rev, map[string]string{"job": job.ID}); err != nil {
+			return false, err
+		}
+
+		notifyWebhooks(c, "license.revoked", map[string]string{"id": lic.ID})
+		return true, nil
+	}
+
+	if _, e := signLicense(c, lic); e != nil {
+		return false, e.Error
+	}
+
+	if err := env.Licenses(c, "").Put(c, lic); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// changeBulk makes the change of a bulk action with the job parameters to a
+// license that isn't revoked, without signing or storing it, changed is
+// false for licenses the action leaves alone. Revoking doesn't change the
+// license itself.
+func changeBulk(params map[string]string, lic *license.License) (changed bool, err error) {
+	switch params["action"] {
+	case bulkRevoke:
+		return true, nil
+	case bulkExtend:
+		if lic.ExpiresAt == nil {
+			return false, nil
+		}
+
+		days, err := strconv.Atoi(params["days"])
+
+		if err != nil {
+			return false, err
+		}
+
+		expiresAt := lic.ExpiresAt.AddDate(0, 0, days)
+		lic.ExpiresAt = &expiresAt
+	case bulkAddEntitlement:
+		feature := params["entitlement"]
+
+		if _, ok := lic.Entitlements[feature]; ok || lic.Entitlements == nil {
+			return false, nil
+		}
+
+		limit, err := strconv.Atoi(params["limit"])
+
+		if err != nil {
+			return false, err
+		}
+
+		lic.Entitlements[feature] = limit
+	default:
+		return false, fmt.Errorf("unknown action %q", params["action"])
+	}
+
+	return true, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// validationFacts are what the rules of the validation pipeline decide a
+// license's verdict from. They are loaded before any rule runs, so the rules
+// have no side effects.
+type validationFacts struct {
+	lic *license.License
+	now time.Time
+
+	// stored is the license's stored copy, nil if it isn't stored.
+	stored  *license.License
+	revoked bool
+
+	// users are the named users of a named-user license.
+	users []store.User
+
+	// country, version, user and product are the request's, empty if
+	// unknown.
+	country, version, user, product string
+}
+
+// named reports whether the request's user is one of the license's.
+func (f *validationFacts) named() bool {
+	for _, u := range f.users {
+		if u.Email == f.user {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validationRule fails a license with its status.
+type validationRule struct {
+	status string
+	fails  func(f *validationFacts) bool
+}
+
+// validationRules are the rules of the validation pipeline in order of
+// precedence, the first that fails a license gives its status and a license
+// none of them fail is valid.
+var validationRules = []validationRule{
+	{statusRevoked, func(f *validationFacts) bool {
+		return f.revoked
+	}},
+	{statusSuperseded, func(f *validationFacts) bool {
+		return f.stored != nil && f.stored.Serial > f.lic.Serial
+	}},
+	{statusSuspended, func(f *validationFacts) bool {
+		return f.stored != nil && f.stored.SuspendedAt != nil
+	}},
+	{statusReissueRequired, func(f *validationFacts) bool {
+		return cfg.IsCompromised(f.lic.KeyID)
+	}},
+	{statusNotYetValid, func(f *validationFacts) bool {
+		return !f.lic.ValidAt(f.now)
+	}},
+	{statusExpired, func(f *validationFacts) bool {
+		return f.lic.ExpiresAt != nil && !f.now.Before(*f.lic.ExpiresAt)
+	}},
+	// licenses used from an unknown country get the benefit of the doubt
+	{statusRegionRestricted, func(f *validationFacts) bool {
+		return f.country != "" && !f.lic.AllowsRegion(f.country) && cfg.Products[f.lic.Product].EnforceRegions
+	}},
+	{statusUserNotLicensed, func(f *validationFacts) bool {
+		return f.lic.Seats > 0 && f.user != "" && !f.named()
+	}},
+	{statusProductNotCovered, func(f *validationFacts) bool {
+		return f.product != "" && !f.lic.Covers(f.product)
+	}},
+	{statusVersionNotCovered, func(f *validationFacts) bool {
+		return f.version != "" && !f.lic.AllowsVersion(f.version)
+	}},
+}
+
+// runPipeline returns the verdict of the validation pipeline for a license,
+// without the activations, seats and receipt that don't depend on the rules.
+func runPipeline(f *validationFacts) *verdict {
+	lic := f.lic
+	vd := &verdict{
+		ID:         lic.ID,
+		Product:    lic.Product,
+		Products:   lic.Products,
+		Status:     statusValid,
+		Valid:      true,
+		Test:       lic.Test,
+		Legacy:     lic.Legacy,
+		ExpiresAt:  lic.ExpiresAt,
+		NotBefore:  lic.NotBefore,
+		RevokeAt:   lic.RevokeAt,
+		MinVersion: lic.MinVersion,
+		MaxVersion: lic.MaxVersion,
+	}
+
+	for _, rule := range validationRules {
+		if rule.fails(f) {
+			vd.Status = rule.status
+			vd.Valid = false
+			break
+		}
+	}
+
+	if f.country != "" && len(lic.Regions) > 0 {
+		vd.Country = f.country
+		vd.OutsideRegions = !lic.AllowsRegion(f.country)
+	}
+
+	switch {
+	case vd.Status == statusExpired:
+		if graceEnds := lic.ExpiresAt.Add(cfg.GracePeriod(lic.Product)); f.now.Before(graceEnds) {
+			vd.InGrace = true
+			vd.GraceEndsAt = &graceEnds
+		}
+	case vd.Valid && lic.ExpiresAt != nil:
+		days := int(lic.ExpiresAt.Sub(f.now) / (24 * time.Hour))
+		vd.DaysRemaining = &days
+	}
+
+	return vd
+}
+
+// verdictDiscrepancies returns how the pipeline's verdict differs from the
+// validator's in the fields the rules decide, none if they agree.
+func verdictDiscrepancies(old, next *verdict) []string {
+	var diffs []string
+
+	note := func(field string, a, b interface{}) {
+		if fmt.Sprint(a) != fmt.Sprint(b) {
+			diffs = append(diffs, fmt.Sprintf("%v %v != %v", field, a, b))
+		}
+	}
+
+	intOf := func(n *int) interface{} {
+		if n == nil {
+			return nil
+		}
+
+		return *n
+	}
+
+	timeOf := func(t *time.Time) interface{} {
+		if t == nil {
+			return nil
+		}
+
+		return t.UTC().Format(time.RFC3339)
+	}
+
+	note("status", old.Status, next.Status)
+	note("valid", old.Valid, next.Valid)
+	note("daysRemaining", intOf(old.DaysRemaining), intOf(next.DaysRemaining))
+	note("inGrace", old.InGrace, next.InGrace)
+	note("graceEndsAt", timeOf(old.GraceEndsAt), timeOf(next.GraceEndsAt))
+	note("country", old.Country, next.Country)
+	note("outsideRegions", old.OutsideRegions, next.OutsideRegions)
+	note("minVersion", old.MinVersion, next.MinVersion)
+	note("maxVersion", old.MaxVersion, next.MaxVersion)
+
+	return diffs
+}
+
+// compareWithPipeline logs how the validation pipeline's verdict for a license
+// differs from the validator's, which is the one returned.
+func compareWithPipeline(c context.Context, f *validationFacts, vd *verdict) {
+	diffs := verdictDiscrepancies(vd, runPipeline(f))
+
+	if len(diffs) > 0 {
+		env.Warningf(c, "Validation canary: license %v (%v) differs from the pipeline: %v", f.lic.ID, f.lic.Product, strings.Join(diffs, ", "))
+	}
+}
+
+// ValidateLicenseCanary handles POST requests to /api/v2/licenses/validate-canary
+//
+// It validates a license exactly like /api/v2/licenses/validate and returns
+// that verdict, but also runs the validation pipeline (see validationRules)
+// on the same license and logs a warning for each verdict it disagrees with,
+// so that the pipeline can be checked against real traffic before it
+// replaces the validator. Licenses that can't be decoded or found never
+// reach the pipeline.
+//
+// Example:
+//
+//	POST /api/v2/licenses/validate-canary {"license": "eyJhbGciOiJSUzI1NiIs..."}
+//	200 {"data": {"id": "daS7y8sioiecYy", "product": "domain_changer", "status": "valid", "valid": true}, "request_id": "..."}
+func ValidateLicenseCanary(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	return validateLicense(c, w, r, true)
+}
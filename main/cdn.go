@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/storage"
+)
+
+// cdnTaskPath is where the tasks that publish the revocation list to the CDN
+// are posted.
+const cdnTaskPath = "/api/tasks/cdn"
+
+// cdnImmutable is the Cache-Control of the versioned lists on the CDN, whose
+// names change with their contents so they are never written again.
+const cdnImmutable = "public, max-age=31536000, immutable"
+
+// cdnLatest is the CDN's latest file, which names the current revocation
+// list. It is the only file there that changes.
+type cdnLatest struct {
+	File string `json:"file"`
+
+	// URL is the list's URL on the CDN, if cdn.url is set.
+	URL    string `json:"url,omitempty"`
+	SHA256 string `json:"sha256"`
+	Count  int    `json:"count"`
+
+	// ExpiresAt is when the list's token expires and PublishedAt when the
+	// revocations in it were read, it has every revocation before then.
+	ExpiresAt   time.Time `json:"expiresAt"`
+	PublishedAt time.Time `json:"publishedAt"`
+}
+
+// cdnFile names a file of the revocation list on the CDN after the output
+// file, revocations-<version>.json.
+func cdnFile(version string) string {
+	output := path.Base(cfg.Revocations.Output)
+	ext := path.Ext(output)
+	return strings.TrimSuffix(output, ext) + "-" + version + ext
+}
+
+// enqueueCDNPublish has the revocation list published to the CDN with the
+// revocations made until now, if there is a CDN.
+func enqueueCDNPublish(c context.Context) error {
+	if cfg.CDN.Location == "" {
+		return nil
+	}
+
+	return env.Enqueue(c, cdnTaskPath, url.Values{"at": {time.Now().UTC().Format(time.RFC3339Nano)}})
+}
+
+// readCDNLatest reads the CDN's latest file, nil if nothing was published
+// yet.
+func readCDNLatest(sc storage.Storage) (*cdnLatest, error) {
+	data, err := sc.ReadFile(cdnFile("latest"))
+
+	if err == storage.ErrNotExist {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var latest cdnLatest
+
+	if err := json.Unmarshal(data, &latest); err != nil {
+		return nil, err
+	}
+
+	return &latest, nil
+}
+
+// publishCached writes a public file served with cacheControl, storage that
+// can't set it serves the file with its own.
+func publishCached(sc storage.Storage, fileName string, data []byte, cacheControl string) error {
+	var err error
+
+	if cw, ok := sc.(storage.CacheWriter); ok {
+		err = cw.WriteFileCached(fileName, data, cacheControl)
+	} else {
+		err = sc.WriteFile(fileName, data)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return sc.MakePublic(fileName)
+}
+
+// PublishToCDN handles POST requests to /api/tasks/cdn
+//
+// It is the task that every revocation enqueues to publish the revocation
+// list to the CDN bucket, so that installs download it from the CDN rather
+// than the app. The list is signed like revocations.json and written as
+// revocations-<hash>.json, a name that changes with its contents so it can
+// be cached forever, and then revocations-latest.json names it and is cached
+// for cdn.max_age. A task enqueued before the list was last read does
+// nothing, so a burst of revocations such as a bulk revocation publishes it
+// a few times rather than once per license. The hourly revocation file update
+// publishes it too, which renews its expiry.
+//
+// Example:
+//
+//	POST /api/tasks/cdn at=2026-10-14T11:00:00Z
+//	200 {"file": "revocations-3f2a9c1d7e6b5a40.json", "url": "https://cdn.example.com/revocations-3f2a9c1d7e6b5a40.json", "sha256": "...", "count": 12, ...}
+func PublishToCDN(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	if cfg.CDN.Location == "" {
+		writeJSON(w, 200, "SUCCESS")
+		return nil
+	}
+
+	sc, err := storage.Open(c, cfg.Storage.Backend, cfg.CDN.Location)
+
+	if err != nil {
+		return &appError{err, "Could not open the CDN bucket", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	latest, err := readCDNLatest(sc)
+
+	if err != nil {
+		return &appError{err, "Could not read the CDN's latest revocation list", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	// tasks without a time always publish
+	at, err := time.Parse(time.RFC3339Nano, r.FormValue("at"))
+
+	if err == nil && latest != nil && !latest.PublishedAt.Before(at) {
+		writeJSON(w, 200, latest)
+		return nil
+	}
+
+	revocations, err := env.Revocations(c)
+
+	if err != nil {
+		return &appError{err, "Could not open the revocation store", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	listed := time.Now().UTC()
+	list, err := revocations.List(c)
+
+	if err != nil {
+		return &appError{err, "An error occurred reading the revocation list", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	var formatted []string
+
+	for _, rev := range list {
+		formatted = append(formatted, rev.ID)
+	}
+
+	key, err := getPrivateKey(c, cfg.Keys.ID)
+
+	if err != nil {
+		return &appError{err, "The private key could not be retrieved", http.StatusInternalServerError, codeKeyUnavailable}
+	}
+
+	exp := listed.Add(cfg.Revocations.TTL)
+	body, err := signedFile(key, map[string]interface{}{"_revoked": formatted}, exp)
+
+	if err != nil {
+		return &appError{err, "Could not sign the revocation list", http.StatusInternalServerError, codeInternal}
+	}
+
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	next := &cdnLatest{
+		File:        cdnFile(hash[:16]),
+		SHA256:      hash,
+		Count:       len(formatted),
+		ExpiresAt:   exp,
+		PublishedAt: listed,
+	}
+
+	if cfg.CDN.URL != "" {
+		next.URL = strings.TrimSuffix(cfg.CDN.URL, "/") + "/" + next.File
+	}
+
+	// the list goes first so that the latest file never names a missing one
+	if err := publishCached(sc, next.File, body, cdnImmutable); err != nil {
+		return &appError{err, "An error occurred writing the revocation list to the CDN", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	// a task that read the revocations later may have finished first
+	if latest, err = readCDNLatest(sc); err == nil && latest != nil && latest.PublishedAt.After(listed) {
+		writeJSON(w, 200, latest)
+		return nil
+	}
+
+	data, err := json.Marshal(next)
+
+	if err != nil {
+		return &appError{err, "Could not encode the CDN's latest revocation list", http.StatusInternalServerError, codeInternal}
+	}
+
+	cacheControl := fmt.Sprintf("public, max-age=%d", int64(cfg.CDN.MaxAge/time.Second))
+
+	if err := publishCached(sc, cdnFile("latest"), data, cacheControl); err != nil {
+		return &appError{err, "An error occurred writing the CDN's latest revocation list", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	writeJSON(w, 200, next)
+	return nil
+}
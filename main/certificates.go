@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/pdf"
+	"github.com/volcanicpixels/licensing/qr"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// certificatePDF renders the certificate of a license, a page with the
+// details of the license and a QR code of its license string.
+func certificatePDF(lic *license.License, licStr string) ([]byte, error) {
+	code, err := licenseQR(licStr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &pdf.Document{Title: fmt.Sprintf("%v license %v", lic.Product, lic.ID)}
+	page := doc.AddPage(pdf.A4Width, pdf.A4Height)
+	w, h := page.Width, page.Height
+	center := w / 2
+
+	r, g, b := cfg.Certificate.RGB()
+	brand := func() { page.SetColor(float64(r)/255, float64(g)/255, float64(b)/255) }
+	black := func() { page.SetColor(0.1, 0.1, 0.1) }
+	grey := func() { page.SetColor(0.45, 0.45, 0.45) }
+
+	// the border and header
+	brand()
+	page.Rect(20, 20, w-40, 4)
+	page.Rect(20, h-24, w-40, 4)
+	page.Rect(20, 20, 4, h-40)
+	page.Rect(w-24, 20, 4, h-40)
+	page.Rect(20, h-110, w-40, 90)
+
+	page.SetColor(1, 1, 1)
+	page.Text(pdf.HelveticaBold, 22, 50, h-72, cfg.Certificate.Issuer)
+
+	black()
+	page.CenteredText(pdf.HelveticaBold, 26, center, h-160, "Certificate of License")
+
+	if lic.Test {
+		page.SetColor(0.75, 0.1, 0.1)
+		page.CenteredText(pdf.Helvetica, 10, center, h-180, "Sandbox license, not for use in production")
+	}
+
+	grey()
+	page.CenteredText(pdf.Helvetica, 12, center, h-205, "This certifies that")
+
+	name, email := lic.Name(), lic.Email()
+	holder := name
+
+	if holder == "" {
+		holder = email
+	}
+
+	if holder == "" {
+		holder = "the license holder"
+	}
+
+	black()
+	page.CenteredText(pdf.HelveticaBold, 20, center, h-233, holder)
+
+	if name != "" && email != "" {
+		grey()
+		page.CenteredText(pdf.Helvetica, 11, center, h-251, email)
+	}
+
+	grey()
+	page.CenteredText(pdf.Helvetica, 12, center, h-280, "is licensed to use")
+
+	product := lic.Product
+
+	if lic.Plan != "" {
+		product = fmt.Sprintf("%v (%v plan)", lic.Product, lic.Plan)
+	}
+
+	black()
+	page.CenteredText(pdf.HelveticaBold, 18, center, h-305, product)
+
+	expires := "Never"
+
+	if lic.ExpiresAt != nil {
+		expires = lic.ExpiresAt.UTC().Format("2 January 2006")
+	}
+
+	details := [][2]string{
+		{"License ID", lic.ID},
+		{"Issued", lic.IssuedAt.UTC().Format("2 January 2006")},
+		{"Expires", expires},
+	}
+
+	if lic.MaxActivations > 0 {
+		details = append(details, [2]string{"Activations", fmt.Sprint(lic.MaxActivations)})
+	}
+
+	if lic.Seats > 0 {
+		details = append(details, [2]string{"Seats", fmt.Sprint(lic.Seats)})
+	}
+
+	for i, d := range details {
+		y := h - 345 - float64(i)*18
+
+		grey()
+		page.Text(pdf.Helvetica, 10, 170, y, d[0])
+		black()
+		page.Text(pdf.Helvetica, 11, 270, y, d[1])
+	}
+
+	// the code with its quiet zone, each run of dark modules in a
+	// row is a rectangle. Module edges are on whole hundredths of a point so
+	// that neighbouring modules meet without hairline gaps.
+	const qrSize, qrBottom = 300, 95
+	module := float64(qrSize) / float64(code.Size+qr.QuietZone*2)
+	left, top := center-qrSize/2, float64(qrBottom+qrSize)
+	edge := func(i int) float64 { return math.Floor(float64(i+qr.QuietZone)*module*100) / 100 }
+
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if !code.Black(x, y) {
+				continue
+			}
+
+			run := 1
+
+			for code.Black(x+run, y) {
+				run++
+			}
+
+			page.Rect(left+edge(x), top-edge(y+1), edge(x+run)-edge(x), edge(y+1)-edge(y))
+			x += run
+		}
+	}
+
+	grey()
+	page.CenteredText(pdf.Helvetica, 9, center, qrBottom-10, "Scan the code to copy the license key")
+
+	var buf bytes.Buffer
+
+	if _, err := doc.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// LicenseCertificate handles GET requests to /api/licenses/{id}/certificate.pdf
+//
+// The response is a PDF certificate of a stored license for the customer's
+// records, with who it is licensed to, the product, its ID and expiry, and a
+// QR code of the license string, signed again so that it has the current
+// watermark. The certificate is branded with the certificate settings, and
+// revoked licenses don't have one.
+//
+// Example:
+//
+//	GET /api/licenses/daS7y8sioiecYy/certificate.pdf
+//	200 %PDF-1.4 ...
+func LicenseCertificate(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	lic, err := env.Licenses(c, requestNamespace(c)).Get(c, mux.Vars(r)["id"])
+
+	if err == store.ErrNotFound {
+		return &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if lic.RevokedAt != nil {
+		return &appError{errors.New("license revoked"), "Revoked licenses don't have certificates", http.StatusConflict, codeLicenseRevoked}
+	}
+
+	licStr, e := signLicense(c, lic)
+
+	if e != nil {
+		return e
+	}
+
+	data, err := certificatePDF(lic, licStr)
+
+	if err != nil {
+		return &appError{err, "An error occurred rendering the certificate", http.StatusInternalServerError, codeInternal}
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v-%v-certificate.pdf"`, lic.Product, lic.ID))
+	w.Write(data)
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/volcanicpixels/licensing/config"
+)
+
+// acceptsGzip reports whether the Accept-Encoding header of a request allows
+// gzip responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+
+		for _, p := range params[1:] {
+			if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+				if q, err := strconv.ParseFloat(p[2:], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// compressible reports whether a response with these headers is worth
+// compressing, images, PDFs and archives already are compressed.
+func compressible(h http.Header) bool {
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	switch t := h.Get("Content-Type"); {
+	case strings.HasPrefix(t, "image/"), strings.HasPrefix(t, "application/pdf"),
+		strings.HasPrefix(t, "application/gzip"), strings.HasPrefix(t, "application/zip"):
+		return false
+	}
+
+	return true
+}
+
+// gzipWriter holds back the start of a response until it has minSize bytes,
+// then compresses it if its headers allow. Responses that end sooner are
+// sent as they are.
+type gzipWriter struct {
+	http.ResponseWriter
+	minSize int
+	status  int
+	buf     []byte
+	started bool
+	zw      *gzip.Writer
+}
+
+func (gw *gzipWriter) WriteHeader(status int) {
+	if gw.status == 0 {
+		gw.status = status
+	}
+}
+
+func (gw *gzipWriter) Write(b []byte) (int, error) {
+	if gw.status == 0 {
+		gw.status = http.StatusOK
+	}
+
+	if gw.started {
+		if gw.zw != nil {
+			return gw.zw.Write(b)
+		}
+
+		return gw.ResponseWriter.Write(b)
+	}
+
+	gw.buf = append(gw.buf, b...)
+
+	if len(gw.buf) >= gw.minSize {
+		if err := gw.start(true); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// start sends the headers and what has been held back, compressing the rest
+// of the response if compress is set and the headers allow.
+func (gw *gzipWriter) start(compress bool) error {
+	gw.started = true
+	h := gw.Header()
+
+	if compress && compressible(h) {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		gw.zw = gzip.NewWriter(gw.ResponseWriter)
+	}
+
+	if gw.status != 0 {
+		gw.ResponseWriter.WriteHeader(gw.status)
+	}
+
+	if len(gw.buf) == 0 {
+		return nil
+	}
+
+	buf := gw.buf
+	gw.buf = nil
+
+	if gw.zw != nil {
+		_, err := gw.zw.Write(buf)
+		return err
+	}
+
+	_, err := gw.ResponseWriter.Write(buf)
+	return err
+}
+
+// close ends the response, sending it uncompressed if it never reached
+// minSize.
+func (gw *gzipWriter) close() error {
+	if !gw.started {
+		return gw.start(false)
+	}
+
+	if gw.zw != nil {
+		return gw.zw.Close()
+	}
+
+	return nil
+}
+
+// compressionMiddleware gzips responses of at least the configured size for
+// clients that accept it, it does nothing if compression is disabled.
+func compressionMiddleware(cfg config.Compression) func(http.Handler) http.Handler {
+	if cfg.MinSize == 0 {
+		return func(h http.Handler) http.Handler { return h }
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			if !acceptsGzip(r) || r.Method == "HEAD" {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			gw := &gzipWriter{ResponseWriter: w, minSize: cfg.MinSize}
+			defer gw.close()
+
+			h.ServeHTTP(gw, r)
+		})
+	}
+}
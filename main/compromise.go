@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// resignPageSize is how many licenses a call to ResignLicenses re-signs, so
+// that it stays well within the request deadline.
+const resignPageSize = 100
+
+// ResignLicenses handles POST requests to /api/keys/{id}/resign
+//
+// It re-signs up to 100 of the stored licenses signed with a compromised key
+// with their product's current key. The replacements are stored and, if
+// mail.issued is set, emailed to the customers of those that aren't revoked.
+// Re-signed licenses are no longer signed with the key, so it is called
+// again while more is set. sandbox=true re-signs test licenses. Licenses
+// stored before their key was recorded can't be found this way.
+//
+// Example:
+//
+//	POST /api/keys/plugin/resign
+//	200 {"resigned": 100, "more": true}
+func ResignLicenses(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	kid := mux.Vars(r)["id"]
+
+	if !cfg.IsCompromised(kid) {
+		return &appError{errors.New("key not compromised"), "Only licenses signed with a compromised key are re-signed", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	namespace := ""
+
+	if r.URL.Query().Get("sandbox") == "true" {
+		namespace = store.SandboxNamespace
+	}
+
+	licenses := env.Licenses(c, namespace)
+	q := store.Query{KeyID: kid, Limit: resignPageSize}
+
+	page, cursor, err := licenses.List(c, q)
+
+	if err != nil {
+		return &appError{err, "An error occurred listing the licenses", http.StatusInternalServerError, codeInternal}
+	}
+
+	resigned := 0
+
+	for _, lic := range page {
+		// the query may not have caught up with licenses just re-signed
+		if lic.KeyID != kid {
+			continue
+		}
+
+		if e := resignLicense(c, licenses, lic, cfg.Mail.Issued, map[string]string{"compromisedKey": kid}); e != nil {
+			return e
+		}
+
+		resigned++
+	}
+
+	writeJSON(w, 200, struct {
+		Resigned int  `json:"resigned"`
+		More     bool `json:"more"`
+	}{resigned, cursor != ""})
+
+	return nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/dchest/uniuri"
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// retainedAttrs are the license attributes that aren't personal data and are
+// kept when a customer is forgotten, the charge ID is needed to act on
+// refunds and the order ID to deduplicate orders.
+var retainedAttrs = []string{"chargeId", "orderId"}
+
+// customerLicenses returns every license issued to email, newest first.
+func customerLicenses(c context.Context, licenses store.Licenses, email string) ([]*license.License, error) {
+	var found []*license.License
+	q := store.Query{Email: email, Limit: maxListLimit}
+
+	for {
+		page, cursor, err := licenses.List(c, q)
+
+		if err != nil {
+			return nil, err
+		}
+
+		found = append(found, page...)
+
+		if cursor == "" {
+			return found, nil
+		}
+
+		q.Cursor = cursor
+	}
+}
+
+// forgetLicense removes the personal attributes from a license, and the
+// bookkeeping that may identify the customer or hold notes about them.
+func forgetLicense(lic *license.License) {
+	attrs := make(map[string]interface{})
+
+	for _, name := range retainedAttrs {
+		if v, ok := lic.Attrs[name]; ok {
+			attrs[name] = v
+		}
+	}
+
+	lic.Attrs, lic.Watermark = attrs, ""
+	lic.CustomerID, lic.Notes, lic.Metadata = "", "", nil
+	lic.Invoice, lic.ReceiptURL = "", ""
+}
+
+// forgetLicenses removes the personal data from every license issued to
+// email (see forgetLicense), and from their events, and the sites from their
+// activations, the licenses themselves are kept so that their IDs stay
+// revocable.
+func forgetLicenses(c context.Context, namespace string, email string) (int, error) {
+	licenses, activations := env.Licenses(c, namespace), env.Activations(c, namespace)
+
+	// every page is collected before changing anything, the updated licenses
+	// no longer match the query which would upset the cursor
+	found, err := customerLicenses(c, licenses, email)
+
+	if err != nil {
+		return 0, err
+	}
+
+	for i, lic := range found {
+		forgetLicense(lic)
+
+		if err := licenses.Put(c, lic); err != nil {
+			return i, err
+		}
+
+		// the log keeps every version of the license
+		if _, err := env.Events(c).Redact(c, lic.ID, forgetLicense); err != nil {
+			return i, err
+		}
+
+		list, err := activations.List(c, lic.ID)
+
+		if err != nil {
+			return i, err
+		}
+
+		for _, a := range list {
+			if a.Site == "" {
+				continue
+			}
+
+			// activating an install again replaces its site
+			a.Site = ""
+
+			if err := activations.Activate(c, a, 0); err != nil {
+				return i, err
+			}
+		}
+	}
+
+	return len(found), nil
+}
+
+// forgetSeats removes email from the licenses it is a named user or member
+// of, returning how many seats it had.
+func forgetSeats(c context.Context, namespace string, email string) (int, error) {
+	users := env.Users(c, namespace)
+	seats, err := users.Seats(c, normalizeEmail(email))
+
+	if err != nil {
+		return 0, err
+	}
+
+	for i, u := range seats {
+		if err := users.Remove(c, u.LicenseID, u.Email); err != nil && err != store.ErrNotFound {
+			return i, err
+		}
+	}
+
+	return len(seats), nil
+}
+
+// customerID returns the email address identifying the customer a request is
+// about.
+func customerID(r *http.Request) (string, *appError) {
+	email := mux.Vars(r)["id"]
+
+	if !strings.Contains(email, "@") {
+		err := errors.New("customer ID is not an email address")
+		return "", &appError{err, "Customers are identified by their email address", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	return email, nil
+}
+
+// ExportCustomer handles GET requests to /api/customers/{id}/export
+//
+// The response is everything held about the customer for data access
+// requests: their production and sandbox licenses, the licenses' activations,
+// the seats they have as a named user or member of licenses and the audit log
+// entries about them.
+//
+// Example:
+//
+//	GET /api/customers/jane@example.com/export
+//	200 {"customer": "jane@example.com", "exportedAt": "...", "licenses": [...], "seats": [...], "auditEvents": [...]}
+func ExportCustomer(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	email, e := customerID(r)
+
+	if e != nil {
+		return e
+	}
+
+	licenses := []*license.License{}
+	activations := []store.Activation{}
+	seats := []store.User{}
+
+	for _, namespace := range []string{"", store.SandboxNamespace} {
+		found, err := customerLicenses(c, env.Licenses(c, namespace), email)
+
+		if err != nil {
+			return &appError{err, "An error occurred finding the customer's licenses", http.StatusInternalServerError, codeInternal}
+		}
+
+		licenses = append(licenses, found...)
+
+		for _, lic := range found {
+			list, err := env.Activations(c, namespace).List(c, lic.ID)
+
+			if err != nil {
+				return &appError{err, "An error occurred finding the customer's activations", http.StatusInternalServerError, codeInternal}
+			}
+
+			activations = append(activations, list...)
+		}
+
+		held, err := env.Users(c, namespace).Seats(c, normalizeEmail(email))
+
+		if err != nil {
+			return &appError{err, "An error occurred finding the customer's seats", http.StatusInternalServerError, codeInternal}
+		}
+
+		seats = append(seats, held...)
+	}
+
+	events, err := env.Audit(c).List(c, email)
+
+	if err != nil {
+		return &appError{err, "An error occurred reading the audit log", http.StatusInternalServerError, codeInternal}
+	}
+
+	if events == nil {
+		events = []store.AuditEntry{}
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="customer-export.json"`)
+
+	writeJSON(w, 200, struct {
+		Customer    string             `json:"customer"`
+		ExportedAt  time.Time          `json:"exportedAt"`
+		Licenses    []*license.License `json:"licenses"`
+		Activations []store.Activation `json:"activations"`
+		Seats       []store.User       `json:"seats"`
+		AuditEvents []store.AuditEntry `json:"auditEvents"`
+	}{email, time.Now(), licenses, activations, seats, events})
+
+	return nil
+}
+
+// ForgetCustomer handles POST requests to /api/customers/{id}/forget
+//
+// Customers are identified by their email address. Their personal data is
+// removed from their licenses and activations, in production and the
+// sandbox, from the versions of their licenses in the event log, and from
+// the audit log where it is replaced with the erasure's ID. Their seats as a
+// named user or member of licenses are removed.
+// Nothing is revoked. The erasure itself is recorded in the audit log
+// without the address.
+//
+// Example:
+//
+//	POST /api/customers/jane@example.com/forget
+//	200 {"id": "forgotten:...", "licenses": 2, "seats": 1, "auditEntries": 3}
+func ForgetCustomer(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	email, e := customerID(r)
+
+	if e != nil {
+		return e
+	}
+
+	erasure := "forgotten:" + uniuri.New()
+	n, seats := 0, 0
+
+	for _, namespace := range []string{"", store.SandboxNamespace} {
+		forgotten, err := forgetLicenses(c, namespace, email)
+		n += forgotten
+
+		if err != nil {
+			return &appError{err, "An error occurred removing the customer from their licenses", http.StatusInternalServerError, codeInternal}
+		}
+
+		removed, err := forgetSeats(c, namespace, email)
+		seats += removed
+
+		if err != nil {
+			return &appError{err, "An error occurred removing the customer's seats", http.StatusInternalServerError, codeInternal}
+		}
+	}
+
+	entries, err := env.Audit(c).Anonymize(c, email, erasure)
+
+	if err != nil {
+		return &appError{err, "An error occurred removing the customer from the audit log", http.StatusInternalServerError, codeInternal}
+	}
+
+	err = audit(c, store.AuditEntry{
+		Action: "customer.forget",
+		Target: erasure,
+		Details: map[string]string{
+			"licenses":     strconv.Itoa(n),
+			"seats":        strconv.Itoa(seats),
+			"auditEntries": strconv.Itoa(entries),
+		},
+	})
+
+	if err != nil {
+		return &appError{err, "The customer was forgotten but the erasure could not be recorded", http.StatusInternalServerError, codeInternal}
+	}
+
+	writeJSON(w, 200, struct {
+		ID           string `json:"id"`
+		Licenses     int    `json:"licenses"`
+		Seats        int    `json:"seats"`
+		AuditEntries int    `json:"auditEntries"`
+	}{erasure, n, seats, entries})
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/store"
+)
+
+func TestCustomerSeats(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	id := createLicenseWith(t, s, map[string]interface{}{"product": "domain_changer", "seats": 5})
+
+	if status, _ := changeUser(t, s, "POST", id, "jane@example.com"); status != 200 {
+		t.Fatalf("got %v adding a user", status)
+	}
+
+	resp, err := s.Get("/api/customers/Jane@example.com/export")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var export struct {
+		Seats []store.User `json:"seats"`
+	}
+
+	if err := resp.Decode(&export); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(export.Seats) != 1 || export.Seats[0].LicenseID != id {
+		t.Errorf("exported seats %+v, want the seat of %v", export.Seats, id)
+	}
+
+	if resp, err = s.Post("/api/customers/jane@example.com/forget", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var forgotten struct {
+		Seats int `json:"seats"`
+	}
+
+	if err := resp.Decode(&forgotten); err != nil {
+		t.Fatal(err)
+	}
+
+	if forgotten.Seats != 1 {
+		t.Errorf("%v seats forgotten, want 1", forgotten.Seats)
+	}
+
+	if users, err := p.UserStore.List(context.Background(), id); err != nil || len(users) != 0 {
+		t.Errorf("the license still has users %v: %v", users, err)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/config"
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// watermark returns the watermark for a license with the configured
+// watermarking, empty if it is off or the license has no email address (as
+// after the customer is forgotten).
+func watermark(l *license.License) string {
+	email := strings.TrimSpace(l.Email())
+
+	if email == "" {
+		return ""
+	}
+
+	switch cfg.Licenses.Watermark {
+	case config.WatermarkPlain:
+		if name := strings.TrimSpace(l.Name()); name != "" {
+			return fmt.Sprintf("%v <%v>", name, email)
+		}
+
+		return email
+	case config.WatermarkHash:
+		// enough of the hash to tell customers apart, support can compute
+		// it from the address
+		sum := sha256.Sum256([]byte(strings.ToLower(email)))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	}
+
+	return ""
+}
+
+// licenseFile is where the .lic file of a license is kept for downloading
+// from storage.
+func licenseFile(l *license.License) string {
+	if l.Test {
+		return "licenses/sandbox/" + l.ID + ".lic"
+	}
+
+	return "licenses/" + l.ID + ".lic"
+}
+
+// licenseFileName is the name a license's .lic file downloads as.
+func licenseFileName(l *license.License) string {
+	return fmt.Sprintf("%v-%v.lic", l.Product, l.ID)
+}
+
+// licenseFileURL stores the .lic file of a license and returns a signed URL
+// of it, or an empty string if signed_urls is off or the storage can't sign
+// URLs. The file is written each time so that it has the current watermark.
+func licenseFileURL(c context.Context, l *license.License, data []byte) (string, error) {
+	if cfg.Storage.SignedURLs == 0 {
+		return "", nil
+	}
+
+	sc, err := newStorage(c)
+
+	if err != nil {
+		return "", err
+	}
+
+	file := licenseFile(l)
+
+	if err := sc.WriteFile(file, data); err != nil {
+		return "", err
+	}
+
+	return signedFileURL(sc, file, licenseFileName(l))
+}
+
+// DownloadLicense handles GET requests to /api/licenses/{id}/download
+//
+// It returns a stored license as a .lic file, signed again so that it has the
+// current watermark. Revoked licenses can't be downloaded. With signed_urls
+// the file is stored and the response redirects to a signed URL of it.
+//
+// Example:
+//
+//	GET /api/licenses/daS7y8sioiecYy/download
+//	200
+//	# License for domain_changer
+//	# ID: daS7y8sioiecYy
+//	# Licensed to: Jane Doe <jane@example.com>
+//	# Expires: never
+//	eyJhbGciOiJSUzI1NiIs...
+func DownloadLicense(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	lic, err := env.Licenses(c, requestNamespace(c)).Get(c, mux.Vars(r)["id"])
+
+	if err == store.ErrNotFound {
+		return &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if lic.RevokedAt != nil {
+		return &appError{errors.New("license revoked"), "Revoked licenses can't be downloaded", http.StatusConflict, codeLicenseRevoked}
+	}
+
+	licStr, e := signLicense(c, lic)
+
+	if e != nil {
+		return e
+	}
+
+	data := lic.EncodeFile(licStr)
+	url, err := licenseFileURL(c, lic, data)
+
+	if err != nil {
+		return &appError{err, "An error occurred storing the license file", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	if url != "" {
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, url, http.StatusFound)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v"`, licenseFileName(lic)))
+	w.Write(data)
+
+	return nil
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// maxDryRunLicenses is the most licenses a dry run of a bulk action goes
+// through, it answers straight away rather than in a job.
+const maxDryRunLicenses = 1000
+
+// maxDryRunIDs is how many of the licenses a dry run of a bulk action would
+// change it lists.
+const maxDryRunIDs = 20
+
+// dryRun reports whether a request asks for a dry run, with ?dry_run=true or
+// an X-Dry-Run: true header, which checks the request and answers with what
+// would happen without changing anything.
+func dryRun(r *http.Request) (bool, *appError) {
+	s := r.URL.Query().Get("dry_run")
+
+	if s == "" {
+		s = r.Header.Get("X-Dry-Run")
+	}
+
+	if s == "" {
+		return false, nil
+	}
+
+	dry, err := strconv.ParseBool(s)
+
+	if err != nil {
+		return false, &appError{&fieldError{Field: "dry_run", err: err}, "dry_run must be true or false", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	return dry, nil
+}
+
+// licensePreview is the license a create request would issue. Its ID and
+// issue time are those it would have if it were issued now, an issued
+// license gets new ones.
+type licensePreview struct {
+	DryRun  bool                   `json:"dryRun"`
+	License *license.License       `json:"license"`
+	Claims  map[string]interface{} `json:"claims"`
+	Format  string                 `json:"format"`
+
+	// Deduplicated is set if the order was already issued, License is then
+	// that license.
+	Deduplicated bool `json:"deduplicated,omitempty"`
+}
+
+// previewLicense answers a dry run of a create request with the license it
+// would issue, after the checks of issueLicense: the product and request,
+// the order, the product's cap, the API key's quota and the signing key and
+// certificate.
+func previewLicense(c context.Context, w http.ResponseWriter, req *createRequest, reseller string) *appError {
+	if e := checkProduct(c, req.Product); e != nil {
+		return e
+	}
+
+	lic := license.New(req.Product)
+	lic.Test = isSandbox(c)
+	lic.Reseller = reseller
+
+	if err := applyCreateRequest(lic, req); err != nil {
+		return &appError{err, err.Error(), http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	if lic.OrderID() != "" {
+		existing, e := orderedLicense(c, lic)
+
+		if e != nil {
+			return e
+		}
+
+		if existing != nil {
+			format := encodeFormat(c, existing, crossSignKeyID(existing.Product) != "" && !existing.Test)
+			writeJSON(w, 200, licensePreview{true, existing, existing.Claims(), format, true})
+			return nil
+		}
+	}
+
+	if e := checkCap(c, lic, req.IgnoreCap); e != nil {
+		return e
+	}
+
+	if e := checkKeyQuota(c, time.Now()); e != nil {
+		return e
+	}
+
+	if _, e := prepareSigning(c, lic); e != nil {
+		return e
+	}
+
+	format := encodeFormat(c, lic, crossSignKeyID(lic.Product) != "" && !lic.Test)
+	writeJSON(w, 200, licensePreview{true, lic, lic.Claims(), format, false})
+	return nil
+}
+
+// orderedLicense returns the license already issued for the order of a
+// create request, without claiming the order, or nil if there is none.
+func orderedLicense(c context.Context, lic *license.License) (*license.License, *appError) {
+	namespace := licenseNamespace(lic)
+	id, err := env.Orders(c, namespace).Get(c, lic.Product, lic.OrderID())
+
+	if err == store.ErrNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, &appError{err, "An error occurred checking the order", http.StatusInternalServerError, codeInternal}
+	}
+
+	return claimedLicense(c, lic, id)
+}
+
+// checkCap checks that a license could be counted against its product's cap
+// like takeCap, without counting it.
+func checkCap(c context.Context, lic *license.License, ignore bool) *appError {
+	limit := cfg.Products[lic.Product].Cap
+
+	if ignore && !isAdmin(c) {
+		return &appError{errors.New("ignore_cap without admin access"), "Only admins can ignore the product's cap", http.StatusForbidden, codeForbidden}
+	}
+
+	if limit == 0 || lic.Test || ignore {
+		return nil
+	}
+
+	n, err := env.Counters(c).Count(c, capCounter(lic.Product))
+
+	if err != nil {
+		return &appError{err, "An error occurred checking the product's cap", http.StatusInternalServerError, codeInternal}
+	}
+
+	if n >= limit {
+		return &appError{store.ErrCapReached, fmt.Sprintf("All %v licenses of %v have been issued", limit, lic.Product), http.StatusConflict, codeSoldOut}
+	}
+
+	return nil
+}
+
+// revocationPreview is what a revoke request would do.
+type revocationPreview struct {
+	DryRun bool   `json:"dryRun"`
+	ID     string `json:"id"`
+
+	// Stored is whether the license is stored, IDs of licenses issued
+	// before they were stored can still be revoked.
+	Stored bool `json:"stored"`
+
+	// Revoked is whether the license is already on the revocation list,
+	// revoking it again changes nothing.
+	Revoked bool `json:"revoked"`
+
+	// RevokeAt is when it would be revoked, if it would be scheduled.
+	RevokeAt *time.Time `json:"revokeAt,omitempty"`
+}
+
+// previewRevocation answers a dry run of a revoke request, at is when it
+// would be scheduled for or nil to revoke it straight away.
+func previewRevocation(c context.Context, w http.ResponseWriter, namespace, id string, at *time.Time) *appError {
+	lic, err := env.Licenses(c, namespace).Get(c, id)
+
+	if err != nil && err != store.ErrNotFound {
+		return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	preview := revocationPreview{DryRun: true, ID: id, Stored: lic != nil}
+
+	if at != nil {
+		if lic == nil {
+			return &appError{err, "License not found, only stored licenses can be revoked later", http.StatusNotFound, codeLicenseNotFound}
+		}
+
+		if lic.RevokedAt != nil {
+			return &appError{fmt.Errorf("license %v is revoked", id), "The license is already revoked", http.StatusConflict, codeLicenseRevoked}
+		}
+
+		revokeAt := at.UTC()
+		preview.RevokeAt = &revokeAt
+	}
+
+	revocations, err := listRevocations(c)
+
+	if err != nil {
+		return &appError{err, "An error occurred reading the revocation list", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	for _, rev := range revocations {
+		if rev.ID == id {
+			preview.Revoked = true
+			break
+		}
+	}
+
+	writeJSON(w, 200, preview)
+	return nil
+}
+
+// bulkPreview is what a bulk action would do to the licenses it goes through.
+type bulkPreview struct {
+	DryRun bool `json:"dryRun"`
+
+	// Matched counts the licenses that match the filter and aren't revoked,
+	// Changed those the action would change and IDs lists the first of
+	// them.
+	Matched int      `json:"matched"`
+	Changed int      `json:"changed"`
+	IDs     []string `json:"ids"`
+
+	// Complete is false if there were more licenses than a dry run goes
+	// through, the counts are then of the first of them.
+	Complete bool `json:"complete"`
+}
+
+// previewBulk answers a dry run of a checked bulk action with how many of
+// the first maxDryRunLicenses licenses it would change.
+func previewBulk(c context.Context, w http.ResponseWriter, params map[string]string) *appError {
+	preview := bulkPreview{DryRun: true, IDs: []string{}, Complete: true}
+	cursor, scanned := "", 0
+
+	for {
+		q, err := bulkQuery(params, cursor)
+
+		if err != nil {
+			return &appError{err, "Invalid filter", http.StatusBadRequest, codeInvalidRequest}
+		}
+
+		var licenses []*license.License
+
+		if licenses, cursor, err = env.Licenses(c, "").List(c, q); err != nil {
+			return &appError{err, "An error occurred listing the licenses", http.StatusInternalServerError, codeInternal}
+		}
+
+		for _, lic := range licenses {
+			scanned++
+
+			if lic.RevokedAt != nil {
+				continue
+			}
+
+			preview.Matched++
+			changed, err := changeBulk(params, lic)
+
+			if err != nil {
+				return &appError{err, "An error occurred applying the action", http.StatusInternalServerError, codeInternal}
+			}
+
+			if !changed {
+				continue
+			}
+
+			preview.Changed++
+
+			if len(preview.IDs) < maxDryRunIDs {
+				preview.IDs = append(preview.IDs, lic.ID)
+			}
+		}
+
+		if cursor == "" {
+			break
+		}
+
+		if scanned >= maxDryRunLicenses {
+			preview.Complete = false
+			break
+		}
+	}
+
+	writeJSON(w, 200, preview)
+	return nil
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// eddResponse is the response of Easy Digital Downloads Software Licensing
+// to its license actions, which EDD clients decode without our envelope.
+type eddResponse struct {
+	Success bool   `json:"success"`
+	License string `json:"license"`
+	Error   string `json:"error,omitempty"`
+
+	ItemID          int         `json:"item_id,omitempty"`
+	ItemName        string      `json:"item_name,omitempty"`
+	Expires         string      `json:"expires,omitempty"`
+	LicenseLimit    *int        `json:"license_limit,omitempty"`
+	SiteCount       *int        `json:"site_count,omitempty"`
+	ActivationsLeft interface{} `json:"activations_left,omitempty"` // a number or "unlimited"
+	CustomerName    string      `json:"customer_name,omitempty"`
+	CustomerEmail   string      `json:"customer_email,omitempty"`
+}
+
+// eddVersion is EDD's response to get_version, as read by its plugin
+// updater.
+type eddVersion struct {
+	NewVersion    string            `json:"new_version"`
+	StableVersion string            `json:"stable_version"`
+	Name          string            `json:"name"`
+	Slug          string            `json:"slug"`
+	Package       string            `json:"package"`
+	DownloadLink  string            `json:"download_link"`
+	Requires      string            `json:"requires,omitempty"`
+	Tested        string            `json:"tested,omitempty"`
+	RequiresPHP   string            `json:"requires_php,omitempty"`
+	Sections      map[string]string `json:"sections"`
+}
+
+// writeEDD writes a response as EDD does, as bare JSON.
+func writeEDD(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(v)
+}
+
+// eddSite normalizes the url an EDD client sends the way EDD does, so that
+// http://www.example.com/ and https://example.com are the same activation.
+func eddSite(url string) string {
+	site := strings.ToLower(strings.TrimSpace(url))
+
+	for _, prefix := range []string{"https://", "http://", "www."} {
+		site = strings.TrimPrefix(site, prefix)
+	}
+
+	return strings.TrimRight(site, "/")
+}
+
+// eddItemProduct returns the product of the EDD item a request names with
+// item_id or item_name, which may also be our product ID. It returns false
+// if the request names no item.
+func eddItemProduct(r *http.Request) (string, bool) {
+	id, _ := strconv.Atoi(r.FormValue("item_id"))
+	name := strings.TrimSpace(r.FormValue("item_name"))
+
+	if id == 0 && name == "" {
+		return "", false
+	}
+
+	for product, p := range cfg.Products {
+		if id != 0 && id == p.EDDItemID {
+			return product, true
+		}
+
+		if name != "" && (name == product || strings.EqualFold(name, p.EDDItemName)) {
+			return product, true
+		}
+	}
+
+	return "", true
+}
+
+// eddExpires formats the expiry of a license as EDD does.
+func eddExpires(lic *license.License) string {
+	if lic.ExpiresAt == nil {
+		return "lifetime"
+	}
+
+	return lic.ExpiresAt.UTC().Format("2006-01-02 15:04:05")
+}
+
+// newEDDResponse returns the response describing a license and its
+// activations.
+func newEDDResponse(lic *license.License, vd *verdict) *eddResponse {
+	p := cfg.Products[lic.Product]
+	resp := &eddResponse{
+		Success:       true,
+		ItemID:        p.EDDItemID,
+		ItemName:      p.EDDItemName,
+		Expires:       eddExpires(lic),
+		CustomerName:  lic.Name(),
+		CustomerEmail: lic.Email(),
+	}
+
+	if resp.ItemName == "" {
+		resp.ItemName = lic.Product
+	}
+
+	if vd.Activations != nil {
+		resp.LicenseLimit = &vd.Activations.Allowed
+		resp.SiteCount = &vd.Activations.Used
+		resp.ActivationsLeft = "unlimited"
+
+		if vd.Activations.Allowed > 0 {
+			resp.ActivationsLeft = vd.Activations.Allowed - vd.Activations.Used
+		}
+	}
+
+	return resp
+}
+
+// eddStatus maps the status of a license to the one EDD would report.
+func eddStatus(status string) string {
+	switch status {
+	case statusValid:
+		return "valid"
+	case statusExpired:
+		return "expired"
+	case statusRevoked:
+		return "disabled"
+	case statusRegionRestricted:
+		return "license_not_activable"
+	default:
+		return "invalid"
+	}
+}
+
+// eddRefusal is the response to an action on an invalid license or, unless
+// missing is set, a license for another item than the one the client named.
+func eddRefusal(action string, missing bool) *eddResponse {
+	switch {
+	case action == "deactivate_license":
+		return &eddResponse{License: "failed"}
+	case action == "activate_license" && missing:
+		return &eddResponse{License: "invalid", Error: "missing"}
+	case action == "activate_license":
+		return &eddResponse{License: "invalid", Error: "item_name_mismatch"}
+	case missing:
+		return &eddResponse{License: "invalid"}
+	default:
+		return &eddResponse{License: "item_name_mismatch"}
+	}
+}
+
+// EDDAction handles GET and POST requests to /api/edd
+//
+// It answers the edd_action query API of Easy Digital Downloads Software
+// Licensing, so plugins with EDD's client code can point it here unchanged.
+// The license parameter is the encoded license, url is the site, and
+// item_id or item_name (an EDD item configured on a product, or the product
+// ID) is the product the client expects. Sites are activations with the
+// normalized url as the fingerprint.
+//
+// The actions are activate_license, check_license, deactivate_license and
+// get_version, which describes the product's release for EDD's updater.
+//
+// Example:
+//
+//	POST /api/edd edd_action=activate_license&license=eyJhbGciOiJSUzI1NiIs...&item_name=domain_changer&url=https://example.com
+//	200 {"success": true, "license": "valid", "item_name": "domain_changer", "expires": "lifetime", "license_limit": 3, "site_count": 1, "activations_left": 2}
+func EDDAction(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	action := r.FormValue("edd_action")
+
+	switch action {
+	case "activate_license", "check_license", "deactivate_license", "get_version":
+	default:
+		return &appError{errors.New("unknown edd_action"), "Unknown edd_action, it must be activate_license, check_license, deactivate_license or get_version", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	v := newValidator(c)
+	v.country = requestCountry(r)
+	lic, err := v.parse(strings.TrimSpace(r.FormValue("license")))
+
+	if _, invalid := err.(*invalidError); invalid {
+		lic = nil
+	} else if err != nil {
+		return &appError{err, "Could not load public key for verifying", http.StatusInternalServerError, codeKeyUnavailable}
+	}
+
+	item, named := eddItemProduct(r)
+
+	if action == "get_version" {
+		return eddVersionAction(c, w, r, v, lic, item)
+	}
+
+	if lic == nil || (named && !lic.Covers(item)) {
+		writeEDD(w, eddRefusal(action, lic == nil))
+		return nil
+	}
+
+	site := eddSite(r.FormValue("url"))
+	activations := env.Activations(c, licenseNamespace(lic))
+
+	if action != "check_license" && checkFingerprint(site) != nil {
+		writeEDD(w, &eddResponse{License: "invalid", Error: "missing_url"})
+		return nil
+	}
+
+	vd, err := v.check(lic)
+
+	if err != nil {
+		return &appError{err, "An error occurred validating the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	active, err := activations.List(c, lic.ID)
+
+	if err != nil {
+		return &appError{err, "Could not load the license's activations", http.StatusInternalServerError, codeInternal}
+	}
+
+	siteActive := false
+
+	for _, a := range active {
+		if a.Fingerprint == site {
+			siteActive = true
+		}
+	}
+
+	resp := newEDDResponse(lic, vd)
+
+	switch action {
+	case "check_license":
+		resp.License = eddStatus(vd.Status)
+
+		if vd.Valid && site != "" && !siteActive {
+			resp.License = "site_inactive"
+		}
+	case "deactivate_license":
+		if !siteActive {
+			writeEDD(w, &eddResponse{License: "failed"})
+			return nil
+		}
+
+		if err := activations.Deactivate(c, lic.ID, site); err != nil {
+			return &appError{err, "An error occurred deactivating the license", http.StatusInternalServerError, codeInternal}
+		}
+
+		resp = &eddResponse{Success: true, License: "deactivated"}
+	case "activate_license":
+		if !vd.Valid {
+			resp.Success = false
+			resp.License = "invalid"
+			resp.Error = eddStatus(vd.Status)
+			break
+		}
+
+		now := time.Now()
+		a := store.Activation{
+			LicenseID:   lic.ID,
+			Fingerprint: site,
+			Site:        strings.TrimSpace(r.FormValue("url")),
+			ActivatedAt: now,
+			LastSeenAt:  now,
+		}
+
+		err := activations.Activate(c, a, activationLimit(lic))
+
+		if err == store.ErrActivationLimit {
+			resp.Success = false
+			resp.License = "invalid"
+			resp.Error = "no_activations_left"
+			break
+		}
+
+		if err != nil {
+			return &appError{err, "An error occurred activating the license", http.StatusInternalServerError, codeInternal}
+		}
+
+		// the verdict is checked again to count the new activation
+		if vd, err = v.check(lic); err != nil {
+			return &appError{err, "An error occurred validating the license", http.StatusInternalServerError, codeInternal}
+		}
+
+		resp = newEDDResponse(lic, vd)
+		resp.License = "valid"
+	}
+
+	writeEDD(w, resp)
+	return nil
+}
+
+// eddVersionAction answers get_version with the release of the license's
+// product, or of the item if the license is invalid or a bundle including it. The package is only
+// set for licenses that are valid or in their grace period and cover the
+// release.
+func eddVersionAction(c context.Context, w http.ResponseWriter, r *http.Request, v *validator, lic *license.License, item string) *appError {
+	product := item
+
+	if lic != nil && !lic.Covers(item) {
+		product = lic.Product
+	}
+
+	p, ok := cfg.Products[product]
+
+	if !ok || p.Release.Version == "" {
+		return &appError{errors.New("no release"), "Unknown product", http.StatusNotFound, codeProductUnknown}
+	}
+
+	name := p.EDDItemName
+
+	if name == "" {
+		name = product
+	}
+
+	resp := &eddVersion{
+		NewVersion:    p.Release.Version,
+		StableVersion: p.Release.Version,
+		Name:          name,
+		Slug:          r.FormValue("slug"),
+		Requires:      p.Release.Requires,
+		Tested:        p.Release.Tested,
+		RequiresPHP:   p.Release.RequiresPHP,
+		Sections:      map[string]string{"changelog": p.Release.Changelog},
+	}
+
+	if lic != nil && p.Release.Package != "" {
+		vd, err := v.check(lic)
+
+		if err != nil {
+			return &appError{err, "An error occurred validating the license", http.StatusInternalServerError, codeInternal}
+		}
+
+		if (vd.Valid || vd.InGrace) && lic.AllowsVersion(p.Release.Version) {
+			url, _, err := downloadURL(c, r, lic, product)
+
+			if err != nil {
+				return &appError{err, "Could not sign the download URL", http.StatusInternalServerError, codeInternal}
+			}
+
+			resp.Package = url
+			resp.DownloadLink = url
+		}
+	}
+
+	writeEDD(w, resp)
+	return nil
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/mail"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// The emails sent to customers.
+const (
+	emailIssued         = "issued"
+	emailExpiryReminder = "expiry-reminder"
+	emailRevoked        = "revoked"
+	emailSeatInvite     = "seat-invite"
+	emailRecovery       = "recovery"
+	emailDeactivated    = "deactivated"
+)
+
+// defaultLocale is the locale every email has a template in, used when
+// there is none for the customer's.
+const defaultLocale = "en"
+
+// emailTemplate is a text/template for the subject and body of an email,
+// executed with emailData.
+type emailTemplate struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// emailData is what email templates can use, fields that don't apply to an
+// email are empty.
+type emailData struct {
+	Name      string
+	Product   string
+	LicenseID string
+	ExpiresAt string // 2006-01-02, empty for perpetual licenses
+
+	// License is the encoded license, for the issued and seat-invite
+	// emails.
+	License string
+
+	// Link is the link to the customer's license keys, for the recovery
+	// email.
+	Link string
+
+	// Reason is payments.Refund or payments.Chargeback for the revoked
+	// email, or empty if the license was revoked by hand.
+	Reason string
+
+	// Site is the site of the activation, and LastSeen when it was last
+	// seen (2006-01-02), for the deactivated email.
+	Site     string
+	LastSeen string
+}
+
+// emailTemplates are the built-in templates of each email by locale, they
+// can be replaced and added to with the email template API.
+var emailTemplates = map[string]map[string]emailTemplate{
+	emailIssued: {
+		"en": {
+			Subject: "Your {{.Product}} license",
+			Body: `{{if .Name}}Hi {{.Name}},{{else}}Hi,{{end}}
+
+Thank you for your purchase. Your {{.Product}} license key is:
+
+{{.License}}
+
+License ID: {{.LicenseID}}
+{{if .ExpiresAt}}It is valid until {{.ExpiresAt}}.{{else}}It never expires.{{end}}
+
+Keep this email, you will need the license key to install {{.Product}}.
+`,
+		},
+		"de": {
+			Subject: "Ihre {{.Product}}-Lizenz",
+			Body: `{{if .Name}}Hallo {{.Name}},{{else}}Hallo,{{end}}
+
+vielen Dank für Ihren Kauf. Ihr Lizenzschlüssel für {{.Product}} lautet:
+
+{{.License}}
+
+Lizenz-ID: {{.LicenseID}}
+{{if .ExpiresAt}}Die Lizenz ist gültig bis {{.ExpiresAt}}.{{else}}Die Lizenz läuft nie ab.{{end}}
+
+Bitte bewahren Sie diese E-Mail auf, Sie benötigen den Lizenzschlüssel für die Installation von {{.Product}}.
+`,
+		},
+		"fr": {
+			Subject: "Votre licence {{.Product}}",
+			Body: `{{if .Name}}Bonjour {{.Name}},{{else}}Bonjour,{{end}}
+
+Merci pour votre achat. Votre clé de licence {{.Product}} est :
+
+{{.License}}
+
+ID de licence : {{.LicenseID}}
+{{if .ExpiresAt}}Elle est valable jusqu'au {{.ExpiresAt}}.{{else}}Elle n'expire jamais.{{end}}
+
+Conservez cet e-mail, la clé de licence vous sera demandée pour installer {{.Product}}.
+`,
+		},
+		"es": {
+			Subject: "Su licencia de {{.Product}}",
+			Body: `{{if .Name}}Hola {{.Name}}:{{else}}Hola:{{end}}
+
+Gracias por su compra. Su clave de licencia de {{.Product}} es:
+
+{{.License}}
+
+ID de licencia: {{.LicenseID}}
+{{if .ExpiresAt}}Es válida hasta el {{.ExpiresAt}}.{{else}}No caduca nunca.{{end}}
+
+Guarde este correo, necesitará la clave de licencia para instalar {{.Product}}.
+`,
+		},
+	},
+	emailExpiryReminder: {
+		"en": {
+			Subject: "Your {{.Product}} license expires on {{.ExpiresAt}}",
+			Body: `{{if .Name}}Hi {{.Name}},{{else}}Hi,{{end}}
+
+Your {{.Product}} license {{.LicenseID}} expires on {{.ExpiresAt}}. Renew it before then to keep receiving updates and support.
+`,
+		},
+		"de": {
+			Subject: "Ihre {{.Product}}-Lizenz läuft am {{.ExpiresAt}} ab",
+			Body: `{{if .Name}}Hallo {{.Name}},{{else}}Hallo,{{end}}
+
+Ihre {{.Product}}-Lizenz {{.LicenseID}} läuft am {{.ExpiresAt}} ab. Verlängern Sie sie vorher, um weiterhin Updates und Support zu erhalten.
+`,
+		},
+		"fr": {
+			Subject: "Votre licence {{.Product}} expire le {{.ExpiresAt}}",
+			Body: `{{if .Name}}Bonjour {{.Name}},{{else}}Bonjour,{{end}}
+
+Votre licence {{.Product}} {{.LicenseID}} expire le {{.ExpiresAt}}. Renouvelez-la avant cette date pour continuer à recevoir les mises à jour et l'assistance.
+`,
+		},
+		"es": {
+			Subject: "Su licencia de {{.Product}} caduca el {{.ExpiresAt}}",
+			Body: `{{if .Name}}Hola {{.Name}}:{{else}}Hola:{{end}}
+
+Su licencia de {{.Product}} {{.LicenseID}} caduca el {{.ExpiresAt}}. Renuévela antes de esa fecha para seguir recibiendo actualizaciones y soporte.
+`,
+		},
+	},
+	emailRevoked: {
+		"en": {
+			Subject: "Your {{.Product}} license has been revoked",
+			Body: `{{if .Name}}Hi {{.Name}},{{else}}Hi,{{end}}
+
+Your {{.Product}} license {{.LicenseID}} has been revoked{{if eq .Reason "refund"}} because its payment was refunded{{else if eq .Reason "chargeback"}} because its payment was disputed{{end}}.
+
+If you think this is a mistake, reply to this email with the license ID.
+`,
+		},
+		"de": {
+			Subject: "Ihre {{.Product}}-Lizenz wurde widerrufen",
+			Body: `{{if .Name}}Hallo {{.Name}},{{else}}Hallo,{{end}}
+
+Ihre {{.Product}}-Lizenz {{.LicenseID}} wurde widerrufen{{if eq .Reason "refund"}}, da die Zahlung erstattet wurde{{else if eq .Reason "chargeback"}}, da die Zahlung angefochten wurde{{end}}.
+
+Falls Sie dies für einen Irrtum halten, antworten Sie bitte mit der Lizenz-ID auf diese E-Mail.
+`,
+		},
+		"fr": {
+			Subject: "Votre licence {{.Product}} a été révoquée",
+			Body: `{{if .Name}}Bonjour {{.Name}},{{else}}Bonjour,{{end}}
+
+Votre licence {{.Product}} {{.LicenseID}} a été révoquée{{if eq .Reason "refund"}} car son paiement a été remboursé{{else if eq .Reason "chargeback"}} car son paiement a été contesté{{end}}.
+
+Si vous pensez qu'il s'agit d'une erreur, répondez à cet e-mail en indiquant l'ID de licence.
+`,
+		},
+		"es": {
+			Subject: "Su licencia de {{.Product}} ha sido revocada",
+			Body: `{{if .Name}}Hola {{.Name}}:{{else}}Hola:{{end}}
+
+Su licencia de {{.Product}} {{.LicenseID}} ha sido revocada{{if eq .Reason "refund"}} porque su pago fue reembolsado{{else if eq .Reason "chargeback"}} porque su pago fue disputado{{end}}.
+
+Si cree que se trata de un error, responda a este correo indicando el ID de licencia.
+`,
+		},
+	},
+	emailSeatInvite: {
+		"en": {
+			Subject: "You have a seat of {{.Product}}",
+			Body: `Hi,
+
+You have been given a seat of the {{.Product}} license {{.LicenseID}}{{if .Name}} by {{.Name}}{{end}}. Activate {{.Product}} with this email address and the license key:
+
+{{.License}}
+`,
+		},
+		"de": {
+			Subject: "Sie haben einen Platz für {{.Product}}",
+			Body: `Hallo,
+
+Sie haben einen Platz der {{.Product}}-Lizenz {{.LicenseID}}{{if .Name}} von {{.Name}}{{end}} erhalten. Aktivieren Sie {{.Product}} mit dieser E-Mail-Adresse und dem Lizenzschlüssel:
+
+{{.License}}
+`,
+		},
+		"fr": {
+			Subject: "Vous avez une place sur {{.Product}}",
+			Body: `Bonjour,
+
+Vous avez reçu une place sur la licence {{.Product}} {{.LicenseID}}{{if .Name}} de {{.Name}}{{end}}. Activez {{.Product}} avec cette adresse e-mail et la clé de licence :
+
+{{.License}}
+`,
+		},
+		"es": {
+			Subject: "Tiene un puesto de {{.Product}}",
+			Body: `Hola:
+
+Ha recibido un puesto de la licencia de {{.Product}} {{.LicenseID}}{{if .Name}} de {{.Name}}{{end}}. Active {{.Product}} con esta dirección de correo y la clave de licencia:
+
+{{.License}}
+`,
+		},
+	},
+	emailRecovery: {
+		"en": {
+			Subject: "Your license keys",
+			Body: `{{if .Name}}Hi {{.Name}},{{else}}Hi,{{end}}
+
+The license keys issued to this email address are at this link:
+
+{{.Link}}
+
+The link only works for a short time. If you didn't ask for it you can ignore this email.
+`,
+		},
+		"de": {
+			Subject: "Ihre Lizenzschlüssel",
+			Body: `{{if .Name}}Hallo {{.Name}},{{else}}Hallo,{{end}}
+
+die Lizenzschlüssel, die auf diese E-Mail-Adresse ausgestellt wurden, finden Sie unter diesem Link:
+
+{{.Link}}
+
+Der Link ist nur kurze Zeit gültig. Falls Sie ihn nicht angefordert haben, können Sie diese E-Mail ignorieren.
+`,
+		},
+		"fr": {
+			Subject: "Vos clés de licence",
+			Body: `{{if .Name}}Bonjour {{.Name}},{{else}}Bonjour,{{end}}
+
+Les clés de licence émises pour cette adresse e-mail se trouvent à ce lien :
+
+{{.Link}}
+
+Le lien n'est valable que peu de temps. Si vous ne l'avez pas demandé, vous pouvez ignorer cet e-mail.
+`,
+		},
+		"es": {
+			Subject: "Sus claves de licencia",
+			Body: `{{if .Name}}Hola {{.Name}}:{{else}}Hola:{{end}}
+
+Las claves de licencia emitidas para esta dirección de correo están en este enlace:
+
+{{.Link}}
+
+El enlace solo funciona durante poco tiempo. Si no lo ha solicitado, puede ignorar este correo.
+`,
+		},
+	},
+	emailDeactivated: {
+		"en": {
+			Subject: "An activation of your {{.Product}} license was freed",
+			Body: `{{if .Name}}Hi {{.Name}},{{else}}Hi,{{end}}
+
+The install of {{.Product}}{{if .Site}} on {{.Site}}{{end}} hasn't been seen since {{.LastSeen}}, so it was deactivated and its activation of license {{.LicenseID}} is free again.
+
+If the install is still in use, it is activated again the next time it is started.
+`,
+		},
+		"de": {
+			Subject: "Eine Aktivierung Ihrer {{.Product}}-Lizenz wurde freigegeben",
+			Body: `{{if .Name}}Hallo {{.Name}},{{else}}Hallo,{{end}}
+
+die Installation von {{.Product}}{{if .Site}} auf {{.Site}}{{end}} wurde seit dem {{.LastSeen}} nicht mehr gesehen. Sie wurde deshalb deaktiviert, und ihre Aktivierung der Lizenz {{.LicenseID}} ist wieder frei.
+
+Falls die Installation noch verwendet wird, wird sie beim nächsten Start erneut aktiviert.
+`,
+		},
+		"fr": {
+			Subject: "Une activation de votre licence {{.Product}} a été libérée",
+			Body: `{{if .Name}}Bonjour {{.Name}},{{else}}Bonjour,{{end}}
+
+L'installation de {{.Product}}{{if .Site}} sur {{.Site}}{{end}} n'a pas été vue depuis le {{.LastSeen}}. Elle a donc été désactivée et son activation de la licence {{.LicenseID}} est de nouveau libre.
+
+Si l'installation est toujours utilisée, elle sera réactivée à son prochain démarrage.
+`,
+		},
+		"es": {
+			Subject: "Se ha liberado una activación de su licencia de {{.Product}}",
+			Body: `{{if .Name}}Hola {{.Name}}:{{else}}Hola:{{end}}
+
+La instalación de {{.Product}}{{if .Site}} en {{.Site}}{{end}} no se ha visto desde el {{.LastSeen}}, por lo que se ha desactivado y su activación de la licencia {{.LicenseID}} vuelve a estar libre.
+
+Si la instalación sigue en uso, se activará de nuevo la próxima vez que se inicie.
+`,
+		},
+	},
+}
+
+// canonicalLocale writes a locale as a lower case language and an upper case
+// region, e.g. "pt_br" as "pt-BR".
+func canonicalLocale(locale string) string {
+	parts := strings.SplitN(strings.Replace(strings.TrimSpace(locale), "_", "-", -1), "-", 2)
+	parts[0] = strings.ToLower(parts[0])
+
+	if len(parts) == 2 {
+		parts[1] = strings.ToUpper(parts[1])
+	}
+
+	return strings.Join(parts, "-")
+}
+
+// lookupEmailTemplate returns the template of an email to write in a locale,
+// falling back from a regional locale ("pt-BR") to its language ("pt") and
+// then to English. At each step a stored template replaces the built-in one.
+func lookupEmailTemplate(c context.Context, kind, locale string) (*emailTemplate, error) {
+	locale = canonicalLocale(locale)
+	candidates := []string{locale, strings.SplitN(locale, "-", 2)[0], defaultLocale}
+
+	for _, l := range candidates {
+		if l == "" {
+			continue
+		}
+
+		st, err := env.EmailTemplates(c).Get(c, kind, l)
+
+		if err == nil {
+			return &emailTemplate{st.Subject, st.Body}, nil
+		}
+
+		if err != store.ErrNotFound {
+			return nil, err
+		}
+
+		if t, ok := emailTemplates[kind][l]; ok {
+			return &t, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no %v email template", kind)
+}
+
+// renderEmail executes an email template, it fails if either part doesn't
+// parse or uses a variable that emailData doesn't have.
+func renderEmail(kind string, t *emailTemplate, data emailData) (*mail.Message, error) {
+	msg := &mail.Message{}
+
+	for _, part := range []struct {
+		text string
+		out  *string
+	}{{t.Subject, &msg.Subject}, {t.Body, &msg.Body}} {
+		tmpl, err := template.New(kind).Parse(part.text)
+
+		if err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+
+		*part.out = buf.String()
+	}
+
+	// a subject can't span lines
+	msg.Subject = strings.TrimSpace(strings.Replace(msg.Subject, "\n", " ", -1))
+
+	return msg, nil
+}
+
+// licenseEmailData fills in the fields of data that come from a license.
+func licenseEmailData(lic *license.License, data emailData) emailData {
+	data.Name = lic.Name()
+	data.Product = lic.Product
+	data.LicenseID = lic.ID
+
+	if lic.ExpiresAt != nil {
+		data.ExpiresAt = lic.ExpiresAt.UTC().Format("2006-01-02")
+	}
+
+	return data
+}
+
+// mailCustomer sends an email to the customer of a license in their locale,
+// filling in the license's fields of data. It does nothing if no sender is
+// configured or the license has no email.
+func mailCustomer(c context.Context, kind string, lic *license.License, data emailData) error {
+	return mailAbout(c, kind, lic.Email(), lic, data)
+}
+
+// mailAbout sends an email about a license to someone, such as a member of
+// the customer's team, in the customer's locale. It does nothing if no sender
+// is configured or to is empty.
+func mailAbout(c context.Context, kind, to string, lic *license.License, data emailData) error {
+	if cfg.Mail.Sender == "" || to == "" {
+		return nil
+	}
+
+	t, err := lookupEmailTemplate(c, kind, lic.Locale())
+
+	if err != nil {
+		return err
+	}
+
+	msg, err := renderEmail(kind, t, licenseEmailData(lic, data))
+
+	if err != nil {
+		return err
+	}
+
+	msg.To = to
+	return env.Mailer(c).Send(c, msg)
+}
+
+// SendExpiryReminders handles GET requests to /api/jobs/expiry-reminders
+//
+// It is run daily by cron and emails the customers of active licenses that
+// expire in the day that starts mail.expiry_reminder from now, so each
+// license is reminded once.
+//
+// Example:
+//
+//	GET /api/jobs/expiry-reminders
+//	200 {"sent": 3}
+func SendExpiryReminders(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	sent := 0
+
+	if cfg.Mail.ExpiryReminder > 0 && cfg.Mail.Sender != "" {
+		from := time.Now().Add(cfg.Mail.ExpiryReminder)
+		q := store.Query{
+			Status:         license.StatusActive,
+			ExpiringAfter:  from,
+			ExpiringBefore: from.Add(24 * time.Hour),
+			Order:          store.OrderExpiry,
+			Limit:          maxListLimit,
+		}
+
+		for {
+			licenses, cursor, err := env.Licenses(c, "").List(c, q)
+
+			if err != nil {
+				return &appError{err, "An error occurred listing the expiring licenses", http.StatusInternalServerError, codeInternal}
+			}
+
+			for _, lic := range licenses {
+				if lic.Email() == "" {
+					continue
+				}
+
+				if err := mailCustomer(c, emailExpiryReminder, lic, emailData{}); err != nil {
+					env.Errorf(c, "Could not email the expiry reminder for %v: %v", lic.ID, err)
+					continue
+				}
+
+				sent++
+			}
+
+			if cursor == "" {
+				break
+			}
+
+			q.Cursor = cursor
+		}
+	}
+
+	writeJSON(w, 200, struct {
+		Sent int `json:"sent"`
+	}{sent})
+
+	return nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/payments"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// localePattern matches canonical locales such as "de" and "pt-BR".
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}(-[A-Z0-9]{2,3})?$`)
+
+// sampleEmailData is what templates are checked and previewed with, every
+// field is set so that conditional parts are shown.
+var sampleEmailData = emailData{
+	Name:      "Jane Doe",
+	Product:   "domain_changer",
+	LicenseID: "daS7y8sioiecYy",
+	ExpiresAt: "2027-01-31",
+	License:   "eyJhbGciOiJSUzI1NiIs...",
+	Link:      "https://licensing.example.com/api/licenses/recover/eyJhbGciOiJSUzI1NiIs...",
+	Reason:    payments.Refund,
+	Site:      "https://example.com",
+	LastSeen:  "2026-07-01",
+}
+
+// emailTemplateInfo is an email template as listed by the API, Custom is set
+// for stored templates and unset for built-in ones.
+type emailTemplateInfo struct {
+	Kind      string     `json:"kind"`
+	Locale    string     `json:"locale"`
+	Subject   string     `json:"subject"`
+	Body      string     `json:"body"`
+	Custom    bool       `json:"custom"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+// emailTemplateVars returns the kind and canonical locale of the template a
+// request is for.
+func emailTemplateVars(r *http.Request) (string, string, *appError) {
+	kind, locale := mux.Vars(r)["kind"], canonicalLocale(mux.Vars(r)["locale"])
+
+	if _, ok := emailTemplates[kind]; !ok {
+		return "", "", &appError{errors.New("unknown email"), "Unknown email, it must be issued, expiry-reminder, revoked, seat-invite or recovery", http.StatusNotFound, codeNotFound}
+	}
+
+	if !localePattern.MatchString(locale) {
+		return "", "", &appError{errors.New("invalid locale"), "Invalid locale, use a language code such as de or pt-BR", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	return kind, locale, nil
+}
+
+// ListEmailTemplates handles GET requests to /api/email-templates
+//
+// It returns every email template, the stored ones and the built-in ones that
+// haven't been replaced, ordered by kind and locale.
+//
+// Example:
+//
+//	GET /api/email-templates
+//	200 [{"kind": "expiry-reminder", "locale": "de", "subject": "Ihre {{.Product}}-Lizenz ...", "custom": false}, ...]
+func ListEmailTemplates(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	stored, err := env.EmailTemplates(c).List(c)
+
+	if err != nil {
+		return &appError{err, "Could not load the email templates", http.StatusInternalServerError, codeInternal}
+	}
+
+	byID := make(map[string]emailTemplateInfo)
+
+	for kind, locales := range emailTemplates {
+		for locale, t := range locales {
+			byID[kind+"/"+locale] = emailTemplateInfo{Kind: kind, Locale: locale, Subject: t.Subject, Body: t.Body}
+		}
+	}
+
+	for _, t := range stored {
+		updatedAt := t.UpdatedAt
+		byID[t.Kind+"/"+t.Locale] = emailTemplateInfo{t.Kind, t.Locale, t.Subject, t.Body, true, &updatedAt}
+	}
+
+	templates := make([]emailTemplateInfo, 0, len(byID))
+
+	for _, t := range byID {
+		templates = append(templates, t)
+	}
+
+	sort.Slice(templates, func(i, j int) bool {
+		if templates[i].Kind != templates[j].Kind {
+			return templates[i].Kind < templates[j].Kind
+		}
+
+		return templates[i].Locale < templates[j].Locale
+	})
+
+	writeJSON(w, 200, templates)
+	return nil
+}
+
+// PutEmailTemplate handles PUT requests to /api/email-templates/{kind}/{locale}
+//
+// The request body has the subject and body of the email as text/templates,
+// which can use {{.Name}}, {{.Product}}, {{.LicenseID}}, {{.ExpiresAt}},
+// {{.License}} (issued emails) and {{.Reason}} (revoked emails). The
+// template replaces the built-in one for the locale, or adds the locale. It
+// is rendered with sample data first and rejected if that fails.
+//
+// Example:
+//
+//	PUT /api/email-templates/issued/pt {"subject": "Sua licença {{.Product}}", "body": "Olá {{.Name}}, ..."}
+//	200 {"kind": "issued", "locale": "pt", ...}
+func PutEmailTemplate(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	kind, locale, e := emailTemplateVars(r)
+
+	if e != nil {
+		return e
+	}
+
+	var req emailTemplate
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	if req.Subject == "" || req.Body == "" {
+		return &appError{errors.New("empty template"), "The subject and body are required", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	if _, err := renderEmail(kind, &req, sampleEmailData); err != nil {
+		return &appError{err, "Invalid template: " + err.Error(), http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	t := &store.EmailTemplate{
+		Kind:      kind,
+		Locale:    locale,
+		Subject:   req.Subject,
+		Body:      req.Body,
+		UpdatedAt: time.Now(),
+	}
+
+	if err := env.EmailTemplates(c).Put(c, t); err != nil {
+		return &appError{err, "Could not store the email template", http.StatusInternalServerError, codeInternal}
+	}
+
+	if err := audit(c, store.AuditEntry{Action: "email-template.update", Target: kind + "/" + locale}); err != nil {
+		env.Errorf(c, "Could not record the %v/%v email template update in the audit log: %v", kind, locale, err)
+	}
+
+	writeJSON(w, 200, t)
+	return nil
+}
+
+// DeleteEmailTemplate handles DELETE requests to
+// /api/email-templates/{kind}/{locale}
+//
+// It removes a stored template, so the built-in one for the locale (if any)
+// is used again.
+func DeleteEmailTemplate(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	kind, locale, e := emailTemplateVars(r)
+
+	if e != nil {
+		return e
+	}
+
+	if err := env.EmailTemplates(c).Delete(c, kind, locale); err != nil {
+		return &appError{err, "Could not delete the email template", http.StatusInternalServerError, codeInternal}
+	}
+
+	if err := audit(c, store.AuditEntry{Action: "email-template.delete", Target: kind + "/" + locale}); err != nil {
+		env.Errorf(c, "Could not record the %v/%v email template deletion in the audit log: %v", kind, locale, err)
+	}
+
+	writeJSON(w, 200, "SUCCESS")
+	return nil
+}
+
+// PreviewEmailTemplate handles POST requests to
+// /api/email-templates/{kind}/{locale}/preview
+//
+// It renders the email that would be sent in the locale, with the fallbacks
+// customers get. The request body may have a subject and body to preview
+// instead of the current template, and a licenseId to render with instead of
+// sample data.
+//
+// Example:
+//
+//	POST /api/email-templates/revoked/de-AT/preview {}
+//	200 {"subject": "Ihre domain_changer-Lizenz wurde widerrufen", "body": "Hallo Jane Doe, ..."}
+func PreviewEmailTemplate(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	kind, locale, e := emailTemplateVars(r)
+
+	if e != nil {
+		return e
+	}
+
+	var req struct {
+		Subject   string `json:"subject"`
+		Body      string `json:"body"`
+		LicenseID string `json:"licenseId"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	t, err := lookupEmailTemplate(c, kind, locale)
+
+	if err != nil {
+		return &appError{err, "Could not load the email template", http.StatusInternalServerError, codeInternal}
+	}
+
+	if req.Subject != "" {
+		t.Subject = req.Subject
+	}
+
+	if req.Body != "" {
+		t.Body = req.Body
+	}
+
+	data := sampleEmailData
+
+	if req.LicenseID != "" {
+		lic, err := env.Licenses(c, "").Get(c, req.LicenseID)
+
+		if err == store.ErrNotFound {
+			return &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+		}
+
+		if err != nil {
+			return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+		}
+
+		sample := sampleEmailData
+		data = licenseEmailData(lic, emailData{License: sample.License, Link: sample.Link, Reason: sample.Reason, Site: sample.Site, LastSeen: sample.LastSeen})
+	}
+
+	msg, err := renderEmail(kind, t, data)
+
+	if err != nil {
+		return &appError{err, "Invalid template: " + err.Error(), http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	writeJSON(w, 200, struct {
+		Subject string `json:"subject"`
+		Body    string `json:"body"`
+	}{msg.Subject, msg.Body})
+
+	return nil
+}
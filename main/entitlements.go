@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// entitlementCheck is the result of checking one feature of a license. Limit
+// is omitted if the feature is unlimited and used if the server doesn't
+// count its usage.
+type entitlementCheck struct {
+	ID      string `json:"id"`
+	Feature string `json:"feature"`
+	Product string `json:"product,omitempty"`
+	Allowed bool   `json:"allowed"`
+	Status  string `json:"status"`
+	Limit   *int   `json:"limit,omitempty"`
+	Used    *int   `json:"used,omitempty"`
+}
+
+// CheckEntitlement handles GET requests to /api/licenses/{id}/entitlements/{feature}
+//
+// A feature is allowed if the license is valid, or expired but within the
+// grace period, and unlocks it. Licenses without entitlements unlock every
+// feature. The usage of the product's activation entitlement is the number of
+// activations. For a bundle license, ?product= checks the feature of one of
+// the bundle's products, which may have its own entitlements.
+//
+// Example:
+//
+//	GET /api/licenses/daS7y8sioiecYy/entitlements/domains
+//	200 {"id": "daS7y8sioiecYy", "feature": "domains", "allowed": true, "status": "valid", "limit": 10, "used": 7}
+//
+//	GET /api/licenses/Kq2vX9wLm3RtYb0c/entitlements/domains?product=domain_changer
+//	200 {"id": "Kq2vX9wLm3RtYb0c", "feature": "domains", "product": "domain_changer", "allowed": true, "status": "valid", "limit": 3}
+func CheckEntitlement(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	vars := mux.Vars(r)
+	lic, err := env.Licenses(c, requestNamespace(c)).Get(c, vars["id"])
+
+	if err == store.ErrNotFound {
+		return &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	vd, err := newValidator(c).check(lic)
+
+	if err != nil {
+		return &appError{err, "An error occurred validating the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	ec := &entitlementCheck{ID: lic.ID, Feature: vars["feature"], Product: r.FormValue("product"), Status: vd.Status}
+	product := lic.Product
+
+	if ec.Product != "" {
+		product = ec.Product
+	}
+
+	entitlements := lic.EntitlementsFor(product)
+	limit, unlocked := entitlements[ec.Feature]
+
+	if entitlements == nil {
+		unlocked = true
+	}
+
+	ec.Allowed = unlocked && (vd.Valid || vd.InGrace)
+
+	if limit > 0 {
+		ec.Limit = &limit
+	}
+
+	if ec.Feature == cfg.Products[product].ActivationEntitlement {
+		ec.Used = &vd.Activations.Used
+	}
+
+	writeJSON(w, 200, ec)
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+// The codes of error responses, sent as their code and logged with them.
+// Client SDKs and the support docs refer to them, so a code must not change
+// or be reused once released, add a new one instead. They are listed in the
+// README.
+const (
+	// the request
+	codeInvalidRequest = "invalid_request" // malformed body or parameters
+	codeAuthRequired   = "auth_required"   // no admin session or API key
+	codeForbidden      = "forbidden"       // the credentials don't allow it
+	codeNotFound       = "not_found"       // an endpoint that is disabled
+	codeRateLimited    = "rate_limited"    // too many requests from the client
+	codeQuotaExceeded  = "quota_exceeded"  // an API key or reseller issuance quota
+	codeTokenInvalid   = "token_invalid"   // a link or access token doesn't verify
+	codeTokenExpired   = "token_expired"
+	codeWebhookInvalid = "webhook_invalid" // a payment webhook that can't be decoded or verified
+
+	// products, resellers, licenses and their seats
+	codeProductUnknown  = "product_unknown"
+	codeResellerUnknown = "reseller_unknown"
+	codeLicenseNotFound = "license_not_found"
+	codeLicenseInvalid  = "license_invalid"   // the license string doesn't verify
+	codeLicenseRevoked  = "license_revoked"   // the request needs a license that isn't revoked
+	codeLicenseNotValid = "license_not_valid" // expired, not yet valid or not covering a version
+	codeActivationLimit = "activation_limit"
+	codeSeatLimit       = "seat_limit"
+	codeSeatNotFound    = "seat_not_found"
+	codeOrderConflict   = "order_conflict" // the order ID is used by another license
+	codeSoldOut         = "sold_out"       // the product's issuance cap is reached
+
+	// background jobs and snapshots
+	codeJobNotFound      = "job_not_found"
+	codeSnapshotNotFound = "snapshot_not_found"
+
+	// the server
+	codeKeyUnavailable     = "key_unavailable"     // a signing or verifying key couldn't be loaded
+	codeStorageUnavailable = "storage_unavailable" // reading or writing files in storage failed
+	codeBackendUnavailable = "backend_unavailable" // storage or the datastore is down, retry later
+	codeMaintenance        = "maintenance"         // changes are rejected during maintenance, retry later
+	codeInternal           = "internal_error"
+)
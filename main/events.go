@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// Kinds of the jobs that replay and seed the event log.
+const (
+	jobRebuild    = "rebuild"
+	jobSeedEvents = "seed-events"
+)
+
+// eventBatchSize is how many events a batch of a job replays or appends.
+const eventBatchSize = 200
+
+// maxEventListLimit is the most events listed per page.
+const maxEventListLimit = 500
+
+// seedPhases are what a seed job goes through in turn, the revocation list
+// and then the licenses of each namespace.
+var seedPhases = []string{"revocations", "licenses", "sandbox"}
+
+// parseUntil parses an optional RFC 3339 time, zero if s is empty.
+func parseUntil(s string) (time.Time, *appError) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	until, err := time.Parse(time.RFC3339, s)
+
+	if err != nil {
+		return time.Time{}, &appError{&fieldError{Field: "until", err: err}, "until must be an RFC 3339 time", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	return until, nil
+}
+
+// ListEvents handles GET requests to /api/events
+//
+// It lists the event log oldest first, a page of limit (100 by default) at a
+// time, optionally only the events up to until. Pass the returned cursor to
+// get the next page.
+//
+// Example:
+//
+//	GET /api/events?until=2026-10-01T00:00:00Z
+//	200 {"events": [{"id": "5629499534213120", "kind": "create", "at": "...", "licenseId": "daS7y8sioiecYy", "license": {...}}, ...], "cursor": "..."}
+func ListEvents(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	until, e := parseUntil(r.URL.Query().Get("until"))
+
+	if e != nil {
+		return e
+	}
+
+	limit := 100
+
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxEventListLimit {
+			return &appError{fmt.Errorf("invalid limit %q", s), "limit must be from 1 to 500", http.StatusBadRequest, codeInvalidRequest}
+		}
+	}
+
+	events, cursor, err := env.Events(c).List(c, until, r.URL.Query().Get("cursor"), limit)
+
+	if err != nil {
+		return &appError{err, "An error occurred listing the events", http.StatusInternalServerError, codeInternal}
+	}
+
+	if events == nil {
+		events = []store.Event{}
+	}
+
+	writeJSON(w, 200, struct {
+		Events []store.Event `json:"events"`
+		Cursor string        `json:"cursor,omitempty"`
+	}{events, cursor})
+
+	return nil
+}
+
+// RebuildFromEvents handles POST requests to /api/events/rebuild
+//
+// It starts a job that replays the event log, optionally only up to until,
+// into the license and revocation stores. Replaying a change that was
+// already made makes it again, so an intact store is left as it was. Changes
+// made after until aren't undone, so recovering to a point in time is done
+// into empty stores, such as those of a new project. The revocation list is
+// published with the revocations on its next update.
+//
+// Example:
+//
+//	POST /api/events/rebuild {"until": "2026-10-01T00:00:00Z"}
+//	202 {"id": "p3Bd9sKe0aQvT1xZ", "kind": "rebuild", "status": "running", ...}
+func RebuildFromEvents(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	var req struct {
+		Until string `json:"until"`
+	}
+
+	// the body is optional
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	if _, e := parseUntil(req.Until); e != nil {
+		return e
+	}
+
+	var params map[string]string
+
+	if req.Until != "" {
+		params = map[string]string{"until": req.Until}
+	}
+
+	return startEventJob(c, w, jobRebuild, params)
+}
+
+// SeedEvents handles POST requests to /api/events/seed
+//
+// It starts a job that appends the revocations and the licenses that were
+// stored before there was an event log to it, so that they can be rebuilt
+// too. It only needs running once, when the log is deployed.
+func SeedEvents(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	return startEventJob(c, w, jobSeedEvents, nil)
+}
+
+// startEventJob starts a job on the event log and responds with it.
+func startEventJob(c context.Context, w http.ResponseWriter, kind string, params map[string]string) *appError {
+	job, err := startJob(c, kind, params)
+
+	if err != nil {
+		return &appError{err, "Could not start the job", http.StatusInternalServerError, codeInternal}
+	}
+
+	if err := audit(c, store.AuditEntry{Action: "events." + kind, Target: job.ID, Details: params}); err != nil {
+		env.Errorf(c, "Could not record job %v in the audit log: %v", job.ID, err)
+	}
+
+	writeJSON(w, http.StatusAccepted, job)
+	return nil
+}
+
+// runRebuildBatch replays a batch of the event log.
+func runRebuildBatch(c context.Context, job *store.Job) (string, error) {
+	until, e := parseUntil(job.Params["until"])
+
+	if e != nil {
+		return "", &jobError{e.Error}
+	}
+
+	events, cursor, err := env.Events(c).List(c, until, job.Cursor, eventBatchSize)
+
+	if err != nil {
+		return "", err
+	}
+
+	revocations, err := env.Revocations(c)
+
+	if err != nil {
+		return "", err
+	}
+
+	licenses := func(namespace string) store.Licenses {
+		return env.Licenses(c, namespace)
+	}
+
+	for _, ev := range events {
+		if err := store.Apply(c, ev, licenses, revocations); err != nil {
+			env.Errorf(c, "Could not replay event %v: %v", ev.ID, err)
+			job.Failed++
+			continue
+		}
+
+		job.Processed++
+	}
+
+	return cursor, nil
+}
+
+// runSeedBatch appends a batch of the revocations or of a namespace's
+// licenses to the event log. Its cursor is the phase and the cursor within
+// it.
+func runSeedBatch(c context.Context, job *store.Job) (string, error) {
+	phase, cursor := seedPhases[0], ""
+
+	if job.Cursor != "" {
+		parts := strings.SplitN(job.Cursor, ":", 2)
+		phase, cursor = parts[0], parts[1]
+	}
+
+	events := env.Events(c)
+	now := time.Now()
+	var next string
+
+	switch phase {
+	case "revocations":
+		revocations, err := listRevocations(c)
+
+		if err != nil {
+			return "", err
+		}
+
+		offset, _ := strconv.Atoi(cursor)
+		end := offset + eventBatchSize
+
+		if end < len(revocations) {
+			next = strconv.Itoa(end)
+		} else {
+			end = len(revocations)
+		}
+
+		for _, rev := range revocations[offset:end] {
+			rev := rev
+
+			if err := events.Append(c, &store.Event{Kind: store.EventRevoke, At: now, LicenseID: rev.ID, Revocation: &rev}); err != nil {
+				return "", err
+			}
+
+			job.Processed++
+		}
+	case "licenses", "sandbox":
+		namespace := ""
+
+		if phase == "sandbox" {
+			namespace = store.SandboxNamespace
+		}
+
+		page, pageCursor, err := env.Licenses(c, namespace).List(c, store.Query{Limit: eventBatchSize, Cursor: cursor})
+
+		if err != nil {
+			return "", err
+		}
+
+		for _, lic := range page {
+			e := &store.Event{Kind: store.EventCreate, At: now, Namespace: namespace, LicenseID: lic.ID, License: lic}
+
+			if err := events.Append(c, e); err != nil {
+				return "", err
+			}
+
+			job.Processed++
+		}
+
+		next = pageCursor
+	default:
+		return "", &jobError{fmt.Errorf("unknown seed phase %q", phase)}
+	}
+
+	if next != "" {
+		return phase + ":" + next, nil
+	}
+
+	for i, p := range seedPhases[:len(seedPhases)-1] {
+		if p == phase {
+			return seedPhases[i+1] + ":", nil
+		}
+	}
+
+	return "", nil
+}
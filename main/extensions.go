@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// maxExtension is the longest a license can be extended by at once, the
+// same ten years as a bulk extension.
+const maxExtension = maxBulkExtendDays * 24 * time.Hour
+
+// maxExtensionReason is the longest reason an extension can be given.
+const maxExtensionReason = 500
+
+// ExtendLicense handles POST requests to /api/licenses/{id}/extend
+//
+// It moves the expiry of a license later by a duration, such as a goodwill
+// extension by support, without renewing it: the license keeps its ID and
+// isn't counted as renewed. The reason is required, and the extension is
+// kept on the license's extensions and in the audit log. A license that has
+// already expired is extended from now. The response is the re-signed
+// license.
+//
+// Example:
+//
+//	POST /api/licenses/daS7y8sioiecYy/extend {"duration": "720h", "reason": "goodwill, support ticket #123"}
+//	200 "eyJhbGciOiJSUzI1NiIs..."
+func ExtendLicense(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	var req struct {
+		Duration string `json:"duration"` // e.g. "720h"
+		Reason   string `json:"reason"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	d, err := time.ParseDuration(req.Duration)
+
+	if err != nil || d <= 0 || d > maxExtension {
+		err = fmt.Errorf("invalid duration %q", req.Duration)
+		return &appError{&fieldError{Field: "duration", err: err}, "duration must be a duration up to 87600h, e.g. 720h", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	reason := strings.TrimSpace(req.Reason)
+
+	if reason == "" || len(reason) > maxExtensionReason {
+		err := errors.New("invalid reason")
+		return &appError{&fieldError{Field: "reason", err: err}, "A reason of up to 500 characters is required", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	var e *appError
+	var licStr string
+	var from, to time.Time
+
+	// the extension is made in a transaction so that extensions at the same
+	// time each move the expiry on from the other
+	lic, err := env.Licenses(c, requestNamespace(c)).Update(c, mux.Vars(r)["id"], func(lic *license.License) error {
+		if licStr, e = extendLicense(c, lic, d, reason); e != nil {
+			return e.Error
+		}
+
+		from, to = lic.Extensions[len(lic.Extensions)-1].From, *lic.ExpiresAt
+		return nil
+	})
+
+	if e != nil {
+		return e
+	}
+
+	if err == store.ErrNotFound {
+		return &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not store the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	entry := store.AuditEntry{
+		Action:   "license.extend",
+		Target:   lic.ID,
+		Customer: lic.Email(),
+		Details: map[string]string{
+			"duration": d.String(),
+			"reason":   reason,
+			"from":     from.Format(time.RFC3339),
+			"to":       to.Format(time.RFC3339),
+		},
+	}
+
+	if err = audit(c, entry); err != nil {
+		env.Errorf(c, "Could not record the extension of %v in the audit log: %v", lic.ID, err)
+	}
+
+	writeJSON(w, 200, encodedLicense(licStr))
+	return nil
+}
+
+// extendLicense moves the expiry of a license later by d, recording the
+// extension on it, and re-signs it.
+func extendLicense(c context.Context, lic *license.License, d time.Duration, reason string) (string, *appError) {
+	if lic.RevokedAt != nil {
+		return "", &appError{errors.New("license revoked"), "Revoked licenses can't be extended", http.StatusConflict, codeLicenseRevoked}
+	}
+
+	if lic.ExpiresAt == nil {
+		return "", &appError{errors.New("license is perpetual"), "The license never expires", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	now := time.Now()
+	from := *lic.ExpiresAt
+	start := from
+
+	if start.Before(now) {
+		start = now
+	}
+
+	to := start.Add(d)
+	lic.ExpiresAt = &to
+
+	// the stored license may share its extensions with the one just read
+	ext := license.Extension{At: now, Actor: actor(c), Reason: reason, From: from, To: to}
+	lic.Extensions = append(lic.Extensions[:len(lic.Extensions):len(lic.Extensions)], ext)
+
+	return signLicense(c, lic)
+}
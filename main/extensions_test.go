@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/config"
+)
+
+func TestExtendLicenseConcurrently(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	id := createLicenseWith(t, s, map[string]interface{}{"product": "domain_changer", "expires_in": "8760h"})
+	lic, err := p.LicenseStore.Get(context.Background(), id)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lic.ExpiresAt == nil {
+		t.Fatal("the test license never expires")
+	}
+
+	useAPIKey(s, p, config.APIKey{ID: "admin", Admin: true})
+
+	const n = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			resp, err := s.Post("/api/licenses/"+id+"/extend", map[string]string{"duration": "24h", "reason": fmt.Sprintf("goodwill %v", i)})
+
+			if err == nil && resp.StatusCode != 200 {
+				err = fmt.Errorf("extending %v: %v %s", id, resp.StatusCode, resp.Body)
+			}
+
+			errs <- err
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	extended, err := p.LicenseStore.Get(context.Background(), id)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(extended.Extensions) != n {
+		t.Errorf("%v extensions, want %v", len(extended.Extensions), n)
+	}
+
+	if want := lic.ExpiresAt.Add(n * 24 * time.Hour); !extended.ExpiresAt.Equal(want) {
+		t.Errorf("expires at %v, want %v", extended.ExpiresAt, want)
+	}
+}
+
+func TestExtendLicenseNeedsAdminKey(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	id := createLicense(t, s)
+	useAPIKey(s, p, config.APIKey{ID: "plain"})
+
+	resp, err := s.Post("/api/licenses/"+id+"/extend", map[string]string{"duration": "24h", "reason": "goodwill"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 403 {
+		t.Errorf("got %v %s, want a 403", resp.StatusCode, resp.Body)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// flagNamePattern matches flag names such as "license-v2".
+var flagNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// flagRefresh is how often an instance reads the flags again, so changing
+// one takes this long to reach every instance.
+const flagRefresh = 30 * time.Second
+
+// flagCache keeps the flags in memory so that checking one doesn't query the
+// datastore.
+type flagCache struct {
+	mu      sync.Mutex
+	flags   map[string]store.Flag
+	fetched time.Time
+}
+
+var flags = &flagCache{}
+
+// get returns a flag, ok is false if it isn't set. If the flags can't be
+// read, the ones last read are used until the next refresh.
+func (fc *flagCache) get(c context.Context, name string) (store.Flag, bool) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if time.Since(fc.fetched) >= flagRefresh {
+		fc.fetched = time.Now()
+
+		if list, err := env.Flags(c).List(c); err != nil {
+			env.Warningf(c, "Could not read the feature flags: %v", err)
+		} else {
+			fc.flags = make(map[string]store.Flag, len(list))
+
+			for _, f := range list {
+				fc.flags[f.Name] = f
+			}
+		}
+	}
+
+	f, ok := fc.flags[name]
+	return f, ok
+}
+
+// expire makes the instance read the flags again when one is next checked,
+// after changing one.
+func (fc *flagCache) expire() {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.fetched = time.Time{}
+}
+
+// flagBucket places a subject in one of 100 buckets for a flag, so that the
+// same subject stays on or off as the percentage grows while different flags
+// cover different subjects.
+func flagBucket(name, subject string) int {
+	h := fnv.New32a()
+	h.Write([]byte(name + "/" + subject))
+	return int(h.Sum32() % 100)
+}
+
+// flagOn reports whether a flag is on for a subject, such as a license ID,
+// of a product. Flags that aren't set are off.
+func flagOn(c context.Context, name, product, subject string) bool {
+	f, ok := flags.get(c, name)
+
+	if !ok {
+		return false
+	}
+
+	if len(f.Products) > 0 && !containsString(f.Products, product) {
+		return false
+	}
+
+	return flagBucket(name, subject) < f.Percent
+}
+
+// containsString reports whether s is one of list.
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ListFlags handles GET requests to /api/flags
+//
+// Example:
+//
+//	GET /api/flags
+//	200 [{"name": "license-v2", "products": ["domain_changer"], "percent": 10, "updatedAt": "..."}]
+func ListFlags(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	list, err := env.Flags(c).List(c)
+
+	if err != nil {
+		return &appError{err, "Could not load the feature flags", http.StatusInternalServerError, codeInternal}
+	}
+
+	writeJSON(w, 200, list)
+	return nil
+}
+
+// PutFlag handles PUT requests to /api/flags/{name}
+//
+// The request body has the percent of licenses the flag is on for, from 0 to
+// 100, and optionally the products it is limited to. Licenses are picked by
+// a hash of their ID, so raising the percentage keeps the flag on for those
+// it was already on for. Other instances notice within 30 seconds.
+//
+// Example:
+//
+//	PUT /api/flags/license-v2 {"products": ["domain_changer"], "percent": 10}
+//	200 {"name": "license-v2", "products": ["domain_changer"], "percent": 10, "updatedAt": "..."}
+func PutFlag(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	name := mux.Vars(r)["name"]
+
+	if !flagNamePattern.MatchString(name) {
+		return &appError{errors.New("invalid flag name"), "Invalid flag name, use lowercase letters, digits and dashes", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	var req struct {
+		Products []string `json:"products"`
+		Percent  int      `json:"percent"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	if req.Percent < 0 || req.Percent > 100 {
+		return &appError{errors.New("invalid percent"), "The percent must be from 0 to 100", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	for _, product := range req.Products {
+		if _, ok := cfg.Products[product]; !ok {
+			return &appError{fmt.Errorf("unknown product %q", product), "Unknown product " + product, http.StatusBadRequest, codeProductUnknown}
+		}
+	}
+
+	f := &store.Flag{
+		Name:      name,
+		Products:  req.Products,
+		Percent:   req.Percent,
+		UpdatedAt: time.Now(),
+	}
+
+	if err := env.Flags(c).Put(c, f); err != nil {
+		return &appError{err, "Could not store the feature flag", http.StatusInternalServerError, codeInternal}
+	}
+
+	flags.expire()
+
+	entry := store.AuditEntry{
+		Action:  "flag.update",
+		Target:  name,
+		Details: map[string]string{"products": strings.Join(req.Products, ","), "percent": strconv.Itoa(req.Percent)},
+	}
+
+	if err := audit(c, entry); err != nil {
+		env.Errorf(c, "Could not record the %v flag update in the audit log: %v", name, err)
+	}
+
+	writeJSON(w, 200, f)
+	return nil
+}
+
+// DeleteFlag handles DELETE requests to /api/flags/{name}
+//
+// It removes a flag, which turns it off everywhere.
+func DeleteFlag(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	name := mux.Vars(r)["name"]
+
+	if err := env.Flags(c).Delete(c, name); err != nil {
+		return &appError{err, "Could not delete the feature flag", http.StatusInternalServerError, codeInternal}
+	}
+
+	flags.expire()
+
+	if err := audit(c, store.AuditEntry{Action: "flag.delete", Target: name}); err != nil {
+		env.Errorf(c, "Could not record the %v flag deletion in the audit log: %v", name, err)
+	}
+
+	writeJSON(w, 200, "SUCCESS")
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/license"
+)
+
+// flagLicenseV2 is the feature flag that issues licenses in the v2 format,
+// the percentage is of new licenses.
+const flagLicenseV2 = "license-v2"
+
+// licenseFormats are the formats licenses are issued in.
+var licenseFormats = []string{license.FormatV1, license.FormatV2}
+
+// The events counted for each format.
+const (
+	formatIssued  = "issued"
+	formatDecoded = "decoded" // sent by a client and verified
+	formatFailed  = "failed"  // sent by a client and didn't verify
+)
+
+// maxFormatReportHours is the longest a format report can cover.
+const maxFormatReportHours = 7 * 24
+
+// formatCounter names the counter of a format's event in the hour containing
+// t (in UTC).
+func formatCounter(event, format string, t time.Time) string {
+	return fmt.Sprintf("format:%v:%v:%v", event, format, t.UTC().Format("2006-01-02T15"))
+}
+
+// countFormat counts an event of a license format for the current hour.
+// Errors are logged since counting is only for monitoring.
+func countFormat(c context.Context, event, format string) {
+	if err := env.Counters(c).Increment(c, formatCounter(event, format, time.Now())); err != nil {
+		env.Errorf(c, "Could not count a %v %v license: %v", event, format, err)
+	}
+}
+
+// countDecode counts a license sent by a client as decoded or failed in its
+// format, errors loading keys aren't the license's fault and aren't counted.
+func countDecode(c context.Context, token string, err error) {
+	if _, invalid := err.(*invalidError); invalid {
+		countFormat(c, formatFailed, license.Format(token))
+	} else if err == nil {
+		countFormat(c, formatDecoded, license.Format(token))
+	}
+}
+
+// encodeFormat returns the format a new license is signed in. Licenses
+// signed with two keys during a rotation are always v1, which is the only
+// format with more than one signature, and a product whose policy allows a
+// single format always gets it.
+func encodeFormat(c context.Context, lic *license.License, crossSigned bool) string {
+	allowed := cfg.Products[lic.Product].Policy.Formats
+
+	switch {
+	case crossSigned:
+		return license.FormatV1
+	case len(allowed) == 1:
+		return allowed[0]
+	case flagOn(c, flagLicenseV2, lic.Product, lic.ID):
+		return license.FormatV2
+	}
+
+	return license.FormatV1
+}
+
+// formatUsage is how many licenses of a format were issued, decoded and
+// failed to decode.
+type formatUsage struct {
+	Format  string `json:"format"`
+	Issued  int    `json:"issued"`
+	Decoded int    `json:"decoded"`
+	Failed  int    `json:"failed"`
+
+	// FailureRate is the fraction of the licenses sent by clients that
+	// failed, zero if none were sent.
+	FailureRate float64 `json:"failureRate"`
+}
+
+// FormatReport handles GET requests to /api/reports/formats
+//
+// It counts the licenses issued in each format in the last hours (24 by
+// default, at most a week), and those sent for validation, activation,
+// updates and decoding that verified and that didn't. A rise in v2 failures
+// while the license-v2 flag is rolled out points at clients that can't read
+// the format.
+//
+// Example:
+//
+//	GET /api/reports/formats?hours=24
+//	200 [{"format": "v1", "issued": 180, "decoded": 9120, "failed": 14, "failureRate": 0.0015}, {"format": "v2", ...}]
+func FormatReport(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	hours := 24
+
+	if s := r.URL.Query().Get("hours"); s != "" {
+		var err error
+
+		if hours, err = strconv.Atoi(s); err != nil || hours < 1 || hours > maxFormatReportHours {
+			return &appError{fmt.Errorf("invalid hours %q", s), "hours must be from 1 to 168", http.StatusBadRequest, codeInvalidRequest}
+		}
+	}
+
+	now := time.Now()
+	counters := env.Counters(c)
+	report := make([]formatUsage, 0, len(licenseFormats))
+
+	for _, format := range licenseFormats {
+		usage := formatUsage{Format: format}
+		counts := map[string]*int{formatIssued: &usage.Issued, formatDecoded: &usage.Decoded, formatFailed: &usage.Failed}
+
+		for event, count := range counts {
+			for i := 0; i < hours; i++ {
+				n, err := counters.Count(c, formatCounter(event, format, now.Add(-time.Duration(i)*time.Hour)))
+
+				if err != nil {
+					return &appError{err, "An error occurred counting the licenses", http.StatusInternalServerError, codeInternal}
+				}
+
+				*count += n
+			}
+		}
+
+		if sent := usage.Decoded + usage.Failed; sent > 0 {
+			usage.FailureRate = float64(usage.Failed) / float64(sent)
+		}
+
+		report = append(report, usage)
+	}
+
+	writeJSON(w, 200, report)
+	return nil
+}
+
+// DescribeFormats handles GET requests to /api/formats
+//
+// It describes the formats licenses are encoded in, their layout, signature
+// algorithm and version bytes and the claims of their payload, for
+// generating and testing clients in other languages. issued lists the
+// formats new licenses are issued in.
+//
+// Example:
+//
+//	GET /api/formats
+//	200 {"formats": [{"name": "v1", "algorithm": "RS256", "layout": [...], ...}, {"name": "v2", "version": 2, ...}], "claims": [{"name": "jti", "type": "string", "required": true, "since": 1, ...}, ...], "claimsVersion": 12, "certificateClaims": [...], "maxTokenSize": 32768, "issued": ["v1", "v2"]}
+func DescribeFormats(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	writeJSON(w, 200, struct {
+		*license.Description
+		Issued []string `json:"issued"`
+	}{license.Describe(), licenseFormats})
+
+	return nil
+}
@@ -3,205 +3,563 @@ package main
 import (
 	"crypto/rsa"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
-	"strings"
+	"runtime/debug"
+	"strconv"
 	"time"
 
 	"golang.org/x/net/context"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
 
-	"github.com/danielchatfield/go-jwt"
 	"github.com/gorilla/mux"
 	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/storage"
+	"github.com/volcanicpixels/licensing/store"
 )
 
 type appHandler func(context.Context, http.ResponseWriter, *http.Request) *appError
 
+// appError is an error response, the Message is shown to the client and the
+// Error is only logged. Code is from the catalog in errorcodes.go.
 type appError struct {
 	Error   error
 	Message string
-	Code    int
+	Status  int
+	Code    string
 }
 
 func (fn appHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
+	start := time.Now()
+	entry := &accessEntry{}
+	timings := newRequestTimings(start)
+	c := context.WithValue(env.NewContext(r), accessContextKey, entry)
+	c = context.WithValue(c, timingsContextKey, timings)
+	sw := &statusWriter{ResponseWriter: w}
+	w = &timingWriter{ResponseWriter: sw, timings: timings}
+
+	// deferred first so that it runs last and logs the 500 of a panic
+	defer logAccess(c, r, sw, entry, start)
+
+	// a panic is logged with its stack and answered like any other error,
+	// rather than with the platform's own 500 page
+	defer func() {
+		if v := recover(); v != nil {
+			id := env.RequestID(c)
+			env.Errorf(c, "[%v] panic in %v %v (request %v): %v\n%s", codeInternal, r.Method, r.URL.Path, id, v, debug.Stack())
+
+			w.Header().Set("X-Request-Id", id)
+			writeError(w, &appError{fmt.Errorf("panic: %v", v), "An unexpected error occurred", http.StatusInternalServerError, codeInternal})
+		}
+	}()
+
 	if e := fn(c, w, r); e != nil {
-		log.Errorf(c, "[%v] %v", e.Message, e.Error)
-		http.Error(w, e.Message, e.Code)
+		// whatever a handler was doing, a backend behind an open circuit
+		// breaker is the same outage for the client
+		if e.Error == storage.ErrUnavailable {
+			e = &appError{e.Error, "The service is temporarily unavailable, please retry later", http.StatusServiceUnavailable, codeBackendUnavailable}
+			w.Header().Set("Retry-After", strconv.Itoa(int(cfg.Storage.BreakerCooldown/time.Second)))
+		}
+
+		env.Errorf(c, "[%v] [%v] %v", e.Code, e.Message, e.Error)
+		writeError(w, e)
 	}
 }
 
 // NewLicense handles POST requests on /api/licenses/create
 //
-// The request body must contain a JSON object with a product field
+// The request body must contain a JSON object with a product field, it may
+// also name one of the product's templates and override any of its settings
+// (see createRequest).
 //
 // Examples:
 //
 //  POST /api/licenses {"product": ""}
-//  400 empty title
+//  400 {"status": 400, "error": "The product is required", "code": "invalid_request", "field": "product"}
+//
+//  POST /api/licenses {"product": "domain_chnager"}
+//  400 {"status": 400, "error": "Unknown product domain_chnager", "code": "product_unknown", "field": "product", "allowed": ["domain_changer"]}
 //
 //  POST /api/licenses {"product": "domain_changer"}
 //  200
+//
+//  POST /api/licenses {"product": "domain_changer", "template": "pro-annual"}
+//  200
+//
+//  POST /api/licenses {"product": "domain_changer", "order_id": "1042"}
+//  200 {"status": 200, "result": "eyJhbGciOiJSUzI1NiIs...", "deduplicated": true}
+//
+//  POST /api/licenses?dry_run=true {"product": "domain_changer"}
+//  200 {"status": 200, "result": {"dryRun": true, "license": {...}, "claims": {"jti": "...", "_prod": "domain_changer", ...}, "format": "v1"}}
 func NewLicense(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
-	var req struct{ Product string }
+	var req createRequest
 	var err error
 
+	dry, e := dryRun(r)
+
+	if e != nil {
+		return e
+	}
+
 	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return &appError{err, "Could not decode json request", http.StatusBadRequest}
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
 	}
 
-	var key *rsa.PrivateKey
-	if key, err = getPrivateKey(c, "plugin"); err != nil {
-		return &appError{err, "Could not load private key for signing", http.StatusInternalServerError}
+	if dry {
+		return previewLicense(c, w, &req, "")
 	}
 
-	// create the license
-	lic := license.New(req.Product)
+	_, licStr, dup, e := issueLicense(c, &req, "")
 
-	var licStr string
-	if licStr, err = lic.Encode(key); err != nil {
-		return &appError{err, "Could not encode the license", http.StatusInternalServerError}
+	if e != nil {
+		return e
 	}
 
-	writeJSON(w, 200, licStr)
+	writeResponse(w, response{Status: 200, Result: encodedLicense(licStr), Deduplicated: dup})
 	return nil
 }
 
-func revokeLicense(c context.Context, id string) error {
-	// ideally we would simply add the license ID on to the end of the revocations.txt file
-	// but Google Storage doesn't support appends.
-	// It does support a composition operation, so we could write the new ID to a new file
-	// and then compose the original with the new one to ensure atomicity, except the Google
-	// storage client library does not implement this operation.
-	// Therefore the best we can do without stupidly complex locks is to simply read in the current file
-	// and then write a new file with the addition
+// createdLicense is the result of a v2 create request.
+type createdLicense struct {
+	ID        string     `json:"id"`
+	License   string     `json:"license"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// NewLicenseV2 handles POST requests on /api/v2/licenses
+//
+// It issues a license like NewLicense, but the result also has the license's
+// ID, which revoking or looking it up takes, and when it expires (null if it
+// never does). A dry run answers like NewLicense's. The result is in a v2
+// envelope (see envelope) like every other v2 response.
+//
+// Example:
+//
+//  POST /api/v2/licenses {"product": "domain_changer", "template": "pro-annual"}
+//  200 {"data": {"id": "ZpJm3dQ1sTbc0aXe", "license": "eyJhbGciOiJSUzI1NiIs...", "expires_at": "2027-10-14T11:00:00Z"}, "request_id": "..."}
+func NewLicenseV2(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	var req createRequest
 
-	// read the current revocations.txt file
-	sc := NewStorageContext(c)
-	data, err := sc.ReadFile("revocations.txt")
+	dry, e := dryRun(r)
 
-	if err != nil {
-		return err
+	if e != nil {
+		return e
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
 	}
 
-	line := id
+	if dry {
+		return previewLicense(c, w, &req, "")
+	}
 
-	// almost certainly a better way to do this
-	data = []byte(string(data) + "\n" + line)
+	lic, licStr, dup, e := issueLicense(c, &req, "")
 
-	if err := sc.WriteFile("revocations.txt", data); err != nil {
-		return err
+	if e != nil {
+		return e
+	}
+
+	result := createdLicense{ID: lic.ID, License: licStr}
+
+	// as signed, in whole seconds
+	if lic.ExpiresAt != nil {
+		expiresAt := lic.ExpiresAt.Truncate(time.Second)
+		result.ExpiresAt = &expiresAt
 	}
 
+	writeResponse(w, response{Status: 200, Result: result, Deduplicated: dup})
 	return nil
 }
 
-// RevokeLicense handles POST requests to /api/licenses/{ID}/revoke
-func RevokeLicense(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
-	vars := mux.Vars(r)
-	id := vars["id"]
+// orderLicense returns the license already issued for the order of a create
+// request, signed again, or nil if lic is the first claim on it.
+func orderLicense(c context.Context, lic *license.License) (*license.License, string, *appError) {
+	namespace := licenseNamespace(lic)
+	id, err := env.Orders(c, namespace).Claim(c, lic.Product, lic.OrderID(), lic.ID)
 
-	if err := revokeLicense(c, id); err != nil {
-		return &appError{err, "An error occurred updating the revocations file", http.StatusInternalServerError}
+	if err != nil {
+		return nil, "", &appError{err, "An error occurred checking the order", http.StatusInternalServerError, codeInternal}
 	}
 
-	writeJSON(w, 200, "SUCCESS")
+	if id == lic.ID {
+		return nil, "", nil
+	}
 
-	return nil
+	existing, e := claimedLicense(c, lic, id)
+
+	if e != nil {
+		return nil, "", e
+	}
+
+	licStr, e := signLicense(c, existing)
+
+	if e != nil {
+		return nil, "", e
+	}
+
+	return existing, licStr, nil
 }
 
-func DecodeLicense(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
-	var req struct{ License string }
-	var err error
+// claimedLicense loads the license with the ID that the order of a create
+// request was claimed for by an earlier request.
+func claimedLicense(c context.Context, lic *license.License, id string) (*license.License, *appError) {
+	existing, err := env.Licenses(c, licenseNamespace(lic)).Get(c, id)
 
-	if err = json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return &appError{err, "Could not decode json request", http.StatusBadRequest}
+	// the first request claims the order before it stores the license
+	if err == store.ErrNotFound {
+		return nil, &appError{err, "The license for the order is still being issued, try again", http.StatusConflict, codeOrderConflict}
 	}
 
-	// req successfully Decoded
+	if err != nil {
+		return nil, &appError{err, "Could not load the license issued for the order", http.StatusInternalServerError, codeInternal}
+	}
 
-	var key *rsa.PublicKey
-	if key, err = getPublicKey(c, "plugin"); err != nil {
-		return &appError{err, "Could not load public key for verifying", http.StatusInternalServerError}
+	if existing.Reseller != lic.Reseller {
+		err := fmt.Errorf("order %v of %v was issued by reseller %q", lic.OrderID(), lic.Product, existing.Reseller)
+		return nil, &appError{err, "The order ID is used by another license", http.StatusConflict, codeOrderConflict}
 	}
 
-	l, err := license.Parse(req.License, key)
+	return existing, nil
+}
+
+// issueLicense creates, signs and stores a license, returning it and the
+// encoded license. Sandbox licenses are signed with the test key and stored
+// separately so integration tests never mix with real licenses. If a license
+// was already issued for the request's order_id that license is returned
+// instead, and deduplicated is true.
+func issueLicense(c context.Context, req *createRequest, reseller string) (lic *license.License, licStr string, deduplicated bool, e *appError) {
+	if e := checkProduct(c, req.Product); e != nil {
+		return nil, "", false, e
+	}
+
+	lic = license.New(req.Product)
+	lic.Test = isSandbox(c)
+	lic.Reseller = reseller
+
+	if err := applyCreateRequest(lic, req); err != nil {
+		return nil, "", false, &appError{err, err.Error(), http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	if lic.OrderID() != "" {
+		var existing *license.License
+		var existingStr string
+
+		// e is the named result, which the deferred release below checks
+		if existing, existingStr, e = orderLicense(c, lic); e != nil || existing != nil {
+			return existing, existingStr, existing != nil, e
+		}
+
+		// the order is released for another attempt if this one fails, by
+		// then lic is the nil result
+		orders, product, orderID := env.Orders(c, licenseNamespace(lic)), lic.Product, lic.OrderID()
+
+		defer func() {
+			if e == nil {
+				return
+			}
+
+			if err := orders.Release(c, product, orderID); err != nil {
+				env.Errorf(c, "Could not release order %v of %v: %v", orderID, product, err)
+			}
+		}()
+	}
+
+	capped, e := takeCap(c, lic, req.IgnoreCap)
+
+	if e != nil {
+		return nil, "", false, e
+	}
+
+	if capped {
+		product := lic.Product
+
+		defer func() {
+			if e != nil {
+				returnCap(c, product)
+			}
+		}()
+	}
+
+	quotas, e := takeKeyQuota(c, time.Now())
+
+	if e != nil {
+		return nil, "", false, e
+	}
+
+	defer func() {
+		if e != nil {
+			returnKeyQuota(c, quotas)
+		}
+	}()
+
+	licStr, e = signLicense(c, lic)
+
+	if e != nil {
+		return nil, "", false, e
+	}
+
+	stored := timed(c, "store")
+	err := env.Licenses(c, licenseNamespace(lic)).Put(c, lic)
+	stored()
 
 	if err != nil {
-		return &appError{err, "An error occured parsing the token", http.StatusBadRequest}
+		return nil, "", false, &appError{err, "Could not store the license", http.StatusInternalServerError, codeInternal}
 	}
 
-	// license successfuly decoded - now lets return the response
-	writeJSON(w, 200, l)
+	countSigned(c, issuingKeyIDs(lic)...)
+	noteLicense(c, lic.ID)
 
-	return nil
+	if !lic.Test {
+		countVolume(c, volumeIssued, lic.Product)
+
+		if cfg.Mail.Issued {
+			if err := mailCustomer(c, emailIssued, lic, emailData{License: licStr}); err != nil {
+				env.Errorf(c, "Could not email license %v to its customer: %v", lic.ID, err)
+			}
+		}
+	}
+
+	entry := store.AuditEntry{Action: "license.create", Target: lic.ID, Customer: lic.Email()}
+
+	if reseller != "" {
+		entry.Details = map[string]string{"reseller": reseller}
+	}
+
+	if err := audit(c, entry); err != nil {
+		env.Errorf(c, "Could not record the license %v in the audit log: %v", lic.ID, err)
+	}
+
+	return lic, licStr, false, nil
 }
 
-func UpdateRevocationFile(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
-	sc := NewStorageContext(c)
-	data, err := sc.ReadFile("revocations.txt")
+// prepareSigning sets the watermark, signing key and certificate of a license
+// and returns the key for its product, or the sandbox key for test licenses.
+func prepareSigning(c context.Context, lic *license.License) (*rsa.PrivateKey, *appError) {
+	defer timed(c, "key")()
+
+	lic.Watermark = watermark(lic)
+	keyID := signingKeyID(lic)
+	lic.KeyID = keyID
+	key, err := productPrivateKey(c, lic.Product, keyID)
 
 	if err != nil {
-		return &appError{err, "An error occurred reading the revocations.txt file", http.StatusInternalServerError}
+		return nil, &appError{err, "Could not load private key for signing", http.StatusInternalServerError, codeKeyUnavailable}
 	}
 
-	revocations := strings.Split(string(data), "\n")
+	if !lic.Test {
+		if lic.Certificate, err = licenseCertificate(c, keyID, lic); err != nil {
+			return nil, &appError{err, "Could not load the certificate of the signing key", http.StatusInternalServerError, codeKeyUnavailable}
+		}
+	}
 
-	var formatted []string
+	return key, nil
+}
 
-	for _, line := range revocations {
-		id := strings.TrimSpace(strings.Split(line, "#")[0])
+// signLicense encodes a license with the key for its product, or the sandbox
+// key for test licenses, watermarking it first.
+func signLicense(c context.Context, lic *license.License) (string, *appError) {
+	key, e := prepareSigning(c, lic)
+
+	if e != nil {
+		return "", e
+	}
 
-		if id == "" {
-			continue
+	keyID := lic.KeyID
+	var err error
+	var licStr string
+	crossID := crossSignKeyID(lic.Product)
+	crossSigned := crossID != "" && !lic.Test
+	format := encodeFormat(c, lic, crossSigned)
+
+	var old *rsa.PrivateKey
+
+	if crossSigned {
+		loaded := timed(c, "key")
+		old, err = productPrivateKey(c, lic.Product, crossID)
+		loaded()
+
+		if err != nil {
+			return "", &appError{err, "Could not load private key for cross-signing", http.StatusInternalServerError, codeKeyUnavailable}
 		}
+	}
 
-		formatted = append(formatted, id)
+	signed := timed(c, "sign")
+
+	// while rotating keys the license is signed with both keys so that
+	// software that only knows the old one can still verify it
+	switch {
+	case crossSigned:
+		licStr, err = lic.EncodeMulti(license.Signer{KeyID: keyID, Key: key}, license.Signer{KeyID: crossID, Key: old})
+	case format == license.FormatV2:
+		licStr, err = lic.EncodeV2(key)
+	default:
+		licStr, err = lic.Encode(key)
 	}
 
-	// ok, we have the revocations now
+	signed()
 
-	t := jwt.NewToken(jwt.RSA)
-	t.SetClaim("_revoked", formatted)
-	t.SetClaim("exp", time.Now().Add(time.Hour*72).Unix())
+	if err != nil {
+		return "", &appError{err, "Could not encode the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	countFormat(c, formatIssued, format)
+	return licStr, nil
+}
 
-	key, err := getPrivateKey(c, "plugin")
+// revokeLicense adds a license to the revocation list, the details say why
+// in the audit log. The stored license is marked revoked in namespace, which
+// callers take from the license or the request since admin requests and
+// webhooks have no sandbox key to go by.
+func revokeLicense(c context.Context, namespace string, rev store.Revocation, details map[string]string) error {
+	id := rev.ID
+	revocations, err := env.Revocations(c)
 
 	if err != nil {
-		return &appError{err, "The private key could not be retrieved", http.StatusInternalServerError}
+		return err
 	}
 
-	type revokedJSON struct {
-		Token string `json:"token"`
+	if err := revocations.Revoke(c, rev); err != nil {
+		return err
 	}
 
-	tokenString, err := t.Encode(key)
+	if err := logRevocations(c, []string{id}); err != nil {
+		env.Errorf(c, "Could not add %v to the transparency log, it will be added when the revocation file is next updated: %v", id, err)
+	}
 
-	if err != nil {
-		return &appError{err, "An error occured when signing the token", http.StatusInternalServerError}
+	if err := enqueueCDNPublish(c); err != nil {
+		env.Errorf(c, "Could not enqueue publishing %v to the CDN, it will be published when the revocation file is next updated: %v", id, err)
 	}
 
-	rev, err := json.Marshal(revokedJSON{tokenString})
+	// the revocation list is what counts, but keep the stored license in
+	// step so that it can be listed by status. IDs issued before licenses
+	// were stored won't be found.
+	licenses := env.Licenses(c, namespace)
+	lic, err := licenses.Get(c, id)
 
-	if err != nil {
-		return &appError{err, "An error occured when marshalling the JSON", http.StatusInternalServerError}
+	switch {
+	case err == store.ErrNotFound:
+		lic = nil
+	case err != nil:
+		return err
+	case lic.RevokedAt == nil:
+		now := time.Now()
+		lic.RevokedAt = &now
+		lic.RevokeAt = nil
+
+		if err := licenses.Put(c, lic); err != nil {
+			return err
+		}
 	}
 
-	err = sc.WriteFile("revocations.json", []byte(rev))
+	entry := store.AuditEntry{Action: "license.revoke", Target: id, Details: details}
 
-	if err != nil {
-		return &appError{err, "An error occured when writing the revocations.json file", http.StatusInternalServerError}
+	if lic != nil {
+		entry.Customer = lic.Email()
+		countVolume(c, volumeRevoked, lic.Product)
+	}
+
+	if err := audit(c, entry); err != nil {
+		env.Errorf(c, "Could not record the revocation of %v in the audit log: %v", id, err)
 	}
 
-	err = sc.MakePublic("revocations.json")
+	return nil
+}
 
-	if err != nil {
-		return &appError{err, "An error occured when making the revocations.json file public", http.StatusInternalServerError}
+// RevokeLicense handles POST requests to /api/licenses/{ID}/revoke
+//
+// The license is revoked straight away unless the request body has an
+// effective_at time (RFC 3339) in the future, then the stored license is
+// scheduled to be revoked at that time by the scheduled revocations job and
+// stays valid until then. Scheduling again moves the revocation. With
+// ?sandbox=true the license is a test license.
+//
+// Examples:
+//
+//	POST /api/licenses/daS7y8sioiecYy/revoke
+//	200 "SUCCESS"
+//
+//	POST /api/licenses/daS7y8sioiecYy/revoke {"effective_at": "2026-10-28T00:00:00Z"}
+//	200 {"revokeAt": "2026-10-28T00:00:00Z"}
+//
+//	POST /api/licenses/daS7y8sioiecYy/revoke?dry_run=true
+//	200 {"dryRun": true, "id": "daS7y8sioiecYy", "stored": true, "revoked": false}
+func RevokeLicense(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	vars := mux.Vars(r)
+	id := vars["id"]
+	namespace := ""
+
+	if r.URL.Query().Get("sandbox") == "true" {
+		namespace = store.SandboxNamespace
+	}
+
+	var req struct {
+		EffectiveAt *time.Time `json:"effective_at"`
+	}
+
+	dry, e := dryRun(r)
+
+	if e != nil {
+		return e
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return &appError{err, "Could not decode json request, effective_at must be an RFC 3339 time", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	scheduled := req.EffectiveAt != nil && req.EffectiveAt.After(time.Now())
+
+	if dry {
+		if !scheduled {
+			req.EffectiveAt = nil
+		}
+
+		return previewRevocation(c, w, namespace, id, req.EffectiveAt)
+	}
+
+	if scheduled {
+		return scheduleRevocation(c, w, namespace, id, *req.EffectiveAt)
+	}
+
+	if err := revokeLicense(c, namespace, store.Revocation{ID: id}, nil); err != nil {
+		return &appError{err, "An error occurred updating the revocations file", http.StatusInternalServerError, codeStorageUnavailable}
 	}
 
+	notifyWebhooks(c, "license.revoked", map[string]string{"id": id})
+	notifySlack(c, "license.revoked", fmt.Sprintf("License %v was revoked by %v", id, actor(c)))
+
 	writeJSON(w, 200, "SUCCESS")
 
 	return nil
+}
+
+func DecodeLicense(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	var req struct{ License string }
+	var err error
+
+	if err = json.NewDecoder(http.MaxBytesReader(w, r.Body, maxValidateRequestSize)).Decode(&req); err != nil {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	// req successfully Decoded
 
+	// the product decides which key the license should have been signed
+	// with, it is verified along with everything else
+	l, err := verifyLicense(req.License, func(kid string) (*rsa.PublicKey, error) {
+		return getPublicKey(c, kid)
+	})
+	countDecode(c, req.License, err)
+
+	if _, invalid := err.(*invalidError); invalid {
+		return &appError{err, "An error occured parsing the token", http.StatusBadRequest, codeTokenInvalid}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load public key for verifying", http.StatusInternalServerError, codeKeyUnavailable}
+	}
+
+	// license successfuly decoded - now lets return the response
+	writeJSON(w, 200, l)
+
+	return nil
 }
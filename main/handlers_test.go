@@ -0,0 +1,403 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/config"
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/licensingtest"
+	"github.com/volcanicpixels/licensing/store"
+	"github.com/volcanicpixels/licensing/translog"
+)
+
+// newTestServer serves the API from an in-memory platform with the default
+// config, callers should close the server.
+func newTestServer(t *testing.T) (*licensingtest.Server, *licensingtest.Platform) {
+	c, err := config.Load("")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := licensingtest.NewPlatform()
+	cfg, env = c, p
+
+	return licensingtest.NewServer(newAPIRouter()), p
+}
+
+// useAPIKey makes the server's requests with an API key configured like key,
+// instead of as an app admin.
+func useAPIKey(s *licensingtest.Server, p *licensingtest.Platform, key config.APIKey) {
+	sum := sha256.Sum256([]byte(key.ID + "-key"))
+	key.Hash = hex.EncodeToString(sum[:])
+	cfg.APIKeys = append(cfg.APIKeys, key)
+	p.Admin, s.APIKey = false, key.ID+"-key"
+}
+
+// createLicense issues a domain_changer license, returning its ID.
+func createLicense(t *testing.T, s *licensingtest.Server) string {
+	return createLicenseWith(t, s, map[string]interface{}{"product": "domain_changer"})
+}
+
+// createLicenseWith issues a license for a create request, returning its ID.
+func createLicenseWith(t *testing.T, s *licensingtest.Server, req map[string]interface{}) string {
+	resp, err := s.Post("/api/v2/licenses", req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("creating a license: %v %s", resp.StatusCode, resp.Body)
+	}
+
+	var body struct {
+		Data createdLicense `json:"data"`
+	}
+
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+
+	return body.Data.ID
+}
+
+func TestNewLicense(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	id := createLicense(t, s)
+	lic, err := p.LicenseStore.Get(context.Background(), id)
+
+	if err != nil {
+		t.Fatalf("the license wasn't stored: %v", err)
+	}
+
+	if lic.Product != "domain_changer" {
+		t.Errorf("product = %q, want domain_changer", lic.Product)
+	}
+}
+
+func TestNewLicenseUnknownProduct(t *testing.T) {
+	s, _ := newTestServer(t)
+	defer s.Close()
+
+	resp, err := s.Post("/api/licenses", map[string]string{"product": "domain_chnager"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body response
+
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 400 || body.Code != codeProductUnknown || body.Field != "product" {
+		t.Fatalf("got %v %s, want a 400 %v on product", resp.StatusCode, resp.Body, codeProductUnknown)
+	}
+
+	if len(body.Allowed) != 1 || body.Allowed[0] != config.DefaultProduct {
+		t.Errorf("allowed = %v, want the default product", body.Allowed)
+	}
+}
+
+func TestRevokeLicenseConcurrently(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	const n = 20
+	ids := make([]string, n)
+
+	for i := range ids {
+		ids[i] = createLicense(t, s)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+
+	for _, id := range ids {
+		wg.Add(1)
+
+		go func(id string) {
+			defer wg.Done()
+
+			resp, err := s.Post("/api/licenses/"+id+"/revoke", nil)
+
+			if err == nil && resp.StatusCode != 200 {
+				err = fmt.Errorf("revoking %v: %v %s", id, resp.StatusCode, resp.Body)
+			}
+
+			errs <- err
+		}(id)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	revoked, err := p.RevocationStore.List(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(revoked) != n {
+		t.Errorf("%v revocations, want %v", len(revoked), n)
+	}
+
+	entries, err := translog.New(p.Files, cfg.Revocations.Log).Entries()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logged := make(map[string]bool)
+
+	for i, e := range entries {
+		if e.Index != i {
+			t.Errorf("entry %v has index %v", i, e.Index)
+		}
+
+		logged[e.ID] = true
+	}
+
+	for _, id := range ids {
+		if !logged[id] {
+			t.Errorf("%v isn't in the transparency log", id)
+		}
+
+		if lic, err := p.LicenseStore.Get(context.Background(), id); err != nil || lic.RevokedAt == nil {
+			t.Errorf("%v isn't marked revoked: %v", id, err)
+		}
+	}
+}
+
+func TestValidateSandboxLicenseID(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	sum := sha256.Sum256([]byte("sandbox-key"))
+	cfg.APIKeys = []config.APIKey{{ID: "test", Hash: hex.EncodeToString(sum[:]), Sandbox: true}}
+	p.Admin, s.APIKey = false, "sandbox-key"
+
+	id := createLicense(t, s)
+
+	if _, err := p.SandboxLicenseStore.Get(context.Background(), id); err != nil {
+		t.Fatalf("the license isn't in the sandbox: %v", err)
+	}
+
+	resp, err := s.Post("/api/licenses/validate", map[string]string{"license": id})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v verdict
+
+	if err := resp.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Status != statusValid {
+		t.Errorf("got %v %s, want a valid verdict", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestKeyQuotaConcurrently(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	const quota, n = 5, 20
+	sum := sha256.Sum256([]byte("quota-key"))
+	cfg.APIKeys = []config.APIKey{{ID: "test", Hash: hex.EncodeToString(sum[:]), DailyQuota: quota}}
+	p.Admin, s.APIKey = false, "quota-key"
+
+	var wg sync.WaitGroup
+	statuses := make(chan int, n)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			resp, err := s.Post("/api/v2/licenses", map[string]string{"product": "domain_changer"})
+
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			statuses <- resp.StatusCode
+		}()
+	}
+
+	wg.Wait()
+	close(statuses)
+
+	counts := make(map[int]int)
+
+	for status := range statuses {
+		counts[status]++
+	}
+
+	if counts[200] != quota || counts[429] != n-quota {
+		t.Errorf("got statuses %v, want %v 200s and %v 429s", counts, quota, n-quota)
+	}
+
+	q := keyQuotas(&cfg.APIKeys[0], time.Now())[0]
+
+	if count, err := p.CounterStore.Count(context.Background(), q.counter); err != nil || count != quota {
+		t.Errorf("%v counted against the quota, want %v: %v", count, quota, err)
+	}
+}
+
+func TestRevokeSandboxLicense(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	useAPIKey(s, p, config.APIKey{ID: "sandbox", Sandbox: true})
+	id := createLicense(t, s)
+
+	// revoking is for admins, who have no sandbox key
+	p.Admin, s.APIKey = true, ""
+	resp, err := s.Post("/api/licenses/"+id+"/revoke?sandbox=true", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("revoking %v: %v %s", id, resp.StatusCode, resp.Body)
+	}
+
+	useAPIKey(s, p, config.APIKey{ID: "sandbox", Sandbox: true})
+
+	if resp, err = s.Get("/api/v2/licenses/" + id); err != nil {
+		t.Fatal(err)
+	}
+
+	var body struct {
+		Data struct {
+			License license.License `json:"license"`
+		} `json:"data"`
+	}
+
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 200 || body.Data.License.RevokedAt == nil {
+		t.Errorf("got %v %s, want the license revoked", resp.StatusCode, resp.Body)
+	}
+
+	if _, err := p.LicenseStore.Get(context.Background(), id); err == nil {
+		t.Error("revoking the sandbox license stored it in production")
+	}
+}
+
+func TestNewLicenseSameOrder(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	req := map[string]interface{}{"product": "domain_changer", "order_id": "1042"}
+	first := createLicenseWith(t, s, req)
+
+	const n = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			resp, err := s.Post("/api/v2/licenses", req)
+
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			var body struct {
+				Data         createdLicense `json:"data"`
+				Deduplicated bool           `json:"deduplicated"`
+			}
+
+			if err := json.Unmarshal(resp.Body, &body); err != nil {
+				errs <- err
+				return
+			}
+
+			if resp.StatusCode != 200 || !body.Deduplicated || body.Data.ID != first {
+				err = fmt.Errorf("got %v %s, want license %v of the order", resp.StatusCode, resp.Body, first)
+			}
+
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if stored, _, err := p.LicenseStore.List(context.Background(), store.Query{}); err != nil || len(stored) != 1 {
+		t.Errorf("%v licenses stored for one order, want 1: %v", len(stored), err)
+	}
+}
+
+func TestNewLicenseOrderReleased(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	product := cfg.Products["domain_changer"]
+	product.Cap = 1
+	cfg.Products["domain_changer"] = product
+
+	createLicenseWith(t, s, map[string]interface{}{"product": "domain_changer", "order_id": "1"})
+
+	req := map[string]interface{}{"product": "domain_changer", "order_id": "2"}
+	resp, err := s.Post("/api/v2/licenses", req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 409 {
+		t.Fatalf("got %v %s, want the product sold out", resp.StatusCode, resp.Body)
+	}
+
+	if _, err := p.OrderStore.Get(context.Background(), "domain_changer", "2"); err != store.ErrNotFound {
+		t.Fatalf("the failed order is still claimed: %v", err)
+	}
+
+	// once issuing can succeed the order gets a license of its own
+	product.Cap = 2
+	cfg.Products["domain_changer"] = product
+	id := createLicenseWith(t, s, req)
+
+	if claimed, err := p.OrderStore.Get(context.Background(), "domain_changer", "2"); err != nil || claimed != id {
+		t.Errorf("the order is claimed for %q, want %v: %v", claimed, id, err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/volcanicpixels/licensing/license"
+)
+
+// maxInvoiceLength is the longest invoice number a license can be tagged with,
+// and maxReceiptURLLength the longest receipt URL.
+const (
+	maxInvoiceLength    = 64
+	maxReceiptURLLength = 2048
+)
+
+// setInvoice sets the invoice number and receipt URL of a license, those that
+// are nil are left as they are and empty strings clear them. The error is a
+// *fieldError naming the invalid one.
+func setInvoice(lic *license.License, invoice, receiptURL *string) error {
+	if invoice != nil {
+		number := strings.TrimSpace(*invoice)
+
+		if len(number) > maxInvoiceLength {
+			return &fieldError{Field: "invoice", err: fmt.Errorf("longer than %v characters", maxInvoiceLength)}
+		}
+
+		lic.Invoice = number
+	}
+
+	if receiptURL != nil {
+		s := strings.TrimSpace(*receiptURL)
+
+		if s != "" {
+			u, err := url.Parse(s)
+
+			if err == nil && (u.Scheme != "http" && u.Scheme != "https" || u.Host == "") {
+				err = errors.New("not an http or https URL")
+			}
+
+			if err == nil && len(s) > maxReceiptURLLength {
+				err = fmt.Errorf("longer than %v characters", maxReceiptURLLength)
+			}
+
+			if err != nil {
+				return &fieldError{Field: "receipt_url", err: err}
+			}
+		}
+
+		lic.ReceiptURL = s
+	}
+
+	return nil
+}
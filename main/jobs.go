@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/dchest/uniuri"
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// jobTaskPath is where the tasks that run the batches of jobs are posted.
+const jobTaskPath = "/api/tasks/jobs"
+
+// maxJobListLimit is the most jobs listed per page.
+const maxJobListLimit = 100
+
+// jobBatch runs the batch of a job that starts at its cursor, updating its
+// counts and result, and returns the cursor of the next batch, which is
+// empty once the job is done. A *jobError fails the job, other errors are
+// retried by the task queue.
+type jobBatch func(c context.Context, job *store.Job) (cursor string, err error)
+
+// jobKinds are the batches of each kind of job.
+var jobKinds = map[string]jobBatch{
+	jobBulk:       runBulkBatch,
+	jobResign:     runResignBatch,
+	jobRebuild:    runRebuildBatch,
+	jobSeedEvents: runSeedBatch,
+	jobSnapshot:   runSnapshotBatch,
+	jobRestore:    runRestoreBatch,
+}
+
+// jobError is an error a job can't get past, such as parameters that don't
+// parse, so retrying its batch is pointless.
+type jobError struct {
+	err error
+}
+
+func (e *jobError) Error() string {
+	return e.err.Error()
+}
+
+// startJob stores a new job of a kind and enqueues its first batch.
+func startJob(c context.Context, kind string, params map[string]string) (*store.Job, error) {
+	if _, ok := jobKinds[kind]; !ok {
+		return nil, fmt.Errorf("unknown job kind %q", kind)
+	}
+
+	now := time.Now()
+	job := &store.Job{
+		ID:        uniuri.New(),
+		Kind:      kind,
+		Status:    store.JobRunning,
+		Params:    params,
+		CreatedBy: actor(c),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := env.Jobs(c).Put(c, job); err != nil {
+		return nil, err
+	}
+
+	if err := env.Enqueue(c, jobTaskPath, url.Values{"id": {job.ID}}); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// RunJob handles POST requests to /api/tasks/jobs
+//
+// It is the task that runs a batch of a job and enqueues itself for the
+// next. The task names the cursor it starts at, so a task that is retried
+// after its batch was recorded does nothing instead of running the batch
+// twice, and jobs that were cancelled stop at the next batch.
+func RunJob(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	jobs := env.Jobs(c)
+	job, err := jobs.Get(c, r.FormValue("id"))
+
+	// tasks for jobs that are gone mustn't be retried forever
+	if err == store.ErrNotFound {
+		env.Warningf(c, "Job %v not found", r.FormValue("id"))
+		writeJSON(w, 200, "SUCCESS")
+		return nil
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load the job", http.StatusInternalServerError, codeInternal}
+	}
+
+	if job.Status != store.JobRunning || job.Cursor != r.FormValue("cursor") {
+		writeJSON(w, 200, "SUCCESS")
+		return nil
+	}
+
+	batch, ok := jobKinds[job.Kind]
+
+	if !ok {
+		err = &jobError{fmt.Errorf("unknown job kind %q", job.Kind)}
+	} else {
+		job.Cursor, err = batch(c, job)
+	}
+
+	if _, failed := err.(*jobError); failed {
+		env.Errorf(c, "Job %v failed: %v", job.ID, err)
+		job.Status = store.JobFailed
+		job.Error = err.Error()
+	} else if err != nil {
+		return &appError{err, "An error occurred running the job", http.StatusInternalServerError, codeInternal}
+	} else if job.Cursor == "" {
+		job.Status = store.JobDone
+	}
+
+	// a job cancelled during the batch stays cancelled
+	if current, err := jobs.Get(c, job.ID); err == nil && current.Status == store.JobCancelled {
+		job.Status = store.JobCancelled
+	}
+
+	job.UpdatedAt = time.Now()
+
+	if err := jobs.Put(c, job); err != nil {
+		return &appError{err, "Could not store the job", http.StatusInternalServerError, codeInternal}
+	}
+
+	if job.Status == store.JobRunning {
+		if err := env.Enqueue(c, jobTaskPath, url.Values{"id": {job.ID}, "cursor": {job.Cursor}}); err != nil {
+			return &appError{err, "Could not continue the job", http.StatusInternalServerError, codeInternal}
+		}
+	}
+
+	writeJSON(w, 200, "SUCCESS")
+	return nil
+}
+
+// loadJob returns the job with the ID in the request path.
+func loadJob(c context.Context, r *http.Request) (*store.Job, *appError) {
+	job, err := env.Jobs(c).Get(c, mux.Vars(r)["id"])
+
+	if err == store.ErrNotFound {
+		return nil, &appError{err, "Job not found", http.StatusNotFound, codeJobNotFound}
+	}
+
+	if err != nil {
+		return nil, &appError{err, "Could not load the job", http.StatusInternalServerError, codeInternal}
+	}
+
+	return job, nil
+}
+
+// GetJob handles GET requests to /api/jobs/{id}
+//
+// It returns a background job's status (running, done, failed or
+// cancelled), how much it has processed and failed to, and what it produced.
+//
+// Example:
+//
+//	GET /api/jobs/p3Bd9sKe0aQvT1xZ
+//	200 {"id": "p3Bd9sKe0aQvT1xZ", "kind": "bulk", "status": "done", "params": {...}, "processed": 1200, "failed": 0, ...}
+func GetJob(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	job, e := loadJob(c, r)
+
+	if e != nil {
+		return e
+	}
+
+	writeJSON(w, 200, job)
+	return nil
+}
+
+// ListJobs handles GET requests to /api/jobs
+//
+// It lists the background jobs newest first, a page of limit (50 by
+// default) at a time. Pass the returned cursor to get the next page.
+func ListJobs(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	limit := 50
+
+	if s := r.URL.Query().Get("limit"); s != "" {
+		var err error
+
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > maxJobListLimit {
+			return &appError{fmt.Errorf("invalid limit %q", s), "limit must be from 1 to 100", http.StatusBadRequest, codeInvalidRequest}
+		}
+	}
+
+	jobs, cursor, err := env.Jobs(c).List(c, r.URL.Query().Get("cursor"), limit)
+
+	if err != nil {
+		return &appError{err, "An error occurred listing the jobs", http.StatusInternalServerError, codeInternal}
+	}
+
+	if jobs == nil {
+		jobs = []store.Job{}
+	}
+
+	writeJSON(w, 200, struct {
+		Jobs   []store.Job `json:"jobs"`
+		Cursor string      `json:"cursor,omitempty"`
+	}{jobs, cursor})
+
+	return nil
+}
+
+// CancelJob handles POST requests to /api/jobs/{id}/cancel
+//
+// It stops a running job before its next batch, what it has done so far
+// isn't undone. Jobs that have finished can't be cancelled.
+func CancelJob(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	job, e := loadJob(c, r)
+
+	if e != nil {
+		return e
+	}
+
+	if job.Status != store.JobRunning {
+		return &appError{errors.New("job not running"), "The job has already finished", http.StatusConflict, codeInvalidRequest}
+	}
+
+	job.Status = store.JobCancelled
+	job.UpdatedAt = time.Now()
+
+	if err := env.Jobs(c).Put(c, job); err != nil {
+		return &appError{err, "Could not store the job", http.StatusInternalServerError, codeInternal}
+	}
+
+	if err := audit(c, store.AuditEntry{Action: "job.cancel", Target: job.ID, Details: map[string]string{"kind": job.Kind}}); err != nil {
+		env.Errorf(c, "Could not record cancelling job %v in the audit log: %v", job.ID, err)
+	}
+
+	writeJSON(w, 200, job)
+	return nil
+}
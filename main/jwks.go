@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/net/context"
+)
+
+// jwksMaxAge is how long clients may cache the sandbox key set, in seconds.
+const jwksMaxAge = 3600
+
+// jwk is an RSA public key as a JSON Web Key (RFC 7517).
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
+
+// newJWK returns the JSON Web Key of a key that verifies RS256 signatures.
+func newJWK(kid string, key *rsa.PublicKey) jwk {
+	encode := base64.RawURLEncoding.EncodeToString
+	e := big.NewInt(int64(key.E)).Bytes()
+	return jwk{"RSA", kid, "sig", "RS256", encode(key.N.Bytes()), encode(e)}
+}
+
+// SandboxJWKS handles GET requests to /api/sandbox/jwks.json
+//
+// It returns the sandbox key, which test-mode licenses are signed with, as a
+// JSON Web Key Set so that client test suites can verify test licenses. It
+// is bare JSON for JWKS clients. Production builds must never trust it, its
+// path says it is the sandbox's so that it isn't mistaken for theirs.
+//
+// Example:
+//
+//	GET /api/sandbox/jwks.json
+//	200 {"keys": [{"kty": "RSA", "kid": "sandbox", "use": "sig", "alg": "RS256", "n": "1Skx...", "e": "AQAB"}]}
+func SandboxJWKS(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	kid := cfg.Keys.SandboxID
+
+	if kid == "" {
+		return &appError{errors.New("no sandbox key"), "There is no sandbox key", http.StatusNotFound, codeNotFound}
+	}
+
+	key, err := getPublicKey(c, kid)
+
+	if err != nil {
+		return &appError{err, "Could not load the sandbox key", http.StatusInternalServerError, codeKeyUnavailable}
+	}
+
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(jwksMaxAge))
+
+	json.NewEncoder(w).Encode(struct {
+		Keys []jwk `json:"keys"`
+	}{[]jwk{newJWK(kid, key)}})
+
+	return nil
+}
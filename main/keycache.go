@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/rsa"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// keyCacheKey identifies a cached private key, product is empty for the
+// keys that aren't a product's.
+type keyCacheKey struct {
+	product string
+	kid     string
+}
+
+type cachedKey struct {
+	key     *rsa.PrivateKey
+	fetched time.Time
+}
+
+// privateKeyCache keeps parsed private keys in memory so that issuing a
+// license doesn't read and parse its key every time. Keys are loaded again
+// once they are older than keys.cache_ttl.
+type privateKeyCache struct {
+	mu      sync.Mutex
+	entries map[keyCacheKey]cachedKey
+}
+
+var privateKeys = &privateKeyCache{entries: make(map[keyCacheKey]cachedKey)}
+
+// get returns the cached key, loading it with load if it isn't cached or has
+// expired. Failures aren't cached.
+func (kc *privateKeyCache) get(k keyCacheKey, load func() (*rsa.PrivateKey, error)) (*rsa.PrivateKey, error) {
+	ttl := cfg.Keys.CacheTTL
+
+	if ttl == 0 {
+		return load()
+	}
+
+	kc.mu.Lock()
+	e, ok := kc.entries[k]
+	kc.mu.Unlock()
+
+	if ok && time.Since(e.fetched) < ttl {
+		return e.key, nil
+	}
+
+	key, err := load()
+
+	if err != nil {
+		return nil, err
+	}
+
+	kc.mu.Lock()
+	kc.entries[k] = cachedKey{key, time.Now()}
+	kc.mu.Unlock()
+
+	return key, nil
+}
+
+// invalidate drops the cached keys of a product, they are loaded again when
+// next used.
+func (kc *privateKeyCache) invalidate(product string) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	for k := range kc.entries {
+		if k.product == product {
+			delete(kc.entries, k)
+		}
+	}
+}
+
+// productPrivateKey returns the private key kid that licenses for a product
+// are signed with, from the cache if it is in it.
+func productPrivateKey(c context.Context, product, kid string) (*rsa.PrivateKey, error) {
+	return privateKeys.get(keyCacheKey{product, kid}, func() (*rsa.PrivateKey, error) {
+		return loadPrivateKey(c, kid)
+	})
+}
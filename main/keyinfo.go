@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/storage"
+)
+
+// Key statuses, active keys sign new licenses or tokens and retired ones are
+// only trusted for verifying what they signed before. What compromised keys
+// signed must be re-signed.
+const (
+	keyActive      = "active"
+	keyRetired     = "retired"
+	keyCompromised = "compromised"
+)
+
+// keyHistoryFile records when each key was first configured and when it
+// stopped signing, for keys that have no other record of it.
+const keyHistoryFile = "key-history.json"
+
+type keyRecord struct {
+	FirstSeen time.Time  `json:"firstSeen"`
+	RetiredAt *time.Time `json:"retiredAt,omitempty"`
+}
+
+// keyInfo describes a key for GET /api/keys.
+type keyInfo struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Roles       []string   `json:"roles"`
+	Algorithm   string     `json:"algorithm"`
+	Bits        int        `json:"bits,omitempty"`
+	Fingerprint string     `json:"fingerprint,omitempty"` // hex SHA-256 of the PKIX public key
+	CreatedAt   *time.Time `json:"createdAt,omitempty"`
+	RetiredAt   *time.Time `json:"retiredAt,omitempty"`
+	Signed      int        `json:"signed"` // licenses issued with the key
+	Error       string     `json:"error,omitempty"`
+}
+
+// keyRoles returns the configured keys and what each is used for.
+func keyRoles() map[string][]string {
+	roles := make(map[string][]string)
+	add := func(kid, role string) {
+		if kid != "" {
+			roles[kid] = append(roles[kid], role)
+		}
+	}
+
+	add(cfg.Keys.ID, "default")
+	add(cfg.Keys.SandboxID, "sandbox")
+	add(cfg.Keys.CrossSignID, "cross_sign")
+	add(cfg.AccessToken.Key, "access_tokens")
+
+	names := make([]string, 0, len(cfg.Products))
+
+	for name := range cfg.Products {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if p := cfg.Products[name]; p.Key != "" {
+			add(p.Key, "product:"+name)
+			add(p.CrossSignKey, "cross_sign:"+name)
+		}
+	}
+
+	add(cfg.Keys.RootID, "root")
+	add(cfg.Keys.LegacyID, "legacy")
+
+	return roles
+}
+
+// isSigningRole reports whether a role signs new licenses or tokens, the root
+// key only certifies and the legacy key only verifies.
+func isSigningRole(role string) bool {
+	return role != "root" && role != "legacy"
+}
+
+// signedCounter names the counter of the licenses issued with a key.
+func signedCounter(kid string) string {
+	return "signed:" + kid
+}
+
+// issuingKeyIDs returns the keys a new license is signed with, which is two
+// while its product's key is being rotated.
+func issuingKeyIDs(lic *license.License) []string {
+	kids := []string{signingKeyID(lic)}
+
+	if crossID := crossSignKeyID(lic.Product); crossID != "" && !lic.Test {
+		kids = append(kids, crossID)
+	}
+
+	return kids
+}
+
+// countSigned counts an issued license towards the keys it was signed with.
+// Errors are logged rather than failing the issuance.
+func countSigned(c context.Context, kids ...string) {
+	for _, kid := range kids {
+		if err := env.Counters(c).Increment(c, signedCounter(kid)); err != nil {
+			env.Errorf(c, "Could not count a license signed with key %v: %v", kid, err)
+		}
+	}
+}
+
+// readKeyHistory returns the recorded history of the keys, which is empty
+// until the revocation cron has first run.
+func readKeyHistory(sc storage.Storage) (map[string]*keyRecord, error) {
+	history := make(map[string]*keyRecord)
+	data, err := sc.ReadFile(keyHistoryFile)
+
+	if err == storage.ErrNotExist {
+		return history, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return history, json.Unmarshal(data, &history)
+}
+
+// recordKeyHistory notes the keys configured for the first time and those
+// that stopped or started signing again since it last ran.
+func recordKeyHistory(sc storage.Storage, now time.Time) error {
+	history, err := readKeyHistory(sc)
+
+	if err != nil {
+		return err
+	}
+
+	roles := keyRoles()
+	changed := false
+
+	for kid, rs := range roles {
+		if _, ok := history[kid]; !ok {
+			history[kid] = &keyRecord{FirstSeen: now}
+			changed = true
+		}
+
+		if signsWith(rs) && history[kid].RetiredAt != nil {
+			history[kid].RetiredAt = nil
+			changed = true
+		}
+	}
+
+	for kid, rec := range history {
+		if rec.RetiredAt == nil && !signsWith(roles[kid]) {
+			t := now
+			rec.RetiredAt = &t
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	data, err := json.Marshal(history)
+
+	if err != nil {
+		return err
+	}
+
+	return sc.WriteFile(keyHistoryFile, data)
+}
+
+// signsWith reports whether any of a key's roles signs.
+func signsWith(roles []string) bool {
+	for _, role := range roles {
+		if isSigningRole(role) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// describeKey loads a key's public key and usage. Failures to load the key
+// are reported in the info rather than returned.
+func describeKey(c context.Context, kid string, roles []string, rec *keyRecord) (*keyInfo, error) {
+	info := &keyInfo{ID: kid, Status: keyRetired, Roles: roles, Algorithm: "RS256"}
+
+	if info.Roles == nil {
+		info.Roles = []string{}
+	}
+
+	switch {
+	case cfg.IsCompromised(kid):
+		info.Status = keyCompromised
+	case signsWith(roles):
+		info.Status = keyActive
+	}
+
+	if rec != nil {
+		info.CreatedAt = &rec.FirstSeen
+		info.RetiredAt = rec.RetiredAt
+	}
+
+	signed, err := env.Counters(c).Count(c, signedCounter(kid))
+
+	if err != nil {
+		return nil, err
+	}
+
+	info.Signed = signed
+
+	key, err := getPublicKey(c, kid)
+
+	if err != nil {
+		info.Error = fmt.Sprintf("could not load the public key: %v", err)
+		return info, nil
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+
+	if err != nil {
+		info.Error = err.Error()
+		return info, nil
+	}
+
+	sum := sha256.Sum256(der)
+	info.Fingerprint = hex.EncodeToString(sum[:])
+	info.Bits = key.N.BitLen()
+
+	// a certified key was created no later than its certificate
+	if cfg.Keys.RootID != "" {
+		if cert, err := getCertificate(c, kid); err == nil && cert != "" {
+			if root, err := getPublicKey(c, cfg.Keys.RootID); err == nil {
+				if parsed, err := license.ParseCertificate(cert, root); err == nil && (info.CreatedAt == nil || parsed.IssuedAt.Before(*info.CreatedAt)) {
+					info.CreatedAt = &parsed.IssuedAt
+				}
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// ListKeys handles GET requests to /api/keys
+//
+// The response lists the configured keys and those configured before, with
+// their status, what they are used for, the SHA-256 fingerprint of their
+// public key, when they were first configured (or certified, if earlier) and
+// retired, and how many licenses were issued with them, to plan rotations.
+// Retired keys no longer sign anything but licenses they signed may still be
+// in use. Counts start from when this was added.
+//
+// Example:
+//
+//	GET /api/keys
+//	200 [{"id": "plugin", "status": "active", "roles": ["default"], "algorithm": "RS256", "bits": 2048, "fingerprint": "9f2c...", "createdAt": "...", "signed": 1042}, ...]
+func ListKeys(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	sc, err := newStorage(c)
+
+	if err != nil {
+		return &appError{err, "Could not open storage", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	history, err := readKeyHistory(sc)
+
+	if err != nil {
+		return &appError{err, "Could not read the key history", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	roles := keyRoles()
+	kids := make([]string, 0, len(roles))
+
+	for kid := range roles {
+		kids = append(kids, kid)
+	}
+
+	for kid := range history {
+		if _, ok := roles[kid]; !ok && !cfg.IsCompromised(kid) {
+			kids = append(kids, kid)
+		}
+	}
+
+	for _, kid := range cfg.Keys.Compromised {
+		if _, ok := roles[kid]; !ok {
+			kids = append(kids, kid)
+		}
+	}
+
+	sort.Strings(kids)
+	keys := make([]*keyInfo, 0, len(kids))
+
+	for _, kid := range kids {
+		info, err := describeKey(c, kid, roles[kid], history[kid])
+
+		if err != nil {
+			return &appError{err, "Could not count the licenses signed with the keys", http.StatusInternalServerError, codeInternal}
+		}
+
+		keys = append(keys, info)
+	}
+
+	// active keys first
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].Status == keyActive && keys[j].Status != keyActive
+	})
+
+	writeJSON(w, 200, keys)
+	return nil
+}
@@ -2,12 +2,29 @@ package main
 
 import (
 	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/danielchatfield/go-jwt"
 	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/license/verify"
+	"github.com/volcanicpixels/licensing/secrets"
+	"github.com/volcanicpixels/licensing/storage"
 )
 
+// getPrivateKey returns the private key kid, which isn't a product's signing
+// key, from the cache if it is in it.
 func getPrivateKey(c context.Context, kid string) (*rsa.PrivateKey, error) {
+	return productPrivateKey(c, "", kid)
+}
+
+// loadPrivateKey reads and parses the private key kid.
+func loadPrivateKey(c context.Context, kid string) (*rsa.PrivateKey, error) {
 	file, err := getKey(c, kid, "private.pem")
 
 	if err != nil {
@@ -27,10 +44,289 @@ func getPublicKey(c context.Context, kid string) (*rsa.PublicKey, error) {
 	return jwt.ParseRSAPublicKeyFromPEM(file)
 }
 
+// getCertificate returns the encoded certificate of an intermediate key, or
+// an empty string if the key isn't certified by the root key.
+func getCertificate(c context.Context, kid string) (string, error) {
+	file, err := getKey(c, kid, "certificate.jwt")
+
+	if err == storage.ErrNotExist || err == secrets.ErrNotFound {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(file)), nil
+}
+
+// licenseCertificate returns the certificate a license signed with kid must
+// carry. If the root key is configured the certificate is checked to allow
+// the license so that we never issue one that won't verify.
+func licenseCertificate(c context.Context, kid string, l *license.License) (string, error) {
+	cert, err := getCertificate(c, kid)
+
+	if err != nil || cert == "" || cfg.Keys.RootID == "" {
+		return cert, err
+	}
+
+	root, err := getPublicKey(c, cfg.Keys.RootID)
+
+	if err != nil {
+		return "", err
+	}
+
+	parsed, err := license.ParseCertificate(cert, root)
+
+	if err != nil {
+		return "", err
+	}
+
+	if !parsed.AllowsLicense(l) {
+		return "", fmt.Errorf("the certificate of key %v doesn't allow %v licenses at %v", kid, strings.Join(append([]string{l.Product}, l.Products...), " and "), l.IssuedAt)
+	}
+
+	return cert, nil
+}
+
+// verifier verifies a license with a public key.
+type verifier func(token string, key *rsa.PublicKey) (*license.License, error)
+
+func parseLicense(token string, key *rsa.PublicKey) (*license.License, error) {
+	return license.Parse(token, key)
+}
+
+func parseLegacyLicense(token string, key *rsa.PublicKey) (*license.License, error) {
+	return license.ParseLegacy(token, key)
+}
+
+// licenseVerifier returns the IDs of the keys a license may be verified with
+// and how. Licenses signed by an intermediate key are verified through its
+// certificate with the root key, so they stay valid once the product's key
+// has been rotated. During a rotation licenses signed by the old key alone
+// are accepted too, and so are those signed by compromised keys for
+// verifySignature. The sandbox key is never certified. Licenses in the
+// legacy format are verified with the legacy key.
+func licenseVerifier(u *license.Unverified) ([]string, verifier) {
+	switch {
+	case u.Legacy:
+		return []string{cfg.Keys.LegacyID}, parseLegacyLicense
+	case u.Test():
+		return append([]string{cfg.Keys.SandboxID}, cfg.Keys.Compromised...), parseLicense
+	case u.Certificate() != "" && cfg.Keys.RootID != "":
+		return []string{cfg.Keys.RootID}, verify.License
+	}
+
+	kids := []string{productKeyID(u.Product())}
+
+	if old := crossSignKeyID(u.Product()); old != "" {
+		kids = append(kids, old)
+	}
+
+	// licenses signed with a compromised key still verify, so that they can
+	// be told to get a replacement rather than just rejected
+	return append(kids, cfg.Keys.Compromised...), parseLicense
+}
+
+// verifyLicense verifies a license with the first of the keys that it is
+// signed by, publicKey loads the keys. Errors loading keys are returned as
+// they are so that they can be told apart from invalid licenses. Licenses
+// signed with a compromised key are invalid.
+func verifyLicense(token string, publicKey func(kid string) (*rsa.PublicKey, error)) (*license.License, error) {
+	l, err := verifySignature(token, publicKey)
+
+	if err == nil && cfg.IsCompromised(l.KeyID) {
+		return nil, &invalidError{fmt.Errorf("the license is signed with compromised key %v and must be reissued", l.KeyID)}
+	}
+
+	return l, err
+}
+
+// verifySignature is verifyLicense accepting licenses signed with
+// compromised keys, for validation to report them.
+func verifySignature(token string, publicKey func(kid string) (*rsa.PublicKey, error)) (*license.License, error) {
+	u, err := license.Peek(token)
+
+	if err != nil {
+		return nil, &invalidError{err}
+	}
+
+	if u.Legacy && cfg.Keys.LegacyID == "" {
+		return nil, &invalidError{errors.New("legacy licenses aren't accepted")}
+	}
+
+	kids, verify := licenseVerifier(u)
+
+	for _, kid := range kids {
+		key, kerr := publicKey(kid)
+
+		if kerr != nil {
+			return nil, kerr
+		}
+
+		var l *license.License
+
+		if l, err = verify(token, key); err == nil {
+			l.KeyID = signedBy(l, kid, key)
+			return l, nil
+		}
+	}
+
+	return nil, &invalidError{err}
+}
+
+// signedBy returns the key that signed a license verified with kid, which is
+// the intermediate key named by its certificate if kid is the root key.
+func signedBy(l *license.License, kid string, key *rsa.PublicKey) string {
+	if l.Certificate == "" || kid != cfg.Keys.RootID {
+		return kid
+	}
+
+	if cert, err := license.ParseCertificate(l.Certificate, key); err == nil {
+		return cert.KeyID
+	}
+
+	return kid
+}
+
+// invalidError is returned by verifyLicense when the license is invalid.
+type invalidError struct {
+	err error
+}
+
+func (e *invalidError) Error() string {
+	return e.err.Error()
+}
+
+// crossSignKeyID returns the ID of the key that licenses for a product are
+// also signed with during a rotation, or an empty string.
+func crossSignKeyID(product string) string {
+	if p, ok := cfg.Products[product]; ok && p.Key != "" {
+		return p.CrossSignKey
+	}
+
+	return cfg.Keys.CrossSignID
+}
+
+// productKeyID returns the ID of the key that licenses for a product are
+// signed with.
+func productKeyID(product string) string {
+	if p, ok := cfg.Products[product]; ok && p.Key != "" {
+		return p.Key
+	}
+
+	return cfg.Keys.ID
+}
+
+// signingKeyID returns the ID of the key a license is signed with.
+func signingKeyID(l *license.License) string {
+	if l.Test {
+		return cfg.Keys.SandboxID
+	}
+
+	return productKeyID(l.Product)
+}
+
 func getKey(c context.Context, kid string, fileName string) (key []byte, err error) {
-	sc := NewStorageContext(c)
+	switch cfg.Keys.Source {
+	case "storage":
+	case "secretmanager":
+		// e.g. plugin-private-pem
+		return getSecret(c, kid+"-"+strings.Replace(fileName, ".", "-", -1))
+	default:
+		return nil, fmt.Errorf("unknown key source %q", cfg.Keys.Source)
+	}
+
+	sc, err := newStorage(c)
+
+	if err != nil {
+		return nil, err
+	}
 
 	// directory traversal is not a problem since this input is internal
 
 	return sc.ReadFile("keys/" + kid + "/" + fileName)
 }
+
+// publicKeyIDs returns the IDs of the keys that licenses and published files
+// are verified with, sorted.
+func publicKeyIDs() []string {
+	kids := map[string]bool{cfg.Keys.SandboxID: true, cfg.Keys.LegacyID: true}
+
+	for product := range cfg.Products {
+		for _, k := range offlineKeys(product) {
+			kids[k.ID] = true
+		}
+	}
+
+	var ids []string
+
+	for kid := range kids {
+		if kid != "" {
+			ids = append(ids, kid)
+		}
+	}
+
+	sort.Strings(ids)
+	return ids
+}
+
+// signingKeysFile records the key that each product was last seen signing
+// with so that rotations can be noticed, the default key is under "".
+const signingKeysFile = "signing-keys.json"
+
+// notifyKeyRotations posts to Slack when a product signs with a different key
+// than when it was last checked, which happens when its key is rotated in
+// the config. Nothing is posted the first time.
+func notifyKeyRotations(c context.Context, sc storage.Storage) error {
+	current := map[string]string{"": cfg.Keys.ID}
+
+	for name := range cfg.Products {
+		current[name] = productKeyID(name)
+	}
+
+	data, err := sc.ReadFile(signingKeysFile)
+
+	if err != nil && err != storage.ErrNotExist {
+		return err
+	}
+
+	if err == nil {
+		var previous map[string]string
+
+		if err := json.Unmarshal(data, &previous); err != nil {
+			return err
+		}
+
+		changed := false
+
+		for name, kid := range current {
+			old, ok := previous[name]
+			changed = changed || old != kid
+
+			if !ok || old == kid {
+				continue
+			}
+
+			privateKeys.invalidate(name)
+
+			what := "The default signing key"
+
+			if name != "" {
+				what = "The signing key of " + name
+			}
+
+			notifySlack(c, "key.rotated", fmt.Sprintf("%v was rotated from %v to %v", what, old, kid))
+		}
+
+		if !changed && len(previous) == len(current) {
+			return nil
+		}
+	}
+
+	if data, err = json.Marshal(current); err != nil {
+		return err
+	}
+
+	return sc.WriteFile(signingKeysFile, data)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/storage"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// Kill-switch modes, which override the verdict of every license of a
+// product during an incident. Off is only used in requests to clear one.
+const (
+	killSwitchFail = "fail"
+	killSwitchPass = "pass"
+	killSwitchOff  = "off"
+)
+
+// killSwitchFile holds the kill-switches that are set, by product.
+const killSwitchFile = "kill-switches.json"
+
+// killSwitchRefresh is how often an instance reads the kill-switches again,
+// so setting one takes this long to reach every instance.
+const killSwitchRefresh = 10 * time.Second
+
+// killSwitch is a product's emergency mode.
+type killSwitch struct {
+	Mode   string     `json:"mode"`
+	Reason string     `json:"reason,omitempty"`
+	SetAt  *time.Time `json:"setAt,omitempty"`
+	SetBy  string     `json:"setBy,omitempty"`
+}
+
+// killSwitchCache keeps the kill-switches in memory so that validations
+// don't read them from storage every time.
+type killSwitchCache struct {
+	mu       sync.Mutex
+	switches map[string]*killSwitch
+	fetched  time.Time
+
+	// refreshing is closed once the read in progress is done, it is nil
+	// when there is none
+	refreshing chan struct{}
+}
+
+var killSwitches = &killSwitchCache{}
+
+// get returns the kill-switch of a product, nil if none is set. One request
+// at a time reads them again once they are stale, the others use the ones
+// last read meanwhile, and the same if they can't be read. Only an instance
+// that has never read them waits for the read.
+func (kc *killSwitchCache) get(c context.Context, product string) *killSwitch {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	if time.Since(kc.fetched) >= killSwitchRefresh && kc.refreshing == nil {
+		done, started := make(chan struct{}), kc.fetched
+		kc.refreshing = done
+
+		kc.mu.Unlock()
+		kc.refresh(c, started, done)
+		kc.mu.Lock()
+	}
+
+	for kc.switches == nil && kc.refreshing != nil {
+		done := kc.refreshing
+
+		kc.mu.Unlock()
+		<-done
+		kc.mu.Lock()
+	}
+
+	return kc.switches[product]
+}
+
+// refresh reads the kill-switches without holding the lock, then closes
+// done. Those set on this instance since they were fetched at started are
+// kept.
+func (kc *killSwitchCache) refresh(c context.Context, started time.Time, done chan struct{}) {
+	switches, err := readKillSwitches(c)
+
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	defer close(done)
+	kc.refreshing = nil
+
+	if err != nil {
+		env.Warningf(c, "Could not read the kill-switches: %v", err)
+		kc.fetched = time.Now()
+		return
+	}
+
+	if kc.fetched.Equal(started) {
+		kc.switches, kc.fetched = switches, time.Now()
+	}
+}
+
+// set replaces the cached kill-switches after they are written, so that the
+// instance that set one uses it straight away.
+func (kc *killSwitchCache) set(switches map[string]*killSwitch) {
+	kc.mu.Lock()
+	defer kc.mu.Unlock()
+
+	kc.switches = switches
+	kc.fetched = time.Now()
+}
+
+// readKillSwitches returns the kill-switches that are set.
+func readKillSwitches(c context.Context) (map[string]*killSwitch, error) {
+	sc, err := newStorage(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := sc.ReadFile(killSwitchFile)
+
+	if err != nil && err != storage.ErrNotExist {
+		return nil, err
+	}
+
+	return parseKillSwitches(data)
+}
+
+// parseKillSwitches decodes the kill-switch file, which is empty if none has
+// been set.
+func parseKillSwitches(data []byte) (map[string]*killSwitch, error) {
+	switches := make(map[string]*killSwitch)
+
+	if len(data) == 0 {
+		return switches, nil
+	}
+
+	return switches, json.Unmarshal(data, &switches)
+}
+
+// applyKillSwitch overrides a verdict if its product's kill-switch is set.
+func applyKillSwitch(c context.Context, product string, vd *verdict) {
+	ks := killSwitches.get(c, product)
+
+	if ks == nil {
+		return
+	}
+
+	vd.Emergency = ks.Mode
+
+	switch ks.Mode {
+	case killSwitchFail:
+		vd.Status = statusSuspended
+		vd.Valid = false
+		vd.DaysRemaining = nil
+		vd.InGrace = false
+		vd.GraceEndsAt = nil
+	case killSwitchPass:
+		vd.Status = statusValid
+		vd.Valid = true
+	}
+}
+
+// GetKillSwitch handles GET requests to /api/products/{product}/kill-switch
+//
+// Example:
+//
+//	GET /api/products/domain_changer/kill-switch
+//	200 {"mode": "fail", "reason": "investigating the plugin key", "setAt": "...", "setBy": "admin"}
+func GetKillSwitch(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	product := mux.Vars(r)["product"]
+
+	if _, ok := cfg.Products[product]; !ok {
+		return &appError{fmt.Errorf("unknown product %q", product), "Product not found", http.StatusNotFound, codeProductUnknown}
+	}
+
+	switches, err := readKillSwitches(c)
+
+	if err != nil {
+		return &appError{err, "Could not read the kill-switches", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	ks := switches[product]
+
+	if ks == nil {
+		ks = &killSwitch{Mode: killSwitchOff}
+	}
+
+	writeJSON(w, 200, ks)
+	return nil
+}
+
+// SetKillSwitch handles PUT requests to /api/products/{product}/kill-switch
+//
+// The request body is a JSON object with the mode, fail, pass or off, and the
+// reason for it. While it is fail every license of the product fails
+// validation with the status suspended, and while it is pass every license
+// that verifies passes, whatever else is wrong with it. Either way verdicts
+// have the mode as emergency. Other instances notice within 10 seconds.
+//
+// Example:
+//
+//	PUT /api/products/domain_changer/kill-switch {"mode": "fail", "reason": "investigating the plugin key"}
+//	200 {"mode": "fail", "reason": "investigating the plugin key", "setAt": "...", "setBy": "admin"}
+func SetKillSwitch(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	product := mux.Vars(r)["product"]
+
+	if _, ok := cfg.Products[product]; !ok {
+		return &appError{fmt.Errorf("unknown product %q", product), "Product not found", http.StatusNotFound, codeProductUnknown}
+	}
+
+	var req struct {
+		Mode   string `json:"mode"`
+		Reason string `json:"reason"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	if req.Mode != killSwitchFail && req.Mode != killSwitchPass && req.Mode != killSwitchOff {
+		return &appError{errors.New("unknown mode"), "The mode must be fail, pass or off", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	sc, err := newStorage(c)
+
+	if err != nil {
+		return &appError{err, "Could not open storage", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	now := time.Now()
+	ks := &killSwitch{Mode: req.Mode, Reason: req.Reason, SetAt: &now, SetBy: actor(c)}
+	var switches map[string]*killSwitch
+
+	// the file is updated rather than overwritten so that kill-switches set
+	// at the same time for other products are kept
+	err = storage.UpdateFile(sc, killSwitchFile, func(data []byte) ([]byte, error) {
+		var err error
+
+		if switches, err = parseKillSwitches(data); err != nil {
+			return nil, err
+		}
+
+		if req.Mode == killSwitchOff {
+			delete(switches, product)
+		} else {
+			switches[product] = ks
+		}
+
+		return json.Marshal(switches)
+	})
+
+	if err != nil {
+		return &appError{err, "Could not store the kill-switch", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	killSwitches.set(switches)
+	text := fmt.Sprintf("The kill-switch of %v was set to %v by %v: %v", product, req.Mode, ks.SetBy, req.Reason)
+	env.Warningf(c, "%v", text)
+	notifySlack(c, "product.kill-switch", text)
+
+	entry := store.AuditEntry{
+		Action:  "product.kill-switch",
+		Target:  product,
+		Details: map[string]string{"mode": req.Mode, "reason": req.Reason},
+	}
+
+	if err := audit(c, entry); err != nil {
+		env.Errorf(c, "Could not record the kill-switch of %v in the audit log: %v", product, err)
+	}
+
+	writeJSON(w, 200, ks)
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/storage"
+)
+
+// slowStorage widens the window between reading and writing a file, like a
+// bucket would.
+type slowStorage struct {
+	storage.Storage
+}
+
+func (s slowStorage) ReadFile(fileName string) ([]byte, error) {
+	data, err := s.Storage.ReadFile(fileName)
+	time.Sleep(time.Millisecond)
+	return data, err
+}
+
+func TestSetKillSwitchConcurrently(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	p.FileStorage = slowStorage{p.Files}
+
+	const n = 20
+	products := make([]string, n)
+
+	for i := range products {
+		products[i] = fmt.Sprintf("product_%v", i)
+		cfg.Products[products[i]] = cfg.Products["domain_changer"]
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+
+	for _, product := range products {
+		wg.Add(1)
+
+		go func(product string) {
+			defer wg.Done()
+
+			resp, err := s.Do("PUT", "/api/products/"+product+"/kill-switch", map[string]string{"mode": "fail", "reason": "incident"})
+
+			if err == nil && resp.StatusCode != 200 {
+				err = fmt.Errorf("setting the kill-switch of %v: %v %s", product, resp.StatusCode, resp.Body)
+			}
+
+			errs <- err
+		}(product)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	switches, err := readKillSwitches(context.Background())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, product := range products {
+		if ks := switches[product]; ks == nil || ks.Mode != killSwitchFail {
+			t.Errorf("the kill-switch of %v was lost", product)
+		}
+	}
+}
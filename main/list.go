@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+const maxListLimit = 500
+
+// parseListQuery builds a store query from the URL query parameters.
+func parseListQuery(r *http.Request) (store.Query, error) {
+	v := r.URL.Query()
+
+	q := store.Query{
+		Product:  v.Get("product"),
+		Status:   v.Get("status"),
+		Email:    v.Get("email"),
+		Campaign: strings.ToLower(v.Get("campaign")),
+		Invoice:  strings.TrimSpace(v.Get("invoice")),
+		Tag:      strings.ToLower(strings.TrimSpace(v.Get("tag"))),
+		Order:    v.Get("sort"),
+		Cursor:   v.Get("cursor"),
+
+		CustomerID: strings.TrimSpace(v.Get("customer_id")),
+	}
+
+	switch q.Status {
+	case "", license.StatusActive, license.StatusRevoked, license.StatusExpired:
+	default:
+		return q, fmt.Errorf("unknown status %q", q.Status)
+	}
+
+	switch q.Order {
+	case "", store.OrderCreated, store.OrderExpiry:
+	default:
+		return q, fmt.Errorf("unknown sort %q", q.Order)
+	}
+
+	times := map[string]*time.Time{
+		"expiring_before": &q.ExpiringBefore,
+		"created_after":   &q.CreatedAfter,
+	}
+
+	for name, t := range times {
+		if s := v.Get(name); s != "" {
+			var err error
+
+			if *t, err = time.Parse(time.RFC3339, s); err != nil {
+				return q, fmt.Errorf("%v must be an RFC 3339 time", name)
+			}
+		}
+	}
+
+	if s := v.Get("limit"); s != "" {
+		var err error
+
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 1 || q.Limit > maxListLimit {
+			return q, fmt.Errorf("limit must be between 1 and %v", maxListLimit)
+		}
+	}
+
+	return q, nil
+}
+
+// ListLicenses handles GET requests to /api/licenses
+//
+// The licenses can be filtered with the product, status (active, revoked or
+// expired), email, campaign, invoice, customer_id, tag, expiring_before and created_after parameters,
+// the times are RFC 3339. They are sorted newest first or, with sort=expiry,
+// soonest to expire first (leaving out licenses that never expire). Pass the
+// returned cursor to get the next page, sandbox=true lists test licenses.
+//
+// Example:
+//
+//	GET /api/licenses?product=domain_changer&status=active&sort=expiry&limit=20
+//	200 {"licenses": [...], "cursor": "..."}
+func ListLicenses(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	q, err := parseListQuery(r)
+
+	if err != nil {
+		return &appError{err, err.Error(), http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	namespace := ""
+
+	if r.URL.Query().Get("sandbox") == "true" {
+		namespace = store.SandboxNamespace
+	}
+
+	licenses, cursor, err := env.Licenses(c, namespace).List(c, q)
+
+	if err != nil {
+		return &appError{err, "An error occurred listing the licenses", http.StatusInternalServerError, codeInternal}
+	}
+
+	if licenses == nil {
+		licenses = []*license.License{}
+	}
+
+	writeJSON(w, 200, struct {
+		Licenses []*license.License `json:"licenses"`
+		Cursor   string             `json:"cursor,omitempty"`
+	}{licenses, cursor})
+
+	return nil
+}
+
+// GetLicense handles GET requests to /api/licenses/{id}
+//
+// The response is a stored license with how many of its activations, and of
+// its seats if it has any, are used.
+//
+// Example:
+//
+//	GET /api/licenses/daS7y8sioiecYy
+//	200 {"license": {...}, "activations": {"used": 2, "allowed": 3}, "seats": {"used": 4, "allowed": 10}}
+func GetLicense(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	namespace := requestNamespace(c)
+	lic, err := env.Licenses(c, namespace).Get(c, mux.Vars(r)["id"])
+
+	if err == store.ErrNotFound {
+		return &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	activations, err := env.Activations(c, namespace).List(c, lic.ID)
+
+	if err != nil {
+		return &appError{err, "An error occurred listing the activations", http.StatusInternalServerError, codeInternal}
+	}
+
+	var seats *activationUsage
+
+	if lic.Seats > 0 {
+		users, err := env.Users(c, namespace).List(c, lic.ID)
+
+		if err != nil {
+			return &appError{err, "An error occurred listing the users", http.StatusInternalServerError, codeInternal}
+		}
+
+		seats = &activationUsage{len(users), lic.Seats}
+	}
+
+	writeJSON(w, 200, struct {
+		License     *license.License `json:"license"`
+		Activations *activationUsage `json:"activations"`
+		Seats       *activationUsage `json:"seats,omitempty"`
+	}{lic, &activationUsage{len(activations), activationLimit(lic)}, seats})
+
+	return nil
+}
+
+// ResaveLicenses handles POST requests to /api/licenses/_/resave
+//
+// It stores a page of licenses again, along with the cursor for the next
+// page, so that those stored before personal data was encrypted get
+// encrypted (and can be found by email). sandbox=true re-saves test
+// licenses.
+//
+// Example:
+//
+//	POST /api/licenses/_/resave?cursor=...
+//	200 {"resaved": 500, "cursor": "..."}
+func ResaveLicenses(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	namespace := ""
+
+	if r.URL.Query().Get("sandbox") == "true" {
+		namespace = store.SandboxNamespace
+	}
+
+	licenses := env.Licenses(c, namespace)
+	q := store.Query{Limit: maxListLimit, Cursor: r.URL.Query().Get("cursor")}
+
+	page, cursor, err := licenses.List(c, q)
+
+	if err != nil {
+		return &appError{err, "An error occurred listing the licenses", http.StatusInternalServerError, codeInternal}
+	}
+
+	for _, lic := range page {
+		if err := licenses.Put(c, lic); err != nil {
+			return &appError{err, "An error occurred storing license " + lic.ID, http.StatusInternalServerError, codeInternal}
+		}
+	}
+
+	writeJSON(w, 200, struct {
+		Resaved int    `json:"resaved"`
+		Cursor  string `json:"cursor,omitempty"`
+	}{len(page), cursor})
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// readOnlyRoutes are the routes that aren't GET requests but don't change a
+// store, so they are served during maintenance.
+var readOnlyRoutes = map[string]bool{
+	"DecodeLicense":         true,
+	"ValidateLicense":       true,
+	"ValidateLicenseBatch":  true,
+	"ValidateLicenseCanary": true,
+	"ListMembers":           true,
+	"NewAccessToken":        true,
+	"IssueDownloadToken":    true,
+	"PreviewEmailTemplate":  true,
+
+	// the migration is what maintenance is for
+	"MigrateRevocations": true,
+}
+
+// writingRoutes are the GET routes that change a store, EDD clients activate
+// with GET and the jobs are run by cron.
+var writingRoutes = map[string]bool{
+	"EDDAction":           true,
+	"SendExpiryReminders": true,
+	"Reconcile":           true,
+	"RevokeScheduled":     true,
+	"DeactivateStale":     true,
+	"TakeSnapshot":        true,
+}
+
+// changesStores reports whether a route may change a store.
+func changesStores(rt route) bool {
+	if rt.method == "GET" {
+		return writingRoutes[rt.name]
+	}
+
+	return !readOnlyRoutes[rt.name]
+}
+
+// inMaintenance rejects a route's requests with a 503 during maintenance if
+// it could change a store.
+func inMaintenance(rt route, h appHandler) appHandler {
+	if !cfg.Maintenance.Enabled || !changesStores(rt) {
+		return h
+	}
+
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+		w.Header().Set("Retry-After", strconv.Itoa(int(cfg.Maintenance.RetryAfter/time.Second)))
+		return &appError{errors.New("maintenance mode"), "The service is under maintenance, please retry later", http.StatusServiceUnavailable, codeMaintenance}
+	}
+}
+
+// maintenanceRevocations is the revocation list an instance read during
+// maintenance, nothing can be revoked until it ends so it is read once.
+var maintenanceRevocations struct {
+	sync.Mutex
+	list []store.Revocation
+}
+
+// listRevocations returns the revoked licenses, from memory during
+// maintenance so that validations don't read the store being migrated, and
+// from the replicated list on replicas.
+func listRevocations(c context.Context) ([]store.Revocation, error) {
+	if isReplica() {
+		return replicatedRevocations(c)
+	}
+
+	if cfg.Maintenance.Enabled {
+		maintenanceRevocations.Lock()
+		defer maintenanceRevocations.Unlock()
+
+		if maintenanceRevocations.list != nil {
+			return maintenanceRevocations.list, nil
+		}
+	}
+
+	revocations, err := env.Revocations(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := revocations.List(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Maintenance.Enabled {
+		if list == nil {
+			list = []store.Revocation{}
+		}
+
+		maintenanceRevocations.list = list
+	}
+
+	return list, nil
+}
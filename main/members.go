@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/dchest/uniuri"
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// membersTokenLength is the length of the tokens of organization admins.
+const membersTokenLength = 32
+
+// memberRequest is a request from the admin of an organization about the
+// members of its license. The members are given the license to activate
+// with, so it is authenticated by the license's members token (see
+// IssueMembersToken) and not the license alone.
+type memberRequest struct {
+	License      string `json:"license"`
+	MembersToken string `json:"members_token"`
+	Email        string `json:"email"`
+}
+
+// hashMembersToken returns the hash of a members token that is stored.
+func hashMembersToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// decodeMemberRequest decodes the request, verifies its license, which must
+// have seats, and checks the members token against the stored license. The
+// member's email is only required if needsEmail.
+func decodeMemberRequest(v *validator, r *http.Request, needsEmail bool) (*memberRequest, *license.License, *appError) {
+	var req memberRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, nil, &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	req.Email = normalizeEmail(req.Email)
+
+	if needsEmail && !strings.Contains(req.Email, "@") {
+		return nil, nil, &appError{errors.New("invalid email"), "The member's email address is required", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	lic, err := v.parse(strings.TrimSpace(req.License))
+
+	if _, invalid := err.(*invalidError); invalid {
+		return nil, nil, &appError{err, "The license is invalid", http.StatusBadRequest, codeLicenseInvalid}
+	}
+
+	if err != nil {
+		return nil, nil, &appError{err, "Could not load public key for verifying", http.StatusInternalServerError, codeKeyUnavailable}
+	}
+
+	if lic.Seats == 0 {
+		return nil, nil, &appError{fmt.Errorf("license %v has no seats", lic.ID), "The license's plan doesn't have seats for members", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	stored, err := env.Licenses(v.c, licenseNamespace(lic)).Get(v.c, lic.ID)
+
+	if err == store.ErrNotFound {
+		return nil, nil, &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return nil, nil, &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	hash := hashMembersToken(strings.TrimSpace(req.MembersToken))
+
+	if stored.MembersTokenHash == "" || subtle.ConstantTimeCompare([]byte(hash), []byte(stored.MembersTokenHash)) != 1 {
+		return nil, nil, &appError{fmt.Errorf("wrong members token for %v", lic.ID), "The license's members token is required", http.StatusForbidden, codeForbidden}
+	}
+
+	return &req, lic, nil
+}
+
+// IssueMembersToken handles POST requests to /api/licenses/{id}/members-token
+//
+// It issues the token that the admin of an organization manages the members
+// of its license with, for the storefront to give to whoever bought it. A
+// new token replaces the one issued before. Only its hash is stored, so it
+// can't be looked up again, only replaced.
+//
+// Example:
+//
+//	POST /api/licenses/daS7y8sioiecYy/members-token
+//	200 {"membersToken": "x7Qk2..."}
+func IssueMembersToken(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	licenses := env.Licenses(c, requestNamespace(c))
+	lic, err := licenses.Get(c, mux.Vars(r)["id"])
+
+	if err == store.ErrNotFound {
+		return &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if lic.Seats == 0 {
+		return &appError{fmt.Errorf("license %v has no seats", lic.ID), "The license's plan doesn't have seats for members", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	if lic.RevokedAt != nil {
+		return &appError{fmt.Errorf("license %v is revoked", lic.ID), "The license is revoked", http.StatusConflict, codeLicenseRevoked}
+	}
+
+	token := uniuri.NewLen(membersTokenLength)
+	lic.MembersTokenHash = hashMembersToken(token)
+
+	if err := licenses.Put(c, lic); err != nil {
+		return &appError{err, "Could not store the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if err := audit(c, store.AuditEntry{Action: "license.members-token", Target: lic.ID, Customer: lic.Email()}); err != nil {
+		env.Errorf(c, "Could not record issuing the members token of %v in the audit log: %v", lic.ID, err)
+	}
+
+	writeJSON(w, 200, struct {
+		MembersToken string `json:"membersToken"`
+	}{token})
+	return nil
+}
+
+// ListMembers handles POST requests to /api/licenses/members
+//
+// The request body holds the encoded license of an organization, such as one
+// on a business plan, and its members token, and the response is the members
+// holding its seats.
+//
+// Example:
+//
+//	POST /api/licenses/members {"license": "eyJhbGciOiJSUzI1NiIs...", "members_token": "x7Qk2..."}
+//	200 {"users": [{"licenseId": "daS7y8sioiecYy", "email": "jane@example.com", "addedAt": "..."}], "seats": {"used": 1, "allowed": 10}}
+func ListMembers(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	_, lic, e := decodeMemberRequest(newValidator(c), r, false)
+
+	if e != nil {
+		return e
+	}
+
+	return writeUsers(c, w, licenseNamespace(lic), lic)
+}
+
+// InviteMember handles POST requests to /api/licenses/members/invite
+//
+// The admin of an organization gives a member one of the seats of its
+// license, and the member is emailed the license key to activate with (see
+// ActivateLicense), which doesn't let them manage the members. Inviting a member again sends the email again. The
+// license must be valid, and one with all its seats taken answers 409.
+//
+// Example:
+//
+//	POST /api/licenses/members/invite {"license": "eyJhbGciOiJSUzI1NiIs...", "members_token": "x7Qk2...", "email": "jane@example.com"}
+//	200 {"users": [...], "seats": {"used": 2, "allowed": 10}}
+//
+//	409 All 10 seats of the license are taken
+func InviteMember(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	v := newValidator(c)
+	req, lic, e := decodeMemberRequest(v, r, true)
+
+	if e != nil {
+		return e
+	}
+
+	vd, err := v.check(lic)
+
+	if err != nil {
+		return &appError{err, "An error occurred validating the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if !vd.Valid {
+		writeJSON(w, http.StatusForbidden, vd)
+		return nil
+	}
+
+	u := store.User{LicenseID: lic.ID, Email: req.Email, AddedAt: time.Now()}
+	err = env.Users(c, licenseNamespace(lic)).Add(c, u, lic.Seats)
+
+	if err == store.ErrSeatLimit {
+		return &appError{err, fmt.Sprintf("All %v seats of the license are taken", lic.Seats), http.StatusConflict, codeSeatLimit}
+	}
+
+	if err != nil {
+		return &appError{err, "An error occurred inviting the member", http.StatusInternalServerError, codeInternal}
+	}
+
+	err = audit(c, store.AuditEntry{Action: "license.invite-member", Target: lic.ID, Customer: lic.Email(), Details: map[string]string{"user": req.Email}})
+
+	if err != nil {
+		env.Errorf(c, "Could not record inviting %v to %v in the audit log: %v", req.Email, lic.ID, err)
+	}
+
+	if err := mailAbout(c, emailSeatInvite, req.Email, lic, emailData{License: strings.TrimSpace(req.License)}); err != nil {
+		env.Errorf(c, "Could not email the invite to %v for %v: %v", req.Email, lic.ID, err)
+	}
+
+	return writeUsers(c, w, licenseNamespace(lic), lic)
+}
+
+// RemoveMember handles POST requests to /api/licenses/members/remove
+//
+// The admin of an organization takes a member's seat of its license, so that
+// it can be given to someone else. Installs the member already activated keep
+// their activations until they are deactivated.
+//
+// Example:
+//
+//	POST /api/licenses/members/remove {"license": "eyJhbGciOiJSUzI1NiIs...", "members_token": "x7Qk2...", "email": "jane@example.com"}
+//	200 {"users": [...], "seats": {"used": 1, "allowed": 10}}
+func RemoveMember(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	req, lic, e := decodeMemberRequest(newValidator(c), r, true)
+
+	if e != nil {
+		return e
+	}
+
+	err := env.Users(c, licenseNamespace(lic)).Remove(c, lic.ID, req.Email)
+
+	if err == store.ErrNotFound {
+		return &appError{err, "The member doesn't have a seat of the license", http.StatusNotFound, codeSeatNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "An error occurred removing the member", http.StatusInternalServerError, codeInternal}
+	}
+
+	err = audit(c, store.AuditEntry{Action: "license.remove-member", Target: lic.ID, Customer: lic.Email(), Details: map[string]string{"user": req.Email}})
+
+	if err != nil {
+		env.Errorf(c, "Could not record removing %v from %v in the audit log: %v", req.Email, lic.ID, err)
+	}
+
+	return writeUsers(c, w, licenseNamespace(lic), lic)
+}
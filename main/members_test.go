@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/volcanicpixels/licensing/licensingtest"
+)
+
+// issueMembersToken issues the members token of a license.
+func issueMembersToken(t *testing.T, s *licensingtest.Server, id string) string {
+	resp, err := s.Post("/api/licenses/"+id+"/members-token", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result struct {
+		MembersToken string `json:"membersToken"`
+	}
+
+	if err := resp.Decode(&result); err != nil || result.MembersToken == "" {
+		t.Fatalf("issuing the members token of %v: %v %s: %v", id, resp.StatusCode, resp.Body, err)
+	}
+
+	return result.MembersToken
+}
+
+// memberCall makes a members request, returning the status and the members
+// on success.
+func memberCall(t *testing.T, s *licensingtest.Server, path, licStr, token, email string) (int, *seatsResult) {
+	resp, err := s.Post("/api/licenses/members"+path, memberRequest{License: licStr, MembersToken: token, Email: email})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 200 {
+		return resp.StatusCode, nil
+	}
+
+	var result seatsResult
+
+	if err := resp.Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, &result
+}
+
+func TestMembers(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	cfg.Mail.Sender = "licenses@example.com"
+
+	resp, err := s.Post("/api/licenses", map[string]interface{}{"product": "domain_changer", "seats": 2})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var licStr string
+
+	if err := resp.Decode(&licStr); err != nil {
+		t.Fatal(err)
+	}
+
+	id := validateString(t, s, licStr).ID
+
+	// the license alone doesn't let anyone manage the members
+	if status, _ := memberCall(t, s, "", licStr, "", ""); status != 403 {
+		t.Errorf("got %v before a members token was issued, want a 403", status)
+	}
+
+	old := issueMembersToken(t, s, id)
+	token := issueMembersToken(t, s, id)
+
+	tests := []struct {
+		name, path, token, email string
+		status, used             int
+	}{
+		{"old token", "", old, "", 403, 0},
+		{"wrong token", "", "x" + token, "", 403, 0},
+		{"list", "", token, "", 200, 0},
+		{"invite", "/invite", token, "jane@example.com", 200, 1},
+		{"invite again", "/invite", token, "Jane@Example.com", 200, 1},
+		{"invite another", "/invite", token, "john@example.com", 200, 2},
+		{"seats taken", "/invite", token, "joe@example.com", 409, 0},
+		{"no email", "/invite", token, "", 400, 0},
+		{"remove", "/remove", token, "john@example.com", 200, 1},
+		{"remove again", "/remove", token, "john@example.com", 404, 0},
+		{"list again", "", token, "", 200, 1},
+	}
+
+	for _, test := range tests {
+		status, result := memberCall(t, s, test.path, licStr, test.token, test.email)
+
+		if status != test.status {
+			t.Errorf("%v: got %v, want %v", test.name, status, test.status)
+			continue
+		}
+
+		if result != nil && (len(result.Users) != test.used || result.Seats.Used != test.used || result.Seats.Allowed != 2) {
+			t.Errorf("%v: got %v members and seats %+v, want %v of 2 used", test.name, len(result.Users), result.Seats, test.used)
+		}
+	}
+
+	// each invite emails the member the license
+	invites := 0
+
+	for _, m := range p.Mail.Messages() {
+		if m.To == "jane@example.com" || m.To == "john@example.com" {
+			invites++
+		}
+	}
+
+	if invites != 3 {
+		t.Errorf("%v invites emailed, want 3", invites)
+	}
+
+	if status, _ := memberCall(t, s, "", "not a license", token, ""); status != 400 {
+		t.Errorf("got %v for an invalid license, want a 400", status)
+	}
+}
+
+func TestMembersNeedSeats(t *testing.T) {
+	s, _ := newTestServer(t)
+	defer s.Close()
+
+	resp, err := s.Post("/api/licenses/"+createLicense(t, s)+"/members-token", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 400 {
+		t.Errorf("got %v %s issuing a members token for a license without seats, want a 400", resp.StatusCode, resp.Body)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// Limits on the bookkeeping of a license.
+const (
+	maxCustomerIDLength    = 64
+	maxMetadataKeys        = 50
+	maxMetadataKeyLength   = 40
+	maxMetadataValueLength = 500
+	maxNotesLength         = 4000
+	maxTags                = 20
+	maxTagLength           = 32
+
+	// maxPatchRequestSize bounds the body of a PATCH request.
+	maxPatchRequestSize = 64 << 10
+)
+
+// tagPattern is what tags look like once lower cased.
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_:-]*$`)
+
+// patchableFields are the fields of a license that PATCH changes, none of them
+// are signed into it.
+var patchableFields = []string{"customer_id", "invoice", "metadata", "notes", "receipt_url", "tags"}
+
+// licensePatch is the body of a PATCH request, fields that are nil are left
+// as they are.
+type licensePatch struct {
+	CustomerID *string   `json:"customer_id"`
+	Invoice    *string   `json:"invoice"`
+	ReceiptURL *string   `json:"receipt_url"`
+	Notes      *string   `json:"notes"`
+	Tags       *[]string `json:"tags"`
+
+	// Metadata is merged into the license's, a null value removes a key.
+	Metadata map[string]*string `json:"metadata"`
+}
+
+// decodePatch decodes the body of a PATCH request, the error is a
+// *fieldError for fields that can't be changed or have the wrong type.
+func decodePatch(body []byte) (*licensePatch, error) {
+	var fields map[string]json.RawMessage
+
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(fields))
+
+	for name := range fields {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if i := sort.SearchStrings(patchableFields, name); i == len(patchableFields) || patchableFields[i] != name {
+			return nil, &fieldError{Field: name, Allowed: patchableFields, err: errors.New("can't be changed, only the bookkeeping that isn't signed into the license can")}
+		}
+	}
+
+	var p licensePatch
+
+	if err := json.Unmarshal(body, &p); err != nil {
+		if te, ok := err.(*json.UnmarshalTypeError); ok && te.Field != "" {
+			return nil, &fieldError{Field: te.Field, err: fmt.Errorf("can't be a JSON %v", te.Value)}
+		}
+
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+// apply changes a license, returning what changed for the audit log. The
+// error is a *fieldError naming the first invalid field, the license is then
+// partly changed.
+func (p *licensePatch) apply(lic *license.License) (map[string]string, error) {
+	changes := make(map[string]string)
+	old := *lic
+
+	if p.CustomerID != nil {
+		id := strings.TrimSpace(*p.CustomerID)
+
+		if len(id) > maxCustomerIDLength || strings.ContainsAny(id, " \t\r\n") {
+			return nil, &fieldError{Field: "customer_id", err: fmt.Errorf("must be at most %v characters without spaces", maxCustomerIDLength)}
+		}
+
+		lic.CustomerID = id
+	}
+
+	if err := setInvoice(lic, p.Invoice, p.ReceiptURL); err != nil {
+		return nil, err
+	}
+
+	if p.Notes != nil {
+		if len(*p.Notes) > maxNotesLength {
+			return nil, &fieldError{Field: "notes", err: fmt.Errorf("longer than %v characters", maxNotesLength)}
+		}
+
+		lic.Notes = strings.TrimSpace(*p.Notes)
+	}
+
+	if p.Tags != nil {
+		tags, err := normalizeTags(*p.Tags)
+
+		if err != nil {
+			return nil, err
+		}
+
+		lic.Tags = tags
+	}
+
+	for field, values := range map[string][2]string{
+		"customerId": {old.CustomerID, lic.CustomerID},
+		"invoice":    {old.Invoice, lic.Invoice},
+		"receiptUrl": {old.ReceiptURL, lic.ReceiptURL},
+		"notes":      {old.Notes, lic.Notes},
+		"tags":       {strings.Join(old.Tags, ","), strings.Join(lic.Tags, ",")},
+	} {
+		if values[0] != values[1] {
+			changes[field] = values[1]
+		}
+	}
+
+	if p.Metadata != nil {
+		if err := applyMetadata(lic, p.Metadata, changes); err != nil {
+			return nil, err
+		}
+	}
+
+	return changes, nil
+}
+
+// normalizeTags lower cases and sorts tags, dropping duplicates.
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var normalized []string
+
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+
+		if len(t) > maxTagLength || !tagPattern.MatchString(t) {
+			return nil, &fieldError{Field: "tags", err: fmt.Errorf("%q isn't a tag, tags are up to %v letters, digits, _, - and :", t, maxTagLength)}
+		}
+
+		if !seen[t] {
+			seen[t] = true
+			normalized = append(normalized, t)
+		}
+	}
+
+	if len(normalized) > maxTags {
+		return nil, &fieldError{Field: "tags", err: fmt.Errorf("more than %v tags", maxTags)}
+	}
+
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// applyMetadata merges metadata into a license's, noting the keys that
+// changed as metadata.<key>.
+func applyMetadata(lic *license.License, metadata map[string]*string, changes map[string]string) error {
+	merged := make(map[string]string, len(lic.Metadata)+len(metadata))
+
+	for k, v := range lic.Metadata {
+		merged[k] = v
+	}
+
+	for k, v := range metadata {
+		field := "metadata." + k
+
+		if k == "" || len(k) > maxMetadataKeyLength {
+			return &fieldError{Field: field, err: fmt.Errorf("keys must be 1 to %v characters", maxMetadataKeyLength)}
+		}
+
+		old, had := merged[k]
+
+		if v == nil {
+			delete(merged, k)
+
+			if had {
+				changes[field] = ""
+			}
+
+			continue
+		}
+
+		if len(*v) > maxMetadataValueLength {
+			return &fieldError{Field: field, err: fmt.Errorf("longer than %v characters", maxMetadataValueLength)}
+		}
+
+		merged[k] = *v
+
+		if !had || old != *v {
+			changes[field] = *v
+		}
+	}
+
+	if len(merged) > maxMetadataKeys {
+		return &fieldError{Field: "metadata", err: fmt.Errorf("more than %v keys", maxMetadataKeys)}
+	}
+
+	lic.Metadata = merged
+
+	if len(merged) == 0 {
+		lic.Metadata = nil
+	}
+
+	return nil
+}
+
+// UpdateLicense handles PATCH requests to /api/licenses/{id}
+//
+// It changes the stored bookkeeping of a license, which isn't signed into
+// it, so its string stays as it is instead of the license being revoked and
+// reissued. The body has the fields to change: customer_id (the storefront's
+// or CRM's customer), invoice and receipt_url, notes, tags (which replace the
+// license's, lower cased) and metadata (merged into the license's, a null
+// value removes a key). Fields left out are kept and empty strings clear
+// them. Any other field, such as the signed expiry or attributes, fails the
+// request with the field named, as does an invalid value. Revoked licenses
+// can be changed too. Only admins and admin API keys can change licenses. The
+// changes are recorded in the audit log and the response is the updated
+// license.
+//
+// Examples:
+//
+//	PATCH /api/licenses/daS7y8sioiecYy {"invoice": "INV-2026-0042", "tags": ["vip"], "metadata": {"crm": "acct-17"}}
+//	200 {"id": "daS7y8sioiecYy", "product": "domain_changer", ..., "invoice": "INV-2026-0042", "metadata": {"crm": "acct-17"}, "tags": ["vip"]}
+//
+//	PATCH /api/licenses/daS7y8sioiecYy {"expires_at": "2030-01-01T00:00:00Z"}
+//	400 {"status": 400, "error": "Invalid expires_at: can't be changed, ...", "code": "invalid_request", "field": "expires_at", "allowed": ["customer_id", ...]}
+func UpdateLicense(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPatchRequestSize))
+
+	if err != nil {
+		return &appError{err, "Could not read the request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	patch, err := decodePatch(body)
+
+	if fe, ok := err.(*fieldError); ok {
+		return &appError{fe, "Invalid " + fe.Error(), http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	licenses := env.Licenses(c, requestNamespace(c))
+	lic, err := licenses.Get(c, mux.Vars(r)["id"])
+
+	if err == store.ErrNotFound {
+		return &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	changes, err := patch.apply(lic)
+
+	if err != nil {
+		return &appError{err, "Invalid " + err.Error(), http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	if len(changes) == 0 {
+		writeJSON(w, 200, lic)
+		return nil
+	}
+
+	if err := licenses.Put(c, lic); err != nil {
+		return &appError{err, "Could not store the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	entry := store.AuditEntry{Action: "license.update", Target: lic.ID, Customer: lic.Email(), Details: changes}
+
+	if err := audit(c, entry); err != nil {
+		env.Errorf(c, "Could not record the update of %v in the audit log: %v", lic.ID, err)
+	}
+
+	writeJSON(w, 200, lic)
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/config"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// revocationMigration reports what a migration of the revocation file did,
+// Missing lists any revoked IDs that the datastore doesn't have afterwards.
+type revocationMigration struct {
+	Entries    int      `json:"entries"`    // lines with an ID in the file
+	Duplicates int      `json:"duplicates"` // lines repeating an earlier ID
+	Imported   int      `json:"imported"`
+	Existing   int      `json:"existing"` // already in the datastore
+	Stored     int      `json:"stored"`   // in the datastore afterwards
+	Missing    []string `json:"missing"`
+}
+
+// MigrateRevocations handles POST requests to /api/revocations/migrate
+//
+// It imports the revocation file (revocations.source) into the datastore
+// revocation store. Repeated IDs are imported once, with the first comment
+// given for them, and IDs already in the datastore are left alone so the
+// migration can be run again, e.g. for revocations written to the file by
+// other systems since. The datastore is then listed again to verify that
+// every ID in the file is there, if any aren't the response is a 500 listing
+// them.
+//
+// Run it while revocations.store is still file and then switch the store to
+// datastore.
+//
+// Example:
+//
+//	POST /api/revocations/migrate
+//	200 {"entries": 212, "duplicates": 3, "imported": 209, "existing": 0, "stored": 209, "missing": []}
+func MigrateRevocations(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	source, err := env.RevocationsIn(c, config.RevocationsFile)
+
+	if err != nil {
+		return &appError{err, "Could not open the revocation file", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	target, err := env.RevocationsIn(c, config.RevocationsDatastore)
+
+	if err != nil {
+		return &appError{err, "Could not open the datastore's revocations", http.StatusInternalServerError, codeInternal}
+	}
+
+	entries, err := source.List(c)
+
+	if err != nil {
+		return &appError{err, "An error occurred reading the revocation file", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	report := &revocationMigration{Entries: len(entries), Missing: []string{}}
+	var revocations []store.Revocation
+	seen := make(map[string]int)
+
+	for _, rev := range entries {
+		i, dup := seen[rev.ID]
+
+		if !dup {
+			seen[rev.ID] = len(revocations)
+			revocations = append(revocations, rev)
+			continue
+		}
+
+		report.Duplicates++
+
+		if revocations[i].Comment == "" {
+			revocations[i].Comment = rev.Comment
+		}
+	}
+
+	stored, err := target.List(c)
+
+	if err != nil {
+		return &appError{err, "An error occurred reading the datastore's revocations", http.StatusInternalServerError, codeInternal}
+	}
+
+	existing := make(map[string]bool)
+
+	for _, rev := range stored {
+		existing[rev.ID] = true
+	}
+
+	for _, rev := range revocations {
+		if existing[rev.ID] {
+			report.Existing++
+			continue
+		}
+
+		if err := target.Revoke(c, rev); err != nil {
+			return &appError{err, fmt.Sprintf("An error occurred importing %v, %v revocations had been imported", rev.ID, report.Imported), http.StatusInternalServerError, codeInternal}
+		}
+
+		report.Imported++
+	}
+
+	if stored, err = target.List(c); err != nil {
+		return &appError{err, "The revocations were imported but could not be listed to verify them", http.StatusInternalServerError, codeInternal}
+	}
+
+	existing = make(map[string]bool)
+
+	for _, rev := range stored {
+		existing[rev.ID] = true
+	}
+
+	report.Stored = len(stored)
+
+	for _, rev := range revocations {
+		if !existing[rev.ID] {
+			report.Missing = append(report.Missing, rev.ID)
+		}
+	}
+
+	err = audit(c, store.AuditEntry{
+		Action: "revocations.migrate",
+		Target: cfg.Revocations.Source,
+		Details: map[string]string{
+			"imported": strconv.Itoa(report.Imported),
+			"missing":  strconv.Itoa(len(report.Missing)),
+		},
+	})
+
+	if err != nil {
+		env.Errorf(c, "Could not audit the migration of the revocation file: %v", err)
+	}
+
+	if len(report.Missing) > 0 {
+		env.Errorf(c, "%v revocations are missing from the datastore after migrating: %v", len(report.Missing), report.Missing)
+		writeJSON(w, http.StatusInternalServerError, report)
+		return nil
+	}
+
+	writeJSON(w, 200, report)
+	return nil
+}
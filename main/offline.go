@@ -0,0 +1,249 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/danielchatfield/go-jwt"
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/storage"
+)
+
+// offlineKey is a public key in an offline bundle and what it is for:
+// license, cross-sign, root or revocations.
+type offlineKey struct {
+	ID    string   `json:"id"`
+	File  string   `json:"file"`
+	Roles []string `json:"roles"`
+}
+
+// offlineManifest describes the contents of an offline bundle.
+type offlineManifest struct {
+	Product     string       `json:"product"`
+	GeneratedAt time.Time    `json:"generatedAt"`
+	Keys        []offlineKey `json:"keys"`
+	Revocations string       `json:"revocations"`
+	Revoked     int          `json:"revoked"`
+}
+
+// offlineBundleFile is where the offline bundle of a product is kept.
+func offlineBundleFile(product string) string {
+	return "offline/" + product + ".tar.gz"
+}
+
+// offlineKeys returns the keys that software for a product needs to verify
+// its licenses and the revocation snapshot.
+func offlineKeys(product string) []offlineKey {
+	roles := []struct{ kid, role string }{
+		{productKeyID(product), "license"},
+		{crossSignKeyID(product), "cross-sign"},
+		{cfg.Keys.RootID, "root"},
+		{cfg.Keys.ID, "revocations"},
+	}
+
+	var keys []offlineKey
+	index := make(map[string]int)
+
+	for _, r := range roles {
+		if r.kid == "" {
+			continue
+		}
+
+		if i, ok := index[r.kid]; ok {
+			keys[i].Roles = append(keys[i].Roles, r.role)
+			continue
+		}
+
+		index[r.kid] = len(keys)
+		keys = append(keys, offlineKey{r.kid, "keys/" + r.kid + ".pem", []string{r.role}})
+	}
+
+	return keys
+}
+
+// buildOfflineBundle packages the public keys of a product, a signed snapshot
+// of the revoked IDs and a manifest into a gzipped tar archive. Unlike the
+// published revocation list the snapshot doesn't expire, it has the time it
+// was taken in its iat claim so that software can tell how old it is.
+func buildOfflineBundle(c context.Context, product string, revoked []string, now time.Time) ([]byte, error) {
+	manifest := offlineManifest{
+		Product:     product,
+		GeneratedAt: now,
+		Keys:        offlineKeys(product),
+		Revocations: "revocations.json",
+		Revoked:     len(revoked),
+	}
+
+	if revoked == nil {
+		revoked = []string{}
+	}
+
+	files := make(map[string][]byte)
+
+	for _, k := range manifest.Keys {
+		pem, err := getKey(c, k.ID, "public.pem")
+
+		if err != nil {
+			return nil, fmt.Errorf("loading public key %v: %v", k.ID, err)
+		}
+
+		files[k.File] = pem
+	}
+
+	key, err := getPrivateKey(c, cfg.Keys.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	t := jwt.NewToken(jwt.RSA)
+	t.SetClaim("_revoked", revoked)
+	t.SetClaim("iat", now.Unix())
+
+	token, err := t.Encode(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if files[manifest.Revocations], err = json.Marshal(struct {
+		Token string `json:"token"`
+	}{token}); err != nil {
+		return nil, err
+	}
+
+	if files["manifest.json"], err = json.MarshalIndent(manifest, "", "  "); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+
+	// the manifest goes first so that it can be read without the rest
+	names := []string{"manifest.json", manifest.Revocations}
+
+	for _, k := range manifest.Keys {
+		names = append(names, k.File)
+	}
+
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name])), ModTime: now}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+
+		if _, err := tw.Write(files[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// publishOfflineBundles regenerates the offline bundle of every configured
+// product, it runs with the revocation file update.
+func publishOfflineBundles(c context.Context, sc storage.Storage, revoked []string) error {
+	now := time.Now()
+
+	for product := range cfg.Products {
+		bundle, err := buildOfflineBundle(c, product, revoked, now)
+
+		if err != nil {
+			return err
+		}
+
+		if err := sc.WriteFile(offlineBundleFile(product), bundle); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// OfflineBundle handles GET requests to /api/products/{product}/offline-bundle
+//
+// It returns the product's offline bundle for installations without internet
+// access, a .tar.gz of manifest.json, revocations.json and the public keys in
+// keys/. Bundles are regenerated along with the revocation list, a product's
+// first is built when it is requested. With signed_urls the response
+// redirects to a signed URL of the stored bundle.
+func OfflineBundle(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	product := mux.Vars(r)["product"]
+
+	if _, ok := cfg.Products[product]; !ok {
+		return &appError{fmt.Errorf("unknown product %q", product), "Product not found", http.StatusNotFound, codeProductUnknown}
+	}
+
+	sc, err := newStorage(c)
+
+	if err != nil {
+		return &appError{err, "Could not open storage", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	bundle, err := sc.ReadFile(offlineBundleFile(product))
+
+	if err == storage.ErrNotExist {
+		bundle, err = newOfflineBundle(c, sc, product)
+	}
+
+	if err != nil {
+		return &appError{err, "An error occurred loading the offline bundle", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	name := fmt.Sprintf("%v-offline.tar.gz", product)
+	redirected, err := redirectToFile(w, r, sc, offlineBundleFile(product), name)
+
+	if err != nil {
+		return &appError{err, "Could not sign the download URL", http.StatusInternalServerError, codeInternal}
+	}
+
+	if redirected {
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v"`, name))
+	w.Write(bundle)
+
+	return nil
+}
+
+// newOfflineBundle builds and stores the first offline bundle of a product.
+func newOfflineBundle(c context.Context, sc storage.Storage, product string) ([]byte, error) {
+	list, err := listRevocations(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := make([]string, 0, len(list))
+
+	for _, rev := range list {
+		revoked = append(revoked, rev.ID)
+	}
+
+	bundle, err := buildOfflineBundle(c, product, revoked, time.Now())
+
+	if err != nil {
+		return nil, err
+	}
+
+	return bundle, sc.WriteFile(offlineBundleFile(product), bundle)
+}
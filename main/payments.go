@@ -0,0 +1,318 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/config"
+	"github.com/volcanicpixels/licensing/payments"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// maxWebhookSize is the largest payment webhook body that is read.
+const maxWebhookSize = 1 << 20
+
+// handlePaymentEvent revokes the licenses paid for by the charge of a refund
+// or chargeback and emails their customers, returning the IDs of the newly
+// revoked licenses. Licenses that are already revoked are skipped so that
+// retried webhooks do nothing. Test mode events are ignored since sandbox
+// licenses aren't linked to real payments.
+func handlePaymentEvent(c context.Context, provider string, e *payments.Event) ([]string, error) {
+	revoked := []string{}
+
+	if (e.Kind != payments.Refund && e.Kind != payments.Chargeback) || e.ChargeID == "" || !e.Live {
+		return revoked, nil
+	}
+
+	q := store.Query{ChargeID: e.ChargeID, Limit: maxListLimit}
+	licenses, _, err := env.Licenses(c, "").List(c, q)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, lic := range licenses {
+		if lic.RevokedAt != nil {
+			continue
+		}
+
+		rev := store.Revocation{ID: lic.ID, Comment: fmt.Sprintf("%v of %v (%v %v)", e.Kind, e.ChargeID, provider, e.ID)}
+		details := map[string]string{
+			"reason":   e.Kind,
+			"provider": provider,
+			"charge":   e.ChargeID,
+			"event":    e.ID,
+		}
+
+		if err := revokeLicense(c, licenseNamespace(lic), rev, details); err != nil {
+			return nil, err
+		}
+
+		revoked = append(revoked, lic.ID)
+		notifyWebhooks(c, "license.revoked", map[string]string{"id": lic.ID, "reason": e.Kind})
+		notifySlack(c, "license.auto-revoked", fmt.Sprintf("%v license %v was revoked automatically: %v", lic.Product, lic.ID, rev.Comment))
+
+		if err := mailCustomer(c, emailRevoked, lic, emailData{Reason: e.Kind}); err != nil {
+			env.Errorf(c, "Could not email the customer of revoked license %v: %v", lic.ID, err)
+		}
+	}
+
+	return revoked, nil
+}
+
+// issuePurchase issues a license for each item of a purchase, with the
+// payment as its chargeId so that refunds of it revoke them. Licenses the
+// payment already has count towards its items, so retried webhooks only
+// issue what is missing. Items without a configured license are logged and
+// skipped, and test mode purchases are ignored like other test events.
+func issuePurchase(c context.Context, provider string, e *payments.Event, items map[string]config.PaymentItem) ([]string, error) {
+	issued := []string{}
+
+	if e.Kind != payments.Purchase || e.ChargeID == "" || !e.Live {
+		return issued, nil
+	}
+
+	existing, _, err := env.Licenses(c, "").List(c, store.Query{ChargeID: e.ChargeID, Limit: maxListLimit})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var licensed []config.PaymentItem
+
+	for _, id := range e.Items {
+		if item, ok := items[id]; ok {
+			licensed = append(licensed, item)
+		} else {
+			env.Errorf(c, "No license is configured for %v item %v of %v", provider, id, e.ChargeID)
+		}
+	}
+
+	for i, item := range licensed {
+		if i < len(existing) {
+			continue
+		}
+
+		req := &createRequest{
+			Product:  item.Product,
+			Template: item.Template,
+			Plan:     item.Plan,
+			Attrs:    map[string]interface{}{"chargeId": e.ChargeID},
+		}
+
+		if e.Email != "" {
+			req.Attrs["email"] = e.Email
+		}
+
+		if e.Name != "" {
+			req.Attrs["name"] = e.Name
+		}
+
+		lic, _, _, ae := issueLicense(c, req, "")
+
+		if ae != nil {
+			return nil, fmt.Errorf("%v: %v", ae.Message, ae.Error)
+		}
+
+		issued = append(issued, lic.ID)
+	}
+
+	return issued, nil
+}
+
+// StripeWebhook handles POST requests to /api/payments/stripe/webhook
+//
+// The webhook must be signed with the endpoint's signing secret. Licenses
+// whose chargeId is the charge of a full refund (charge.refunded) or a
+// dispute (charge.dispute.created) are revoked, with the reason and the
+// event in the revocation comment and the audit log.
+//
+// Example:
+//
+//	POST /api/payments/stripe/webhook {"id": "evt_1", "type": "charge.refunded", ...}
+//	200 {"revoked": ["daS7y8sioiecYy"]}
+func StripeWebhook(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	if cfg.Payments.StripeWebhookSecret == "" {
+		return &appError{errors.New("stripe webhook disabled"), "Not found", http.StatusNotFound, codeNotFound}
+	}
+
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+
+	if err != nil {
+		return &appError{err, "Could not read the request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	secret, err := getSecret(c, cfg.Payments.StripeWebhookSecret)
+
+	if err != nil {
+		return &appError{err, "Could not load the webhook secret", http.StatusInternalServerError, codeInternal}
+	}
+
+	if err := payments.VerifyStripe(payload, r.Header.Get("Stripe-Signature"), secret, time.Now()); err != nil {
+		return &appError{err, "Invalid signature", http.StatusBadRequest, codeWebhookInvalid}
+	}
+
+	e, err := payments.ParseStripe(payload)
+
+	if err != nil {
+		return &appError{err, "Could not decode the event", http.StatusBadRequest, codeWebhookInvalid}
+	}
+
+	revoked, err := handlePaymentEvent(c, "stripe", e)
+
+	if err != nil {
+		// Stripe retries failed webhooks
+		return &appError{err, "An error occurred revoking the licenses", http.StatusInternalServerError, codeInternal}
+	}
+
+	writeJSON(w, 200, struct {
+		Revoked []string `json:"revoked"`
+	}{revoked})
+
+	return nil
+}
+
+// PayPalWebhook handles POST requests to /api/payments/paypal/webhook, and
+// to /api/webhooks/paypal
+//
+// It is the Instant Payment Notification listener of the PayPal account in
+// payments.paypal.receiver. Each message is posted back to PayPal to verify
+// it. Completed payments issue a license for each unit of each item_number
+// in payments.paypal.items that was paid the item's price in its currency,
+// with the transaction ID as the chargeId.
+// Refunded and reversed payments revoke the licenses of the original
+// transaction as the Stripe webhook does.
+//
+// Example:
+//
+//	POST /api/payments/paypal/webhook txn_id=61E67681CH3238416&payment_status=Completed&item_number=dc-pro&...
+//	200 {"issued": ["daS7y8sioiecYy"], "revoked": []}
+func PayPalWebhook(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	if cfg.Payments.PayPal.Receiver == "" {
+		return &appError{errors.New("paypal webhook disabled"), "Not found", http.StatusNotFound, codeNotFound}
+	}
+
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+
+	if err != nil {
+		return &appError{err, "Could not read the request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	err = payments.VerifyPayPal(env.HTTPClient(c), payload)
+
+	if err == payments.ErrInvalidSignature {
+		return &appError{err, "Invalid notification", http.StatusBadRequest, codeWebhookInvalid}
+	}
+
+	if err != nil {
+		// PayPal resends notifications that fail
+		return &appError{err, "Could not verify the notification with PayPal", http.StatusInternalServerError, codeInternal}
+	}
+
+	prices := make(map[string]payments.Price, len(cfg.Payments.PayPal.Items))
+
+	for id, item := range cfg.Payments.PayPal.Items {
+		prices[id] = payments.Price{Amount: item.Price, Currency: item.Currency}
+	}
+
+	e, err := payments.ParsePayPal(payload, cfg.Payments.PayPal.Receiver, prices)
+
+	if err != nil {
+		return &appError{err, "Could not decode the notification", http.StatusBadRequest, codeWebhookInvalid}
+	}
+
+	for _, id := range e.Underpaid {
+		env.Warningf(c, "Not issuing a license for PayPal item %v of %v, it wasn't paid its price", id, e.ChargeID)
+	}
+
+	issued, err := issuePurchase(c, "paypal", e, cfg.Payments.PayPal.Items)
+
+	if err != nil {
+		return &appError{err, "An error occurred issuing the licenses", http.StatusInternalServerError, codeInternal}
+	}
+
+	revoked, err := handlePaymentEvent(c, "paypal", e)
+
+	if err != nil {
+		return &appError{err, "An error occurred revoking the licenses", http.StatusInternalServerError, codeInternal}
+	}
+
+	writeJSON(w, 200, struct {
+		Issued  []string `json:"issued"`
+		Revoked []string `json:"revoked"`
+	}{issued, revoked})
+
+	return nil
+}
+
+// FastSpringWebhook handles POST requests to /api/payments/fastspring/webhook
+//
+// The webhook must be signed with its HMAC secret. Each order.completed
+// event issues a license for each unit of each item whose SKU is in
+// payments.fastspring.skus, with the order ID as the chargeId.
+// return.created events revoke the licenses of the returned order as
+// refunds, or chargebacks for CHARGEBACK returns.
+//
+// Example:
+//
+//	POST /api/payments/fastspring/webhook {"events": [{"id": "1b2f...", "type": "order.completed", "live": true, "data": {...}}]}
+//	200 {"issued": ["daS7y8sioiecYy"], "revoked": []}
+func FastSpringWebhook(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	if cfg.Payments.FastSpring.WebhookSecret == "" {
+		return &appError{errors.New("fastspring webhook disabled"), "Not found", http.StatusNotFound, codeNotFound}
+	}
+
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+
+	if err != nil {
+		return &appError{err, "Could not read the request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	secret, err := getSecret(c, cfg.Payments.FastSpring.WebhookSecret)
+
+	if err != nil {
+		return &appError{err, "Could not load the webhook secret", http.StatusInternalServerError, codeInternal}
+	}
+
+	if err := payments.VerifyFastSpring(payload, r.Header.Get("X-FS-Signature"), secret); err != nil {
+		return &appError{err, "Invalid signature", http.StatusBadRequest, codeWebhookInvalid}
+	}
+
+	events, err := payments.ParseFastSpring(payload)
+
+	if err != nil {
+		return &appError{err, "Could not decode the events", http.StatusBadRequest, codeWebhookInvalid}
+	}
+
+	issued, revoked := []string{}, []string{}
+
+	// FastSpring retries the whole batch if any of it fails, which is safe
+	// since both issuing and revoking skip what was done before
+	for _, e := range events {
+		ids, err := issuePurchase(c, "fastspring", e, cfg.Payments.FastSpring.SKUs)
+
+		if err != nil {
+			return &appError{err, "An error occurred issuing the licenses", http.StatusInternalServerError, codeInternal}
+		}
+
+		issued = append(issued, ids...)
+
+		if ids, err = handlePaymentEvent(c, "fastspring", e); err != nil {
+			return &appError{err, "An error occurred revoking the licenses", http.StatusInternalServerError, codeInternal}
+		}
+
+		revoked = append(revoked, ids...)
+	}
+
+	writeJSON(w, 200, struct {
+		Issued  []string `json:"issued"`
+		Revoked []string `json:"revoked"`
+	}{issued, revoked})
+
+	return nil
+}
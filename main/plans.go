@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/config"
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// prorate scales the time left on a license by the ratio of the old and new
+// plans' prices, so upgrading shortens it and downgrading extends it.
+func prorate(lic *license.License, from, to *config.Plan, now time.Time) {
+	if lic.ExpiresAt == nil || from.Price <= 0 || to.Price <= 0 || !now.Before(*lic.ExpiresAt) {
+		return
+	}
+
+	left := float64(lic.ExpiresAt.Sub(now)) * from.Price / to.Price
+	expiresAt := now.Add(time.Duration(left))
+	lic.ExpiresAt = &expiresAt
+}
+
+// ChangePlan handles POST requests to /api/licenses/{id}/change-plan
+//
+// The license is re-issued, keeping its ID, with the entitlements and
+// activation limit of the new plan, and the string for the old plan is
+// superseded. If the product prorates plan changes, the time left on the
+// license is scaled by the ratio of the plans' prices. The response is the
+// new license.
+//
+// Example:
+//
+//	POST /api/licenses/daS7y8sioiecYy/change-plan {"plan": "agency"}
+//	200 "eyJhbGciOiJSUzI1NiIs..."
+func ChangePlan(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	var req struct {
+		Plan string `json:"plan"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	licenses := env.Licenses(c, requestNamespace(c))
+	lic, err := licenses.Get(c, mux.Vars(r)["id"])
+
+	if err == store.ErrNotFound {
+		return &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if lic.RevokedAt != nil {
+		return &appError{errors.New("license revoked"), "Revoked licenses can't change plan", http.StatusConflict, codeLicenseRevoked}
+	}
+
+	to, err := lookupPlan(lic.Product, req.Plan)
+
+	if err != nil {
+		return &appError{err, err.Error(), http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	now := time.Now()
+	old := lic.Plan
+
+	// licenses issued before the product had plans aren't prorated
+	if from, err := lookupPlan(lic.Product, old); err == nil && cfg.Products[lic.Product].ProratePlanChanges {
+		prorate(lic, from, to, now)
+	}
+
+	applyPlan(lic, req.Plan, to)
+	lic.IssuedAt = now
+
+	// the string for the old plan stops working
+	lic.Serial++
+
+	licStr, e := signLicense(c, lic)
+
+	if e != nil {
+		return e
+	}
+
+	if err = licenses.Put(c, lic); err != nil {
+		return &appError{err, "Could not store the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	entry := store.AuditEntry{
+		Action:   "license.change-plan",
+		Target:   lic.ID,
+		Customer: lic.Email(),
+		Details:  map[string]string{"from": old, "to": req.Plan},
+	}
+
+	if err = audit(c, entry); err != nil {
+		env.Errorf(c, "Could not record the plan change of %v in the audit log: %v", lic.ID, err)
+	}
+
+	writeJSON(w, 200, encodedLicense(licStr))
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// productPattern matches product names such as "domain_changer".
+var productPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// fieldError is the error of a request field with an invalid value, Allowed
+// lists the values it may have when the client is allowed to see them.
+type fieldError struct {
+	Field   string
+	Allowed []string
+	err     error
+}
+
+func (e *fieldError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Field, e.err)
+}
+
+// checkProduct returns an error unless product is a well-formed name of a
+// configured product. Admins and admin API keys are told which products there
+// are.
+func checkProduct(c context.Context, product string) *appError {
+	fe := &fieldError{Field: "product"}
+
+	if isAdmin(c) || isAdminKey(c) {
+		for name := range cfg.Products {
+			fe.Allowed = append(fe.Allowed, name)
+		}
+
+		sort.Strings(fe.Allowed)
+	}
+
+	switch _, ok := cfg.Products[product]; {
+	case product == "":
+		fe.err = errors.New("empty product")
+		return &appError{fe, "The product is required", http.StatusBadRequest, codeInvalidRequest}
+	case !productPattern.MatchString(product):
+		fe.err = fmt.Errorf("invalid product %q", product)
+		return &appError{fe, "Invalid product, use up to 64 lowercase letters, digits, underscores and dashes", http.StatusBadRequest, codeInvalidRequest}
+	case !ok:
+		fe.err = fmt.Errorf("unknown product %q", product)
+		return &appError{fe, "Unknown product " + product, http.StatusBadRequest, codeProductUnknown}
+	}
+
+	return nil
+}
+
+// capCounter names the counter of the licenses issued for a product with a
+// cap.
+func capCounter(product string) string {
+	return "cap:" + product
+}
+
+// takeCap counts a new license against its product's cap, returning a sold
+// out error once the cap is reached. Admins can ignore the cap, the license
+// is still counted. taken is true if the license was counted and must be
+// given back with returnCap if it isn't issued.
+func takeCap(c context.Context, lic *license.License, ignore bool) (taken bool, e *appError) {
+	limit := cfg.Products[lic.Product].Cap
+
+	if ignore && !isAdmin(c) {
+		return false, &appError{errors.New("ignore_cap without admin access"), "Only admins can ignore the product's cap", http.StatusForbidden, codeForbidden}
+	}
+
+	if limit == 0 || lic.Test {
+		return false, nil
+	}
+
+	if ignore {
+		limit = math.MaxInt32
+	}
+
+	switch err := env.Counters(c).IncrementCapped(c, capCounter(lic.Product), limit); err {
+	case nil:
+		return true, nil
+	case store.ErrCapReached:
+		return false, &appError{err, fmt.Sprintf("All %v licenses of %v have been issued", limit, lic.Product), http.StatusConflict, codeSoldOut}
+	default:
+		return false, &appError{err, "An error occurred checking the product's cap", http.StatusInternalServerError, codeInternal}
+	}
+}
+
+// returnCap gives back a license counted against its product's cap.
+func returnCap(c context.Context, product string) {
+	if err := env.Counters(c).Decrement(c, capCounter(product)); err != nil {
+		env.Errorf(c, "Could not give back a license of %v to its cap: %v", product, err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/config"
+)
+
+// setCap caps the licenses of domain_changer.
+func setCap(limit int) {
+	product := cfg.Products["domain_changer"]
+	product.Cap = limit
+	cfg.Products["domain_changer"] = product
+}
+
+func TestCapConcurrently(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	const limit, n = 3, 10
+	setCap(limit)
+
+	var wg sync.WaitGroup
+	statuses := make(chan int, n)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			resp, err := s.Post("/api/v2/licenses", map[string]string{"product": "domain_changer"})
+
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			statuses <- resp.StatusCode
+		}()
+	}
+
+	wg.Wait()
+	close(statuses)
+
+	counts := make(map[int]int)
+
+	for status := range statuses {
+		counts[status]++
+	}
+
+	if counts[200] != limit || counts[409] != n-limit {
+		t.Errorf("got statuses %v, want %v 200s and %v 409s", counts, limit, n-limit)
+	}
+
+	if count, err := p.CounterStore.Count(context.Background(), capCounter("domain_changer")); err != nil || count != limit {
+		t.Errorf("%v counted against the cap, want %v: %v", count, limit, err)
+	}
+}
+
+func TestCapReturnedOnFailure(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	setCap(5)
+	useAPIKey(s, p, config.APIKey{ID: "quota", DailyQuota: 1})
+	createLicense(t, s)
+
+	// the quota is taken after the cap, so the cap is given back
+	resp, err := s.Post("/api/v2/licenses", map[string]string{"product": "domain_changer"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 429 {
+		t.Fatalf("got %v %s, want the quota used up", resp.StatusCode, resp.Body)
+	}
+
+	if count, err := p.CounterStore.Count(context.Background(), capCounter("domain_changer")); err != nil || count != 1 {
+		t.Errorf("%v counted against the cap, want 1: %v", count, err)
+	}
+}
+
+func TestCapSandboxAndIgnore(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	setCap(1)
+	createLicense(t, s)
+
+	// admins can go over the cap, still counting the license
+	createLicenseWith(t, s, map[string]interface{}{"product": "domain_changer", "ignore_cap": true})
+
+	useAPIKey(s, p, config.APIKey{ID: "sandbox", Sandbox: true})
+	createLicense(t, s)
+
+	useAPIKey(s, p, config.APIKey{ID: "plain"})
+	resp, err := s.Post("/api/v2/licenses", map[string]interface{}{"product": "domain_changer", "ignore_cap": true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 403 {
+		t.Errorf("got %v %s ignoring the cap with an API key, want a 403", resp.StatusCode, resp.Body)
+	}
+
+	if count, err := p.CounterStore.Count(context.Background(), capCounter("domain_changer")); err != nil || count != 2 {
+		t.Errorf("%v counted against the cap, want 2: %v", count, err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/png"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/qr"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// maxQRScale is the most pixels per module a QR code image can have.
+const maxQRScale = 20
+
+// licenseQR encodes a license string as a QR code, at level M if it fits and
+// otherwise L since licenses with certificates are long.
+func licenseQR(licStr string) (*qr.Code, error) {
+	code, err := qr.Encode([]byte(licStr), qr.M)
+
+	if err == qr.ErrTooLong {
+		code, err = qr.Encode([]byte(licStr), qr.L)
+	}
+
+	return code, err
+}
+
+// LicenseQR handles GET requests to /api/licenses/{id}/qr.png
+//
+// The response is a PNG QR code of a stored license, for desktop and mobile
+// activation flows to scan instead of having the customer type the license.
+// By default the code holds the license string, signed again so that it has
+// the current watermark. With content=url it holds a signed URL of the
+// license's .lic file instead, which makes a smaller code that expires after
+// signed_urls, and needs storage that can sign URLs. scale is the pixels per
+// module, from 1 to 20 (default 4). Revoked licenses don't have codes.
+//
+// Examples:
+//
+//	GET /api/licenses/daS7y8sioiecYy/qr.png?scale=6
+//	200 (image/png)
+//
+//	GET /api/licenses/daS7y8sioiecYy/qr.png?content=url
+//	200 (image/png)
+func LicenseQR(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	scale := 4
+
+	if s := r.URL.Query().Get("scale"); s != "" {
+		n, err := strconv.Atoi(s)
+
+		if err != nil || n < 1 || n > maxQRScale {
+			return &appError{fmt.Errorf("invalid scale %q", s), fmt.Sprintf("The scale must be from 1 to %v", maxQRScale), http.StatusBadRequest, codeInvalidRequest}
+		}
+
+		scale = n
+	}
+
+	content := r.URL.Query().Get("content")
+
+	if content != "" && content != "license" && content != "url" {
+		return &appError{fmt.Errorf("invalid content %q", content), "The content must be license or url", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	lic, err := env.Licenses(c, requestNamespace(c)).Get(c, mux.Vars(r)["id"])
+
+	if err == store.ErrNotFound {
+		return &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if lic.RevokedAt != nil {
+		return &appError{errors.New("license revoked"), "Revoked licenses don't have QR codes", http.StatusConflict, codeLicenseRevoked}
+	}
+
+	licStr, e := signLicense(c, lic)
+
+	if e != nil {
+		return e
+	}
+
+	data := licStr
+
+	if content == "url" {
+		url, err := licenseFileURL(c, lic, lic.EncodeFile(licStr))
+
+		if err != nil {
+			return &appError{err, "Could not sign the license URL", http.StatusInternalServerError, codeInternal}
+		}
+
+		if url == "" {
+			return &appError{errors.New("signed URLs unavailable"), "License URLs need storage that signs URLs and signed_urls", http.StatusBadRequest, codeInvalidRequest}
+		}
+
+		data = url
+	}
+
+	code, err := licenseQR(data)
+
+	if err != nil {
+		return &appError{err, "An error occurred encoding the QR code", http.StatusInternalServerError, codeInternal}
+	}
+
+	var buf bytes.Buffer
+
+	if err := png.Encode(&buf, code.Image(scale)); err != nil {
+		return &appError{err, "An error occurred encoding the QR code", http.StatusInternalServerError, codeInternal}
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/config"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// errKeyQuota is the error for licenses refused by an API key's quota, the
+// reseller quota has its own.
+var errKeyQuota = errors.New("API key quota used up")
+
+// keyQuota is a limit on the licenses an API key issues in a period.
+type keyQuota struct {
+	period  string // daily or monthly
+	counter string
+	limit   int
+}
+
+// keyQuotas returns the quotas of an API key for the periods containing now,
+// counters are named after the key and the period (in UTC) so that they
+// reset without being cleared.
+func keyQuotas(key *config.APIKey, now time.Time) []keyQuota {
+	now = now.UTC()
+	var quotas []keyQuota
+
+	if key.DailyQuota > 0 {
+		quotas = append(quotas, keyQuota{"daily", fmt.Sprintf("issued:key:%v:day:%v", key.ID, now.Format("2006-01-02")), key.DailyQuota})
+	}
+
+	if key.MonthlyQuota > 0 {
+		quotas = append(quotas, keyQuota{"monthly", fmt.Sprintf("issued:key:%v:month:%v", key.ID, now.Format("2006-01")), key.MonthlyQuota})
+	}
+
+	return quotas
+}
+
+// checkKeyQuota returns a 429 if the request's API key has used up a quota,
+// without taking any of it, for dry runs.
+func checkKeyQuota(c context.Context, now time.Time) *appError {
+	key := requestAPIKey(c)
+
+	if key == nil {
+		return nil
+	}
+
+	for _, q := range keyQuotas(key, now) {
+		n, err := env.Counters(c).Count(c, q.counter)
+
+		if err != nil {
+			return &appError{err, "An error occurred checking the API key's quota", http.StatusInternalServerError, codeInternal}
+		}
+
+		if n >= q.limit {
+			notifyQuotaExceeded(c, q.counter, fmt.Sprintf("API key %v has used up its %v issuance quota of %v licenses", key.ID, q.period, q.limit))
+			return &appError{errKeyQuota, fmt.Sprintf("The API key's %v issuance quota is used up", q.period), http.StatusTooManyRequests, codeQuotaExceeded}
+		}
+	}
+
+	return nil
+}
+
+// notifyQuotaExceeded posts to Slack that a quota is used up, once per
+// quota (named by its counter) rather than for every license refused.
+func notifyQuotaExceeded(c context.Context, counter, text string) {
+	if !slackEvent("quota.exceeded") {
+		return
+	}
+
+	notified := counter + ":notified"
+	n, err := env.Counters(c).Count(c, notified)
+
+	if err != nil {
+		env.Errorf(c, "Could not check whether %v has been notified: %v", counter, err)
+		return
+	}
+
+	if n > 0 {
+		return
+	}
+
+	if err := env.Counters(c).Increment(c, notified); err != nil {
+		env.Errorf(c, "Could not record that %v has been notified: %v", counter, err)
+	}
+
+	notifySlack(c, "quota.exceeded", text)
+}
+
+// takeKeyQuota counts a new license against the quotas of the request's API
+// key, returning a 429 once one is used up. The counters are capped so that
+// licenses issued at the same time can't take the quota past its limit, and
+// a license that can't be counted isn't issued. The counters taken must be
+// given back with returnKeyQuota if the license isn't issued.
+func takeKeyQuota(c context.Context, now time.Time) (taken []string, e *appError) {
+	key := requestAPIKey(c)
+
+	if key == nil {
+		return nil, nil
+	}
+
+	for _, q := range keyQuotas(key, now) {
+		switch err := env.Counters(c).IncrementCapped(c, q.counter, q.limit); err {
+		case nil:
+			taken = append(taken, q.counter)
+			continue
+		case store.ErrCapReached:
+			notifyQuotaExceeded(c, q.counter, fmt.Sprintf("API key %v has used up its %v issuance quota of %v licenses", key.ID, q.period, q.limit))
+			e = &appError{errKeyQuota, fmt.Sprintf("The API key's %v issuance quota is used up", q.period), http.StatusTooManyRequests, codeQuotaExceeded}
+		default:
+			e = &appError{err, "An error occurred counting the license against the API key's quota", http.StatusInternalServerError, codeInternal}
+		}
+
+		returnKeyQuota(c, taken)
+		return nil, e
+	}
+
+	return taken, nil
+}
+
+// returnKeyQuota gives back the quota taken for a license that wasn't
+// issued.
+func returnKeyQuota(c context.Context, counters []string) {
+	for _, counter := range counters {
+		if err := env.Counters(c).Decrement(c, counter); err != nil {
+			env.Errorf(c, "Could not give back a license to the quota %v: %v", counter, err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/volcanicpixels/licensing/config"
+)
+
+// rateLimiter is a per-instance token bucket for each client IP address.
+type rateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// maxBuckets bounds the memory used by the limiter, once reached buckets that
+// have refilled are discarded.
+const maxBuckets = 10000
+
+func newRateLimiter(rl config.RateLimit) *rateLimiter {
+	burst := rl.Burst
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &rateLimiter{
+		rate:    float64(rl.RequestsPerMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow takes a token for the client, if there are none it returns false and
+// how long until there will be.
+func (rl *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	b, ok := rl.buckets[client]
+
+	if !ok {
+		if len(rl.buckets) >= maxBuckets {
+			rl.prune(now)
+		}
+
+		b = &bucket{rl.burst, now}
+		rl.buckets[client] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * rl.rate
+	b.last = now
+
+	if b.tokens > rl.burst {
+		b.tokens = rl.burst
+	}
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+func (rl *rateLimiter) prune(now time.Time) {
+	for client, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, client)
+		}
+	}
+}
+
+// clientAddress returns the IP address of the client that made a request.
+func clientAddress(r *http.Request) string {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return client
+}
+
+// rateLimitMiddleware rejects clients that exceed the configured rate with a
+// 429, it does nothing if rate limiting is disabled.
+func rateLimitMiddleware(cfg config.RateLimit) func(http.Handler) http.Handler {
+	if cfg.RequestsPerMinute == 0 {
+		return func(h http.Handler) http.Handler { return h }
+	}
+
+	rl := newRateLimiter(cfg)
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ok, wait := rl.allow(clientAddress(r), time.Now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+				writeError(w, &appError{nil, "Too many requests", http.StatusTooManyRequests, codeRateLimited})
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
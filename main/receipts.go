@@ -0,0 +1,57 @@
+package main
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/license"
+)
+
+// nextSequence increments and returns the license's counter for receipts and
+// offline activations. Requests at the same time can get the same number,
+// but it never goes down.
+func nextSequence(c context.Context, lic *license.License) (int, error) {
+	name := "sequence:" + lic.ID
+
+	if err := env.Counters(c).Increment(c, name); err != nil {
+		return 0, err
+	}
+
+	return env.Counters(c).Count(c, name)
+}
+
+// signReceipt returns the signed receipt of a verdict, signed with the
+// license's key.
+func signReceipt(c context.Context, lic *license.License, vd *verdict, now time.Time) (string, error) {
+	seq, err := nextSequence(c, lic)
+
+	if err != nil {
+		return "", err
+	}
+
+	kid := signingKeyID(lic)
+	key, err := productPrivateKey(c, lic.Product, kid)
+
+	if err != nil {
+		return "", err
+	}
+
+	receipt := &license.Receipt{
+		LicenseID:       lic.ID,
+		Status:          vd.Status,
+		Valid:           vd.Valid,
+		Sequence:        seq,
+		IssuedAt:        now,
+		ExpiresAt:       lic.ExpiresAt,
+		RevalidateAfter: vd.RevalidateAfter,
+	}
+
+	if !lic.Test {
+		if receipt.Certificate, err = getCertificate(c, kid); err != nil {
+			return "", err
+		}
+	}
+
+	return receipt.Encode(key)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"golang.org/x/net/context"
+
+	"github.com/danielchatfield/go-jwt"
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/storage"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// reconciliation lists the IDs that the license store, the revocation store
+// and the exported revocation list disagree on.
+type reconciliation struct {
+	RevokedNotListed  []string `json:"revokedNotListed"`  // stored as revoked, not in the revocation store
+	ListedNotRevoked  []string `json:"listedNotRevoked"`  // in the revocation store, stored as not revoked
+	NotExported       []string `json:"notExported"`       // in the revocation store, not in the exported list
+	ExportedNotListed []string `json:"exportedNotListed"` // in the exported list, not in the revocation store
+
+	// ExportError says why the exported list couldn't be read, all of the
+	// revocation store is then not exported.
+	ExportError string `json:"exportError,omitempty"`
+
+	Consistent bool `json:"consistent"`
+	Repaired   bool `json:"repaired"`
+}
+
+// exportedRevocations reads the IDs in the signed revocation list that was
+// last published.
+func exportedRevocations(c context.Context, sc storage.Storage) ([]string, error) {
+	data, err := sc.ReadFile(cfg.Revocations.Output)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Token string `json:"token"`
+	}
+
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	key, err := getPublicKey(c, cfg.Keys.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tok, err := jwt.ParseToken(file.Token, jwt.RSA, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, _ := tok.Claim("_revoked").([]interface{})
+	ids := make([]string, 0, len(revoked))
+
+	for _, id := range revoked {
+		if s, ok := id.(string); ok {
+			ids = append(ids, s)
+		}
+	}
+
+	return ids, nil
+}
+
+// Reconcile handles GET requests to /api/jobs/reconcile
+//
+// It is run daily by cron and cross-checks the stored licenses, the
+// revocation store and the exported revocation list (revocations.output),
+// reporting the IDs each is missing. IDs in the revocation store that were
+// issued before licenses were stored aren't reported. Any differences are
+// logged and posted to Slack as reconcile.mismatch.
+//
+// With repair=true an exported list that differs from the revocation store
+// is published again, as the hourly update would. The license and revocation
+// stores are never changed, their differences need a person to look at them.
+//
+// Example:
+//
+//	GET /api/jobs/reconcile?repair=true
+//	200 {"revokedNotListed": [], "listedNotRevoked": ["daS7y8sioiecYy"], "notExported": ["daS7y8sioiecYy"], "exportedNotListed": [], "consistent": false, "repaired": true}
+func Reconcile(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	revocations, err := env.Revocations(c)
+
+	if err != nil {
+		return &appError{err, "Could not open the revocation store", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	list, err := revocations.List(c)
+
+	if err != nil {
+		return &appError{err, "An error occurred reading the revocation list", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	listed := make(map[string]bool)
+	var ids []string
+
+	for _, rev := range list {
+		if !listed[rev.ID] {
+			listed[rev.ID] = true
+			ids = append(ids, rev.ID)
+		}
+	}
+
+	rec := &reconciliation{
+		RevokedNotListed:  []string{},
+		ListedNotRevoked:  []string{},
+		NotExported:       []string{},
+		ExportedNotListed: []string{},
+	}
+
+	licenses := env.Licenses(c, "")
+	revoked := make(map[string]bool)
+	q := store.Query{Status: license.StatusRevoked, Limit: maxListLimit}
+
+	for {
+		page, cursor, err := licenses.List(c, q)
+
+		if err != nil {
+			return &appError{err, "An error occurred listing the revoked licenses", http.StatusInternalServerError, codeInternal}
+		}
+
+		for _, lic := range page {
+			revoked[lic.ID] = true
+
+			if !listed[lic.ID] {
+				rec.RevokedNotListed = append(rec.RevokedNotListed, lic.ID)
+			}
+		}
+
+		if cursor == "" {
+			break
+		}
+
+		q.Cursor = cursor
+	}
+
+	for _, id := range ids {
+		if revoked[id] {
+			continue
+		}
+
+		_, err := licenses.Get(c, id)
+
+		if err == store.ErrNotFound {
+			continue
+		}
+
+		if err != nil {
+			return &appError{err, "Could not load the license " + id, http.StatusInternalServerError, codeInternal}
+		}
+
+		rec.ListedNotRevoked = append(rec.ListedNotRevoked, id)
+	}
+
+	sc, err := newStorage(c)
+
+	if err != nil {
+		return &appError{err, "Could not open storage", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	exported := make(map[string]bool)
+	exportedIDs, err := exportedRevocations(c, sc)
+
+	if err != nil {
+		rec.ExportError = err.Error()
+	}
+
+	for _, id := range exportedIDs {
+		exported[id] = true
+
+		if !listed[id] {
+			rec.ExportedNotListed = append(rec.ExportedNotListed, id)
+		}
+	}
+
+	for _, id := range ids {
+		if !exported[id] {
+			rec.NotExported = append(rec.NotExported, id)
+		}
+	}
+
+	for _, s := range [][]string{rec.RevokedNotListed, rec.ListedNotRevoked, rec.NotExported, rec.ExportedNotListed} {
+		sort.Strings(s)
+	}
+
+	exportDiffers := rec.ExportError != "" || len(rec.NotExported) > 0 || len(rec.ExportedNotListed) > 0
+	rec.Consistent = !exportDiffers && len(rec.RevokedNotListed) == 0 && len(rec.ListedNotRevoked) == 0
+
+	if r.FormValue("repair") == "true" && exportDiffers {
+		if e := publishRevocationList(c, sc, ids); e != nil {
+			return e
+		}
+
+		rec.Repaired = true
+
+		err := audit(c, store.AuditEntry{
+			Action: "revocations.repair",
+			Target: cfg.Revocations.Output,
+			Details: map[string]string{
+				"notExported":       strconv.Itoa(len(rec.NotExported)),
+				"exportedNotListed": strconv.Itoa(len(rec.ExportedNotListed)),
+			},
+		})
+
+		if err != nil {
+			env.Errorf(c, "Could not record the repair of the revocation list in the audit log: %v", err)
+		}
+	}
+
+	if !rec.Consistent {
+		text := fmt.Sprintf("The revocations are inconsistent: %v revoked licenses aren't in the revocation store, %v revoked IDs aren't revoked in the license store, %v aren't exported and %v exported IDs aren't in the revocation store",
+			len(rec.RevokedNotListed), len(rec.ListedNotRevoked), len(rec.NotExported), len(rec.ExportedNotListed))
+
+		if rec.Repaired {
+			text += ", the exported list was published again"
+		}
+
+		env.Errorf(c, "%v", text)
+		notifySlack(c, "reconcile.mismatch", text)
+	}
+
+	writeJSON(w, 200, rec)
+	return nil
+}
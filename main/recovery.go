@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// recoveryAccepted is the response to every recovery request that gets as far
+// as looking up the address, so that it doesn't tell whether there are
+// licenses for it.
+const recoveryAccepted = "If licenses were issued to the address, a link to them has been emailed"
+
+// takeRecovery counts a recovery request against a limit, returning false if
+// the limit is already used up. The counter is capped so that requests made
+// at the same time can't go over the limit. Zero is unlimited, and nothing
+// is counted.
+func takeRecovery(c context.Context, counter string, limit int) (bool, error) {
+	if limit <= 0 {
+		return true, nil
+	}
+
+	switch err := env.Counters(c).IncrementCapped(c, counter, limit); err {
+	case nil:
+		return true, nil
+	case store.ErrCapReached:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// recoverableLicenses returns the production licenses issued to email that
+// aren't revoked, newest first.
+func recoverableLicenses(c context.Context, email string) ([]*license.License, error) {
+	found, err := customerLicenses(c, env.Licenses(c, ""), email)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var licenses []*license.License
+
+	for _, lic := range found {
+		if lic.RevokedAt == nil {
+			licenses = append(licenses, lic)
+		}
+	}
+
+	return licenses, nil
+}
+
+// sendRecoveryLink emails a link to the license keys of email if it has
+// any, failures are only logged since the response mustn't depend on them.
+func sendRecoveryLink(c context.Context, r *http.Request, email string) {
+	licenses, err := recoverableLicenses(c, email)
+
+	if err != nil {
+		env.Errorf(c, "Could not find the licenses to recover: %v", err)
+		return
+	}
+
+	if len(licenses) == 0 {
+		return
+	}
+
+	key, err := getPrivateKey(c, cfg.AccessToken.Key)
+
+	if err != nil {
+		env.Errorf(c, "Could not load the key to sign a recovery link: %v", err)
+		return
+	}
+
+	token, err := license.NewRecoveryToken(email, cfg.Mail.RecoveryTTL).Encode(key)
+
+	if err != nil {
+		env.Errorf(c, "Could not sign a recovery link: %v", err)
+		return
+	}
+
+	link := requestBaseURL(r) + "/api/licenses/recover/" + token
+
+	if err := mailAbout(c, emailRecovery, email, licenses[0], emailData{Link: link}); err != nil {
+		env.Errorf(c, "Could not email a recovery link: %v", err)
+		return
+	}
+
+	err = audit(c, store.AuditEntry{Action: "customer.recover", Target: email, Customer: email})
+
+	if err != nil {
+		env.Errorf(c, "Could not record a recovery link in the audit log: %v", err)
+	}
+}
+
+// RecoverLicenses handles POST requests to /api/licenses/recover
+//
+// A customer who lost their license keys submits the email address they
+// bought with, and the server emails it a link to the keys that works for
+// mail.recovery_ttl. The response is the same whether or not any licenses
+// were issued to the address. Each address is emailed at most
+// mail.recovery_per_address links a day, and each client IP address can make
+// mail.recovery_per_client requests an hour before getting 429s.
+//
+// Example:
+//
+//	POST /api/licenses/recover {"email": "jane@example.com"}
+//	202 "If licenses were issued to the address, a link to them has been emailed"
+func RecoverLicenses(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	if cfg.Mail.RecoveryTTL == 0 {
+		return &appError{errors.New("recovery disabled"), "License recovery is not enabled", http.StatusNotFound, codeNotFound}
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	email := strings.TrimSpace(req.Email)
+
+	if !strings.Contains(email, "@") {
+		return &appError{errors.New("invalid email"), "An email address is required", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	now := time.Now().UTC()
+	ok, err := takeRecovery(c, "recover:client:"+clientAddress(r)+":"+now.Format("2006-01-02T15"), cfg.Mail.RecoveryPerClient)
+
+	if err != nil {
+		return &appError{err, "An error occurred checking the recovery limit", http.StatusInternalServerError, codeInternal}
+	}
+
+	if !ok {
+		w.Header().Set("Retry-After", "3600")
+		return &appError{errors.New("recovery limit reached"), "Too many recovery requests, try again later", http.StatusTooManyRequests, codeRateLimited}
+	}
+
+	// the counter is named by a hash so that it doesn't hold the address
+	sum := sha256.Sum256([]byte(strings.ToLower(email)))
+	ok, err = takeRecovery(c, "recover:address:"+hex.EncodeToString(sum[:16])+":"+now.Format("2006-01-02"), cfg.Mail.RecoveryPerAddress)
+
+	if err != nil {
+		return &appError{err, "An error occurred checking the recovery limit", http.StatusInternalServerError, codeInternal}
+	}
+
+	if ok {
+		sendRecoveryLink(c, r, email)
+	}
+
+	writeJSON(w, http.StatusAccepted, recoveryAccepted)
+	return nil
+}
+
+// ShowRecoveredLicenses handles GET requests to /api/licenses/recover/{token}
+//
+// The token is from the link emailed by RecoverLicenses. The response is the
+// .lic files of the licenses issued to the address that aren't revoked, one
+// after another, until the link expires.
+//
+// Example:
+//
+//	GET /api/licenses/recover/eyJhbGciOiJSUzI1NiIs...
+//	200
+//	# License for domain_changer
+//	# ID: daS7y8sioiecYy
+//	# Licensed to: Jane Doe <jane@example.com>
+//	# Expires: never
+//	eyJhbGciOiJSUzI1NiIs...
+func ShowRecoveredLicenses(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	if cfg.Mail.RecoveryTTL == 0 {
+		return &appError{errors.New("recovery disabled"), "Not found", http.StatusNotFound, codeNotFound}
+	}
+
+	key, err := getPublicKey(c, cfg.AccessToken.Key)
+
+	if err != nil {
+		return &appError{err, "Could not load public key for verifying", http.StatusInternalServerError, codeKeyUnavailable}
+	}
+
+	rt, err := license.ParseRecoveryToken(mux.Vars(r)["token"], key)
+
+	if err == license.ErrRecoveryTokenExpired {
+		return &appError{err, "The link has expired, ask for a new one", http.StatusGone, codeTokenExpired}
+	}
+
+	if err != nil {
+		return &appError{errors.New("invalid recovery token"), "Invalid link", http.StatusForbidden, codeTokenInvalid}
+	}
+
+	licenses, err := recoverableLicenses(c, rt.Email)
+
+	if err != nil {
+		return &appError{err, "An error occurred finding the licenses", http.StatusInternalServerError, codeInternal}
+	}
+
+	var buf bytes.Buffer
+
+	for i, lic := range licenses {
+		licStr, e := signLicense(c, lic)
+
+		if e != nil {
+			return e
+		}
+
+		if i > 0 {
+			buf.WriteString("\n")
+		}
+
+		buf.Write(lic.EncodeFile(licStr))
+	}
+
+	if len(licenses) == 0 {
+		buf.WriteString("# No licenses\n")
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf.Bytes())
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/licensingtest"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// slowCounters widens the window between reading and incrementing a
+// counter, like the datastore would.
+type slowCounters struct {
+	store.Counters
+}
+
+func (sc slowCounters) Count(c context.Context, name string) (int, error) {
+	n, err := sc.Counters.Count(c, name)
+	time.Sleep(time.Millisecond)
+	return n, err
+}
+
+func TestRecoverLicensesClientLimitConcurrently(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	p.CounterStorage = slowCounters{p.CounterStore}
+
+	const limit, n = 5, 20
+	cfg.Mail.RecoveryTTL = time.Hour
+	cfg.Mail.RecoveryPerClient = limit
+
+	var wg sync.WaitGroup
+	statuses := make(chan int, n)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			resp, err := s.Post("/api/licenses/recover", map[string]string{"email": fmt.Sprintf("customer%v@example.com", i)})
+
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			statuses <- resp.StatusCode
+		}(i)
+	}
+
+	wg.Wait()
+	close(statuses)
+
+	counts := make(map[int]int)
+
+	for status := range statuses {
+		counts[status]++
+	}
+
+	if counts[202] != limit || counts[429] != n-limit {
+		t.Errorf("got statuses %v, want %v 202s and %v 429s", counts, limit, n-limit)
+	}
+}
+
+// recoverLicenses asks for a recovery link for email, returning the status and the
+// result.
+func recoverLicenses(t *testing.T, s *licensingtest.Server, email string) (int, string) {
+	resp, err := s.Post("/api/licenses/recover", map[string]string{"email": email})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result string
+
+	if resp.StatusCode == 202 {
+		if err := resp.Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return resp.StatusCode, result
+}
+
+func TestRecoverLicenses(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	cfg.Mail.Sender = "licenses@example.com"
+	cfg.Mail.RecoveryTTL = time.Hour
+	cfg.Mail.RecoveryPerAddress = 2
+	cfg.AccessToken.Key = licensingtest.PluginKey.ID
+	createLicenseWith(t, s, map[string]interface{}{"product": "domain_changer", "attrs": map[string]string{"email": "jane@example.com"}})
+
+	tests := []struct {
+		name, email string
+		mailed      int
+	}{
+		{"customer", "jane@example.com", 1},
+		{"not a customer", "john@example.com", 1},
+		{"customer again", "Jane@Example.com", 2},
+		{"over the address limit", "jane@example.com", 2},
+	}
+
+	for _, test := range tests {
+		status, result := recoverLicenses(t, s, test.email)
+
+		// the response never tells whether there are licenses
+		if status != 202 || result != recoveryAccepted {
+			t.Errorf("%v: got %v %q, want a 202 %q", test.name, status, result, recoveryAccepted)
+		}
+
+		if mailed := len(p.Mail.Messages()); mailed != test.mailed {
+			t.Errorf("%v: %v links mailed, want %v", test.name, mailed, test.mailed)
+		}
+	}
+
+	body := p.Mail.Messages()[0].Body
+	i := strings.Index(body, "/api/licenses/recover/")
+
+	if i < 0 {
+		t.Fatalf("the email has no recovery link: %q", body)
+	}
+
+	resp, err := s.Get(strings.Fields(body[i:])[0])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 200 || !strings.Contains(string(resp.Body), "# License for domain_changer") {
+		t.Errorf("got %v %s following the link, want the license", resp.StatusCode, resp.Body)
+	}
+
+	if status, _ := recoverLicenses(t, s, "not an address"); status != 400 {
+		t.Errorf("got %v for an invalid address, want a 400", status)
+	}
+
+	cfg.Mail.RecoveryTTL = 0
+
+	if status, _ := recoverLicenses(t, s, "jane@example.com"); status != 404 {
+		t.Errorf("got %v with recovery disabled, want a 404", status)
+	}
+}
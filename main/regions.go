@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/storage"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// primaryRoutes are the GET routes that don't change a store but still only
+// run on the primary, the cron jobs that publish files and send alerts.
+var primaryRoutes = map[string]bool{
+	"UpdateRevocationFile": true,
+	"CheckAnomalies":       true,
+}
+
+// isReplica reports whether the deployment is a replica in another region,
+// which validates with the files the primary copies to its bucket.
+func isReplica() bool {
+	return cfg.Region.Primary != ""
+}
+
+// toPrimary redirects a route's requests to the primary with a 307 if the
+// deployment is a replica and the route could change a store or is one of
+// the primary's cron jobs. The redirect keeps the method and body, and
+// happens before authentication so that the primary authenticates them.
+func toPrimary(rt route, h appHandler) appHandler {
+	if !isReplica() || !changesStores(rt) && !primaryRoutes[rt.name] {
+		return h
+	}
+
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+		http.Redirect(w, r, strings.TrimSuffix(cfg.Region.Primary, "/")+r.RequestURI, http.StatusTemporaryRedirect)
+		return nil
+	}
+}
+
+// replicaStorages opens the bucket of each replica region.
+func replicaStorages(c context.Context) ([]storage.Storage, error) {
+	var replicas []storage.Storage
+
+	for _, r := range cfg.Region.Replicas {
+		s, err := storage.Open(c, cfg.Storage.Backend, r.Location)
+
+		if err != nil {
+			return nil, err
+		}
+
+		replicas = append(replicas, s)
+	}
+
+	return replicas, nil
+}
+
+// replicatedStorage returns sc with the files written to it also written to
+// the replica regions' buckets, for the files replicas validate with.
+func replicatedStorage(c context.Context, sc storage.Storage) (storage.Storage, error) {
+	replicas, err := replicaStorages(c)
+
+	if err != nil || len(replicas) == 0 {
+		return sc, err
+	}
+
+	return storage.Mirror(sc, replicas...), nil
+}
+
+// replicateKeys copies the public keys and certificates that licenses are
+// verified with to the replica regions' buckets. Private keys aren't copied,
+// replicas that sign (receipts, access tokens and tree heads) read them from
+// Secret Manager, which isn't regional, so nothing is copied for it either.
+func replicateKeys(c context.Context) error {
+	if cfg.Keys.Source != "storage" {
+		return nil
+	}
+
+	replicas, err := replicaStorages(c)
+
+	if err != nil || len(replicas) == 0 {
+		return err
+	}
+
+	for _, kid := range publicKeyIDs() {
+		for _, fileName := range []string{"public.pem", "certificate.jwt"} {
+			data, err := getKey(c, kid, fileName)
+
+			// only intermediate keys have certificates
+			if err == storage.ErrNotExist && fileName == "certificate.jwt" {
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+
+			for _, s := range replicas {
+				if err := s.WriteFile("keys/"+kid+"/"+fileName, data); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// replicatedRevocations returns the revocations in the list the primary last
+// copied to a replica's bucket, so a replica only reads from its region.
+// Revocations reach replicas when the list is next published.
+func replicatedRevocations(c context.Context) ([]store.Revocation, error) {
+	sc, err := newStorage(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := exportedRevocations(c, sc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]store.Revocation, 0, len(ids))
+
+	for _, id := range ids {
+		list = append(list, store.Revocation{ID: id})
+	}
+
+	return list, nil
+}
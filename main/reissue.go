@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// ReissueLicense handles POST requests to /api/licenses/{id}/reissue
+//
+// It signs the stored license again with its product's current key and
+// returns the new string, for customers who lost theirs or to move a license
+// onto a new key after a rotation. The claims are unchanged, so the old
+// string stays valid until the license is revoked, unless invalidate is set
+// in the body. That increments the license's serial, and strings with a
+// lower serial are then validated as superseded.
+//
+// Example:
+//
+//	POST /api/licenses/daS7y8sioiecYy/reissue {"invalidate": true}
+//	200 "eyJhbGciOiJSUzI1NiIs..."
+func ReissueLicense(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	var req struct {
+		Invalidate bool `json:"invalidate"`
+	}
+
+	// the body is optional
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	licenses := env.Licenses(c, requestNamespace(c))
+	lic, err := licenses.Get(c, mux.Vars(r)["id"])
+
+	if err == store.ErrNotFound {
+		return &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if lic.RevokedAt != nil {
+		return &appError{errors.New("license revoked"), "Revoked licenses can't be reissued", http.StatusConflict, codeLicenseRevoked}
+	}
+
+	oldKey := lic.KeyID
+
+	if req.Invalidate {
+		lic.Serial++
+	}
+
+	licStr, e := signLicense(c, lic)
+
+	if e != nil {
+		return e
+	}
+
+	if err := licenses.Put(c, lic); err != nil {
+		return &appError{err, "Could not store the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	entry := store.AuditEntry{
+		Action:   "license.reissue",
+		Target:   lic.ID,
+		Customer: lic.Email(),
+		Details:  map[string]string{"oldKey": oldKey, "key": lic.KeyID, "serial": strconv.Itoa(lic.Serial)},
+	}
+
+	if err := audit(c, entry); err != nil {
+		env.Errorf(c, "Could not record the reissue of %v in the audit log: %v", lic.ID, err)
+	}
+
+	writeJSON(w, 200, encodedLicense(licStr))
+	return nil
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// reportPeriods are the lengths of period a summary can cover.
+var reportPeriods = map[string]func(time.Time) time.Time{
+	"day":   func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+	"week":  func(t time.Time) time.Time { return t.AddDate(0, 0, 7) },
+	"month": func(t time.Time) time.Time { return t.AddDate(0, 1, 0) },
+	"year":  func(t time.Time) time.Time { return t.AddDate(1, 0, 0) },
+}
+
+// periodStart returns the start (in UTC) of the period containing t, weeks
+// start on Monday.
+func periodStart(period string, t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch period {
+	case "week":
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case "month":
+		return monthStart(t)
+	case "year":
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	return day
+}
+
+// counts are what happened to licenses in a period.
+type counts struct {
+	Issued   int `json:"issued"`
+	Renewed  int `json:"renewed"`
+	Revoked  int `json:"revoked"`
+	Expired  int `json:"expired"`
+	Expiring int `json:"expiring"` // active licenses expiring in the next period
+}
+
+// productSummary counts what happened to a product's licenses in a period.
+type productSummary struct {
+	Product string `json:"product"`
+	counts
+}
+
+// campaignSummary counts what happened to the licenses issued under a
+// campaign in a period, those revoked and expired are its churn.
+type campaignSummary struct {
+	Campaign string `json:"campaign"`
+	counts
+}
+
+// upcomingExpiration is an active license that expires in the next period.
+type upcomingExpiration struct {
+	ID        string    `json:"id"`
+	Product   string    `json:"product"`
+	Email     string    `json:"email,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// summary is the report of all products for a period.
+type summary struct {
+	Period   string            `json:"period"`
+	From     time.Time         `json:"from"`
+	To       time.Time         `json:"to"`
+	Products []*productSummary `json:"products"`
+	Total    productSummary    `json:"total"`
+
+	// Campaigns count the licenses tagged with a campaign, by campaign.
+	Campaigns []*campaignSummary `json:"campaigns"`
+
+	// Upcoming are the licenses counted as expiring, soonest first.
+	Upcoming []upcomingExpiration `json:"upcomingExpirations"`
+}
+
+// renewal reports whether a license was issued to replace an earlier one,
+// as renewals (with a renewalOf attribute) and upgrades are.
+func renewal(lic *license.License) bool {
+	_, renewed := lic.Attrs["renewalOf"]
+	_, upgraded := lic.Attrs["upgradedFrom"]
+	return renewed || upgraded
+}
+
+// eachLicense calls fn with each license stored in namespace matching the
+// query.
+func eachLicense(c context.Context, namespace string, q store.Query, fn func(*license.License)) error {
+	licenses := env.Licenses(c, namespace)
+	q.Limit = maxListLimit
+
+	for {
+		page, cursor, err := licenses.List(c, q)
+
+		if err != nil {
+			return err
+		}
+
+		for _, lic := range page {
+			fn(lic)
+		}
+
+		if cursor == "" {
+			return nil
+		}
+
+		q.Cursor = cursor
+	}
+}
+
+// summarize counts the licenses issued, renewed, revoked and expired from
+// (inclusive) to (exclusive), and those expiring between now and next.
+func summarize(c context.Context, period string, from, to, now, next time.Time) (*summary, error) {
+	products := make(map[string]*productSummary)
+	product := func(name string) *productSummary {
+		if products[name] == nil {
+			products[name] = &productSummary{Product: name}
+		}
+
+		return products[name]
+	}
+
+	campaigns := make(map[string]*campaignSummary)
+
+	// count counts a license towards its product and campaign
+	count := func(lic *license.License, fn func(*counts)) {
+		fn(&product(lic.Product).counts)
+
+		if lic.Campaign == "" {
+			return
+		}
+
+		if campaigns[lic.Campaign] == nil {
+			campaigns[lic.Campaign] = &campaignSummary{Campaign: lic.Campaign}
+		}
+
+		fn(&campaigns[lic.Campaign].counts)
+	}
+
+	for name := range cfg.Products {
+		product(name)
+	}
+
+	in := func(t time.Time) bool { return !t.Before(from) && t.Before(to) }
+	s := &summary{Period: period, From: from, To: to, Products: []*productSummary{}, Campaigns: []*campaignSummary{}, Upcoming: []upcomingExpiration{}}
+
+	// CreatedAfter and ExpiringAfter are exclusive, licenses just before
+	// from are skipped by in
+	err := eachLicense(c, "", store.Query{CreatedAfter: from.Add(-time.Second)}, func(lic *license.License) {
+		switch {
+		case !in(lic.IssuedAt):
+		case renewal(lic):
+			count(lic, func(n *counts) { n.Renewed++ })
+		default:
+			count(lic, func(n *counts) { n.Issued++ })
+		}
+	})
+
+	if err == nil {
+		// there is no index on when licenses were revoked
+		err = eachLicense(c, "", store.Query{Status: license.StatusRevoked}, func(lic *license.License) {
+			if in(*lic.RevokedAt) {
+				count(lic, func(n *counts) { n.Revoked++ })
+			}
+		})
+	}
+
+	if err == nil {
+		// licenses in a period that hasn't ended yet may not have expired
+		before := to
+
+		if now.Before(before) {
+			before = now
+		}
+
+		q := store.Query{Order: store.OrderExpiry, ExpiringAfter: from.Add(-time.Second), ExpiringBefore: before}
+		err = eachLicense(c, "", q, func(lic *license.License) {
+			// licenses revoked before they expired count as revoked
+			if in(*lic.ExpiresAt) && lic.ExpiresAt.Before(before) && (lic.RevokedAt == nil || lic.RevokedAt.After(*lic.ExpiresAt)) {
+				count(lic, func(n *counts) { n.Expired++ })
+			}
+		})
+	}
+
+	if err == nil {
+		q := store.Query{Status: license.StatusActive, Order: store.OrderExpiry, ExpiringAfter: now, ExpiringBefore: next}
+		err = eachLicense(c, "", q, func(lic *license.License) {
+			count(lic, func(n *counts) { n.Expiring++ })
+			s.Upcoming = append(s.Upcoming, upcomingExpiration{lic.ID, lic.Product, lic.Email(), *lic.ExpiresAt})
+		})
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.Total.Product = "total"
+
+	for _, p := range products {
+		s.Products = append(s.Products, p)
+		s.Total.Issued += p.Issued
+		s.Total.Renewed += p.Renewed
+		s.Total.Revoked += p.Revoked
+		s.Total.Expired += p.Expired
+		s.Total.Expiring += p.Expiring
+	}
+
+	for _, n := range campaigns {
+		s.Campaigns = append(s.Campaigns, n)
+	}
+
+	sort.Slice(s.Products, func(i, j int) bool { return s.Products[i].Product < s.Products[j].Product })
+	sort.Slice(s.Campaigns, func(i, j int) bool { return s.Campaigns[i].Campaign < s.Campaigns[j].Campaign })
+	return s, nil
+}
+
+// writeSummaryCSV writes a row of counts for each product and the total, or
+// with by campaign for each campaign.
+func writeSummaryCSV(w http.ResponseWriter, s *summary, by string) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="summary-%v-%v.csv"`, s.Period, s.From.Format("2006-01-02")))
+
+	names := []string{}
+	rows := []counts{}
+
+	if by == "campaign" {
+		for _, n := range s.Campaigns {
+			names, rows = append(names, n.Campaign), append(rows, n.counts)
+		}
+	} else {
+		for _, p := range append(s.Products, &s.Total) {
+			names, rows = append(names, p.Product), append(rows, p.counts)
+		}
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{by, "issued", "renewed", "revoked", "expired", "expiring"})
+
+	for i, n := range rows {
+		cw.Write([]string{
+			names[i],
+			strconv.Itoa(n.Issued),
+			strconv.Itoa(n.Renewed),
+			strconv.Itoa(n.Revoked),
+			strconv.Itoa(n.Expired),
+			strconv.Itoa(n.Expiring),
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// SummaryReport handles GET requests to /api/reports/summary
+//
+// It counts the licenses of each product that were issued, renewed, revoked
+// and expired in a period, along with the active licenses that expire in
+// the next period from now and a list of them. The period is a day, week,
+// month (the default) or year, starting at from (an RFC 3339 time) or the
+// start of the current one. Licenses issued with a renewalOf attribute, and
+// upgrades, count as renewed rather than issued. Licenses revoked before they
+// expired only count as revoked. The licenses tagged with a campaign are
+// counted by campaign as well. format=csv returns the counts as CSV, by
+// product or with by=campaign by campaign.
+//
+// Examples:
+//
+//	GET /api/reports/summary?period=month&from=2026-09-01T00:00:00Z
+//	200 {"period": "month", "from": "...", "to": "...", "products": [{"product": "domain_changer", "issued": 40, "renewed": 12, "revoked": 2, "expired": 9, "expiring": 11}], "total": {...}, "campaigns": [{"campaign": "blackfriday", "issued": 15, ...}], "upcomingExpirations": [...]}
+//
+//	GET /api/reports/summary?period=month&format=csv
+//	product,issued,renewed,revoked,expired,expiring
+//	domain_changer,40,12,2,9,11
+//	total,40,12,2,9,11
+//
+//	GET /api/reports/summary?period=month&format=csv&by=campaign
+//	campaign,issued,renewed,revoked,expired,expiring
+//	blackfriday,15,0,1,0,0
+func SummaryReport(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	period := r.URL.Query().Get("period")
+
+	if period == "" {
+		period = "month"
+	}
+
+	end, ok := reportPeriods[period]
+
+	if !ok {
+		return &appError{fmt.Errorf("unknown period %q", period), "period must be day, week, month or year", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	now := time.Now()
+	from := periodStart(period, now)
+
+	if s := r.URL.Query().Get("from"); s != "" {
+		var err error
+
+		if from, err = time.Parse(time.RFC3339, s); err != nil {
+			return &appError{err, "from must be an RFC 3339 time", http.StatusBadRequest, codeInvalidRequest}
+		}
+	}
+
+	format := r.URL.Query().Get("format")
+
+	if format != "" && format != "json" && format != "csv" {
+		return &appError{errors.New("unknown format"), "format must be json or csv", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	by := r.URL.Query().Get("by")
+
+	if by == "" {
+		by = "product"
+	}
+
+	if by != "product" && by != "campaign" {
+		return &appError{fmt.Errorf("unknown by %q", by), "by must be product or campaign", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	s, err := summarize(c, period, from, end(from), now, end(now))
+
+	if err != nil {
+		return &appError{err, "An error occurred counting the licenses", http.StatusInternalServerError, codeInternal}
+	}
+
+	if format == "csv" {
+		if err := writeSummaryCSV(w, s, by); err != nil {
+			env.Errorf(c, "Could not write the summary report: %v", err)
+		}
+
+		return nil
+	}
+
+	writeJSON(w, 200, s)
+	return nil
+}
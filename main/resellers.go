@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/config"
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// requestReseller returns the reseller named in the URL.
+func requestReseller(r *http.Request) (*config.Reseller, *appError) {
+	id := mux.Vars(r)["id"]
+	reseller := cfg.Reseller(id)
+
+	if reseller == nil {
+		return nil, &appError{fmt.Errorf("unknown reseller %q", id), "Reseller not found", http.StatusNotFound, codeResellerUnknown}
+	}
+
+	return reseller, nil
+}
+
+// resellerNamespace returns the namespace of the licenses a reseller request
+// is about, sandbox keys and sandbox=true see test licenses.
+func resellerNamespace(c context.Context, r *http.Request) string {
+	if r.URL.Query().Get("sandbox") == "true" {
+		return store.SandboxNamespace
+	}
+
+	return requestNamespace(c)
+}
+
+// monthStart returns the start of the calendar month (UTC) that t is in.
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// issuance is how many licenses a reseller issued from (inclusive) to
+// (exclusive).
+type issuance struct {
+	Reseller  string         `json:"reseller"`
+	From      time.Time      `json:"from"`
+	To        time.Time      `json:"to"`
+	Issued    int            `json:"issued"`
+	ByProduct map[string]int `json:"byProduct"`
+}
+
+// countIssued pages through the licenses a reseller issued in a period.
+func countIssued(c context.Context, namespace, reseller string, from, to time.Time) (*issuance, error) {
+	n := &issuance{Reseller: reseller, From: from, To: to, ByProduct: make(map[string]int)}
+	licenses := env.Licenses(c, namespace)
+
+	// CreatedAfter is exclusive, licenses issued just before from are
+	// skipped below
+	q := store.Query{Reseller: reseller, CreatedAfter: from.Add(-time.Second), Limit: maxListLimit}
+
+	for {
+		page, cursor, err := licenses.List(c, q)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, l := range page {
+			if !l.IssuedAt.Before(from) && l.IssuedAt.Before(to) {
+				n.Issued++
+				n.ByProduct[l.Product]++
+			}
+		}
+
+		if cursor == "" {
+			return n, nil
+		}
+
+		q.Cursor = cursor
+	}
+}
+
+// NewResellerLicense handles POST requests to /api/resellers/{id}/licenses
+//
+// The request body and dry runs are as for /api/licenses. The license is
+// tagged with the reseller, which must be allowed the product and have some
+// of its monthly quota left. Licenses issued at the same time can both take the
+// last of the quota.
+//
+// Examples:
+//
+//	POST /api/resellers/acme/licenses {"product": "domain_changer", "template": "pro-annual"}
+//	200 "eyJhbGciOiJSUzI1NiIs..."
+//
+//	429 The reseller's monthly quota is used up
+func NewResellerLicense(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	reseller, e := requestReseller(r)
+
+	if e != nil {
+		return e
+	}
+
+	var req createRequest
+
+	dry, e := dryRun(r)
+
+	if e != nil {
+		return e
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	if !resellerAllows(reseller, req.Product) {
+		err := fmt.Errorf("reseller %v can't issue %v licenses", reseller.ID, req.Product)
+		return &appError{err, "The reseller can't issue licenses for this product", http.StatusForbidden, codeForbidden}
+	}
+
+	if reseller.MonthlyQuota > 0 {
+		from := monthStart(time.Now())
+		n, err := countIssued(c, requestNamespace(c), reseller.ID, from, from.AddDate(0, 1, 0))
+
+		if err != nil {
+			return &appError{err, "An error occurred counting the reseller's licenses", http.StatusInternalServerError, codeInternal}
+		}
+
+		if n.Issued >= reseller.MonthlyQuota {
+			counter := fmt.Sprintf("issued:reseller:%v:month:%v", reseller.ID, from.Format("2006-01"))
+			notifyQuotaExceeded(c, counter, fmt.Sprintf("Reseller %v has used up its monthly quota of %v licenses", reseller.ID, reseller.MonthlyQuota))
+
+			err := errors.New("reseller quota used up")
+			return &appError{err, "The reseller's monthly quota is used up", http.StatusTooManyRequests, codeQuotaExceeded}
+		}
+	}
+
+	if dry {
+		return previewLicense(c, w, &req, reseller.ID)
+	}
+
+	_, licStr, dup, e := issueLicense(c, &req, reseller.ID)
+
+	if e != nil {
+		return e
+	}
+
+	writeResponse(w, response{Status: 200, Result: encodedLicense(licStr), Deduplicated: dup})
+	return nil
+}
+
+func resellerAllows(reseller *config.Reseller, product string) bool {
+	if len(reseller.Products) == 0 {
+		return true
+	}
+
+	for _, p := range reseller.Products {
+		if p == product {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ListResellerLicenses handles GET requests to /api/resellers/{id}/licenses
+//
+// It lists the licenses issued by the reseller, newest first, taking the
+// product, status, created_after, limit and cursor parameters of
+// /api/licenses.
+func ListResellerLicenses(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	reseller, e := requestReseller(r)
+
+	if e != nil {
+		return e
+	}
+
+	q, err := parseListQuery(r)
+
+	if err != nil {
+		return &appError{err, err.Error(), http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	q.Reseller = reseller.ID
+	licenses, cursor, err := env.Licenses(c, resellerNamespace(c, r)).List(c, q)
+
+	if err != nil {
+		return &appError{err, "An error occurred listing the licenses", http.StatusInternalServerError, codeInternal}
+	}
+
+	if licenses == nil {
+		licenses = []*license.License{}
+	}
+
+	writeJSON(w, 200, struct {
+		Licenses []*license.License `json:"licenses"`
+		Cursor   string             `json:"cursor,omitempty"`
+	}{licenses, cursor})
+
+	return nil
+}
+
+// ResellerReport handles GET requests to /api/resellers/{id}/report
+//
+// It counts the licenses the reseller issued between the from and to
+// parameters (RFC 3339 times), by default the current month, for invoicing.
+// Revoked licenses are counted since they were issued.
+//
+// Example:
+//
+//	GET /api/resellers/acme/report?from=2026-09-01T00:00:00Z&to=2026-10-01T00:00:00Z
+//	200 {"reseller": "acme", "from": "...", "to": "...", "issued": 42, "byProduct": {"domain_changer": 42}}
+func ResellerReport(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	reseller, e := requestReseller(r)
+
+	if e != nil {
+		return e
+	}
+
+	from := monthStart(time.Now())
+	to := from.AddDate(0, 1, 0)
+	times := map[string]*time.Time{"from": &from, "to": &to}
+
+	for name, t := range times {
+		if s := r.URL.Query().Get(name); s != "" {
+			var err error
+
+			if *t, err = time.Parse(time.RFC3339, s); err != nil {
+				return &appError{err, name + " must be an RFC 3339 time", http.StatusBadRequest, codeInvalidRequest}
+			}
+		}
+	}
+
+	if !from.Before(to) {
+		return &appError{errors.New("empty period"), "from must be before to", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	n, err := countIssued(c, resellerNamespace(c, r), reseller.ID, from, to)
+
+	if err != nil {
+		return &appError{err, "An error occurred counting the reseller's licenses", http.StatusInternalServerError, codeInternal}
+	}
+
+	writeJSON(w, 200, n)
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// jobResign is the kind of the jobs that re-sign a product's licenses.
+const jobResign = "resign"
+
+// resignLicense signs a stored license again with its product's current key
+// and stores it, emailing the new string to the customer if notify is set
+// (revoked and test licenses aren't emailed). The details say why in the
+// audit log along with the keys.
+func resignLicense(c context.Context, licenses store.Licenses, lic *license.License, notify bool, details map[string]string) *appError {
+	oldKey := lic.KeyID
+	licStr, e := signLicense(c, lic)
+
+	if e != nil {
+		return e
+	}
+
+	if err := licenses.Put(c, lic); err != nil {
+		return &appError{err, "An error occurred storing license " + lic.ID, http.StatusInternalServerError, codeInternal}
+	}
+
+	if notify && !lic.Test && lic.RevokedAt == nil {
+		if err := mailCustomer(c, emailIssued, lic, emailData{License: licStr}); err != nil {
+			env.Errorf(c, "Could not email the re-signed license %v to its customer: %v", lic.ID, err)
+		}
+	}
+
+	entry := store.AuditEntry{
+		Action:   "license.resign",
+		Target:   lic.ID,
+		Customer: lic.Email(),
+		Details:  map[string]string{"oldKey": oldKey, "key": lic.KeyID},
+	}
+
+	for k, v := range details {
+		entry.Details[k] = v
+	}
+
+	if err := audit(c, entry); err != nil {
+		env.Errorf(c, "Could not record the re-signed license %v in the audit log: %v", lic.ID, err)
+	}
+
+	return nil
+}
+
+// ResignProduct handles POST requests to /api/products/{product}/resign
+//
+// It starts a job that re-signs every active license of a product that
+// isn't signed with the product's current key, such as after a rotation so
+// that the old key can be retired. Licenses stored before the server
+// recorded their key are re-signed too. The new licenses are stored and,
+// with email set, emailed to the customers. sandbox=true re-signs test
+// licenses. The response is the job (see GET /api/jobs/{id}), which counts
+// the licenses re-signed as processed.
+//
+// Example:
+//
+//	POST /api/products/domain_changer/resign {"email": true}
+//	202 {"id": "Vx2kq9LmTb0ZsY4e", "kind": "resign", "status": "running", ...}
+func ResignProduct(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	product := mux.Vars(r)["product"]
+
+	if e := checkProduct(c, product); e != nil {
+		return e
+	}
+
+	var req struct {
+		Email bool `json:"email"`
+	}
+
+	// the body is optional
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	params := map[string]string{"product": product, "email": strconv.FormatBool(req.Email)}
+
+	if r.URL.Query().Get("sandbox") == "true" {
+		params["namespace"] = store.SandboxNamespace
+	}
+
+	job, err := startJob(c, jobResign, params)
+
+	if err != nil {
+		return &appError{err, "Could not start the job", http.StatusInternalServerError, codeInternal}
+	}
+
+	if err := audit(c, store.AuditEntry{Action: "product.resign", Target: product, Details: map[string]string{"job": job.ID}}); err != nil {
+		env.Errorf(c, "Could not record re-signing the %v licenses in the audit log: %v", product, err)
+	}
+
+	writeJSON(w, http.StatusAccepted, job)
+	return nil
+}
+
+// runResignBatch re-signs a batch of a product's active licenses, those
+// that fail to are counted and logged.
+func runResignBatch(c context.Context, job *store.Job) (string, error) {
+	licenses := env.Licenses(c, job.Params["namespace"])
+	q := store.Query{Product: job.Params["product"], Status: license.StatusActive, Limit: resignPageSize, Cursor: job.Cursor}
+	page, cursor, err := licenses.List(c, q)
+
+	if err != nil {
+		return "", err
+	}
+
+	notify := job.Params["email"] == "true"
+
+	for _, lic := range page {
+		if lic.KeyID == signingKeyID(lic) {
+			continue
+		}
+
+		if e := resignLicense(c, licenses, lic, notify, map[string]string{"job": job.ID}); e != nil {
+			env.Errorf(c, "Could not re-sign %v: %v", lic.ID, e.Error)
+			job.Failed++
+			continue
+		}
+
+		job.Processed++
+	}
+
+	return cursor, nil
+}
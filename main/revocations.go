@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rsa"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/danielchatfield/go-jwt"
+	"github.com/volcanicpixels/licensing/storage"
+)
+
+// revocationShard describes one shard of the revocation list in its index.
+type revocationShard struct {
+	Prefix string `json:"prefix"`
+	File   string `json:"file"`
+	Count  int    `json:"count"`
+}
+
+// publishToken signs the claims into a token with the revocation list's
+// expiry and writes it to a public file as {"token": ...}.
+func publishToken(sc storage.Storage, key *rsa.PrivateKey, fileName string, claims map[string]interface{}, exp time.Time) ([]byte, error) {
+	body, err := signedFile(key, claims, exp)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return body, publish(sc, fileName, body)
+}
+
+// signedFile signs the claims into a token that expires at exp and returns
+// the file {"token": ...} that it is published as.
+func signedFile(key *rsa.PrivateKey, claims map[string]interface{}, exp time.Time) ([]byte, error) {
+	t := jwt.NewToken(jwt.RSA)
+
+	for name, value := range claims {
+		t.SetClaim(name, value)
+	}
+
+	t.SetClaim("exp", exp.Unix())
+
+	tokenString, err := t.Encode(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(struct {
+		Token string `json:"token"`
+	}{tokenString})
+}
+
+// publish writes a file and makes it public.
+func publish(sc storage.Storage, fileName string, data []byte) error {
+	if err := sc.WriteFile(fileName, data); err != nil {
+		return err
+	}
+
+	return sc.MakePublic(fileName)
+}
+
+// shardDir is where the shards of the revocation list are written, next to
+// the list itself, so revocations.json has shards in revocations/.
+func shardDir() string {
+	output := cfg.Revocations.Output
+	return strings.TrimSuffix(output, path.Ext(output))
+}
+
+// shardFile names the shard for a prefix, it is hex encoded so that shards
+// differing only in case don't collide on case-insensitive file systems.
+func shardFile(prefix string) string {
+	return path.Join(shardDir(), "shard-"+hex.EncodeToString([]byte(prefix))+".json")
+}
+
+// UpdateRevocationFile handles GET requests to /api/update_revocation_file
+//
+// The revocation list is signed and written to the output file along with a
+// gzip compressed copy (the output file name with .gz appended). If sharding
+// is configured each shard is written as its own signed file followed by a
+// signed index.json of the shards. The offline bundles of the products are
+// then regenerated, and key rotations since the last run are posted to Slack.
+func UpdateRevocationFile(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	revocations, err := env.Revocations(c)
+
+	if err != nil {
+		return &appError{err, "Could not open the revocation store", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	list, err := revocations.List(c)
+
+	if err != nil {
+		return &appError{err, "An error occurred reading the revocation list", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	var formatted []string
+
+	for _, rev := range list {
+		formatted = append(formatted, rev.ID)
+	}
+
+	// ok, we have the revocations now
+
+	// revocations can be written to the source file by other systems, they
+	// are added to the transparency log here
+	if err := logRevocations(c, formatted); err != nil {
+		return &appError{err, "An error occurred updating the transparency log", http.StatusInternalServerError, codeInternal}
+	}
+
+	sc, err := newStorage(c)
+
+	if err != nil {
+		return &appError{err, "Could not open storage", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	if e := publishRevocationList(c, sc, formatted); e != nil {
+		return e
+	}
+
+	if err := notifyKeyRotations(c, sc); err != nil {
+		env.Errorf(c, "Could not check for key rotations: %v", err)
+	}
+
+	if err := recordKeyHistory(sc, time.Now()); err != nil {
+		env.Errorf(c, "Could not record the key history: %v", err)
+	}
+
+	writeJSON(w, 200, "SUCCESS")
+
+	return nil
+}
+
+// publishRevocationList signs and writes the revocation list of the revoked
+// IDs, with its compressed copy, shards and the offline bundles, to storage
+// and the replica regions' buckets along with the public keys, and then has
+// it published to the CDN.
+func publishRevocationList(c context.Context, sc storage.Storage, formatted []string) *appError {
+	key, err := getPrivateKey(c, cfg.Keys.ID)
+
+	if err != nil {
+		return &appError{err, "The private key could not be retrieved", http.StatusInternalServerError, codeKeyUnavailable}
+	}
+
+	if sc, err = replicatedStorage(c, sc); err != nil {
+		return &appError{err, "Could not open the replicas' storage", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	if err := replicateKeys(c); err != nil {
+		return &appError{err, "An error occurred copying the public keys to the replicas", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	exp := time.Now().Add(cfg.Revocations.TTL)
+	body, err := publishToken(sc, key, cfg.Revocations.Output, map[string]interface{}{"_revoked": formatted}, exp)
+
+	if err != nil {
+		return &appError{err, "An error occured when writing the revocation list", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+
+	if _, err := zw.Write(body); err == nil {
+		err = zw.Close()
+	}
+
+	if err == nil {
+		err = publish(sc, cfg.Revocations.Output+".gz", gz.Bytes())
+	}
+
+	if err != nil {
+		return &appError{err, "An error occured when writing the compressed revocation list", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	if n := cfg.Revocations.ShardPrefixLength; n > 0 {
+		if err := publishShards(sc, key, formatted, n, exp); err != nil {
+			return &appError{err, "An error occured when writing the revocation shards", http.StatusInternalServerError, codeStorageUnavailable}
+		}
+	}
+
+	if err := publishOfflineBundles(c, sc, formatted); err != nil {
+		return &appError{err, "An error occured when writing the offline bundles", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	// the CDN gets the list with a fresh expiry even if nothing was revoked
+	if err := enqueueCDNPublish(c); err != nil {
+		env.Errorf(c, "Could not enqueue publishing the revocation list to the CDN: %v", err)
+	}
+
+	return nil
+}
+
+// publishShards writes the revoked IDs in shards by their prefix and then the
+// index of the shards, last so that it never refers to a missing shard. IDs
+// shorter than the prefix are in the shard of the whole ID.
+func publishShards(sc storage.Storage, key *rsa.PrivateKey, ids []string, prefixLength int, exp time.Time) error {
+	shards := make(map[string][]string)
+
+	for _, id := range ids {
+		prefix := id
+
+		if len(prefix) > prefixLength {
+			prefix = prefix[:prefixLength]
+		}
+
+		shards[prefix] = append(shards[prefix], id)
+	}
+
+	index := make([]revocationShard, 0, len(shards))
+
+	for prefix, revoked := range shards {
+		fileName := shardFile(prefix)
+		claims := map[string]interface{}{"_revoked": revoked, "_prefix": prefix}
+
+		if _, err := publishToken(sc, key, fileName, claims, exp); err != nil {
+			return err
+		}
+
+		index = append(index, revocationShard{prefix, path.Base(fileName), len(revoked)})
+	}
+
+	sort.Slice(index, func(i, j int) bool { return index[i].Prefix < index[j].Prefix })
+
+	claims := map[string]interface{}{"_shards": index, "_prefixLength": prefixLength}
+	_, err := publishToken(sc, key, path.Join(shardDir(), "index.json"), claims, exp)
+
+	return err
+}
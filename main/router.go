@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/justinas/alice"
@@ -10,10 +11,15 @@ import (
 
 func newAPIRouter() http.Handler {
 	router := mux.NewRouter()
-	chain := alice.New(stripPrefixMiddleware("/api"))
+	chain := alice.New(compressionMiddleware(cfg.Compression), stripPrefixMiddleware("/api"), rateLimitMiddleware(cfg.RateLimit))
 
 	for _, route := range apiRoutes {
-		handler := appHandler(route.handler)
+		handler := toPrimary(route, authenticate(route.access, inMaintenance(route, route.handler)))
+
+		// routes that are only under /v2 answer like the rest of it
+		if strings.HasPrefix(route.pattern, v2Prefix+"/") {
+			handler = versioned(handler)
+		}
 
 		// add middlleware here
 
@@ -25,6 +31,22 @@ func newAPIRouter() http.Handler {
 
 	}
 
+	// every route is served under /v2 too, after the routes that are only
+	// there so that they match first
+	for _, route := range apiRoutes {
+		if strings.HasPrefix(route.pattern, v2Prefix+"/") {
+			continue
+		}
+
+		handler := versioned(toPrimary(route, authenticate(route.access, inMaintenance(route, route.handler))))
+
+		router.
+			Methods(route.method).
+			Path(v2Prefix + route.pattern).
+			Name(route.name).
+			Handler(handler)
+	}
+
 	//router.HandleFunc("/licenses", testHandler)
 
 	return chain.Then(router)
@@ -4,6 +4,7 @@ type route struct {
 	name    string
 	method  string
 	pattern string
+	access  access
 	handler appHandler
 }
 
@@ -14,24 +15,638 @@ var apiRoutes = routes{
 		"NewLicense",
 		"POST",
 		"/licenses",
+		apiKeyAccess,
 		NewLicense,
 	},
+	route{
+		"NewLicenseV2",
+		"POST",
+		"/v2/licenses",
+		apiKeyAccess,
+		NewLicenseV2,
+	},
+	route{
+		"ListLicenses",
+		"GET",
+		"/licenses",
+		adminAccess,
+		ListLicenses,
+	},
+	route{
+		"BulkAction",
+		"POST",
+		"/licenses/bulk",
+		adminAccess,
+		BulkAction,
+	},
+	route{
+		"GetLicense",
+		"GET",
+		"/licenses/{id}",
+		apiKeyAccess,
+		GetLicense,
+	},
+	route{
+		"UpdateLicense",
+		"PATCH",
+		"/licenses/{id}",
+		adminKeyAccess,
+		UpdateLicense,
+	},
 	route{
 		"RevokeLicense",
 		"POST",
 		"/licenses/{id}/revoke",
+		adminAccess,
 		RevokeLicense,
 	},
+	route{
+		"CancelRevocation",
+		"DELETE",
+		"/licenses/{id}/revoke",
+		adminAccess,
+		CancelRevocation,
+	},
 	route{
 		"DecodeLicense",
 		"POST",
 		"/licenses/_/decode",
+		adminAccess,
 		DecodeLicense,
 	},
+	route{
+		"ResaveLicenses",
+		"POST",
+		"/licenses/_/resave",
+		adminAccess,
+		ResaveLicenses,
+	},
+	route{
+		"ValidateLicense",
+		"POST",
+		"/licenses/validate",
+		publicAccess,
+		ValidateLicense,
+	},
+	route{
+		"ValidateLicenseCanary",
+		"POST",
+		"/v2/licenses/validate-canary",
+		publicAccess,
+		ValidateLicenseCanary,
+	},
+	route{
+		"ActivateLicense",
+		"POST",
+		"/licenses/activate",
+		publicAccess,
+		ActivateLicense,
+	},
+	route{
+		"DeactivateLicense",
+		"POST",
+		"/licenses/deactivate",
+		publicAccess,
+		DeactivateLicense,
+	},
+	route{
+		"NewAccessToken",
+		"POST",
+		"/licenses/token",
+		publicAccess,
+		NewAccessToken,
+	},
+	route{
+		"RecoverLicenses",
+		"POST",
+		"/licenses/recover",
+		publicAccess,
+		RecoverLicenses,
+	},
+	route{
+		"ShowRecoveredLicenses",
+		"GET",
+		"/licenses/recover/{token}",
+		publicAccess,
+		ShowRecoveredLicenses,
+	},
+	route{
+		"ActivateOffline",
+		"POST",
+		"/activations/offline",
+		adminAccess,
+		ActivateOffline,
+	},
+	route{
+		"ListMembers",
+		"POST",
+		"/licenses/members",
+		publicAccess,
+		ListMembers,
+	},
+	route{
+		"InviteMember",
+		"POST",
+		"/licenses/members/invite",
+		publicAccess,
+		InviteMember,
+	},
+	route{
+		"RemoveMember",
+		"POST",
+		"/licenses/members/remove",
+		publicAccess,
+		RemoveMember,
+	},
+	route{
+		"ValidateLicenseBatch",
+		"POST",
+		"/licenses/validate-batch",
+		publicAccess,
+		ValidateLicenseBatch,
+	},
+	route{
+		"DownloadLicense",
+		"GET",
+		"/licenses/{id}/download",
+		apiKeyAccess,
+		DownloadLicense,
+	},
+	route{
+		"LicenseCertificate",
+		"GET",
+		"/licenses/{id}/certificate.pdf",
+		apiKeyAccess,
+		LicenseCertificate,
+	},
+	route{
+		"LicenseQR",
+		"GET",
+		"/licenses/{id}/qr.png",
+		apiKeyAccess,
+		LicenseQR,
+	},
+	route{
+		"ListActivations",
+		"GET",
+		"/licenses/{id}/activations",
+		apiKeyAccess,
+		ListActivations,
+	},
+	route{
+		"ReissueLicense",
+		"POST",
+		"/licenses/{id}/reissue",
+		apiKeyAccess,
+		ReissueLicense,
+	},
+	route{
+		"LiftSuspension",
+		"DELETE",
+		"/licenses/{id}/suspension",
+		adminKeyAccess,
+		LiftSuspension,
+	},
+	route{
+		"ChangePlan",
+		"POST",
+		"/licenses/{id}/change-plan",
+		apiKeyAccess,
+		ChangePlan,
+	},
+	route{
+		"ExtendLicense",
+		"POST",
+		"/licenses/{id}/extend",
+		adminKeyAccess,
+		ExtendLicense,
+	},
+	route{
+		"UpgradeLicense",
+		"POST",
+		"/licenses/{id}/upgrade",
+		apiKeyAccess,
+		UpgradeLicense,
+	},
+	route{
+		"SplitLicense",
+		"POST",
+		"/licenses/{id}/split",
+		apiKeyAccess,
+		SplitLicense,
+	},
+	route{
+		"ListUsers",
+		"GET",
+		"/licenses/{id}/users",
+		apiKeyAccess,
+		ListUsers,
+	},
+	route{
+		"AddUser",
+		"POST",
+		"/licenses/{id}/users",
+		apiKeyAccess,
+		AddUser,
+	},
+	route{
+		"RemoveUser",
+		"DELETE",
+		"/licenses/{id}/users",
+		apiKeyAccess,
+		RemoveUser,
+	},
+	route{
+		"IssueMembersToken",
+		"POST",
+		"/licenses/{id}/members-token",
+		apiKeyAccess,
+		IssueMembersToken,
+	},
+	route{
+		"IssueDownloadToken",
+		"POST",
+		"/licenses/{id}/download-token",
+		apiKeyAccess,
+		IssueDownloadToken,
+	},
+	route{
+		"CheckEntitlement",
+		"GET",
+		"/licenses/{id}/entitlements/{feature}",
+		apiKeyAccess,
+		CheckEntitlement,
+	},
+	route{
+		"OfflineBundle",
+		"GET",
+		"/products/{product}/offline-bundle",
+		publicAccess,
+		OfflineBundle,
+	},
+	route{
+		"GetKillSwitch",
+		"GET",
+		"/products/{product}/kill-switch",
+		adminAccess,
+		GetKillSwitch,
+	},
+	route{
+		"SetKillSwitch",
+		"PUT",
+		"/products/{product}/kill-switch",
+		adminAccess,
+		SetKillSwitch,
+	},
+	route{
+		"ResignProduct",
+		"POST",
+		"/products/{product}/resign",
+		adminAccess,
+		ResignProduct,
+	},
+	route{
+		"NewResellerLicense",
+		"POST",
+		"/resellers/{id}/licenses",
+		resellerAccess,
+		NewResellerLicense,
+	},
+	route{
+		"ListResellerLicenses",
+		"GET",
+		"/resellers/{id}/licenses",
+		resellerAccess,
+		ListResellerLicenses,
+	},
+	route{
+		"ResellerReport",
+		"GET",
+		"/resellers/{id}/report",
+		resellerAccess,
+		ResellerReport,
+	},
+	route{
+		"SummaryReport",
+		"GET",
+		"/reports/summary",
+		adminAccess,
+		SummaryReport,
+	},
+	route{
+		"DescribeFormats",
+		"GET",
+		"/formats",
+		publicAccess,
+		DescribeFormats,
+	},
+	route{
+		"FormatReport",
+		"GET",
+		"/reports/formats",
+		adminAccess,
+		FormatReport,
+	},
+	route{
+		"ExportCustomer",
+		"GET",
+		"/customers/{id}/export",
+		adminAccess,
+		ExportCustomer,
+	},
+	route{
+		"ForgetCustomer",
+		"POST",
+		"/customers/{id}/forget",
+		adminAccess,
+		ForgetCustomer,
+	},
+	route{
+		"StripeWebhook",
+		"POST",
+		"/payments/stripe/webhook",
+		publicAccess,
+		StripeWebhook,
+	},
+	route{
+		"PayPalWebhook",
+		"POST",
+		"/payments/paypal/webhook",
+		publicAccess,
+		PayPalWebhook,
+	},
+	route{
+		"PayPalWebhook",
+		"POST",
+		"/webhooks/paypal",
+		publicAccess,
+		PayPalWebhook,
+	},
+	route{
+		"FastSpringWebhook",
+		"POST",
+		"/payments/fastspring/webhook",
+		publicAccess,
+		FastSpringWebhook,
+	},
+	route{
+		"TransparencyHead",
+		"GET",
+		"/transparency/head",
+		publicAccess,
+		TransparencyHead,
+	},
+	route{
+		"TransparencyProof",
+		"GET",
+		"/transparency/proof",
+		publicAccess,
+		TransparencyProof,
+	},
+	route{
+		"TransparencyConsistency",
+		"GET",
+		"/transparency/consistency",
+		publicAccess,
+		TransparencyConsistency,
+	},
+	route{
+		"TransparencyEntries",
+		"GET",
+		"/transparency/entries",
+		publicAccess,
+		TransparencyEntries,
+	},
+	route{
+		"ServiceStatus",
+		"GET",
+		"/status",
+		publicAccess,
+		ServiceStatus,
+	},
+	route{
+		"EDDAction",
+		"GET",
+		"/edd",
+		publicAccess,
+		EDDAction,
+	},
+	route{
+		"EDDActionPost",
+		"POST",
+		"/edd",
+		publicAccess,
+		EDDAction,
+	},
+	route{
+		"CheckUpdate",
+		"GET",
+		"/updates/{product}",
+		publicAccess,
+		CheckUpdate,
+	},
+	route{
+		"Download",
+		"GET",
+		"/downloads/{token}",
+		publicAccess,
+		Download,
+	},
+	route{
+		"CheckAnomalies",
+		"GET",
+		"/jobs/anomalies",
+		adminAccess,
+		CheckAnomalies,
+	},
+	route{
+		"ListEmailTemplates",
+		"GET",
+		"/email-templates",
+		adminAccess,
+		ListEmailTemplates,
+	},
+	route{
+		"PutEmailTemplate",
+		"PUT",
+		"/email-templates/{kind}/{locale}",
+		adminAccess,
+		PutEmailTemplate,
+	},
+	route{
+		"DeleteEmailTemplate",
+		"DELETE",
+		"/email-templates/{kind}/{locale}",
+		adminAccess,
+		DeleteEmailTemplate,
+	},
+	route{
+		"ListFlags",
+		"GET",
+		"/flags",
+		adminAccess,
+		ListFlags,
+	},
+	route{
+		"PutFlag",
+		"PUT",
+		"/flags/{name}",
+		adminAccess,
+		PutFlag,
+	},
+	route{
+		"DeleteFlag",
+		"DELETE",
+		"/flags/{name}",
+		adminAccess,
+		DeleteFlag,
+	},
+	route{
+		"PreviewEmailTemplate",
+		"POST",
+		"/email-templates/{kind}/{locale}/preview",
+		adminAccess,
+		PreviewEmailTemplate,
+	},
+	route{
+		"DeactivateStale",
+		"GET",
+		"/jobs/stale-activations",
+		adminAccess,
+		DeactivateStale,
+	},
+	route{
+		"TakeSnapshot",
+		"GET",
+		"/jobs/snapshot",
+		adminAccess,
+		TakeSnapshot,
+	},
+	route{
+		"RunJob",
+		"POST",
+		"/tasks/jobs",
+		adminAccess,
+		RunJob,
+	},
+	route{
+		"PublishToCDN",
+		"POST",
+		"/tasks/cdn",
+		adminAccess,
+		PublishToCDN,
+	},
+	route{
+		"SendExpiryReminders",
+		"GET",
+		"/jobs/expiry-reminders",
+		adminAccess,
+		SendExpiryReminders,
+	},
+	route{
+		"Reconcile",
+		"GET",
+		"/jobs/reconcile",
+		adminAccess,
+		Reconcile,
+	},
+	route{
+		"RevokeScheduled",
+		"GET",
+		"/jobs/scheduled-revocations",
+		adminAccess,
+		RevokeScheduled,
+	},
+	// after the cron jobs, whose paths would match {id}
+	route{
+		"ListJobs",
+		"GET",
+		"/jobs",
+		adminAccess,
+		ListJobs,
+	},
+	route{
+		"GetJob",
+		"GET",
+		"/jobs/{id}",
+		adminAccess,
+		GetJob,
+	},
+	route{
+		"CancelJob",
+		"POST",
+		"/jobs/{id}/cancel",
+		adminAccess,
+		CancelJob,
+	},
+	route{
+		"ListEvents",
+		"GET",
+		"/events",
+		adminAccess,
+		ListEvents,
+	},
+	route{
+		"RebuildFromEvents",
+		"POST",
+		"/events/rebuild",
+		adminAccess,
+		RebuildFromEvents,
+	},
+	route{
+		"SeedEvents",
+		"POST",
+		"/events/seed",
+		adminAccess,
+		SeedEvents,
+	},
+	route{
+		"ListSnapshots",
+		"GET",
+		"/snapshots",
+		adminAccess,
+		ListSnapshots,
+	},
+	route{
+		"RestoreSnapshot",
+		"POST",
+		"/snapshots/{id}/restore",
+		adminAccess,
+		RestoreSnapshot,
+	},
+	route{
+		"SandboxJWKS",
+		"GET",
+		"/sandbox/jwks.json",
+		publicAccess,
+		SandboxJWKS,
+	},
+	route{
+		"ListKeys",
+		"GET",
+		"/keys",
+		adminAccess,
+		ListKeys,
+	},
+	route{
+		"ResignLicenses",
+		"POST",
+		"/keys/{id}/resign",
+		adminAccess,
+		ResignLicenses,
+	},
+	route{
+		"MigrateRevocations",
+		"POST",
+		"/revocations/migrate",
+		adminAccess,
+		MigrateRevocations,
+	},
 	route{
 		"UpdateRevocationFile",
 		"GET",
 		"/update_revocation_file",
+		adminAccess,
 		UpdateRevocationFile,
 	},
 }
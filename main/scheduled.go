@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// scheduleRevocation schedules a stored license to be revoked at a time,
+// licenses that aren't stored can't be scheduled since there is nowhere to
+// keep the time.
+func scheduleRevocation(c context.Context, w http.ResponseWriter, namespace, id string, at time.Time) *appError {
+	licenses := env.Licenses(c, namespace)
+	lic, err := licenses.Get(c, id)
+
+	if err == store.ErrNotFound {
+		return &appError{err, "License not found, only stored licenses can be revoked later", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if lic.RevokedAt != nil {
+		return &appError{fmt.Errorf("license %v is revoked", id), "The license is already revoked", http.StatusConflict, codeLicenseRevoked}
+	}
+
+	at = at.UTC()
+	lic.RevokeAt = &at
+
+	if err := licenses.Put(c, lic); err != nil {
+		return &appError{err, "An error occurred scheduling the revocation", http.StatusInternalServerError, codeInternal}
+	}
+
+	err = audit(c, store.AuditEntry{
+		Action:   "license.schedule-revocation",
+		Target:   id,
+		Customer: lic.Email(),
+		Details:  map[string]string{"revokeAt": at.Format(time.RFC3339)},
+	})
+
+	if err != nil {
+		env.Errorf(c, "Could not record the scheduled revocation of %v in the audit log: %v", id, err)
+	}
+
+	writeJSON(w, 200, struct {
+		RevokeAt time.Time `json:"revokeAt"`
+	}{at})
+
+	return nil
+}
+
+// CancelRevocation handles DELETE requests to /api/licenses/{id}/revoke
+//
+// It cancels the scheduled revocation of a license, e.g. once the customer
+// has fixed their payment. Licenses that are already revoked stay revoked.
+// With ?sandbox=true the license is a test license.
+//
+// Example:
+//
+//	DELETE /api/licenses/daS7y8sioiecYy/revoke
+//	200 "SUCCESS"
+func CancelRevocation(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	id := mux.Vars(r)["id"]
+	namespace := ""
+
+	if r.URL.Query().Get("sandbox") == "true" {
+		namespace = store.SandboxNamespace
+	}
+
+	licenses := env.Licenses(c, namespace)
+	lic, err := licenses.Get(c, id)
+
+	if err == store.ErrNotFound {
+		return &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if lic.RevokeAt == nil {
+		return &appError{errors.New("no scheduled revocation"), "The license has no scheduled revocation", http.StatusNotFound, codeNotFound}
+	}
+
+	lic.RevokeAt = nil
+
+	if err := licenses.Put(c, lic); err != nil {
+		return &appError{err, "An error occurred cancelling the revocation", http.StatusInternalServerError, codeInternal}
+	}
+
+	err = audit(c, store.AuditEntry{Action: "license.cancel-revocation", Target: id, Customer: lic.Email()})
+
+	if err != nil {
+		env.Errorf(c, "Could not record the cancelled revocation of %v in the audit log: %v", id, err)
+	}
+
+	writeJSON(w, 200, "SUCCESS")
+	return nil
+}
+
+// RevokeScheduled handles GET requests to /api/jobs/scheduled-revocations
+//
+// It is run hourly by cron and revokes the licenses whose scheduled
+// revocation is due, adding them to the revocation store.
+//
+// Example:
+//
+//	GET /api/jobs/scheduled-revocations
+//	200 {"revoked": 2}
+func RevokeScheduled(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	var due []*license.License
+
+	// revoking changes the licenses being listed, so they are all found first
+	for _, namespace := range []string{"", store.SandboxNamespace} {
+		err := eachLicense(c, namespace, store.Query{RevokeDueBy: time.Now()}, func(lic *license.License) {
+			if lic.RevokedAt == nil {
+				due = append(due, lic)
+			}
+		})
+
+		if err != nil {
+			return &appError{err, "An error occurred listing the licenses due to be revoked", http.StatusInternalServerError, codeInternal}
+		}
+	}
+
+	revoked := 0
+
+	for _, lic := range due {
+		scheduled := lic.RevokeAt.Format(time.RFC3339)
+		rev := store.Revocation{ID: lic.ID, Comment: "scheduled for " + scheduled}
+
+		if err := revokeLicense(c, licenseNamespace(lic), rev, map[string]string{"scheduledFor": scheduled}); err != nil {
+			env.Errorf(c, "Could not revoke %v as scheduled: %v", lic.ID, err)
+			continue
+		}
+
+		notifyWebhooks(c, "license.revoked", map[string]string{"id": lic.ID})
+		notifySlack(c, "license.revoked", fmt.Sprintf("License %v was revoked as scheduled for %v", lic.ID, scheduled))
+		revoked++
+	}
+
+	writeJSON(w, 200, struct {
+		Revoked int `json:"revoked"`
+	}{revoked})
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/secrets"
+)
+
+var (
+	secretCacheOnce sync.Once
+	secretCache     *secrets.Cache
+)
+
+// getSecret returns the latest value of a secret from Secret Manager, values
+// are cached per instance for the configured TTL.
+func getSecret(c context.Context, name string) ([]byte, error) {
+	secretCacheOnce.Do(func() {
+		secretCache = secrets.NewCache(cfg.Secrets.CacheTTL)
+	})
+
+	project := cfg.Secrets.Project
+
+	if project == "" {
+		project = env.ProjectID(c)
+	}
+
+	if project == "" {
+		return nil, errors.New("no project is configured for Secret Manager")
+	}
+
+	client, err := env.GoogleClient(c, secrets.Scope)
+
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := secretCache.Get(c, secrets.NewSecretManager(client, project), name)
+
+	if se, ok := err.(*secrets.StaleError); ok {
+		env.Warningf(c, "%v", se)
+		err = nil
+	}
+
+	return value, err
+}
@@ -1,7 +1,37 @@
 package main
 
-import "net/http"
+import (
+	"net/http"
+	"os"
 
-func init() {
+	"github.com/volcanicpixels/licensing/config"
+	"github.com/volcanicpixels/licensing/platform"
+)
+
+var (
+	// cfg is the server's configuration, it is loaded on startup.
+	cfg *config.Config
+
+	// env is the platform the server is running on, it is set by
+	// appengine.go or standalone.go depending on the build.
+	env platform.Platform
+)
+
+// loadConfig loads the configuration from the file named by LICENSING_CONFIG
+// (config.yaml by default, it is optional) and the environment.
+func loadConfig() (*config.Config, error) {
+	path := os.Getenv("LICENSING_CONFIG")
+
+	if path == "" {
+		path = "config.yaml"
+	}
+
+	return config.Load(path)
+}
+
+// registerHandlers registers the API, it must be called once cfg and env
+// have been set.
+func registerHandlers() {
 	http.Handle("/api/", newAPIRouter())
+	http.Handle("/_ah/warmup", appHandler(Warmup))
 }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// sharingCounter names the counter of the distinct fingerprints or IPs (the
+// kind) a license checked in from in the window starting at start. With a
+// value it names the counter that marks that value as seen, which is capped
+// at one so that only the first check-in from it is counted.
+func sharingCounter(id, kind string, start time.Time, value string) string {
+	name := fmt.Sprintf("sharing:%v:%v:%v", id, kind, start.Unix())
+
+	if value == "" {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(value))
+	return name + ":" + hex.EncodeToString(sum[:16])
+}
+
+// countDistinct counts a value a license checked in from in a window, and
+// returns how many distinct values it has checked in from so far. Values
+// already seen in the window aren't counted again and return zero, since the
+// total can't have changed.
+func countDistinct(c context.Context, id, kind string, start time.Time, value string) (int, error) {
+	counters := env.Counters(c)
+	err := counters.IncrementCapped(c, sharingCounter(id, kind, start, value), 1)
+
+	if err == store.ErrCapReached {
+		return 0, nil
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	total := sharingCounter(id, kind, start, "")
+
+	if err := counters.Increment(c, total); err != nil {
+		return 0, err
+	}
+
+	return counters.Count(c, total)
+}
+
+// watchSharing counts the fingerprint (if any) and IP address a license a
+// verdict is for checked in from, and flags the license if it has checked in
+// from more of either than its product allows in the window. Errors are
+// logged since they mustn't fail the request. Sandbox licenses aren't
+// watched, and nothing is counted in maintenance mode.
+func watchSharing(c context.Context, r *http.Request, vd *verdict, fingerprint string) {
+	sharing := cfg.Products[vd.Product].Policy.Sharing
+
+	if !sharing.Enabled() || vd.Test || cfg.Maintenance.Enabled {
+		return
+	}
+
+	start := time.Now().Truncate(sharing.Period())
+	fingerprints, ips := 0, 0
+	var err error
+
+	if sharing.Fingerprints > 0 && fingerprint != "" {
+		if fingerprints, err = countDistinct(c, vd.ID, "fingerprint", start, fingerprint); err != nil {
+			env.Errorf(c, "Could not count the fingerprints of %v: %v", vd.ID, err)
+			return
+		}
+	}
+
+	if sharing.IPs > 0 {
+		if ips, err = countDistinct(c, vd.ID, "ip", start, clientAddress(r)); err != nil {
+			env.Errorf(c, "Could not count the IP addresses of %v: %v", vd.ID, err)
+			return
+		}
+	}
+
+	over := sharing.Fingerprints > 0 && fingerprints > sharing.Fingerprints || sharing.IPs > 0 && ips > sharing.IPs
+
+	if !over {
+		return
+	}
+
+	if err := flagSharing(c, vd.ID, start, fingerprints, ips); err != nil {
+		env.Errorf(c, "Could not flag %v as shared: %v", vd.ID, err)
+	}
+}
+
+// flagSharing records that a license was detected being shared, and
+// suspends it if its product says to. A license is only flagged once a
+// window, which started at start.
+func flagSharing(c context.Context, id string, start time.Time, fingerprints, ips int) error {
+	licenses := env.Licenses(c, "")
+	lic, err := licenses.Get(c, id)
+
+	// licenses that aren't stored can't be flagged
+	if err == store.ErrNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if lic.SharingDetectedAt != nil && !lic.SharingDetectedAt.Before(start) {
+		return nil
+	}
+
+	now := time.Now()
+	suspend := cfg.Products[lic.Product].Policy.Sharing.Suspend
+	lic.SharingDetectedAt = &now
+
+	if suspend && lic.SuspendedAt == nil {
+		lic.SuspendedAt = &now
+	}
+
+	if err := licenses.Put(c, lic); err != nil {
+		return err
+	}
+
+	details := map[string]string{
+		"fingerprints": strconv.Itoa(fingerprints),
+		"ips":          strconv.Itoa(ips),
+		"suspended":    strconv.FormatBool(lic.SuspendedAt != nil),
+	}
+
+	if err := audit(c, store.AuditEntry{Action: "license.sharing", Target: id, Customer: lic.Email(), Details: details}); err != nil {
+		env.Errorf(c, "Could not record the sharing of %v in the audit log: %v", id, err)
+	}
+
+	notifyWebhooks(c, "license.sharing", map[string]string{
+		"id":           id,
+		"fingerprints": details["fingerprints"],
+		"ips":          details["ips"],
+		"suspended":    details["suspended"],
+	})
+
+	text := fmt.Sprintf("%v license %v looks shared, it checked in from %v new installs and %v new IP addresses", lic.Product, id, fingerprints, ips)
+
+	if suspend {
+		text += " and was suspended"
+	}
+
+	notifySlack(c, "license.sharing", text)
+	return nil
+}
+
+// LiftSuspension handles DELETE requests to /api/licenses/{id}/suspension
+//
+// It lifts the suspension of a license that was suspended for being shared,
+// once the customer has been in touch. The license stays flagged, so it isn't
+// suspended again until it is detected being shared in a later window.
+//
+// Example:
+//
+//	DELETE /api/licenses/daS7y8sioiecYy/suspension
+//	200 {"id": "daS7y8sioiecYy", "sharingDetectedAt": "...", ...}
+func LiftSuspension(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	licenses := env.Licenses(c, requestNamespace(c))
+	lic, err := licenses.Get(c, mux.Vars(r)["id"])
+
+	if err == store.ErrNotFound {
+		return &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if lic.SuspendedAt != nil {
+		suspended := lic.SuspendedAt
+		lic.SuspendedAt = nil
+
+		if err := licenses.Put(c, lic); err != nil {
+			return &appError{err, "Could not store the license", http.StatusInternalServerError, codeInternal}
+		}
+
+		entry := store.AuditEntry{
+			Action:   "license.unsuspend",
+			Target:   lic.ID,
+			Customer: lic.Email(),
+			Details:  map[string]string{"suspendedAt": suspended.Format(time.RFC3339)},
+		}
+
+		if err := audit(c, entry); err != nil {
+			env.Errorf(c, "Could not record lifting the suspension of %v in the audit log: %v", lic.ID, err)
+		}
+	}
+
+	writeJSON(w, 200, lic)
+	return nil
+}
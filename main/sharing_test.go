@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/config"
+)
+
+func TestLiftSuspensionNeedsAdminKey(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	c := context.Background()
+	id := createLicense(t, s)
+	lic, err := p.LicenseStore.Get(c, id)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	lic.SharingDetectedAt, lic.SuspendedAt = &now, &now
+
+	if err := p.LicenseStore.Put(c, lic); err != nil {
+		t.Fatal(err)
+	}
+
+	useAPIKey(s, p, config.APIKey{ID: "plain"})
+	resp, err := s.Do("DELETE", "/api/licenses/"+id+"/suspension", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 403 {
+		t.Errorf("got %v %s with a plain API key, want a 403", resp.StatusCode, resp.Body)
+	}
+
+	if lic, err = p.LicenseStore.Get(c, id); err != nil || lic.SuspendedAt == nil {
+		t.Fatalf("the suspension was lifted with a plain API key: %v", err)
+	}
+
+	useAPIKey(s, p, config.APIKey{ID: "admin", Admin: true})
+
+	if resp, err = s.Do("DELETE", "/api/licenses/"+id+"/suspension", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 200 {
+		t.Fatalf("got %v %s with an admin API key, want a 200", resp.StatusCode, resp.Body)
+	}
+
+	if lic, err = p.LicenseStore.Get(c, id); err != nil || lic.SuspendedAt != nil || lic.SharingDetectedAt == nil {
+		t.Errorf("the suspension wasn't lifted, or the license unflagged: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// notifySlack posts a message about an event to Slack if the event is one of
+// the configured Slack events. Failures are logged since by the time we notify
+// the event has already happened.
+func notifySlack(c context.Context, event, text string) {
+	if !slackEvent(event) {
+		return
+	}
+
+	if err := postSlack(c, text); err != nil {
+		env.Errorf(c, "Could not post %v to Slack: %v", event, err)
+	}
+}
+
+// slackEvent reports whether an event is posted to Slack.
+func slackEvent(event string) bool {
+	for _, e := range cfg.Slack.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// postSlack posts a message to the configured Slack incoming webhook.
+func postSlack(c context.Context, text string) error {
+	if cfg.Slack.WebhookSecret == "" {
+		return errors.New("no Slack webhook is configured")
+	}
+
+	url, err := getSecret(c, cfg.Slack.WebhookSecret)
+
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(struct {
+		Text string `json:"text"`
+	}{text})
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := env.HTTPClient(c).Post(strings.TrimSpace(string(url)), "application/json", bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack responded with %v", resp.Status)
+	}
+
+	return nil
+}
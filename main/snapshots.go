@@ -0,0 +1,613 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/storage"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// Kinds of the jobs that take and restore snapshots.
+const (
+	jobSnapshot = "snapshot"
+	jobRestore  = "restore"
+)
+
+// snapshotDir is where snapshots are written, each in a directory named by
+// its ID, and snapshotIndex lists the complete ones.
+const (
+	snapshotDir   = "snapshots/"
+	snapshotIndex = snapshotDir + "index.json"
+)
+
+// snapshotIDFormat is the time a snapshot was taken, which is its ID.
+const snapshotIDFormat = "20060102T150405Z"
+
+// snapshotPageSize is how many licenses are in each file of a snapshot.
+const snapshotPageSize = 500
+
+// snapshotManifest describes a complete snapshot, it is written last so
+// that a snapshot without one is never restored.
+type snapshotManifest struct {
+	ID          string    `json:"id"`
+	TakenAt     time.Time `json:"takenAt"`
+	Pages       int       `json:"pages"` // files of licenses, see snapshotPageFile
+	Licenses    int       `json:"licenses"`
+	Revocations int       `json:"revocations"`
+	Keys        []string  `json:"keys"`
+
+	// Encrypted is whether the pages are encrypted with the PII keys.
+	Encrypted bool `json:"encrypted,omitempty"`
+}
+
+// snapshotPage is a page of the licenses of a namespace in a snapshot.
+type snapshotPage struct {
+	Namespace string             `json:"namespace"`
+	Licenses  []*license.License `json:"licenses"`
+}
+
+// snapshotStorage opens where snapshots are written, snapshots.location or
+// else storage.
+func snapshotStorage(c context.Context) (storage.Storage, error) {
+	if cfg.Snapshots.Location == "" {
+		return newStorage(c)
+	}
+
+	return storage.Open(c, cfg.Storage.Backend, cfg.Snapshots.Location)
+}
+
+// snapshotFile names a file of a snapshot.
+func snapshotFile(id, fileName string) string {
+	return snapshotDir + id + "/" + fileName
+}
+
+// writeSnapshotFile writes a file of a snapshot as JSON, snapshots are never
+// made public.
+func writeSnapshotFile(sc storage.Storage, id, fileName string, v interface{}) error {
+	data, err := json.Marshal(v)
+
+	if err != nil {
+		return err
+	}
+
+	return sc.WriteFile(snapshotFile(id, fileName), data)
+}
+
+// readSnapshotFile reads a file of a snapshot written by writeSnapshotFile.
+func readSnapshotFile(sc storage.Storage, id, fileName string, v interface{}) error {
+	data, err := sc.ReadFile(snapshotFile(id, fileName))
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// snapshotPageFile names the nth page of licenses, licenses-<n>.json or, if
+// it is encrypted, licenses-<n>.json.enc.
+func snapshotPageFile(n int, encrypted bool) string {
+	fileName := "licenses-" + strconv.Itoa(n) + ".json"
+
+	if encrypted {
+		fileName += ".enc"
+	}
+
+	return fileName
+}
+
+// writeSnapshotPage writes the nth page of licenses. Encrypted pages are
+// encrypted whole with the PII keys like the personal attributes in the
+// datastore, so that a snapshot that leaks doesn't expose the customers.
+func writeSnapshotPage(c context.Context, sc storage.Storage, id string, n int, page snapshotPage, encrypted bool) error {
+	if !encrypted {
+		return writeSnapshotFile(sc, id, snapshotPageFile(n, false), page)
+	}
+
+	pc, err := env.PII(c)
+
+	if err == nil && pc == nil {
+		err = errors.New("the PII keys are no longer configured")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(page)
+
+	if err != nil {
+		return err
+	}
+
+	if data, err = pc.Encrypt(string(data)); err != nil {
+		return err
+	}
+
+	return sc.WriteFile(snapshotFile(id, snapshotPageFile(n, true)), data)
+}
+
+// readSnapshotPage reads the nth page of licenses of a snapshot.
+func readSnapshotPage(c context.Context, sc storage.Storage, manifest *snapshotManifest, n int) (*snapshotPage, error) {
+	var page snapshotPage
+
+	if !manifest.Encrypted {
+		return &page, readSnapshotFile(sc, manifest.ID, snapshotPageFile(n, false), &page)
+	}
+
+	pc, err := env.PII(c)
+
+	if err == nil && pc == nil {
+		err = errors.New("the snapshot is encrypted but the PII keys aren't configured")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := sc.ReadFile(snapshotFile(manifest.ID, snapshotPageFile(n, true)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := pc.Decrypt(data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &page, json.Unmarshal([]byte(plain), &page)
+}
+
+// readSnapshotIndex reads the manifests of the complete snapshots, oldest
+// first.
+func readSnapshotIndex(sc storage.Storage) ([]snapshotManifest, error) {
+	data, err := sc.ReadFile(snapshotIndex)
+
+	if err == storage.ErrNotExist {
+		return []snapshotManifest{}, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var index []snapshotManifest
+	return index, json.Unmarshal(data, &index)
+}
+
+// TakeSnapshot handles GET requests to /api/jobs/snapshot
+//
+// It is run daily by cron and starts a job that writes the licenses, in
+// production and the sandbox, the revocations and the public keys to a new
+// snapshot in snapshots.location. If personal data is encrypted (see
+// config.PII) the licenses are too.
+//
+// Example:
+//
+//	GET /api/jobs/snapshot
+//	202 {"id": "p3Bd9sKe0aQvT1xZ", "kind": "snapshot", "status": "running", "params": {"snapshot": "20261014T030000Z"}, ...}
+func TakeSnapshot(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	id := time.Now().UTC().Format(snapshotIDFormat)
+	params := map[string]string{"snapshot": id}
+	pc, err := env.PII(c)
+
+	if err != nil {
+		return &appError{err, "Could not load the keys personal data is encrypted with", http.StatusInternalServerError, codeKeyUnavailable}
+	}
+
+	if pc != nil {
+		params["encrypted"] = "true"
+	}
+
+	job, err := startJob(c, jobSnapshot, params)
+
+	if err != nil {
+		return &appError{err, "Could not start the job", http.StatusInternalServerError, codeInternal}
+	}
+
+	writeJSON(w, http.StatusAccepted, job)
+	return nil
+}
+
+// runSnapshotBatch writes a page of licenses to a snapshot, first those in
+// production and then those in the sandbox, and lastly the revocations, the
+// public keys and the manifest. Its cursor is the namespace, the number of
+// pages written and the cursor within the namespace.
+func runSnapshotBatch(c context.Context, job *store.Job) (string, error) {
+	sc, err := snapshotStorage(c)
+
+	if err != nil {
+		return "", err
+	}
+
+	id, encrypted := job.Params["snapshot"], job.Params["encrypted"] == "true"
+	phase, pages, cursor := "licenses", 0, ""
+
+	if job.Cursor != "" {
+		parts := strings.SplitN(job.Cursor, ":", 3)
+
+		if len(parts) != 3 {
+			return "", &jobError{fmt.Errorf("invalid cursor %q", job.Cursor)}
+		}
+
+		phase, cursor = parts[0], parts[2]
+		pages, _ = strconv.Atoi(parts[1])
+	}
+
+	if phase == "licenses" || phase == "sandbox" {
+		namespace := ""
+
+		if phase == "sandbox" {
+			namespace = store.SandboxNamespace
+		}
+
+		page, next, err := env.Licenses(c, namespace).List(c, store.Query{Limit: snapshotPageSize, Cursor: cursor})
+
+		if err != nil {
+			return "", err
+		}
+
+		if len(page) > 0 {
+			if err := writeSnapshotPage(c, sc, id, pages, snapshotPage{namespace, page}, encrypted); err != nil {
+				return "", err
+			}
+
+			pages++
+			job.Processed += len(page)
+		}
+
+		switch {
+		case next != "":
+		case phase == "licenses":
+			phase = "sandbox"
+		default:
+			phase = "finish"
+		}
+
+		return fmt.Sprintf("%v:%v:%v", phase, pages, next), nil
+	}
+
+	revocations, err := listRevocations(c)
+
+	if err != nil {
+		return "", err
+	}
+
+	if revocations == nil {
+		revocations = []store.Revocation{}
+	}
+
+	if err := writeSnapshotFile(sc, id, "revocations.json", revocations); err != nil {
+		return "", err
+	}
+
+	kids := publicKeyIDs()
+
+	for _, kid := range kids {
+		for _, fileName := range []string{"public.pem", "certificate.jwt"} {
+			data, err := getKey(c, kid, fileName)
+
+			// only intermediate keys have certificates
+			if err == storage.ErrNotExist && fileName == "certificate.jwt" {
+				continue
+			}
+
+			if err != nil {
+				return "", err
+			}
+
+			if err := sc.WriteFile(snapshotFile(id, "keys/"+kid+"/"+fileName), data); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	takenAt, _ := time.Parse(snapshotIDFormat, id)
+	manifest := snapshotManifest{id, takenAt, pages, job.Processed, len(revocations), kids, encrypted}
+
+	if err := writeSnapshotFile(sc, id, "manifest.json", manifest); err != nil {
+		return "", err
+	}
+
+	index, err := readSnapshotIndex(sc)
+
+	if err != nil {
+		return "", err
+	}
+
+	// a retried batch mustn't list the snapshot twice
+	for _, m := range index {
+		if m.ID == id {
+			job.Result = snapshotFile(id, "")
+			return "", nil
+		}
+	}
+
+	data, err := json.Marshal(append(index, manifest))
+
+	if err != nil {
+		return "", err
+	}
+
+	if err := sc.WriteFile(snapshotIndex, data); err != nil {
+		return "", err
+	}
+
+	job.Result = snapshotFile(id, "")
+	return "", nil
+}
+
+// ListSnapshots handles GET requests to /api/snapshots
+//
+// It lists the complete snapshots newest first.
+//
+// Example:
+//
+//	GET /api/snapshots
+//	200 {"snapshots": [{"id": "20261014T030000Z", "takenAt": "...", "pages": 3, "licenses": 1204, "revocations": 212, "keys": ["plugin"]}, ...]}
+func ListSnapshots(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	sc, err := snapshotStorage(c)
+
+	if err != nil {
+		return &appError{err, "Could not open the snapshots' storage", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	index, err := readSnapshotIndex(sc)
+
+	if err != nil {
+		return &appError{err, "An error occurred reading the snapshots", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	for i, j := 0, len(index)-1; i < j; i, j = i+1, j-1 {
+		index[i], index[j] = index[j], index[i]
+	}
+
+	writeJSON(w, 200, struct {
+		Snapshots []snapshotManifest `json:"snapshots"`
+	}{index})
+
+	return nil
+}
+
+// readManifest reads the manifest of a complete snapshot, storage.ErrNotExist
+// if there is no such snapshot or it is incomplete.
+func readManifest(sc storage.Storage, id string) (*snapshotManifest, error) {
+	// the ID is part of a path, so it must be a time
+	if _, err := time.Parse(snapshotIDFormat, id); err != nil {
+		return nil, storage.ErrNotExist
+	}
+
+	var manifest snapshotManifest
+
+	if err := readSnapshotFile(sc, id, "manifest.json", &manifest); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// RestoreSnapshot handles POST requests to /api/snapshots/{id}/restore
+//
+// It starts a job that rolls the revocations and licenses back to a
+// snapshot: revocations made since are removed, those removed since are made
+// again and the licenses are stored as they were, except that the personal
+// data of customers forgotten since isn't brought back. Licenses issued
+// since are left alone. Public keys missing from storage are written back, keys are
+// never overwritten. Every change is in the event log, and the revocation
+// list is published with the revocations on its next update.
+//
+// Example:
+//
+//	POST /api/snapshots/20261014T030000Z/restore
+//	202 {"id": "p3Bd9sKe0aQvT1xZ", "kind": "restore", "status": "running", "params": {"snapshot": "20261014T030000Z"}, ...}
+func RestoreSnapshot(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	id := mux.Vars(r)["id"]
+	sc, err := snapshotStorage(c)
+
+	if err != nil {
+		return &appError{err, "Could not open the snapshots' storage", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	if _, err := readManifest(sc, id); err == storage.ErrNotExist {
+		return &appError{err, "Snapshot not found", http.StatusNotFound, codeSnapshotNotFound}
+	} else if err != nil {
+		return &appError{err, "An error occurred reading the snapshot", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	job, err := startJob(c, jobRestore, map[string]string{"snapshot": id})
+
+	if err != nil {
+		return &appError{err, "Could not start the job", http.StatusInternalServerError, codeInternal}
+	}
+
+	if err := audit(c, store.AuditEntry{Action: "snapshot.restore", Target: id, Details: map[string]string{"job": job.ID}}); err != nil {
+		env.Errorf(c, "Could not record restoring snapshot %v in the audit log: %v", id, err)
+	}
+
+	writeJSON(w, http.StatusAccepted, job)
+	return nil
+}
+
+// runRestoreBatch restores the revocations and public keys of a snapshot in
+// its first batch and then a page of licenses in each. Its cursor is the
+// page to restore next.
+func runRestoreBatch(c context.Context, job *store.Job) (string, error) {
+	sc, err := snapshotStorage(c)
+
+	if err != nil {
+		return "", err
+	}
+
+	id := job.Params["snapshot"]
+	manifest, err := readManifest(sc, id)
+
+	if err == storage.ErrNotExist {
+		return "", &jobError{errors.New("snapshot " + id + " not found")}
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if job.Cursor == "" {
+		if err := restoreRevocations(c, sc, job); err != nil {
+			return "", err
+		}
+
+		if err := restoreKeys(c, sc, manifest); err != nil {
+			return "", err
+		}
+
+		if manifest.Pages == 0 {
+			return "", nil
+		}
+
+		return "0", nil
+	}
+
+	n, err := strconv.Atoi(job.Cursor)
+
+	if err != nil {
+		return "", &jobError{fmt.Errorf("invalid cursor %q", job.Cursor)}
+	}
+
+	page, err := readSnapshotPage(c, sc, manifest, n)
+
+	if err != nil {
+		return "", err
+	}
+
+	licenses := env.Licenses(c, page.Namespace)
+
+	for _, lic := range page.Licenses {
+		// customers forgotten since the snapshot stay forgotten
+		if current, err := licenses.Get(c, lic.ID); err == nil && current.Email() == "" && lic.Email() != "" {
+			forgetLicense(lic)
+		}
+
+		if err := licenses.Put(c, lic); err != nil {
+			env.Errorf(c, "Could not restore %v: %v", lic.ID, err)
+			job.Failed++
+			continue
+		}
+
+		job.Processed++
+	}
+
+	if n+1 == manifest.Pages {
+		return "", nil
+	}
+
+	return strconv.Itoa(n + 1), nil
+}
+
+// restoreRevocations makes the revocation store match a snapshot's
+// revocations, counting the revocations it removes and makes.
+func restoreRevocations(c context.Context, sc storage.Storage, job *store.Job) error {
+	var snapshot []store.Revocation
+
+	if err := readSnapshotFile(sc, job.Params["snapshot"], "revocations.json", &snapshot); err != nil {
+		return err
+	}
+
+	revocations, err := env.Revocations(c)
+
+	if err != nil {
+		return err
+	}
+
+	current, err := revocations.List(c)
+
+	if err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool, len(snapshot))
+
+	for _, rev := range snapshot {
+		keep[rev.ID] = true
+	}
+
+	for _, rev := range current {
+		if keep[rev.ID] {
+			delete(keep, rev.ID)
+			continue
+		}
+
+		if err := revocations.Unrevoke(c, rev.ID); err != nil {
+			return err
+		}
+
+		job.Processed++
+	}
+
+	// what is left in keep was revoked in the snapshot but isn't now
+	for _, rev := range snapshot {
+		if !keep[rev.ID] {
+			continue
+		}
+
+		if err := revocations.Revoke(c, rev); err != nil {
+			return err
+		}
+
+		job.Processed++
+	}
+
+	return nil
+}
+
+// restoreKeys writes the public keys and certificates of a snapshot that
+// are missing from storage back to it. Keys from Secret Manager can't be
+// written back, it keeps their versions itself.
+func restoreKeys(c context.Context, sc storage.Storage, manifest *snapshotManifest) error {
+	if cfg.Keys.Source != "storage" {
+		return nil
+	}
+
+	keys, err := newStorage(c)
+
+	if err != nil {
+		return err
+	}
+
+	for _, kid := range manifest.Keys {
+		for _, fileName := range []string{"public.pem", "certificate.jwt"} {
+			path := "keys/" + kid + "/" + fileName
+
+			if _, err := keys.ReadFile(path); err == nil {
+				continue
+			} else if err != storage.ErrNotExist {
+				return err
+			}
+
+			data, err := sc.ReadFile(snapshotFile(manifest.ID, path))
+
+			if err == storage.ErrNotExist {
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+
+			if err := keys.WriteFile(path, data); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
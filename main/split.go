@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// splitLicense is a license issued by splitting a bundle license.
+type splitLicense struct {
+	ID      string `json:"id"`
+	Product string `json:"product"`
+	License string `json:"license"`
+}
+
+// SplitLicense handles POST requests to /api/licenses/{id}/split
+//
+// It converts a bundle license into a license for each of the bundle's
+// products, e.g. when a customer transfers one of the plugins to a colleague.
+// The new licenses keep the bundle's expiry and not before time, its
+// entitlements for their product (see license.License.EntitlementsFor), its
+// activation limit, seats and named users, regions, versions and attributes,
+// with splitFrom set to its ID. They aren't activated. The bundle license is
+// then revoked, so its string stops working. If a license can't be issued
+// the bundle is left as it is, and licenses issued before it are logged.
+//
+// Example:
+//
+//	POST /api/licenses/Kq2vX9wLm3RtYb0c/split
+//	200 [{"id": "ZpJm3dQ1sTbc0aXe", "product": "domain_changer", "license": "eyJhbGciOiJSUzI1NiIs..."}, ...]
+//
+//	400 The license isn't a bundle license
+func SplitLicense(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	bundle, err := env.Licenses(c, requestNamespace(c)).Get(c, mux.Vars(r)["id"])
+
+	if err == store.ErrNotFound {
+		return &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if bundle.RevokedAt != nil {
+		return &appError{errors.New("license revoked"), "Revoked licenses can't be split", http.StatusConflict, codeLicenseRevoked}
+	}
+
+	if len(bundle.Products) == 0 {
+		return &appError{fmt.Errorf("license %v isn't a bundle", bundle.ID), "The license isn't a bundle license", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	var users []store.User
+
+	if bundle.Seats > 0 {
+		if users, err = env.Users(c, requestNamespace(c)).List(c, bundle.ID); err != nil {
+			return &appError{err, "Could not list the license's named users", http.StatusInternalServerError, codeInternal}
+		}
+	}
+
+	var split []splitLicense
+	var ids []string
+
+	for _, product := range bundle.Products {
+		maxActivations, seats := bundle.MaxActivations, bundle.Seats
+		create := &createRequest{
+			Product:        product,
+			MaxActivations: &maxActivations,
+			Seats:          &seats,
+			Regions:        bundle.Regions,
+			MinVersion:     bundle.MinVersion,
+			MaxVersion:     bundle.MaxVersion,
+			Attrs:          make(map[string]interface{}, len(bundle.Attrs)+1),
+
+			// the bundle was sold already
+			IgnoreCap: true,
+			splitFrom: bundle,
+		}
+
+		// the chargeId is kept, refunding the bundle revokes its parts
+		for k, v := range bundle.Attrs {
+			create.Attrs[k] = v
+		}
+
+		create.Attrs["splitFrom"] = bundle.ID
+
+		lic, licStr, _, e := issueLicense(c, create, bundle.Reseller)
+
+		if e != nil {
+			if len(ids) > 0 {
+				env.Errorf(c, "Splitting %v failed at %v, it wasn't revoked but %v were issued", bundle.ID, product, strings.Join(ids, ", "))
+			}
+
+			return e
+		}
+
+		for _, u := range users {
+			u.LicenseID = lic.ID
+
+			if err := env.Users(c, licenseNamespace(lic)).Add(c, u, 0); err != nil {
+				env.Errorf(c, "Could not copy user %v of %v to %v: %v", u.Email, bundle.ID, lic.ID, err)
+			}
+		}
+
+		split = append(split, splitLicense{lic.ID, product, licStr})
+		ids = append(ids, lic.ID)
+	}
+
+	rev := store.Revocation{ID: bundle.ID, Comment: "split into " + strings.Join(ids, ", ")}
+
+	if err := revokeLicense(c, licenseNamespace(bundle), rev, map[string]string{"reason": "split", "licenses": strings.Join(ids, ",")}); err != nil {
+		return &appError{err, "The licenses were issued but the bundle license could not be revoked", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	notifyWebhooks(c, "license.revoked", map[string]string{"id": bundle.ID, "reason": "split"})
+
+	entry := store.AuditEntry{
+		Action:   "license.split",
+		Target:   bundle.ID,
+		Customer: bundle.Email(),
+		Details:  map[string]string{"licenses": strings.Join(ids, ",")},
+	}
+
+	if err := audit(c, entry); err != nil {
+		env.Errorf(c, "Could not record the split of %v in the audit log: %v", bundle.ID, err)
+	}
+
+	writeJSON(w, 200, split)
+	return nil
+}
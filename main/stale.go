@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// stalePageSize is how many activations are read at a time when looking for
+// stale ones.
+const stalePageSize = 500
+
+// isStale reports whether an activation of a license hasn't been seen for
+// longer than its product's policy allows.
+func isStale(lic *license.License, a store.Activation, now time.Time) bool {
+	after := cfg.Products[lic.Product].Policy.DeactivateAfter
+	return after > 0 && a.LastSeenAt.Before(now.Add(-after))
+}
+
+// DeactivateStale handles GET requests to /api/jobs/stale-activations
+//
+// It is run daily by cron and deactivates the activations of production
+// licenses that haven't been seen for longer than their product's
+// deactivate_after. Each customer is emailed about the freed activation and
+// an activation.deactivated webhook is posted, so that sites that were
+// deleted don't need support to free their activations.
+//
+// Example:
+//
+//	GET /api/jobs/stale-activations
+//	200 {"deactivated": 4}
+func DeactivateStale(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	now := time.Now()
+	var shortest time.Duration
+
+	for _, p := range cfg.Products {
+		if after := p.Policy.DeactivateAfter; after > 0 && (shortest == 0 || after < shortest) {
+			shortest = after
+		}
+	}
+
+	type staleActivation struct {
+		lic *license.License
+		a   store.Activation
+	}
+
+	var stale []staleActivation
+	activations := env.Activations(c, "")
+	licenses := make(map[string]*license.License)
+
+	// deactivating changes the activations being listed, so they are all
+	// found first
+	for cursor := ""; shortest > 0; {
+		page, next, err := activations.Stale(c, now.Add(-shortest), cursor, stalePageSize)
+
+		if err != nil {
+			return &appError{err, "An error occurred listing the stale activations", http.StatusInternalServerError, codeInternal}
+		}
+
+		for _, a := range page {
+			lic, ok := licenses[a.LicenseID]
+
+			if !ok {
+				if lic, err = env.Licenses(c, "").Get(c, a.LicenseID); err != nil && err != store.ErrNotFound {
+					return &appError{err, "Could not load license " + a.LicenseID, http.StatusInternalServerError, codeInternal}
+				}
+
+				licenses[a.LicenseID] = lic
+			}
+
+			if lic != nil && isStale(lic, a, now) {
+				stale = append(stale, staleActivation{lic, a})
+			}
+		}
+
+		if next == "" {
+			break
+		}
+
+		cursor = next
+	}
+
+	for _, s := range stale {
+		if err := activations.Deactivate(c, s.a.LicenseID, s.a.Fingerprint); err != nil {
+			return &appError{err, "An error occurred deactivating license " + s.a.LicenseID, http.StatusInternalServerError, codeInternal}
+		}
+
+		lastSeen := s.a.LastSeenAt.UTC().Format("2006-01-02")
+
+		if err := mailCustomer(c, emailDeactivated, s.lic, emailData{Site: s.a.Site, LastSeen: lastSeen}); err != nil {
+			env.Errorf(c, "Could not email the deactivation of %v of %v: %v", s.a.Fingerprint, s.lic.ID, err)
+		}
+
+		notifyWebhooks(c, "activation.deactivated", map[string]string{
+			"id":          s.lic.ID,
+			"fingerprint": s.a.Fingerprint,
+			"site":        s.a.Site,
+			"lastSeenAt":  s.a.LastSeenAt.UTC().Format(time.RFC3339),
+		})
+
+		entry := store.AuditEntry{
+			Action:   "activation.deactivate-stale",
+			Target:   s.lic.ID,
+			Customer: s.lic.Email(),
+			Details:  map[string]string{"fingerprint": s.a.Fingerprint, "lastSeenAt": s.a.LastSeenAt.UTC().Format(time.RFC3339)},
+		}
+
+		if err := audit(c, entry); err != nil {
+			env.Errorf(c, "Could not record the deactivation of %v of %v in the audit log: %v", s.a.Fingerprint, s.lic.ID, err)
+		}
+	}
+
+	writeJSON(w, 200, struct {
+		Deactivated int `json:"deactivated"`
+	}{len(stale)})
+
+	return nil
+}
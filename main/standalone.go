@@ -0,0 +1,47 @@
+//go:build !appengine
+// +build !appengine
+
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/volcanicpixels/licensing/platform"
+)
+
+// main runs the server as a plain net/http binary for local development or
+// hosts such as Cloud Run. Files are kept on the filesystem under
+// STORAGE_LOCATION (./data by default) and logs are written to stdout.
+//
+// There is no equivalent of the app.yaml admin login here, so only API keys
+// have access unless LOCAL_ADMIN=true makes every request an admin's, which
+// must not be exposed publicly.
+func main() {
+	var err error
+
+	if cfg, err = loadConfig(); err != nil {
+		log.Fatal(err)
+	}
+
+	if env, err = platform.NewLocal(cfg, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+
+	registerHandlers()
+
+	http.Handle("/static/", http.FileServer(http.Dir(".")))
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "static/index.html")
+	})
+
+	port := os.Getenv("PORT")
+
+	if port == "" {
+		port = "8080"
+	}
+
+	log.Printf("Listening on port %v", port)
+	log.Fatal(http.ListenAndServe(":"+port, nil))
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// statusHours is how many hours the validation success rate is over.
+const statusHours = 24
+
+// statusRefresh is how often an instance works out its status again, since
+// status pages poll it.
+const statusRefresh = time.Minute
+
+// healthyValidationRate is the validation success rate below which the
+// service is degraded.
+const healthyValidationRate = 0.99
+
+// validationRoutes are the routes counted towards the validation success
+// rate.
+var validationRoutes = map[string]bool{
+	"ValidateLicense":       true,
+	"ValidateLicenseBatch":  true,
+	"ValidateLicenseCanary": true,
+}
+
+// healthCounter names the counter of validation requests in the hour of t,
+// or of those that failed with a server error if failed is set.
+func healthCounter(failed bool, t time.Time) string {
+	kind := "validations"
+
+	if failed {
+		kind = "validation-errors"
+	}
+
+	return fmt.Sprintf("health:%v:%v", kind, t.UTC().Format("2006-01-02T15"))
+}
+
+// countValidation counts a request to a validation route that was answered
+// with status. Errors are logged since counting is only for the status page,
+// and nothing is counted in maintenance mode.
+func countValidation(c context.Context, route string, status int) {
+	if !validationRoutes[route] || cfg.Maintenance.Enabled {
+		return
+	}
+
+	now := time.Now()
+	names := []string{healthCounter(false, now)}
+
+	if status >= 500 {
+		names = append(names, healthCounter(true, now))
+	}
+
+	for _, name := range names {
+		if err := env.Counters(c).Increment(c, name); err != nil {
+			env.Errorf(c, "Could not count a validation: %v", err)
+			return
+		}
+	}
+}
+
+// serviceStatus is the health of the service, for customer-facing status
+// pages, so it says nothing about customers or their licenses.
+type serviceStatus struct {
+	// Status is operational, degraded (validations failing or a
+	// kill-switch set) or maintenance.
+	Status string `json:"status"`
+
+	// ValidationSuccessRate is the share of validation requests in the last
+	// statusHours hours that didn't fail with a server error, nil if there
+	// weren't any.
+	ValidationSuccessRate *float64 `json:"validationSuccessRate"`
+
+	Incidents struct {
+		Maintenance bool `json:"maintenance"`
+
+		// KillSwitches are the modes of the products whose kill-switch
+		// is set.
+		KillSwitches map[string]string `json:"killSwitches"`
+	} `json:"incidents"`
+
+	// Revocations is the version of the revocation list, which is the
+	// number of revocations in the transparency log, and when the last one
+	// was logged.
+	Revocations struct {
+		Version   int        `json:"version"`
+		UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	} `json:"revocations"`
+
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// statusCache keeps an instance's status so that polling it doesn't read
+// every counter and the transparency log each time.
+type statusCache struct {
+	mu      sync.Mutex
+	status  *serviceStatus
+	fetched time.Time
+}
+
+var currentStatus = &statusCache{}
+
+// get returns the status, working it out again once it is statusRefresh
+// old.
+func (sc *statusCache) get(c context.Context) (*serviceStatus, *appError) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.status != nil && time.Since(sc.fetched) < statusRefresh {
+		return sc.status, nil
+	}
+
+	status, e := checkStatus(c, time.Now())
+
+	if e != nil {
+		return nil, e
+	}
+
+	sc.status, sc.fetched = status, time.Now()
+	return status, nil
+}
+
+// checkStatus works out the status of the service at now.
+func checkStatus(c context.Context, now time.Time) (*serviceStatus, *appError) {
+	status := &serviceStatus{Status: "operational", CheckedAt: now}
+	status.Incidents.Maintenance = cfg.Maintenance.Enabled
+	status.Incidents.KillSwitches = make(map[string]string)
+
+	for product := range cfg.Products {
+		if ks := killSwitches.get(c, product); ks != nil {
+			status.Incidents.KillSwitches[product] = ks.Mode
+		}
+	}
+
+	total, failed := 0, 0
+	hour := now.Truncate(time.Hour)
+
+	for i := 0; i < statusHours; i++ {
+		t := hour.Add(-time.Duration(i) * time.Hour)
+		n, err := env.Counters(c).Count(c, healthCounter(false, t))
+
+		if err != nil {
+			return nil, &appError{err, "An error occurred counting validations", http.StatusInternalServerError, codeInternal}
+		}
+
+		f, err := env.Counters(c).Count(c, healthCounter(true, t))
+
+		if err != nil {
+			return nil, &appError{err, "An error occurred counting validations", http.StatusInternalServerError, codeInternal}
+		}
+
+		total, failed = total+n, failed+f
+	}
+
+	if total > 0 {
+		rate := float64(total-failed) / float64(total)
+		status.ValidationSuccessRate = &rate
+	}
+
+	entries, _, e := readLog(c)
+
+	if e != nil {
+		return nil, e
+	}
+
+	status.Revocations.Version = len(entries)
+
+	if len(entries) > 0 {
+		updatedAt := time.Unix(entries[len(entries)-1].Timestamp, 0).UTC()
+		status.Revocations.UpdatedAt = &updatedAt
+	}
+
+	switch {
+	case status.Incidents.Maintenance:
+		status.Status = "maintenance"
+	case len(status.Incidents.KillSwitches) > 0:
+		status.Status = "degraded"
+	case status.ValidationSuccessRate != nil && *status.ValidationSuccessRate < healthyValidationRate:
+		status.Status = "degraded"
+	}
+
+	return status, nil
+}
+
+// ServiceStatus handles GET requests to /api/status
+//
+// It returns the health of the service for status pages: the share of
+// validations in the last day that didn't fail with a server error, whether
+// it is in maintenance mode or any product's kill-switch is set, and the
+// version of the revocation list. It is refreshed every minute.
+//
+// Example:
+//
+//	GET /api/status
+//	200 {"status": "operational", "validationSuccessRate": 0.9998, "incidents": {"maintenance": false, "killSwitches": {}}, "revocations": {"version": 214, "updatedAt": "..."}, ...}
+func ServiceStatus(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	status, e := currentStatus.get(c)
+
+	if e != nil {
+		return e
+	}
+
+	writeJSON(w, 200, status)
+	return nil
+}
@@ -1,137 +1,45 @@
 package main
 
 import (
-	"io/ioutil"
-	"mime"
 	"net/http"
-	"path/filepath"
-	"strings"
+	"time"
 
 	"golang.org/x/net/context"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/file"
-	"google.golang.org/appengine/log"
-	"google.golang.org/appengine/urlfetch"
-	"google.golang.org/cloud"
-	"google.golang.org/cloud/storage"
+	"github.com/volcanicpixels/licensing/storage"
 )
 
-type StorageContext interface {
-	ReadFile(fileName string) ([]byte, error)
-	WriteFile(fileName string, data []byte) error
-	MakePublic(fileName string) error
+// newStorage returns the file storage of the platform the server is running
+// on.
+func newStorage(c context.Context) (storage.Storage, error) {
+	return env.Storage(c)
 }
 
-type storageContext struct {
-	c      context.Context
-	ctx    context.Context
-	bucket string
-}
+// signedFileURL returns a signed URL of a stored file that works for
+// signed_urls, or an empty string if signed_urls is off or the storage can't
+// sign URLs.
+func signedFileURL(sc storage.Storage, fileName, downloadName string) (string, error) {
+	signer, ok := sc.(storage.URLSigner)
 
-func NewStorageContext(c context.Context) StorageContext {
-	hc := &http.Client{
-		Transport: &oauth2.Transport{
-			Source: google.AppEngineTokenSource(c, storage.ScopeFullControl),
-			Base:   &urlfetch.Transport{Context: c},
-		},
+	if !ok || cfg.Storage.SignedURLs == 0 {
+		return "", nil
 	}
 
-	ctx := cloud.NewContext(appengine.AppID(c), hc)
-
-	return &storageContext{
-		c:   c,
-		ctx: ctx,
-	}
+	return signer.SignedURL(fileName, downloadName, time.Now().Add(cfg.Storage.SignedURLs))
 }
 
-func (sc *storageContext) Bucket() (string, error) {
-
-	// The dev app server does not support getting the bucket name
-	// IsDevAppServer has rather unhelpfully not been implemented
-	if strings.HasPrefix(appengine.ServerSoftware(), "Development") {
-		return "vp-licensing.appspot.com", nil
-	}
-
-	if sc.bucket == "" {
-		var err error
+// redirectToFile redirects the request to a signed URL of a stored file, so
+// that it is downloaded from storage rather than through the instance. It
+// returns false, having done nothing, if signed_urls is off or the storage
+// can't sign URLs, in which case the caller sends the file itself.
+func redirectToFile(w http.ResponseWriter, r *http.Request, sc storage.Storage, fileName, downloadName string) (bool, error) {
+	url, err := signedFileURL(sc, fileName, downloadName)
 
-		if sc.bucket, err = file.DefaultBucketName(sc.c); err != nil {
-			return "", err
-		}
+	if err != nil || url == "" {
+		return false, err
 	}
 
-	return sc.bucket, nil
-}
-
-func (sc *storageContext) ReadFile(fileName string) (slurp []byte, err error) {
-
-	bucket, err := sc.Bucket()
-
-	if err != nil {
-		return
-	}
-
-	log.Debugf(sc.c, "Reading file %v from bucket %v", fileName, bucket)
-
-	rc, err := storage.NewReader(sc.ctx, bucket, fileName)
-
-	if err != nil {
-		return
-	}
-
-	defer rc.Close()
-
-	slurp, err = ioutil.ReadAll(rc)
-	return
-}
-
-// WriteFile writes a byte arrat to a file and sets the content type based on
-// the file extension.
-func (sc *storageContext) WriteFile(fileName string, data []byte) error {
-	bucket, err := sc.Bucket()
-
-	if err != nil {
-		return err
-	}
-
-	log.Debugf(sc.c, "Writing file %v to bucket %v", fileName, bucket)
-
-	wc := storage.NewWriter(sc.ctx, bucket, fileName)
-
-	ext := filepath.Ext(fileName)
-
-	// unfortunately app engine doesn't support mimetypes so we have to do this manually
-	var contentType string
-
-	switch ext {
-	case ".json":
-		contentType = "application/json"
-	case ".txt":
-		contentType = "text/plain"
-	default:
-		contentType = mime.TypeByExtension(ext)
-	}
-
-	wc.ContentType = contentType
-
-	if _, err := wc.Write(data); err != nil {
-		return err
-	}
-
-	return wc.Close()
-}
-
-func (sc *storageContext) MakePublic(fileName string) error {
-	bucket, err := sc.Bucket()
-
-	if err != nil {
-		return err
-	}
-
-	log.Debugf(sc.c, "Making file %v in bucket %v public", fileName, bucket)
-
-	return storage.PutACLRule(sc.ctx, bucket, fileName, storage.AllUsers, storage.RoleReader)
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, url, http.StatusFound)
+	return true, nil
 }
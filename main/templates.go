@@ -0,0 +1,298 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/volcanicpixels/licensing/config"
+	"github.com/volcanicpixels/licensing/license"
+)
+
+// maxCampaignLength is the longest campaign code a license can be tagged
+// with.
+const maxCampaignLength = 64
+
+// createRequest is the body of a request to create a license. Everything
+// other than the product is optional, settings given explicitly override
+// those of the template which override the defaults.
+type createRequest struct {
+	Product        string                 `json:"product"`
+	Template       string                 `json:"template"`
+	Plan           string                 `json:"plan"`
+	ExpiresIn      string                 `json:"expires_in"` // e.g. "8760h"
+	NotBefore      *time.Time             `json:"not_before"` // RFC 3339
+	Entitlements   map[string]int         `json:"entitlements"`
+	MaxActivations *int                   `json:"max_activations"`
+	Seats          *int                   `json:"seats"`
+	Regions        []string               `json:"regions"`
+	MinVersion     string                 `json:"min_version"`
+	MaxVersion     string                 `json:"max_version"`
+	Attrs          map[string]interface{} `json:"attrs"`
+
+	// OrderID is the storefront's order, a second request for the same
+	// product and order gets the license issued for the first.
+	OrderID string `json:"order_id"`
+
+	// Campaign is the promotion or coupon code of the sale, stored on the
+	// license and counted in the summary report.
+	Campaign string `json:"campaign"`
+
+	// Invoice and ReceiptURL are the invoice number and receipt of the
+	// sale, stored on the license for support.
+	Invoice    string `json:"invoice"`
+	ReceiptURL string `json:"receipt_url"`
+
+	// IgnoreCap lets an admin issue a license for a product that has sold
+	// out, it is still counted.
+	IgnoreCap bool `json:"ignore_cap"`
+
+	// splitFrom is the bundle license a license is split from, whose
+	// expiry, not before time and entitlements for the product it keeps.
+	// Requests can't set it.
+	splitFrom *license.License
+}
+
+// lookupTemplate returns the named template of a product.
+func lookupTemplate(product, name string) (*config.Template, error) {
+	t, ok := cfg.Products[product].Templates[name]
+
+	if !ok {
+		return nil, fmt.Errorf("product %q has no template %q", product, name)
+	}
+
+	return &t, nil
+}
+
+// lookupPlan returns the named plan of a product.
+func lookupPlan(product, name string) (*config.Plan, error) {
+	p, ok := cfg.Products[product].Plans[name]
+
+	if !ok {
+		return nil, fmt.Errorf("product %q has no plan %q", product, name)
+	}
+
+	return &p, nil
+}
+
+// applyPlan puts a license on a plan, replacing its entitlements,
+// activation limit and seats with the plan's.
+func applyPlan(lic *license.License, name string, p *config.Plan) {
+	lic.Plan = name
+	lic.Entitlements = nil
+	lic.MaxActivations = p.MaxActivations
+	lic.Seats = p.Seats
+
+	if p.Entitlements != nil {
+		lic.Entitlements = make(map[string]int, len(p.Entitlements))
+
+		for feature, limit := range p.Entitlements {
+			lic.Entitlements[feature] = limit
+		}
+	}
+}
+
+// applyBundle makes a license a bundle license for the products of a bundle,
+// with the entitlements of those that have their own.
+func applyBundle(lic *license.License, bundle map[string]config.BundledProduct) {
+	lic.Products, lic.ProductEntitlements = nil, nil
+
+	for product, b := range bundle {
+		lic.Products = append(lic.Products, product)
+
+		if b.Entitlements == nil {
+			continue
+		}
+
+		if lic.ProductEntitlements == nil {
+			lic.ProductEntitlements = make(map[string]map[string]int)
+		}
+
+		lic.ProductEntitlements[product] = make(map[string]int, len(b.Entitlements))
+
+		for feature, limit := range b.Entitlements {
+			lic.ProductEntitlements[product][feature] = limit
+		}
+	}
+
+	sort.Strings(lic.Products)
+}
+
+// applyCreateRequest sets up a new license from the defaults, the template
+// (if any), the plan (if any) and then the settings in the request.
+func applyCreateRequest(lic *license.License, req *createRequest) error {
+	expiry := cfg.DefaultExpiry(req.Product)
+	applyBundle(lic, cfg.Products[req.Product].Bundle)
+
+	if req.Template != "" {
+		t, err := lookupTemplate(req.Product, req.Template)
+
+		if err != nil {
+			return err
+		}
+
+		if t.Expiry > 0 {
+			expiry = t.Expiry
+		}
+
+		if t.Entitlements != nil {
+			lic.Entitlements = make(map[string]int, len(t.Entitlements))
+
+			for feature, limit := range t.Entitlements {
+				lic.Entitlements[feature] = limit
+			}
+		}
+
+		lic.MaxActivations = t.MaxActivations
+		lic.Seats = t.Seats
+
+		for k, v := range t.Attrs {
+			lic.Attrs[k] = v
+		}
+
+		if len(t.Regions) > 0 {
+			lic.Regions = normalizeRegions(t.Regions)
+		}
+
+		lic.MinVersion, lic.MaxVersion = t.MinVersion, t.MaxVersion
+	}
+
+	if req.Plan != "" {
+		p, err := lookupPlan(req.Product, req.Plan)
+
+		if err != nil {
+			return err
+		}
+
+		applyPlan(lic, req.Plan, p)
+	}
+
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid expires_in %q", req.ExpiresIn)
+		}
+
+		expiry = d
+	}
+
+	// pre-orders are valid from not_before, their expiry counts from then
+	// too
+	start := lic.IssuedAt
+
+	if req.NotBefore != nil {
+		notBefore := req.NotBefore.UTC()
+		lic.NotBefore = &notBefore
+
+		if notBefore.After(start) {
+			start = notBefore
+		}
+	}
+
+	if expiry > 0 {
+		expiresAt := start.Add(expiry)
+		lic.ExpiresAt = &expiresAt
+	}
+
+	if b := req.splitFrom; b != nil {
+		lic.ExpiresAt, lic.NotBefore, lic.Entitlements = b.ExpiresAt, b.NotBefore, nil
+
+		if ent := b.EntitlementsFor(req.Product); ent != nil {
+			lic.Entitlements = make(map[string]int, len(ent))
+
+			for feature, limit := range ent {
+				lic.Entitlements[feature] = limit
+			}
+		}
+	}
+
+	for feature, limit := range req.Entitlements {
+		if limit < 0 {
+			return fmt.Errorf("invalid limit for %v", feature)
+		}
+
+		if lic.Entitlements == nil {
+			lic.Entitlements = make(map[string]int)
+		}
+
+		lic.Entitlements[feature] = limit
+	}
+
+	if req.MaxActivations != nil {
+		if *req.MaxActivations < 0 {
+			return fmt.Errorf("invalid max_activations %v", *req.MaxActivations)
+		}
+
+		lic.MaxActivations = *req.MaxActivations
+	} else if lic.MaxActivations == 0 {
+		lic.MaxActivations = cfg.Products[req.Product].Policy.MaxActivations
+	}
+
+	if req.Seats != nil {
+		if *req.Seats < 0 {
+			return fmt.Errorf("invalid seats %v", *req.Seats)
+		}
+
+		lic.Seats = *req.Seats
+	}
+
+	if req.Regions != nil {
+		for _, r := range req.Regions {
+			if len(r) != 2 {
+				return fmt.Errorf("invalid region %q, regions are two letter country codes", r)
+			}
+		}
+
+		lic.Regions = normalizeRegions(req.Regions)
+	}
+
+	if req.MinVersion != "" {
+		lic.MinVersion = req.MinVersion
+	}
+
+	if req.MaxVersion != "" {
+		lic.MaxVersion = req.MaxVersion
+	}
+
+	if lic.MinVersion != "" && lic.MaxVersion != "" && !lic.AllowsVersion(lic.MinVersion) {
+		return fmt.Errorf("min_version %v is above max_version %v", lic.MinVersion, lic.MaxVersion)
+	}
+
+	for k, v := range req.Attrs {
+		lic.Attrs[k] = v
+	}
+
+	if req.OrderID = strings.TrimSpace(req.OrderID); req.OrderID != "" {
+		lic.Attrs["orderId"] = req.OrderID
+	}
+
+	// codes are lower cased so that a campaign is counted once however the
+	// storefront typed it
+	if campaign := strings.ToLower(strings.TrimSpace(req.Campaign)); campaign != "" {
+		if len(campaign) > maxCampaignLength {
+			return fmt.Errorf("campaign is longer than %v characters", maxCampaignLength)
+		}
+
+		lic.Campaign = campaign
+	}
+
+	return setInvoice(lic, &req.Invoice, &req.ReceiptURL)
+}
+
+// normalizeRegions upper cases country codes, as App Engine reports them,
+// returning nil for none.
+func normalizeRegions(regions []string) []string {
+	if len(regions) == 0 {
+		return nil
+	}
+
+	normalized := make([]string, len(regions))
+
+	for i, r := range regions {
+		normalized[i] = strings.ToUpper(r)
+	}
+
+	return normalized
+}
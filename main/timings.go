@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+const timingsContextKey contextKey = 2
+
+// requestTimings are how long the parts of a request took, such as loading
+// the signing key, signing and storing a license. Requests by admins and
+// with debug API keys get them in a Server-Timing header, to find out why
+// issuance is slow without extra logging.
+type requestTimings struct {
+	mu      sync.Mutex
+	enabled bool
+	start   time.Time
+	names   []string // in the order they were first timed
+	spent   map[string]time.Duration
+}
+
+func newRequestTimings(start time.Time) *requestTimings {
+	return &requestTimings{start: start, spent: make(map[string]time.Duration)}
+}
+
+// requestTimingsOf returns the timings of a request, or nil outside of one.
+func requestTimingsOf(c context.Context) *requestTimings {
+	t, _ := c.Value(timingsContextKey).(*requestTimings)
+	return t
+}
+
+// timed starts timing a part of a request and returns the function that
+// stops it, the times of a part done more than once add up.
+func timed(c context.Context, name string) func() {
+	t := requestTimingsOf(c)
+
+	if t == nil {
+		return func() {}
+	}
+
+	start := time.Now()
+
+	return func() {
+		d := time.Since(start)
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		if _, ok := t.spent[name]; !ok {
+			t.names = append(t.names, name)
+		}
+
+		t.spent[name] += d
+	}
+}
+
+// header returns the Server-Timing header of the timings up to now, in
+// milliseconds, with the whole request so far as total.
+func (t *requestTimings) header() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 1, 64)
+	}
+
+	metrics := make([]string, 0, len(t.names)+1)
+
+	for _, name := range t.names {
+		metrics = append(metrics, fmt.Sprintf("%v;dur=%v", name, ms(t.spent[name])))
+	}
+
+	return strings.Join(append(metrics, "total;dur="+ms(time.Since(t.start))), ", ")
+}
+
+// timingWriter adds the Server-Timing header to a response before it is
+// written, if the request's timings are enabled.
+type timingWriter struct {
+	http.ResponseWriter
+	timings     *requestTimings
+	wroteHeader bool
+}
+
+func (tw *timingWriter) WriteHeader(status int) {
+	if !tw.wroteHeader {
+		tw.wroteHeader = true
+
+		if tw.timings.enabled {
+			tw.Header().Set("Server-Timing", tw.timings.header())
+		}
+	}
+
+	tw.ResponseWriter.WriteHeader(status)
+}
+
+func (tw *timingWriter) Write(b []byte) (int, error) {
+	if !tw.wroteHeader {
+		tw.WriteHeader(http.StatusOK)
+	}
+
+	return tw.ResponseWriter.Write(b)
+}
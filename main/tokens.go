@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/license"
+)
+
+// NewAccessToken handles POST requests to /api/licenses/token
+//
+// The request body holds an encoded license and one of the configured
+// scopes. A valid license, or one in its grace period, is exchanged for a
+// short-lived token signed with the access token key, which services such as
+// update downloads verify instead of the license. Scopes can require an
+// entitlement.
+//
+// Example:
+//
+//	POST /api/licenses/token {"license": "eyJhbGciOiJSUzI1NiIs...", "scope": "updates"}
+//	200 {"token": "eyJhbGciOiJSUzI1NiIs...", "expiresAt": "2026-10-14T09:45:00Z"}
+func NewAccessToken(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	if cfg.AccessToken.Key == "" {
+		return &appError{errors.New("access tokens disabled"), "Access tokens are not enabled", http.StatusNotFound, codeNotFound}
+	}
+
+	var req struct {
+		License string `json:"license"`
+		Scope   string `json:"scope"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	entitlement, ok := cfg.AccessToken.Scopes[req.Scope]
+
+	if !ok {
+		return &appError{fmt.Errorf("unknown scope %q", req.Scope), "Unknown scope", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	v := newValidator(c)
+	lic, err := v.parse(strings.TrimSpace(req.License))
+
+	if _, invalid := err.(*invalidError); invalid {
+		return &appError{err, "The license is invalid", http.StatusBadRequest, codeLicenseInvalid}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load public key for verifying", http.StatusInternalServerError, codeKeyUnavailable}
+	}
+
+	vd, err := v.check(lic)
+
+	if err != nil {
+		return &appError{err, "An error occurred validating the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if !vd.Valid && !vd.InGrace {
+		writeJSON(w, http.StatusForbidden, vd)
+		return nil
+	}
+
+	if _, entitled := lic.Entitlements[entitlement]; entitlement != "" && lic.Entitlements != nil && !entitled {
+		err := fmt.Errorf("license %v isn't entitled to %v", lic.ID, entitlement)
+		return &appError{err, "The license doesn't include this scope", http.StatusForbidden, codeForbidden}
+	}
+
+	key, err := getPrivateKey(c, cfg.AccessToken.Key)
+
+	if err != nil {
+		return &appError{err, "Could not load private key for signing", http.StatusInternalServerError, codeKeyUnavailable}
+	}
+
+	at := license.NewAccessToken(lic, req.Scope, cfg.AccessToken.TTL)
+	token, err := at.Encode(key)
+
+	if err != nil {
+		return &appError{err, "Could not encode the access token", http.StatusInternalServerError, codeInternal}
+	}
+
+	writeJSON(w, 200, struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}{token, at.ExpiresAt})
+
+	return nil
+}
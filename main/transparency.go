@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/danielchatfield/go-jwt"
+	"github.com/volcanicpixels/licensing/translog"
+)
+
+const maxLogEntries = 1000
+
+// transparencyLog opens the transparency log of revocations, which the
+// primary appends to in the replica regions' buckets too.
+func transparencyLog(c context.Context) (*translog.Log, error) {
+	sc, err := newStorage(c)
+
+	if err == nil {
+		sc, err = replicatedStorage(c, sc)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return translog.New(sc, cfg.Revocations.Log), nil
+}
+
+// logRevocations adds any of the IDs that aren't in the transparency log yet.
+func logRevocations(c context.Context, ids []string) error {
+	tl, err := transparencyLog(c)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tl.Append(ids, time.Now())
+	return err
+}
+
+// treeHead is a signed statement of the size and root hash of the log.
+type treeHead struct {
+	TreeSize  int           `json:"treeSize"`
+	RootHash  translog.Hash `json:"rootHash"`
+	Timestamp int64         `json:"timestamp"`
+
+	// Token is the JWT of the other fields, signed with the default key, its
+	// claims are _treeSize, _rootHash and iat.
+	Token string `json:"token"`
+}
+
+func signTreeHead(c context.Context, leaves []translog.Hash) (*treeHead, error) {
+	key, err := getPrivateKey(c, cfg.Keys.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	th := &treeHead{
+		TreeSize:  len(leaves),
+		RootHash:  translog.RootHash(leaves),
+		Timestamp: time.Now().Unix(),
+	}
+
+	root, _ := th.RootHash.MarshalText()
+
+	t := jwt.NewToken(jwt.RSA)
+	t.SetClaim("_treeSize", th.TreeSize)
+	t.SetClaim("_rootHash", string(root))
+	t.SetClaim("iat", th.Timestamp)
+
+	if th.Token, err = t.Encode(key); err != nil {
+		return nil, err
+	}
+
+	return th, nil
+}
+
+// queryInt returns an integer query parameter, or def if it is missing.
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	s := r.URL.Query().Get(name)
+
+	if s == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(s)
+
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%v must be a non-negative integer", name)
+	}
+
+	return n, nil
+}
+
+// readLog returns the log's entries and their leaf hashes.
+func readLog(c context.Context) ([]translog.Entry, []translog.Hash, *appError) {
+	tl, err := transparencyLog(c)
+
+	if err != nil {
+		return nil, nil, &appError{err, "Could not open storage", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	entries, err := tl.Entries()
+
+	if err != nil {
+		return nil, nil, &appError{err, "An error occurred reading the transparency log", http.StatusInternalServerError, codeInternal}
+	}
+
+	return entries, translog.Leaves(entries), nil
+}
+
+// TransparencyHead handles GET requests to /api/transparency/head
+//
+// It returns the signed tree head of the current log.
+func TransparencyHead(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	_, leaves, e := readLog(c)
+
+	if e != nil {
+		return e
+	}
+
+	th, err := signTreeHead(c, leaves)
+
+	if err != nil {
+		return &appError{err, "An error occurred signing the tree head", http.StatusInternalServerError, codeInternal}
+	}
+
+	writeJSON(w, 200, th)
+	return nil
+}
+
+// TransparencyProof handles GET requests to /api/transparency/proof
+//
+// It returns the entry for the revoked license ID and the audit path proving
+// it is in the tree of the given size, by default the current tree whose
+// signed head is included.
+//
+// Example:
+//
+//	GET /api/transparency/proof?id=daS7y8sioiecYy
+//	200 {"entry": {"index": 4, "timestamp": ..., "id": "daS7y8sioiecYy"}, "treeSize": 9, "auditPath": [...], "head": {...}}
+func TransparencyProof(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	entries, leaves, e := readLog(c)
+
+	if e != nil {
+		return e
+	}
+
+	size, err := queryInt(r, "size", len(leaves))
+
+	if err == nil && size > len(leaves) {
+		err = errors.New("size is larger than the log")
+	}
+
+	if err != nil {
+		return &appError{err, err.Error(), http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	id := r.URL.Query().Get("id")
+	index := -1
+
+	for i := 0; i < size; i++ {
+		if entries[i].ID == id {
+			index = i
+			break
+		}
+	}
+
+	if index < 0 {
+		err := fmt.Errorf("%v is not in the log", id)
+		return &appError{err, "The license is not in the transparency log", http.StatusNotFound, codeNotFound}
+	}
+
+	th, err := signTreeHead(c, leaves)
+
+	if err != nil {
+		return &appError{err, "An error occurred signing the tree head", http.StatusInternalServerError, codeInternal}
+	}
+
+	writeJSON(w, 200, struct {
+		Entry     translog.Entry  `json:"entry"`
+		TreeSize  int             `json:"treeSize"`
+		AuditPath []translog.Hash `json:"auditPath"`
+		Head      *treeHead       `json:"head"`
+	}{entries[index], size, translog.InclusionProof(leaves[:size], index), th})
+
+	return nil
+}
+
+// TransparencyConsistency handles GET requests to /api/transparency/consistency
+//
+// It returns the proof that the tree of the first size is a prefix of the
+// tree of the second, by default the current tree.
+//
+// Example:
+//
+//	GET /api/transparency/consistency?first=5&second=9
+//	200 {"first": 5, "second": 9, "proof": [...]}
+func TransparencyConsistency(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	_, leaves, e := readLog(c)
+
+	if e != nil {
+		return e
+	}
+
+	first, err := queryInt(r, "first", 0)
+	second := len(leaves)
+
+	if err == nil {
+		second, err = queryInt(r, "second", len(leaves))
+	}
+
+	if err == nil && (first > second || second > len(leaves)) {
+		err = errors.New("the sizes must be in order and no larger than the log")
+	}
+
+	if err != nil {
+		return &appError{err, err.Error(), http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	proof := translog.ConsistencyProof(leaves[:second], first)
+
+	if proof == nil {
+		proof = []translog.Hash{}
+	}
+
+	writeJSON(w, 200, struct {
+		First  int             `json:"first"`
+		Second int             `json:"second"`
+		Proof  []translog.Hash `json:"proof"`
+	}{first, second, proof})
+
+	return nil
+}
+
+// TransparencyEntries handles GET requests to /api/transparency/entries
+//
+// It returns up to count (at most 1000) entries from start so that auditors
+// can rebuild the tree.
+func TransparencyEntries(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	entries, _, e := readLog(c)
+
+	if e != nil {
+		return e
+	}
+
+	start, err := queryInt(r, "start", 0)
+	count := maxLogEntries
+
+	if err == nil {
+		count, err = queryInt(r, "count", maxLogEntries)
+	}
+
+	if err != nil {
+		return &appError{err, err.Error(), http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	if count > maxLogEntries {
+		count = maxLogEntries
+	}
+
+	if start > len(entries) {
+		start = len(entries)
+	}
+
+	end := start + count
+
+	if end > len(entries) {
+		end = len(entries)
+	}
+
+	writeJSON(w, 200, struct {
+		Entries []translog.Entry `json:"entries"`
+	}{append([]translog.Entry{}, entries[start:end]...)})
+
+	return nil
+}
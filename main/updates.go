@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/storage"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// downloadScope is the scope of the access tokens in download URLs.
+const downloadScope = "download"
+
+// updateMessages are shown by the plugin in place of an update when the
+// license doesn't allow one.
+var updateMessages = map[string]string{
+	statusInvalid:          "Your license key is invalid.",
+	statusExpired:          "Your license has expired, renew it to receive updates.",
+	statusNotYetValid:      "Your license isn't valid yet.",
+	statusRevoked:          "Your license has been revoked.",
+	statusSuperseded:       "Your license key has been replaced, enter the new one.",
+	statusRegionRestricted: "Your license isn't valid in your country.",
+	statusReissueRequired:  "Your license must be replaced, contact support for a new license key.",
+	statusSuspended:        "Updates are unavailable at the moment, try again later.",
+}
+
+// updateInfo is the update metadata returned to an install, the package is
+// only set if the license is valid.
+type updateInfo struct {
+	Product     string `json:"product"`
+	Version     string `json:"version"`
+	Update      bool   `json:"update"` // newer than the install's version
+	Changelog   string `json:"changelog,omitempty"`
+	Requires    string `json:"requires,omitempty"`
+	Tested      string `json:"tested,omitempty"`
+	RequiresPHP string `json:"requiresPhp,omitempty"`
+
+	Package          string     `json:"package,omitempty"`
+	PackageExpiresAt *time.Time `json:"packageExpiresAt,omitempty"`
+
+	LicenseStatus string `json:"licenseStatus"`
+	Message       string `json:"message,omitempty"`
+}
+
+// requestBaseURL returns the scheme and host a request was made to, App
+// Engine terminates TLS so everything but local development is https.
+func requestBaseURL(r *http.Request) string {
+	scheme := "https"
+
+	if r.TLS == nil && (strings.HasPrefix(r.Host, "localhost") || strings.HasPrefix(r.Host, "127.0.0.1")) {
+		scheme = "http"
+	}
+
+	return scheme + "://" + r.Host
+}
+
+// downloadURL returns a URL that the release of a product the license covers
+// can be downloaded from until it expires, signed as an access token.
+func downloadURL(c context.Context, r *http.Request, lic *license.License, product string) (string, time.Time, error) {
+	key, err := getPrivateKey(c, cfg.AccessToken.Key)
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	at := license.NewAccessToken(lic, downloadScope, cfg.AccessToken.TTL)
+	at.Product = product
+	token, err := at.Encode(key)
+
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return requestBaseURL(r) + "/api/downloads/" + token, at.ExpiresAt, nil
+}
+
+// CheckUpdate handles GET requests to /api/updates/{product}
+//
+// The license parameter is the install's encoded license and version is the
+// version it has. The response describes the product's latest release with
+// a download URL for it if the license is valid (or in its grace period) and
+// covers the release's version, otherwise the licenseStatus says why not
+// along with a message to show. Bundle licenses get the releases of each of
+// the bundle's products.
+//
+// Examples:
+//
+//	GET /api/updates/domain_changer?license=eyJhbGciOiJSUzI1NiIs...&version=1.3.2
+//	200 {"product": "domain_changer", "version": "1.4.0", "update": true, "package": "https://.../api/downloads/eyJ...", "licenseStatus": "valid", ...}
+//
+//	GET /api/updates/domain_changer?license=eyJhbGciOiJSUzI1NiIs...&version=1.3.2
+//	200 {"product": "domain_changer", "version": "1.4.0", "update": true, "licenseStatus": "expired", "message": "Your license has expired, renew it to receive updates."}
+func CheckUpdate(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	product := mux.Vars(r)["product"]
+	p, ok := cfg.Products[product]
+
+	if !ok || p.Release.Version == "" {
+		return &appError{fmt.Errorf("no release of %q", product), "Unknown product", http.StatusNotFound, codeProductUnknown}
+	}
+
+	rel := p.Release
+	info := &updateInfo{
+		Product:     product,
+		Version:     rel.Version,
+		Update:      license.CompareVersions(r.FormValue("version"), rel.Version) < 0,
+		Changelog:   rel.Changelog,
+		Requires:    rel.Requires,
+		Tested:      rel.Tested,
+		RequiresPHP: rel.RequiresPHP,
+	}
+
+	v := newValidator(c)
+	v.country = requestCountry(r)
+	lic, err := v.parse(strings.TrimSpace(r.FormValue("license")))
+
+	if _, invalid := err.(*invalidError); invalid || (err == nil && !lic.Covers(product)) {
+		info.LicenseStatus = statusInvalid
+		info.Message = updateMessages[statusInvalid]
+		writeJSON(w, 200, info)
+		return nil
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load public key for verifying", http.StatusInternalServerError, codeKeyUnavailable}
+	}
+
+	vd, err := v.check(lic)
+
+	if err != nil {
+		return &appError{err, "An error occurred validating the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	info.LicenseStatus = vd.Status
+
+	if !vd.Valid && !vd.InGrace {
+		info.Message = updateMessages[vd.Status]
+		writeJSON(w, 200, info)
+		return nil
+	}
+
+	if !lic.AllowsVersion(rel.Version) {
+		info.LicenseStatus = statusVersionNotCovered
+		info.Message = fmt.Sprintf("Your license doesn't cover version %v, upgrade it to receive this update.", rel.Version)
+		writeJSON(w, 200, info)
+		return nil
+	}
+
+	if rel.Package != "" {
+		url, expiresAt, err := downloadURL(c, r, lic, product)
+
+		if err != nil {
+			return &appError{err, "Could not sign the download URL", http.StatusInternalServerError, codeInternal}
+		}
+
+		info.Package = url
+		info.PackageExpiresAt = &expiresAt
+	}
+
+	writeJSON(w, 200, info)
+	return nil
+}
+
+// IssueDownloadToken handles POST requests to /api/licenses/{id}/download-token
+//
+// It returns a URL the latest release of a stored license's product can be
+// downloaded from, for storefronts and customer portals to link to. The
+// license must be valid or in its grace period, and the URL expires after
+// access_tokens.ttl.
+//
+// Example:
+//
+//	POST /api/licenses/daS7y8sioiecYy/download-token
+//	200 {"url": "https://.../api/downloads/eyJ...", "expiresAt": "2016-05-01T12:15:00Z"}
+func IssueDownloadToken(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	lic, err := env.Licenses(c, requestNamespace(c)).Get(c, mux.Vars(r)["id"])
+
+	if err == store.ErrNotFound {
+		return &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	rel := cfg.Products[lic.Product].Release
+
+	if rel.Package == "" {
+		return &appError{fmt.Errorf("no package for %q", lic.Product), "The product has no release to download", http.StatusNotFound, codeNotFound}
+	}
+
+	if !lic.AllowsVersion(rel.Version) {
+		return &appError{fmt.Errorf("license %v doesn't cover %v", lic.ID, rel.Version), fmt.Sprintf("The license doesn't cover version %v", rel.Version), http.StatusForbidden, codeLicenseNotValid}
+	}
+
+	vd, err := newValidator(c).check(lic)
+
+	if err != nil {
+		return &appError{err, "An error occurred validating the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if !vd.Valid && !vd.InGrace {
+		return &appError{fmt.Errorf("license %v is %v", lic.ID, vd.Status), "The license is " + vd.Status, http.StatusForbidden, codeLicenseNotValid}
+	}
+
+	url, expiresAt, err := downloadURL(c, r, lic, lic.Product)
+
+	if err != nil {
+		return &appError{err, "Could not sign the download URL", http.StatusInternalServerError, codeInternal}
+	}
+
+	writeJSON(w, 200, struct {
+		URL       string    `json:"url"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}{url, expiresAt})
+
+	return nil
+}
+
+// Download handles GET requests to /api/downloads/{token}
+//
+// The token is an access token with the download scope, from the update or
+// download token endpoints. The latest release of its product is returned until it expires.
+func Download(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	if cfg.AccessToken.Key == "" {
+		return &appError{errors.New("access tokens disabled"), "Not found", http.StatusNotFound, codeNotFound}
+	}
+
+	key, err := getPublicKey(c, cfg.AccessToken.Key)
+
+	if err != nil {
+		return &appError{err, "Could not load public key for verifying", http.StatusInternalServerError, codeKeyUnavailable}
+	}
+
+	at, err := license.ParseAccessToken(mux.Vars(r)["token"], key)
+
+	if err == license.ErrAccessTokenExpired {
+		return &appError{err, "The download link has expired", http.StatusGone, codeTokenExpired}
+	}
+
+	if err != nil || at.Scope != downloadScope {
+		return &appError{errors.New("invalid download token"), "Invalid download link", http.StatusForbidden, codeTokenInvalid}
+	}
+
+	pkg := cfg.Products[at.Product].Release.Package
+
+	if pkg == "" {
+		return &appError{fmt.Errorf("no package for %q", at.Product), "Not found", http.StatusNotFound, codeNotFound}
+	}
+
+	sc, err := newStorage(c)
+
+	if err != nil {
+		return &appError{err, "Could not open storage", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	data, err := sc.ReadFile(pkg)
+
+	if err == storage.ErrNotExist {
+		return &appError{err, "Not found", http.StatusNotFound, codeNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not read the package", http.StatusInternalServerError, codeStorageUnavailable}
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v"`, path.Base(pkg)))
+	w.Write(data)
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// UpgradeLicense handles POST requests to /api/licenses/{id}/upgrade
+//
+// It issues a new license that covers a later major version of the product,
+// e.g. 3.x for the holder of a lifetime 2.x license. The new license has the
+// entitlements, activation limit, seats and named users, regions and
+// attributes of the old one, with upgradedFrom set to its ID instead of its
+// chargeId, and covers only the given version (see
+// license.License.MaxVersion). It expires after expires_in if given, or the
+// default expiry. The old license is left as it is, so it keeps working with
+// the versions it covers. The response is the new license.
+//
+// Example:
+//
+//	POST /api/licenses/daS7y8sioiecYy/upgrade {"version": "3"}
+//	200 "eyJhbGciOiJSUzI1NiIs..."
+//
+//	400 The license already covers version 3
+func UpgradeLicense(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	var req struct {
+		Version   string `json:"version"`
+		ExpiresIn string `json:"expires_in"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	version := strings.TrimSuffix(strings.TrimSpace(req.Version), ".x")
+
+	if version == "" {
+		return &appError{errors.New("no version"), "The version to upgrade to is required", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	old, err := env.Licenses(c, requestNamespace(c)).Get(c, mux.Vars(r)["id"])
+
+	if err == store.ErrNotFound {
+		return &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if old.RevokedAt != nil {
+		return &appError{errors.New("license revoked"), "Revoked licenses can't be upgraded", http.StatusConflict, codeLicenseRevoked}
+	}
+
+	if old.AllowsVersion(version) {
+		return &appError{errors.New("version already covered"), fmt.Sprintf("The license already covers version %v", version), http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	maxActivations, seats := old.MaxActivations, old.Seats
+	create := &createRequest{
+		Product:        old.Product,
+		ExpiresIn:      req.ExpiresIn,
+		Entitlements:   old.Entitlements,
+		MaxActivations: &maxActivations,
+		Seats:          &seats,
+		Regions:        old.Regions,
+		MinVersion:     version,
+		MaxVersion:     version,
+		Attrs:          make(map[string]interface{}, len(old.Attrs)+1),
+	}
+
+	// plans that have since been removed are dropped
+	if _, err := lookupPlan(old.Product, old.Plan); err == nil {
+		create.Plan = old.Plan
+	}
+
+	for k, v := range old.Attrs {
+		create.Attrs[k] = v
+	}
+
+	// the upgrade isn't paid for by the old license's payment, so refunding
+	// that doesn't revoke it
+	delete(create.Attrs, "chargeId")
+	create.Attrs["upgradedFrom"] = old.ID
+
+	lic, licStr, _, e := issueLicense(c, create, old.Reseller)
+
+	if e != nil {
+		return e
+	}
+
+	// the upgrade keeps the old license's named users
+	if lic.Seats > 0 {
+		users := env.Users(c, requestNamespace(c))
+		list, err := users.List(c, old.ID)
+
+		if err != nil {
+			env.Errorf(c, "Could not list the users of %v to copy them to %v: %v", old.ID, lic.ID, err)
+		}
+
+		for _, u := range list {
+			u.LicenseID = lic.ID
+
+			if err := users.Add(c, u, 0); err != nil {
+				env.Errorf(c, "Could not copy user %v of %v to %v: %v", u.Email, old.ID, lic.ID, err)
+			}
+		}
+	}
+
+	entry := store.AuditEntry{
+		Action:   "license.upgrade",
+		Target:   old.ID,
+		Customer: old.Email(),
+		Details:  map[string]string{"license": lic.ID, "version": version},
+	}
+
+	if err := audit(c, entry); err != nil {
+		env.Errorf(c, "Could not record the upgrade of %v in the audit log: %v", old.ID, err)
+	}
+
+	writeJSON(w, 200, encodedLicense(licStr))
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/gorilla/mux"
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// normalizeEmail trims and lower cases an email address, as the named users
+// of licenses are stored.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// namedUserLicense loads the stored license a users request is about,
+// failing unless it has named users.
+func namedUserLicense(c context.Context, r *http.Request) (*license.License, *appError) {
+	id := mux.Vars(r)["id"]
+	lic, err := env.Licenses(c, requestNamespace(c)).Get(c, id)
+
+	if err == store.ErrNotFound {
+		return nil, &appError{err, "License not found", http.StatusNotFound, codeLicenseNotFound}
+	}
+
+	if err != nil {
+		return nil, &appError{err, "Could not load the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if lic.Seats == 0 {
+		return nil, &appError{fmt.Errorf("license %v has no seats", id), "The license doesn't have named users", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	return lic, nil
+}
+
+// decodeUserRequest decodes the email of a users request.
+func decodeUserRequest(r *http.Request) (string, *appError) {
+	var req struct {
+		Email string `json:"email"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return "", &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	email := normalizeEmail(req.Email)
+
+	if !strings.Contains(email, "@") {
+		return "", &appError{errors.New("invalid email"), "An email address is required", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	return email, nil
+}
+
+// writeUsers writes the users of a license and how many of its seats they
+// take.
+func writeUsers(c context.Context, w http.ResponseWriter, namespace string, lic *license.License) *appError {
+	users, err := env.Users(c, namespace).List(c, lic.ID)
+
+	if err != nil {
+		return &appError{err, "An error occurred listing the users", http.StatusInternalServerError, codeInternal}
+	}
+
+	if users == nil {
+		users = []store.User{}
+	}
+
+	writeJSON(w, 200, struct {
+		Users []store.User     `json:"users"`
+		Seats *activationUsage `json:"seats"`
+	}{users, &activationUsage{len(users), lic.Seats}})
+
+	return nil
+}
+
+// ListUsers handles GET requests to /api/licenses/{id}/users
+//
+// The response is the named users of a license, oldest first, and how many
+// of its seats they take.
+//
+// Example:
+//
+//	GET /api/licenses/daS7y8sioiecYy/users
+//	200 {"users": [{"licenseId": "daS7y8sioiecYy", "email": "jane@example.com", "addedAt": "..."}], "seats": {"used": 1, "allowed": 5}}
+func ListUsers(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	lic, e := namedUserLicense(c, r)
+
+	if e != nil {
+		return e
+	}
+
+	return writeUsers(c, w, requestNamespace(c), lic)
+}
+
+// AddUser handles POST requests to /api/licenses/{id}/users
+//
+// It gives a user a seat of a named-user license, adding a user that already
+// has one does nothing. A license whose seats are all taken answers 409, as
+// does a revoked license. The response is the license's users.
+//
+// Example:
+//
+//	POST /api/licenses/daS7y8sioiecYy/users
+//	{"email": "jane@example.com"}
+//	200 {"users": [...], "seats": {"used": 2, "allowed": 5}}
+func AddUser(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	lic, e := namedUserLicense(c, r)
+
+	if e != nil {
+		return e
+	}
+
+	email, e := decodeUserRequest(r)
+
+	if e != nil {
+		return e
+	}
+
+	if lic.RevokedAt != nil {
+		return &appError{fmt.Errorf("license %v is revoked", lic.ID), "The license is revoked", http.StatusConflict, codeLicenseRevoked}
+	}
+
+	u := store.User{LicenseID: lic.ID, Email: email, AddedAt: time.Now()}
+	err := env.Users(c, requestNamespace(c)).Add(c, u, lic.Seats)
+
+	if err == store.ErrSeatLimit {
+		return &appError{err, fmt.Sprintf("All %v seats of the license are taken", lic.Seats), http.StatusConflict, codeSeatLimit}
+	}
+
+	if err != nil {
+		return &appError{err, "An error occurred adding the user", http.StatusInternalServerError, codeInternal}
+	}
+
+	err = audit(c, store.AuditEntry{Action: "license.add-user", Target: lic.ID, Customer: lic.Email(), Details: map[string]string{"user": email}})
+
+	if err != nil {
+		env.Errorf(c, "Could not record adding %v to %v in the audit log: %v", email, lic.ID, err)
+	}
+
+	return writeUsers(c, w, requestNamespace(c), lic)
+}
+
+// RemoveUser handles DELETE requests to /api/licenses/{id}/users
+//
+// It takes a user's seat of a named-user license, freeing it for someone
+// else. The response is the license's remaining users.
+//
+// Example:
+//
+//	DELETE /api/licenses/daS7y8sioiecYy/users
+//	{"email": "jane@example.com"}
+//	200 {"users": [...], "seats": {"used": 1, "allowed": 5}}
+func RemoveUser(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	lic, e := namedUserLicense(c, r)
+
+	if e != nil {
+		return e
+	}
+
+	email, e := decodeUserRequest(r)
+
+	if e != nil {
+		return e
+	}
+
+	err := env.Users(c, requestNamespace(c)).Remove(c, lic.ID, email)
+
+	if err == store.ErrNotFound {
+		return &appError{err, "The user doesn't have a seat of the license", http.StatusNotFound, codeSeatNotFound}
+	}
+
+	if err != nil {
+		return &appError{err, "An error occurred removing the user", http.StatusInternalServerError, codeInternal}
+	}
+
+	err = audit(c, store.AuditEntry{Action: "license.remove-user", Target: lic.ID, Customer: lic.Email(), Details: map[string]string{"user": email}})
+
+	if err != nil {
+		env.Errorf(c, "Could not record removing %v from %v in the audit log: %v", email, lic.ID, err)
+	}
+
+	return writeUsers(c, w, requestNamespace(c), lic)
+}
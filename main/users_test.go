@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/licensingtest"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// seatsResult is the users of a license and its seats.
+type seatsResult struct {
+	Users []store.User     `json:"users"`
+	Seats *activationUsage `json:"seats"`
+}
+
+// changeUser makes a users request for email, returning the status and the
+// users on success.
+func changeUser(t *testing.T, s *licensingtest.Server, method, id, email string) (int, *seatsResult) {
+	resp, err := s.Do(method, "/api/licenses/"+id+"/users", map[string]string{"email": email})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != 200 {
+		return resp.StatusCode, nil
+	}
+
+	var result seatsResult
+
+	if err := resp.Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, &result
+}
+
+func TestNamedUsers(t *testing.T) {
+	s, _ := newTestServer(t)
+	defer s.Close()
+
+	id := createLicenseWith(t, s, map[string]interface{}{"product": "domain_changer", "seats": 2})
+
+	tests := []struct {
+		name, method, email string
+		status, used        int
+	}{
+		{"add", "POST", "jane@example.com", 200, 1},
+		{"add again", "POST", " Jane@Example.com ", 200, 1},
+		{"add another", "POST", "john@example.com", 200, 2},
+		{"seats taken", "POST", "joe@example.com", 409, 0},
+		{"invalid email", "POST", "joe", 400, 0},
+		{"remove", "DELETE", "JANE@example.com", 200, 1},
+		{"remove again", "DELETE", "jane@example.com", 404, 0},
+		{"seat freed", "POST", "joe@example.com", 200, 2},
+	}
+
+	for _, test := range tests {
+		status, result := changeUser(t, s, test.method, id, test.email)
+
+		if status != test.status {
+			t.Errorf("%v: got %v, want %v", test.name, status, test.status)
+			continue
+		}
+
+		if result != nil && (len(result.Users) != test.used || result.Seats.Used != test.used || result.Seats.Allowed != 2) {
+			t.Errorf("%v: got %v users and seats %+v, want %v of 2 used", test.name, len(result.Users), result.Seats, test.used)
+		}
+	}
+
+	if status, _ := changeUser(t, s, "POST", createLicense(t, s), "jane@example.com"); status != 400 {
+		t.Errorf("got %v adding a user to a license without seats, want a 400", status)
+	}
+}
+
+func TestNamedUsersConcurrently(t *testing.T) {
+	s, p := newTestServer(t)
+	defer s.Close()
+
+	const seats, n = 3, 10
+	id := createLicenseWith(t, s, map[string]interface{}{"product": "domain_changer", "seats": seats})
+
+	var wg sync.WaitGroup
+	statuses := make(chan int, n)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			resp, err := s.Post("/api/licenses/"+id+"/users", map[string]string{"email": fmt.Sprintf("user%v@example.com", i)})
+
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			statuses <- resp.StatusCode
+		}(i)
+	}
+
+	wg.Wait()
+	close(statuses)
+
+	counts := make(map[int]int)
+
+	for status := range statuses {
+		counts[status]++
+	}
+
+	if counts[200] != seats || counts[409] != n-seats {
+		t.Errorf("got statuses %v, want %v 200s and %v 409s", counts, seats, n-seats)
+	}
+
+	if users, err := p.UserStore.List(context.Background(), id); err != nil || len(users) != seats {
+		t.Errorf("%v users, want %v: %v", len(users), seats, err)
+	}
+}
@@ -8,10 +8,45 @@ import (
 type response struct {
 	Status int         `json:"status"`
 	Result interface{} `json:"result"`
+
+	// Deduplicated is set when a create request returns the license that
+	// was already issued for its order.
+	Deduplicated bool `json:"deduplicated,omitempty"`
+
+	// Error and Code are the message and code of an error response.
+	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
+
+	// Field is the request field an error is about and Allowed the values
+	// it may have, if they are known.
+	Field   string   `json:"field,omitempty"`
+	Allowed []string `json:"allowed,omitempty"`
 }
 
 func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) error {
+	return writeResponse(w, response{Status: statusCode, Result: v})
+}
+
+// writeResponse writes a response, as an envelope on v2 routes.
+func writeResponse(w http.ResponseWriter, resp response) error {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(statusCode)
-	return json.NewEncoder(w).Encode(response{statusCode, v})
+	w.WriteHeader(resp.Status)
+
+	if vw, ok := w.(*v2Writer); ok && resp.Error == "" {
+		return json.NewEncoder(w).Encode(envelope{v2Data(resp.Result), vw.requestID, resp.Deduplicated})
+	}
+
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// writeError writes the response of an error, with its message and code and
+// the field it is about for a fieldError.
+func writeError(w http.ResponseWriter, e *appError) error {
+	resp := response{Status: e.Status, Error: e.Message, Code: e.Code}
+
+	if fe, ok := e.Error.(*fieldError); ok {
+		resp.Field, resp.Allowed = fe.Field, fe.Allowed
+	}
+
+	return writeResponse(w, resp)
 }
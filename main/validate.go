@@ -0,0 +1,575 @@
+package main
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/storage"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// Validation statuses.
+const (
+	statusValid    = "valid"
+	statusRevoked  = "revoked"
+	statusExpired  = "expired"
+	statusInvalid  = "invalid"
+	statusNotFound = "not_found"
+
+	// statusNotYetValid is for licenses used before their not before time,
+	// such as pre-orders before the release date.
+	statusNotYetValid = "not_yet_valid"
+
+	// statusRegionRestricted is for licenses used outside their regions,
+	// for products that enforce them.
+	statusRegionRestricted = "region_restricted"
+
+	// statusVersionNotCovered is for licenses used with a version of the
+	// product outside their minimum and maximum versions.
+	statusVersionNotCovered = "version_not_covered"
+
+	// statusProductNotCovered is for licenses used with a product they
+	// aren't for, neither their own nor one of their bundle's.
+	statusProductNotCovered = "product_not_covered"
+
+	// statusReissueRequired is for licenses signed with a compromised key,
+	// which must be replaced by a license signed with the current key.
+	statusReissueRequired = "reissue_required"
+
+	// statusSuperseded is for license strings that were replaced by a
+	// string with a higher serial, such as when the license is reissued.
+	statusSuperseded = "superseded"
+
+	// statusSuspended is for licenses of a product whose kill-switch is
+	// set to fail, and for licenses suspended for being shared.
+	statusSuspended = "suspended"
+
+	// statusUserNotLicensed is for named-user licenses used by someone who
+	// isn't one of their users.
+	statusUserNotLicensed = "user_not_licensed"
+)
+
+// maxBatchSize is the most licenses that can be validated in one request.
+const maxBatchSize = 100
+
+// batchParallelism is how many licenses of a batch are validated at once.
+const batchParallelism = 10
+
+// maxValidateRequestSize bounds the body of a request with one license, and
+// maxBatchRequestSize that of a batch, so that crafted requests can't make
+// the server read more than the largest licenses it decodes.
+const (
+	maxValidateRequestSize = license.MaxTokenSize + 4<<10
+	maxBatchRequestSize    = maxBatchSize*license.MaxTokenSize + 4<<10
+)
+
+// verdict is the result of validating a single license.
+type verdict struct {
+	ID        string     `json:"id,omitempty"`
+	Product   string     `json:"product,omitempty"`
+	Products  []string   `json:"products,omitempty"` // the bundle's
+	Status    string     `json:"status"`
+	Valid     bool       `json:"valid"`
+	Test      bool       `json:"test,omitempty"`
+	Legacy    bool       `json:"legacy,omitempty"` // in the previous system's format
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+	Error     string     `json:"error,omitempty"`
+
+	// DaysRemaining is the number of whole days until a license that
+	// hasn't expired does, so software can show "expires in 12 days".
+	DaysRemaining *int `json:"daysRemaining,omitempty"`
+
+	// InGrace is set for licenses in the grace period after expiring.
+	InGrace     bool       `json:"inGrace,omitempty"`
+	GraceEndsAt *time.Time `json:"graceEndsAt,omitempty"`
+
+	// RevokeAt is when a license looked up by ID is scheduled to be
+	// revoked, encoded licenses don't know.
+	RevokeAt *time.Time `json:"revokeAt,omitempty"`
+
+	Activations *activationUsage `json:"activations,omitempty"`
+
+	// Seats are the named users of a named-user license, and User the one
+	// that was checked if any.
+	Seats *activationUsage `json:"seats,omitempty"`
+	User  string           `json:"user,omitempty"`
+
+	// Country is where the request came from and OutsideRegions is set if
+	// the license isn't allowed there.
+	Country        string `json:"country,omitempty"`
+	OutsideRegions bool   `json:"outsideRegions,omitempty"`
+
+	// MinVersion and MaxVersion are the product versions the license
+	// covers, if it is limited.
+	MinVersion string `json:"minVersion,omitempty"`
+	MaxVersion string `json:"maxVersion,omitempty"`
+
+	// RevalidateAfter is when the software should validate the license
+	// again, until then it may rely on this verdict. It is never after the
+	// license expires or is revoked as scheduled, and is missing if the
+	// server leaves it to the software.
+	RevalidateAfter *time.Time `json:"revalidateAfter,omitempty"`
+
+	// Receipt is the verdict signed with the license's key along with the
+	// license's sequence number, if one was asked for (see
+	// license.Receipt).
+	Receipt string `json:"receipt,omitempty"`
+
+	// Emergency is the mode of the product's kill-switch if it is set,
+	// which overrides the status.
+	Emergency string `json:"emergency,omitempty"`
+}
+
+// activationUsage is how many of a license's activations are used, allowed
+// is zero if they are unlimited.
+type activationUsage struct {
+	Used    int `json:"used"`
+	Allowed int `json:"allowed"`
+}
+
+// validator validates licenses, sharing the keys and revocation list it loads
+// between them. It is safe for concurrent use and lives for one request.
+type validator struct {
+	c   context.Context
+	now time.Time
+
+	// country is the request's country, empty if it isn't known.
+	country string
+
+	// version is the product version the request is from, empty if it
+	// isn't known.
+	version string
+
+	// product is the product the request is from, empty if it isn't
+	// known.
+	product string
+
+	// receipts signs a receipt into each verdict.
+	receipts bool
+
+	// user is the email of the user of a named-user license, empty if it
+	// isn't known.
+	user string
+
+	// canary compares each verdict with the validation pipeline's (see
+	// ValidateLicenseCanary).
+	canary bool
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+
+	revokedOnce sync.Once
+	revoked     map[string]bool
+	revokedErr  error
+}
+
+func newValidator(c context.Context) *validator {
+	return &validator{
+		c:    c,
+		now:  time.Now(),
+		keys: make(map[string]*rsa.PublicKey),
+	}
+}
+
+func (v *validator) publicKey(kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+
+	key, err := getPublicKey(v.c, kid)
+
+	if err != nil {
+		return nil, err
+	}
+
+	v.keys[kid] = key
+	return key, nil
+}
+
+func (v *validator) isRevoked(id string) (bool, error) {
+	v.revokedOnce.Do(func() {
+		list, err := listRevocations(v.c)
+
+		if err != nil {
+			v.revokedErr = err
+			return
+		}
+
+		v.revoked = make(map[string]bool, len(list))
+
+		for _, rev := range list {
+			v.revoked[rev.ID] = true
+		}
+	})
+
+	return v.revoked[id], v.revokedErr
+}
+
+// storedLicense returns the stored copy of a verified license, or nil for
+// licenses that aren't stored, such as legacy ones. It knows what the string
+// can't, such as a higher serial the license was reissued with (which
+// supersedes the string) or a suspension.
+func (v *validator) storedLicense(lic *license.License) (*license.License, error) {
+	stored, err := env.Licenses(v.c, licenseNamespace(lic)).Get(v.c, lic.ID)
+
+	if err == store.ErrNotFound {
+		return nil, nil
+	}
+
+	return stored, err
+}
+
+// requestCountry returns the country App Engine located a request in, or an
+// empty string if it couldn't.
+func requestCountry(r *http.Request) string {
+	country := strings.ToUpper(r.Header.Get("X-AppEngine-Country"))
+
+	if country == "ZZ" {
+		return ""
+	}
+
+	return country
+}
+
+// parse verifies a license string with the key for its product.
+func (v *validator) parse(token string) (*license.License, error) {
+	lic, err := verifyLicense(token, v.publicKey)
+	countDecode(v.c, token, err)
+	return lic, err
+}
+
+// validate checks a license string, or a license ID if lookup is allowed.
+// Failures to load keys or revocations are returned as errors, everything
+// else is part of the verdict.
+func (v *validator) validate(input string, lookup bool) (*verdict, error) {
+	var lic *license.License
+
+	switch {
+	case strings.HasPrefix(input, "{"), strings.Count(input, ".") == 2, license.Format(input) == license.FormatV2:
+		var err error
+
+		// licenses signed with a compromised key are reported as such
+		if lic, err = verifySignature(input, v.publicKey); err == storage.ErrUnavailable {
+			return nil, err
+		}
+
+		countDecode(v.c, input, err)
+
+		if err != nil {
+			return &verdict{Status: statusInvalid, Error: err.Error()}, nil
+		}
+	case !lookup:
+		return &verdict{Status: statusInvalid, Error: "license IDs can only be validated with an API key"}, nil
+	default:
+		var err error
+
+		lic, err = env.Licenses(v.c, requestNamespace(v.c)).Get(v.c, input)
+
+		if err == store.ErrNotFound {
+			return &verdict{ID: input, Status: statusNotFound}, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return v.check(lic)
+}
+
+// check returns the verdict for a license that has been verified or looked
+// up.
+func (v *validator) check(lic *license.License) (*verdict, error) {
+	noteLicense(v.c, lic.ID)
+
+	vd := &verdict{
+		ID:        lic.ID,
+		Product:   lic.Product,
+		Products:  lic.Products,
+		Test:      lic.Test,
+		Legacy:    lic.Legacy,
+		ExpiresAt: lic.ExpiresAt,
+		NotBefore: lic.NotBefore,
+		RevokeAt:  lic.RevokeAt,
+	}
+
+	revoked, err := v.isRevoked(lic.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := v.storedLicense(lic)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case revoked:
+		vd.Status = statusRevoked
+	case stored != nil && stored.Serial > lic.Serial:
+		vd.Status = statusSuperseded
+	case stored != nil && stored.SuspendedAt != nil:
+		vd.Status = statusSuspended
+	case cfg.IsCompromised(lic.KeyID):
+		vd.Status = statusReissueRequired
+	case !lic.ValidAt(v.now):
+		vd.Status = statusNotYetValid
+	case lic.ExpiresAt != nil && !v.now.Before(*lic.ExpiresAt):
+		vd.Status = statusExpired
+
+		if graceEnds := lic.ExpiresAt.Add(cfg.GracePeriod(lic.Product)); v.now.Before(graceEnds) {
+			vd.InGrace = true
+			vd.GraceEndsAt = &graceEnds
+		}
+	default:
+		vd.Status = statusValid
+		vd.Valid = true
+
+		if lic.ExpiresAt != nil {
+			days := int(lic.ExpiresAt.Sub(v.now) / (24 * time.Hour))
+			vd.DaysRemaining = &days
+		}
+	}
+
+	activations, err := env.Activations(v.c, licenseNamespace(lic)).List(v.c, lic.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	vd.Activations = &activationUsage{len(activations), activationLimit(lic)}
+
+	// licenses used from an unknown country get the benefit of the doubt
+	if v.country != "" && len(lic.Regions) > 0 {
+		vd.Country = v.country
+		vd.OutsideRegions = !lic.AllowsRegion(v.country)
+
+		if vd.OutsideRegions && vd.Valid && cfg.Products[lic.Product].EnforceRegions {
+			vd.Status = statusRegionRestricted
+			vd.Valid = false
+			vd.DaysRemaining = nil
+		}
+	}
+
+	var users []store.User
+
+	if lic.Seats > 0 {
+		if users, err = env.Users(v.c, licenseNamespace(lic)).List(v.c, lic.ID); err != nil {
+			return nil, err
+		}
+
+		vd.Seats = &activationUsage{len(users), lic.Seats}
+
+		if v.user != "" {
+			vd.User = v.user
+			named := false
+
+			for _, u := range users {
+				named = named || u.Email == v.user
+			}
+
+			if !named && vd.Valid {
+				vd.Status = statusUserNotLicensed
+				vd.Valid = false
+				vd.DaysRemaining = nil
+			}
+		}
+	}
+
+	if v.product != "" && vd.Valid && !lic.Covers(v.product) {
+		vd.Status = statusProductNotCovered
+		vd.Valid = false
+		vd.DaysRemaining = nil
+	}
+
+	vd.MinVersion, vd.MaxVersion = lic.MinVersion, lic.MaxVersion
+
+	if v.version != "" && vd.Valid && !lic.AllowsVersion(v.version) {
+		vd.Status = statusVersionNotCovered
+		vd.Valid = false
+		vd.DaysRemaining = nil
+	}
+
+	if v.canary {
+		compareWithPipeline(v.c, &validationFacts{lic, v.now, stored, revoked, users, v.country, v.version, v.user, v.product}, vd)
+	}
+
+	applyKillSwitch(v.c, lic.Product, vd)
+
+	if !lic.Test {
+		countVolume(v.c, volumeValidated, lic.Product)
+	}
+
+	vd.RevalidateAfter = revalidateAfter(lic, v.now)
+
+	if v.receipts {
+		if vd.Receipt, err = signReceipt(v.c, lic, vd, v.now); err != nil {
+			return nil, err
+		}
+	}
+
+	return vd, nil
+}
+
+// revalidateAfter returns when software should next validate a license it
+// validated at now, nil if its product leaves that to the software.
+func revalidateAfter(lic *license.License, now time.Time) *time.Time {
+	d := cfg.RevalidateAfter(lic.Product)
+
+	if d == 0 {
+		return nil
+	}
+
+	at := now.Add(d)
+
+	for _, t := range []*time.Time{lic.ExpiresAt, lic.RevokeAt} {
+		if t != nil && t.After(now) && t.Before(at) {
+			at = *t
+		}
+	}
+
+	return &at
+}
+
+// ValidateLicense handles POST requests to /api/licenses/validate
+//
+// The request body is a JSON object with a license field holding either an
+// encoded license or, for API keys, a license ID. Licenses restricted to
+// regions are checked against the country of the request, and are only
+// reported as valid outside them if the product doesn't enforce regions.
+// With the version of the product the software is, licenses that don't cover
+// it have the status version_not_covered, and with the product it is, licenses
+// that are neither for it nor a bundle including it have the status
+// product_not_covered. Named-user licenses report their
+// seats, and with a user (an email) that isn't one of the license's users the
+// status is user_not_licensed. With receipt set the verdict has a
+// signed receipt, whose sequence number and time let offline software notice
+// its clock being rolled back. With the install's fingerprint its activation
+// is checked in, updating when it was last seen. Licenses that check in from
+// more installs or IP addresses than their product's sharing policy allows
+// are flagged, and suspended if the policy says to.
+//
+// Example:
+//
+//	POST /api/licenses/validate {"license": "eyJhbGciOiJSUzI1NiIs..."}
+//	200 {"id": "daS7y8sioiecYy", "product": "domain_changer", "status": "valid", "valid": true}
+func ValidateLicense(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	return validateLicense(c, w, r, false)
+}
+
+// validateLicense answers a request to validate one license, comparing its
+// verdict with the validation pipeline's if canary is set.
+func validateLicense(c context.Context, w http.ResponseWriter, r *http.Request, canary bool) *appError {
+	var req struct {
+		License string `json:"license"`
+		Version string `json:"version"`
+		Product string `json:"product"`
+		User    string `json:"user"`
+		Receipt bool   `json:"receipt"`
+
+		// Fingerprint is the install's, which checks in its activation.
+		Fingerprint string `json:"fingerprint"`
+	}
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxValidateRequestSize)).Decode(&req); err != nil {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	v := newValidator(c)
+	v.country = requestCountry(r)
+	v.version = strings.TrimSpace(req.Version)
+	v.product = strings.TrimSpace(req.Product)
+	v.user = normalizeEmail(req.User)
+	v.receipts = req.Receipt
+	v.canary = canary
+	vd, err := v.validate(strings.TrimSpace(req.License), isAuthenticated(c))
+
+	if err != nil {
+		return &appError{err, "An error occurred validating the license", http.StatusInternalServerError, codeInternal}
+	}
+
+	if vd.Status != statusInvalid && vd.Status != statusNotFound {
+		fingerprint := strings.TrimSpace(req.Fingerprint)
+
+		if fingerprint != "" {
+			checkIn(c, vd, fingerprint, v.version)
+		}
+
+		watchSharing(c, r, vd, fingerprint)
+	}
+
+	writeJSON(w, 200, vd)
+	return nil
+}
+
+// ValidateLicenseBatch handles POST requests to /api/licenses/validate-batch
+//
+// The request body is a JSON object with a licenses field holding up to 100
+// encoded licenses (or IDs, for API keys), and optionally the version and
+// product they are checked against. The response has a verdict for each, in the same
+// order.
+func ValidateLicenseBatch(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	var req struct {
+		Licenses []string `json:"licenses"`
+		Version  string   `json:"version"`
+		Product  string   `json:"product"`
+	}
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchRequestSize)).Decode(&req); err != nil {
+		return &appError{err, "Could not decode json request", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	if len(req.Licenses) > maxBatchSize {
+		return &appError{errors.New("batch too large"), "At most 100 licenses can be validated at once", http.StatusBadRequest, codeInvalidRequest}
+	}
+
+	v := newValidator(c)
+	v.country = requestCountry(r)
+	v.version = strings.TrimSpace(req.Version)
+	v.product = strings.TrimSpace(req.Product)
+	lookup := isAuthenticated(c)
+	verdicts := make([]*verdict, len(req.Licenses))
+	errs := make([]error, len(req.Licenses))
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, batchParallelism)
+
+	for i, input := range req.Licenses {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int, input string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			verdicts[i], errs[i] = v.validate(strings.TrimSpace(input), lookup)
+		}(i, input)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return &appError{err, "An error occurred validating the licenses", http.StatusInternalServerError, codeInternal}
+		}
+	}
+
+	writeJSON(w, 200, verdicts)
+	return nil
+}
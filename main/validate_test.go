@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/volcanicpixels/licensing/licensingtest"
+)
+
+// validateString returns the verdict for a license string or ID.
+func validateString(t *testing.T, s *licensingtest.Server, input string) *verdict {
+	resp, err := s.Post("/api/licenses/validate", map[string]string{"license": input})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v verdict
+
+	if err := resp.Decode(&v); err != nil {
+		t.Fatalf("validating: %v %s: %v", resp.StatusCode, resp.Body, err)
+	}
+
+	return &v
+}
+
+// reissueLicense reissues a license, returning the new string.
+func reissueLicense(t *testing.T, s *licensingtest.Server, id string, invalidate bool) string {
+	resp, err := s.Post("/api/licenses/"+id+"/reissue", map[string]bool{"invalidate": invalidate})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var licStr string
+
+	if err := resp.Decode(&licStr); err != nil {
+		t.Fatalf("reissuing %v: %v %s: %v", id, resp.StatusCode, resp.Body, err)
+	}
+
+	return licStr
+}
+
+func TestValidateSupersededSerial(t *testing.T) {
+	s, _ := newTestServer(t)
+	defer s.Close()
+
+	resp, err := s.Post("/api/licenses", map[string]string{"product": "domain_changer"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var first string
+
+	if err := resp.Decode(&first); err != nil {
+		t.Fatal(err)
+	}
+
+	id := validateString(t, s, first).ID
+
+	// reissuing without invalidating keeps the old string valid
+	same := reissueLicense(t, s, id, false)
+
+	for _, licStr := range []string{first, same} {
+		if v := validateString(t, s, licStr); v.Status != statusValid {
+			t.Errorf("status = %v, want %v before invalidating", v.Status, statusValid)
+		}
+	}
+
+	second := reissueLicense(t, s, id, true)
+	third := reissueLicense(t, s, id, true)
+
+	tests := []struct {
+		name, licStr, status string
+	}{
+		{"first string", first, statusSuperseded},
+		{"reissued string", same, statusSuperseded},
+		{"second serial", second, statusSuperseded},
+		{"latest serial", third, statusValid},
+		{"ID", id, statusValid},
+	}
+
+	for _, test := range tests {
+		if v := validateString(t, s, test.licStr); v.Status != test.status {
+			t.Errorf("%v: status = %v, want %v", test.name, v.Status, test.status)
+		}
+	}
+
+	// revocation comes first
+	if resp, err := s.Post("/api/licenses/"+id+"/revoke", nil); err != nil || resp.StatusCode != 200 {
+		t.Fatalf("revoking %v: %v", id, err)
+	}
+
+	if v := validateString(t, s, first); v.Status != statusRevoked {
+		t.Errorf("status = %v, want %v once revoked", v.Status, statusRevoked)
+	}
+}
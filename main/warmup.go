@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// warmupKeys returns the product and ID of each private key the instance
+// may sign with, the way they are cached.
+func warmupKeys() []keyCacheKey {
+	keys := []keyCacheKey{{"", cfg.Keys.ID}}
+
+	if cfg.AccessToken.Key != "" {
+		keys = append(keys, keyCacheKey{"", cfg.AccessToken.Key})
+	}
+
+	for name := range cfg.Products {
+		keys = append(keys, keyCacheKey{name, productKeyID(name)}, keyCacheKey{name, cfg.Keys.SandboxID})
+
+		if crossID := crossSignKeyID(name); crossID != "" {
+			keys = append(keys, keyCacheKey{name, crossID})
+		}
+	}
+
+	return keys
+}
+
+// Warmup handles GET requests to /_ah/warmup
+//
+// App Engine sends it to new instances before they get traffic, so that the
+// first request after scaling up doesn't pay for loading and parsing keys.
+// It loads the signing keys of every product into the key cache and reads
+// the revocation list once, which also opens the connections to storage and
+// the datastore. Failures are logged and don't stop the instance starting,
+// the requests that need what failed load it themselves.
+//
+// Example:
+//
+//	GET /_ah/warmup
+//	200 {"status": 200, "result": {"keys": 7, "revocations": 42, "errors": 0}}
+func Warmup(c context.Context, w http.ResponseWriter, r *http.Request) *appError {
+	start := time.Now()
+	result := struct {
+		Keys        int `json:"keys"`
+		Revocations int `json:"revocations"`
+		Errors      int `json:"errors"`
+	}{}
+
+	for _, k := range warmupKeys() {
+		if _, err := productPrivateKey(c, k.product, k.kid); err != nil {
+			env.Warningf(c, "Warmup could not load key %v: %v", k.kid, err)
+			result.Errors++
+			continue
+		}
+
+		result.Keys++
+	}
+
+	list, err := listRevocations(c)
+	result.Revocations = len(list)
+
+	if err != nil {
+		env.Warningf(c, "Warmup could not load the revocation list: %v", err)
+		result.Errors++
+	}
+
+	env.Infof(c, "Warmed up %v keys and %v revocations in %v with %v errors", result.Keys, result.Revocations, time.Since(start), result.Errors)
+	writeJSON(w, 200, result)
+	return nil
+}
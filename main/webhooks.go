@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+type webhookEvent struct {
+	Event string      `json:"event"`
+	Time  time.Time   `json:"time"`
+	Data  interface{} `json:"data"`
+}
+
+// notifyWebhooks posts an event to each of the configured webhook URLs. If a
+// webhook secret is configured the body is signed with HMAC-SHA256 in the
+// X-Licensing-Signature header. Failures are logged rather than returned since
+// by the time we notify the change has already happened.
+func notifyWebhooks(c context.Context, event string, data interface{}) {
+	if len(cfg.Webhooks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(webhookEvent{event, time.Now(), data})
+
+	if err != nil {
+		env.Errorf(c, "Could not marshal %v webhook: %v", event, err)
+		return
+	}
+
+	var signature string
+
+	if cfg.WebhookSecret != "" {
+		secret, err := getSecret(c, cfg.WebhookSecret)
+
+		if err != nil {
+			env.Errorf(c, "Could not load the webhook secret, not sending %v webhook: %v", event, err)
+			return
+		}
+
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body)
+		signature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	client := env.HTTPClient(c)
+
+	for _, url := range cfg.Webhooks {
+		req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+
+		if err != nil {
+			env.Errorf(c, "Could not create %v webhook request for %v: %v", event, url, err)
+			continue
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+
+		if signature != "" {
+			req.Header.Set("X-Licensing-Signature", signature)
+		}
+
+		resp, err := client.Do(req)
+
+		if err != nil {
+			env.Errorf(c, "Could not deliver %v webhook to %v: %v", event, url, err)
+			continue
+		}
+
+		resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			env.Errorf(c, "Webhook %v responded to %v with %v", url, event, resp.Status)
+		}
+	}
+}
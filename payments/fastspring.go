@@ -0,0 +1,130 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// VerifyFastSpring checks the X-FS-Signature header of a webhook, which is
+// the base64 encoded HMAC-SHA256 of the payload with the webhook's secret.
+func VerifyFastSpring(payload []byte, header string, secret []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(header))
+
+	if err != nil || len(sig) == 0 {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// fsOrder is the part of a FastSpring order that licenses need.
+type fsOrder struct {
+	ID       string          `json:"id"`
+	Order    string          `json:"order"`
+	Customer json.RawMessage `json:"customer"`
+	Items    []struct {
+		Product  string `json:"product"`
+		SKU      string `json:"sku"`
+		Quantity int    `json:"quantity"`
+	} `json:"items"`
+}
+
+// orderID returns the ID of an order, which webhooks have in order or, for
+// expanded orders, id.
+func (o *fsOrder) orderID() string {
+	if o.Order != "" {
+		return o.Order
+	}
+
+	return o.ID
+}
+
+// ParseFastSpring parses a FastSpring webhook that has been verified, which
+// holds a batch of events. A purchase is an order.completed event, with an
+// item for each unit of each item's SKU (or product path if it has none).
+// The customer's email and name are only known if the webhook expands the
+// customer. A return.created event is a chargeback if the return's type is
+// CHARGEBACK and a refund otherwise, of the original order. FastSpring
+// returns can be partial and revoke all the same.
+func ParseFastSpring(payload []byte) ([]*Event, error) {
+	var batch struct {
+		Events []struct {
+			ID   string          `json:"id"`
+			Type string          `json:"type"`
+			Live bool            `json:"live"`
+			Data json.RawMessage `json:"data"`
+		} `json:"events"`
+	}
+
+	if err := json.Unmarshal(payload, &batch); err != nil {
+		return nil, err
+	}
+
+	events := make([]*Event, 0, len(batch.Events))
+
+	for _, fe := range batch.Events {
+		e := &Event{ID: fe.ID, Live: fe.Live}
+
+		switch fe.Type {
+		case "order.completed":
+			var o fsOrder
+
+			if err := json.Unmarshal(fe.Data, &o); err != nil {
+				return nil, err
+			}
+
+			e.Kind, e.ChargeID = Purchase, o.orderID()
+
+			var customer struct {
+				First string `json:"first"`
+				Last  string `json:"last"`
+				Email string `json:"email"`
+			}
+
+			// unexpanded customers are just an ID
+			if json.Unmarshal(o.Customer, &customer) == nil {
+				e.Email = customer.Email
+				e.Name = strings.TrimSpace(customer.First + " " + customer.Last)
+			}
+
+			for _, item := range o.Items {
+				sku := item.SKU
+
+				if sku == "" {
+					sku = item.Product
+				}
+
+				e.Items = appendItems(e.Items, sku, item.Quantity)
+			}
+		case "return.created":
+			var ret struct {
+				Type     string  `json:"type"`
+				Original fsOrder `json:"original"`
+			}
+
+			if err := json.Unmarshal(fe.Data, &ret); err != nil {
+				return nil, err
+			}
+
+			e.Kind, e.ChargeID = Refund, ret.Original.orderID()
+
+			if strings.EqualFold(ret.Type, "chargeback") {
+				e.Kind = Chargeback
+			}
+		}
+
+		events = append(events, e)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,84 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func TestVerifyFastSpring(t *testing.T) {
+	payload := []byte(`{"events": []}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(payload)
+	valid := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name    string
+		payload []byte
+		header  string
+		ok      bool
+	}{
+		{"valid", payload, valid, true},
+		{"surrounding space", payload, " " + valid + "\n", true},
+		{"changed payload", []byte(`{"events": [{}]}`), valid, false},
+		{"hex instead of base64", payload, "00ff", false},
+		{"not base64", payload, "%%%", false},
+		{"empty", payload, "", false},
+	}
+
+	for _, test := range tests {
+		err := VerifyFastSpring(test.payload, test.header, []byte("secret"))
+
+		if test.ok && err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		}
+
+		if !test.ok && err != ErrInvalidSignature {
+			t.Errorf("%v: got %v, want ErrInvalidSignature", test.name, err)
+		}
+	}
+
+	if err := VerifyFastSpring(payload, valid, []byte("other")); err != ErrInvalidSignature {
+		t.Errorf("got %v with another secret, want ErrInvalidSignature", err)
+	}
+}
+
+func TestParseFastSpring(t *testing.T) {
+	payload := `{"events": [
+		{"id": "e1", "type": "order.completed", "live": true, "data": {"order": "o1", "customer": {"first": "Jane", "last": "Doe", "email": "jane@example.com"}, "items": [{"product": "pro-path", "sku": "pro", "quantity": 2}, {"product": "basic-path", "quantity": 0}]}},
+		{"id": "e2", "type": "order.completed", "data": {"id": "o2", "customer": "cus_1", "items": [{"sku": "pro", "quantity": 1}]}},
+		{"id": "e3", "type": "return.created", "live": true, "data": {"type": "REFUND", "original": {"order": "o1"}}},
+		{"id": "e4", "type": "return.created", "live": true, "data": {"type": "CHARGEBACK", "original": {"id": "o2"}}},
+		{"id": "e5", "type": "subscription.activated", "live": true, "data": {}}
+	]}`
+
+	events, err := ParseFastSpring([]byte(payload))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*Event{
+		{ID: "e1", Kind: Purchase, ChargeID: "o1", Live: true, Items: []string{"pro", "pro", "basic-path"}, Email: "jane@example.com", Name: "Jane Doe"},
+		{ID: "e2", Kind: Purchase, ChargeID: "o2", Items: []string{"pro"}},
+		{ID: "e3", Kind: Refund, ChargeID: "o1", Live: true},
+		{ID: "e4", Kind: Chargeback, ChargeID: "o2", Live: true},
+		{ID: "e5", Live: true},
+	}
+
+	if len(events) != len(want) {
+		t.Fatalf("%v events, want %v", len(events), len(want))
+	}
+
+	for i, e := range events {
+		if !reflect.DeepEqual(e, want[i]) {
+			t.Errorf("event %v = %+v, want %+v", i, e, want[i])
+		}
+	}
+
+	if _, err := ParseFastSpring([]byte(`{"events": [{"type": "order.completed", "data": []}]}`)); err == nil {
+		t.Error("parsed an order that isn't an object")
+	}
+}
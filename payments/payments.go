@@ -0,0 +1,78 @@
+// Package payments parses the webhook events of payment providers into the
+// few kinds that affect licenses.
+package payments
+
+import "errors"
+
+// ErrInvalidSignature is returned for webhooks that aren't signed by the
+// provider.
+var ErrInvalidSignature = errors.New("payments: invalid webhook signature")
+
+// Kinds of event.
+const (
+	// Purchase is a completed payment for items that licenses are issued
+	// for.
+	Purchase = "purchase"
+
+	// Refund is a payment that has been refunded in full.
+	Refund = "refund"
+
+	// Chargeback is a payment that the customer has disputed with their
+	// bank.
+	Chargeback = "chargeback"
+)
+
+// Event is a webhook event from a payment provider.
+type Event struct {
+	// ID is the provider's ID of the event, so that retried deliveries can
+	// be recognized.
+	ID string
+
+	// Kind is Purchase, Refund or Chargeback, or empty for events that
+	// don't affect licenses.
+	Kind string
+
+	// ChargeID is the provider's ID of the payment, which licenses have in
+	// their chargeId attribute.
+	ChargeID string
+
+	// Live is false for events from the provider's test mode.
+	Live bool
+
+	// Items are the provider's IDs of the items bought in a purchase, once
+	// for each license to issue.
+	Items []string
+
+	// Underpaid are the items of a purchase that weren't paid their price
+	// for, once for each unit, no licenses are issued for them.
+	Underpaid []string
+
+	// Email and Name are the customer's in a purchase.
+	Email string
+	Name  string
+}
+
+// maxQuantity is the most licenses issued for one item of a purchase.
+const maxQuantity = 100
+
+// appendItems appends an item once for each unit of its quantity, at least
+// one and at most maxQuantity.
+func appendItems(items []string, item string, quantity int) []string {
+	if item == "" {
+		return items
+	}
+
+	if quantity < 1 {
+		quantity = 1
+	}
+
+	if quantity > maxQuantity {
+		quantity = maxQuantity
+	}
+
+	for i := 0; i < quantity; i++ {
+		items = append(items, item)
+	}
+
+	return items
+}
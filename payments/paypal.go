@@ -0,0 +1,156 @@
+package payments
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The endpoints PayPal IPN messages are verified with.
+const (
+	PayPalVerifyURL        = "https://ipnpb.paypal.com/cgi-bin/webscr"
+	PayPalSandboxVerifyURL = "https://ipnpb.sandbox.paypal.com/cgi-bin/webscr"
+)
+
+// VerifyPayPal checks that an Instant Payment Notification came from PayPal
+// by posting it back, as PayPal requires, to the live or sandbox endpoint
+// depending on test_ipn. PayPal answers VERIFIED, anything else is
+// ErrInvalidSignature and failures to ask are other errors.
+func VerifyPayPal(client *http.Client, payload []byte) error {
+	values, err := url.ParseQuery(string(payload))
+
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	endpoint := PayPalVerifyURL
+
+	if values.Get("test_ipn") == "1" {
+		endpoint = PayPalSandboxVerifyURL
+	}
+
+	body := append([]byte("cmd=_notify-validate&"), payload...)
+	resp, err := client.Post(endpoint, "application/x-www-form-urlencoded", bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("payments: PayPal responded with %v", resp.Status)
+	}
+
+	answer, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return err
+	}
+
+	if strings.TrimSpace(string(answer)) != "VERIFIED" {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// Price is what one unit of an item costs, Amount is a decimal such as
+// "49.00" in the Currency, e.g. "USD".
+type Price struct {
+	Amount   string
+	Currency string
+}
+
+// cents parses an amount in hundredths of the currency's unit.
+func cents(amount string) (int64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(amount), 64)
+
+	if err != nil || f < 0 || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("payments: invalid amount %q", amount)
+	}
+
+	return int64(math.Round(f * 100)), nil
+}
+
+// paidFor reports whether gross in currency covers quantity units at price.
+// The gross of a payment may include tax and shipping, so it can be more.
+func paidFor(price Price, gross, currency string, quantity int) bool {
+	want, err := cents(price.Amount)
+
+	if err != nil || currency != price.Currency {
+		return false
+	}
+
+	paid, err := cents(gross)
+	return err == nil && paid >= want*int64(quantity)
+}
+
+// ParsePayPal parses an IPN message that has been verified. A purchase is a
+// Completed payment, with an item for each unit of each item_number. A
+// refund is a Refunded payment and a chargeback a Reversed one, both of the
+// parent_txn_id. PayPal doesn't say whether a refund is in full, so partial
+// refunds are refunds too. Messages for accounts other than the receiver
+// (if set) don't affect licenses.
+//
+// IPN messages come from buttons that buyers can change, so items with a
+// price are only included when their mc_gross and mc_currency pay for every
+// unit, otherwise they are in Underpaid.
+func ParsePayPal(payload []byte, receiver string, prices map[string]Price) (*Event, error) {
+	v, err := url.ParseQuery(string(payload))
+
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Event{ID: v.Get("ipn_track_id"), Live: v.Get("test_ipn") != "1"}
+
+	if e.ID == "" {
+		e.ID = v.Get("txn_id")
+	}
+
+	if receiver != "" && !strings.EqualFold(v.Get("receiver_email"), receiver) && !strings.EqualFold(v.Get("business"), receiver) {
+		return e, nil
+	}
+
+	switch v.Get("payment_status") {
+	case "Completed":
+		e.Kind, e.ChargeID = Purchase, v.Get("txn_id")
+		e.Email = v.Get("payer_email")
+		e.Name = strings.TrimSpace(v.Get("first_name") + " " + v.Get("last_name"))
+
+		currency := v.Get("mc_currency")
+
+		// the gross of a cart item is for all of its units
+		add := func(item, quantity, gross string) {
+			n, _ := strconv.Atoi(quantity)
+			units := appendItems(nil, item, n)
+
+			if price, ok := prices[item]; ok && !paidFor(price, gross, currency, len(units)) {
+				e.Underpaid = append(e.Underpaid, units...)
+				return
+			}
+
+			e.Items = append(e.Items, units...)
+		}
+
+		if n, _ := strconv.Atoi(v.Get("num_cart_items")); n > 0 {
+			for i := 1; i <= n; i++ {
+				add(v.Get(fmt.Sprintf("item_number%v", i)), v.Get(fmt.Sprintf("quantity%v", i)), v.Get(fmt.Sprintf("mc_gross_%v", i)))
+			}
+		} else {
+			add(v.Get("item_number"), v.Get("quantity"), v.Get("mc_gross"))
+		}
+	case "Refunded":
+		e.Kind, e.ChargeID = Refund, v.Get("parent_txn_id")
+	case "Reversed":
+		e.Kind, e.ChargeID = Chargeback, v.Get("parent_txn_id")
+	}
+
+	return e, nil
+}
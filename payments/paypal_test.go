@@ -0,0 +1,168 @@
+package payments
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// roundTripper answers requests with a function instead of the network.
+type roundTripper func(r *http.Request) (*http.Response, error)
+
+func (rt roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return rt(r)
+}
+
+// payPal returns a client that PayPal answers with status and answer,
+// recording the URL and body of the request.
+func payPal(status int, answer string, url, body *string) *http.Client {
+	return &http.Client{Transport: roundTripper(func(r *http.Request) (*http.Response, error) {
+		b, err := ioutil.ReadAll(r.Body)
+
+		if err != nil {
+			return nil, err
+		}
+
+		*url, *body = r.URL.String(), string(b)
+		return &http.Response{StatusCode: status, Body: ioutil.NopCloser(strings.NewReader(answer)), Request: r}, nil
+	})}
+}
+
+func TestVerifyPayPal(t *testing.T) {
+	tests := []struct {
+		name, payload string
+		status        int
+		answer        string
+		endpoint      string
+		err           error
+	}{
+		{"verified", "txn_id=1&payment_status=Completed", 200, "VERIFIED", PayPalVerifyURL, nil},
+		{"verified with a newline", "txn_id=1", 200, "VERIFIED\n", PayPalVerifyURL, nil},
+		{"sandbox", "txn_id=1&test_ipn=1", 200, "VERIFIED", PayPalSandboxVerifyURL, nil},
+		{"invalid", "txn_id=1", 200, "INVALID", PayPalVerifyURL, ErrInvalidSignature},
+	}
+
+	for _, test := range tests {
+		var url, body string
+		err := VerifyPayPal(payPal(test.status, test.answer, &url, &body), []byte(test.payload))
+
+		if err != test.err {
+			t.Errorf("%v: got %v, want %v", test.name, err, test.err)
+		}
+
+		if url != test.endpoint {
+			t.Errorf("%v: verified with %v, want %v", test.name, url, test.endpoint)
+		}
+
+		// PayPal needs the message back as it was sent
+		if body != "cmd=_notify-validate&"+test.payload {
+			t.Errorf("%v: posted back %q", test.name, body)
+		}
+	}
+
+	var url, body string
+
+	if err := VerifyPayPal(payPal(500, "", &url, &body), []byte("txn_id=1")); err == nil || err == ErrInvalidSignature {
+		t.Errorf("got %v when PayPal fails, want another error", err)
+	}
+
+	if err := VerifyPayPal(payPal(200, "VERIFIED", &url, &body), []byte("%zz")); err != ErrInvalidSignature {
+		t.Errorf("got %v for a malformed message, want ErrInvalidSignature", err)
+	}
+}
+
+func TestParsePayPal(t *testing.T) {
+	prices := map[string]Price{
+		"pro":    {Amount: "49.00", Currency: "USD"},
+		"agency": {Amount: "199.99", Currency: "USD"},
+	}
+
+	tests := []struct {
+		name, payload    string
+		kind, chargeID   string
+		items, underpaid []string
+	}{
+		{"paid", "txn_id=T1&payment_status=Completed&item_number=pro&quantity=1&mc_gross=49.00&mc_currency=USD", Purchase, "T1", []string{"pro"}, nil},
+		{"paid with tax", "txn_id=T1&payment_status=Completed&item_number=pro&mc_gross=58.80&mc_currency=USD", Purchase, "T1", []string{"pro"}, nil},
+		{"underpaid", "txn_id=T1&payment_status=Completed&item_number=pro&quantity=1&mc_gross=0.01&mc_currency=USD", Purchase, "T1", nil, []string{"pro"}},
+		{"a cent short", "txn_id=T1&payment_status=Completed&item_number=agency&mc_gross=199.98&mc_currency=USD", Purchase, "T1", nil, []string{"agency"}},
+		{"underpaid units", "txn_id=T1&payment_status=Completed&item_number=pro&quantity=3&mc_gross=98.00&mc_currency=USD", Purchase, "T1", nil, []string{"pro", "pro", "pro"}},
+		{"other currency", "txn_id=T1&payment_status=Completed&item_number=pro&mc_gross=49.00&mc_currency=JPY", Purchase, "T1", nil, []string{"pro"}},
+		{"no gross", "txn_id=T1&payment_status=Completed&item_number=pro&mc_currency=USD", Purchase, "T1", nil, []string{"pro"}},
+		{"negative gross", "txn_id=T1&payment_status=Completed&item_number=pro&mc_gross=-49.00&mc_currency=USD", Purchase, "T1", nil, []string{"pro"}},
+		{"no price", "txn_id=T1&payment_status=Completed&item_number=basic&quantity=2&mc_gross=0.00&mc_currency=USD", Purchase, "T1", []string{"basic", "basic"}, nil},
+		{
+			"cart",
+			"txn_id=T2&payment_status=Completed&num_cart_items=2&item_number1=pro&quantity1=2&mc_gross_1=98.00&item_number2=agency&quantity2=1&mc_gross_2=10.00&mc_currency=USD",
+			Purchase, "T2", []string{"pro", "pro"}, []string{"agency"},
+		},
+		{"refund", "txn_id=T3&parent_txn_id=T1&payment_status=Refunded", Refund, "T1", nil, nil},
+		{"reversal", "txn_id=T4&parent_txn_id=T1&payment_status=Reversed", Chargeback, "T1", nil, nil},
+		{"pending", "txn_id=T5&payment_status=Pending&item_number=pro", "", "", nil, nil},
+	}
+
+	for _, test := range tests {
+		e, err := ParsePayPal([]byte(test.payload), "", prices)
+
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+
+		if e.Kind != test.kind || e.ChargeID != test.chargeID {
+			t.Errorf("%v: got a %q of %q, want a %q of %q", test.name, e.Kind, e.ChargeID, test.kind, test.chargeID)
+		}
+
+		if !reflect.DeepEqual(e.Items, test.items) || !reflect.DeepEqual(e.Underpaid, test.underpaid) {
+			t.Errorf("%v: got items %v and underpaid %v, want %v and %v", test.name, e.Items, e.Underpaid, test.items, test.underpaid)
+		}
+	}
+}
+
+func TestParsePayPalReceiver(t *testing.T) {
+	tests := []struct {
+		name, fields string
+		purchase     bool
+	}{
+		{"receiver", "receiver_email=sales@example.com", true},
+		{"receiver in another case", "receiver_email=Sales@Example.com", true},
+		{"business", "receiver_email=merchant@example.com&business=sales@example.com", true},
+		{"other account", "receiver_email=merchant@example.com&business=merchant@example.com", false},
+		{"no receiver", "", false},
+	}
+
+	for _, test := range tests {
+		e, err := ParsePayPal([]byte("txn_id=T1&payment_status=Completed&item_number=pro&"+test.fields), "sales@example.com", nil)
+
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+
+		if purchase := e.Kind == Purchase; purchase != test.purchase {
+			t.Errorf("%v: got a %q, want a purchase %v", test.name, e.Kind, test.purchase)
+		}
+	}
+}
+
+func TestParsePayPalEvent(t *testing.T) {
+	e, err := ParsePayPal([]byte("txn_id=T1&ipn_track_id=I1&test_ipn=1&payment_status=Completed&item_number=pro&quantity=1000&payer_email=jane@example.com&first_name=Jane&last_name=Doe"), "", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.ID != "I1" || e.Live || e.Email != "jane@example.com" || e.Name != "Jane Doe" {
+		t.Errorf("got %+v", e)
+	}
+
+	if len(e.Items) != maxQuantity {
+		t.Errorf("%v items, want at most %v", len(e.Items), maxQuantity)
+	}
+
+	if e, _ := ParsePayPal([]byte("txn_id=T1&payment_status=Refunded"), "", nil); e.ID != "T1" || !e.Live {
+		t.Errorf("got %+v, want the transaction ID and live", e)
+	}
+}
@@ -0,0 +1,98 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StripeTolerance is how old a Stripe webhook's timestamp may be, older
+// webhooks are rejected in case they are being replayed.
+const StripeTolerance = 5 * time.Minute
+
+// VerifyStripe checks the Stripe-Signature header of a webhook, which has
+// the time it was sent and HMAC-SHA256 signatures of "<time>.<payload>" with
+// the endpoint's signing secret.
+func VerifyStripe(payload []byte, header string, secret []byte, now time.Time) error {
+	var timestamp string
+	var signatures []string
+
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			signatures = append(signatures, kv[1])
+		}
+	}
+
+	t, err := strconv.ParseInt(timestamp, 10, 64)
+
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	if d := now.Sub(time.Unix(t, 0)); d > StripeTolerance || d < -StripeTolerance {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+
+	for _, sig := range signatures {
+		if b, err := hex.DecodeString(sig); err == nil && hmac.Equal(b, expected) {
+			return nil
+		}
+	}
+
+	return ErrInvalidSignature
+}
+
+// ParseStripe parses a Stripe webhook that has been verified. A refund is a
+// charge.refunded event for a charge that is refunded in full, partial
+// refunds don't affect the license. A chargeback is a
+// charge.dispute.created event.
+func ParseStripe(payload []byte) (*Event, error) {
+	var se struct {
+		ID       string `json:"id"`
+		Type     string `json:"type"`
+		Livemode bool   `json:"livemode"`
+		Data     struct {
+			Object struct {
+				ID       string `json:"id"`
+				Charge   string `json:"charge"`
+				Refunded bool   `json:"refunded"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+
+	if err := json.Unmarshal(payload, &se); err != nil {
+		return nil, err
+	}
+
+	e := &Event{ID: se.ID, Live: se.Livemode}
+	obj := se.Data.Object
+
+	switch se.Type {
+	case "charge.refunded":
+		if obj.Refunded {
+			e.Kind, e.ChargeID = Refund, obj.ID
+		}
+	case "charge.dispute.created":
+		e.Kind, e.ChargeID = Chargeback, obj.Charge
+	}
+
+	return e, nil
+}
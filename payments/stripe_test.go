@@ -0,0 +1,89 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// stripeSignature returns a Stripe-Signature header for a payload sent at t.
+func stripeSignature(payload []byte, secret string, t time.Time) string {
+	timestamp := fmt.Sprint(t.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyStripe(t *testing.T) {
+	payload := []byte(`{"id": "evt_1", "type": "charge.refunded"}`)
+	now := time.Unix(1700000000, 0)
+	valid := stripeSignature(payload, "whsec", now)
+
+	tests := []struct {
+		name    string
+		payload []byte
+		header  string
+		ok      bool
+	}{
+		{"valid", payload, valid, true},
+		{"one of several signatures", payload, valid + ",v1=00ff", true},
+		{"rolled secret", payload, stripeSignature(payload, "old", now) + "," + valid[len("t=1700000000,"):], true},
+		{"within the tolerance", payload, stripeSignature(payload, "whsec", now.Add(-StripeTolerance)), true},
+		{"wrong secret", payload, stripeSignature(payload, "other", now), false},
+		{"changed payload", []byte(`{"id": "evt_2", "type": "charge.refunded"}`), valid, false},
+		{"replayed", payload, stripeSignature(payload, "whsec", now.Add(-StripeTolerance-time.Second)), false},
+		{"from the future", payload, stripeSignature(payload, "whsec", now.Add(StripeTolerance+time.Second)), false},
+		{"no signature", payload, "t=1700000000", false},
+		{"no timestamp", payload, valid[len("t=1700000000,"):], false},
+		{"v0 signature", payload, "t=1700000000,v0=" + valid[len("t=1700000000,v1="):], false},
+		{"empty", payload, "", false},
+		{"not hex", payload, "t=1700000000,v1=zz", false},
+	}
+
+	for _, test := range tests {
+		err := VerifyStripe(test.payload, test.header, []byte("whsec"), now)
+
+		if test.ok && err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		}
+
+		if !test.ok && err != ErrInvalidSignature {
+			t.Errorf("%v: got %v, want ErrInvalidSignature", test.name, err)
+		}
+	}
+}
+
+func TestParseStripe(t *testing.T) {
+	tests := []struct {
+		name, payload  string
+		kind, chargeID string
+		live           bool
+	}{
+		{"full refund", `{"id": "evt_1", "type": "charge.refunded", "livemode": true, "data": {"object": {"id": "ch_1", "refunded": true}}}`, Refund, "ch_1", true},
+		{"partial refund", `{"id": "evt_2", "type": "charge.refunded", "livemode": true, "data": {"object": {"id": "ch_1", "refunded": false}}}`, "", "", true},
+		{"dispute", `{"id": "evt_3", "type": "charge.dispute.created", "livemode": true, "data": {"object": {"id": "dp_1", "charge": "ch_1"}}}`, Chargeback, "ch_1", true},
+		{"test mode", `{"id": "evt_4", "type": "charge.dispute.created", "data": {"object": {"id": "dp_1", "charge": "ch_1"}}}`, Chargeback, "ch_1", false},
+		{"other event", `{"id": "evt_5", "type": "charge.succeeded", "livemode": true, "data": {"object": {"id": "ch_1"}}}`, "", "", true},
+	}
+
+	for _, test := range tests {
+		e, err := ParseStripe([]byte(test.payload))
+
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+
+		if e.Kind != test.kind || e.ChargeID != test.chargeID || e.Live != test.live {
+			t.Errorf("%v: got %+v, want a %q of %q, live %v", test.name, e, test.kind, test.chargeID, test.live)
+		}
+	}
+
+	if _, err := ParseStripe([]byte("not json")); err == nil {
+		t.Error("parsed a payload that isn't JSON")
+	}
+}
@@ -0,0 +1,243 @@
+// Package pdf writes simple PDF documents: pages of text in the standard
+// fonts, which viewers have built in, and filled rectangles.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A4Width and A4Height are the size of an A4 page in points.
+const (
+	A4Width  = 595.28
+	A4Height = 841.89
+)
+
+// Font is one of the standard fonts.
+type Font int
+
+// The standard fonts that can be used, they aren't embedded.
+const (
+	Helvetica Font = iota
+	HelveticaBold
+	Courier
+)
+
+var fontNames = []string{
+	Helvetica:     "Helvetica",
+	HelveticaBold: "Helvetica-Bold",
+	Courier:       "Courier",
+}
+
+// Document is a PDF document being written.
+type Document struct {
+	Title string
+
+	pages []*Page
+}
+
+// Page is a page of a document, coordinates are in points from its bottom
+// left corner.
+type Page struct {
+	Width, Height float64
+
+	content bytes.Buffer
+}
+
+// AddPage adds a blank page to the end of the document.
+func (d *Document) AddPage(width, height float64) *Page {
+	p := &Page{Width: width, Height: height}
+	d.pages = append(d.pages, p)
+
+	return p
+}
+
+// SetColor sets the colour that text and rectangles are filled with, each
+// component is from 0 to 1.
+func (p *Page) SetColor(r, g, b float64) {
+	fmt.Fprintf(&p.content, "%v %v %v rg\n", num(r), num(g), num(b))
+}
+
+// Rect fills a rectangle whose bottom left corner is x, y.
+func (p *Page) Rect(x, y, width, height float64) {
+	fmt.Fprintf(&p.content, "%v %v %v %v re f\n", num(x), num(y), num(width), num(height))
+}
+
+// Text draws a line of text starting at x on the baseline y. Characters
+// that the fonts' encoding (Windows-1252) doesn't have are drawn as "?".
+func (p *Page) Text(font Font, size, x, y float64, s string) {
+	fmt.Fprintf(&p.content, "BT /F%v %v Tf %v %v Td (%s) Tj ET\n", int(font), num(size), num(x), num(y), encodeText(s))
+}
+
+// CenteredText draws a line of text centred on x.
+func (p *Page) CenteredText(font Font, size, x, y float64, s string) {
+	p.Text(font, size, x-TextWidth(font, size, s)/2, y, s)
+}
+
+// TextWidth returns the width of a line of text, as the viewer draws it.
+func TextWidth(font Font, size float64, s string) float64 {
+	width := 0
+
+	for _, r := range s {
+		width += charWidth(font, r)
+	}
+
+	return float64(width) * size / 1000
+}
+
+// WriteTo writes the document, with the content of each page compressed.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	var offsets []int
+
+	// objects are numbered from 1 in the order they are written
+	object := func(format string, args ...interface{}) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%v 0 obj\n", len(offsets))
+		fmt.Fprintf(&buf, format, args...)
+		buf.WriteString("\nendobj\n")
+	}
+
+	// the catalog, the page tree, the info dictionary, the fonts, then each
+	// page and its content
+	const firstFont = 4
+	firstPage := firstFont + len(fontNames)
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+
+	kids := make([]string, len(d.pages))
+
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%v 0 R", firstPage+i*2)
+	}
+
+	object("<< /Type /Pages /Kids [%v] /Count %v >>", strings.Join(kids, " "), len(d.pages))
+	object("<< /Title (%s) /Producer (licensing) >>", encodeText(d.Title))
+
+	fonts := make([]string, len(fontNames))
+
+	for i, name := range fontNames {
+		object("<< /Type /Font /Subtype /Type1 /BaseFont /%v /Encoding /WinAnsiEncoding >>", name)
+		fonts[i] = fmt.Sprintf("/F%v %v 0 R", i, firstFont+i)
+	}
+
+	for i, p := range d.pages {
+		object("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %v %v] /Resources << /Font << %v >> >> /Contents %v 0 R >>",
+			num(p.Width), num(p.Height), strings.Join(fonts, " "), firstPage+i*2+1)
+
+		var content bytes.Buffer
+		zw := zlib.NewWriter(&content)
+
+		if _, err := zw.Write(p.content.Bytes()); err != nil {
+			return 0, err
+		}
+
+		if err := zw.Close(); err != nil {
+			return 0, err
+		}
+
+		object("<< /Length %v /Filter /FlateDecode >>\nstream\n%s\nendstream", content.Len(), content.Bytes())
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %v\n0000000000 65535 f \n", len(offsets)+1)
+
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+
+	fmt.Fprintf(&buf, "trailer\n<< /Size %v /Root 1 0 R /Info 3 0 R >>\nstartxref\n%v\n%%%%EOF\n", len(offsets)+1, xref)
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// num formats a number with at most two decimal places.
+func num(f float64) string {
+	s := strconv.FormatFloat(f, 'f', 2, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+
+	if s == "-0" {
+		return "0"
+	}
+
+	return s
+}
+
+// encodeText encodes s as the bytes of a PDF string in Windows-1252, escaping
+// the characters that are special in strings.
+func encodeText(s string) []byte {
+	var b []byte
+
+	for _, r := range s {
+		c, ok := winAnsi(r)
+
+		if !ok {
+			c = '?'
+		}
+
+		switch c {
+		case '\\', '(', ')':
+			b = append(b, '\\', c)
+		default:
+			b = append(b, c)
+		}
+	}
+
+	return b
+}
+
+// winAnsi returns the Windows-1252 code of a character, it has ASCII and
+// the Latin-1 letters and symbols, which are all a certificate needs.
+func winAnsi(r rune) (byte, bool) {
+	if r >= 0x20 && r < 0x7f || r >= 0xa0 && r <= 0xff {
+		return byte(r), true
+	}
+
+	return 0, false
+}
+
+// charWidth is the width of a character in thousandths of the font size,
+// from the fonts' metrics. Non-ASCII characters are given the width of a
+// digit, which is close enough to centre text.
+func charWidth(font Font, r rune) int {
+	if font == Courier {
+		return 600
+	}
+
+	widths := helveticaWidths
+
+	if font == HelveticaBold {
+		widths = helveticaBoldWidths
+	}
+
+	if r >= 0x20 && r < 0x7f {
+		return widths[r-0x20]
+	}
+
+	return 556
+}
+
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
@@ -0,0 +1,201 @@
+// Package pii encrypts customers' personal data before it is stored, using
+// data keys that are themselves encrypted (wrapped) by a Cloud KMS key.
+package pii
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// Scope is the OAuth scope needed to unwrap keys with Cloud KMS.
+const Scope = "https://www.googleapis.com/auth/cloudkms"
+
+// version prefixes ciphertexts so that the format can change.
+const version = 1
+
+// Cipher encrypts values with AES-256-GCM and computes blind indexes, keyed
+// hashes that let encrypted values be searched for by exact match.
+type Cipher struct {
+	aead  cipher.AEAD
+	index []byte
+}
+
+// NewCipher returns a Cipher using a 32 byte data key for encryption and a
+// separate key for blind indexes.
+func NewCipher(dataKey, indexKey []byte) (*Cipher, error) {
+	if len(dataKey) != 32 || len(indexKey) < 32 {
+		return nil, errors.New("pii: keys must be at least 32 bytes")
+	}
+
+	block, err := aes.NewCipher(dataKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cipher{aead, indexKey}, nil
+}
+
+// Encrypt returns the encrypted value, empty values stay empty.
+func (pc *Cipher) Encrypt(plaintext string) ([]byte, error) {
+	if plaintext == "" {
+		return nil, nil
+	}
+
+	nonce := make([]byte, pc.aead.NonceSize())
+
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	out := append([]byte{version}, nonce...)
+	return pc.aead.Seal(out, nonce, []byte(plaintext), nil), nil
+}
+
+// Decrypt returns the value encrypted by Encrypt.
+func (pc *Cipher) Decrypt(ciphertext []byte) (string, error) {
+	if len(ciphertext) == 0 {
+		return "", nil
+	}
+
+	n := pc.aead.NonceSize()
+
+	if len(ciphertext) < 1+n || ciphertext[0] != version {
+		return "", errors.New("pii: malformed ciphertext")
+	}
+
+	plaintext, err := pc.aead.Open(nil, ciphertext[1:1+n], ciphertext[1+n:], nil)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// BlindIndex returns the blind index of a value, which ignores case and
+// surrounding space. Empty values have an empty index.
+func (pc *Cipher) BlindIndex(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	if value == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, pc.index)
+	mac.Write([]byte(value))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// UnwrapFunc decrypts a wrapped data key.
+type UnwrapFunc func(c context.Context, wrapped []byte) ([]byte, error)
+
+// KMS returns an UnwrapFunc that decrypts keys with the named Cloud KMS key
+// (projects/…/locations/…/keyRings/…/cryptoKeys/…), client must return an
+// HTTP client authorized for Scope.
+func KMS(keyName string, client func(c context.Context) (*http.Client, error)) UnwrapFunc {
+	return func(c context.Context, wrapped []byte) ([]byte, error) {
+		hc, err := client(c)
+
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := json.Marshal(map[string]string{
+			"ciphertext": base64.StdEncoding.EncodeToString(wrapped),
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		u := "https://cloudkms.googleapis.com/v1/" + keyName + ":decrypt"
+		resp, err := hc.Post(u, "application/json", bytes.NewReader(body))
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("pii: unwrapping key with %v: %v", keyName, resp.Status)
+		}
+
+		var result struct {
+			Plaintext string `json:"plaintext"`
+		}
+
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return nil, err
+		}
+
+		return base64.StdEncoding.DecodeString(result.Plaintext)
+	}
+}
+
+// Keyring unwraps the data keys the first time they are needed and keeps the
+// resulting Cipher for the life of the process.
+type Keyring struct {
+	unwrap            UnwrapFunc
+	dataKey, indexKey []byte
+
+	mu     sync.Mutex
+	cipher *Cipher
+}
+
+// NewKeyring returns a Keyring for the wrapped data and index keys.
+func NewKeyring(unwrap UnwrapFunc, dataKey, indexKey []byte) *Keyring {
+	return &Keyring{unwrap: unwrap, dataKey: dataKey, indexKey: indexKey}
+}
+
+// Cipher returns the Cipher for the keyring's keys. Failures aren't cached so
+// that a KMS outage only lasts as long as the outage.
+func (k *Keyring) Cipher(c context.Context) (*Cipher, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.cipher != nil {
+		return k.cipher, nil
+	}
+
+	dataKey, err := k.unwrap(c, k.dataKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	indexKey, err := k.unwrap(c, k.indexKey)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if k.cipher, err = NewCipher(dataKey, indexKey); err != nil {
+		return nil, err
+	}
+
+	return k.cipher, nil
+}
@@ -0,0 +1,248 @@
+package platform
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	aemail "google.golang.org/appengine/mail"
+	"google.golang.org/appengine/taskqueue"
+	"google.golang.org/appengine/urlfetch"
+	"google.golang.org/appengine/user"
+
+	"github.com/volcanicpixels/licensing/config"
+	"github.com/volcanicpixels/licensing/mail"
+	"github.com/volcanicpixels/licensing/pii"
+	"github.com/volcanicpixels/licensing/storage"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+type appEngine struct {
+	cfg     *config.Config
+	keyring *pii.Keyring
+
+	// storageBreaker and datastoreBreaker are shared by the requests to
+	// the instance, nil if the breaker is disabled.
+	storageBreaker   *storage.Breaker
+	datastoreBreaker *storage.Breaker
+}
+
+// NewAppEngine returns the App Engine platform. Storage is opened per request
+// using the configured backend (see storage.Open), by default this is the
+// app's GCS bucket. Storage and the datastore revocations are behind circuit
+// breakers unless they are disabled. Personal data in the datastore is encrypted if a KMS key
+// is configured.
+func NewAppEngine(cfg *config.Config) Platform {
+	p := &appEngine{cfg: cfg}
+
+	if cfg.Storage.BreakerThreshold > 0 {
+		p.storageBreaker = storage.NewBreaker(cfg.Storage.BreakerThreshold, cfg.Storage.BreakerCooldown)
+		p.datastoreBreaker = storage.NewBreaker(cfg.Storage.BreakerThreshold, cfg.Storage.BreakerCooldown)
+	}
+
+	if cfg.PII.KMSKey != "" {
+		// the keys have been checked by config.Validate
+		dataKey, _ := base64.StdEncoding.DecodeString(cfg.PII.DataKey)
+		indexKey, _ := base64.StdEncoding.DecodeString(cfg.PII.IndexKey)
+
+		unwrap := pii.KMS(cfg.PII.KMSKey, func(c context.Context) (*http.Client, error) {
+			return p.GoogleClient(c, pii.Scope)
+		})
+
+		p.keyring = pii.NewKeyring(unwrap, dataKey, indexKey)
+	}
+
+	return p
+}
+
+func (p *appEngine) PII(c context.Context) (*pii.Cipher, error) {
+	if p.keyring == nil {
+		return nil, nil
+	}
+
+	return p.keyring.Cipher(c)
+}
+
+func (p *appEngine) NewContext(r *http.Request) context.Context {
+	return appengine.NewContext(r)
+}
+
+func (p *appEngine) RequestID(c context.Context) string {
+	return appengine.RequestID(c)
+}
+
+func (p *appEngine) Storage(c context.Context) (storage.Storage, error) {
+	s, err := storage.Open(c, p.cfg.Storage.Backend, p.cfg.Storage.Location)
+
+	if err != nil || p.storageBreaker == nil {
+		return s, err
+	}
+
+	return p.storageBreaker.Wrap(s), nil
+}
+
+func (p *appEngine) Licenses(c context.Context, namespace string) store.Licenses {
+	return store.NewLoggedLicenses(store.NewDatastoreLicenses(namespace, p.keyring), p.Events(c), namespace)
+}
+
+func (p *appEngine) Activations(c context.Context, namespace string) store.Activations {
+	return store.NewDatastoreActivations(namespace)
+}
+
+func (p *appEngine) Users(c context.Context, namespace string) store.Users {
+	return store.NewDatastoreUsers(namespace, p.keyring)
+}
+
+func (p *appEngine) Orders(c context.Context, namespace string) store.Orders {
+	return store.NewDatastoreOrders(namespace)
+}
+
+func (p *appEngine) Revocations(c context.Context) (store.Revocations, error) {
+	revocations, err := shadowed(c, p, p.cfg.Revocations)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return store.NewLoggedRevocations(revocations, p.Events(c)), nil
+}
+
+func (p *appEngine) RevocationsIn(c context.Context, backend string) (store.Revocations, error) {
+	if backend == config.RevocationsDatastore {
+		if p.datastoreBreaker == nil {
+			return store.NewDatastoreRevocations(), nil
+		}
+
+		return &breakerRevocations{store.NewDatastoreRevocations(), p.datastoreBreaker}, nil
+	}
+
+	s, err := p.Storage(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return store.NewTextRevocations(s, p.cfg.Revocations.Source), nil
+}
+
+// breakerRevocations calls the datastore revocations through a breaker.
+type breakerRevocations struct {
+	revocations store.Revocations
+	breaker     *storage.Breaker
+}
+
+func (br *breakerRevocations) Revoke(c context.Context, r store.Revocation) error {
+	return br.breaker.Do(func() error {
+		return br.revocations.Revoke(c, r)
+	})
+}
+
+func (br *breakerRevocations) Unrevoke(c context.Context, id string) error {
+	return br.breaker.Do(func() error {
+		return br.revocations.Unrevoke(c, id)
+	})
+}
+
+func (br *breakerRevocations) List(c context.Context) (list []store.Revocation, err error) {
+	err = br.breaker.Do(func() error {
+		list, err = br.revocations.List(c)
+		return err
+	})
+
+	return
+}
+
+func (p *appEngine) Audit(c context.Context) store.Audit {
+	return store.NewDatastoreAudit(p.keyring)
+}
+
+func (p *appEngine) Events(c context.Context) store.Events {
+	return store.NewDatastoreEvents(p.keyring)
+}
+
+func (p *appEngine) Counters(c context.Context) store.Counters {
+	return store.NewDatastoreCounters()
+}
+
+func (p *appEngine) EmailTemplates(c context.Context) store.EmailTemplates {
+	return store.NewDatastoreEmailTemplates()
+}
+
+func (p *appEngine) Flags(c context.Context) store.Flags {
+	return store.NewDatastoreFlags()
+}
+
+func (p *appEngine) Jobs(c context.Context) store.Jobs {
+	return store.NewDatastoreJobs()
+}
+
+// Enqueue adds the task to the default queue, whose requests App Engine
+// makes as an admin.
+func (p *appEngine) Enqueue(c context.Context, path string, params url.Values) error {
+	_, err := taskqueue.Add(c, taskqueue.NewPOSTTask(path, params), "")
+	return err
+}
+
+func (p *appEngine) Mailer(c context.Context) mail.Mailer {
+	return appEngineMailer{p.cfg.Mail.Sender}
+}
+
+// appEngineMailer sends mail with the App Engine mail API, the sender must be
+// authorized to send mail for the app.
+type appEngineMailer struct {
+	sender string
+}
+
+func (m appEngineMailer) Send(c context.Context, msg *mail.Message) error {
+	return aemail.Send(c, &aemail.Message{
+		Sender:  m.sender,
+		To:      []string{msg.To},
+		Subject: msg.Subject,
+		Body:    msg.Body,
+	})
+}
+
+func (p *appEngine) HTTPClient(c context.Context) *http.Client {
+	return urlfetch.Client(c)
+}
+
+func (p *appEngine) GoogleClient(c context.Context, scope ...string) (*http.Client, error) {
+	return &http.Client{
+		Transport: &oauth2.Transport{
+			Source: google.AppEngineTokenSource(c, scope...),
+			Base:   &urlfetch.Transport{Context: c},
+		},
+	}, nil
+}
+
+// IsAdmin is true for signed in app admins and cron jobs, App Engine strips
+// the X-Appengine-Cron header from external requests.
+func (p *appEngine) IsAdmin(c context.Context, r *http.Request) bool {
+	// App Engine strips these headers from requests it didn't make
+	return r.Header.Get("X-Appengine-Cron") == "true" || r.Header.Get("X-Appengine-Queuename") != "" || user.IsAdmin(c)
+}
+
+func (p *appEngine) ProjectID(c context.Context) string {
+	return appengine.AppID(c)
+}
+
+func (p *appEngine) Debugf(c context.Context, format string, args ...interface{}) {
+	log.Debugf(c, format, args...)
+}
+
+func (p *appEngine) Infof(c context.Context, format string, args ...interface{}) {
+	log.Infof(c, format, args...)
+}
+
+func (p *appEngine) Warningf(c context.Context, format string, args ...interface{}) {
+	log.Warningf(c, format, args...)
+}
+
+func (p *appEngine) Errorf(c context.Context, format string, args ...interface{}) {
+	log.Errorf(c, format, args...)
+}
@@ -0,0 +1,308 @@
+package platform
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2/google"
+
+	"github.com/volcanicpixels/licensing/config"
+	"github.com/volcanicpixels/licensing/mail"
+	"github.com/volcanicpixels/licensing/pii"
+	"github.com/volcanicpixels/licensing/storage"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+type local struct {
+	cfg         *config.Config
+	storage     storage.Storage
+	licenses    map[string]store.Licenses
+	activations map[string]store.Activations
+	users       map[string]store.Users
+	orders      map[string]store.Orders
+	audit       store.Audit
+	events      store.Events
+	counters    store.Counters
+	revocations store.Revocations
+	templates   store.EmailTemplates
+	flags       store.Flags
+	jobs        store.Jobs
+	log         *log.Logger
+
+	// admin is whether every request is an admin's, see IsAdmin
+	admin bool
+
+	// taskToken marks the requests of tasks run by Enqueue
+	taskToken string
+}
+
+// taskHeader is the header task requests carry the task token in.
+const taskHeader = "X-Local-Task"
+
+// NewLocal returns a platform for running outside of App Engine, all requests
+// share the configured storage (the ./data directory by default) and logs are
+// written to w. There is no datastore so issued licenses, named users,
+// orders, the audit log, the event log, counters, email templates, feature flags, jobs and
+// (with the datastore store) revocations are only kept in memory. Mail is
+// written to the log instead of being sent, and tasks are run in the
+// background by the server itself. Requests are only an admin's with
+// LOCAL_ADMIN=true, see IsAdmin.
+func NewLocal(cfg *config.Config, w io.Writer) (Platform, error) {
+	var s storage.Storage
+
+	switch cfg.Storage.Backend {
+	case storage.FileSystem, "":
+		dir := cfg.Storage.Location
+
+		if dir == "" {
+			dir = "data"
+		}
+
+		s = storage.NewFileSystem(dir)
+	case storage.Memory:
+		s = storage.NewMemory()
+	default:
+		return nil, fmt.Errorf("platform: storage backend %q is only supported on App Engine", cfg.Storage.Backend)
+	}
+
+	admin := false
+
+	if s := os.Getenv("LOCAL_ADMIN"); s != "" {
+		var err error
+
+		if admin, err = strconv.ParseBool(s); err != nil {
+			return nil, fmt.Errorf("platform: LOCAL_ADMIN: %v", err)
+		}
+	}
+
+	token := make([]byte, 16)
+
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+
+	events := store.NewMemoryEvents()
+
+	return &local{
+		cfg:     cfg,
+		storage: s,
+		licenses: map[string]store.Licenses{
+			"":                     store.NewLoggedLicenses(store.NewMemoryLicenses(), events, ""),
+			store.SandboxNamespace: store.NewLoggedLicenses(store.NewMemoryLicenses(), events, store.SandboxNamespace),
+		},
+		activations: map[string]store.Activations{
+			"":                     store.NewMemoryActivations(),
+			store.SandboxNamespace: store.NewMemoryActivations(),
+		},
+		users: map[string]store.Users{
+			"":                     store.NewMemoryUsers(),
+			store.SandboxNamespace: store.NewMemoryUsers(),
+		},
+		orders: map[string]store.Orders{
+			"":                     store.NewMemoryOrders(),
+			store.SandboxNamespace: store.NewMemoryOrders(),
+		},
+		audit:       store.NewMemoryAudit(),
+		events:      events,
+		counters:    store.NewMemoryCounters(),
+		revocations: store.NewMemoryRevocations(),
+		templates:   store.NewMemoryEmailTemplates(),
+		flags:       store.NewMemoryFlags(),
+		jobs:        store.NewMemoryJobs(),
+		log:         log.New(w, "", log.LstdFlags),
+		admin:       admin,
+		taskToken:   hex.EncodeToString(token),
+	}, nil
+}
+
+// requestIDKey is the context key of the ID of a local request.
+type requestIDKey struct{}
+
+func (p *local) NewContext(r *http.Request) context.Context {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	return context.WithValue(context.Background(), requestIDKey{}, hex.EncodeToString(id))
+}
+
+func (p *local) RequestID(c context.Context) string {
+	id, _ := c.Value(requestIDKey{}).(string)
+	return id
+}
+
+func (p *local) Storage(c context.Context) (storage.Storage, error) {
+	return p.storage, nil
+}
+
+func (p *local) Licenses(c context.Context, namespace string) store.Licenses {
+	return p.licenses[namespace]
+}
+
+func (p *local) Activations(c context.Context, namespace string) store.Activations {
+	return p.activations[namespace]
+}
+
+func (p *local) Users(c context.Context, namespace string) store.Users {
+	return p.users[namespace]
+}
+
+func (p *local) Orders(c context.Context, namespace string) store.Orders {
+	return p.orders[namespace]
+}
+
+func (p *local) Revocations(c context.Context) (store.Revocations, error) {
+	revocations, err := shadowed(c, p, p.cfg.Revocations)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return store.NewLoggedRevocations(revocations, p.events), nil
+}
+
+// RevocationsIn keeps the datastore's revocations in memory, there is no
+// datastore locally.
+func (p *local) RevocationsIn(c context.Context, backend string) (store.Revocations, error) {
+	if backend == config.RevocationsDatastore {
+		return p.revocations, nil
+	}
+
+	return store.NewTextRevocations(p.storage, p.cfg.Revocations.Source), nil
+}
+
+func (p *local) Audit(c context.Context) store.Audit {
+	return p.audit
+}
+
+func (p *local) Events(c context.Context) store.Events {
+	return p.events
+}
+
+func (p *local) Counters(c context.Context) store.Counters {
+	return p.counters
+}
+
+func (p *local) EmailTemplates(c context.Context) store.EmailTemplates {
+	return p.templates
+}
+
+func (p *local) Flags(c context.Context) store.Flags {
+	return p.flags
+}
+
+func (p *local) Jobs(c context.Context) store.Jobs {
+	return p.jobs
+}
+
+// Enqueue serves the task with http.DefaultServeMux, which the server's
+// handlers are registered with, in a goroutine. Tasks that fail are logged
+// rather than retried.
+func (p *local) Enqueue(c context.Context, path string, params url.Values) error {
+	r, err := http.NewRequest("POST", path, strings.NewReader(params.Encode()))
+
+	if err != nil {
+		return err
+	}
+
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set(taskHeader, p.taskToken)
+	r.RemoteAddr = "127.0.0.1:0"
+
+	go func() {
+		w := &taskResponse{header: make(http.Header), status: http.StatusOK}
+		http.DefaultServeMux.ServeHTTP(w, r)
+
+		if w.status >= 300 {
+			p.Errorf(c, "Task %v failed with status %v", path, w.status)
+		}
+	}()
+
+	return nil
+}
+
+// taskResponse keeps the status of a task's response and discards the
+// rest.
+type taskResponse struct {
+	header http.Header
+	status int
+}
+
+func (w *taskResponse) Header() http.Header         { return w.header }
+func (w *taskResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (w *taskResponse) WriteHeader(status int)      { w.status = status }
+
+// PII is nil, there is no KMS to unwrap the keys with locally and the stores
+// are in memory.
+func (p *local) PII(c context.Context) (*pii.Cipher, error) {
+	return nil, nil
+}
+
+func (p *local) Mailer(c context.Context) mail.Mailer {
+	return logMailer{p}
+}
+
+// logMailer logs messages instead of sending them.
+type logMailer struct {
+	p *local
+}
+
+func (m logMailer) Send(c context.Context, msg *mail.Message) error {
+	m.p.Infof(c, "Mail to %v: %v\n%v", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+func (p *local) HTTPClient(c context.Context) *http.Client {
+	return http.DefaultClient
+}
+
+// GoogleClient uses the application default credentials.
+func (p *local) GoogleClient(c context.Context, scope ...string) (*http.Client, error) {
+	return google.DefaultClient(c, scope...)
+}
+
+// IsAdmin is true for the tasks run by Enqueue, and for every request if the
+// LOCAL_ADMIN environment variable is set to true. There are no users when
+// running locally, so that is only for development or a server that can
+// only be reached by admins, never for one that is exposed publicly (such as
+// on Cloud Run without IAM in front of it), where there is only API key
+// access.
+func (p *local) IsAdmin(c context.Context, r *http.Request) bool {
+	task := r.Header.Get(taskHeader)
+	return p.admin || task != "" && subtle.ConstantTimeCompare([]byte(task), []byte(p.taskToken)) == 1
+}
+
+// ProjectID is read from the GOOGLE_CLOUD_PROJECT environment variable, which
+// is set on Cloud Run.
+func (p *local) ProjectID(c context.Context) string {
+	return os.Getenv("GOOGLE_CLOUD_PROJECT")
+}
+
+func (p *local) logf(level, format string, args ...interface{}) {
+	p.log.Printf("%-7s %v", level, fmt.Sprintf(format, args...))
+}
+
+func (p *local) Debugf(c context.Context, format string, args ...interface{}) {
+	p.logf("DEBUG", format, args...)
+}
+
+func (p *local) Infof(c context.Context, format string, args ...interface{}) {
+	p.logf("INFO", format, args...)
+}
+
+func (p *local) Warningf(c context.Context, format string, args ...interface{}) {
+	p.logf("WARNING", format, args...)
+}
+
+func (p *local) Errorf(c context.Context, format string, args ...interface{}) {
+	p.logf("ERROR", format, args...)
+}
@@ -0,0 +1,134 @@
+// Package platform abstracts the services the licensing server takes from the
+// environment it is deployed to, so that it can run on App Engine or as a
+// plain net/http binary.
+package platform
+
+import (
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/config"
+	"github.com/volcanicpixels/licensing/mail"
+	"github.com/volcanicpixels/licensing/pii"
+	"github.com/volcanicpixels/licensing/storage"
+	"github.com/volcanicpixels/licensing/store"
+)
+
+// Logger writes leveled log messages associated with a request context. The
+// method signatures match the App Engine log package.
+type Logger interface {
+	Debugf(c context.Context, format string, args ...interface{})
+	Infof(c context.Context, format string, args ...interface{})
+	Warningf(c context.Context, format string, args ...interface{})
+	Errorf(c context.Context, format string, args ...interface{})
+}
+
+// Platform is a deployment target.
+type Platform interface {
+	Logger
+
+	// NewContext returns the context for an incoming request.
+	NewContext(r *http.Request) context.Context
+
+	// RequestID returns the ID of the request a context is for, which the
+	// request's log entries can be found by.
+	RequestID(c context.Context) string
+
+	// Storage returns the file storage for a request context.
+	Storage(c context.Context) (storage.Storage, error)
+
+	// Licenses returns the store of issued licenses in a namespace, either
+	// production ("") or store.SandboxNamespace. Every license it stores
+	// is logged in Events first.
+	Licenses(c context.Context, namespace string) store.Licenses
+
+	// Activations returns the store of license activations in a namespace,
+	// the same namespace as the licenses.
+	Activations(c context.Context, namespace string) store.Activations
+
+	// Users returns the store of the named users of licenses in a
+	// namespace, the same namespace as the licenses.
+	Users(c context.Context, namespace string) store.Users
+
+	// Orders returns the store of the licenses issued for orders in a
+	// namespace, the same namespace as the licenses.
+	Orders(c context.Context, namespace string) store.Orders
+
+	// Revocations returns the store of revoked license IDs, the one set by
+	// revocations.store. Every revocation is logged in Events first.
+	Revocations(c context.Context) (store.Revocations, error)
+
+	// RevocationsIn returns the revocation store of a backend,
+	// config.RevocationsFile or config.RevocationsDatastore, for migrating
+	// between them.
+	RevocationsIn(c context.Context, backend string) (store.Revocations, error)
+
+	// Audit returns the log of actions taken through the API.
+	Audit(c context.Context) store.Audit
+
+	// Events returns the log of changes to the licenses and revocations,
+	// which they can be rebuilt from.
+	Events(c context.Context) store.Events
+
+	// Counters returns the store of counters, such as issuance quotas.
+	Counters(c context.Context) store.Counters
+
+	// EmailTemplates returns the store of customised email templates.
+	EmailTemplates(c context.Context) store.EmailTemplates
+
+	// Flags returns the store of feature flags.
+	Flags(c context.Context) store.Flags
+
+	// Jobs returns the store of background jobs.
+	Jobs(c context.Context) store.Jobs
+
+	// Enqueue adds a task that POSTs params to path on the app in the
+	// background, such as the next batch of a job. Tasks are retried until
+	// they succeed and are made as an admin.
+	Enqueue(c context.Context, path string, params url.Values) error
+
+	// PII returns the cipher personal data is encrypted with before it is
+	// stored, nil if there are no keys for it (see config.PII).
+	PII(c context.Context) (*pii.Cipher, error)
+
+	// Mailer returns the mailer for emailing customers, messages are sent
+	// from the configured sender.
+	Mailer(c context.Context) mail.Mailer
+
+	// HTTPClient returns a client for making outgoing requests.
+	HTTPClient(c context.Context) *http.Client
+
+	// GoogleClient returns a client authorized to call Google APIs with the
+	// given scopes as the app's service account.
+	GoogleClient(c context.Context, scope ...string) (*http.Client, error)
+
+	// IsAdmin reports whether a request was made by an administrator of the
+	// app (or by the platform's scheduler or task queue).
+	IsAdmin(c context.Context, r *http.Request) bool
+
+	// ProjectID returns the Google Cloud project the server runs in, or an
+	// empty string if it isn't known.
+	ProjectID(c context.Context) string
+}
+
+// shadowed returns a platform's revocation store, shadowed by the other one
+// if revocations.shadow is set. The shadow store failing to open is logged
+// and the store is used alone.
+func shadowed(c context.Context, p Platform, cfg config.Revocations) (store.Revocations, error) {
+	primary, err := p.RevocationsIn(c, cfg.Store)
+
+	if err != nil || cfg.Shadow == "" {
+		return primary, err
+	}
+
+	shadow, err := p.RevocationsIn(c, cfg.Shadow)
+
+	if err != nil {
+		p.Warningf(c, "revocations shadow mismatch: could not open the shadow store: %v", err)
+		return primary, nil
+	}
+
+	return store.NewShadowRevocations(primary, shadow, p.Warningf), nil
+}
@@ -0,0 +1,31 @@
+package qr
+
+import (
+	"image"
+	"image/color"
+)
+
+// QuietZone is the width in modules of the light border a code needs around
+// it to be scanned.
+const QuietZone = 4
+
+// Image returns the code as a black and white image with scale pixels per
+// module, including the quiet zone.
+func (qr *Code) Image(scale int) image.Image {
+	if scale < 1 {
+		scale = 1
+	}
+
+	size := (qr.Size + QuietZone*2) * scale
+	img := image.NewPaletted(image.Rect(0, 0, size, size), color.Palette{color.White, color.Black})
+
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if qr.Black(x/scale-QuietZone, y/scale-QuietZone) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+
+	return img
+}
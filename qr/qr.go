@@ -0,0 +1,556 @@
+// Package qr encodes data as QR codes (ISO/IEC 18004), in byte mode with
+// versions 1 to 40, so that licenses can be printed and scanned.
+package qr
+
+import (
+	"errors"
+)
+
+// Level is the error correction level of a code, higher levels survive more
+// damage but hold less data.
+type Level int
+
+// The error correction levels, recovering about 7%, 15%, 25% and 30% of the
+// code.
+const (
+	L Level = iota
+	M
+	Q
+	H
+)
+
+// ErrTooLong is returned by Encode for data that doesn't fit in a version 40
+// code at the level.
+var ErrTooLong = errors.New("qr: data too long")
+
+// formatBits are the bits of each level in the format information.
+var formatBits = [4]int{L: 1, M: 0, Q: 3, H: 2}
+
+// eccPerBlock and eccBlocks are the error correction codewords in each block
+// and the number of blocks of each version by level, from table 9 of the
+// standard. Index 0 is unused.
+var eccPerBlock = [4][41]int{
+	L: {0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18, 20, 24, 26, 30, 22, 24, 28, 30, 28, 28, 28, 28, 30, 30, 26, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	M: {0, 10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26, 26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28},
+	Q: {0, 13, 22, 18, 26, 18, 24, 18, 22, 20, 24, 28, 26, 24, 20, 30, 24, 28, 28, 26, 30, 28, 30, 30, 30, 30, 28, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+	H: {0, 17, 28, 22, 16, 22, 28, 26, 26, 24, 28, 24, 28, 22, 24, 24, 30, 28, 28, 26, 28, 30, 24, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30, 30},
+}
+
+var eccBlocks = [4][41]int{
+	L: {0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4, 4, 4, 4, 4, 6, 6, 6, 6, 7, 8, 8, 9, 9, 10, 12, 12, 12, 13, 14, 15, 16, 17, 18, 19, 19, 20, 21, 22, 24, 25},
+	M: {0, 1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16, 17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49},
+	Q: {0, 1, 1, 2, 2, 4, 4, 6, 6, 8, 8, 8, 10, 12, 16, 12, 17, 16, 18, 21, 20, 23, 23, 25, 27, 29, 34, 34, 35, 38, 40, 43, 45, 48, 51, 53, 56, 59, 62, 65, 68},
+	H: {0, 1, 1, 2, 4, 4, 4, 5, 6, 8, 8, 11, 11, 16, 16, 18, 16, 19, 21, 25, 25, 25, 34, 30, 32, 35, 37, 40, 42, 45, 48, 51, 54, 57, 60, 63, 66, 70, 74, 77, 81},
+}
+
+// Code is an encoded QR code, a square of dark and light modules. It doesn't
+// include the quiet zone of 4 light modules that should surround it.
+type Code struct {
+	Version int
+	Level   Level
+	Size    int
+
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+// Black reports whether the module in column x and row y is dark, modules
+// outside the code are light.
+func (qr *Code) Black(x, y int) bool {
+	return x >= 0 && y >= 0 && x < qr.Size && y < qr.Size && qr.modules[y][x]
+}
+
+// Encode returns the smallest code holding data at the level.
+func Encode(data []byte, level Level) (*Code, error) {
+	for version := 1; version <= 40; version++ {
+		if len(data) <= capacity(version, level) {
+			qr := newCode(version, level)
+			qr.drawCodewords(qr.codewords(data))
+			qr.applyBestMask()
+
+			return qr, nil
+		}
+	}
+
+	return nil, ErrTooLong
+}
+
+// rawCodewords is the number of codewords, data and error correction, that
+// a version holds.
+func rawCodewords(version int) int {
+	n := (16*version+128)*version + 64
+
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+
+		if version >= 7 {
+			n -= 36
+		}
+	}
+
+	return n / 8
+}
+
+// dataCodewords is the number of data codewords a version holds at a level.
+func dataCodewords(version int, level Level) int {
+	return rawCodewords(version) - eccPerBlock[level][version]*eccBlocks[level][version]
+}
+
+// countBits is the length of the character count in byte mode.
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+
+	return 16
+}
+
+// capacity is the number of bytes a version holds at a level.
+func capacity(version int, level Level) int {
+	return (dataCodewords(version, level)*8 - 4 - countBits(version)) / 8
+}
+
+func newCode(version int, level Level) *Code {
+	size := version*4 + 17
+	qr := &Code{Version: version, Level: level, Size: size}
+
+	qr.modules = make([][]bool, size)
+	qr.isFunction = make([][]bool, size)
+
+	for i := range qr.modules {
+		qr.modules[i] = make([]bool, size)
+		qr.isFunction[i] = make([]bool, size)
+	}
+
+	qr.drawFunctionPatterns()
+	return qr
+}
+
+func (qr *Code) setFunction(x, y int, black bool) {
+	qr.modules[y][x] = black
+	qr.isFunction[y][x] = true
+}
+
+// drawFunctionPatterns draws the finder, alignment and timing patterns, and
+// reserves the format and version information.
+func (qr *Code) drawFunctionPatterns() {
+	for i := 0; i < qr.Size; i++ {
+		qr.setFunction(6, i, i%2 == 0)
+		qr.setFunction(i, 6, i%2 == 0)
+	}
+
+	qr.drawFinder(3, 3)
+	qr.drawFinder(qr.Size-4, 3)
+	qr.drawFinder(3, qr.Size-4)
+
+	positions := alignmentPositions(qr.Version)
+	last := len(positions) - 1
+
+	for i, x := range positions {
+		for j, y := range positions {
+			// the corners with finder patterns
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+
+			qr.drawAlignment(x, y)
+		}
+	}
+
+	qr.drawFormat(0)
+	qr.drawVersion()
+}
+
+// drawFinder draws a finder pattern and its separator centred on x, y.
+func (qr *Code) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+
+			if xx < 0 || yy < 0 || xx >= qr.Size || yy >= qr.Size {
+				continue
+			}
+
+			d := chebyshev(dx, dy)
+			qr.setFunction(xx, yy, d != 2 && d != 4)
+		}
+	}
+}
+
+// drawAlignment draws an alignment pattern centred on x, y.
+func (qr *Code) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			qr.setFunction(x+dx, y+dy, chebyshev(dx, dy) != 1)
+		}
+	}
+}
+
+// alignmentPositions are the rows and columns of the centres of the
+// alignment patterns of a version.
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+
+	n := version/7 + 2
+	step := (version*8 + n*3 + 5) / (n*4 - 4) * 2
+	positions := make([]int, n)
+	positions[0] = 6
+
+	for i, pos := n-1, version*4+10; i > 0; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+
+	return positions
+}
+
+// drawFormat draws both copies of the format information for a mask.
+func (qr *Code) drawFormat(mask int) {
+	data := formatBits[qr.Level]<<3 | mask
+	rem := data
+
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>uint(i)&1 != 0 }
+
+	// around the top left finder pattern
+	for i := 0; i <= 5; i++ {
+		qr.setFunction(8, i, bit(i))
+	}
+
+	qr.setFunction(8, 7, bit(6))
+	qr.setFunction(8, 8, bit(7))
+	qr.setFunction(7, 8, bit(8))
+
+	for i := 9; i < 15; i++ {
+		qr.setFunction(14-i, 8, bit(i))
+	}
+
+	// split between the other two
+	for i := 0; i < 8; i++ {
+		qr.setFunction(qr.Size-1-i, 8, bit(i))
+	}
+
+	for i := 8; i < 15; i++ {
+		qr.setFunction(8, qr.Size-15+i, bit(i))
+	}
+
+	// the dark module
+	qr.setFunction(8, qr.Size-8, true)
+}
+
+// drawVersion draws both copies of the version information, which versions
+// below 7 don't have.
+func (qr *Code) drawVersion() {
+	if qr.Version < 7 {
+		return
+	}
+
+	rem := qr.Version
+
+	for i := 0; i < 12; i++ {
+		rem = rem<<1 ^ (rem>>11)*0x1f25
+	}
+
+	bits := qr.Version<<12 | rem
+
+	for i := 0; i < 18; i++ {
+		black := bits>>uint(i)&1 != 0
+		a, b := qr.Size-11+i%3, i/3
+
+		qr.setFunction(a, b, black)
+		qr.setFunction(b, a, black)
+	}
+}
+
+// codewords returns the data in byte mode, padded to the capacity of the
+// code, and interleaved with its error correction.
+func (qr *Code) codewords(data []byte) []byte {
+	var bb bitBuffer
+
+	bb.append(0x4, 4)
+	bb.append(len(data), countBits(qr.Version))
+
+	for _, b := range data {
+		bb.append(int(b), 8)
+	}
+
+	capacityBits := dataCodewords(qr.Version, qr.Level) * 8
+	terminator := capacityBits - len(bb)
+
+	if terminator > 4 {
+		terminator = 4
+	}
+
+	bb.append(0, terminator)
+	bb.append(0, (8-len(bb)%8)%8)
+
+	for pad := 0xec; len(bb) < capacityBits; pad ^= 0xec ^ 0x11 {
+		bb.append(pad, 8)
+	}
+
+	return addECC(bb.bytes(), qr.Version, qr.Level)
+}
+
+// addECC splits data into the blocks of a version and level, and returns
+// their data codewords then their error correction codewords, interleaved.
+func addECC(data []byte, version int, level Level) []byte {
+	numBlocks := eccBlocks[level][version]
+	eccLen := eccPerBlock[level][version]
+	raw := rawCodewords(version)
+
+	// the short blocks come first, the rest have a codeword more
+	numShort := numBlocks - raw%numBlocks
+	shortLen := raw/numBlocks - eccLen
+
+	divisor := rsDivisor(eccLen)
+	blocks := make([][]byte, numBlocks)
+	eccs := make([][]byte, numBlocks)
+
+	for i, k := 0, 0; i < numBlocks; i++ {
+		n := shortLen
+
+		if i >= numShort {
+			n++
+		}
+
+		blocks[i] = data[k : k+n]
+		eccs[i] = rsRemainder(blocks[i], divisor)
+		k += n
+	}
+
+	result := make([]byte, 0, raw)
+
+	for i := 0; i <= shortLen; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+
+	for i := 0; i < eccLen; i++ {
+		for _, ecc := range eccs {
+			result = append(result, ecc[i])
+		}
+	}
+
+	return result
+}
+
+// drawCodewords places the codewords in the modules that aren't function
+// patterns, in two module wide columns zigzagging from the bottom right.
+func (qr *Code) drawCodewords(data []byte) {
+	i := 0
+
+	for right := qr.Size - 1; right >= 1; right -= 2 {
+		// the vertical timing pattern
+		if right == 6 {
+			right = 5
+		}
+
+		upward := (right+1)&2 == 0
+
+		for vert := 0; vert < qr.Size; vert++ {
+			y := vert
+
+			if upward {
+				y = qr.Size - 1 - vert
+			}
+
+			for j := 0; j < 2; j++ {
+				x := right - j
+
+				if qr.isFunction[y][x] {
+					continue
+				}
+
+				// the remainder bits are left light
+				if i < len(data)*8 {
+					qr.modules[y][x] = data[i>>3]>>uint(7-i&7)&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules the mask selects, applying it again
+// undoes it.
+func (qr *Code) applyMask(mask int) {
+	for y := 0; y < qr.Size; y++ {
+		for x := 0; x < qr.Size; x++ {
+			if !qr.isFunction[y][x] && masked(mask, x, y) {
+				qr.modules[y][x] = !qr.modules[y][x]
+			}
+		}
+	}
+}
+
+func masked(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// applyBestMask applies the mask with the lowest penalty, the one least
+// likely to confuse a scanner.
+func (qr *Code) applyBestMask() {
+	best, lowest := 0, -1
+
+	for mask := 0; mask < 8; mask++ {
+		qr.applyMask(mask)
+		qr.drawFormat(mask)
+
+		if p := qr.penalty(); lowest < 0 || p < lowest {
+			best, lowest = mask, p
+		}
+
+		qr.applyMask(mask)
+	}
+
+	qr.applyMask(best)
+	qr.drawFormat(best)
+}
+
+// penalty scores the modules by the rules of the standard: runs of the same
+// colour, 2x2 blocks, patterns that look like finders, and imbalance between
+// dark and light.
+func (qr *Code) penalty() int {
+	p, dark := 0, 0
+	line := make([]bool, qr.Size)
+
+	for _, vertical := range []bool{false, true} {
+		for i := 0; i < qr.Size; i++ {
+			for j := 0; j < qr.Size; j++ {
+				if vertical {
+					line[j] = qr.modules[j][i]
+				} else {
+					line[j] = qr.modules[i][j]
+				}
+			}
+
+			p += linePenalty(line)
+		}
+	}
+
+	for y := 0; y < qr.Size; y++ {
+		for x := 0; x < qr.Size; x++ {
+			black := qr.modules[y][x]
+
+			if black {
+				dark++
+			}
+
+			if x > 0 && y > 0 && black == qr.modules[y][x-1] && black == qr.modules[y-1][x] && black == qr.modules[y-1][x-1] {
+				p += 3
+			}
+		}
+	}
+
+	total := qr.Size * qr.Size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+
+	return p + k*10
+}
+
+// finderLike are the patterns 1011101 with four light modules on one side.
+var finderLike = [2][]bool{
+	{true, false, true, true, true, false, true, false, false, false, false},
+	{false, false, false, false, true, false, true, true, true, false, true},
+}
+
+func linePenalty(line []bool) int {
+	p, run := 0, 1
+
+	for i := 1; i <= len(line); i++ {
+		if i < len(line) && line[i] == line[i-1] {
+			run++
+			continue
+		}
+
+		if run >= 5 {
+			p += run - 2
+		}
+
+		run = 1
+	}
+
+	for i := 0; i+11 <= len(line); i++ {
+		for _, pattern := range finderLike {
+			if equal(line[i:i+11], pattern) {
+				p += 40
+			}
+		}
+	}
+
+	return p
+}
+
+func equal(a, b []bool) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
+
+// chebyshev is the distance of dx, dy from the centre of a pattern.
+func chebyshev(dx, dy int) int {
+	if abs(dx) > abs(dy) {
+		return abs(dx)
+	}
+
+	return abs(dy)
+}
+
+// bitBuffer is a sequence of bits, most significant first.
+type bitBuffer []bool
+
+func (bb *bitBuffer) append(v, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*bb = append(*bb, v>>uint(i)&1 != 0)
+	}
+}
+
+func (bb bitBuffer) bytes() []byte {
+	b := make([]byte, (len(bb)+7)/8)
+
+	for i, bit := range bb {
+		if bit {
+			b[i>>3] |= 0x80 >> uint(i&7)
+		}
+	}
+
+	return b
+}
@@ -0,0 +1,142 @@
+// Package secrets loads secret values such as signing keys from Google Secret
+// Manager.
+package secrets
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Scope is the OAuth scope needed to access secrets.
+const Scope = "https://www.googleapis.com/auth/cloud-platform"
+
+// ErrNotFound is returned when a secret does not exist.
+var ErrNotFound = errors.New("secrets: not found")
+
+// Source returns the latest value of a named secret.
+type Source interface {
+	Get(c context.Context, name string) ([]byte, error)
+}
+
+type secretManager struct {
+	client  *http.Client
+	project string
+}
+
+// NewSecretManager returns a Source that reads secrets from a project's
+// Secret Manager, client must be authorized for Scope.
+func NewSecretManager(client *http.Client, project string) Source {
+	return &secretManager{client, project}
+}
+
+func (sm *secretManager) Get(c context.Context, name string) ([]byte, error) {
+	u := fmt.Sprintf("https://secretmanager.googleapis.com/v1/projects/%v/secrets/%v/versions/latest:access",
+		url.PathEscape(sm.project), url.PathEscape(name))
+
+	resp, err := sm.client.Get(u)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("secrets: accessing %v: %v", name, resp.Status)
+	}
+
+	var body struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+
+	return base64.StdEncoding.DecodeString(body.Payload.Data)
+}
+
+// Cache keeps secret values in memory so that they aren't fetched on every
+// request. Once a value is older than the TTL it is refreshed, if that fails
+// the stale value continues to be used so that an outage of the secret source
+// doesn't take us down with it.
+type Cache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+type entry struct {
+	value   []byte
+	fetched time.Time
+}
+
+// NewCache returns an empty Cache.
+func NewCache(ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:     ttl,
+		entries: make(map[string]entry),
+	}
+}
+
+// Get returns the cached value of the secret, fetching it from src if it is
+// missing or has expired. If refreshing an expired value fails the stale
+// value is returned along with a *StaleError.
+func (sc *Cache) Get(c context.Context, src Source, name string) ([]byte, error) {
+	sc.mu.Lock()
+	e, ok := sc.entries[name]
+	sc.mu.Unlock()
+
+	if ok && time.Since(e.fetched) < sc.ttl {
+		return e.value, nil
+	}
+
+	value, err := src.Get(c, name)
+
+	if err != nil {
+		if ok {
+			return e.value, &StaleError{name, err}
+		}
+
+		return nil, err
+	}
+
+	sc.mu.Lock()
+	sc.entries[name] = entry{value, time.Now()}
+	sc.mu.Unlock()
+
+	return value, nil
+}
+
+// Invalidate removes all cached values, forcing them to be fetched again.
+func (sc *Cache) Invalidate() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.entries = make(map[string]entry)
+}
+
+// StaleError is returned along with a stale value when refreshing a secret
+// fails.
+type StaleError struct {
+	Name string
+	Err  error
+}
+
+func (e *StaleError) Error() string {
+	return fmt.Sprintf("secrets: using stale value of %v: %v", e.Name, e.Err)
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+)
+
+// ErrUnavailable is returned instead of calling a backend that a Breaker
+// considers down.
+var ErrUnavailable = errors.New("storage: backend unavailable")
+
+// Breaker fails calls to a backend fast once threshold calls in a row have
+// failed with timeouts or server errors, rather than have every request wait
+// out the backend's timeouts. After the cooldown a single call is let through
+// as a probe, closing the breaker if it succeeds and opening it again for
+// another cooldown if it doesn't. A Breaker is shared by all requests to the
+// backend and is safe for concurrent use.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// NewBreaker returns a closed Breaker.
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// Do calls op unless the breaker is open, in which case it returns
+// ErrUnavailable.
+func (b *Breaker) Do(op func() error) error {
+	probe, ok := b.allow(time.Now())
+
+	if !ok {
+		return ErrUnavailable
+	}
+
+	err := op()
+	b.done(probe, isOutage(err), time.Now())
+
+	return err
+}
+
+// allow reports whether a call may go ahead and whether it is the probe of an
+// open breaker.
+func (b *Breaker) allow(now time.Time) (probe, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return false, true
+	}
+
+	if b.probing || now.Before(b.openUntil) {
+		return false, false
+	}
+
+	b.probing = true
+	return true, true
+}
+
+// done records the outcome of a call.
+func (b *Breaker) done(probe, failed bool, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+
+	if !failed {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+
+	if probe || b.failures == b.threshold {
+		b.openUntil = now.Add(b.cooldown)
+	}
+}
+
+// isOutage reports whether err suggests the backend is down rather than that
+// the call was wrong, errors worth retrying and timeouts.
+func isOutage(err error) bool {
+	return err != nil && (isTransient(err) || err == context.DeadlineExceeded || appengine.IsTimeoutError(err))
+}
+
+// Wrap returns the Storage s with its calls through the breaker. It is a
+// URLSigner if s is.
+func (b *Breaker) Wrap(s Storage) Storage {
+	bs := &breakerStorage{s: s, b: b}
+
+	if signer, ok := s.(URLSigner); ok {
+		return &signingBreakerStorage{bs, signer}
+	}
+
+	return bs
+}
+
+type breakerStorage struct {
+	s Storage
+	b *Breaker
+}
+
+func (bs *breakerStorage) ReadFile(fileName string) (data []byte, err error) {
+	err = bs.b.Do(func() error {
+		data, err = bs.s.ReadFile(fileName)
+		return err
+	})
+
+	return
+}
+
+func (bs *breakerStorage) WriteFile(fileName string, data []byte) error {
+	return bs.b.Do(func() error {
+		return bs.s.WriteFile(fileName, data)
+	})
+}
+
+func (bs *breakerStorage) UpdateFile(fileName string, update func(data []byte) ([]byte, error)) error {
+	return bs.b.Do(func() error {
+		return UpdateFile(bs.s, fileName, update)
+	})
+}
+
+func (bs *breakerStorage) MakePublic(fileName string) error {
+	return bs.b.Do(func() error {
+		return bs.s.MakePublic(fileName)
+	})
+}
+
+type signingBreakerStorage struct {
+	*breakerStorage
+	signer URLSigner
+}
+
+func (ss *signingBreakerStorage) SignedURL(fileName, downloadName string, expires time.Time) (url string, err error) {
+	err = ss.b.Do(func() error {
+		url, err = ss.signer.SignedURL(fileName, downloadName, expires)
+		return err
+	})
+
+	return
+}
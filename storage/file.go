@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+type fileStorage struct {
+	root string
+}
+
+// NewFileSystem returns a Storage that keeps files under the root directory,
+// it is intended for local development.
+func NewFileSystem(root string) Storage {
+	return &fileStorage{root}
+}
+
+func (fs *fileStorage) path(fileName string) string {
+	return filepath.Join(fs.root, filepath.FromSlash(fileName))
+}
+
+func (fs *fileStorage) ReadFile(fileName string) ([]byte, error) {
+	data, err := ioutil.ReadFile(fs.path(fileName))
+
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+
+	return data, err
+}
+
+// WriteFile writes to a temporary file and renames it into place so that
+// readers never see a partially written file. Each write has its own
+// temporary file so that concurrent writes don't rename each other's.
+func (fs *fileStorage) WriteFile(fileName string, data []byte) error {
+	path := fs.path(fileName)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+
+	return err
+}
+
+// MakePublic is a no-op, files on the local filesystem have no ACLs.
+func (fs *fileStorage) MakePublic(fileName string) error {
+	if _, err := os.Stat(fs.path(fileName)); os.IsNotExist(err) {
+		return ErrNotExist
+	}
+
+	return nil
+}
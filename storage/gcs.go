@@ -0,0 +1,283 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"google.golang.org/api/googleapi"
+	rawgcs "google.golang.org/api/storage/v1"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/file"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/urlfetch"
+	"google.golang.org/cloud"
+	gcs "google.golang.org/cloud/storage"
+)
+
+type gcsStorage struct {
+	c      context.Context
+	ctx    context.Context
+	bucket string
+
+	// raw is the JSON API, which unlike the cloud package can make writes
+	// conditional on the object's generation
+	raw *rawgcs.Service
+}
+
+// NewGCS returns a Storage backed by a Google Cloud Storage bucket, if bucket
+// is empty the app's default bucket is used.
+func NewGCS(c context.Context, bucket string) Storage {
+	hc := &http.Client{
+		Transport: &oauth2.Transport{
+			Source: google.AppEngineTokenSource(c, gcs.ScopeFullControl),
+			Base:   &urlfetch.Transport{Context: c},
+		},
+	}
+
+	ctx := cloud.NewContext(appengine.AppID(c), hc)
+
+	// New only fails without a client
+	raw, _ := rawgcs.New(hc)
+
+	return &gcsStorage{
+		c:      c,
+		ctx:    ctx,
+		bucket: bucket,
+		raw:    raw,
+	}
+}
+
+func (gs *gcsStorage) Bucket() (string, error) {
+
+	// The dev app server does not support getting the bucket name
+	// IsDevAppServer has rather unhelpfully not been implemented
+	if gs.bucket == "" && strings.HasPrefix(appengine.ServerSoftware(), "Development") {
+		return "vp-licensing.appspot.com", nil
+	}
+
+	if gs.bucket == "" {
+		var err error
+
+		if gs.bucket, err = file.DefaultBucketName(gs.c); err != nil {
+			return "", err
+		}
+	}
+
+	return gs.bucket, nil
+}
+
+func (gs *gcsStorage) ReadFile(fileName string) (slurp []byte, err error) {
+
+	bucket, err := gs.Bucket()
+
+	if err != nil {
+		return
+	}
+
+	log.Debugf(gs.c, "Reading file %v from bucket %v", fileName, bucket)
+
+	// reads are idempotent so they can always be retried
+	err = retry(gs.c, "read of "+fileName, func() error {
+		rc, err := gcs.NewReader(gs.ctx, bucket, fileName)
+
+		if err == gcs.ErrObjectNotExist {
+			return ErrNotExist
+		}
+
+		if err != nil {
+			return err
+		}
+
+		defer rc.Close()
+
+		slurp, err = ioutil.ReadAll(rc)
+		return err
+	})
+
+	return
+}
+
+// WriteFile writes a byte arrat to a file and sets the content type based on
+// the file extension.
+//
+// Writes are conditional on the object's generation from before the first
+// attempt, so they fail rather than clobber a concurrent write, and failed
+// writes are retried only as long as the object hasn't changed (an earlier
+// attempt of ours may in fact have succeeded).
+func (gs *gcsStorage) WriteFile(fileName string, data []byte) error {
+	return gs.WriteFileCached(fileName, data, "")
+}
+
+// WriteFileCached is WriteFile setting the object's Cache-Control, empty
+// leaves it to GCS (an hour for public objects).
+func (gs *gcsStorage) WriteFileCached(fileName string, data []byte, cacheControl string) error {
+	bucket, err := gs.Bucket()
+
+	if err != nil {
+		return err
+	}
+
+	log.Debugf(gs.c, "Writing file %v to bucket %v", fileName, bucket)
+
+	generation, err := gs.generation(bucket, fileName)
+
+	if err != nil {
+		return err
+	}
+
+	return retry(gs.c, "write of "+fileName, func() error {
+		err := gs.write(bucket, fileName, data, cacheControl, generation)
+
+		if err == ErrConflict {
+			return fmt.Errorf("%v changed during write (was generation %v)", fileName, generation)
+		}
+
+		return err
+	})
+}
+
+// UpdateFile writes the update conditional on the generation of the object
+// it was computed from, and starts over if another write got in first.
+func (gs *gcsStorage) UpdateFile(fileName string, update func(data []byte) ([]byte, error)) error {
+	bucket, err := gs.Bucket()
+
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		// the generation is read first, if the contents are of a later one
+		// the write fails and we start over
+		generation, err := gs.generation(bucket, fileName)
+
+		if err != nil {
+			return err
+		}
+
+		data, err := gs.ReadFile(fileName)
+
+		if err != nil && err != ErrNotExist {
+			return err
+		}
+
+		if data, err = update(data); err != nil || data == nil {
+			return err
+		}
+
+		err = retry(gs.c, "update of "+fileName, func() error {
+			return gs.write(bucket, fileName, data, "", generation)
+		})
+
+		if err != ErrConflict || attempt == updateAttempts-1 {
+			return err
+		}
+
+		log.Warningf(gs.c, "%v was changed during the update (was generation %v), starting over", fileName, generation)
+		time.Sleep(backoff(attempt + 1))
+	}
+}
+
+// write writes a file if it is still at generation, 0 being a file that
+// doesn't exist yet, and fails with ErrConflict otherwise.
+func (gs *gcsStorage) write(bucket, fileName string, data []byte, cacheControl string, generation int64) error {
+	obj := &rawgcs.Object{Name: fileName, ContentType: contentType(fileName), CacheControl: cacheControl}
+	var opts []googleapi.MediaOption
+
+	if obj.ContentType != "" {
+		opts = append(opts, googleapi.ContentType(obj.ContentType))
+	}
+
+	_, err := gs.raw.Objects.Insert(bucket, obj).
+		Media(bytes.NewReader(data), opts...).
+		IfGenerationMatch(generation).
+		Context(gs.c).
+		Do()
+
+	if e, ok := err.(*googleapi.Error); ok && e.Code == http.StatusPreconditionFailed {
+		return ErrConflict
+	}
+
+	return err
+}
+
+// generation returns the current generation of an object, or 0 if it doesn't
+// exist yet.
+func (gs *gcsStorage) generation(bucket, fileName string) (generation int64, err error) {
+	err = retry(gs.c, "stat of "+fileName, func() error {
+		obj, err := gcs.StatObject(gs.ctx, bucket, fileName)
+
+		switch {
+		case err == gcs.ErrObjectNotExist:
+			generation = 0
+			return nil
+		case err != nil:
+			return err
+		}
+
+		generation = obj.Generation
+		return nil
+	})
+
+	return
+}
+
+func (gs *gcsStorage) MakePublic(fileName string) error {
+	bucket, err := gs.Bucket()
+
+	if err != nil {
+		return err
+	}
+
+	log.Debugf(gs.c, "Making file %v in bucket %v public", fileName, bucket)
+
+	return gcs.PutACLRule(gs.ctx, bucket, fileName, gcs.AllUsers, gcs.RoleReader)
+}
+
+// SignedURL signs a V2 URL for reading the file with the app's service
+// account, so that no private key has to be kept.
+//
+// See https://cloud.google.com/storage/docs/access-control/signed-urls-v2
+func (gs *gcsStorage) SignedURL(fileName, downloadName string, expires time.Time) (string, error) {
+	bucket, err := gs.Bucket()
+
+	if err != nil {
+		return "", err
+	}
+
+	account, err := appengine.ServiceAccount(gs.c)
+
+	if err != nil {
+		return "", err
+	}
+
+	path := "/" + bucket + "/" + (&url.URL{Path: fileName}).EscapedPath()
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	_, signature, err := appengine.SignBytes(gs.c, []byte("GET\n\n\n"+expiry+"\n"+path))
+
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{
+		"GoogleAccessId": {account},
+		"Expires":        {expiry},
+		"Signature":      {base64.StdEncoding.EncodeToString(signature)},
+	}
+
+	if downloadName != "" {
+		q.Set("response-content-disposition", fmt.Sprintf(`attachment; filename="%v"`, downloadName))
+	}
+
+	return "https://storage.googleapis.com" + path + "?" + q.Encode(), nil
+}
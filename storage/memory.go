@@ -0,0 +1,75 @@
+package storage
+
+import "sync"
+
+// MemoryStorage is a Storage that keeps files in memory, it is intended for
+// tests.
+type MemoryStorage struct {
+	mu     sync.RWMutex
+	files  map[string][]byte
+	public map[string]bool
+}
+
+// NewMemory returns an empty MemoryStorage.
+func NewMemory() *MemoryStorage {
+	return &MemoryStorage{
+		files:  make(map[string][]byte),
+		public: make(map[string]bool),
+	}
+}
+
+func (ms *MemoryStorage) ReadFile(fileName string) ([]byte, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	data, ok := ms.files[fileName]
+
+	if !ok {
+		return nil, ErrNotExist
+	}
+
+	return append([]byte(nil), data...), nil
+}
+
+func (ms *MemoryStorage) WriteFile(fileName string, data []byte) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.files[fileName] = append([]byte(nil), data...)
+	return nil
+}
+
+// UpdateFile holds the lock across the update.
+func (ms *MemoryStorage) UpdateFile(fileName string, update func(data []byte) ([]byte, error)) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	data, err := update(append([]byte(nil), ms.files[fileName]...))
+
+	if err != nil || data == nil {
+		return err
+	}
+
+	ms.files[fileName] = append([]byte(nil), data...)
+	return nil
+}
+
+func (ms *MemoryStorage) MakePublic(fileName string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if _, ok := ms.files[fileName]; !ok {
+		return ErrNotExist
+	}
+
+	ms.public[fileName] = true
+	return nil
+}
+
+// IsPublic reports whether MakePublic has been called for the file.
+func (ms *MemoryStorage) IsPublic(fileName string) bool {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	return ms.public[fileName]
+}
@@ -0,0 +1,88 @@
+package storage
+
+import "time"
+
+// Mirror returns the Storage s with the files written to it, and made public,
+// also written to and made public in each of mirrors, such as buckets in the
+// regions that serve them closer to clients. Files are read from s alone. A
+// write fails if it fails on any of them, and is retried on all of them by
+// writing the file again. It is a URLSigner if s is.
+func Mirror(s Storage, mirrors ...Storage) Storage {
+	ms := &mirrorStorage{s, mirrors}
+
+	if signer, ok := s.(URLSigner); ok {
+		return &signingMirrorStorage{ms, signer}
+	}
+
+	return ms
+}
+
+type mirrorStorage struct {
+	s       Storage
+	mirrors []Storage
+}
+
+func (ms *mirrorStorage) ReadFile(fileName string) ([]byte, error) {
+	return ms.s.ReadFile(fileName)
+}
+
+func (ms *mirrorStorage) WriteFile(fileName string, data []byte) error {
+	if err := ms.s.WriteFile(fileName, data); err != nil {
+		return err
+	}
+
+	for _, m := range ms.mirrors {
+		if err := m.WriteFile(fileName, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UpdateFile updates the file in s and then writes it to the mirrors, which
+// a concurrent update may leave a version behind until the next one.
+func (ms *mirrorStorage) UpdateFile(fileName string, update func(data []byte) ([]byte, error)) error {
+	var written []byte
+
+	err := UpdateFile(ms.s, fileName, func(data []byte) ([]byte, error) {
+		var err error
+		written, err = update(data)
+		return written, err
+	})
+
+	if err != nil || written == nil {
+		return err
+	}
+
+	for _, m := range ms.mirrors {
+		if err := m.WriteFile(fileName, written); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ms *mirrorStorage) MakePublic(fileName string) error {
+	if err := ms.s.MakePublic(fileName); err != nil {
+		return err
+	}
+
+	for _, m := range ms.mirrors {
+		if err := m.MakePublic(fileName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type signingMirrorStorage struct {
+	*mirrorStorage
+	signer URLSigner
+}
+
+func (ss *signingMirrorStorage) SignedURL(fileName, downloadName string, expires time.Time) (string, error) {
+	return ss.signer.SignedURL(fileName, downloadName, expires)
+}
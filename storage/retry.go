@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"math/rand"
+	"net"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/appengine/log"
+)
+
+const (
+	retryAttempts  = 4
+	retryBaseDelay = 100 * time.Millisecond
+	retryMaxDelay  = 2 * time.Second
+
+	// updateAttempts is how many times UpdateFile starts over when the file
+	// is changed by someone else
+	updateAttempts = 8
+)
+
+// retry calls op until it succeeds, returns a non-transient error or the
+// attempts run out. Attempts are spaced using exponential backoff with full
+// jitter so that instances retrying the same failure don't do so in lockstep.
+func retry(c context.Context, desc string, op func() error) (err error) {
+	for attempt := 0; attempt < retryAttempts; attempt++ {
+		if attempt > 0 {
+			delay := backoff(attempt)
+			log.Warningf(c, "Retrying %v in %v (attempt %v): %v", desc, delay, attempt+1, err)
+			time.Sleep(delay)
+		}
+
+		if err = op(); err == nil || !isTransient(err) {
+			return
+		}
+	}
+
+	return
+}
+
+// backoff returns a random delay in [0, min(max, base*2^attempt)).
+func backoff(attempt int) time.Duration {
+	d := retryBaseDelay << uint(attempt)
+
+	if d > retryMaxDelay || d <= 0 {
+		d = retryMaxDelay
+	}
+
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// isTransient reports whether err is worth retrying, that is a server side
+// error, rate limiting or a network timeout.
+func isTransient(err error) bool {
+	switch e := err.(type) {
+	case *googleapi.Error:
+		return e.Code >= 500 || e.Code == 429
+	case net.Error:
+		return e.Timeout()
+	}
+
+	return false
+}
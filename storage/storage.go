@@ -0,0 +1,130 @@
+// Package storage provides the file storage used for keys and revocation
+// lists, with implementations backed by Google Cloud Storage, the local
+// filesystem and memory.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+var (
+	// ErrNotExist is returned when reading a file that does not exist.
+	ErrNotExist = errors.New("storage: file does not exist")
+
+	// ErrConflict is returned by writes conditional on a file being as it
+	// was read when it has been changed since.
+	ErrConflict = errors.New("storage: file was changed by another write")
+)
+
+// Storage is a flat namespace of files.
+type Storage interface {
+	ReadFile(fileName string) ([]byte, error)
+	WriteFile(fileName string, data []byte) error
+	MakePublic(fileName string) error
+}
+
+// URLSigner is implemented by the storages that files can be downloaded from
+// directly, without going through the app.
+type URLSigner interface {
+	// SignedURL returns a URL the file can be read from until expires. A
+	// downloadName makes it download as an attachment with that name.
+	SignedURL(fileName, downloadName string, expires time.Time) (string, error)
+}
+
+// CacheWriter is implemented by the storages whose files can be given the
+// Cache-Control they are served with, such as a bucket behind a CDN.
+type CacheWriter interface {
+	// WriteFileCached writes a file like WriteFile, to be served with the
+	// cacheControl header.
+	WriteFileCached(fileName string, data []byte, cacheControl string) error
+}
+
+// Updater is implemented by the storages that can change a file without
+// losing a concurrent change to it, such as GCS with writes conditional on
+// the object's generation.
+type Updater interface {
+	// UpdateFile calls update with the contents of a file, nil if it doesn't
+	// exist, and writes what it returns in its place, nothing if it returns
+	// nil. If the file was changed in the meantime update is called again
+	// with the new contents.
+	UpdateFile(fileName string, update func(data []byte) ([]byte, error)) error
+}
+
+// updateMu serializes the updates of storages that aren't Updaters.
+var updateMu sync.Mutex
+
+// UpdateFile changes a file like Updater. Storages that aren't Updaters, like
+// the filesystem, are read and written holding a lock for the process, which
+// is enough for the one instance they are used with.
+func UpdateFile(s Storage, fileName string, update func(data []byte) ([]byte, error)) error {
+	if u, ok := s.(Updater); ok {
+		return u.UpdateFile(fileName, update)
+	}
+
+	updateMu.Lock()
+	defer updateMu.Unlock()
+
+	data, err := s.ReadFile(fileName)
+
+	if err != nil && err != ErrNotExist {
+		return err
+	}
+
+	if data, err = update(data); err != nil || data == nil {
+		return err
+	}
+
+	return s.WriteFile(fileName, data)
+}
+
+// Backend names accepted by Open.
+const (
+	GCS        = "gcs"
+	FileSystem = "file"
+	Memory     = "memory"
+)
+
+var memory = NewMemory()
+
+// Open returns the Storage for the named backend. The location is the bucket
+// name for GCS (empty for the app's default bucket) and the root directory
+// for the filesystem backend. The memory backend is shared by all callers in
+// the process so that it behaves like the other backends across requests.
+func Open(c context.Context, backend, location string) (Storage, error) {
+	switch backend {
+	case GCS, "":
+		return NewGCS(c, location), nil
+	case FileSystem:
+		return NewFileSystem(location), nil
+	case Memory:
+		return memory, nil
+	}
+
+	return nil, fmt.Errorf("storage: unknown backend %q", backend)
+}
+
+// contentType returns the content type for a file based on its extension.
+func contentType(fileName string) string {
+	ext := filepath.Ext(fileName)
+
+	// unfortunately app engine doesn't support mimetypes so we have to do this manually
+	switch ext {
+	case ".json":
+		return "application/json"
+	case ".txt":
+		return "text/plain"
+	case ".gz":
+		return "application/gzip"
+	case ".lic":
+		return "text/plain"
+	}
+
+	return mime.TypeByExtension(ext)
+}
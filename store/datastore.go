@@ -0,0 +1,1913 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+
+	"github.com/volcanicpixels/licensing/license"
+	"github.com/volcanicpixels/licensing/pii"
+)
+
+const licenseKind = "License"
+
+// personalAttrs are the license attributes that are encrypted when a keyring
+// is configured.
+var personalAttrs = []string{"email", "name"}
+
+// licenseEntity is the datastore representation of a license, attributes
+// are free-form so they are stored as JSON. See index.yaml for the indexes
+// List needs.
+type licenseEntity struct {
+	Product  string
+	IssuedAt time.Time
+	Attrs    []byte `datastore:",noindex"`
+
+	// PersonalAttrs are the encrypted JSON of the personal attributes, which
+	// are then left out of Attrs.
+	PersonalAttrs []byte `datastore:",noindex"`
+
+	// Email is the lower cased email attribute so it can be queried, or its
+	// blind index if the personal attributes are encrypted.
+	Email string
+
+	// ChargeID is the chargeId attribute so that licenses can be found by
+	// payment.
+	ChargeID string
+
+	// ExpiresAt is the zero time for licenses that never expire.
+	ExpiresAt time.Time
+
+	// NotBefore is the zero time for licenses valid once issued.
+	NotBefore time.Time `datastore:",noindex"`
+
+	Test           bool
+	Entitlements   []byte `datastore:",noindex"`
+	Extensions     []byte `datastore:",noindex"`
+	MaxActivations int    `datastore:",noindex"`
+	Seats          int    `datastore:",noindex"`
+	Plan           string
+	Regions        []string `datastore:",noindex"`
+
+	// Products are a bundle license's products and ProductEntitlements the
+	// JSON of the entitlements of those with their own.
+	Products            []string `datastore:",noindex"`
+	ProductEntitlements []byte   `datastore:",noindex"`
+
+	MinVersion string `datastore:",noindex"`
+	MaxVersion string `datastore:",noindex"`
+	Reseller   string
+	Campaign   string
+	Invoice    string
+	ReceiptURL string `datastore:",noindex"`
+	CustomerID string
+	Tags       []string
+	KeyID      string
+
+	// Metadata is the JSON of the license's metadata.
+	Metadata []byte `datastore:",noindex"`
+	Notes    string `datastore:",noindex"`
+
+	MembersTokenHash string `datastore:",noindex"`
+
+	Revoked   bool
+	RevokedAt time.Time `datastore:",noindex"`
+
+	// RevokeAt is the zero time unless a revocation is scheduled.
+	RevokeAt time.Time
+
+	// SharingDetectedAt and SuspendedAt are the zero time unless sharing
+	// was detected and the license suspended for it.
+	SharingDetectedAt time.Time `datastore:",noindex"`
+	SuspendedAt       time.Time `datastore:",noindex"`
+}
+
+func toEntity(l *license.License, pc *pii.Cipher) (*licenseEntity, error) {
+	attrs := l.Attrs
+	email := strings.ToLower(l.Email())
+	var personal []byte
+
+	if pc != nil {
+		attrs = make(map[string]interface{}, len(l.Attrs))
+		private := make(map[string]interface{})
+
+		for k, v := range l.Attrs {
+			attrs[k] = v
+		}
+
+		for _, k := range personalAttrs {
+			if v, ok := attrs[k]; ok {
+				private[k] = v
+				delete(attrs, k)
+			}
+		}
+
+		if len(private) > 0 {
+			b, err := json.Marshal(private)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if personal, err = pc.Encrypt(string(b)); err != nil {
+				return nil, err
+			}
+		}
+
+		email = pc.BlindIndex(l.Email())
+	}
+
+	attrsJSON, err := json.Marshal(attrs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	entitlements, err := json.Marshal(l.Entitlements)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var productEntitlements []byte
+
+	if len(l.ProductEntitlements) > 0 {
+		if productEntitlements, err = json.Marshal(l.ProductEntitlements); err != nil {
+			return nil, err
+		}
+	}
+
+	var metadata []byte
+
+	if len(l.Metadata) > 0 {
+		if metadata, err = json.Marshal(l.Metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	var extensions []byte
+
+	if len(l.Extensions) > 0 {
+		if extensions, err = json.Marshal(l.Extensions); err != nil {
+			return nil, err
+		}
+	}
+
+	e := &licenseEntity{
+		Product:             l.Product,
+		IssuedAt:            l.IssuedAt,
+		Attrs:               attrsJSON,
+		PersonalAttrs:       personal,
+		Email:               email,
+		Test:                l.Test,
+		Entitlements:        entitlements,
+		Extensions:          extensions,
+		MaxActivations:      l.MaxActivations,
+		Seats:               l.Seats,
+		Plan:                l.Plan,
+		Regions:             l.Regions,
+		Products:            l.Products,
+		ProductEntitlements: productEntitlements,
+		MinVersion:          l.MinVersion,
+		MaxVersion:          l.MaxVersion,
+		Reseller:            l.Reseller,
+		Campaign:            l.Campaign,
+		Invoice:             l.Invoice,
+		ReceiptURL:          l.ReceiptURL,
+		CustomerID:          l.CustomerID,
+		Tags:                l.Tags,
+		Metadata:            metadata,
+		Notes:               l.Notes,
+		MembersTokenHash:    l.MembersTokenHash,
+		KeyID:               l.KeyID,
+		ChargeID:            l.ChargeID(),
+	}
+
+	if l.ExpiresAt != nil {
+		e.ExpiresAt = *l.ExpiresAt
+	}
+
+	if l.NotBefore != nil {
+		e.NotBefore = *l.NotBefore
+	}
+
+	if l.RevokedAt != nil {
+		e.Revoked = true
+		e.RevokedAt = *l.RevokedAt
+	}
+
+	if l.RevokeAt != nil {
+		e.RevokeAt = *l.RevokeAt
+	}
+
+	if l.SharingDetectedAt != nil {
+		e.SharingDetectedAt = *l.SharingDetectedAt
+	}
+
+	if l.SuspendedAt != nil {
+		e.SuspendedAt = *l.SuspendedAt
+	}
+
+	return e, nil
+}
+
+func fromEntity(id string, e *licenseEntity, pc *pii.Cipher) (*license.License, error) {
+	l := &license.License{
+		ID:             id,
+		Product:        e.Product,
+		IssuedAt:       e.IssuedAt,
+		Test:           e.Test,
+		MaxActivations: e.MaxActivations,
+		Seats:          e.Seats,
+		Plan:           e.Plan,
+		Regions:        e.Regions,
+		Products:       e.Products,
+		MinVersion:     e.MinVersion,
+		MaxVersion:     e.MaxVersion,
+		Reseller:       e.Reseller,
+		Campaign:       e.Campaign,
+		Invoice:        e.Invoice,
+		ReceiptURL:     e.ReceiptURL,
+		CustomerID:     e.CustomerID,
+		Tags:           e.Tags,
+		Notes:          e.Notes,
+		KeyID:          e.KeyID,
+	}
+
+	l.MembersTokenHash = e.MembersTokenHash
+
+	if err := json.Unmarshal(e.Attrs, &l.Attrs); err != nil {
+		return nil, err
+	}
+
+	if len(e.PersonalAttrs) > 0 {
+		if pc == nil {
+			return nil, errors.New("store: license " + id + " has encrypted attributes but there are no keys")
+		}
+
+		b, err := pc.Decrypt(e.PersonalAttrs)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if l.Attrs == nil {
+			l.Attrs = make(map[string]interface{})
+		}
+
+		if err := json.Unmarshal([]byte(b), &l.Attrs); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(e.Entitlements) > 0 {
+		if err := json.Unmarshal(e.Entitlements, &l.Entitlements); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(e.ProductEntitlements) > 0 {
+		if err := json.Unmarshal(e.ProductEntitlements, &l.ProductEntitlements); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(e.Metadata) > 0 {
+		if err := json.Unmarshal(e.Metadata, &l.Metadata); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(e.Extensions) > 0 {
+		if err := json.Unmarshal(e.Extensions, &l.Extensions); err != nil {
+			return nil, err
+		}
+	}
+
+	if !e.ExpiresAt.IsZero() {
+		l.ExpiresAt = &e.ExpiresAt
+	}
+
+	if !e.NotBefore.IsZero() {
+		l.NotBefore = &e.NotBefore
+	}
+
+	if e.Revoked {
+		l.RevokedAt = &e.RevokedAt
+	}
+
+	if !e.RevokeAt.IsZero() {
+		l.RevokeAt = &e.RevokeAt
+	}
+
+	if !e.SharingDetectedAt.IsZero() {
+		l.SharingDetectedAt = &e.SharingDetectedAt
+	}
+
+	if !e.SuspendedAt.IsZero() {
+		l.SuspendedAt = &e.SuspendedAt
+	}
+
+	return l, nil
+}
+
+// cipher returns the keyring's cipher, or nil if there is no keyring.
+func cipher(c context.Context, k *pii.Keyring) (*pii.Cipher, error) {
+	if k == nil {
+		return nil, nil
+	}
+
+	return k.Cipher(c)
+}
+
+type datastoreLicenses struct {
+	namespace string
+	keyring   *pii.Keyring
+}
+
+// NewDatastoreLicenses returns a Licenses store backed by the App Engine
+// datastore, kept in the given datastore namespace. If keyring isn't nil the
+// customer's email and name are encrypted with it. Licenses stored before
+// then are still read but can't be found by email until they are stored
+// again.
+func NewDatastoreLicenses(namespace string, keyring *pii.Keyring) Licenses {
+	return datastoreLicenses{namespace, keyring}
+}
+
+// key returns the datastore key for a license along with the context to use
+// it in.
+func (dl datastoreLicenses) key(c context.Context, id string) (context.Context, *datastore.Key, error) {
+	c, err := appengine.Namespace(c, dl.namespace)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c, datastore.NewKey(c, licenseKind, id, 0, nil), nil
+}
+
+func (dl datastoreLicenses) Put(c context.Context, l *license.License) error {
+	pc, err := cipher(c, dl.keyring)
+
+	if err != nil {
+		return err
+	}
+
+	e, err := toEntity(l, pc)
+
+	if err != nil {
+		return err
+	}
+
+	c, key, err := dl.key(c, l.ID)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = datastore.Put(c, key, e)
+	return err
+}
+
+func (dl datastoreLicenses) Get(c context.Context, id string) (*license.License, error) {
+	pc, err := cipher(c, dl.keyring)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, key, err := dl.key(c, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var e licenseEntity
+
+	err = datastore.Get(c, key, &e)
+
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return fromEntity(id, &e, pc)
+}
+
+func (dl datastoreLicenses) Update(c context.Context, id string, f func(l *license.License) error) (*license.License, error) {
+	pc, err := cipher(c, dl.keyring)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, key, err := dl.key(c, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var l *license.License
+
+	err = datastore.RunInTransaction(c, func(tc context.Context) error {
+		var e licenseEntity
+		err := datastore.Get(tc, key, &e)
+
+		if err == datastore.ErrNoSuchEntity {
+			return ErrNotFound
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if l, err = fromEntity(id, &e, pc); err != nil {
+			return err
+		}
+
+		if err := f(l); err != nil {
+			return err
+		}
+
+		updated, err := toEntity(l, pc)
+
+		if err != nil {
+			return err
+		}
+
+		_, err = datastore.Put(tc, key, updated)
+		return err
+	}, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// List runs as much of the query as it can in the datastore, which is the
+// equality filters and one range on the property being ordered by, and
+// applies the rest of the filters to the results.
+func (dl datastoreLicenses) List(c context.Context, q Query) ([]*license.License, string, error) {
+	pc, err := cipher(c, dl.keyring)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	c, err = appengine.Namespace(c, dl.namespace)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	dq := datastore.NewQuery(licenseKind)
+
+	if q.Product != "" {
+		dq = dq.Filter("Product =", q.Product)
+	}
+
+	if q.Reseller != "" {
+		dq = dq.Filter("Reseller =", q.Reseller)
+	}
+
+	if q.Campaign != "" {
+		dq = dq.Filter("Campaign =", q.Campaign)
+	}
+
+	if q.Invoice != "" {
+		dq = dq.Filter("Invoice =", q.Invoice)
+	}
+
+	if q.CustomerID != "" {
+		dq = dq.Filter("CustomerID =", q.CustomerID)
+	}
+
+	if q.Tag != "" {
+		dq = dq.Filter("Tags =", q.Tag)
+	}
+
+	if q.ChargeID != "" {
+		dq = dq.Filter("ChargeID =", q.ChargeID)
+	}
+
+	if q.KeyID != "" {
+		dq = dq.Filter("KeyID =", q.KeyID)
+	}
+
+	if q.Email != "" && pc != nil {
+		dq = dq.Filter("Email =", pc.BlindIndex(q.Email))
+	} else if q.Email != "" {
+		dq = dq.Filter("Email =", strings.ToLower(q.Email))
+	}
+
+	switch q.Status {
+	case license.StatusRevoked:
+		dq = dq.Filter("Revoked =", true)
+	case license.StatusActive, license.StatusExpired:
+		dq = dq.Filter("Revoked =", false)
+	}
+
+	switch {
+	case !q.RevokeDueBy.IsZero():
+		// licenses without a scheduled revocation have a zero RevokeAt
+		dq = dq.Filter("RevokeAt >", time.Time{}).Filter("RevokeAt <=", q.RevokeDueBy).Order("RevokeAt")
+	case q.Order == OrderExpiry:
+		// perpetual licenses have a zero ExpiresAt, which would sort first,
+		// ExpiringAfter is at least the zero time so they are left out
+		dq = dq.Filter("ExpiresAt >", q.ExpiringAfter)
+
+		if !q.ExpiringBefore.IsZero() {
+			dq = dq.Filter("ExpiresAt <", q.ExpiringBefore)
+		} else if q.Status == license.StatusExpired {
+			dq = dq.Filter("ExpiresAt <", now)
+		}
+
+		dq = dq.Order("ExpiresAt")
+	default:
+		if !q.CreatedAfter.IsZero() {
+			dq = dq.Filter("IssuedAt >", q.CreatedAfter)
+		}
+
+		if !q.CreatedBefore.IsZero() {
+			dq = dq.Filter("IssuedAt <", q.CreatedBefore)
+		}
+
+		dq = dq.Order("-IssuedAt")
+	}
+
+	if q.Cursor != "" {
+		cursor, err := datastore.DecodeCursor(q.Cursor)
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		dq = dq.Start(cursor)
+	}
+
+	limit := q.Limit
+
+	if limit <= 0 {
+		limit = 50
+	}
+
+	var licenses []*license.License
+	it := dq.Run(c)
+
+	for len(licenses) < limit {
+		var e licenseEntity
+		key, err := it.Next(&e)
+
+		if err == datastore.Done {
+			return licenses, "", nil
+		}
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		l, err := fromEntity(key.StringID(), &e, pc)
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		if q.Matches(l, now) {
+			licenses = append(licenses, l)
+		}
+	}
+
+	cursor, err := it.Cursor()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return licenses, cursor.String(), nil
+}
+
+const activationKind = "Activation"
+
+// activationEntity is keyed by the fingerprint and is a child of the license
+// so that a license's activations can be counted in a transaction.
+type activationEntity struct {
+	Site        string `datastore:",noindex"`
+	Version     string `datastore:",noindex"`
+	ActivatedAt time.Time
+	LastSeenAt  time.Time
+}
+
+type datastoreActivations struct {
+	namespace string
+}
+
+// NewDatastoreActivations returns an Activations store backed by the App
+// Engine datastore, kept in the given datastore namespace.
+func NewDatastoreActivations(namespace string) Activations {
+	return datastoreActivations{namespace}
+}
+
+// parent returns the key of the license that activations belong to and the
+// context to use it in.
+func (da datastoreActivations) parent(c context.Context, licenseID string) (context.Context, *datastore.Key, error) {
+	return datastoreLicenses{namespace: da.namespace}.key(c, licenseID)
+}
+
+func (da datastoreActivations) Activate(c context.Context, a Activation, max int) error {
+	c, parent, err := da.parent(c, a.LicenseID)
+
+	if err != nil {
+		return err
+	}
+
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		key := datastore.NewKey(tc, activationKind, a.Fingerprint, 0, parent)
+
+		var e activationEntity
+		err := datastore.Get(tc, key, &e)
+
+		switch {
+		case err == nil:
+			e.Site = a.Site
+			e.Version = a.Version
+			e.LastSeenAt = a.LastSeenAt
+		case err == datastore.ErrNoSuchEntity:
+			if max > 0 {
+				n, err := datastore.NewQuery(activationKind).Ancestor(parent).KeysOnly().Count(tc)
+
+				if err != nil {
+					return err
+				}
+
+				if n >= max {
+					return ErrActivationLimit
+				}
+			}
+
+			e = activationEntity{a.Site, a.Version, a.ActivatedAt, a.LastSeenAt}
+		default:
+			return err
+		}
+
+		_, err = datastore.Put(tc, key, &e)
+		return err
+	}, nil)
+}
+
+func (da datastoreActivations) CheckIn(c context.Context, a Activation) error {
+	c, parent, err := da.parent(c, a.LicenseID)
+
+	if err != nil {
+		return err
+	}
+
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		key := datastore.NewKey(tc, activationKind, a.Fingerprint, 0, parent)
+
+		var e activationEntity
+
+		switch err := datastore.Get(tc, key, &e); err {
+		case nil:
+		case datastore.ErrNoSuchEntity:
+			return nil
+		default:
+			return err
+		}
+
+		e.LastSeenAt = a.LastSeenAt
+
+		if a.Version != "" {
+			e.Version = a.Version
+		}
+
+		_, err := datastore.Put(tc, key, &e)
+		return err
+	}, nil)
+}
+
+func (da datastoreActivations) Deactivate(c context.Context, licenseID, fingerprint string) error {
+	c, parent, err := da.parent(c, licenseID)
+
+	if err != nil {
+		return err
+	}
+
+	err = datastore.Delete(c, datastore.NewKey(c, activationKind, fingerprint, 0, parent))
+
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+
+	return err
+}
+
+func (da datastoreActivations) List(c context.Context, licenseID string) ([]Activation, error) {
+	c, parent, err := da.parent(c, licenseID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var entities []activationEntity
+	keys, err := datastore.NewQuery(activationKind).Ancestor(parent).GetAll(c, &entities)
+
+	if err != nil {
+		return nil, err
+	}
+
+	activations := make([]Activation, len(keys))
+
+	for i, e := range entities {
+		activations[i] = Activation{
+			LicenseID:   licenseID,
+			Fingerprint: keys[i].StringID(),
+			Site:        e.Site,
+			Version:     e.Version,
+			ActivatedAt: e.ActivatedAt,
+			LastSeenAt:  e.LastSeenAt,
+		}
+	}
+
+	// sorted here rather than in the query, which would need an index
+	sort.Slice(activations, func(i, j int) bool {
+		return activations[i].ActivatedAt.Before(activations[j].ActivatedAt)
+	})
+
+	return activations, nil
+}
+
+func (da datastoreActivations) Stale(c context.Context, before time.Time, cursor string, limit int) ([]Activation, string, error) {
+	c, err := appengine.Namespace(c, da.namespace)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	dq := datastore.NewQuery(activationKind).Filter("LastSeenAt <", before).Order("LastSeenAt")
+
+	if cursor != "" {
+		start, err := datastore.DecodeCursor(cursor)
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		dq = dq.Start(start)
+	}
+
+	if limit <= 0 {
+		limit = 50
+	}
+
+	var activations []Activation
+	it := dq.Run(c)
+
+	for len(activations) < limit {
+		var e activationEntity
+		key, err := it.Next(&e)
+
+		if err == datastore.Done {
+			return activations, "", nil
+		}
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		activations = append(activations, Activation{
+			LicenseID:   key.Parent().StringID(),
+			Fingerprint: key.StringID(),
+			Site:        e.Site,
+			Version:     e.Version,
+			ActivatedAt: e.ActivatedAt,
+			LastSeenAt:  e.LastSeenAt,
+		})
+	}
+
+	next, err := it.Cursor()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return activations, next.String(), nil
+}
+
+const userKind = "User"
+
+// userEntity is a child of the license like activationEntity. It is keyed by
+// the email, or by its blind index with the email encrypted if personal data
+// is.
+type userEntity struct {
+	Email   []byte `datastore:",noindex"`
+	AddedAt time.Time
+}
+
+type datastoreUsers struct {
+	namespace string
+	keyring   *pii.Keyring
+}
+
+// NewDatastoreUsers returns a Users store backed by the App Engine
+// datastore, kept in the given datastore namespace. Emails are encrypted if
+// there is a keyring.
+func NewDatastoreUsers(namespace string, k *pii.Keyring) Users {
+	return datastoreUsers{namespace, k}
+}
+
+// key returns the key of a user and the context to use it in.
+func (du datastoreUsers) key(c context.Context, pc *pii.Cipher, licenseID, email string) (context.Context, *datastore.Key, error) {
+	c, parent, err := datastoreLicenses{namespace: du.namespace}.key(c, licenseID)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	id := email
+
+	if pc != nil {
+		id = pc.BlindIndex(email)
+	}
+
+	return c, datastore.NewKey(c, userKind, id, 0, parent), nil
+}
+
+func (du datastoreUsers) Add(c context.Context, u User, max int) error {
+	pc, err := cipher(c, du.keyring)
+
+	if err != nil {
+		return err
+	}
+
+	c, key, err := du.key(c, pc, u.LicenseID, u.Email)
+
+	if err != nil {
+		return err
+	}
+
+	e := userEntity{Email: []byte(u.Email), AddedAt: u.AddedAt}
+
+	if pc != nil {
+		if e.Email, err = pc.Encrypt(u.Email); err != nil {
+			return err
+		}
+	}
+
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		err := datastore.Get(tc, key, &userEntity{})
+
+		if err == nil {
+			return nil
+		}
+
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		if max > 0 {
+			n, err := datastore.NewQuery(userKind).Ancestor(key.Parent()).KeysOnly().Count(tc)
+
+			if err != nil {
+				return err
+			}
+
+			if n >= max {
+				return ErrSeatLimit
+			}
+		}
+
+		_, err = datastore.Put(tc, key, &e)
+		return err
+	}, nil)
+}
+
+func (du datastoreUsers) Remove(c context.Context, licenseID, email string) error {
+	pc, err := cipher(c, du.keyring)
+
+	if err != nil {
+		return err
+	}
+
+	c, key, err := du.key(c, pc, licenseID, email)
+
+	if err != nil {
+		return err
+	}
+
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		err := datastore.Get(tc, key, &userEntity{})
+
+		if err == datastore.ErrNoSuchEntity {
+			return ErrNotFound
+		}
+
+		if err != nil {
+			return err
+		}
+
+		return datastore.Delete(tc, key)
+	}, nil)
+}
+
+func (du datastoreUsers) List(c context.Context, licenseID string) ([]User, error) {
+	pc, err := cipher(c, du.keyring)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, parent, err := datastoreLicenses{namespace: du.namespace}.key(c, licenseID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var entities []userEntity
+
+	if _, err := datastore.NewQuery(userKind).Ancestor(parent).GetAll(c, &entities); err != nil {
+		return nil, err
+	}
+
+	users := make([]User, len(entities))
+
+	for i, e := range entities {
+		email := string(e.Email)
+
+		if pc != nil {
+			if email, err = pc.Decrypt(e.Email); err != nil {
+				return nil, err
+			}
+		}
+
+		users[i] = User{LicenseID: licenseID, Email: email, AddedAt: e.AddedAt}
+	}
+
+	// sorted here rather than in the query, which would need an index
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].AddedAt.Before(users[j].AddedAt)
+	})
+
+	return users, nil
+}
+
+func (du datastoreUsers) Seats(c context.Context, email string) ([]User, error) {
+	pc, err := cipher(c, du.keyring)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, err = appengine.Namespace(c, du.namespace)
+
+	if err != nil {
+		return nil, err
+	}
+
+	id := email
+
+	if pc != nil {
+		id = pc.BlindIndex(email)
+	}
+
+	// users are keyed by their email under their license, finding them
+	// without the license scans the keys, which is fine for the rare
+	// customer exports and erasures that need it
+	keys, err := datastore.NewQuery(userKind).KeysOnly().GetAll(c, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var held []*datastore.Key
+
+	for _, key := range keys {
+		if key.StringID() == id {
+			held = append(held, key)
+		}
+	}
+
+	if len(held) == 0 {
+		return nil, nil
+	}
+
+	entities := make([]userEntity, len(held))
+
+	if err := datastore.GetMulti(c, held, entities); err != nil {
+		return nil, err
+	}
+
+	seats := make([]User, len(held))
+
+	for i, key := range held {
+		seats[i] = User{LicenseID: key.Parent().StringID(), Email: email, AddedAt: entities[i].AddedAt}
+	}
+
+	sort.Slice(seats, func(i, j int) bool {
+		return seats[i].AddedAt.Before(seats[j].AddedAt)
+	})
+
+	return seats, nil
+}
+
+const orderKind = "Order"
+
+// orderEntity is keyed by the product and order ID, so that claiming an
+// order in a transaction is strongly consistent where a query on the
+// licenses wouldn't be.
+type orderEntity struct {
+	LicenseID string    `datastore:",noindex"`
+	ClaimedAt time.Time `datastore:",noindex"`
+}
+
+type datastoreOrders struct {
+	namespace string
+}
+
+// NewDatastoreOrders returns an Orders store backed by the App Engine
+// datastore, kept in the given datastore namespace.
+func NewDatastoreOrders(namespace string) Orders {
+	return datastoreOrders{namespace}
+}
+
+// key returns the key of an order and the context to use it in.
+func (do datastoreOrders) key(c context.Context, product, orderID string) (context.Context, *datastore.Key, error) {
+	c, err := appengine.Namespace(c, do.namespace)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c, datastore.NewKey(c, orderKind, product+"/"+orderID, 0, nil), nil
+}
+
+func (do datastoreOrders) Claim(c context.Context, product, orderID, licenseID string) (string, error) {
+	c, key, err := do.key(c, product, orderID)
+
+	if err != nil {
+		return "", err
+	}
+
+	claimed := licenseID
+
+	err = datastore.RunInTransaction(c, func(tc context.Context) error {
+		var e orderEntity
+		err := datastore.Get(tc, key, &e)
+
+		if err == nil {
+			claimed = e.LicenseID
+			return nil
+		}
+
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		claimed = licenseID
+		_, err = datastore.Put(tc, key, &orderEntity{licenseID, time.Now()})
+		return err
+	}, nil)
+
+	return claimed, err
+}
+
+func (do datastoreOrders) Get(c context.Context, product, orderID string) (string, error) {
+	c, key, err := do.key(c, product, orderID)
+
+	if err != nil {
+		return "", err
+	}
+
+	var e orderEntity
+	err = datastore.Get(c, key, &e)
+
+	if err == datastore.ErrNoSuchEntity {
+		return "", ErrNotFound
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return e.LicenseID, nil
+}
+
+func (do datastoreOrders) Release(c context.Context, product, orderID string) error {
+	c, key, err := do.key(c, product, orderID)
+
+	if err != nil {
+		return err
+	}
+
+	return datastore.Delete(c, key)
+}
+
+const emailTemplateKind = "EmailTemplate"
+
+type emailTemplateEntity struct {
+	Kind      string
+	Locale    string
+	Subject   string `datastore:",noindex"`
+	Body      string `datastore:",noindex"`
+	UpdatedAt time.Time
+}
+
+type datastoreEmailTemplates struct{}
+
+// NewDatastoreEmailTemplates returns an EmailTemplates store backed by the
+// App Engine datastore.
+func NewDatastoreEmailTemplates() EmailTemplates {
+	return datastoreEmailTemplates{}
+}
+
+func (datastoreEmailTemplates) key(c context.Context, kind, locale string) *datastore.Key {
+	return datastore.NewKey(c, emailTemplateKind, emailTemplateID(kind, locale), 0, nil)
+}
+
+func (dt datastoreEmailTemplates) Get(c context.Context, kind, locale string) (*EmailTemplate, error) {
+	var e emailTemplateEntity
+	err := datastore.Get(c, dt.key(c, kind, locale), &e)
+
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	t := EmailTemplate(e)
+	return &t, nil
+}
+
+func (dt datastoreEmailTemplates) Put(c context.Context, t *EmailTemplate) error {
+	e := emailTemplateEntity(*t)
+	_, err := datastore.Put(c, dt.key(c, t.Kind, t.Locale), &e)
+	return err
+}
+
+func (dt datastoreEmailTemplates) Delete(c context.Context, kind, locale string) error {
+	err := datastore.Delete(c, dt.key(c, kind, locale))
+
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+
+	return err
+}
+
+func (dt datastoreEmailTemplates) List(c context.Context) ([]EmailTemplate, error) {
+	var entities []emailTemplateEntity
+
+	// without an order the results are in key order
+	if _, err := datastore.NewQuery(emailTemplateKind).GetAll(c, &entities); err != nil {
+		return nil, err
+	}
+
+	templates := make([]EmailTemplate, len(entities))
+
+	for i, e := range entities {
+		templates[i] = EmailTemplate(e)
+	}
+
+	return templates, nil
+}
+
+const flagKind = "Flag"
+
+type flagEntity struct {
+	Name      string
+	Products  []string `datastore:",noindex"`
+	Percent   int      `datastore:",noindex"`
+	UpdatedAt time.Time
+}
+
+type datastoreFlags struct{}
+
+// NewDatastoreFlags returns a Flags store backed by the App Engine
+// datastore.
+func NewDatastoreFlags() Flags {
+	return datastoreFlags{}
+}
+
+func (datastoreFlags) key(c context.Context, name string) *datastore.Key {
+	return datastore.NewKey(c, flagKind, name, 0, nil)
+}
+
+func (df datastoreFlags) Put(c context.Context, f *Flag) error {
+	e := flagEntity(*f)
+	_, err := datastore.Put(c, df.key(c, f.Name), &e)
+	return err
+}
+
+func (df datastoreFlags) Delete(c context.Context, name string) error {
+	err := datastore.Delete(c, df.key(c, name))
+
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+
+	return err
+}
+
+func (df datastoreFlags) List(c context.Context) ([]Flag, error) {
+	var entities []flagEntity
+
+	// without an order the results are in key order
+	if _, err := datastore.NewQuery(flagKind).GetAll(c, &entities); err != nil {
+		return nil, err
+	}
+
+	flags := make([]Flag, len(entities))
+
+	for i, e := range entities {
+		flags[i] = Flag(e)
+	}
+
+	return flags, nil
+}
+
+const jobKind = "Job"
+
+// jobEntity holds the job's parameters as JSON.
+type jobEntity struct {
+	Kind      string
+	Status    string
+	Params    []byte `datastore:",noindex"`
+	Cursor    string `datastore:",noindex"`
+	Processed int    `datastore:",noindex"`
+	Failed    int    `datastore:",noindex"`
+	Error     string `datastore:",noindex"`
+	Result    string `datastore:",noindex"`
+	CreatedBy string `datastore:",noindex"`
+	CreatedAt time.Time
+	UpdatedAt time.Time `datastore:",noindex"`
+}
+
+type datastoreJobs struct{}
+
+// NewDatastoreJobs returns a Jobs store backed by the App Engine datastore.
+func NewDatastoreJobs() Jobs {
+	return datastoreJobs{}
+}
+
+func (dj datastoreJobs) Put(c context.Context, j *Job) error {
+	params, err := json.Marshal(j.Params)
+
+	if err != nil {
+		return err
+	}
+
+	e := &jobEntity{
+		Kind:      j.Kind,
+		Status:    j.Status,
+		Params:    params,
+		Cursor:    j.Cursor,
+		Processed: j.Processed,
+		Failed:    j.Failed,
+		Error:     j.Error,
+		Result:    j.Result,
+		CreatedBy: j.CreatedBy,
+		CreatedAt: j.CreatedAt,
+		UpdatedAt: j.UpdatedAt,
+	}
+
+	_, err = datastore.Put(c, datastore.NewKey(c, jobKind, j.ID, 0, nil), e)
+	return err
+}
+
+func (dj datastoreJobs) Get(c context.Context, id string) (*Job, error) {
+	var e jobEntity
+
+	err := datastore.Get(c, datastore.NewKey(c, jobKind, id, 0, nil), &e)
+
+	if err == datastore.ErrNoSuchEntity {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return fromJobEntity(id, &e)
+}
+
+func (dj datastoreJobs) List(c context.Context, cursor string, limit int) ([]Job, string, error) {
+	dq := datastore.NewQuery(jobKind).Order("-CreatedAt")
+
+	if cursor != "" {
+		start, err := datastore.DecodeCursor(cursor)
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		dq = dq.Start(start)
+	}
+
+	if limit <= 0 {
+		limit = 50
+	}
+
+	var jobs []Job
+	it := dq.Run(c)
+
+	for len(jobs) < limit {
+		var e jobEntity
+		key, err := it.Next(&e)
+
+		if err == datastore.Done {
+			return jobs, "", nil
+		}
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		j, err := fromJobEntity(key.StringID(), &e)
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		jobs = append(jobs, *j)
+	}
+
+	next, err := it.Cursor()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return jobs, next.String(), nil
+}
+
+func fromJobEntity(id string, e *jobEntity) (*Job, error) {
+	j := &Job{
+		ID:        id,
+		Kind:      e.Kind,
+		Status:    e.Status,
+		Cursor:    e.Cursor,
+		Processed: e.Processed,
+		Failed:    e.Failed,
+		Error:     e.Error,
+		Result:    e.Result,
+		CreatedBy: e.CreatedBy,
+		CreatedAt: e.CreatedAt,
+		UpdatedAt: e.UpdatedAt,
+	}
+
+	if err := json.Unmarshal(e.Params, &j.Params); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+const revocationKind = "Revocation"
+
+// revocationEntity is keyed by the license ID, so a license can only be
+// revoked once.
+type revocationEntity struct {
+	Comment string `datastore:",noindex"`
+}
+
+type datastoreRevocations struct{}
+
+// NewDatastoreRevocations returns a Revocations store backed by the App
+// Engine datastore.
+func NewDatastoreRevocations() Revocations {
+	return datastoreRevocations{}
+}
+
+func (datastoreRevocations) Revoke(c context.Context, r Revocation) error {
+	_, err := datastore.Put(c, datastore.NewKey(c, revocationKind, r.ID, 0, nil), &revocationEntity{r.Comment})
+	return err
+}
+
+func (datastoreRevocations) List(c context.Context) ([]Revocation, error) {
+	var entities []revocationEntity
+	keys, err := datastore.NewQuery(revocationKind).GetAll(c, &entities)
+
+	if err != nil {
+		return nil, err
+	}
+
+	revocations := make([]Revocation, len(entities))
+
+	for i, e := range entities {
+		revocations[i] = Revocation{ID: keys[i].StringID(), Comment: e.Comment}
+	}
+
+	return revocations, nil
+}
+
+func (datastoreRevocations) Unrevoke(c context.Context, id string) error {
+	err := datastore.Delete(c, datastore.NewKey(c, revocationKind, id, 0, nil))
+
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+
+	return err
+}
+
+const counterShardKind = "CounterShard"
+
+// counterShards is how many entities a counter is spread over, an entity
+// group can only be written about once a second.
+const counterShards = 20
+
+type counterShardEntity struct {
+	Count int `datastore:",noindex"`
+}
+
+type datastoreCounters struct{}
+
+// NewDatastoreCounters returns a Counters store backed by the App Engine
+// datastore, each counter is sharded so that it can be incremented by
+// concurrent requests.
+func NewDatastoreCounters() Counters {
+	return datastoreCounters{}
+}
+
+func (datastoreCounters) key(c context.Context, name string, shard int) *datastore.Key {
+	return datastore.NewKey(c, counterShardKind, fmt.Sprintf("%v#%v", name, shard), 0, nil)
+}
+
+func (dc datastoreCounters) Count(c context.Context, name string) (int, error) {
+	keys := make([]*datastore.Key, counterShards)
+
+	for i := range keys {
+		keys[i] = dc.key(c, name, i)
+	}
+
+	shards := make([]counterShardEntity, counterShards)
+	err := datastore.GetMulti(c, keys, shards)
+
+	if me, ok := err.(appengine.MultiError); ok {
+		// shards that have never been incremented don't exist
+		for _, err := range me {
+			if err != nil && err != datastore.ErrNoSuchEntity {
+				return 0, err
+			}
+		}
+	} else if err != nil {
+		return 0, err
+	}
+
+	n := 0
+
+	for _, s := range shards {
+		n += s.Count
+	}
+
+	return n, nil
+}
+
+func (dc datastoreCounters) Increment(c context.Context, name string) error {
+	key := dc.key(c, name, rand.Intn(counterShards))
+
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		var s counterShardEntity
+
+		if err := datastore.Get(tc, key, &s); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		s.Count++
+		_, err := datastore.Put(tc, key, &s)
+		return err
+	}, nil)
+}
+
+// IncrementCapped keeps a capped counter in its first shard, so that the
+// transaction sees every increment. Capped counters are for things that are
+// counted rarely enough for one entity, such as a product's launch licenses.
+func (dc datastoreCounters) IncrementCapped(c context.Context, name string, limit int) error {
+	return dc.add(c, name, func(n int) (int, error) {
+		if n >= limit {
+			return n, ErrCapReached
+		}
+
+		return n + 1, nil
+	})
+}
+
+func (dc datastoreCounters) Decrement(c context.Context, name string) error {
+	return dc.add(c, name, func(n int) (int, error) {
+		if n > 0 {
+			n--
+		}
+
+		return n, nil
+	})
+}
+
+// add changes the first shard of a capped counter in a transaction.
+func (dc datastoreCounters) add(c context.Context, name string, change func(n int) (int, error)) error {
+	key := dc.key(c, name, 0)
+
+	return datastore.RunInTransaction(c, func(tc context.Context) error {
+		var s counterShardEntity
+
+		if err := datastore.Get(tc, key, &s); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+
+		n, err := change(s.Count)
+
+		if err != nil {
+			return err
+		}
+
+		s.Count = n
+		_, err = datastore.Put(tc, key, &s)
+		return err
+	}, nil)
+}
+
+const auditKind = "AuditEntry"
+
+type auditEntity struct {
+	Time   time.Time
+	Action string
+	Actor  string
+	Target string
+
+	// Customer is lower cased so it can be queried, or its blind index if
+	// it is encrypted in CustomerEnc.
+	Customer    string
+	CustomerEnc []byte `datastore:",noindex"`
+	Details     []byte `datastore:",noindex"`
+}
+
+type datastoreAudit struct {
+	keyring *pii.Keyring
+}
+
+// NewDatastoreAudit returns an Audit log backed by the App Engine datastore,
+// it is kept in the default namespace whichever namespace the entries are
+// about. If keyring isn't nil customers' email addresses are encrypted
+// with it.
+func NewDatastoreAudit(keyring *pii.Keyring) Audit {
+	return datastoreAudit{keyring}
+}
+
+// customerQuery returns the queries for the entries about a customer, there
+// are two with encryption to find the entries recorded before it was enabled.
+func (da datastoreAudit) customerQuery(pc *pii.Cipher, customer string) []*datastore.Query {
+	plain := datastore.NewQuery(auditKind).Filter("Customer =", strings.ToLower(customer))
+
+	if pc == nil {
+		return []*datastore.Query{plain}
+	}
+
+	return []*datastore.Query{
+		datastore.NewQuery(auditKind).Filter("Customer =", pc.BlindIndex(customer)),
+		plain,
+	}
+}
+
+// find returns the entries about a customer.
+func (da datastoreAudit) find(c context.Context, pc *pii.Cipher, customer string) ([]*datastore.Key, []auditEntity, error) {
+	var keys []*datastore.Key
+	var entities []auditEntity
+
+	for _, q := range da.customerQuery(pc, customer) {
+		var found []auditEntity
+		k, err := q.GetAll(c, &found)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		keys = append(keys, k...)
+		entities = append(entities, found...)
+	}
+
+	return keys, entities, nil
+}
+
+func (da datastoreAudit) Record(c context.Context, e AuditEntry) error {
+	pc, err := cipher(c, da.keyring)
+
+	if err != nil {
+		return err
+	}
+
+	details, err := json.Marshal(e.Details)
+
+	if err != nil {
+		return err
+	}
+
+	entity := &auditEntity{
+		Time:     e.Time,
+		Action:   e.Action,
+		Actor:    e.Actor,
+		Target:   e.Target,
+		Customer: strings.ToLower(e.Customer),
+		Details:  details,
+	}
+
+	if pc != nil && e.Customer != "" {
+		entity.Customer = pc.BlindIndex(e.Customer)
+
+		if entity.CustomerEnc, err = pc.Encrypt(e.Customer); err != nil {
+			return err
+		}
+	}
+
+	key := datastore.NewIncompleteKey(c, auditKind, nil)
+
+	_, err = datastore.Put(c, key, entity)
+	return err
+}
+
+func (da datastoreAudit) List(c context.Context, customer string) ([]AuditEntry, error) {
+	pc, err := cipher(c, da.keyring)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, entities, err := da.find(c, pc, customer)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entities, func(i, j int) bool {
+		return entities[i].Time.Before(entities[j].Time)
+	})
+
+	entries := make([]AuditEntry, len(entities))
+
+	for i, e := range entities {
+		entries[i] = AuditEntry{
+			Time:     e.Time,
+			Action:   e.Action,
+			Actor:    e.Actor,
+			Target:   e.Target,
+			Customer: e.Customer,
+		}
+
+		if len(e.CustomerEnc) > 0 {
+			if pc == nil {
+				return nil, errors.New("store: audit entry has an encrypted customer but there are no keys")
+			}
+
+			if entries[i].Customer, err = pc.Decrypt(e.CustomerEnc); err != nil {
+				return nil, err
+			}
+		}
+
+		if len(e.Details) > 0 {
+			if err := json.Unmarshal(e.Details, &entries[i].Details); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return entries, nil
+}
+
+func (da datastoreAudit) Anonymize(c context.Context, customer, pseudonym string) (int, error) {
+	pc, err := cipher(c, da.keyring)
+
+	if err != nil {
+		return 0, err
+	}
+
+	keys, entities, err := da.find(c, pc, customer)
+
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range entities {
+		entities[i].Customer = pseudonym
+		entities[i].CustomerEnc = nil
+		entities[i].Details = nil
+	}
+
+	// PutMulti is limited to 500 entities per call
+	for i := 0; i < len(keys); i += 500 {
+		end := i + 500
+
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		if _, err := datastore.PutMulti(c, keys[i:end], entities[i:end]); err != nil {
+			return i, err
+		}
+	}
+
+	return len(keys), nil
+}
+
+const eventKind = "Event"
+
+// eventEntity holds the license of an event as the JSON of its entity, so
+// that its personal attributes are encrypted like those of stored licenses.
+type eventEntity struct {
+	Kind      string `datastore:",noindex"`
+	At        time.Time
+	Namespace string `datastore:",noindex"`
+	LicenseID string
+	License   []byte `datastore:",noindex"`
+	Comment   string `datastore:",noindex"`
+}
+
+type datastoreEvents struct {
+	keyring *pii.Keyring
+}
+
+// NewDatastoreEvents returns an Events log backed by the App Engine
+// datastore, it is kept in the default namespace whichever namespace the
+// licenses are in. If keyring isn't nil the licenses' personal attributes
+// are encrypted with it.
+func NewDatastoreEvents(keyring *pii.Keyring) Events {
+	return datastoreEvents{keyring}
+}
+
+// encodeLicense returns the JSON of a license's entity.
+func encodeLicense(l *license.License, pc *pii.Cipher) ([]byte, error) {
+	e, err := toEntity(l, pc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(e)
+}
+
+func (de datastoreEvents) Append(c context.Context, e *Event) error {
+	pc, err := cipher(c, de.keyring)
+
+	if err != nil {
+		return err
+	}
+
+	entity := &eventEntity{Kind: e.Kind, At: e.At, Namespace: e.Namespace, LicenseID: e.LicenseID}
+
+	if e.License != nil {
+		if entity.License, err = encodeLicense(e.License, pc); err != nil {
+			return err
+		}
+	}
+
+	if e.Revocation != nil {
+		entity.Comment = e.Revocation.Comment
+	}
+
+	key, err := datastore.Put(c, datastore.NewIncompleteKey(c, eventKind, nil), entity)
+
+	if err != nil {
+		return err
+	}
+
+	e.ID = strconv.FormatInt(key.IntID(), 10)
+	return nil
+}
+
+func (de datastoreEvents) List(c context.Context, until time.Time, cursor string, limit int) ([]Event, string, error) {
+	pc, err := cipher(c, de.keyring)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	dq := datastore.NewQuery(eventKind).Order("At")
+
+	if !until.IsZero() {
+		dq = dq.Filter("At <=", until)
+	}
+
+	if cursor != "" {
+		start, err := datastore.DecodeCursor(cursor)
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		dq = dq.Start(start)
+	}
+
+	if limit <= 0 {
+		limit = 50
+	}
+
+	var events []Event
+	it := dq.Run(c)
+
+	for len(events) < limit {
+		var e eventEntity
+		key, err := it.Next(&e)
+
+		if err == datastore.Done {
+			return events, "", nil
+		}
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		event := Event{
+			ID:        strconv.FormatInt(key.IntID(), 10),
+			Kind:      e.Kind,
+			At:        e.At,
+			Namespace: e.Namespace,
+			LicenseID: e.LicenseID,
+		}
+
+		if e.Kind == EventRevoke {
+			event.Revocation = &Revocation{ID: e.LicenseID, Comment: e.Comment}
+		} else if len(e.License) > 0 {
+			var le licenseEntity
+
+			if err := json.Unmarshal(e.License, &le); err != nil {
+				return nil, "", err
+			}
+
+			if event.License, err = fromEntity(e.LicenseID, &le, pc); err != nil {
+				return nil, "", err
+			}
+		}
+
+		events = append(events, event)
+	}
+
+	next, err := it.Cursor()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return events, next.String(), nil
+}
+
+func (de datastoreEvents) Redact(c context.Context, licenseID string, redact func(l *license.License)) (int, error) {
+	pc, err := cipher(c, de.keyring)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var entities []eventEntity
+	keys, err := datastore.NewQuery(eventKind).Filter("LicenseID =", licenseID).GetAll(c, &entities)
+
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+
+	for i := range entities {
+		if len(entities[i].License) == 0 {
+			continue
+		}
+
+		var le licenseEntity
+
+		if err := json.Unmarshal(entities[i].License, &le); err != nil {
+			return n, err
+		}
+
+		l, err := fromEntity(licenseID, &le, pc)
+
+		if err != nil {
+			return n, err
+		}
+
+		redact(l)
+
+		if entities[i].License, err = encodeLicense(l, pc); err != nil {
+			return n, err
+		}
+
+		if _, err := datastore.Put(c, keys[i], &entities[i]); err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
@@ -0,0 +1,163 @@
+package store
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/license"
+)
+
+type replayingKey struct{}
+
+// Replaying returns a context whose changes to the logged stores aren't
+// logged, for applying events that are already in the log.
+func Replaying(c context.Context) context.Context {
+	return context.WithValue(c, replayingKey{}, true)
+}
+
+func isReplaying(c context.Context) bool {
+	replaying, _ := c.Value(replayingKey{}).(bool)
+	return replaying
+}
+
+type loggedLicenses struct {
+	licenses  Licenses
+	events    Events
+	namespace string
+}
+
+// NewLoggedLicenses returns a Licenses store that appends an event to the
+// log before every license it stores, a create for a new license, an extend
+// for one with a new extension, a renew for one whose expiry moved later and
+// an update for any other change. A license whose event can't be appended
+// isn't stored. If storing it fails after that, replaying the log stores it.
+func NewLoggedLicenses(licenses Licenses, events Events, namespace string) Licenses {
+	return &loggedLicenses{licenses, events, namespace}
+}
+
+func (ll *loggedLicenses) Put(c context.Context, l *license.License) error {
+	if isReplaying(c) {
+		return ll.licenses.Put(c, l)
+	}
+
+	old, err := ll.licenses.Get(c, l.ID)
+
+	if err != nil && err != ErrNotFound {
+		return err
+	}
+
+	if err := ll.events.Append(c, ll.event(old, l)); err != nil {
+		return err
+	}
+
+	return ll.licenses.Put(c, l)
+}
+
+// Update appends the event of each attempt at the update before it is
+// stored, an attempt that is retried leaves an event that stores the same
+// license again when replayed.
+func (ll *loggedLicenses) Update(c context.Context, id string, f func(l *license.License) error) (*license.License, error) {
+	if isReplaying(c) {
+		return ll.licenses.Update(c, id, f)
+	}
+
+	return ll.licenses.Update(c, id, func(l *license.License) error {
+		old := *l
+
+		if err := f(l); err != nil {
+			return err
+		}
+
+		return ll.events.Append(c, ll.event(&old, l))
+	})
+}
+
+// event returns the event for storing l over old, which is nil for a new
+// license.
+func (ll *loggedLicenses) event(old, l *license.License) *Event {
+	e := &Event{Kind: EventUpdate, At: time.Now(), Namespace: ll.namespace, LicenseID: l.ID, License: l}
+
+	switch {
+	case old == nil:
+		e.Kind = EventCreate
+	case len(l.Extensions) > len(old.Extensions):
+		e.Kind = EventExtend
+	case renewed(old, l):
+		e.Kind = EventRenew
+	}
+
+	return e
+}
+
+// renewed reports whether a license's expiry moved later, or went away.
+func renewed(old, l *license.License) bool {
+	return old.ExpiresAt != nil && (l.ExpiresAt == nil || l.ExpiresAt.After(*old.ExpiresAt))
+}
+
+func (ll *loggedLicenses) Get(c context.Context, id string) (*license.License, error) {
+	return ll.licenses.Get(c, id)
+}
+
+func (ll *loggedLicenses) List(c context.Context, q Query) ([]*license.License, string, error) {
+	return ll.licenses.List(c, q)
+}
+
+type loggedRevocations struct {
+	revocations Revocations
+	events      Events
+}
+
+// NewLoggedRevocations returns a Revocations store that appends a revoke
+// event to the log before every revocation, and an unrevoke event before
+// every revocation it removes.
+func NewLoggedRevocations(revocations Revocations, events Events) Revocations {
+	return &loggedRevocations{revocations, events}
+}
+
+func (lr *loggedRevocations) Revoke(c context.Context, r Revocation) error {
+	if !isReplaying(c) {
+		e := &Event{Kind: EventRevoke, At: time.Now(), LicenseID: r.ID, Revocation: &r}
+
+		if err := lr.events.Append(c, e); err != nil {
+			return err
+		}
+	}
+
+	return lr.revocations.Revoke(c, r)
+}
+
+func (lr *loggedRevocations) List(c context.Context) ([]Revocation, error) {
+	return lr.revocations.List(c)
+}
+
+func (lr *loggedRevocations) Unrevoke(c context.Context, id string) error {
+	if !isReplaying(c) {
+		e := &Event{Kind: EventUnrevoke, At: time.Now(), LicenseID: id}
+
+		if err := lr.events.Append(c, e); err != nil {
+			return err
+		}
+	}
+
+	return lr.revocations.Unrevoke(c, id)
+}
+
+// Apply makes the change an event records, with a context that doesn't log
+// it again. licenses returns the store of a namespace.
+func Apply(c context.Context, e Event, licenses func(namespace string) Licenses, revocations Revocations) error {
+	c = Replaying(c)
+
+	switch e.Kind {
+	case EventRevoke:
+		return revocations.Revoke(c, *e.Revocation)
+	case EventUnrevoke:
+		return revocations.Unrevoke(c, e.LicenseID)
+	}
+
+	if e.License == nil {
+		return nil
+	}
+
+	return licenses(e.Namespace).Put(c, e.License)
+}
@@ -0,0 +1,787 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/license"
+)
+
+// MemoryLicenses is an in-memory Licenses store.
+type MemoryLicenses struct {
+	mu       sync.RWMutex
+	licenses map[string]license.License
+}
+
+// NewMemoryLicenses returns an empty MemoryLicenses.
+func NewMemoryLicenses() *MemoryLicenses {
+	return &MemoryLicenses{licenses: make(map[string]license.License)}
+}
+
+func (ml *MemoryLicenses) Put(c context.Context, l *license.License) error {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	ml.licenses[l.ID] = *l
+	return nil
+}
+
+func (ml *MemoryLicenses) Get(c context.Context, id string) (*license.License, error) {
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+
+	l, ok := ml.licenses[id]
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &l, nil
+}
+
+func (ml *MemoryLicenses) Update(c context.Context, id string, f func(l *license.License) error) (*license.License, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	l, ok := ml.licenses[id]
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	if err := f(&l); err != nil {
+		return nil, err
+	}
+
+	ml.licenses[id] = l
+	return &l, nil
+}
+
+// List applies the query to every license, the cursor is an offset into the
+// results.
+func (ml *MemoryLicenses) List(c context.Context, q Query) ([]*license.License, string, error) {
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+
+	now := time.Now()
+	var matched []*license.License
+
+	for id := range ml.licenses {
+		l := ml.licenses[id]
+
+		if q.Order == OrderExpiry && l.ExpiresAt == nil {
+			continue
+		}
+
+		if q.Matches(&l, now) {
+			matched = append(matched, &l)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+
+		if q.Order == OrderExpiry && !a.ExpiresAt.Equal(*b.ExpiresAt) {
+			return a.ExpiresAt.Before(*b.ExpiresAt)
+		}
+
+		if !a.IssuedAt.Equal(b.IssuedAt) {
+			return a.IssuedAt.After(b.IssuedAt)
+		}
+
+		return a.ID < b.ID
+	})
+
+	offset := 0
+
+	if q.Cursor != "" {
+		var err error
+
+		if offset, err = strconv.Atoi(q.Cursor); err != nil || offset < 0 {
+			return nil, "", errors.New("store: invalid cursor")
+		}
+	}
+
+	if offset > len(matched) {
+		offset = len(matched)
+	}
+
+	limit := q.Limit
+
+	if limit <= 0 {
+		limit = 50
+	}
+
+	end := offset + limit
+
+	if end >= len(matched) {
+		return matched[offset:], "", nil
+	}
+
+	return matched[offset:end], strconv.Itoa(end), nil
+}
+
+// MemoryActivations is an in-memory Activations store.
+type MemoryActivations struct {
+	mu          sync.Mutex
+	activations map[string][]Activation
+}
+
+// NewMemoryActivations returns an empty MemoryActivations.
+func NewMemoryActivations() *MemoryActivations {
+	return &MemoryActivations{activations: make(map[string][]Activation)}
+}
+
+func (ma *MemoryActivations) Activate(c context.Context, a Activation, max int) error {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	list := ma.activations[a.LicenseID]
+
+	for i := range list {
+		if list[i].Fingerprint == a.Fingerprint {
+			list[i].Site = a.Site
+			list[i].Version = a.Version
+			list[i].LastSeenAt = a.LastSeenAt
+			return nil
+		}
+	}
+
+	if max > 0 && len(list) >= max {
+		return ErrActivationLimit
+	}
+
+	ma.activations[a.LicenseID] = append(list, a)
+	return nil
+}
+
+func (ma *MemoryActivations) CheckIn(c context.Context, a Activation) error {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	list := ma.activations[a.LicenseID]
+
+	for i := range list {
+		if list[i].Fingerprint == a.Fingerprint {
+			list[i].LastSeenAt = a.LastSeenAt
+
+			if a.Version != "" {
+				list[i].Version = a.Version
+			}
+		}
+	}
+
+	return nil
+}
+
+func (ma *MemoryActivations) Deactivate(c context.Context, licenseID, fingerprint string) error {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	list := ma.activations[licenseID]
+
+	for i := range list {
+		if list[i].Fingerprint == fingerprint {
+			ma.activations[licenseID] = append(list[:i:i], list[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+func (ma *MemoryActivations) List(c context.Context, licenseID string) ([]Activation, error) {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	return append([]Activation(nil), ma.activations[licenseID]...), nil
+}
+
+func (ma *MemoryActivations) Stale(c context.Context, before time.Time, cursor string, limit int) ([]Activation, string, error) {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	var stale []Activation
+
+	for _, list := range ma.activations {
+		for _, a := range list {
+			if a.LastSeenAt.Before(before) {
+				stale = append(stale, a)
+			}
+		}
+	}
+
+	sort.Slice(stale, func(i, j int) bool {
+		if !stale[i].LastSeenAt.Equal(stale[j].LastSeenAt) {
+			return stale[i].LastSeenAt.Before(stale[j].LastSeenAt)
+		}
+
+		return stale[i].LicenseID+"/"+stale[i].Fingerprint < stale[j].LicenseID+"/"+stale[j].Fingerprint
+	})
+
+	offset := 0
+
+	if cursor != "" {
+		var err error
+
+		if offset, err = strconv.Atoi(cursor); err != nil || offset < 0 {
+			return nil, "", errors.New("store: invalid cursor")
+		}
+	}
+
+	if offset > len(stale) {
+		offset = len(stale)
+	}
+
+	if limit <= 0 {
+		limit = 50
+	}
+
+	if end := offset + limit; end < len(stale) {
+		return stale[offset:end], strconv.Itoa(end), nil
+	}
+
+	return stale[offset:], "", nil
+}
+
+// MemoryUsers is an in-memory Users store.
+type MemoryUsers struct {
+	mu    sync.Mutex
+	users map[string][]User
+}
+
+// NewMemoryUsers returns an empty MemoryUsers.
+func NewMemoryUsers() *MemoryUsers {
+	return &MemoryUsers{users: make(map[string][]User)}
+}
+
+func (ms *MemoryUsers) Add(c context.Context, u User, max int) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	list := ms.users[u.LicenseID]
+
+	for _, existing := range list {
+		if existing.Email == u.Email {
+			return nil
+		}
+	}
+
+	if max > 0 && len(list) >= max {
+		return ErrSeatLimit
+	}
+
+	ms.users[u.LicenseID] = append(list, u)
+	return nil
+}
+
+func (ms *MemoryUsers) Remove(c context.Context, licenseID, email string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	list := ms.users[licenseID]
+
+	for i := range list {
+		if list[i].Email == email {
+			ms.users[licenseID] = append(list[:i:i], list[i+1:]...)
+			return nil
+		}
+	}
+
+	return ErrNotFound
+}
+
+func (ms *MemoryUsers) List(c context.Context, licenseID string) ([]User, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return append([]User(nil), ms.users[licenseID]...), nil
+}
+
+func (ms *MemoryUsers) Seats(c context.Context, email string) ([]User, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var seats []User
+
+	for _, list := range ms.users {
+		for _, u := range list {
+			if u.Email == email {
+				seats = append(seats, u)
+			}
+		}
+	}
+
+	sort.Slice(seats, func(i, j int) bool {
+		return seats[i].AddedAt.Before(seats[j].AddedAt)
+	})
+
+	return seats, nil
+}
+
+// MemoryOrders is an in-memory Orders store.
+type MemoryOrders struct {
+	mu     sync.Mutex
+	orders map[string]string
+}
+
+// NewMemoryOrders returns an empty MemoryOrders.
+func NewMemoryOrders() *MemoryOrders {
+	return &MemoryOrders{orders: make(map[string]string)}
+}
+
+func (mo *MemoryOrders) Claim(c context.Context, product, orderID, licenseID string) (string, error) {
+	mo.mu.Lock()
+	defer mo.mu.Unlock()
+
+	key := product + "/" + orderID
+
+	if existing, ok := mo.orders[key]; ok {
+		return existing, nil
+	}
+
+	mo.orders[key] = licenseID
+	return licenseID, nil
+}
+
+func (mo *MemoryOrders) Get(c context.Context, product, orderID string) (string, error) {
+	mo.mu.Lock()
+	defer mo.mu.Unlock()
+
+	if id, ok := mo.orders[product+"/"+orderID]; ok {
+		return id, nil
+	}
+
+	return "", ErrNotFound
+}
+
+func (mo *MemoryOrders) Release(c context.Context, product, orderID string) error {
+	mo.mu.Lock()
+	defer mo.mu.Unlock()
+
+	delete(mo.orders, product+"/"+orderID)
+	return nil
+}
+
+// MemoryRevocations is an in-memory Revocations store.
+type MemoryRevocations struct {
+	mu          sync.RWMutex
+	revocations []Revocation
+}
+
+// NewMemoryRevocations returns an empty MemoryRevocations.
+func NewMemoryRevocations() *MemoryRevocations {
+	return &MemoryRevocations{}
+}
+
+func (mr *MemoryRevocations) Revoke(c context.Context, r Revocation) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	for _, existing := range mr.revocations {
+		if existing.ID == r.ID {
+			return nil
+		}
+	}
+
+	mr.revocations = append(mr.revocations, r)
+	return nil
+}
+
+func (mr *MemoryRevocations) List(c context.Context) ([]Revocation, error) {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+
+	return append([]Revocation(nil), mr.revocations...), nil
+}
+
+func (mr *MemoryRevocations) Unrevoke(c context.Context, id string) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	for i, existing := range mr.revocations {
+		if existing.ID == id {
+			mr.revocations = append(mr.revocations[:i], mr.revocations[i+1:]...)
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// MemoryCounters is an in-memory Counters store.
+type MemoryCounters struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// NewMemoryCounters returns an empty MemoryCounters.
+func NewMemoryCounters() *MemoryCounters {
+	return &MemoryCounters{counts: make(map[string]int)}
+}
+
+func (mc *MemoryCounters) Count(c context.Context, name string) (int, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	return mc.counts[name], nil
+}
+
+func (mc *MemoryCounters) Increment(c context.Context, name string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.counts[name]++
+	return nil
+}
+
+func (mc *MemoryCounters) IncrementCapped(c context.Context, name string, limit int) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.counts[name] >= limit {
+		return ErrCapReached
+	}
+
+	mc.counts[name]++
+	return nil
+}
+
+func (mc *MemoryCounters) Decrement(c context.Context, name string) error {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.counts[name] > 0 {
+		mc.counts[name]--
+	}
+
+	return nil
+}
+
+// MemoryEmailTemplates is an in-memory EmailTemplates store.
+type MemoryEmailTemplates struct {
+	mu        sync.Mutex
+	templates map[string]EmailTemplate
+}
+
+// NewMemoryEmailTemplates returns an empty MemoryEmailTemplates.
+func NewMemoryEmailTemplates() *MemoryEmailTemplates {
+	return &MemoryEmailTemplates{templates: make(map[string]EmailTemplate)}
+}
+
+func (mt *MemoryEmailTemplates) Get(c context.Context, kind, locale string) (*EmailTemplate, error) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	t, ok := mt.templates[emailTemplateID(kind, locale)]
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &t, nil
+}
+
+func (mt *MemoryEmailTemplates) Put(c context.Context, t *EmailTemplate) error {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	mt.templates[emailTemplateID(t.Kind, t.Locale)] = *t
+	return nil
+}
+
+func (mt *MemoryEmailTemplates) Delete(c context.Context, kind, locale string) error {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	delete(mt.templates, emailTemplateID(kind, locale))
+	return nil
+}
+
+func (mt *MemoryEmailTemplates) List(c context.Context) ([]EmailTemplate, error) {
+	mt.mu.Lock()
+	defer mt.mu.Unlock()
+
+	templates := make([]EmailTemplate, 0, len(mt.templates))
+
+	for _, t := range mt.templates {
+		templates = append(templates, t)
+	}
+
+	sort.Slice(templates, func(i, j int) bool {
+		return emailTemplateID(templates[i].Kind, templates[i].Locale) < emailTemplateID(templates[j].Kind, templates[j].Locale)
+	})
+
+	return templates, nil
+}
+
+// MemoryFlags is an in-memory Flags store.
+type MemoryFlags struct {
+	mu    sync.Mutex
+	flags map[string]Flag
+}
+
+// NewMemoryFlags returns an empty MemoryFlags.
+func NewMemoryFlags() *MemoryFlags {
+	return &MemoryFlags{flags: make(map[string]Flag)}
+}
+
+func (mf *MemoryFlags) Put(c context.Context, f *Flag) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	mf.flags[f.Name] = *f
+	return nil
+}
+
+func (mf *MemoryFlags) Delete(c context.Context, name string) error {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	delete(mf.flags, name)
+	return nil
+}
+
+func (mf *MemoryFlags) List(c context.Context) ([]Flag, error) {
+	mf.mu.Lock()
+	defer mf.mu.Unlock()
+
+	flags := make([]Flag, 0, len(mf.flags))
+
+	for _, f := range mf.flags {
+		flags = append(flags, f)
+	}
+
+	sort.Slice(flags, func(i, j int) bool {
+		return flags[i].Name < flags[j].Name
+	})
+
+	return flags, nil
+}
+
+// MemoryJobs is an in-memory Jobs store.
+type MemoryJobs struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+// NewMemoryJobs returns an empty MemoryJobs.
+func NewMemoryJobs() *MemoryJobs {
+	return &MemoryJobs{jobs: make(map[string]Job)}
+}
+
+func (mj *MemoryJobs) Put(c context.Context, j *Job) error {
+	mj.mu.Lock()
+	defer mj.mu.Unlock()
+
+	mj.jobs[j.ID] = *j
+	return nil
+}
+
+func (mj *MemoryJobs) Get(c context.Context, id string) (*Job, error) {
+	mj.mu.Lock()
+	defer mj.mu.Unlock()
+
+	j, ok := mj.jobs[id]
+
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return &j, nil
+}
+
+func (mj *MemoryJobs) List(c context.Context, cursor string, limit int) ([]Job, string, error) {
+	mj.mu.Lock()
+	defer mj.mu.Unlock()
+
+	jobs := make([]Job, 0, len(mj.jobs))
+
+	for _, j := range mj.jobs {
+		jobs = append(jobs, j)
+	}
+
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].CreatedAt.Equal(jobs[j].CreatedAt) {
+			return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+		}
+
+		return jobs[i].ID < jobs[j].ID
+	})
+
+	offset := 0
+
+	if cursor != "" {
+		var err error
+
+		if offset, err = strconv.Atoi(cursor); err != nil || offset < 0 {
+			return nil, "", errors.New("store: invalid cursor")
+		}
+	}
+
+	if offset > len(jobs) {
+		offset = len(jobs)
+	}
+
+	if limit <= 0 {
+		limit = 50
+	}
+
+	if end := offset + limit; end < len(jobs) {
+		return jobs[offset:end], strconv.Itoa(end), nil
+	}
+
+	return jobs[offset:], "", nil
+}
+
+// MemoryAudit is an in-memory Audit log.
+type MemoryAudit struct {
+	mu      sync.RWMutex
+	entries []AuditEntry
+}
+
+// NewMemoryAudit returns an empty MemoryAudit.
+func NewMemoryAudit() *MemoryAudit {
+	return &MemoryAudit{}
+}
+
+func (ma *MemoryAudit) Record(c context.Context, e AuditEntry) error {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	ma.entries = append(ma.entries, e)
+	return nil
+}
+
+func (ma *MemoryAudit) List(c context.Context, customer string) ([]AuditEntry, error) {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+
+	var entries []AuditEntry
+
+	for _, e := range ma.entries {
+		if strings.EqualFold(e.Customer, customer) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+func (ma *MemoryAudit) Anonymize(c context.Context, customer, pseudonym string) (int, error) {
+	ma.mu.Lock()
+	defer ma.mu.Unlock()
+
+	n := 0
+
+	for i := range ma.entries {
+		if strings.EqualFold(ma.entries[i].Customer, customer) {
+			ma.entries[i].Customer = pseudonym
+			ma.entries[i].Details = nil
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+// Entries returns a copy of the log, oldest first.
+func (ma *MemoryAudit) Entries() []AuditEntry {
+	ma.mu.RLock()
+	defer ma.mu.RUnlock()
+
+	return append([]AuditEntry(nil), ma.entries...)
+}
+
+// MemoryEvents is an in-memory Events log.
+type MemoryEvents struct {
+	mu     sync.RWMutex
+	events []Event
+}
+
+// NewMemoryEvents returns an empty MemoryEvents.
+func NewMemoryEvents() *MemoryEvents {
+	return &MemoryEvents{}
+}
+
+func (me *MemoryEvents) Append(c context.Context, e *Event) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	e.ID = strconv.Itoa(len(me.events) + 1)
+	stored := *e
+
+	if e.License != nil {
+		l := *e.License
+		stored.License = &l
+	}
+
+	me.events = append(me.events, stored)
+	return nil
+}
+
+// List returns the events in the order they were appended, the cursor is an
+// offset into them.
+func (me *MemoryEvents) List(c context.Context, until time.Time, cursor string, limit int) ([]Event, string, error) {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+
+	offset := 0
+
+	if cursor != "" {
+		var err error
+
+		if offset, err = strconv.Atoi(cursor); err != nil || offset < 0 {
+			return nil, "", errors.New("store: invalid cursor")
+		}
+	}
+
+	if limit <= 0 {
+		limit = 50
+	}
+
+	var events []Event
+
+	for i := offset; i < len(me.events); i++ {
+		e := me.events[i]
+
+		if !until.IsZero() && e.At.After(until) {
+			break
+		}
+
+		if len(events) == limit {
+			return events, strconv.Itoa(i), nil
+		}
+
+		if e.License != nil {
+			l := *e.License
+			e.License = &l
+		}
+
+		events = append(events, e)
+	}
+
+	return events, "", nil
+}
+
+func (me *MemoryEvents) Redact(c context.Context, licenseID string, redact func(l *license.License)) (int, error) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	n := 0
+
+	for i := range me.events {
+		if me.events[i].LicenseID == licenseID && me.events[i].License != nil {
+			redact(me.events[i].License)
+			n++
+		}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// maxMismatchIDs is how many of the IDs that differ are logged.
+const maxMismatchIDs = 10
+
+// Logf logs a message for a request context, matching platform.Logger.
+type Logf func(c context.Context, format string, args ...interface{})
+
+type shadowRevocations struct {
+	primary Revocations
+	shadow  Revocations
+	logf    Logf
+}
+
+// NewShadowRevocations returns a Revocations store that mirrors revocations
+// into a shadow store, for migrating from one store to another. Revocations
+// are recorded in the primary store and then in the shadow, and the primary
+// store's list is compared with the shadow's every time it is listed. The
+// primary store is the one relied on, failures of the shadow store and
+// differences between them are logged with logf rather than returned.
+func NewShadowRevocations(primary, shadow Revocations, logf Logf) Revocations {
+	return &shadowRevocations{primary, shadow, logf}
+}
+
+func (sr *shadowRevocations) Revoke(c context.Context, r Revocation) error {
+	if err := sr.primary.Revoke(c, r); err != nil {
+		return err
+	}
+
+	if err := sr.shadow.Revoke(c, r); err != nil {
+		sr.logf(c, "revocations shadow mismatch: could not revoke %v in the shadow store: %v", r.ID, err)
+	}
+
+	return nil
+}
+
+func (sr *shadowRevocations) Unrevoke(c context.Context, id string) error {
+	if err := sr.primary.Unrevoke(c, id); err != nil {
+		return err
+	}
+
+	if err := sr.shadow.Unrevoke(c, id); err != nil {
+		sr.logf(c, "revocations shadow mismatch: could not unrevoke %v in the shadow store: %v", id, err)
+	}
+
+	return nil
+}
+
+func (sr *shadowRevocations) List(c context.Context) ([]Revocation, error) {
+	type result struct {
+		list []Revocation
+		err  error
+	}
+
+	done := make(chan result, 1)
+
+	go func() {
+		list, err := sr.shadow.List(c)
+		done <- result{list, err}
+	}()
+
+	list, err := sr.primary.List(c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	shadow := <-done
+
+	if shadow.err != nil {
+		sr.logf(c, "revocations shadow mismatch: could not list the shadow store: %v", shadow.err)
+		return list, nil
+	}
+
+	missing, extra := compareRevocations(list, shadow.list)
+
+	if len(missing) > 0 || len(extra) > 0 {
+		sr.logf(c, "revocations shadow mismatch: %v revoked only in the primary store (%v), %v only in the shadow store (%v)",
+			len(missing), sampleIDs(missing), len(extra), sampleIDs(extra))
+	}
+
+	return list, nil
+}
+
+// compareRevocations returns the IDs in primary that aren't in shadow and
+// those in shadow that aren't in primary, sorted. Comments aren't compared.
+func compareRevocations(primary, shadow []Revocation) (missing, extra []string) {
+	inPrimary := make(map[string]bool, len(primary))
+	inShadow := make(map[string]bool, len(shadow))
+
+	for _, r := range primary {
+		inPrimary[r.ID] = true
+	}
+
+	for _, r := range shadow {
+		inShadow[r.ID] = true
+
+		if !inPrimary[r.ID] {
+			extra = append(extra, r.ID)
+		}
+	}
+
+	for id := range inPrimary {
+		if !inShadow[id] {
+			missing = append(missing, id)
+		}
+	}
+
+	sort.Strings(missing)
+	sort.Strings(extra)
+
+	return missing, extra
+}
+
+// sampleIDs joins the first few IDs for a log message.
+func sampleIDs(ids []string) string {
+	if len(ids) > maxMismatchIDs {
+		return strings.Join(ids[:maxMismatchIDs], ", ") + ", ..."
+	}
+
+	return strings.Join(ids, ", ")
+}
@@ -0,0 +1,409 @@
+// Package store persists issued licenses and revocations.
+package store
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/license"
+)
+
+// ErrNotFound is returned when a record does not exist.
+var ErrNotFound = errors.New("store: not found")
+
+// SandboxNamespace keeps test-mode licenses apart from real ones, the
+// production namespace is the empty string.
+const SandboxNamespace = "sandbox"
+
+// Licenses stores issued licenses by ID.
+type Licenses interface {
+	Put(c context.Context, l *license.License) error
+	Get(c context.Context, id string) (*license.License, error)
+
+	// Update applies f to a stored license in a transaction and stores the
+	// result, returning it, or ErrNotFound if there is no license. Nothing
+	// is stored if f returns an error, which Update returns. f may be called
+	// more than once if the license changes in the meantime.
+	Update(c context.Context, id string, f func(l *license.License) error) (*license.License, error)
+
+	// List returns the licenses matching the query and a cursor for the
+	// next page, which is empty if there are no more.
+	List(c context.Context, q Query) ([]*license.License, string, error)
+}
+
+// Orders for listing licenses.
+const (
+	OrderCreated = "created" // newest first
+	OrderExpiry  = "expiry"  // soonest to expire first, only licenses that expire
+)
+
+// Query filters and orders a list of licenses, zero fields don't filter.
+type Query struct {
+	Product        string
+	Status         string // one of the license.Status constants
+	Email          string
+	Reseller       string
+	Campaign       string
+	Invoice        string
+	CustomerID     string
+	Tag            string
+	ChargeID       string
+	KeyID          string
+	Plan           string
+	ExpiringBefore time.Time
+	ExpiringAfter  time.Time
+	CreatedAfter   time.Time
+	CreatedBefore  time.Time
+
+	// RevokeDueBy only matches licenses with a revocation scheduled for it
+	// or earlier, in the order they are scheduled. Order is ignored.
+	RevokeDueBy time.Time
+
+	Order  string // OrderCreated by default
+	Limit  int
+	Cursor string
+}
+
+// Matches reports whether a license satisfies the query's filters at the
+// given time.
+func (q *Query) Matches(l *license.License, now time.Time) bool {
+	switch {
+	case q.Product != "" && l.Product != q.Product:
+		return false
+	case q.Status != "" && l.Status(now) != q.Status:
+		return false
+	case q.Email != "" && !strings.EqualFold(l.Email(), q.Email):
+		return false
+	case q.Reseller != "" && l.Reseller != q.Reseller:
+		return false
+	case q.Campaign != "" && l.Campaign != q.Campaign:
+		return false
+	case q.Invoice != "" && l.Invoice != q.Invoice:
+		return false
+	case q.CustomerID != "" && l.CustomerID != q.CustomerID:
+		return false
+	case q.Tag != "" && !l.HasTag(q.Tag):
+		return false
+	case q.ChargeID != "" && l.ChargeID() != q.ChargeID:
+		return false
+	case q.KeyID != "" && l.KeyID != q.KeyID:
+		return false
+	case !q.ExpiringBefore.IsZero() && (l.ExpiresAt == nil || !l.ExpiresAt.Before(q.ExpiringBefore)):
+		return false
+	case !q.ExpiringAfter.IsZero() && (l.ExpiresAt == nil || !l.ExpiresAt.After(q.ExpiringAfter)):
+		return false
+	case q.Plan != "" && l.Plan != q.Plan:
+		return false
+	case !q.CreatedAfter.IsZero() && !l.IssuedAt.After(q.CreatedAfter):
+		return false
+	case !q.CreatedBefore.IsZero() && !l.IssuedAt.Before(q.CreatedBefore):
+		return false
+	case !q.RevokeDueBy.IsZero() && (l.RevokeAt == nil || l.RevokeAt.After(q.RevokeDueBy)):
+		return false
+	}
+
+	return true
+}
+
+// ErrActivationLimit is returned when activating a license that has used all
+// of its activations.
+var ErrActivationLimit = errors.New("store: activation limit reached")
+
+// Activation is an install of the software using a license.
+type Activation struct {
+	LicenseID string `json:"licenseId"`
+
+	// Fingerprint identifies the install, it is chosen by the software.
+	Fingerprint string `json:"fingerprint"`
+
+	// Site is the URL of the site the software is installed on, if known.
+	Site string `json:"site,omitempty"`
+
+	// Version is the version of the product the install last reported, if
+	// any.
+	Version string `json:"version,omitempty"`
+
+	ActivatedAt time.Time `json:"activatedAt"`
+	LastSeenAt  time.Time `json:"lastSeenAt"`
+}
+
+// Activations stores the activations of licenses.
+type Activations interface {
+	// Activate records an activation, or updates it if the install is
+	// already activated. A new activation fails with ErrActivationLimit if
+	// the license already has max activations, zero is unlimited.
+	Activate(c context.Context, a Activation, max int) error
+
+	// CheckIn updates the last seen time and, if it is set, the version of
+	// an activation. It is not an error if the install isn't activated.
+	CheckIn(c context.Context, a Activation) error
+
+	// Deactivate removes an activation, it is not an error if there is none.
+	Deactivate(c context.Context, licenseID, fingerprint string) error
+
+	// List returns the activations of a license, oldest first.
+	List(c context.Context, licenseID string) ([]Activation, error)
+
+	// Stale returns a page of up to limit activations, of any license, that
+	// were last seen before a time, least recently seen first, and the
+	// cursor of the next page, empty after the last.
+	Stale(c context.Context, before time.Time, cursor string, limit int) ([]Activation, string, error)
+}
+
+// ErrSeatLimit is returned when adding a user to a license whose seats are
+// all taken.
+var ErrSeatLimit = errors.New("store: seat limit reached")
+
+// User is a named user holding a seat of a license.
+type User struct {
+	LicenseID string    `json:"licenseId"`
+	Email     string    `json:"email"` // lower cased
+	AddedAt   time.Time `json:"addedAt"`
+}
+
+// Users stores the named users of licenses.
+type Users interface {
+	// Add gives a user a seat, it is not an error if they already have one.
+	// A new user fails with ErrSeatLimit if the license already has max
+	// users, zero is unlimited.
+	Add(c context.Context, u User, max int) error
+
+	// Remove takes a user's seat, it returns ErrNotFound if they have none.
+	Remove(c context.Context, licenseID, email string) error
+
+	// List returns the users of a license, oldest first.
+	List(c context.Context, licenseID string) ([]User, error)
+
+	// Seats returns the seats email has on any license, oldest first.
+	Seats(c context.Context, email string) ([]User, error)
+}
+
+// Orders records the license issued for each order of a product, so that an
+// order submitted twice gets the same license.
+type Orders interface {
+	// Claim records licenseID as the license of an order unless it already
+	// has one, it returns the ID of the order's license either way.
+	Claim(c context.Context, product, orderID, licenseID string) (string, error)
+
+	// Get returns the ID of the license of an order, or ErrNotFound if it
+	// hasn't been claimed.
+	Get(c context.Context, product, orderID string) (string, error)
+
+	// Release forgets the license of an order, for when issuing it failed.
+	Release(c context.Context, product, orderID string) error
+}
+
+// Revocation is an entry in the revocation list.
+type Revocation struct {
+	ID      string `json:"id"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// Revocations stores the list of revoked license IDs, revoking a license
+// that is already revoked does nothing.
+type Revocations interface {
+	Revoke(c context.Context, r Revocation) error
+	List(c context.Context) ([]Revocation, error)
+
+	// Unrevoke removes a revocation, it is not an error if there is none.
+	// Revocations are otherwise permanent, it is only for restoring a
+	// snapshot from before a license was revoked by mistake.
+	Unrevoke(c context.Context, id string) error
+}
+
+// EmailTemplate is a customised email in a locale, its subject and body are
+// text/templates.
+type EmailTemplate struct {
+	Kind      string    `json:"kind"` // such as issued
+	Locale    string    `json:"locale"`
+	Subject   string    `json:"subject"`
+	Body      string    `json:"body"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// EmailTemplates stores the email templates that replace the built-in ones.
+type EmailTemplates interface {
+	// Get returns the template of an email in a locale, or ErrNotFound.
+	Get(c context.Context, kind, locale string) (*EmailTemplate, error)
+
+	Put(c context.Context, t *EmailTemplate) error
+
+	// Delete removes a template, it is not an error if there is none.
+	Delete(c context.Context, kind, locale string) error
+
+	// List returns every template ordered by kind and locale.
+	List(c context.Context) ([]EmailTemplate, error)
+}
+
+// emailTemplateID is the key of a template, which sorts by kind and then
+// locale.
+func emailTemplateID(kind, locale string) string {
+	return kind + "/" + locale
+}
+
+// Flag is a feature flag, which turns a new behaviour on for a percentage
+// of the licenses of some or all products.
+type Flag struct {
+	Name string `json:"name"`
+
+	// Products limits the flag to licenses of these products, it covers
+	// every product if empty.
+	Products []string `json:"products,omitempty"`
+
+	// Percent of the licenses the flag covers have it on, zero turns it
+	// off and 100 on for all of them.
+	Percent   int       `json:"percent"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Flags stores the feature flags.
+type Flags interface {
+	Put(c context.Context, f *Flag) error
+
+	// Delete removes a flag, it is not an error if there is none.
+	Delete(c context.Context, name string) error
+
+	// List returns every flag ordered by name.
+	List(c context.Context) ([]Flag, error)
+}
+
+// Job statuses.
+const (
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is work done in the background in batches, such as a bulk action on
+// licenses or an export, which records its progress between them.
+type Job struct {
+	ID     string            `json:"id"`
+	Kind   string            `json:"kind"`
+	Status string            `json:"status"`
+	Params map[string]string `json:"params,omitempty"`
+
+	// Cursor is where the next batch starts, empty before the first.
+	Cursor string `json:"-"`
+
+	// Processed counts what the job has done so far and Failed what it
+	// couldn't, Error is why the job failed.
+	Processed int    `json:"processed"`
+	Failed    int    `json:"failed"`
+	Error     string `json:"error,omitempty"`
+
+	// Result is what the job produced, if anything, such as the file an
+	// export was written to.
+	Result string `json:"result,omitempty"`
+
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Jobs stores background jobs by ID.
+type Jobs interface {
+	Put(c context.Context, j *Job) error
+	Get(c context.Context, id string) (*Job, error)
+
+	// List returns a page of jobs, newest first, and the cursor of the
+	// next page, which is empty if there are no more.
+	List(c context.Context, cursor string, limit int) ([]Job, string, error)
+}
+
+// Event kinds.
+const (
+	EventCreate = "create" // a license was stored for the first time
+	EventRenew  = "renew"  // a license's expiry moved later
+	EventExtend = "extend" // a license was extended by support (see License.Extensions)
+	EventUpdate = "update" // any other change to a license
+	EventRevoke = "revoke" // a license was added to the revocation list
+
+	// EventUnrevoke is a license removed from the revocation list by
+	// restoring a snapshot.
+	EventUnrevoke = "unrevoke"
+)
+
+// Event is an entry of the event log, which records every change to the
+// licenses and the revocation list before it is made, so that they can be
+// rebuilt from it as of any time (see Apply).
+type Event struct {
+	ID   string    `json:"id"`
+	Kind string    `json:"kind"`
+	At   time.Time `json:"at"`
+
+	// Namespace is the licenses' namespace, LicenseID the license changed.
+	Namespace string `json:"namespace,omitempty"`
+	LicenseID string `json:"licenseId"`
+
+	// License is the license as it was stored, for every kind but revoke,
+	// whose Revocation is the entry added to the list.
+	License    *license.License `json:"license,omitempty"`
+	Revocation *Revocation      `json:"revocation,omitempty"`
+}
+
+// Events is the append-only event log.
+type Events interface {
+	Append(c context.Context, e *Event) error
+
+	// List returns a page of the events logged up to and including until,
+	// all of them if it is zero, oldest first, and the cursor of the next
+	// page, which is empty if there are no more.
+	List(c context.Context, until time.Time, cursor string, limit int) ([]Event, string, error)
+
+	// Redact calls redact on the versions of a license in its events, for
+	// when a customer's personal data is removed, returning how many were
+	// changed. It is the only change made to the log.
+	Redact(c context.Context, licenseID string, redact func(l *license.License)) (int, error)
+}
+
+// Counters stores named counts that are incremented often, such as the
+// number of licenses an API key has issued today.
+type Counters interface {
+	// Count returns the value of a counter, zero if it has never been
+	// incremented.
+	Count(c context.Context, name string) (int, error)
+
+	Increment(c context.Context, name string) error
+
+	// IncrementCapped increments a counter unless it has reached limit, in
+	// which case it returns ErrCapReached, so that concurrent requests
+	// never take it past the limit. A capped counter must only be changed
+	// with IncrementCapped and Decrement.
+	IncrementCapped(c context.Context, name string, limit int) error
+
+	// Decrement undoes an IncrementCapped, such as when the license it was
+	// counted for couldn't be issued.
+	Decrement(c context.Context, name string) error
+}
+
+// ErrCapReached is returned when incrementing a capped counter that has
+// reached its limit.
+var ErrCapReached = errors.New("store: cap reached")
+
+// AuditEntry records an action taken through the API.
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"` // such as license.create
+	Actor  string    `json:"actor"`  // admin or key:<id>
+	Target string    `json:"target,omitempty"`
+
+	// Customer is the email address of the customer the action concerns.
+	Customer string            `json:"customer,omitempty"`
+	Details  map[string]string `json:"details,omitempty"`
+}
+
+// Audit is an append-only log of actions.
+type Audit interface {
+	Record(c context.Context, e AuditEntry) error
+
+	// List returns the entries about a customer, oldest first.
+	List(c context.Context, customer string) ([]AuditEntry, error)
+
+	// Anonymize replaces the customer on their entries with a pseudonym and
+	// removes the entries' details, returning how many were changed.
+	Anonymize(c context.Context, customer, pseudonym string) (int, error)
+}
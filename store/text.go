@@ -0,0 +1,110 @@
+package store
+
+import (
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/storage"
+)
+
+type textRevocations struct {
+	storage  storage.Storage
+	fileName string
+}
+
+// NewTextRevocations returns a Revocations store kept in a text file with one
+// revocation per line in the format "<id> # <comment>" (see README).
+func NewTextRevocations(s storage.Storage, fileName string) Revocations {
+	return &textRevocations{s, fileName}
+}
+
+func (tr *textRevocations) Revoke(c context.Context, r Revocation) error {
+	// ideally we would simply add the license ID on to the end of the revocations.txt file
+	// but Google Storage doesn't support appends.
+	// It does support a composition operation, so we could write the new ID to a new file
+	// and then compose the original with the new one to ensure atomicity, except the Google
+	// storage client library does not implement this operation.
+	// Therefore the best we can do is to read in the current file and write a new file with the
+	// addition, as an update so that revocations made at the same time aren't lost
+	return storage.UpdateFile(tr.storage, tr.fileName, func(data []byte) ([]byte, error) {
+		for _, existing := range parseRevocations(data) {
+			if existing.ID == r.ID {
+				return nil, nil
+			}
+		}
+
+		line := r.ID
+
+		if r.Comment != "" {
+			line += " # " + r.Comment
+		}
+
+		if len(data) > 0 && !strings.HasSuffix(string(data), "\n") {
+			line = "\n" + line
+		}
+
+		return append(data, line+"\n"...), nil
+	})
+}
+
+func (tr *textRevocations) List(c context.Context) ([]Revocation, error) {
+	data, err := tr.storage.ReadFile(tr.fileName)
+
+	if err == storage.ErrNotExist {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseRevocations(data), nil
+}
+
+func (tr *textRevocations) Unrevoke(c context.Context, id string) error {
+	return storage.UpdateFile(tr.storage, tr.fileName, func(data []byte) ([]byte, error) {
+		lines := strings.Split(string(data), "\n")
+		kept := lines[:0]
+
+		// the other lines are kept as they are, with their comments
+		for _, line := range lines {
+			if r := parseRevocations([]byte(line)); len(r) == 1 && r[0].ID == id {
+				continue
+			}
+
+			kept = append(kept, line)
+		}
+
+		if len(kept) == len(lines) {
+			return nil, nil
+		}
+
+		return []byte(strings.Join(kept, "\n")), nil
+	})
+}
+
+// parseRevocations parses the lines of a revocations file, skipping blank
+// lines.
+func parseRevocations(data []byte) []Revocation {
+	var revocations []Revocation
+
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.SplitN(line, "#", 2)
+		id := strings.TrimSpace(parts[0])
+
+		if id == "" {
+			continue
+		}
+
+		r := Revocation{ID: id}
+
+		if len(parts) > 1 {
+			r.Comment = strings.TrimSpace(parts[1])
+		}
+
+		revocations = append(revocations, r)
+	}
+
+	return revocations
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/volcanicpixels/licensing/storage"
+)
+
+// slowStorage widens the window between reading and writing a file, like a
+// bucket or a disk would.
+type slowStorage struct {
+	storage.Storage
+}
+
+func (s slowStorage) ReadFile(fileName string) ([]byte, error) {
+	data, err := s.Storage.ReadFile(fileName)
+	time.Sleep(time.Millisecond)
+	return data, err
+}
+
+func TestTextRevocationsConcurrentRevoke(t *testing.T) {
+	c := context.Background()
+	tr := NewTextRevocations(slowStorage{storage.NewMemory()}, "revocations.txt")
+
+	const n = 50
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			if err := tr.Revoke(c, Revocation{ID: fmt.Sprintf("license-%v", i), Comment: "refund"}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	revoked, err := tr.List(c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(revoked) != n {
+		t.Fatalf("%v revocations, want %v", len(revoked), n)
+	}
+
+	// revoking again and unrevoking keep the others
+	if err := tr.Revoke(c, Revocation{ID: "license-0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tr.Unrevoke(c, "license-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if revoked, _ = tr.List(c); len(revoked) != n-1 {
+		t.Errorf("%v revocations after unrevoking one, want %v", len(revoked), n-1)
+	}
+
+	for _, r := range revoked {
+		if r.ID == "license-1" {
+			t.Error("license-1 is still revoked")
+		}
+	}
+}
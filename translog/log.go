@@ -0,0 +1,162 @@
+package translog
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/volcanicpixels/licensing/storage"
+)
+
+// MarshalText encodes the hash as base64.
+func (h Hash) MarshalText() ([]byte, error) {
+	return []byte(base64.StdEncoding.EncodeToString(h[:])), nil
+}
+
+// UnmarshalText decodes a base64 hash.
+func (h *Hash) UnmarshalText(text []byte) error {
+	b, err := base64.StdEncoding.DecodeString(string(text))
+
+	if err != nil {
+		return err
+	}
+
+	if len(b) != len(h) {
+		return errors.New("translog: hash has the wrong length")
+	}
+
+	copy(h[:], b)
+	return nil
+}
+
+// Entry is a revocation in the log.
+type Entry struct {
+	Index     int    `json:"index"`
+	Timestamp int64  `json:"timestamp"` // when it was logged, in Unix seconds
+	ID        string `json:"id"`
+}
+
+// LeafData returns the data the entry's leaf hash is computed from, which is
+// "<timestamp> <id>".
+func (e Entry) LeafData() []byte {
+	return []byte(strconv.FormatInt(e.Timestamp, 10) + " " + e.ID)
+}
+
+// Leaves returns the leaf hashes of entries.
+func Leaves(entries []Entry) []Hash {
+	leaves := make([]Hash, len(entries))
+
+	for i, e := range entries {
+		leaves[i] = LeafHash(e.LeafData())
+	}
+
+	return leaves
+}
+
+// Log is kept in a file with one JSON encoded entry per line, lines are only
+// ever added to the end.
+type Log struct {
+	storage  storage.Storage
+	fileName string
+}
+
+// New returns the log kept in fileName.
+func New(s storage.Storage, fileName string) *Log {
+	return &Log{s, fileName}
+}
+
+// Entries returns every entry in the log in order.
+func (l *Log) Entries() ([]Entry, error) {
+	data, err := l.storage.ReadFile(l.fileName)
+
+	if err == storage.ErrNotExist {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parse(data)
+}
+
+func parse(data []byte) ([]Entry, error) {
+	var entries []Entry
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var e Entry
+
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return nil, err
+		}
+
+		if e.Index != len(entries) {
+			return nil, errors.New("translog: log entries are out of order")
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// Append adds the IDs that aren't already in the log to the end of it and
+// returns the new entries. Concurrent appends don't lose each other's
+// entries or give two of them the same index, see storage.UpdateFile.
+func (l *Log) Append(ids []string, now time.Time) ([]Entry, error) {
+	var added []Entry
+
+	// like the revocations file this is read and written whole as storage
+	// doesn't support appends
+	err := storage.UpdateFile(l.storage, l.fileName, func(data []byte) ([]byte, error) {
+		entries, err := parse(data)
+
+		if err != nil {
+			return nil, err
+		}
+
+		logged := make(map[string]bool, len(entries))
+
+		for _, e := range entries {
+			logged[e.ID] = true
+		}
+
+		added = nil
+
+		for _, id := range ids {
+			if logged[id] {
+				continue
+			}
+
+			logged[id] = true
+			e := Entry{len(entries) + len(added), now.Unix(), id}
+			line, err := json.Marshal(e)
+
+			if err != nil {
+				return nil, err
+			}
+
+			data = append(append(data, line...), '\n')
+			added = append(added, e)
+		}
+
+		if len(added) == 0 {
+			return nil, nil
+		}
+
+		return data, nil
+	})
+
+	if err != nil || len(added) == 0 {
+		return nil, err
+	}
+
+	return added, nil
+}
@@ -0,0 +1,177 @@
+// Package translog keeps revocations in an append-only Merkle tree log, in
+// the style of Certificate Transparency (RFC 6962), so that clients can prove
+// a revocation was logged and detect the log being rewritten.
+package translog
+
+import "crypto/sha256"
+
+// Hash is a node of the tree.
+type Hash [sha256.Size]byte
+
+// LeafHash returns the hash of a leaf's data.
+func LeafHash(data []byte) Hash {
+	return sha256.Sum256(append([]byte{0}, data...))
+}
+
+func nodeHash(left, right Hash) Hash {
+	b := make([]byte, 0, 1+2*sha256.Size)
+	b = append(b, 1)
+	b = append(b, left[:]...)
+	b = append(b, right[:]...)
+
+	return sha256.Sum256(b)
+}
+
+// split returns the largest power of two less than n, n must be at least 2.
+func split(n int) int {
+	k := 1
+
+	for k<<1 < n {
+		k <<= 1
+	}
+
+	return k
+}
+
+// RootHash returns the root of the tree with the given leaves, the hash of
+// an empty tree is the hash of nothing.
+func RootHash(leaves []Hash) Hash {
+	switch len(leaves) {
+	case 0:
+		return sha256.Sum256(nil)
+	case 1:
+		return leaves[0]
+	}
+
+	k := split(len(leaves))
+	return nodeHash(RootHash(leaves[:k]), RootHash(leaves[k:]))
+}
+
+// InclusionProof returns the audit path proving leaves[index] is in the tree.
+func InclusionProof(leaves []Hash, index int) []Hash {
+	if len(leaves) <= 1 {
+		return nil
+	}
+
+	k := split(len(leaves))
+
+	if index < k {
+		return append(InclusionProof(leaves[:k], index), RootHash(leaves[k:]))
+	}
+
+	return append(InclusionProof(leaves[k:], index-k), RootHash(leaves[:k]))
+}
+
+// ConsistencyProof returns the proof that the tree of the first size leaves
+// is a prefix of the tree of all the leaves.
+func ConsistencyProof(leaves []Hash, size int) []Hash {
+	if size <= 0 || size >= len(leaves) {
+		return nil
+	}
+
+	return subproof(leaves, size, true)
+}
+
+func subproof(leaves []Hash, m int, complete bool) []Hash {
+	n := len(leaves)
+
+	if m == n {
+		if complete {
+			return nil
+		}
+
+		return []Hash{RootHash(leaves)}
+	}
+
+	k := split(n)
+
+	if m <= k {
+		return append(subproof(leaves[:k], m, complete), RootHash(leaves[k:]))
+	}
+
+	return append(subproof(leaves[k:], m-k, false), RootHash(leaves[:k]))
+}
+
+// VerifyInclusion reports whether proof shows that the leaf is at index in
+// the tree of size leaves with the given root.
+func VerifyInclusion(leaf Hash, index, size int, proof []Hash, root Hash) bool {
+	if index < 0 || index >= size {
+		return false
+	}
+
+	fn, sn, r := index, size-1, leaf
+
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	return sn == 0 && r == root
+}
+
+// VerifyConsistency reports whether proof shows that the tree of size1 with
+// root1 is a prefix of the tree of size2 with root2.
+func VerifyConsistency(size1, size2 int, proof []Hash, root1, root2 Hash) bool {
+	switch {
+	case size1 < 0 || size2 < size1:
+		return false
+	case size1 == size2:
+		return len(proof) == 0 && root1 == root2
+	case size1 == 0:
+		return len(proof) == 0
+	case len(proof) == 0:
+		return false
+	}
+
+	// the proof leaves out the old root when it is a complete subtree
+	if size1&(size1-1) == 0 {
+		proof = append([]Hash{root1}, proof...)
+	}
+
+	fn, sn := size1-1, size2-1
+
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+
+	fr, sr := proof[0], proof[0]
+
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+
+		fn >>= 1
+		sn >>= 1
+	}
+
+	return sn == 0 && fr == root1 && sr == root2
+}